@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AggregateFunc is the signature of functions used to aggregate the values of
+// a metric over a flush interval, see EngineConfig.Aggregations.
+//
+// The function receives the current aggregate, the number of values aggregated
+// including the new one, and the new value. It returns the new aggregate. When
+// count is 1 the aggregate is zero and should be ignored. Values of sampled
+// metrics are passed once for each of the occurrences they stand for, see
+// Metric.SampleCount.
+type AggregateFunc func(agg float64, count int, value float64) float64
+
+var (
+	// AggregateSum reports the sum of the values.
+	AggregateSum AggregateFunc = func(agg float64, count int, value float64) float64 {
+		return agg + value
+	}
+
+	// AggregateLast reports the last value.
+	AggregateLast AggregateFunc = func(agg float64, count int, value float64) float64 {
+		return value
+	}
+
+	// AggregateMin reports the smallest value.
+	AggregateMin AggregateFunc = func(agg float64, count int, value float64) float64 {
+		if count == 1 || value < agg {
+			return value
+		}
+		return agg
+	}
+
+	// AggregateMax reports the largest value.
+	AggregateMax AggregateFunc = func(agg float64, count int, value float64) float64 {
+		if count == 1 || value > agg {
+			return value
+		}
+		return agg
+	}
+
+	// AggregateMean reports the average of the values.
+	AggregateMean AggregateFunc = func(agg float64, count int, value float64) float64 {
+		return agg + (value-agg)/float64(count)
+	}
+)
+
+// aggregator holds the state of the metrics aggregated by an engine until it
+// is flushed.
+type aggregator struct {
+	funcs  map[string]AggregateFunc
+	mutex  sync.Mutex
+	series map[string]*aggregateSeries
+}
+
+type aggregateSeries struct {
+	metric Metric
+	count  int
+}
+
+func newAggregator(funcs map[string]AggregateFunc) *aggregator {
+	a := &aggregator{
+		funcs:  make(map[string]AggregateFunc, len(funcs)),
+		series: make(map[string]*aggregateSeries),
+	}
+
+	for name, f := range funcs {
+		a.funcs[name] = f
+	}
+
+	return a
+}
+
+// add aggregates m, the method returns false if no aggregation function was
+// configured for the metric, in which case it must be reported as-is.
+func (a *aggregator) add(m *Metric) bool {
+	f := a.funcs[m.Name]
+	if f == nil {
+		return false
+	}
+
+	key := diffKey(m.Type, m.Namespace, m.Name, m.Tags)
+
+	a.mutex.Lock()
+
+	s := a.series[key]
+	if s == nil {
+		s = &aggregateSeries{
+			metric: Metric{
+				Type:      m.Type,
+				Namespace: m.Namespace,
+				Name:      m.Name,
+				Tags:      copyTags(m.Tags),
+				Unit:      m.Unit,
+			},
+		}
+		a.series[key] = s
+	}
+
+	for n := m.SampleCount(); n != 0; n-- {
+		s.count++
+		s.metric.Value = f(s.metric.Value, s.count, m.Value)
+	}
+	s.metric.Expires = m.Expires
+
+	a.mutex.Unlock()
+	return true
+}
+
+// flush returns the aggregated metrics, sorted by key, and resets the state of
+// the aggregator.
+func (a *aggregator) flush(now time.Time) []Metric {
+	a.mutex.Lock()
+	series := a.series
+	a.series = make(map[string]*aggregateSeries, len(series))
+	a.mutex.Unlock()
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metrics := make([]Metric, len(keys))
+	for i, key := range keys {
+		metrics[i] = series[key].metric
+		metrics[i].Time = now
+	}
+
+	return metrics
+}
+
+// reportAggregates passes the metrics aggregated since the last flush to the
+// handlers of eng.
+func (eng *Engine) reportAggregates() {
+	metrics := eng.aggregates.flush(time.Now())
+
+	if len(metrics) == 0 {
+		return
+	}
+
+	eng.hmutex.RLock()
+
+	for i := range metrics {
+		for _, handler := range eng.handlers {
+			handler.HandleMetric(&metrics[i])
+		}
+	}
+
+	eng.hmutex.RUnlock()
+}
@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregateFuncs(t *testing.T) {
+	tests := []struct {
+		name  string
+		agg   AggregateFunc
+		value float64
+	}{
+		{name: "sum", agg: AggregateSum, value: 10},
+		{name: "last", agg: AggregateLast, value: 2},
+		{name: "min", agg: AggregateMin, value: 1},
+		{name: "max", agg: AggregateMax, value: 4},
+		{name: "mean", agg: AggregateMean, value: 2.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agg := 0.0
+
+			for i, v := range []float64{3, 4, 1, 2} {
+				agg = test.agg(agg, i+1, v)
+			}
+
+			if agg != test.value {
+				t.Errorf("bad aggregate: %g != %g", agg, test.value)
+			}
+		})
+	}
+}
+
+func TestEngineAggregations(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name: "E",
+		Aggregations: map[string]AggregateFunc{
+			"queue.depth": AggregateMax,
+			"latency":     AggregateMean,
+		},
+	})
+	e.Register(h)
+
+	e.Set("queue.depth", 3, Tag{"queue", "A"})
+	e.Set("queue.depth", 7, Tag{"queue", "A"})
+	e.Set("queue.depth", 5, Tag{"queue", "A"})
+	e.Set("queue.depth", 1, Tag{"queue", "B"})
+	e.Set("conns", 2)
+	e.IncrAndObserve("requests", "latency", 1)
+
+	b := e.Batch()
+	b.Observe("latency", 3)
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "conns", Value: 2},
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1},
+	}) {
+		t.Error("bad metrics before flushing:", h.metrics)
+	}
+
+	h.Reset()
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 2},
+		{Type: GaugeType, Namespace: "E", Name: "queue.depth", Tags: []Tag{{"queue", "A"}}, Value: 7},
+		{Type: GaugeType, Namespace: "E", Name: "queue.depth", Tags: []Tag{{"queue", "B"}}, Value: 1},
+	}) {
+		t.Error("bad metrics after flushing:", h.metrics)
+	}
+
+	h.Reset()
+	e.Flush()
+
+	if len(h.metrics) != 0 {
+		t.Error("aggregates were not reset after flushing:", h.metrics)
+	}
+}
+
+func TestAggregatorSampleCount(t *testing.T) {
+	a := newAggregator(map[string]AggregateFunc{
+		"requests": AggregateSum,
+		"latency":  AggregateMean,
+	})
+
+	a.add(&Metric{Type: CounterType, Name: "requests", Value: 2, Rate: 0.5})
+	a.add(&Metric{Type: HistogramType, Name: "latency", Value: 1, Rate: 0.25})
+	a.add(&Metric{Type: HistogramType, Name: "latency", Value: 6})
+
+	values := make(map[string]float64)
+	for _, m := range a.flush(time.Time{}) {
+		values[m.Name] = m.Value
+	}
+
+	if !reflect.DeepEqual(values, map[string]float64{"requests": 4, "latency": 2}) {
+		t.Error("sampled values were not weighted by their sample count:", values)
+	}
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"log"
+	"sync"
+)
+
+// OtherTagValue is the value that engines substitute to tag values which are
+// not part of the allowlist configured for their tag name.
+const OtherTagValue = "other"
+
+// maxLoggedTagValues is the maximum number of offending tag values that an
+// allowlist remembers having logged. Values are no longer logged past this
+// limit, which bounds the memory used by the allowlist when tags carry values
+// of unbounded cardinality.
+const maxLoggedTagValues = 1000
+
+// tagAllowlist rewrites the values of tags that aren't part of a configured
+// set of allowed values.
+type tagAllowlist struct {
+	values map[string]map[string]struct{}
+	log    bool
+	mutex  sync.Mutex
+	logged map[Tag]struct{}
+}
+
+func newTagAllowlist(values map[string][]string, log bool) *tagAllowlist {
+	a := &tagAllowlist{
+		values: make(map[string]map[string]struct{}, len(values)),
+		log:    log,
+		logged: make(map[Tag]struct{}),
+	}
+
+	for name, list := range values {
+		set := make(map[string]struct{}, len(list))
+		for _, value := range list {
+			set[value] = struct{}{}
+		}
+		a.values[name] = set
+	}
+
+	return a
+}
+
+// rewrite replaces in place the values of tags that aren't allowed.
+func (a *tagAllowlist) rewrite(namespace string, name string, tags []Tag) {
+	for i, t := range tags {
+		set, ok := a.values[t.Name]
+		if !ok {
+			continue
+		}
+
+		if _, ok = set[t.Value]; ok {
+			continue
+		}
+
+		if a.log {
+			a.report(namespace, name, t)
+		}
+
+		tags[i].Value = OtherTagValue
+	}
+}
+
+func (a *tagAllowlist) report(namespace string, name string, tag Tag) {
+	a.mutex.Lock()
+	_, logged := a.logged[tag]
+	skip := logged || len(a.logged) >= maxLoggedTagValues
+	if !skip {
+		a.logged[tag] = struct{}{}
+	}
+	last := !skip && len(a.logged) == maxLoggedTagValues
+	a.mutex.Unlock()
+
+	if skip {
+		return
+	}
+
+	log.Printf("stats: value %q of tag %q on metric %s.%s is not allowed and was reported as %q", tag.Value, tag.Name, namespace, name, OtherTagValue)
+
+	if last {
+		log.Printf("stats: logged %d tag values which were not allowed, the following ones will not be logged", maxLoggedTagValues)
+	}
+}
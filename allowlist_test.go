@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestEngineTagValues(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name: "E",
+		TagValues: map[string][]string{
+			"status": {"ok", "error", "timeout"},
+		},
+	})
+	e.Register(h)
+
+	e.Incr("requests", Tag{"status", "ok"}, Tag{"path", "/"})
+	e.Incr("requests", Tag{"status", "teapot"}, Tag{"path", "/"})
+	e.WithTags(Tag{"status", "unknown"}).Set("conns", 1)
+
+	b := e.Batch()
+	b.Incr("requests", Tag{"status", "gone"})
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"status", "ok"}, {"path", "/"}}, Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"status", "other"}, {"path", "/"}}, Value: 1},
+		{Type: GaugeType, Namespace: "E", Name: "conns", Tags: []Tag{{"status", "other"}}, Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"status", "other"}}, Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestTagAllowlistLoggedValues(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	a := newTagAllowlist(map[string][]string{"user": {"admin"}}, true)
+
+	for i := 0; i != 2*maxLoggedTagValues; i++ {
+		a.rewrite("E", "requests", []Tag{{"user", strconv.Itoa(i)}})
+	}
+
+	if n := len(a.logged); n != maxLoggedTagValues {
+		t.Error("bad number of logged tag values:", n)
+	}
+}
@@ -0,0 +1,183 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// AuditRecord is a structured record of a single metric, produced by audit
+// handlers for each flagged metric they receive.
+type AuditRecord struct {
+	Sequence  uint64     `json:"sequence"`
+	Type      MetricType `json:"type"`
+	Namespace string     `json:"namespace,omitempty"`
+	Name      string     `json:"name"`
+	Value     float64    `json:"value"`
+	Unit      string     `json:"unit,omitempty"`
+	Rate      float64    `json:"rate,omitempty"`
+	Time      time.Time  `json:"time"`
+	Tags      []Tag      `json:"tags,omitempty"`
+}
+
+// AuditSink is the interface implemented by the destinations of audit records.
+//
+// Records are written in the order of their sequence numbers, one at a time,
+// the sink must not retain the record after returning.
+type AuditSink interface {
+	WriteAuditRecord(record *AuditRecord) error
+}
+
+// AuditSinkFunc is an adapter which allows the use of ordinary functions as
+// audit sinks.
+type AuditSinkFunc func(*AuditRecord) error
+
+// WriteAuditRecord satisfies the AuditSink interface.
+func (f AuditSinkFunc) WriteAuditRecord(record *AuditRecord) error {
+	return f(record)
+}
+
+// NewJSONAuditSink returns an audit sink which writes records to w as JSON
+// objects separated by newlines.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	enc := json.NewEncoder(w)
+	return AuditSinkFunc(func(record *AuditRecord) error {
+		return enc.Encode(record)
+	})
+}
+
+// The AuditConfig type is used to configure audit handlers.
+type AuditConfig struct {
+	// Metrics is the list of names of the metrics flagged for auditing.
+	Metrics []string
+
+	// Sink is the destination of the audit records, it must be set.
+	Sink AuditSink
+
+	// OnError is called with the errors returned by the sink, the errors are
+	// logged by default.
+	OnError func(error)
+}
+
+type auditHandler struct {
+	handler  Handler
+	metrics  map[string]struct{}
+	sink     AuditSink
+	onError  func(error)
+	mutex    sync.Mutex
+	sequence uint64
+	record   AuditRecord
+}
+
+// NewAuditHandler returns a handler which passes the metrics it receives to
+// handler and, for each metric flagged in the configuration, writes an audit
+// record to the configured sink.
+//
+// Unlike the series reported by handler, which may be aggregated, every
+// flagged metric produces exactly one record carrying all its fields. Records
+// are numbered with a sequence starting at 1 and increasing by one for each
+// record, which lets consumers of an append-only sink detect missing records.
+// A record which failed to be written still consumes its sequence number.
+//
+// The function panics if the sink of the configuration is nil.
+func NewAuditHandler(handler Handler, config AuditConfig) Handler {
+	if config.Sink == nil {
+		panic("stats: the sink of audit handlers must be set")
+	}
+
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			log.Printf("stats: writing audit record failed: %s", err)
+		}
+	}
+
+	h := &auditHandler{
+		handler: handler,
+		metrics: make(map[string]struct{}, len(config.Metrics)),
+		sink:    config.Sink,
+		onError: config.OnError,
+	}
+
+	for _, name := range config.Metrics {
+		h.metrics[name] = struct{}{}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *auditHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.audit(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *auditHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.audit(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *auditHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// audit writes the audit record of m if it is one of the audited metrics.
+func (h *auditHandler) audit(m *Metric) {
+	if _, ok := h.metrics[m.Name]; !ok {
+		return
+	}
+
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	// The lock is held while writing so records reach the sink in the order
+	// of their sequence numbers.
+	h.mutex.Lock()
+	h.sequence++
+	h.record = AuditRecord{
+		Sequence:  h.sequence,
+		Type:      m.Type,
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		Value:     m.Value,
+		Unit:      m.Unit,
+		Rate:      m.Rate,
+		Time:      t,
+		Tags:      append(h.record.Tags[:0], m.Tags...),
+	}
+	err := h.sink.WriteAuditRecord(&h.record)
+	h.mutex.Unlock()
+
+	if err != nil {
+		h.onError(err)
+	}
+}
+
+// Flush satisfies the Flusher interface.
+func (h *auditHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *auditHandler) FlushContext(ctx context.Context) {
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface, the sequence of audit records is not
+// reset.
+func (h *auditHandler) Reset() {
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
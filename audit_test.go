@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditHandler(t *testing.T) {
+	h := &handler{}
+	b := &bytes.Buffer{}
+	a := NewAuditHandler(h, AuditConfig{
+		Metrics: []string{"transactions"},
+		Sink:    NewJSONAuditSink(b),
+	})
+
+	e := NewEngine("E")
+	e.Register(a)
+
+	e.Add("transactions", 10, Tag{"account", "1"})
+	e.Incr("requests")
+	e.Add("transactions", 20, Tag{"account", "2"})
+
+	if len(h.metrics) != 3 {
+		t.Error("bad metrics passed to the handler:", h.metrics)
+	}
+
+	var records []AuditRecord
+	dec := json.NewDecoder(b)
+
+	for dec.More() {
+		var r AuditRecord
+
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Time.IsZero() {
+			t.Error("audit record without time:", r)
+		}
+
+		r.Time = time.Time{}
+		records = append(records, r)
+	}
+
+	if !reflect.DeepEqual(records, []AuditRecord{
+		{
+			Sequence:  1,
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "transactions",
+			Value:     10,
+			Tags:      []Tag{{"account", "1"}},
+		},
+		{
+			Sequence:  2,
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "transactions",
+			Value:     20,
+			Tags:      []Tag{{"account", "2"}},
+		},
+	}) {
+		t.Error("bad audit records:", records)
+	}
+}
+
+func TestAuditHandlerSinkError(t *testing.T) {
+	var sequences []uint64
+	var errs []error
+
+	fail := true
+	a := NewAuditHandler(&handler{}, AuditConfig{
+		Metrics: []string{"transactions"},
+		Sink: AuditSinkFunc(func(r *AuditRecord) error {
+			if fail {
+				fail = false
+				return errors.New("disk full")
+			}
+			sequences = append(sequences, r.Sequence)
+			return nil
+		}),
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	e := NewEngine("E")
+	e.Register(a)
+	e.Incr("transactions")
+	e.Incr("transactions")
+
+	if len(errs) != 1 {
+		t.Error("bad errors:", errs)
+	}
+
+	// The failed record consumed its sequence number, the gap is visible to
+	// consumers of the sink.
+	if !reflect.DeepEqual(sequences, []uint64{2}) {
+		t.Error("bad sequence numbers:", sequences)
+	}
+}
+
+func TestAuditHandlerNilSink(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	NewAuditHandler(&handler{}, AuditConfig{Metrics: []string{"payments"}})
+}
@@ -0,0 +1,512 @@
+// Package awscloudwatch exposes a client which publishes metrics to Amazon
+// CloudWatch, either with PutMetricData requests or as log lines in the
+// embedded metric format.
+package awscloudwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultNamespace is the default CloudWatch namespace that metrics are
+	// published to.
+	DefaultNamespace = "stats"
+
+	// DefaultBatchSize is the default number of datums sent in each
+	// PutMetricData request.
+	DefaultBatchSize = 20
+
+	// DefaultTimeout is the default timeout of requests sent to CloudWatch.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is the default number of times that throttled
+	// requests are retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the default delay before the first retry of a
+	// throttled request, the delay doubles on each retry.
+	DefaultRetryDelay = 100 * time.Millisecond
+
+	// DefaultMaxPendingLines is the default number of full log lines of
+	// histograms that clients retain until they are flushed.
+	DefaultMaxPendingLines = 100
+)
+
+// The ClientConfig type is used to configure CloudWatch clients.
+type ClientConfig struct {
+	// Putter is the AWS client used to put metric data, one of Putter or
+	// Output must be set.
+	Putter Putter
+
+	// Output enables writing metrics as log lines in the CloudWatch embedded
+	// metric format (EMF) instead of sending PutMetricData requests, which is
+	// the way to publish metrics from Lambda functions where the lines
+	// written to os.Stdout are collected by CloudWatch Logs.
+	Output io.Writer
+
+	// Namespace is the CloudWatch namespace that metrics are published to,
+	// defaults to DefaultNamespace.
+	Namespace string
+
+	// Dimensions is the list of names of the tags converted to dimensions,
+	// other tags are dropped. When the list is empty all tags are converted
+	// to dimensions, up to MaxDimensions.
+	//
+	// Each combination of dimensions is a separate CloudWatch metric, which
+	// is billed separately.
+	Dimensions []string
+
+	// BatchSize is the number of datums sent in each PutMetricData request,
+	// it is capped to MaxDatumsPerRequest and defaults to DefaultBatchSize.
+	BatchSize int
+
+	// FlushInterval enables flushing the client in the background at this
+	// interval, in addition to the flushes of the engine it is registered
+	// on. The background flushes are stopped by closing the client.
+	FlushInterval time.Duration
+
+	// Timeout is the maximum amount of time that each request sent to
+	// CloudWatch is allowed to take.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times that throttled requests are retried,
+	// a negative value disables retries.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry of a throttled request,
+	// the delay doubles on each retry.
+	RetryDelay time.Duration
+
+	// MaxPendingLines is the number of log lines of histograms which reached
+	// MaxValuesPerMetric values that the client retains until it is flushed,
+	// with the embedded metric format. It defaults to DefaultMaxPendingLines,
+	// the values observed when this number is reached are dropped, see
+	// Dropped.
+	MaxPendingLines int
+}
+
+// Client represents a CloudWatch client that receives metrics from a stats
+// engine and publishes them to CloudWatch.
+//
+// The client aggregates metrics between flushes and publishes one datum per
+// series: the sum of counters, the last value of gauges, and the sample count,
+// sum, minimum and maximum of histograms. With the embedded metric format the
+// values of histograms are written instead, so CloudWatch can compute their
+// percentiles. Tags are converted to dimensions, tags with empty values are
+// omitted because CloudWatch rejects empty dimensions, and so are non-finite
+// values.
+//
+// Requests are only sent, and log lines written, when the client is flushed,
+// outside of the lock held by HandleMetric, so a slow or unavailable CloudWatch
+// never blocks the code producing metrics.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	dropped int64
+	mutex   sync.Mutex
+	config  ClientConfig
+	series  map[string]*series
+	order   []*series
+	pending []*series // histograms which reached MaxValuesPerMetric values
+	done    chan struct{}
+	once    sync.Once
+}
+
+type series struct {
+	name       string
+	dimensions []Dimension
+	mtype      stats.MetricType
+	unit       string
+	value      float64
+	count      float64
+	min        float64
+	max        float64
+	values     []float64 // values of histograms, only with the embedded metric format
+}
+
+// NewClient creates and returns a new CloudWatch client putting metric data to
+// namespace with putter.
+func NewClient(putter Putter, namespace string) *Client {
+	return NewClientWith(ClientConfig{
+		Putter:    putter,
+		Namespace: namespace,
+	})
+}
+
+// NewEMFClient creates and returns a new CloudWatch client writing metrics of
+// namespace to w in the embedded metric format.
+func NewEMFClient(w io.Writer, namespace string) *Client {
+	return NewClientWith(ClientConfig{
+		Output:    w,
+		Namespace: namespace,
+	})
+}
+
+// NewClientWith creates and returns a new CloudWatch client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Namespace) == 0 {
+		config.Namespace = DefaultNamespace
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	if config.BatchSize > MaxDatumsPerRequest {
+		config.BatchSize = MaxDatumsPerRequest
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	if config.MaxPendingLines <= 0 {
+		config.MaxPendingLines = DefaultMaxPendingLines
+	}
+
+	c := &Client{
+		config: config,
+		series: make(map[string]*series),
+		done:   make(chan struct{}),
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	}
+
+	return c
+}
+
+// Close satisfies the io.Closer interface, it stops the background flushes and
+// flushes the client.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.Flush()
+	return nil
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// CloudWatch are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	now := time.Now()
+	c.mutex.Lock()
+
+	if c.config.Output != nil {
+		b := make([]byte, 0, 1024)
+
+		for _, s := range c.pending {
+			b = appendEMFLine(b, c.config.Namespace, []*series{s}, now)
+		}
+
+		b = appendEMF(b, c.config.Namespace, c.order, now)
+		c.reset()
+		c.mutex.Unlock()
+
+		if len(b) != 0 {
+			c.writeEMF(b)
+		}
+		return
+	}
+
+	data := make([]Datum, 0, len(c.order))
+
+	for _, s := range c.order {
+		data = append(data, s.datum(now))
+	}
+
+	c.reset()
+	c.mutex.Unlock()
+	c.put(ctx, data)
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+		return
+	}
+
+	name := m.Name
+	if len(m.Namespace) != 0 {
+		name = m.Namespace + "." + name
+	}
+
+	dimensions := c.makeDimensions(m.Tags)
+	key := seriesKey(name, dimensions)
+
+	c.mutex.Lock()
+	s := c.lookup(key, name, dimensions, m)
+	n := m.SampleCount()
+
+	switch m.Type {
+	case stats.CounterType:
+		s.value += m.Value * float64(n)
+
+	case stats.HistogramType, stats.SummaryType, stats.ExponentialHistogramType:
+		if c.config.Output == nil {
+			s.observe(m.Value, n)
+			break
+		}
+
+		// Log lines carry the values of histograms, the value is repeated
+		// for each occurrence it stands for.
+		for ; n != 0; n-- {
+			s.observe(m.Value, 1)
+
+			if s.values = append(s.values, m.Value); len(s.values) >= MaxValuesPerMetric {
+				c.enqueue(s)
+			}
+		}
+
+	default:
+		s.value = m.Value
+	}
+
+	c.mutex.Unlock()
+}
+
+// lookup returns the series of m with key, creating it if it doesn't exist.
+func (c *Client) lookup(key string, name string, dimensions []Dimension, m *stats.Metric) *series {
+	s := c.series[key]
+
+	if s == nil {
+		s = &series{
+			name:       name,
+			dimensions: dimensions,
+			mtype:      m.Type,
+			unit:       cloudwatchUnit(m.Unit),
+		}
+		c.series[key] = s
+		c.order = append(c.order, s)
+	}
+
+	return s
+}
+
+// enqueue retains the full line of values of s until the next flush, the values
+// are dropped when MaxPendingLines lines are already retained.
+func (c *Client) enqueue(s *series) {
+	if len(c.pending) < c.config.MaxPendingLines {
+		full := *s
+		c.pending = append(c.pending, &full)
+		s.values = make([]float64, 0, MaxValuesPerMetric)
+		return
+	}
+
+	atomic.AddInt64(&c.dropped, int64(len(s.values)))
+	s.values = s.values[:0]
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// requests to CloudWatch, or writes of log lines, which failed.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// histogram values discarded because MaxPendingLines was reached.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *Client) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) reset() {
+	c.series = make(map[string]*series)
+	c.order = nil
+	c.pending = nil
+}
+
+// put sends data to CloudWatch in batches of BatchSize.
+func (c *Client) put(ctx context.Context, data []Datum) {
+	for len(data) != 0 {
+		n := c.config.BatchSize
+		if n > len(data) {
+			n = len(data)
+		}
+
+		if err := c.write(ctx, data[:n]); err != nil {
+			atomic.AddInt64(&c.errors, 1)
+			log.Printf("stats/awscloudwatch: putting %d datums to %s failed: %s", n, c.config.Namespace, err)
+		}
+
+		data = data[n:]
+	}
+}
+
+// write sends data to CloudWatch, retrying throttled requests with an
+// exponential backoff, until ctx is canceled.
+func (c *Client) write(ctx context.Context, data []Datum) error {
+	input := &PutMetricDataInput{
+		Namespace:  c.config.Namespace,
+		MetricData: data,
+	}
+
+	return retry.Do(ctx, c.config.MaxRetries, c.config.RetryDelay, func() (bool, error) {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+		err := c.config.Putter.PutMetricData(attemptCtx, input)
+		return throttled(err), err
+	})
+}
+
+// writeEMF writes the log lines b to the output of the client, with a single
+// call to its Write method so lines are not interleaved with the output of
+// other writers.
+func (c *Client) writeEMF(b []byte) {
+	if _, err := c.config.Output.Write(b); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/awscloudwatch: writing metrics of %s failed: %s", c.config.Namespace, err)
+	}
+}
+
+// throttled returns true if err reports that the request was throttled.
+func throttled(err error) bool {
+	e, ok := err.(interface {
+		ErrorCode() string
+	})
+	return ok && (e.ErrorCode() == "Throttling" || e.ErrorCode() == "ThrottlingException")
+}
+
+func (c *Client) makeDimensions(tags []stats.Tag) []Dimension {
+	dimensions := make([]Dimension, 0, len(tags))
+
+	for _, t := range tags {
+		if len(t.Name) == 0 || len(t.Value) == 0 || !c.dimension(t.Name) {
+			continue
+		}
+
+		if len(dimensions) == MaxDimensions {
+			break
+		}
+
+		dimensions = append(dimensions, Dimension{Name: t.Name, Value: t.Value})
+	}
+
+	return dimensions
+}
+
+// dimension returns true if tags named name are converted to dimensions.
+func (c *Client) dimension(name string) bool {
+	if len(c.config.Dimensions) == 0 {
+		return true
+	}
+
+	for _, d := range c.config.Dimensions {
+		if d == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observe records n occurrences of value.
+func (s *series) observe(value float64, n uint64) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+
+	s.count += float64(n)
+	s.value += value * float64(n)
+}
+
+func (s *series) histogram() bool {
+	switch s.mtype {
+	case stats.HistogramType, stats.SummaryType, stats.ExponentialHistogramType:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *series) datum(now time.Time) Datum {
+	d := Datum{
+		MetricName: s.name,
+		Dimensions: s.dimensions,
+		Timestamp:  now,
+		Value:      s.value,
+		Unit:       s.unit,
+	}
+
+	if s.histogram() {
+		d.StatisticValues = &StatisticSet{
+			SampleCount: s.count,
+			Sum:         s.value,
+			Minimum:     s.min,
+			Maximum:     s.max,
+		}
+	}
+
+	return d
+}
+
+func seriesKey(name string, dimensions []Dimension) string {
+	b := make([]byte, 0, 64)
+	b = append(b, name...)
+
+	for _, d := range dimensions {
+		b = append(b, 0)
+		b = append(b, d.Name...)
+		b = append(b, '=')
+		b = append(b, d.Value...)
+	}
+
+	return string(b)
+}
+
+// cloudwatchUnit returns the CloudWatch unit of values expressed in unit, or an
+// empty string if CloudWatch has no equivalent unit.
+func cloudwatchUnit(unit string) string {
+	switch unit {
+	case "seconds":
+		return "Seconds"
+	case "milliseconds":
+		return "Milliseconds"
+	case "microseconds":
+		return "Microseconds"
+	case "bytes":
+		return "Bytes"
+	case "percent":
+		return "Percent"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,302 @@
+package awscloudwatch
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type testPutter struct {
+	mutex    sync.Mutex
+	inputs   []*PutMetricDataInput
+	failures []error // errors returned by the next calls
+}
+
+func (p *testPutter) PutMetricData(ctx context.Context, input *PutMetricDataInput) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.failures) != 0 {
+		err := p.failures[0]
+		p.failures = p.failures[1:]
+		return err
+	}
+
+	p.inputs = append(p.inputs, input)
+	return nil
+}
+
+type apiError string
+
+func (e apiError) Error() string     { return string(e) }
+func (e apiError) ErrorCode() string { return string(e) }
+
+func TestClient(t *testing.T) {
+	p := &testPutter{}
+	c := NewClient(p, "service")
+
+	e := stats.NewEngine("test")
+	e.Register(c)
+	e.Incr("calls", stats.Tag{"op", "read"}, stats.Tag{"empty", ""})
+	e.Add("calls", 2, stats.Tag{"op", "read"})
+	e.Set("conns", 1)
+	e.Set("conns", 3)
+	e.Observe("size", 1)
+	e.Observe("size", 4)
+	e.Observe("size", math.NaN())
+	e.Histogram("latency").ObserveDuration(time.Second)
+	e.Flush()
+
+	if len(p.inputs) != 1 {
+		t.Fatal("bad number of requests:", len(p.inputs))
+	}
+
+	input := p.inputs[0]
+
+	if input.Namespace != "service" {
+		t.Error("bad namespace:", input.Namespace)
+	}
+
+	for i := range input.MetricData {
+		if input.MetricData[i].Timestamp.IsZero() {
+			t.Error("missing timestamp:", input.MetricData[i].MetricName)
+		}
+		input.MetricData[i].Timestamp = time.Time{}
+	}
+
+	if !reflect.DeepEqual(input.MetricData, []Datum{
+		{
+			MetricName: "test.calls",
+			Dimensions: []Dimension{{"op", "read"}},
+			Value:      3,
+		},
+		{
+			MetricName: "test.conns",
+			Dimensions: []Dimension{},
+			Value:      3,
+		},
+		{
+			MetricName:      "test.size",
+			Dimensions:      []Dimension{},
+			Value:           5,
+			StatisticValues: &StatisticSet{SampleCount: 2, Sum: 5, Minimum: 1, Maximum: 4},
+		},
+		{
+			MetricName:      "test.latency",
+			Dimensions:      []Dimension{},
+			Value:           1,
+			StatisticValues: &StatisticSet{SampleCount: 1, Sum: 1, Minimum: 1, Maximum: 1},
+			Unit:            "Seconds",
+		},
+	}) {
+		t.Errorf("bad metric data: %+v", input.MetricData)
+	}
+
+	e.Flush()
+
+	if len(p.inputs) != 1 {
+		t.Error("series were not reset by the flush:", len(p.inputs))
+	}
+}
+
+func TestClientSampleCount(t *testing.T) {
+	p := &testPutter{}
+	c := NewClient(p, "service")
+
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1, Rate: 0.5})
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 2, Rate: 0.25})
+	c.Flush()
+
+	if len(p.inputs) != 1 {
+		t.Fatal("bad number of requests:", len(p.inputs))
+	}
+
+	data := p.inputs[0].MetricData
+
+	for i := range data {
+		data[i].Timestamp = time.Time{}
+	}
+
+	if !reflect.DeepEqual(data, []Datum{
+		{
+			MetricName: "calls",
+			Dimensions: []Dimension{},
+			Value:      2,
+		},
+		{
+			MetricName:      "size",
+			Dimensions:      []Dimension{},
+			Value:           8,
+			StatisticValues: &StatisticSet{SampleCount: 4, Sum: 8, Minimum: 2, Maximum: 2},
+		},
+	}) {
+		t.Errorf("sampled metrics were not weighted by their sample count: %+v", data)
+	}
+}
+
+func TestClientDimensions(t *testing.T) {
+	tests := []struct {
+		names      []string
+		tags       []stats.Tag
+		dimensions []Dimension
+	}{
+		{
+			tags:       []stats.Tag{{"host", "a"}, {"op", "read"}},
+			dimensions: []Dimension{{"host", "a"}, {"op", "read"}},
+		},
+		{
+			names:      []string{"op"},
+			tags:       []stats.Tag{{"host", "a"}, {"op", "read"}},
+			dimensions: []Dimension{{"op", "read"}},
+		},
+		{
+			names:      []string{"region"},
+			tags:       []stats.Tag{{"host", "a"}, {"op", "read"}},
+			dimensions: []Dimension{},
+		},
+	}
+
+	for _, test := range tests {
+		c := NewClientWith(ClientConfig{Dimensions: test.names})
+
+		if dimensions := c.makeDimensions(test.tags); !reflect.DeepEqual(dimensions, test.dimensions) {
+			t.Errorf("%v: bad dimensions: %v", test.names, dimensions)
+		}
+	}
+
+	tags := make([]stats.Tag, 2*MaxDimensions)
+	for i := range tags {
+		tags[i] = stats.Tag{Name: string(rune('a' + i)), Value: "x"}
+	}
+
+	if n := len(NewClientWith(ClientConfig{}).makeDimensions(tags)); n != MaxDimensions {
+		t.Error("bad number of dimensions:", n)
+	}
+}
+
+func TestClientBatchSize(t *testing.T) {
+	p := &testPutter{}
+	c := NewClientWith(ClientConfig{
+		Putter:    p,
+		BatchSize: 2000, // capped to MaxDatumsPerRequest
+	})
+
+	for i := 0; i != 2500; i++ {
+		c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1, Tags: []stats.Tag{{"id", string(rune(i))}}})
+	}
+
+	c.Flush()
+
+	if len(p.inputs) != 3 {
+		t.Fatal("bad number of requests:", len(p.inputs))
+	}
+
+	for i, n := range []int{1000, 1000, 500} {
+		if len(p.inputs[i].MetricData) != n {
+			t.Errorf("bad number of datums in request %d: %d", i, len(p.inputs[i].MetricData))
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error
+		requests int
+		errors   int64
+	}{
+		{
+			name:     "throttled",
+			failures: []error{apiError("Throttling"), apiError("ThrottlingException")},
+			requests: 1,
+		},
+		{
+			name:     "retries exhausted",
+			failures: []error{apiError("Throttling"), apiError("Throttling"), apiError("Throttling")},
+			errors:   1,
+		},
+		{
+			name:     "not retried",
+			failures: []error{errors.New("invalid parameter")},
+			errors:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &testPutter{failures: test.failures}
+			c := NewClientWith(ClientConfig{
+				Putter:     p,
+				MaxRetries: 2,
+				RetryDelay: time.Millisecond,
+			})
+
+			c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+			c.Flush()
+
+			if len(p.inputs) != test.requests {
+				t.Error("bad number of successful requests:", len(p.inputs))
+			}
+
+			if n := c.Errors(); n != test.errors {
+				t.Error("bad number of errors:", n)
+			}
+		})
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	p := &testPutter{failures: []error{apiError("Throttling"), apiError("Throttling")}}
+	c := NewClientWith(ClientConfig{
+		Putter:     p,
+		MaxRetries: 1,
+		RetryDelay: time.Hour,
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if n := c.Errors(); n != 1 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientFlushInterval(t *testing.T) {
+	p := &testPutter{}
+	c := NewClientWith(ClientConfig{
+		Putter:        p,
+		FlushInterval: time.Millisecond,
+	})
+	defer c.Close()
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+
+	for i := 0; i != 1000; i++ {
+		p.mutex.Lock()
+		n := len(p.inputs)
+		p.mutex.Unlock()
+
+		if n != 0 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Error("the client was not flushed in the background")
+}
@@ -0,0 +1,57 @@
+package awscloudwatch
+
+import (
+	"context"
+	"time"
+)
+
+// MaxDatumsPerRequest is the maximum number of datums that CloudWatch accepts
+// in a single PutMetricData request.
+const MaxDatumsPerRequest = 1000
+
+// MaxDimensions is the maximum number of dimensions of CloudWatch metrics.
+const MaxDimensions = 30
+
+// Putter is the interface of the AWS clients used to put metric data to
+// CloudWatch.
+//
+// The package doesn't depend on the AWS SDK, programs provide an adapter which
+// converts the input to a cloudwatch.PutMetricDataInput and calls the
+// PutMetricData method of the SDK client. Errors which have an ErrorCode
+// method returning "Throttling" or "ThrottlingException", like the API errors
+// of the SDK, are retried by the client.
+type Putter interface {
+	PutMetricData(ctx context.Context, input *PutMetricDataInput) error
+}
+
+// PutMetricDataInput mirrors the input of the CloudWatch PutMetricData API.
+type PutMetricDataInput struct {
+	Namespace  string
+	MetricData []Datum
+}
+
+// Datum mirrors a CloudWatch metric datum, it carries either a single value or
+// the statistics of the values observed by a histogram.
+type Datum struct {
+	MetricName      string
+	Dimensions      []Dimension
+	Timestamp       time.Time
+	Value           float64       // ignored when StatisticValues is set
+	StatisticValues *StatisticSet // set on the datums of histograms
+	Unit            string        // omitted when empty, which CloudWatch treats as "None"
+}
+
+// Dimension mirrors a CloudWatch dimension, the tags of metrics are converted
+// to dimensions.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// StatisticSet mirrors the statistics of a CloudWatch datum.
+type StatisticSet struct {
+	SampleCount float64
+	Sum         float64
+	Minimum     float64
+	Maximum     float64
+}
@@ -0,0 +1,118 @@
+package awscloudwatch
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// MaxMetricsPerLine is the maximum number of metrics that CloudWatch extracts
+// from a log line in the embedded metric format.
+const MaxMetricsPerLine = 100
+
+// MaxValuesPerMetric is the maximum number of values of a metric in a log line
+// in the embedded metric format, the line of a histogram which reaches this
+// number of values is retained until the next flush, see MaxPendingLines.
+const MaxValuesPerMetric = 100
+
+// appendEMF appends the log lines of metrics in the embedded metric format to
+// b. The metrics sharing the same dimensions are written to the same lines, up
+// to MaxMetricsPerLine per line, for example:
+//
+//	{"_aws":{"Timestamp":1500000000000,"CloudWatchMetrics":[{"Namespace":"service","Dimensions":[["op"]],"Metrics":[{"Name":"calls"}]}]},"op":"read","calls":1}
+func appendEMF(b []byte, namespace string, metrics []*series, now time.Time) []byte {
+	var groups [][]*series
+	index := make(map[string]int)
+
+	for _, s := range metrics {
+		key := seriesKey("", s.dimensions)
+		i, ok := index[key]
+
+		if !ok || len(groups[i]) == MaxMetricsPerLine {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], s)
+	}
+
+	for _, g := range groups {
+		b = appendEMFLine(b, namespace, g, now)
+	}
+
+	return b
+}
+
+// appendEMFLine appends a log line carrying metrics, which all have the same
+// dimensions, to b.
+func appendEMFLine(b []byte, namespace string, metrics []*series, now time.Time) []byte {
+	dimensions := metrics[0].dimensions
+
+	b = append(b, `{"_aws":{"Timestamp":`...)
+	b = strconv.AppendInt(b, now.UnixNano()/int64(time.Millisecond), 10)
+	b = append(b, `,"CloudWatchMetrics":[{"Namespace":`...)
+	b = appendString(b, namespace)
+	b = append(b, `,"Dimensions":[[`...)
+
+	for i, d := range dimensions {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, d.Name)
+	}
+
+	b = append(b, `]],"Metrics":[`...)
+
+	for i, s := range metrics {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"Name":`...)
+		b = appendString(b, s.name)
+		if len(s.unit) != 0 {
+			b = append(b, `,"Unit":`...)
+			b = appendString(b, s.unit)
+		}
+		b = append(b, '}')
+	}
+
+	b = append(b, "]}]}"...)
+
+	for _, d := range dimensions {
+		b = append(b, ',')
+		b = appendString(b, d.Name)
+		b = append(b, ':')
+		b = appendString(b, d.Value)
+	}
+
+	for _, s := range metrics {
+		b = append(b, ',')
+		b = appendString(b, s.name)
+		b = append(b, ':')
+
+		if s.histogram() {
+			b = append(b, '[')
+			for i, v := range s.values {
+				if i != 0 {
+					b = append(b, ',')
+				}
+				b = appendFloat(b, v)
+			}
+			b = append(b, ']')
+		} else {
+			b = appendFloat(b, s.value)
+		}
+	}
+
+	return append(b, '}', '\n')
+}
+
+func appendString(b []byte, s string) []byte {
+	j, _ := json.Marshal(s)
+	return append(b, j...)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return strconv.AppendFloat(b, f, 'g', -1, 64)
+}
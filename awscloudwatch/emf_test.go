@@ -0,0 +1,145 @@
+package awscloudwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAppendEMF(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	metrics := []*series{
+		{name: "calls", dimensions: []Dimension{{"op", "read"}}, mtype: stats.CounterType, value: 2},
+		{name: "conns", mtype: stats.GaugeType, value: 3},
+		{name: "latency", dimensions: []Dimension{{"op", "read"}}, mtype: stats.HistogramType, unit: "Seconds", values: []float64{0.5, 1}},
+	}
+
+	if s := string(appendEMF(nil, "service", metrics, now)); s != `{"_aws":{"Timestamp":1500000000000,"CloudWatchMetrics":[{"Namespace":"service","Dimensions":[["op"]],"Metrics":[{"Name":"calls"},{"Name":"latency","Unit":"Seconds"}]}]},"op":"read","calls":2,"latency":[0.5,1]}
+{"_aws":{"Timestamp":1500000000000,"CloudWatchMetrics":[{"Namespace":"service","Dimensions":[[]],"Metrics":[{"Name":"conns"}]}]},"conns":3}
+` {
+		t.Error("bad log lines:\n" + s)
+	}
+}
+
+func TestAppendEMFMaxMetrics(t *testing.T) {
+	metrics := make([]*series, MaxMetricsPerLine+1)
+
+	for i := range metrics {
+		metrics[i] = &series{name: "m" + strconv.Itoa(i), mtype: stats.GaugeType}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(appendEMF(nil, "service", metrics, time.Now()))), "\n")
+
+	if len(lines) != 2 {
+		t.Fatal("bad number of lines:", len(lines))
+	}
+
+	for i, n := range []int{MaxMetricsPerLine, 1} {
+		var line struct {
+			AWS struct {
+				CloudWatchMetrics []struct {
+					Metrics []struct{ Name string }
+				}
+			} `json:"_aws"`
+		}
+
+		if err := json.Unmarshal([]byte(lines[i]), &line); err != nil {
+			t.Fatal(err)
+		}
+
+		if m := len(line.AWS.CloudWatchMetrics[0].Metrics); m != n {
+			t.Errorf("bad number of metrics in line %d: %d", i, m)
+		}
+	}
+}
+
+func TestClientEMF(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewEMFClient(b, "service")
+
+	e := stats.NewEngine("test")
+	e.Register(c)
+
+	for i := 0; i != MaxValuesPerMetric+1; i++ {
+		e.Observe("size", float64(i))
+	}
+
+	// The histogram reached the maximum number of values of a line, which is
+	// retained until the flush.
+	if n := b.Len(); n != 0 {
+		t.Error("lines were written before flushing:", b.String())
+	}
+
+	e.Flush()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Fatal("bad number of lines:", len(lines))
+	}
+
+	var line map[string]interface{}
+
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	if values, _ := line["test.size"].([]interface{}); len(values) != 1 || values[0] != float64(MaxValuesPerMetric) {
+		t.Error("bad values:", line["test.size"])
+	}
+}
+
+func TestClientEMFSampleCount(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewEMFClient(b, "service")
+
+	// The value stands for more occurrences than a line can carry, it is
+	// spread over two lines.
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 1, Rate: 1.0 / (MaxValuesPerMetric + 50)})
+	c.Flush()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Fatal("bad number of lines:", len(lines))
+	}
+
+	for i, n := range []int{MaxValuesPerMetric, 50} {
+		var line struct{ Size []float64 }
+
+		if err := json.Unmarshal([]byte(lines[i]), &line); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(line.Size) != n {
+			t.Errorf("bad number of values in line %d: %d", i, len(line.Size))
+		}
+	}
+}
+
+func TestClientEMFMaxPendingLines(t *testing.T) {
+	b := &bytes.Buffer{}
+	c := NewClientWith(ClientConfig{
+		Output:          b,
+		Namespace:       "service",
+		MaxPendingLines: 1,
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 1, Rate: 1.0 / (3*MaxValuesPerMetric + 50)})
+
+	if n := c.Dropped(); n != 2*MaxValuesPerMetric {
+		t.Error("bad number of dropped values:", n)
+	}
+
+	c.Flush()
+
+	if n := strings.Count(b.String(), "\n"); n != 2 {
+		t.Error("bad number of lines:", n)
+	}
+}
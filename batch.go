@@ -0,0 +1,224 @@
+package stats
+
+import "time"
+
+// BatchHandler is an interface that may be implemented by metric handlers
+// which can apply a group of metrics atomically.
+type BatchHandler interface {
+	Handler
+
+	// HandleMetrics is called to report a group of metrics committed by a
+	// batch, the metrics must be applied so that none or all of them are
+	// visible to readers of the handler's state.
+	//
+	// The handler does not have ownership of the metric objects it receives,
+	// it must not retain the objects or any of their fields.
+	HandleMetrics([]*Metric)
+}
+
+// A Batch buffers metrics produced on an engine until they are committed.
+//
+// All metrics of a batch are reported with the same time and the same engine
+// tags when the batch is committed. Handlers implementing the BatchHandler
+// interface receive them in a single call and guarantee that a reader of
+// their state (a prometheus scrape for example) observes either none or all of
+// the batch. Other handlers receive the metrics one by one, in the order they
+// were added to the batch, and give no atomicity guarantee.
+//
+// Batches are not safe to use concurrently from multiple goroutines.
+type Batch struct {
+	eng     *Engine
+	metrics []Metric
+}
+
+// Batch returns a new batch producing metrics on eng.
+func (eng *Engine) Batch() *Batch {
+	return &Batch{eng: eng}
+}
+
+// Len returns the number of metrics buffered in the batch.
+func (b *Batch) Len() int {
+	return len(b.metrics)
+}
+
+// Incr buffers an increment by 1 of the counter with name and tags.
+func (b *Batch) Incr(name string, tags ...Tag) {
+	b.add(CounterType, name, 1, tags)
+}
+
+// Add buffers an addition of value to the counter with name and tags.
+func (b *Batch) Add(name string, value float64, tags ...Tag) {
+	b.add(CounterType, name, value, tags)
+}
+
+// Set buffers setting the gauge with name and tags to value.
+func (b *Batch) Set(name string, value float64, tags ...Tag) {
+	b.add(GaugeType, name, value, tags)
+}
+
+// Observe buffers reporting value on the histogram with name and tags.
+func (b *Batch) Observe(name string, value float64, tags ...Tag) {
+	b.add(HistogramType, name, value, tags)
+}
+
+// ObserveDuration buffers reporting a duration in seconds on the histogram
+// with name and tags.
+func (b *Batch) ObserveDuration(name string, value time.Duration, tags ...Tag) {
+	b.add(HistogramType, name, value.Seconds(), tags)
+}
+
+// Commit reports all metrics buffered in the batch to the engine's handlers
+// with the current time, then resets the batch so it can be reused.
+func (b *Batch) Commit() {
+	b.CommitAt(time.Now())
+}
+
+// CommitAt is like Commit but reports the metrics with time t.
+func (b *Batch) CommitAt(t time.Time) {
+	if len(b.metrics) == 0 {
+		return
+	}
+
+	if !b.eng.enabled() {
+		b.reset()
+		return
+	}
+
+	eng := b.eng
+	list := make([]*Metric, 0, len(b.metrics))
+
+	if eng.queue != nil {
+		eng.queue.enqueueAt(t, len(b.metrics))
+	}
+
+	commit := func(m *Metric) {
+		m.Namespace = eng.name
+		m.Tags = eng.appendTags(make([]Tag, 0, len(eng.tags)+len(eng.lazy)+len(m.Tags)), m.Tags)
+		m.Time = t
+		if eng.allow != nil {
+			eng.allow.rewrite(m.Namespace, m.Name, m.Tags)
+		}
+		if d := eng.schema.observe(m.Type, m.Namespace, m.Name, m.Tags); d != nil {
+			eng.announce(*d)
+		}
+		if eng.aggregates != nil && eng.aggregates.add(m) {
+			return
+		}
+		list = append(list, m)
+	}
+
+	var outliers []*Metric
+
+	for i := range b.metrics {
+		m := &b.metrics[i]
+		value, rate, outlier, ok := eng.admit(m.Type, m.Name, m.Value)
+
+		if outlier {
+			outliers = append(outliers, &Metric{Type: CounterType, Name: m.Name + OutlierSuffix, Value: 1, Tags: m.Tags})
+		}
+
+		if ok {
+			m.Value, m.Rate = value, rate
+			commit(m)
+		}
+	}
+
+	// The counters of outliers are committed with the batch, after the
+	// observations like with Engine.Observe.
+	for _, m := range outliers {
+		commit(m)
+	}
+
+	if len(list) == 0 {
+		b.reset()
+		return
+	}
+
+	if eng.shards != nil && len(eng.shard) != 0 {
+		for _, m := range list {
+			aggregate := &Metric{}
+			eng.shards.aggregate(aggregate, m, eng.shard)
+			list = append(list, aggregate)
+		}
+	}
+
+	eng.hmutex.RLock()
+
+	for _, handler := range eng.handlers {
+		handleMetrics(handler, list)
+	}
+
+	eng.hmutex.RUnlock()
+	b.reset()
+}
+
+// handleMetrics passes metrics to h in a single call if it implements the
+// BatchHandler interface, or one by one otherwise.
+func handleMetrics(h Handler, metrics []*Metric) {
+	if bh, ok := h.(BatchHandler); ok {
+		bh.HandleMetrics(metrics)
+		return
+	}
+
+	for _, m := range metrics {
+		h.HandleMetric(m)
+	}
+}
+
+// forwardMetrics calls handle with each of metrics, the metrics that handle
+// passes to its second argument are forwarded to next, in a single call if next
+// implements the BatchHandler interface. Handlers transforming the metrics they
+// pass to the handlers they wrap use it to preserve the atomicity of batches.
+func forwardMetrics(next Handler, metrics []*Metric, handle func(*Metric, Handler)) {
+	bh, ok := next.(BatchHandler)
+	if !ok {
+		for _, m := range metrics {
+			handle(m, next)
+		}
+		return
+	}
+
+	b := &metricBuffer{}
+
+	for _, m := range metrics {
+		handle(m, b)
+	}
+
+	bh.HandleMetrics(b.list())
+}
+
+// metricBuffer is a handler retaining copies of the metrics it receives.
+type metricBuffer struct {
+	metrics []Metric
+}
+
+func (b *metricBuffer) HandleMetric(m *Metric) {
+	c := *m
+	c.Tags = copyTags(m.Tags)
+	b.metrics = append(b.metrics, c)
+}
+
+func (b *metricBuffer) list() []*Metric {
+	list := make([]*Metric, len(b.metrics))
+	for i := range b.metrics {
+		list[i] = &b.metrics[i]
+	}
+	return list
+}
+
+func (b *Batch) reset() {
+	for i := range b.metrics {
+		b.metrics[i] = Metric{}
+	}
+
+	b.metrics = b.metrics[:0]
+}
+
+func (b *Batch) add(typ MetricType, name string, value float64, tags []Tag) {
+	b.metrics = append(b.metrics, Metric{
+		Type:  typ,
+		Name:  name,
+		Value: value,
+		Tags:  copyTags(tags),
+	})
+}
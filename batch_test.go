@@ -0,0 +1,197 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type batchHandler struct {
+	handler
+	batches int
+}
+
+func (h *batchHandler) HandleMetrics(metrics []*Metric) {
+	h.batches++
+	for _, m := range metrics {
+		h.HandleMetric(m)
+	}
+}
+
+// batchDescribeHandler is used to test that handlers wrapping other handlers
+// forward batches and schemas.
+type batchDescribeHandler struct {
+	batchHandler
+	schemas []MetricSchema
+}
+
+func (h *batchDescribeHandler) DescribeMetric(s MetricSchema) {
+	h.schemas = append(h.schemas, s)
+}
+
+func TestBatchCommit(t *testing.T) {
+	h1 := &handler{}
+	h2 := &batchHandler{}
+	times := []time.Time{}
+	e := NewEngine("E", Tag{"base", "tag"})
+	e.Register(h1)
+	e.Register(h2)
+	e.Register(HandlerFunc(func(m *Metric) { times = append(times, m.Time) }))
+
+	b := e.Batch()
+	b.Incr("A", Tag{"extra", "tag"})
+	b.Set("B", 2)
+	b.ObserveDuration("C", 3*time.Second)
+
+	if len(h1.metrics) != 0 || len(h2.metrics) != 0 {
+		t.Error("metrics were reported before the batch was committed")
+	}
+
+	b.CommitAt(time.Unix(1, 0))
+
+	if n := b.Len(); n != 0 {
+		t.Error("the batch was not reset after being committed:", n)
+	}
+
+	expected := []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     1,
+			Tags:      []Tag{{"base", "tag"}, {"extra", "tag"}},
+		},
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "B",
+			Value:     2,
+			Tags:      []Tag{{"base", "tag"}},
+		},
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "C",
+			Value:     3,
+			Tags:      []Tag{{"base", "tag"}},
+		},
+	}
+
+	if !reflect.DeepEqual(h1.metrics, expected) {
+		t.Error("bad metrics:", h1.metrics)
+	}
+
+	if !reflect.DeepEqual(h2.metrics, expected) {
+		t.Error("bad metrics:", h2.metrics)
+	}
+
+	if h2.batches != 1 {
+		t.Error("the batch handler should have received a single batch:", h2.batches)
+	}
+
+	for _, x := range times {
+		if !x.Equal(time.Unix(1, 0)) {
+			t.Error("bad metric time:", x)
+		}
+	}
+
+	b.Commit()
+
+	if h2.batches != 1 {
+		t.Error("committing an empty batch should not call the handlers")
+	}
+}
+
+func TestBatchSampling(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:        "E",
+		SampleRates: map[string]float64{"requests": 0.5},
+	})
+	e.Register(h)
+
+	sampled := 0
+
+	for i := 0; i != 1000; i++ {
+		h.metrics = nil
+
+		b := e.WithSampleSeed(uint64(i)).Batch()
+		b.Incr("requests")
+		b.Set("conns", 1)
+		b.Commit()
+
+		switch len(h.metrics) {
+		case 1:
+		case 2:
+			sampled++
+
+			if m := h.metrics[0]; m.Name != "requests" || m.Rate != 0.5 {
+				t.Errorf("seed %d: bad sampled metric: %+v", i, m)
+			}
+		default:
+			t.Fatalf("seed %d: bad metrics: %v", i, h.metrics)
+		}
+
+		kept := len(h.metrics) == 2
+
+		// Batches make the same decisions as the engine.
+		h.metrics = nil
+		e.WithSampleSeed(uint64(i)).Incr("requests")
+
+		if (len(h.metrics) == 1) != kept {
+			t.Fatalf("seed %d: the decision of the batch differs from the engine", i)
+		}
+	}
+
+	if sampled < 400 || sampled > 600 {
+		t.Error("bad number of sampled requests:", sampled)
+	}
+}
+
+func TestBatchOutliers(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:     "E",
+		Outliers: map[string]OutlierThreshold{"latency": {Value: 1}},
+	})
+	e.Register(h)
+
+	b := e.Batch()
+	b.Observe("latency", 2, Tag{"operation", "write"})
+	b.Observe("latency", 0.5, Tag{"operation", "read"})
+	b.CommitAt(time.Time{})
+
+	want := []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 2, Tags: []Tag{{"operation", "write"}}},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 0.5, Tags: []Tag{{"operation", "read"}}},
+		{Type: CounterType, Namespace: "E", Name: "latency" + OutlierSuffix, Value: 1, Tags: []Tag{{"operation", "write"}}},
+	}
+
+	if !reflect.DeepEqual(h.metrics, want) {
+		t.Errorf("bad metrics:\n- expected: %#v\n- found:    %#v", want, h.metrics)
+	}
+}
+
+func TestWrappedBatchHandler(t *testing.T) {
+	for _, test := range wrappers {
+		t.Run(test.name, func(t *testing.T) {
+			h := &batchDescribeHandler{}
+			w := test.wrap(h)
+
+			w.(BatchHandler).HandleMetrics([]*Metric{
+				{Type: GaugeType, Name: "A", Value: 1},
+				{Type: GaugeType, Name: "B", Value: 2},
+			})
+
+			if h.batches != 1 || len(h.metrics) != 2 {
+				t.Error("the batch was not passed to the wrapped handler:", h.batches, h.metrics)
+			}
+
+			w.(Describer).DescribeMetric(MetricSchema{Type: GaugeType, Name: "A", Help: "help"})
+
+			if len(h.schemas) != 1 || h.schemas[0].Help != "help" {
+				t.Error("the schema was not passed to the wrapped handler:", h.schemas)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package batchstats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// Job is a metric collector that reports metrics on the runs of a batch job,
+// like a cron task.
+//
+// The last success timestamp of a job is reported again on every run, even
+// when the run fails, so backends always have a value to alert on when the
+// job stops succeeding.
+type Job struct {
+	mutex       sync.Mutex
+	eng         *stats.Engine
+	name        string
+	lastSuccess *stats.Gauge
+	lastStart   *stats.Gauge
+	lastEnd     *stats.Gauge
+}
+
+// NewJob creates a job collector with name which produces metrics on the
+// default engine.
+func NewJob(name string) *Job {
+	return NewJobWith(stats.DefaultEngine, name)
+}
+
+// NewJobWith creates a job collector with name which produces metrics on eng.
+func NewJobWith(eng *stats.Engine, name string) *Job {
+	tag := stats.Tag{"job", name}
+
+	eng.Describe(stats.CounterType, "batch.run.count", "Number of runs of the batch job.", "runs", "job", "result")
+	eng.Describe(stats.HistogramType, "batch.run.seconds", "Time taken by runs of the batch job.", "seconds", "job", "result")
+	eng.Describe(stats.GaugeType, "batch.last_success.timestamp", "Unix time of the last successful run of the batch job.", "seconds", "job")
+	eng.Describe(stats.GaugeType, "batch.last_start.timestamp", "Unix time at which the last run of the batch job started.", "seconds", "job")
+	eng.Describe(stats.GaugeType, "batch.last_end.timestamp", "Unix time at which the last run of the batch job ended.", "seconds", "job")
+
+	return &Job{
+		eng:         eng,
+		name:        name,
+		lastSuccess: eng.Gauge("batch.last_success.timestamp", tag),
+		lastStart:   eng.Gauge("batch.last_start.timestamp", tag),
+		lastEnd:     eng.Gauge("batch.last_end.timestamp", tag),
+	}
+}
+
+// Name returns the name of the job.
+func (j *Job) Name() string {
+	return j.name
+}
+
+// LastSuccess returns the time of the last successful run of the job, or the
+// zero-value of time.Time if it never succeeded.
+func (j *Job) LastSuccess() time.Time {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if sec := j.lastSuccess.Value(); sec != 0 {
+		return time.Unix(0, int64(sec*float64(time.Second)))
+	}
+
+	return time.Time{}
+}
+
+// Run calls fn, reporting metrics on the execution of the function.
+//
+// The run is considered failed if fn returns a non-nil error or panics, in
+// which case the panic is propagated after metrics were reported. Runs of the
+// same job may be concurrent, the mutex only serializes the updates of the
+// timestamps before and after fn is called.
+func (j *Job) Run(fn func() error) (err error) {
+	j.mutex.Lock()
+	start := time.Now()
+	j.lastStart.Set(unixSeconds(start))
+	j.mutex.Unlock()
+
+	result := "failure"
+
+	defer func() {
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+
+		end := time.Now()
+		tags := []stats.Tag{{"job", j.name}, {"result", result}}
+
+		j.eng.Incr("batch.run.count", tags...)
+		j.eng.ObserveDuration("batch.run.seconds", end.Sub(start), tags...)
+		j.lastEnd.Set(unixSeconds(end))
+
+		if result == "success" {
+			j.lastSuccess.Set(unixSeconds(end))
+		} else if last := j.lastSuccess.Value(); last != 0 {
+			j.lastSuccess.Set(last)
+		}
+	}()
+
+	if err = fn(); err == nil {
+		result = "success"
+	}
+
+	return
+}
+
+// Run calls fn as a batch job with name, producing metrics on the default
+// engine.
+//
+// Jobs created by Run are registered by name, subsequent calls with the same
+// name reuse the job so its last success timestamp is preserved across runs.
+func Run(name string, fn func() error) error {
+	return lookupJob(name).Run(fn)
+}
+
+var (
+	jobsMutex sync.Mutex
+	jobs      = make(map[string]*Job)
+)
+
+func lookupJob(name string) *Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	j := jobs[name]
+
+	if j == nil {
+		j = NewJob(name)
+		jobs[name] = j
+	}
+
+	return j
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
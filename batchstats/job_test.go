@@ -0,0 +1,127 @@
+package batchstats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type handler struct {
+	metrics []stats.Metric
+}
+
+func (h *handler) HandleMetric(m *stats.Metric) {
+	c := *m
+	c.Tags = append([]stats.Tag{}, m.Tags...)
+	c.Time = time.Time{} // discard because it's unpredicatable
+	h.metrics = append(h.metrics, c)
+}
+
+func (h *handler) find(name string) (m []stats.Metric) {
+	for _, x := range h.metrics {
+		if x.Name == name {
+			m = append(m, x)
+		}
+	}
+	return
+}
+
+func TestJobRun(t *testing.T) {
+	h := &handler{}
+	e := stats.NewEngine("")
+	e.Register(h)
+
+	j := NewJobWith(e, "cleanup")
+
+	if err := j.Run(func() error { return nil }); err != nil {
+		t.Error(err)
+	}
+
+	success := j.LastSuccess()
+
+	if success.IsZero() {
+		t.Error("the last success time was not set after a successful run")
+	}
+
+	fail := errors.New("fail")
+
+	if err := j.Run(func() error { return fail }); err != fail {
+		t.Error("bad error:", err)
+	}
+
+	if last := j.LastSuccess(); !last.Equal(success) {
+		t.Error("the last success time changed after a failed run:", last)
+	}
+
+	runs := h.find("batch.run.count")
+
+	if len(runs) != 2 {
+		t.Fatal("bad number of runs reported:", len(runs))
+	}
+
+	for i, result := range []string{"success", "failure"} {
+		if tag := runs[i].Tags[1]; tag != (stats.Tag{"result", result}) {
+			t.Error("bad result tag:", tag)
+		}
+	}
+
+	if n := len(h.find("batch.run.seconds")); n != 2 {
+		t.Error("bad number of durations reported:", n)
+	}
+
+	if n := len(h.find("batch.last_success.timestamp")); n != 2 {
+		t.Error("the last success timestamp was not reported on every run:", n)
+	}
+}
+
+func TestJobRunPanic(t *testing.T) {
+	h := &handler{}
+	e := stats.NewEngine("")
+	e.Register(h)
+
+	func() {
+		defer func() { recover() }()
+		NewJobWith(e, "panic").Run(func() error { panic("oops") })
+	}()
+
+	runs := h.find("batch.run.count")
+
+	if len(runs) != 1 || runs[0].Tags[1] != (stats.Tag{"result", "failure"}) {
+		t.Error("bad runs reported after a panic:", runs)
+	}
+}
+
+func TestJobRunConcurrent(t *testing.T) {
+	j := NewJobWith(stats.NewEngine(""), "concurrent")
+	started := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- j.Run(func() error {
+			select {
+			case <-started:
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("the second run did not start while the first one was running")
+			}
+		})
+	}()
+
+	if err := j.Run(func() error { close(started); return nil }); err != nil {
+		t.Error(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunReusesJobs(t *testing.T) {
+	Run("reuse", func() error { return nil })
+
+	if lookupJob("reuse").LastSuccess().IsZero() {
+		t.Error("the job was not registered by the call to Run")
+	}
+}
@@ -0,0 +1,271 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCircuitBreakerFailures is the default number of consecutive
+	// failed flushes after which circuit breakers open.
+	DefaultCircuitBreakerFailures = 5
+
+	// DefaultCircuitBreakerCooldown is the default amount of time during
+	// which open circuit breakers discard metrics.
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+
+	// CircuitBreakerMetricName is the name of the counter reported by circuit
+	// breakers when they change state, it carries a "breaker" tag set to the
+	// name of the breaker and a "state" tag set to the new state.
+	CircuitBreakerMetricName = "stats.circuit_breaker.transitions"
+)
+
+// CircuitState is an enumeration of the states of circuit breakers.
+type CircuitState int32
+
+const (
+	// CircuitClosed is the state of circuit breakers passing metrics to the
+	// handler they wrap.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen is the state of circuit breakers discarding metrics because
+	// the handler they wrap failed too many times in a row.
+	CircuitOpen
+
+	// CircuitHalfOpen is the state of circuit breakers probing whether the
+	// handler they wrap recovered.
+	CircuitHalfOpen
+)
+
+// String satisfies the fmt.Stringer interface.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// The CircuitBreakerConfig type is used to configure circuit breakers.
+type CircuitBreakerConfig struct {
+	// Name identifies the breaker in logs and in the CircuitBreakerMetricName
+	// counter.
+	Name string
+
+	// Failures is the number of consecutive failed flushes after which the
+	// breaker opens, defaults to DefaultCircuitBreakerFailures.
+	Failures int
+
+	// Cooldown is the amount of time during which the breaker stays open,
+	// defaults to DefaultCircuitBreakerCooldown.
+	Cooldown time.Duration
+
+	// Transitions is the handler that the CircuitBreakerMetricName counter is
+	// reported to when the breaker changes state, it must not be the wrapped
+	// handler which is the one failing. State changes are only logged when
+	// it is nil.
+	Transitions Handler
+}
+
+// CircuitBreaker is a metric handler which stops passing metrics to a handler
+// sending them to a backend after it failed repeatedly.
+//
+// A flush of the wrapped handler fails when the number of errors reported by
+// its Errors method increased since the previous flush, handlers which don't
+// implement the ErrorCounter interface never fail. After the configured
+// number of consecutive failures the breaker opens: metrics are discarded
+// (and counted by the Dropped method) and flushes are skipped, which avoids
+// wasting resources and filling buffers while the backend is down. When the
+// cooldown elapses the breaker becomes half-open, the metrics received are
+// passed to the handler again and the next flush is a probe: the breaker
+// closes if it succeeds, and opens for another cooldown if it fails.
+//
+// Each state change is logged, and reported as an increment of the
+// CircuitBreakerMetricName counter to the Transitions handler of the
+// configuration, which is typically a handler sending metrics to another
+// backend than the wrapped one.
+type CircuitBreaker struct {
+	// Both fields are first for alignment of atomic operations.
+	dropped int64
+	state   int32
+
+	handler  Handler
+	config   CircuitBreakerConfig
+	now      func() time.Time
+	mutex    sync.Mutex
+	failures int
+	errors   int64 // errors of the handler after the last flush
+	until    time.Time
+}
+
+// NewCircuitBreaker returns a circuit breaker wrapping handler, configured with
+// config.
+func NewCircuitBreaker(handler Handler, config CircuitBreakerConfig) *CircuitBreaker {
+	if config.Failures <= 0 {
+		config.Failures = DefaultCircuitBreakerFailures
+	}
+
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	b := &CircuitBreaker{
+		handler: handler,
+		config:  config,
+		now:     time.Now,
+	}
+
+	if c, ok := handler.(ErrorCounter); ok {
+		b.errors = c.Errors()
+	}
+
+	return b
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() CircuitState {
+	return CircuitState(atomic.LoadInt32(&b.state))
+}
+
+// HandleMetric satisfies the Handler interface.
+func (b *CircuitBreaker) HandleMetric(m *Metric) {
+	if b.State() == CircuitOpen && !b.cooledDown() {
+		atomic.AddInt64(&b.dropped, 1)
+		return
+	}
+	b.handler.HandleMetric(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (b *CircuitBreaker) HandleMetrics(metrics []*Metric) {
+	if b.State() == CircuitOpen && !b.cooledDown() {
+		atomic.AddInt64(&b.dropped, int64(len(metrics)))
+		return
+	}
+	handleMetrics(b.handler, metrics)
+}
+
+// DescribeMetric satisfies the Describer interface, schemas are passed to the
+// wrapped handler regardless of the state of the breaker.
+func (b *CircuitBreaker) DescribeMetric(schema MetricSchema) {
+	describeMetric(b.handler, schema)
+}
+
+// Flush satisfies the Flusher interface.
+func (b *CircuitBreaker) Flush() {
+	b.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (b *CircuitBreaker) FlushContext(ctx context.Context) {
+	if b.State() == CircuitOpen && !b.cooledDown() {
+		return
+	}
+
+	flushHandler(ctx, b.handler)
+
+	c, ok := b.handler.(ErrorCounter)
+	if !ok {
+		return
+	}
+
+	errors := c.Errors()
+
+	b.mutex.Lock()
+	failed := errors > b.errors
+	b.errors = errors
+	from := b.State()
+	to := from
+
+	if failed {
+		b.failures++
+		if from == CircuitHalfOpen || b.failures >= b.config.Failures {
+			to = CircuitOpen
+			b.failures = 0
+			b.until = b.now().Add(b.config.Cooldown)
+		}
+	} else {
+		b.failures = 0
+		to = CircuitClosed
+	}
+
+	atomic.StoreInt32(&b.state, int32(to))
+	b.mutex.Unlock()
+
+	if to != from {
+		b.transition(to)
+	}
+}
+
+// Reset satisfies the Resetter interface.
+func (b *CircuitBreaker) Reset() {
+	if r, ok := b.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// Dropped satisfies the DropCounter interface, it returns the number of metrics
+// discarded while the breaker was open, plus the metrics dropped by the
+// wrapped handler.
+func (b *CircuitBreaker) Dropped() int64 {
+	n := atomic.LoadInt64(&b.dropped)
+	if c, ok := b.handler.(DropCounter); ok {
+		n += c.Dropped()
+	}
+	return n
+}
+
+// Errors satisfies the ErrorCounter interface, it returns the errors of the
+// wrapped handler.
+func (b *CircuitBreaker) Errors() int64 {
+	if c, ok := b.handler.(ErrorCounter); ok {
+		return c.Errors()
+	}
+	return 0
+}
+
+// cooledDown moves an open breaker to the half-open state when its cooldown
+// elapsed, and returns true if the breaker isn't open anymore.
+func (b *CircuitBreaker) cooledDown() bool {
+	b.mutex.Lock()
+	ok := b.State() != CircuitOpen || !b.now().Before(b.until)
+	changed := ok && b.State() == CircuitOpen
+
+	if changed {
+		atomic.StoreInt32(&b.state, int32(CircuitHalfOpen))
+	}
+
+	b.mutex.Unlock()
+
+	if changed {
+		b.transition(CircuitHalfOpen)
+	}
+
+	return ok
+}
+
+func (b *CircuitBreaker) transition(to CircuitState) {
+	log.Printf("stats: circuit breaker %q is now %s", b.config.Name, to)
+
+	if b.config.Transitions == nil {
+		return
+	}
+
+	b.config.Transitions.HandleMetric(&Metric{
+		Type:  CounterType,
+		Name:  CircuitBreakerMetricName,
+		Value: 1,
+		Tags:  []Tag{{"breaker", b.config.Name}, {"state", to.String()}},
+		Time:  b.now(),
+	})
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type failingHandler struct {
+	handler
+	fail   bool
+	errors int64
+}
+
+func (h *failingHandler) Flush() {
+	h.handler.Flush()
+	if h.fail {
+		h.errors++
+	}
+}
+
+func (h *failingHandler) Errors() int64 { return h.errors }
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	h := &failingHandler{fail: true}
+	transitions := &handler{}
+	b := NewCircuitBreaker(h, CircuitBreakerConfig{
+		Name:        "backend",
+		Failures:    2,
+		Cooldown:    10 * time.Second,
+		Transitions: transitions,
+	})
+	b.now = func() time.Time { return now }
+
+	e := NewEngine("E")
+	e.Register(b)
+
+	e.Incr("calls")
+	e.Flush()
+
+	if s := b.State(); s != CircuitClosed {
+		t.Error("the breaker opened after a single failure:", s)
+	}
+
+	e.Flush()
+
+	if s := b.State(); s != CircuitOpen {
+		t.Fatal("the breaker did not open after two failures:", s)
+	}
+
+	h.Reset()
+	e.Incr("calls")
+	e.Incr("calls")
+	e.Flush()
+
+	if len(h.metrics) != 0 || h.flushed != 2 {
+		t.Error("the open breaker passed metrics or flushes to the handler:", h.metrics, h.flushed)
+	}
+
+	if n := b.Dropped(); n != 2 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	// The probe fails, the breaker opens for another cooldown.
+	now = now.Add(10 * time.Second)
+	e.Incr("calls")
+	e.Flush()
+
+	if s := b.State(); s != CircuitOpen || h.flushed != 3 {
+		t.Error("the failed probe did not reopen the breaker:", s, h.flushed)
+	}
+
+	// The probe succeeds, the breaker closes.
+	h.fail = false
+	h.Reset()
+	now = now.Add(10 * time.Second)
+	e.Flush()
+
+	if s := b.State(); s != CircuitClosed {
+		t.Error("the successful probe did not close the breaker:", s)
+	}
+
+	if len(h.metrics) != 0 {
+		t.Error("transition metrics were reported to the failing handler:", h.metrics)
+	}
+
+	states := []string{}
+	for _, m := range transitions.metrics {
+		if m.Name == CircuitBreakerMetricName {
+			states = append(states, m.Tags[1].Value)
+		}
+	}
+
+	if !reflect.DeepEqual(states, []string{"open", "half_open", "open", "half_open", "closed"}) {
+		t.Error("bad transition metrics:", transitions.metrics)
+	}
+}
+
+func TestCircuitBreakerForwarding(t *testing.T) {
+	h := &batchDescribeHandler{}
+	b := NewCircuitBreaker(h, CircuitBreakerConfig{})
+
+	b.HandleMetrics([]*Metric{{Name: "A"}, {Name: "B"}})
+	b.DescribeMetric(MetricSchema{Name: "A", Help: "help"})
+
+	if h.batches != 1 || len(h.metrics) != 2 {
+		t.Error("the batch was not passed to the wrapped handler:", h.batches, h.metrics)
+	}
+
+	if len(h.schemas) != 1 || h.schemas[0].Help != "help" {
+		t.Error("the schema was not passed to the wrapped handler:", h.schemas)
+	}
+}
+
+func TestCircuitBreakerWithoutErrorCounter(t *testing.T) {
+	h := &handler{}
+	b := NewCircuitBreaker(h, CircuitBreakerConfig{Failures: 1})
+
+	for i := 0; i != 3; i++ {
+		b.Flush()
+	}
+
+	if s := b.State(); s != CircuitClosed || h.flushed != 3 {
+		t.Error("bad breaker state for a handler without errors:", s, h.flushed)
+	}
+}
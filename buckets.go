@@ -0,0 +1,178 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// sortedBuckets returns a sorted copy of limits.
+func sortedBuckets(limits []float64) []float64 {
+	limits = append([]float64(nil), limits...)
+	sort.Float64s(limits)
+	return limits
+}
+
+// ErrorBoundBuckets returns the smallest set of exponential histogram buckets
+// covering the range [min, max] for which the relative error of values
+// estimated from the buckets is at most relErr, for example 0.05 for 5%. The
+// returned limits can be used wherever handlers accept bucket configurations.
+//
+// A value v falling in the bucket (l, u] is best estimated by 2lu/(l+u), the
+// relative error of this estimate is at most (γ-1)/(γ+1) where γ = u/l is the
+// growth factor of the buckets. Bounding the error by relErr yields:
+//
+//	γ = (1 + relErr) / (1 - relErr)
+//
+// The limits are min·γ^i for i in [0, n], where n is the smallest integer such
+// that min·γ^n >= max, so the function returns n+1 limits with:
+//
+//	n = ceil(ln(max/min) / ln(γ))
+//
+// For example, latencies between 1ms and 10s with a 5% error bound require 94
+// buckets, and 47 with a 10% error bound. Percentiles computed from the
+// buckets (the p99 for example) have the same error bound as long as the
+// values are in the range. Values below min fall in the first bucket and
+// values above max are only counted in the total, their error is unbounded.
+//
+// The function panics if min is not positive, if max is not greater than min,
+// or if relErr is not between 0 and 1 (exclusive).
+func ErrorBoundBuckets(min float64, max float64, relErr float64) []float64 {
+	if !(min > 0) || !(max > min) {
+		panic(fmt.Sprintf("stats: invalid range for error bound buckets: [%g, %g]", min, max))
+	}
+
+	if !(relErr > 0 && relErr < 1) {
+		panic(fmt.Sprintf("stats: invalid relative error for error bound buckets: %g", relErr))
+	}
+
+	growth := (1 + relErr) / (1 - relErr)
+	n := int(math.Ceil(math.Log(max/min) / math.Log(growth)))
+	limits := make([]float64, n+1)
+
+	for i := range limits {
+		limits[i] = min * math.Pow(growth, float64(i))
+	}
+
+	return limits
+}
+
+// LogLinearBuckets returns log-linear histogram buckets covering the range
+// [min, max], which suit values spanning many orders of magnitude, like sizes
+// that are usually small but occasionally huge. The returned limits can be
+// used wherever handlers accept bucket configurations.
+//
+// The range is split in magnitudes, the intervals [base^k, base^(k+1)), and
+// each magnitude is split in steps buckets of equal width. The resolution is
+// therefore fine in absolute terms for small values and coarse for large
+// values, while remaining constant relative to the magnitude of the values.
+// For example, with a base of 10 and 9 steps the limits are 1, 2, ..., 9, 10,
+// 20, ..., 90, 100, 200, and so on.
+//
+// The buckets of the magnitude starting at base^k have a width of
+// base^k·(base-1)/steps, so the relative error of values estimated at the
+// middle of their bucket is at most (base-1)/(2·steps), reached at the start
+// of each magnitude, and decreases to (base-1)/(2·steps·base) at its end.
+// Covering the range takes about steps·log(max/min)/log(base) buckets. Unlike
+// ErrorBoundBuckets, which bounds the relative error uniformly, the limits
+// are round numbers, which keeps dashboards readable.
+//
+// The function panics if min is not positive, if max is not greater than min,
+// if base is not greater than 1, or if steps is not positive.
+func LogLinearBuckets(min float64, max float64, base float64, steps int) []float64 {
+	if !(min > 0) || !(max > min) || math.IsInf(max, +1) {
+		panic(fmt.Sprintf("stats: invalid range for log-linear buckets: [%g, %g]", min, max))
+	}
+
+	if !(base > 1) || math.IsInf(base, +1) || steps <= 0 {
+		panic(fmt.Sprintf("stats: invalid base or steps for log-linear buckets: %g, %d", base, steps))
+	}
+
+	var limits []float64
+	k := math.Floor(math.Log(min) / math.Log(base))
+
+	for {
+		magnitude := math.Pow(base, k)
+
+		for i := 0; i != steps; i++ {
+			limit := roundSignificant(magnitude * (1 + float64(i)*(base-1)/float64(steps)))
+
+			// The limit below min is kept so values down to min fall in a
+			// bucket of the configured width.
+			if limit <= min && len(limits) != 0 {
+				limits = limits[:0]
+			}
+
+			limits = append(limits, limit)
+
+			if limit >= max {
+				return limits
+			}
+		}
+
+		k++
+	}
+}
+
+// roundSignificant rounds x to 12 significant digits, which removes the
+// floating point errors of the limits computed by LogLinearBuckets.
+func roundSignificant(x float64) float64 {
+	scale := math.Pow(10, 11-math.Floor(math.Log10(x)))
+	return math.Round(x*scale) / scale
+}
+
+// sloRatios are the ratios of the SLO targets at which SLOBuckets places
+// limits, the points surrounding the targets give quantile estimates some
+// resolution on both sides of each target.
+var sloRatios = []float64{0.5, 0.75, 0.9, 1, 1.1, 1.25, 1.5, 2}
+
+// SLOBuckets returns histogram buckets with limits placed exactly at the SLO
+// targets, for example 0.2, 0.5 and 1 for latency objectives in seconds, and at
+// a few points surrounding each target (from half to twice the target). The
+// returned limits can be used wherever handlers accept bucket configurations.
+//
+// Limits at the targets make the ratio of values below a threshold exact, and
+// histogram_quantile accurate around the thresholds, rather than interpolated
+// between limits unrelated to the objectives. Limits closer to each other than
+// floating point precision are merged, preferring the targets.
+//
+// The function panics if one of the targets is not positive.
+func SLOBuckets(targets ...float64) []float64 {
+	limits := make([]float64, 0, len(targets)*len(sloRatios))
+
+	for _, target := range targets {
+		if !(target > 0) || math.IsInf(target, +1) {
+			panic(fmt.Sprintf("stats: invalid SLO target for buckets: %g", target))
+		}
+
+		for _, r := range sloRatios {
+			limits = append(limits, target*r)
+		}
+	}
+
+	sort.Float64s(limits)
+	merged := limits[:0]
+
+	for _, l := range limits {
+		if n := len(merged); n != 0 && nearlyEqual(merged[n-1], l) {
+			continue
+		}
+		merged = append(merged, l)
+	}
+
+	for _, target := range targets {
+		i := sort.SearchFloat64s(merged, target)
+
+		if i != 0 && (i == len(merged) || !nearlyEqual(merged[i], target)) {
+			i--
+		}
+
+		merged[i] = target
+	}
+
+	return merged
+}
+
+func nearlyEqual(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestErrorBoundBuckets(t *testing.T) {
+	tests := []struct {
+		min    float64
+		max    float64
+		relErr float64
+		count  int
+	}{
+		{min: 0.001, max: 10, relErr: 0.05, count: 94},
+		{min: 0.001, max: 10, relErr: 0.1, count: 47},
+		{min: 1, max: 2, relErr: 0.5, count: 2},
+	}
+
+	for _, test := range tests {
+		limits := ErrorBoundBuckets(test.min, test.max, test.relErr)
+
+		if len(limits) != test.count {
+			t.Errorf("bad number of buckets for [%g, %g] with %g error: %d", test.min, test.max, test.relErr, len(limits))
+		}
+
+		if limits[0] != test.min || limits[len(limits)-1] < test.max || limits[len(limits)-2] >= test.max {
+			t.Error("the buckets do not cover the range minimally:", limits)
+		}
+
+		for i := 1; i < len(limits); i++ {
+			l, u := limits[i-1], limits[i]
+			estimate := 2 * l * u / (l + u)
+
+			// The worst cases are values at the edges of the bucket.
+			for _, v := range []float64{l, u} {
+				if err := math.Abs(estimate-v) / v; err > test.relErr*(1+1e-9) {
+					t.Errorf("relative error of %g exceeds the bound in (%g, %g]: %g", v, l, u, err)
+				}
+			}
+		}
+	}
+}
+
+func TestErrorBoundBucketsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		min    float64
+		max    float64
+		relErr float64
+	}{
+		{name: "zero min", min: 0, max: 1, relErr: 0.1},
+		{name: "empty range", min: 1, max: 1, relErr: 0.1},
+		{name: "zero error", min: 1, max: 2, relErr: 0},
+		{name: "error too large", min: 1, max: 2, relErr: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			ErrorBoundBuckets(test.min, test.max, test.relErr)
+		})
+	}
+}
+
+func TestSLOBuckets(t *testing.T) {
+	tests := []struct {
+		targets []float64
+		limits  []float64
+	}{
+		{
+			targets: []float64{1},
+			limits:  []float64{0.5, 0.75, 0.9, 1, 1.1, 1.25, 1.5, 2},
+		},
+		{
+			targets: []float64{0.5, 0.2},
+			limits:  []float64{0.1, 0.15, 0.18, 0.2, 0.22, 0.25, 0.3, 0.375, 0.4, 0.45, 0.5, 0.55, 0.625, 0.75, 1},
+		},
+	}
+
+	for _, test := range tests {
+		limits := SLOBuckets(test.targets...)
+
+		if len(limits) != len(test.limits) {
+			t.Errorf("bad buckets for %v: %v", test.targets, limits)
+			continue
+		}
+
+		for i := range limits {
+			if !nearlyEqual(limits[i], test.limits[i]) {
+				t.Errorf("bad buckets for %v: %v", test.targets, limits)
+				break
+			}
+		}
+
+		for _, target := range test.targets {
+			found := false
+
+			for _, l := range limits {
+				found = found || l == target
+			}
+
+			if !found {
+				t.Errorf("no limit at the target %g: %v", target, limits)
+			}
+		}
+	}
+}
+
+func TestSLOBucketsInvalid(t *testing.T) {
+	for _, target := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic for the target %g", target)
+				}
+			}()
+			SLOBuckets(target)
+		}()
+	}
+
+	if limits := SLOBuckets(); !reflect.DeepEqual(limits, []float64{}) {
+		t.Error("bad buckets without targets:", limits)
+	}
+}
+
+func TestLogLinearBuckets(t *testing.T) {
+	tests := []struct {
+		min    float64
+		max    float64
+		base   float64
+		steps  int
+		limits []float64
+	}{
+		{
+			min: 1, max: 300, base: 10, steps: 9,
+			limits: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 200, 300},
+		},
+		{
+			min: 0.25, max: 4, base: 2, steps: 2,
+			limits: []float64{0.25, 0.375, 0.5, 0.75, 1, 1.5, 2, 3, 4},
+		},
+		{
+			min: 0.03, max: 0.5, base: 10, steps: 2,
+			limits: []float64{0.01, 0.055, 0.1, 0.55},
+		},
+	}
+
+	for _, test := range tests {
+		limits := LogLinearBuckets(test.min, test.max, test.base, test.steps)
+
+		if !reflect.DeepEqual(limits, test.limits) {
+			t.Errorf("bad buckets for [%g, %g] with base %g and %d steps: %v", test.min, test.max, test.base, test.steps, limits)
+		}
+	}
+}
+
+func TestLogLinearBucketsError(t *testing.T) {
+	limits := LogLinearBuckets(0.001, 1000, 10, 18)
+	bound := 9.0 / (2 * 18)
+
+	for i := 1; i < len(limits); i++ {
+		l, u := limits[i-1], limits[i]
+
+		if err := (u - l) / 2 / l; err > bound*(1+1e-9) {
+			t.Errorf("relative error in (%g, %g] exceeds the bound: %g", l, u, err)
+		}
+	}
+}
+
+func TestLogLinearBucketsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		min   float64
+		max   float64
+		base  float64
+		steps int
+	}{
+		{name: "zero min", min: 0, max: 1, base: 10, steps: 9},
+		{name: "empty range", min: 1, max: 1, base: 10, steps: 9},
+		{name: "base of one", min: 1, max: 2, base: 1, steps: 9},
+		{name: "zero steps", min: 1, max: 2, base: 10, steps: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic for invalid arguments")
+				}
+			}()
+			LogLinearBuckets(test.min, test.max, test.base, test.steps)
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BurnRateSuffix is appended to the names of success rates to form the
+	// name of the gauges reporting their burn rates.
+	BurnRateSuffix = ".burn_rate"
+
+	// BurnRateWindowTag is the name of the tag set to the window of the burn
+	// rate gauges.
+	BurnRateWindowTag = "window"
+
+	// DefaultBurnRateTarget is the default service level objective of burn
+	// rate handlers.
+	DefaultBurnRateTarget = 0.999
+)
+
+// DefaultBurnRateWindows is the default list of windows over which burn rate
+// handlers compute burn rates, the short and long windows of the classic
+// multi-window alert on a fast burn of the error budget.
+var DefaultBurnRateWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// The BurnRateConfig type is used to configure burn rate handlers.
+type BurnRateConfig struct {
+	// Name is the name of the success rate that burn rates are computed for,
+	// its counters are named with the SuccessSuffix and TotalSuffix (see
+	// SuccessRate).
+	Name string
+
+	// Target is the service level objective, the ratio of operations which
+	// are expected to succeed, for example 0.999. Defaults to
+	// DefaultBurnRateTarget.
+	Target float64
+
+	// Windows is the list of durations of the rolling windows that burn rates
+	// are computed over, defaults to DefaultBurnRateWindows.
+	Windows []time.Duration
+
+	// PartialWindows enables reporting the burn rates of windows which are
+	// not filled yet, computed over the time elapsed since the series was
+	// first seen. By default the burn rate of a window is only reported once
+	// the series was seen for the whole duration of the window, since ratios
+	// computed from the few operations recorded after a restart are noisy
+	// and would trigger alerts on short windows.
+	PartialWindows bool
+}
+
+type burnRateHandler struct {
+	handler Handler
+	name    string
+	success string
+	total   string
+	budget  float64
+	windows []time.Duration
+	labels  []string
+	partial bool
+	now     func() time.Time
+	mutex   sync.Mutex
+	series  map[string]*burnRateSeries
+}
+
+type burnRateSeries struct {
+	namespace string
+	tags      []Tag
+	since     time.Time // time at which the series was first seen
+	success   float64   // increments since the last flush
+	total     float64   // increments since the last flush
+	samples   []burnRateSample
+}
+
+// burnRateSample carries the increments of the counters of a series between
+// two flushes, the time is the time of the flush ending the interval.
+type burnRateSample struct {
+	time    time.Time
+	success float64
+	total   float64
+}
+
+// NewBurnRateHandler returns a handler which passes the metrics it receives
+// to handler and computes the burn rates of the error budget of a success rate
+// over rolling windows.
+//
+// The burn rate is the ratio of failed operations over a window divided by the
+// error budget (1 - Target): a burn rate of 1 consumes the budget exactly over
+// the period of the objective, a burn rate of 14.4 consumes 2% of a 30 days
+// budget in an hour. Every time the handler is flushed, a gauge named after
+// the success rate with the BurnRateSuffix is reported for each series and
+// window, with a BurnRateWindowTag tag set to the window. Alerting when both
+// a short and a long window burn fast is the multi-window alert recommended
+// for service level objectives.
+//
+// The windows are made of the intervals between flushes, the engine the
+// handler is registered on must be flushed at an interval much shorter than
+// the shortest window. Windows without operations report a burn rate of zero.
+func NewBurnRateHandler(handler Handler, config BurnRateConfig) Handler {
+	if config.Target <= 0 || config.Target >= 1 {
+		config.Target = DefaultBurnRateTarget
+	}
+
+	if len(config.Windows) == 0 {
+		config.Windows = DefaultBurnRateWindows
+	}
+
+	windows := append([]time.Duration(nil), config.Windows...)
+	sort.Slice(windows, func(i int, j int) bool { return windows[i] < windows[j] })
+
+	labels := make([]string, len(windows))
+	for i, w := range windows {
+		labels[i] = formatWindow(w)
+	}
+
+	return &burnRateHandler{
+		handler: handler,
+		name:    config.Name + BurnRateSuffix,
+		success: config.Name + SuccessSuffix,
+		total:   config.Name + TotalSuffix,
+		budget:  1 - config.Target,
+		windows: windows,
+		labels:  labels,
+		partial: config.PartialWindows,
+		now:     time.Now,
+		series:  make(map[string]*burnRateSeries),
+	}
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *burnRateHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *burnRateHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *burnRateHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe records the operations counted by m.
+func (h *burnRateHandler) observe(m *Metric) {
+	if m.Type != CounterType || (m.Name != h.success && m.Name != h.total) {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, h.name, tags)
+	value := m.Value / sampleRate(m.Rate)
+
+	h.mutex.Lock()
+
+	s := h.series[key]
+	if s == nil {
+		s = &burnRateSeries{
+			namespace: m.Namespace,
+			tags:      tags,
+			since:     h.now(),
+		}
+		h.series[key] = s
+	}
+
+	if m.Name == h.success {
+		s.success += value
+	} else {
+		s.total += value
+	}
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+//
+// Series without operations during the longest window are forgotten.
+func (h *burnRateHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *burnRateHandler) FlushContext(ctx context.Context) {
+	now := h.now()
+	longest := h.windows[len(h.windows)-1]
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.series))
+	rates := make([]Metric, 0, len(h.series)*len(h.windows))
+
+	for key, s := range h.series {
+		s.samples = append(s.samples, burnRateSample{time: now, success: s.success, total: s.total})
+		s.success, s.total = 0, 0
+		s.expire(now.Add(-longest))
+
+		if len(s.samples) == 0 {
+			delete(h.series, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+
+		for i, w := range h.windows {
+			if !h.partial && now.Sub(s.since) < w {
+				continue
+			}
+
+			rates = append(rates, Metric{
+				Type:      GaugeType,
+				Namespace: s.namespace,
+				Name:      h.name,
+				Tags:      append(copyTags(s.tags), Tag{BurnRateWindowTag, h.labels[i]}),
+				Value:     s.burnRate(now.Add(-w)) / h.budget,
+				Time:      now,
+			})
+		}
+	}
+
+	h.mutex.Unlock()
+
+	for i := range rates {
+		h.handler.HandleMetric(&rates[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *burnRateHandler) Reset() {
+	h.mutex.Lock()
+	h.series = make(map[string]*burnRateSeries)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// expire removes the samples of intervals which ended before t, and the
+// samples without operations at the beginning of the list.
+func (s *burnRateSeries) expire(t time.Time) {
+	i := 0
+
+	for i != len(s.samples) && (!s.samples[i].time.After(t) || s.samples[i].total == 0) {
+		i++
+	}
+
+	s.samples = s.samples[:copy(s.samples, s.samples[i:])]
+}
+
+// burnRate returns the ratio of failed operations in the intervals which ended
+// after t.
+func (s *burnRateSeries) burnRate(t time.Time) float64 {
+	success, total := 0.0, 0.0
+
+	for i := len(s.samples) - 1; i >= 0 && s.samples[i].time.After(t); i-- {
+		success += s.samples[i].success
+		total += s.samples[i].total
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return (total - success) / total
+}
+
+// sampleRate returns the sample rate of metrics, which is 1 when unset.
+func sampleRate(rate float64) float64 {
+	if rate <= 0 || rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// formatWindow formats w without the zero units that time.Duration.String
+// leaves, "5m" instead of "5m0s" and "1h" instead of "1h0m0s".
+func formatWindow(w time.Duration) string {
+	s := w.String()
+
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBurnRateHandler(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	h := &handler{}
+	b := NewBurnRateHandler(h, BurnRateConfig{
+		Name:    "requests",
+		Target:  0.9,
+		Windows: []time.Duration{2 * time.Minute, time.Minute},
+	})
+	b.(*burnRateHandler).now = func() time.Time { return now }
+
+	e := NewEngine("E")
+	e.Register(b)
+	r := e.SuccessRate("requests")
+
+	burnRates := func() map[string]float64 {
+		rates := map[string]float64{}
+		for _, m := range h.metrics {
+			if m.Name == "requests.burn_rate" {
+				rates[m.Tags[0].Value] = math.Round(m.Value*1e6) / 1e6
+			}
+		}
+		h.Reset()
+		return rates
+	}
+
+	tests := []struct {
+		success int
+		failure int
+		rates   map[string]float64
+	}{
+		{ // cold start, only the 1m window is filled
+			success: 9,
+			failure: 1,
+			rates:   map[string]float64{"1m": 1},
+		},
+		{ // 50% of failures burns the budget 5x, 6 failures out of 20 3x
+			success: 5,
+			failure: 5,
+			rates:   map[string]float64{"1m": 5, "2m": 3},
+		},
+		{ // 5 failures out of 20 in the 2m window
+			success: 10,
+			failure: 0,
+			rates:   map[string]float64{"1m": 0, "2m": 2.5},
+		},
+		{ // the 2m window slides
+			success: 0,
+			failure: 0,
+			rates:   map[string]float64{"1m": 0, "2m": 0},
+		},
+	}
+
+	for i, test := range tests {
+		for j := 0; j != test.success; j++ {
+			r.Record(true)
+		}
+		for j := 0; j != test.failure; j++ {
+			r.Record(false)
+		}
+
+		now = now.Add(time.Minute)
+		e.Flush()
+
+		if rates := burnRates(); !reflect.DeepEqual(rates, test.rates) {
+			t.Errorf("#%d: bad burn rates: %v", i, rates)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	e.Flush()
+
+	if rates := burnRates(); len(rates) != 0 {
+		t.Error("idle series were not forgotten:", rates)
+	}
+}
+
+func TestBurnRateHandlerPartialWindows(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	h := &handler{}
+	b := NewBurnRateHandler(h, BurnRateConfig{Name: "requests", PartialWindows: true})
+	b.(*burnRateHandler).now = func() time.Time { return now }
+
+	e := NewEngine("E")
+	e.Register(b)
+	r := e.SuccessRate("requests", Tag{"path", "/"})
+	r.Record(false)
+
+	now = now.Add(time.Minute)
+	e.Flush()
+
+	gauges := []Metric{}
+	for _, m := range h.metrics {
+		if m.Type == GaugeType {
+			m.Value = math.Round(m.Value)
+			gauges = append(gauges, m)
+		}
+	}
+
+	if !reflect.DeepEqual(gauges, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "requests.burn_rate", Tags: []Tag{{"path", "/"}, {"window", "5m"}}, Value: 1000},
+		{Type: GaugeType, Namespace: "E", Name: "requests.burn_rate", Tags: []Tag{{"path", "/"}, {"window", "1h"}}, Value: 1000},
+	}) {
+		t.Error("bad burn rates:", gauges)
+	}
+}
+
+func TestFormatWindow(t *testing.T) {
+	for w, s := range map[time.Duration]string{
+		30 * time.Second:          "30s",
+		5 * time.Minute:           "5m",
+		90 * time.Minute:          "1h30m",
+		6 * time.Hour:             "6h",
+		6*time.Hour + time.Second: "6h0m1s",
+	} {
+		if f := formatWindow(w); f != s {
+			t.Errorf("%s: bad window format: %s", w, f)
+		}
+	}
+}
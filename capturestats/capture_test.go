@@ -0,0 +1,165 @@
+package capturestats
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type handler struct {
+	metrics []stats.Metric
+	flushed int
+}
+
+func (h *handler) HandleMetric(m *stats.Metric) {
+	c := *m
+	c.Tags = append([]stats.Tag(nil), m.Tags...)
+	h.metrics = append(h.metrics, c)
+}
+
+func (h *handler) Flush() { h.flushed++ }
+
+func TestCaptureReplay(t *testing.T) {
+	now := time.Unix(1500000000, 123)
+	metrics := []stats.Metric{
+		{
+			Type:      stats.CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Tags:      []stats.Tag{{"method", "GET"}, {"status", "200"}},
+			Value:     1,
+			Time:      now,
+		},
+		{
+			Type:      stats.GaugeType,
+			Namespace: "E",
+			Name:      "conns",
+			Value:     -42.5,
+			Time:      now.Add(-time.Second),
+			Unit:      "connections",
+		},
+		{
+			Type:      stats.HistogramType,
+			Namespace: "E",
+			Name:      "latency",
+			Tags:      []stats.Tag{{"method", "GET"}},
+			Value:     math.Inf(1),
+			Rate:      0.25,
+		},
+		{
+			Type:      stats.CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Tags:      []stats.Tag{{"method", "GET"}, {"status", "200"}},
+			Value:     2,
+			Time:      now.Add(time.Minute),
+		},
+	}
+
+	b := &bytes.Buffer{}
+	h := NewHandler(b)
+
+	for i := range metrics {
+		h.HandleMetric(&metrics[i])
+	}
+	h.Flush()
+
+	r := &handler{}
+
+	if err := Replay(bytes.NewReader(b.Bytes()), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.metrics, metrics) {
+		t.Errorf("bad metrics:\n%#v\n%#v", metrics, r.metrics)
+	}
+
+	if r.flushed != 1 {
+		t.Error("the handler was not flushed after replaying the capture")
+	}
+}
+
+func TestCaptureStringTable(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandler(b)
+	m := stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1, Tags: []stats.Tag{{"status", "200"}}}
+
+	h.HandleMetric(&m)
+	h.Flush()
+	n := b.Len()
+
+	h.HandleMetric(&m)
+	h.Flush()
+
+	// length, kind, type, flags, namespace, name, value (3 bytes for 1.0),
+	// tag count, tag name and value
+	if size := b.Len() - n; size != 12 {
+		t.Error("bad size of a metric record referencing known strings:", size)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandler(b)
+	h.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1})
+	h.Flush()
+	capture := b.Bytes()
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{
+			name:  "empty",
+			input: nil,
+			err:   io.EOF,
+		},
+		{
+			name:  "bad magic",
+			input: []byte("STATS v1"),
+			err:   ErrMagic,
+		},
+		{
+			name:  "truncated",
+			input: capture[:len(capture)-1],
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "undefined string",
+			input: []byte(magic + "\x01\x06\x02\x01\x00\x00\x00\x00\x00"),
+		},
+		{
+			name:  "unknown record",
+			input: []byte(magic + "\x01\x02\x7fx"),
+			err:   io.EOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(test.input)).Read()
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if test.err != nil && err != test.err {
+				t.Error("bad error:", err)
+			}
+		})
+	}
+}
+
+func TestReaderVersion(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte(magic + "\x02"))).Read()
+
+	if err == nil || err == ErrMagic {
+		t.Error("expected an error for a newer capture version, got", err)
+	}
+}
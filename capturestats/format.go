@@ -0,0 +1,63 @@
+// Package capturestats implements a compact binary format to capture the
+// metrics produced by a program, and replay them later for offline analysis.
+//
+// A capture starts with a header made of the magic string "STATCAP" followed
+// by a version byte, then contains a sequence of records. Each record is
+// prefixed with its length encoded as an unsigned varint, followed by a byte
+// identifying the kind of the record:
+//
+//   - string records define the next entry of the string table, strings
+//     are numbered from zero in the order they are defined
+//   - metric records carry the type of a metric, the string table indexes
+//     of its namespace, name, and tags, its value, and optional fields
+//     flagged in a bit set: the time (as the varint delta in nanoseconds
+//     from the time of the previous metric), the unit, and the sample rate
+//
+// Floating point values are encoded as unsigned varints of their IEEE 754 bits
+// in reverse byte order, so the values most commonly reported (small integers)
+// only take a few bytes.
+//
+// Readers skip records of unknown kinds, which allows new kinds of records to
+// be added without changing the version. The version is incremented when the
+// encoding of existing records changes, readers reject captures with versions
+// greater than the one they support.
+package capturestats
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// Version is the version of the capture format written by handlers.
+	Version = 1
+
+	magic = "STATCAP"
+)
+
+const (
+	stringRecord byte = iota + 1
+	metricRecord
+)
+
+const (
+	hasTime byte = 1 << iota
+	hasUnit
+	hasRate
+)
+
+func appendFloat(b []byte, f float64) []byte {
+	return appendUvarint(b, bits.ReverseBytes64(math.Float64bits(f)))
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+func appendVarint(b []byte, x int64) []byte {
+	return appendUvarint(b, uint64(x<<1)^uint64(x>>63))
+}
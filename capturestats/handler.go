@@ -0,0 +1,153 @@
+package capturestats
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/segmentio/stats"
+)
+
+// Handler is a metric handler which writes the metrics it receives to an
+// io.Writer in the capture format, see the package documentation.
+//
+// Each distinct string (namespaces, names, tag names and values) is written
+// once and then referenced by its index, so a capture of metrics with a low
+// cardinality is much smaller than a text representation. The string table
+// grows with the number of distinct strings, captures of high cardinality
+// tags use more memory.
+type Handler struct {
+	mutex   sync.Mutex
+	w       *bufio.Writer
+	strings map[string]uint64
+	last    int64 // time of the last metric, in unix nanoseconds
+	header  bool
+	tags    []byte
+	record  []byte
+	err     error
+}
+
+// NewHandler creates and returns a new handler writing metrics to w, the
+// capture header is written on the first metric.
+func NewHandler(w io.Writer) *Handler {
+	return &Handler{
+		w:       bufio.NewWriter(w),
+		strings: make(map[string]uint64),
+	}
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (h *Handler) HandleMetric(m *stats.Metric) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.err != nil {
+		return
+	}
+
+	if !h.header {
+		h.check(writeAll(h.w, append([]byte(magic), Version)))
+		h.header = true
+	}
+
+	namespace := h.intern(m.Namespace)
+	name := h.intern(m.Name)
+	unit := uint64(0)
+	flags := byte(0)
+
+	if !m.Time.IsZero() {
+		flags |= hasTime
+	}
+
+	if len(m.Unit) != 0 {
+		flags |= hasUnit
+		unit = h.intern(m.Unit)
+	}
+
+	if m.Rate != 0 {
+		flags |= hasRate
+	}
+
+	// Tags are encoded first because interning their names and values may
+	// write string records, which must precede the metric record.
+	tags := h.tags[:0]
+	for _, t := range m.Tags {
+		tags = appendUvarint(tags, h.intern(t.Name))
+		tags = appendUvarint(tags, h.intern(t.Value))
+	}
+
+	r := h.record[:0]
+	r = append(r, metricRecord, byte(m.Type), flags)
+	r = appendUvarint(r, namespace)
+	r = appendUvarint(r, name)
+	r = appendFloat(r, m.Value)
+
+	if flags&hasTime != 0 {
+		t := m.Time.UnixNano()
+		r = appendVarint(r, t-h.last)
+		h.last = t
+	}
+
+	if flags&hasUnit != 0 {
+		r = appendUvarint(r, unit)
+	}
+
+	if flags&hasRate != 0 {
+		r = appendFloat(r, m.Rate)
+	}
+
+	r = appendUvarint(r, uint64(len(m.Tags)))
+	r = append(r, tags...)
+
+	h.write(r)
+	h.tags = tags
+	h.record = r
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (h *Handler) Flush() {
+	h.mutex.Lock()
+
+	if h.err == nil {
+		h.check(h.w.Flush())
+	}
+
+	h.mutex.Unlock()
+}
+
+// intern returns the index of s in the string table, writing a string record
+// if it was not defined yet. The method must be called with the mutex held.
+func (h *Handler) intern(s string) uint64 {
+	if i, ok := h.strings[s]; ok {
+		return i
+	}
+
+	i := uint64(len(h.strings))
+	h.strings[s] = i
+
+	b := make([]byte, 0, 1+len(s))
+	b = append(b, stringRecord)
+	b = append(b, s...)
+	h.write(b)
+	return i
+}
+
+// write writes a record prefixed with its length.
+func (h *Handler) write(r []byte) {
+	var n [10]byte
+	h.check(writeAll(h.w, appendUvarint(n[:0], uint64(len(r)))))
+	h.check(writeAll(h.w, r))
+}
+
+func (h *Handler) check(err error) {
+	if err != nil && h.err == nil {
+		h.err = err
+		log.Printf("stats/capturestats: discarding metrics after a write error: %s", err)
+	}
+}
+
+func writeAll(w io.Writer, b []byte) error {
+	_, err := w.Write(b)
+	return err
+}
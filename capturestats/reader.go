@@ -0,0 +1,214 @@
+package capturestats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+var (
+	// ErrMagic is returned by readers when the input does not start with the
+	// capture header.
+	ErrMagic = errors.New("stats/capturestats: not a metrics capture")
+
+	errTruncated = errors.New("stats/capturestats: truncated record")
+)
+
+// Reader decodes metrics from a capture written by a Handler.
+type Reader struct {
+	r       *bufio.Reader
+	header  bool
+	strings []string
+	last    int64
+	record  []byte
+	tags    []stats.Tag
+}
+
+// NewReader returns a new reader decoding metrics from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next metric of the capture, or io.EOF when the end of the
+// capture was reached.
+//
+// The tags of the returned metric are only valid until the next call to Read.
+func (r *Reader) Read() (m stats.Metric, err error) {
+	if !r.header {
+		if err = r.readHeader(); err != nil {
+			return
+		}
+		r.header = true
+	}
+
+	for {
+		var n uint64
+
+		if n, err = binary.ReadUvarint(r.r); err != nil {
+			return
+		}
+
+		if uint64(cap(r.record)) < n {
+			r.record = make([]byte, n)
+		}
+		b := r.record[:n]
+
+		if _, err = io.ReadFull(r.r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+
+		if len(b) == 0 {
+			continue
+		}
+
+		switch b[0] {
+		case stringRecord:
+			r.strings = append(r.strings, string(b[1:]))
+		case metricRecord:
+			return r.readMetric(b[1:])
+		}
+	}
+}
+
+func (r *Reader) readHeader() error {
+	var h [len(magic) + 1]byte
+
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrMagic
+		}
+		return err
+	}
+
+	if string(h[:len(magic)]) != magic {
+		return ErrMagic
+	}
+
+	if v := h[len(magic)]; v > Version {
+		return fmt.Errorf("stats/capturestats: unsupported capture version: %d", v)
+	}
+
+	return nil
+}
+
+func (r *Reader) readMetric(b []byte) (m stats.Metric, err error) {
+	d := decoder{b: b, strings: r.strings}
+
+	if len(d.b) < 2 {
+		err = errTruncated
+		return
+	}
+
+	m.Type = stats.MetricType(d.b[0])
+	flags := d.b[1]
+	d.b = d.b[2:]
+
+	m.Namespace = d.string()
+	m.Name = d.string()
+	m.Value = d.float()
+
+	if flags&hasTime != 0 {
+		r.last += d.varint()
+		m.Time = time.Unix(0, r.last)
+	}
+
+	if flags&hasUnit != 0 {
+		m.Unit = d.string()
+	}
+
+	if flags&hasRate != 0 {
+		m.Rate = d.float()
+	}
+
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		err = errTruncated
+		return
+	}
+
+	r.tags = r.tags[:0]
+	for i := uint64(0); i != n; i++ {
+		r.tags = append(r.tags, stats.Tag{Name: d.string(), Value: d.string()})
+	}
+
+	if len(r.tags) != 0 {
+		m.Tags = r.tags
+	}
+
+	err = d.err
+	return
+}
+
+// Replay reads metrics from the capture in r and passes them to handler, then
+// flushes the handler if it implements stats.Flusher.
+func Replay(r io.Reader, handler stats.Handler) error {
+	c := NewReader(r)
+
+	for {
+		m, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		handler.HandleMetric(&m)
+	}
+
+	if f, ok := handler.(stats.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+type decoder struct {
+	b       []byte
+	strings []string
+	err     error
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.b = d.b[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x := d.uvarint()
+	return int64(x>>1) ^ -int64(x&1)
+}
+
+func (d *decoder) float() float64 {
+	return math.Float64frombits(bits.ReverseBytes64(d.uvarint()))
+}
+
+func (d *decoder) string() string {
+	i := d.uvarint()
+	if i >= uint64(len(d.strings)) {
+		d.fail(fmt.Errorf("stats/capturestats: reference to undefined string: %d", i))
+		return ""
+	}
+	return d.strings[i]
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.b = nil
+}
@@ -1,13 +1,24 @@
 package stats
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // The Clock type can be used to report statistics on durations.
 //
 // Clocks are useful to measure the duration taken by sequential execution steps
 // and therefore aren't safe to be used concurrently by multiple goroutines.
+//
+// Durations are computed from the monotonic clock readings carried by times
+// returned by time.Now, so they are not affected by adjustments of the wall
+// clock. The readings are lost when times are transformed with methods like
+// Round, Truncate, UTC, or In, or when they are constructed with time.Unix or
+// time.Date, in which case durations fall back to wall clock differences and
+// the negative durations that may result are reported as zero.
 type Clock struct {
 	metric Histogram
+	start  time.Time
 	last   time.Time
 }
 
@@ -30,6 +41,7 @@ func (c *Clock) Tags() []Tag {
 func (c *Clock) WithTags(tags ...Tag) *Clock {
 	return &Clock{
 		metric: *c.metric.WithTags(tags...),
+		start:  c.start,
 		last:   c.last,
 	}
 }
@@ -68,9 +80,105 @@ func (c *Clock) StopAt(now time.Time) {
 	c.observe("total", now)
 }
 
+// StopContext is like Stop but also reports whether the operation completed
+// within the deadline of ctx.
+//
+// If ctx has a deadline, the metric produced by this method call will have a
+// "timed_out" tag set to "true" or "false", and the budget that remained
+// before the deadline is reported in seconds on a histogram named after the
+// clock with a ".budget.remaining" suffix (zero if the deadline was exceeded).
+// If ctx has no deadline the method behaves like Stop.
+func (c *Clock) StopContext(ctx context.Context) {
+	c.StopContextAt(ctx, time.Now())
+}
+
+// StopContextAt is like StopAt but also reports whether the operation
+// completed within the deadline of ctx, see StopContext for details.
+func (c *Clock) StopContextAt(ctx context.Context, now time.Time) {
+	deadline, ok := ctx.Deadline()
+
+	if !ok {
+		c.StopAt(now)
+		return
+	}
+
+	remain := deadline.Sub(now)
+	timedOut := "false"
+
+	// The outcome is derived from now rather than from ctx.Err, so operations
+	// stopped at a time other than the current time are reported correctly.
+	if remain <= 0 {
+		remain, timedOut = 0, "true"
+	}
+
+	h := c.metric
+	h.tags = append(h.tags, Tag{"stamp", "total"}, Tag{"timed_out", timedOut})
+	h.Observe(elapsed(c.last, now).Seconds())
+	c.last = now
+
+	h.name += ".budget.remaining"
+	h.tags = h.tags[:len(h.tags)-2]
+	h.Observe(remain.Seconds())
+}
+
+// StopDeadline is like StopContext but reports how close the operation came to
+// the deadline of ctx relative to its budget, which is the time that remained
+// before the deadline when the clock was started.
+//
+// If ctx has a deadline, the metric produced by this method call will have a
+// "timed_out" tag set to "true" or "false", and the ratio of the duration of
+// the operation to its budget is reported on a histogram named after the clock
+// with a ".budget.ratio" suffix. Ratios close to 1 reveal operations at risk of
+// timing out, and ratios greater than 1 operations which exceeded their
+// deadline. The ratio is not reported if the deadline had already expired
+// when the clock was started. If ctx has no deadline the method behaves like
+// Stop.
+func (c *Clock) StopDeadline(ctx context.Context) {
+	c.StopDeadlineAt(ctx, time.Now())
+}
+
+// StopDeadlineAt is like StopAt but also reports how close the operation came
+// to the deadline of ctx, see StopDeadline for details.
+func (c *Clock) StopDeadlineAt(ctx context.Context, now time.Time) {
+	deadline, ok := ctx.Deadline()
+
+	if !ok {
+		c.StopAt(now)
+		return
+	}
+
+	timedOut := "false"
+
+	if !now.Before(deadline) {
+		timedOut = "true"
+	}
+
+	h := c.metric
+	h.tags = append(h.tags, Tag{"stamp", "total"}, Tag{"timed_out", timedOut})
+	h.Observe(elapsed(c.last, now).Seconds())
+	c.last = now
+
+	if budget := deadline.Sub(c.start); budget > 0 {
+		h.name += ".budget.ratio"
+		h.tags = h.tags[:len(h.tags)-2]
+		h.Observe(float64(elapsed(c.start, now)) / float64(budget))
+	}
+}
+
 func (c *Clock) observe(stamp string, now time.Time) {
 	h := c.metric
 	h.tags = append(h.tags, Tag{"stamp", stamp})
-	h.Observe(now.Sub(c.last).Seconds())
+	h.Observe(elapsed(c.last, now).Seconds())
 	c.last = now
 }
+
+// elapsed returns the duration between start and end, using the monotonic
+// clock readings of the times when both have one. Negative durations, which
+// can only be observed on times without monotonic clock readings when the wall
+// clock was moved backward, are returned as zero.
+func elapsed(start time.Time, end time.Time) time.Duration {
+	if d := end.Sub(start); d > 0 {
+		return d
+	}
+	return 0
+}
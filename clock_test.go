@@ -1,8 +1,10 @@
 package stats
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestClockStart(t *testing.T) {
@@ -68,3 +70,233 @@ func TestClockWithTags(t *testing.T) {
 		t.Error("bad clock tags:", tags)
 	}
 }
+
+func TestClockStopContext(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		deadline time.Time
+		timedOut string
+		remain   float64
+	}{
+		{
+			deadline: now.Add(2 * time.Second),
+			timedOut: "false",
+			remain:   1,
+		},
+		{
+			deadline: now.Add(500 * time.Millisecond),
+			timedOut: "true",
+			remain:   0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.timedOut, func(t *testing.T) {
+			h := &handler{}
+			e := NewEngine("E")
+			e.Register(h)
+
+			ctx, cancel := context.WithDeadline(context.Background(), test.deadline)
+			defer cancel()
+
+			c := e.Timer("A", Tag{"base", "tag"}).StartAt(now)
+			c.StopContextAt(ctx, now.Add(1*time.Second))
+
+			if !reflect.DeepEqual(h.metrics, []Metric{
+				{
+					Type:      HistogramType,
+					Namespace: "E",
+					Name:      "A",
+					Value:     1,
+					Tags:      []Tag{{"base", "tag"}, {"stamp", "total"}, {"timed_out", test.timedOut}},
+				},
+				{
+					Type:      HistogramType,
+					Namespace: "E",
+					Name:      "A.budget.remaining",
+					Value:     test.remain,
+					Tags:      []Tag{{"base", "tag"}},
+				},
+			}) {
+				t.Error("bad metrics:", h.metrics)
+			}
+		})
+	}
+}
+
+func TestClockStopContextFixedTime(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	// The deadline has passed on the wall clock, but not at the time the
+	// clock is stopped at.
+	now := time.Unix(1500000000, 0)
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(2*time.Second))
+	defer cancel()
+
+	e.Timer("A").StartAt(now).StopContextAt(ctx, now.Add(1*time.Second))
+
+	if len(h.metrics) != 2 || h.metrics[0].Tags[1] != (Tag{"timed_out", "false"}) || h.metrics[1].Value != 1 {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestClockStopDeadline(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		deadline time.Time
+		timedOut string
+		ratio    float64
+	}{
+		{
+			deadline: now.Add(4 * time.Second),
+			timedOut: "false",
+			ratio:    0.25,
+		},
+		{
+			deadline: now.Add(500 * time.Millisecond),
+			timedOut: "true",
+			ratio:    2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.timedOut, func(t *testing.T) {
+			h := &handler{}
+			e := NewEngine("E")
+			e.Register(h)
+
+			ctx, cancel := context.WithDeadline(context.Background(), test.deadline)
+			defer cancel()
+
+			c := e.Timer("A", Tag{"base", "tag"}).StartAt(now)
+			c.StampAt("connect", now.Add(500*time.Millisecond))
+			c.StopDeadlineAt(ctx, now.Add(1*time.Second))
+
+			if !reflect.DeepEqual(h.metrics[1:], []Metric{
+				{
+					Type:      HistogramType,
+					Namespace: "E",
+					Name:      "A",
+					Value:     0.5,
+					Tags:      []Tag{{"base", "tag"}, {"stamp", "total"}, {"timed_out", test.timedOut}},
+				},
+				{
+					Type:      HistogramType,
+					Namespace: "E",
+					Name:      "A.budget.ratio",
+					Value:     test.ratio,
+					Tags:      []Tag{{"base", "tag"}},
+				},
+			}) {
+				t.Error("bad metrics:", h.metrics)
+			}
+		})
+	}
+}
+
+func TestClockStopDeadlineExpired(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	now := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(-time.Second))
+	defer cancel()
+
+	e.Timer("A").StartAt(now).StopDeadlineAt(ctx, now.Add(time.Second))
+
+	if len(h.metrics) != 1 || h.metrics[0].Tags[1] != (Tag{"timed_out", "true"}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestClockStopDeadlineFixedTime(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	// The deadline has passed on the wall clock, but not at the time the
+	// clock is stopped at.
+	now := time.Unix(1500000000, 0)
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(2*time.Second))
+	defer cancel()
+
+	e.Timer("A").StartAt(now).StopDeadlineAt(ctx, now.Add(1*time.Second))
+
+	if len(h.metrics) != 2 || h.metrics[0].Tags[1] != (Tag{"timed_out", "false"}) || h.metrics[1].Value != 0.5 {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestClockStopContextNoDeadline(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	now := time.Now()
+	c := e.Timer("A").StartAt(now)
+	c.StopContextAt(context.Background(), now.Add(1*time.Second))
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     1,
+			Tags:      []Tag{{"stamp", "total"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestClockNegativeDuration(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	// Round(0) strips the monotonic clock reading, so the duration is computed
+	// from wall clock times, as if the wall clock was moved backward.
+	now := time.Now()
+	c := e.Timer("A").StartAt(now)
+	c.StopAt(now.Round(0).Add(-time.Second))
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     0,
+			Tags:      []Tag{{"stamp", "total"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestElapsed(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		want  time.Duration
+	}{
+		{name: "monotonic", start: now, end: now.Add(time.Second), want: time.Second},
+		{name: "wall", start: now.Round(0), end: now.Round(0).Add(time.Second), want: time.Second},
+		{name: "negative", start: now, end: now.Round(0).Add(-time.Second), want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if d := elapsed(test.start, test.end); d != test.want {
+				t.Error("bad duration:", d)
+			}
+		})
+	}
+}
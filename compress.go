@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Compressor is the interface implemented by the compression codecs applied to
+// the bodies of requests sent by the HTTP-based clients of the subpackages.
+//
+// The interface is small enough to be implemented on top of most compression
+// libraries, for example with github.com/klauspost/compress/zstd:
+//
+//	type zstdCompressor struct{}
+//
+//	func (zstdCompressor) Encoding() string { return "zstd" }
+//
+//	func (zstdCompressor) NewWriter(w io.Writer) stats.CompressWriter {
+//		z, _ := zstd.NewWriter(w)
+//		return z
+//	}
+type Compressor interface {
+	// Encoding returns the value of the Content-Encoding header of the bodies
+	// compressed by the compressor, like "gzip" or "zstd".
+	Encoding() string
+
+	// NewWriter returns a writer which compresses what is written to it and
+	// writes the result to w.
+	NewWriter(w io.Writer) CompressWriter
+}
+
+// CompressWriter is the interface of the writers returned by compressors.
+//
+// Writers are reused across requests: Reset is called to compress a new body,
+// and Close must flush the compressed data to the underlying writer without
+// preventing the writer from being reset.
+type CompressWriter interface {
+	io.WriteCloser
+
+	// Reset discards the state of the writer and makes it write to w.
+	Reset(w io.Writer)
+}
+
+// GzipCompressor is a compressor using gzip with the default compression level.
+var GzipCompressor Compressor = gzipCompressor{level: gzip.DefaultCompression}
+
+// NewGzipCompressor returns a compressor using gzip with the given compression
+// level, see the constants of the compress/gzip package.
+func NewGzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (c gzipCompressor) NewWriter(w io.Writer) CompressWriter {
+	z, err := gzip.NewWriterLevel(w, c.level)
+	if err != nil {
+		z = gzip.NewWriter(w)
+	}
+	return z
+}
+
+// CompressorPool is a pool of the writers of a compressor, it is used by
+// clients to compress request bodies without allocating new writers, which
+// are often large, on every flush. Pools are safe to use concurrently.
+type CompressorPool struct {
+	compressor Compressor
+	writers    sync.Pool
+}
+
+// NewCompressorPool returns a pool of the writers of compressor.
+func NewCompressorPool(compressor Compressor) *CompressorPool {
+	return &CompressorPool{compressor: compressor}
+}
+
+// Encoding returns the content encoding of the compressor of the pool.
+func (p *CompressorPool) Encoding() string {
+	return p.compressor.Encoding()
+}
+
+// Compress appends the compressed form of b to dst.
+func (p *CompressorPool) Compress(dst *bytes.Buffer, b []byte) error {
+	w, _ := p.writers.Get().(CompressWriter)
+
+	if w == nil {
+		w = p.compressor.NewWriter(dst)
+	} else {
+		w.Reset(dst)
+	}
+
+	_, err := w.Write(b)
+
+	if e := w.Close(); err == nil {
+		err = e
+	}
+
+	w.Reset(ioutil.Discard)
+	p.writers.Put(w)
+	return err
+}
@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+type flateCompressor struct{}
+
+func (flateCompressor) Encoding() string { return "deflate" }
+
+func (flateCompressor) NewWriter(w io.Writer) CompressWriter {
+	z, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return z
+}
+
+func TestCompressorPool(t *testing.T) {
+	tests := []struct {
+		compressor Compressor
+		reader     func(io.Reader) (io.Reader, error)
+	}{
+		{
+			compressor: GzipCompressor,
+			reader:     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			compressor: NewGzipCompressor(gzip.BestSpeed),
+			reader:     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			compressor: flateCompressor{},
+			reader:     func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil },
+		},
+	}
+
+	for _, test := range tests {
+		pool := NewCompressorPool(test.compressor)
+
+		t.Run(pool.Encoding(), func(t *testing.T) {
+			var wg sync.WaitGroup
+
+			for i := 0; i != 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					for j := 0; j != 10; j++ {
+						b := []byte(fmt.Sprintf("request %d-%d", i, j))
+						z := &bytes.Buffer{}
+
+						if err := pool.Compress(z, b); err != nil {
+							t.Error(err)
+							return
+						}
+
+						r, err := test.reader(z)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+
+						if d, _ := ioutil.ReadAll(r); !bytes.Equal(d, b) {
+							t.Errorf("bad decompressed body: %q", d)
+						}
+					}
+				}(i)
+			}
+
+			wg.Wait()
+		})
+	}
+}
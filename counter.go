@@ -42,6 +42,29 @@ func (c *Counter) WithTags(tags ...Tag) *Counter {
 	}
 }
 
+// WithoutEngineTags returns a copy of the counter which doesn't inherit the
+// tags of the engine it was created on, only the tags set on the counter are
+// reported.
+//
+// The internal value of the returned counter is set to zero.
+func (c *Counter) WithoutEngineTags() *Counter {
+	return &Counter{
+		eng:  c.eng.withoutTags(),
+		name: c.name,
+		tags: c.tags,
+	}
+}
+
+// WithHelp sets the help text of the counter's metric, which handlers like the
+// prometheus handler expose, and returns the counter so the call can be
+// chained with its creation:
+//
+//	requests := stats.C("requests").WithHelp("Number of requests served.")
+func (c *Counter) WithHelp(help string) *Counter {
+	c.eng.describeHelp(CounterType, c.name, help)
+	return c
+}
+
 // Incr increments the counter by a value of 1.
 func (c *Counter) Incr() {
 	c.Add(1)
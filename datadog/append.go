@@ -28,6 +28,11 @@ func appendMetric(b []byte, m Metric) []byte {
 		b = appendTags(b, m.Tags)
 	}
 
+	if len(m.ContainerID) != 0 {
+		b = append(b, "|c:"...)
+		b = append(b, m.ContainerID...)
+	}
+
 	return append(b, '\n')
 }
 
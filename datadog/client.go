@@ -1,8 +1,10 @@
 package datadog
 
 import (
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/segmentio/stats"
@@ -18,25 +20,109 @@ const (
 	// DefaultBufferSize is the default size of the client buffer.
 	DefaultBufferSize = 1024
 
+	// DefaultUnixBufferSize is the default size of the client buffer over unix
+	// domain sockets, which matches the default buffer size of the agent.
+	DefaultUnixBufferSize = 8192
+
+	// DefaultWriteBufferSize is the default size requested for the kernel send
+	// buffer of the client sockets.
+	DefaultWriteBufferSize = 1024 * 1024
+
 	// DefaultFlushInterval is the default interval at which clients flush
 	// metrics from their stats engine.
 	DefaultFlushInterval = 1 * time.Second
+
+	// unixPrefix is the prefix of the addresses of unix domain sockets.
+	unixPrefix = "unix://"
 )
 
 // The ClientConfig type is used to configure datadog clients.
 type ClientConfig struct {
-	// Address of the dogstatsd agent to send metrics to.
+	// Address of the dogstatsd agent to send metrics to, either a host:port
+	// pair to send UDP datagrams to, or the path of a unix datagram socket
+	// prefixed with "unix://", for example "unix:///var/run/datadog/dsd.socket".
 	Address string
 
+	// Addresses is a list of additional dogstatsd agents to send metrics to,
+	// which is useful in redundant setups where the restart of an agent must
+	// not lose data. How metrics are distributed between the agents is
+	// configured by Mode. A failure to send to one of the agents does not
+	// prevent sending to the others.
+	Addresses []string
+
+	// Mode configures how metrics are sent when multiple agents are
+	// configured, defaults to Duplicate.
+	Mode Mode
+
 	// BufferSize is the size of the output buffer used by the client.
 	BufferSize int
+
+	// WriteBufferSize is the size requested for the kernel send buffer of the
+	// client socket, defaults to DefaultWriteBufferSize.
+	WriteBufferSize int
+
+	// MaxNameLength is the maximum length of metric names (namespace
+	// included), defaults to DefaultMaxNameLength. Datadog truncates longer
+	// names, which silently merges metrics sharing a long prefix.
+	MaxNameLength int
+
+	// LongNames configures how names longer than MaxNameLength are handled,
+	// defaults to NameTruncate.
+	LongNames NamePolicy
+
+	// Serializer is used to format the metrics sent by the client, defaults
+	// to DogStatsD. Custom serializers can be used to produce the variations
+	// of the format expected by some relays.
+	Serializer Serializer
+
+	// Distributions sends histograms as distribution metrics, which datadog
+	// aggregates globally instead of on each agent, so percentiles cover all
+	// the hosts reporting a metric.
+	Distributions bool
+
+	// OriginDetection enables sending the id of the container of the program
+	// with metrics, events, and service checks, which the agent uses to tag
+	// them with the tags of the container. The id is read from the cgroups of
+	// the process unless ContainerID is set, and the value of the DD_ENTITY_ID
+	// environment variable is sent as the EntityIDTag tag. Over unix domain
+	// sockets the agent can also detect the origin from the credentials of
+	// the client process.
+	OriginDetection bool
+
+	// ContainerID is the container id sent with OriginDetection, it is read
+	// from the cgroups of the process when empty.
+	ContainerID string
 }
 
+// Mode is an enumeration of the ways that clients distribute metrics between
+// multiple dogstatsd agents.
+type Mode int
+
+const (
+	// Duplicate sends every metric to all agents, deduplication is expected
+	// to happen downstream.
+	Duplicate Mode = iota
+
+	// RoundRobin sends each metric to a single agent, cycling through them.
+	RoundRobin
+)
+
 // Client represents a datadog client that pulls metrics from a stats engine and
 // forward them to a dogstatsd agent.
 type Client struct {
-	conn *Conn
-	once sync.Once
+	dropped       int64 // first for alignment of atomic operations
+	conns         []*Conn
+	mode          Mode
+	next          uint32
+	once          sync.Once
+	maxName       int
+	policy        NamePolicy
+	serializer    Serializer
+	distributions bool
+	containerID   string      // sent with origin detection
+	tags          []stats.Tag // entity tags sent with origin detection
+	mutex         sync.Mutex
+	rejected      map[string]struct{}
 }
 
 // NewClient creates and returns a new datadog client publishing metrics to the
@@ -49,27 +135,69 @@ func NewClient(addr string) *Client {
 
 // NewClientWith creates and returns a new datadog client configured with config.
 func NewClientWith(config ClientConfig) *Client {
-	conn, err := DialConfig(ConnConfig{
-		Address:    config.Address,
-		BufferSize: config.BufferSize,
-	})
+	if config.MaxNameLength < 0 {
+		log.Printf("stats/datadog: ignoring negative maximum name length: %d", config.MaxNameLength)
+		config.MaxNameLength = 0
+	}
+
+	if config.MaxNameLength == 0 {
+		config.MaxNameLength = DefaultMaxNameLength
+	}
+
+	if config.Serializer == nil {
+		config.Serializer = DogStatsD
+	}
+
+	addrs := config.Addresses
+
+	if len(config.Address) != 0 || len(addrs) == 0 {
+		addrs = append([]string{config.Address}, addrs...)
+	}
+
+	conns := make([]*Conn, 0, len(addrs))
+
+	for _, addr := range addrs {
+		conn, err := DialConfig(ConnConfig{
+			Address:         addr,
+			BufferSize:      config.BufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+		})
+
+		if err != nil {
+			log.Printf("stats/datadog: opening a connection to %s failed: %s", addr, err)
+			continue
+		}
+
+		log.Printf("stats/datadog: connection opened to %s with a buffer size of %d B and a socket send buffer of %d B", addr, cap(conn.b), conn.WriteBufferSize())
+		conns = append(conns, conn)
+	}
 
-	if err != nil {
-		log.Printf("stats/datadog: opening a connection to %s failed: %s", config.Address, err)
-	} else {
-		log.Printf("stats/datadog: connection opened to %s with a buffer size of %d B", config.Address, cap(conn.b))
+	c := &Client{
+		conns:         conns,
+		mode:          config.Mode,
+		maxName:       config.MaxNameLength,
+		policy:        config.LongNames,
+		serializer:    config.Serializer,
+		distributions: config.Distributions,
 	}
 
-	return &Client{
-		conn: conn,
+	if config.OriginDetection {
+		if c.containerID = config.ContainerID; len(c.containerID) == 0 {
+			c.containerID = readContainerID(cgroupPath)
+		}
+		c.tags = entityTags()
 	}
+
+	return c
 }
 
 // Close satisfies the io.Closer interface.
 func (c *Client) Close() (err error) {
 	c.once.Do(func() {
-		if c.conn != nil {
-			err = c.conn.Close()
+		for _, conn := range c.conns {
+			if e := conn.Close(); e != nil && err == nil {
+				err = e
+			}
 		}
 	})
 	return
@@ -77,27 +205,166 @@ func (c *Client) Close() (err error) {
 
 // Flsuh satisfies the stats.Flusher interface.
 func (c *Client) Flush() {
-	if c.conn != nil {
-		if err := c.conn.Flush(); err != nil {
-			log.Printf("stats/datadog: sending metrics to %s failed: %s", c.conn.RemoteAddr(), err)
+	for _, conn := range c.conns {
+		if err := conn.Flush(); err != nil {
+			log.Printf("stats/datadog: sending metrics to %s failed: %s", conn.RemoteAddr(), err)
 		}
 	}
 }
 
 // HandleMetric satisfies the stats.Handler interface.
 func (c *Client) HandleMetric(m *stats.Metric) {
-	if c.conn != nil {
+	if len(c.conns) != 0 {
+		namespace, name, ok := c.name(m)
+		if !ok {
+			atomic.AddInt64(&c.dropped, 1)
+			return
+		}
+
+		typ := metricType(m)
+		if typ == Histogram && c.distributions {
+			typ = Distribution
+		}
+
+		tags := m.Tags
+		if len(c.tags) != 0 {
+			tags = append(tags[:len(tags):len(tags)], c.tags...)
+		}
+
 		buf := bufferPool.Get().(*buffer)
-		buf.b = appendMetric(buf.b[:0], Metric{
-			Type:      metricType(m),
-			Namespace: m.Namespace,
-			Name:      m.Name,
-			Value:     m.Value,
-			Tags:      m.Tags,
+		buf.b = c.serializer.AppendMetric(buf.b[:0], Metric{
+			Type:        typ,
+			Namespace:   namespace,
+			Name:        name,
+			Value:       m.Value,
+			Rate:        m.Rate,
+			Tags:        tags,
+			ContainerID: c.containerID,
 		})
-		if _, err := c.conn.Write(buf.b); err != nil {
-			log.Printf("stats/datadog: sending metric %s to %s failed: %s", m.Name, c.conn.RemoteAddr(), err)
+
+		if c.mode == RoundRobin {
+			c.write(c.conns[(atomic.AddUint32(&c.next, 1)-1)%uint32(len(c.conns))], m, buf.b)
+		} else {
+			for _, conn := range c.conns {
+				c.write(conn, m, buf.b)
+			}
 		}
+
 		bufferPool.Put(buf)
 	}
 }
+
+// SendEvent sends e to the agents, which submit it to the datadog event stream.
+// Events are buffered like metrics and sent when the client is flushed.
+func (c *Client) SendEvent(e Event) error {
+	if len(c.tags) != 0 {
+		e.Tags = append(e.Tags[:len(e.Tags):len(e.Tags)], c.tags...)
+	}
+
+	buf := bufferPool.Get().(*buffer)
+	buf.b = appendEvent(buf.b[:0], e, c.containerID)
+	err := c.send(buf.b)
+	bufferPool.Put(buf)
+	return err
+}
+
+// SendServiceCheck sends s to the agents, which submit it to datadog. Service
+// checks are buffered like metrics and sent when the client is flushed.
+func (c *Client) SendServiceCheck(s ServiceCheck) error {
+	if len(c.tags) != 0 {
+		s.Tags = append(s.Tags[:len(s.Tags):len(s.Tags)], c.tags...)
+	}
+
+	buf := bufferPool.Get().(*buffer)
+	buf.b = appendServiceCheck(buf.b[:0], s, c.containerID)
+	err := c.send(buf.b)
+	bufferPool.Put(buf)
+	return err
+}
+
+// send writes b to the agents according to the mode of the client, it returns
+// the first error that occurred.
+func (c *Client) send(b []byte) (err error) {
+	if len(c.conns) == 0 {
+		return fmt.Errorf("stats/datadog: no connection to an agent could be opened")
+	}
+
+	if c.mode == RoundRobin {
+		_, err = c.conns[(atomic.AddUint32(&c.next, 1)-1)%uint32(len(c.conns))].Write(b)
+		return
+	}
+
+	for _, conn := range c.conns {
+		if _, e := conn.Write(b); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
+
+func (c *Client) write(conn *Conn, m *stats.Metric, b []byte) {
+	if _, err := conn.Write(b); err != nil {
+		atomic.AddInt64(&c.dropped, 1)
+		log.Printf("stats/datadog: sending metric %s to %s failed: %s", m.Name, conn.RemoteAddr(), err)
+	}
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// metrics that were rejected or failed to be sent to an agent.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// name returns the namespace and name to send for m, applying the policy of
+// the client if the name is too long. The method returns false if the metric
+// must be discarded.
+func (c *Client) name(m *stats.Metric) (namespace string, name string, ok bool) {
+	namespace, name, ok = m.Namespace, m.Name, true
+	length := len(name)
+
+	if len(namespace) != 0 {
+		length += len(namespace) + 1
+	}
+
+	if length <= c.maxName {
+		return
+	}
+
+	if len(namespace) != 0 {
+		name = namespace + "." + name
+	}
+
+	if c.policy == NameReject {
+		c.reject(name)
+		return "", "", false
+	}
+
+	return "", truncateName(name, c.maxName), true
+}
+
+func (c *Client) reject(name string) {
+	c.mutex.Lock()
+	_, logged := c.rejected[name]
+	skip := logged || len(c.rejected) >= maxLoggedNames
+
+	if !skip {
+		if c.rejected == nil {
+			c.rejected = make(map[string]struct{})
+		}
+		c.rejected[name] = struct{}{}
+	}
+
+	last := !skip && len(c.rejected) == maxLoggedNames
+	c.mutex.Unlock()
+
+	if skip {
+		return
+	}
+
+	log.Printf("stats/datadog: discarding metric %s because its name is longer than %d bytes", name, c.maxName)
+
+	if last {
+		log.Printf("stats/datadog: logged %d metrics discarded because of their name length, the following ones will not be logged", maxLoggedNames)
+	}
+}
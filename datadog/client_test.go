@@ -1,8 +1,13 @@
 package datadog
 
 import (
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/segmentio/stats"
 )
@@ -35,3 +40,114 @@ func BenchmarkClient(b *testing.B) {
 		engine.Flush()
 	})
 }
+
+func TestClientMultipleAddresses(t *testing.T) {
+	tests := []struct {
+		mode   Mode
+		counts [2]int
+	}{
+		{mode: Duplicate, counts: [2]int{4, 4}},
+		{mode: RoundRobin, counts: [2]int{2, 2}},
+	}
+
+	for _, test := range tests {
+		var mutex sync.Mutex
+		var counts [2]int
+
+		addr1, closer1 := startTestServer(t, HandlerFunc(func(m Metric, a net.Addr) {
+			mutex.Lock()
+			counts[0]++
+			mutex.Unlock()
+		}))
+		defer closer1.Close()
+
+		addr2, closer2 := startTestServer(t, HandlerFunc(func(m Metric, a net.Addr) {
+			mutex.Lock()
+			counts[1]++
+			mutex.Unlock()
+		}))
+		defer closer2.Close()
+
+		client := NewClientWith(ClientConfig{
+			Address:   addr1,
+			Addresses: []string{addr2},
+			Mode:      test.mode,
+		})
+
+		engine := stats.NewEngine("datadog.test")
+		engine.Register(client)
+
+		for i := 0; i != 4; i++ {
+			engine.Incr("A")
+		}
+
+		engine.Flush()
+
+		for i := 0; i != 100; i++ {
+			mutex.Lock()
+			c := counts
+			mutex.Unlock()
+
+			if c == test.counts {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		mutex.Lock()
+		if counts != test.counts {
+			t.Error("bad number of metrics received by the agents in mode", test.mode, counts)
+		}
+		mutex.Unlock()
+
+		client.Close()
+	}
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	defer os.Unsetenv("DD_ENTITY_ID")
+	os.Setenv("DD_ENTITY_ID", "pod-1")
+
+	client := NewClientWith(ClientConfig{
+		Address:         "unix://" + path,
+		Distributions:   true,
+		OriginDetection: true,
+		ContainerID:     "0123abcd",
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("test")
+	engine.Register(client)
+	engine.Observe("latency", 0.25)
+
+	client.SendEvent(Event{Title: "deploy", Text: "version 42", AlertType: AlertSuccess})
+	client.SendServiceCheck(ServiceCheck{Name: "db.up", Status: StatusCritical, Message: "timeout"})
+	client.Flush()
+
+	b := make([]byte, DefaultUnixBufferSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(b[:n]); s != "test.latency:0.25|d|#dd.internal.entity_id:pod-1|c:0123abcd\n"+
+		"_e{6,10}:deploy|version 42|t:success|#dd.internal.entity_id:pod-1|c:0123abcd\n"+
+		"_sc|db.up|2|#dd.internal.entity_id:pod-1|c:0123abcd|m:timeout\n" {
+		t.Errorf("bad datagram:\n%s", s)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -13,15 +14,28 @@ import (
 // ConnConfig carries the configuration options that can be set when creating a
 // connection.
 type ConnConfig struct {
-	Address    string
+	// Address of the dogstatsd server, either a host:port pair to send UDP
+	// datagrams to, or the path of a unix datagram socket prefixed with
+	// "unix://", for example "unix:///var/run/datadog/dsd.socket".
+	Address string
+
+	// BufferSize is the maximum size of the datagrams, defaults to
+	// DefaultBufferSize over UDP and DefaultUnixBufferSize over unix domain
+	// sockets.
 	BufferSize int
+
+	// WriteBufferSize is the size requested for the kernel send buffer of the
+	// socket (SO_SNDBUF), defaults to DefaultWriteBufferSize. A negative value
+	// leaves the system default unchanged.
+	WriteBufferSize int
 }
 
-// A Conn represents a UDP connection to a dogstatsd server.
+// A Conn represents a UDP or unix datagram connection to a dogstatsd server.
 type Conn struct {
 	m sync.Mutex
 	c net.Conn
 	b []byte
+	w int
 }
 
 // Dial opens a new dogstatsd connection to address.
@@ -35,20 +49,30 @@ func Dial(address string) (conn *Conn, err error) {
 func DialConfig(config ConnConfig) (conn *Conn, err error) {
 	var c net.Conn
 	var n int
+	var w int
 
 	if len(config.Address) == 0 {
 		config.Address = DefaultAddress
 	}
 
 	if config.BufferSize == 0 {
-		config.BufferSize = DefaultBufferSize
+		if strings.HasPrefix(config.Address, unixPrefix) {
+			config.BufferSize = DefaultUnixBufferSize
+		} else {
+			config.BufferSize = DefaultBufferSize
+		}
 	}
 
-	if c, n, err = dial(config.Address, config.BufferSize); err != nil {
+	if config.WriteBufferSize == 0 {
+		config.WriteBufferSize = DefaultWriteBufferSize
+	}
+
+	if c, n, w, err = dial(config.Address, config.BufferSize, config.WriteBufferSize); err != nil {
 		return
 	}
 
 	conn = NewConn(c, make([]byte, 0, n))
+	conn.w = w
 	return
 }
 
@@ -90,6 +114,13 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	return
 }
 
+// WriteBufferSize returns the size of the kernel send buffer of the socket as
+// applied by the system, which may differ from the requested size, or zero if
+// it is unknown.
+func (c *Conn) WriteBufferSize() int {
+	return c.w
+}
+
 // LocalAddr satisfies the net.Conn interface.
 func (c *Conn) LocalAddr() net.Addr {
 	return c.c.LocalAddr()
@@ -115,7 +146,7 @@ func (c *Conn) SetWriteDeadline(t time.Time) error {
 	return c.c.SetWriteDeadline(t)
 }
 
-// Flush sends a UDP datagram containing all buffered data.
+// Flush sends a datagram containing all buffered data.
 func (c *Conn) Flush() (err error) {
 	c.m.Lock()
 	err = c.flush()
@@ -131,20 +162,35 @@ func (c *Conn) flush() (err error) {
 	return
 }
 
-func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error) {
+func dial(address string, sizehint int, sndbuf int) (conn net.Conn, bufsize int, wsize int, err error) {
 	var f *os.File
+	var network = "udp"
+
+	if strings.HasPrefix(address, unixPrefix) {
+		network, address = "unixgram", address[len(unixPrefix):]
+	}
 
-	if conn, err = net.Dial("udp", address); err != nil {
+	if conn, err = net.Dial(network, address); err != nil {
 		return
 	}
 
-	if f, err = conn.(*net.UDPConn).File(); err != nil {
+	if f, err = conn.(interface {
+		File() (*os.File, error)
+	}).File(); err != nil {
 		conn.Close()
 		return
 	}
 	defer f.Close()
 	fd := int(f.Fd())
 
+	// Under bursts of metrics the kernel may drop datagrams before they leave
+	// the host if the socket send buffer is full, a larger buffer gives more
+	// room to absorb the bursts. The kernel may clamp the value, the error is
+	// ignored and the actual size is read back below.
+	if sndbuf > 0 {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, sndbuf)
+	}
+
 	// The kernel refuses to send UDP datagrams that are larger than the size of
 	// the size of the socket send buffer. To maximize the number of metrics
 	// sent in one batch we attempt to attempt to adjust the kernel buffer size
@@ -158,11 +204,13 @@ func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error)
 	// The kernel applies a 2x factor on the socket buffer size, only half of it
 	// is available to write datagrams from user-space, the other half is used
 	// by the kernel directly.
+	wsize = bufsize
 	bufsize /= 2
 
 	for sizehint > bufsize && sizehint > 0 {
 		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, sizehint); err == nil {
 			bufsize = sizehint
+			wsize, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
 			break
 		}
 		sizehint /= 2
@@ -0,0 +1,27 @@
+package datadog
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDialConfigWriteBufferSize(t *testing.T) {
+	addr, closer := startTestServer(t, HandlerFunc(func(m Metric, a net.Addr) {}))
+	defer closer.Close()
+
+	c1, err := DialConfig(ConnConfig{Address: addr, WriteBufferSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	c2, err := DialConfig(ConnConfig{Address: addr, WriteBufferSize: 65536})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if w1, w2 := c1.WriteBufferSize(), c2.WriteBufferSize(); w1 <= 0 || w2 <= w1 {
+		t.Error("bad socket write buffer sizes:", w1, w2)
+	}
+}
@@ -0,0 +1,156 @@
+package datadog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// EventPriority is an enumeration of the priorities of datadog events.
+type EventPriority string
+
+const (
+	PriorityNormal EventPriority = "normal"
+	PriorityLow    EventPriority = "low"
+)
+
+// EventAlertType is an enumeration of the alert types of datadog events.
+type EventAlertType string
+
+const (
+	AlertInfo    EventAlertType = "info"
+	AlertError   EventAlertType = "error"
+	AlertWarning EventAlertType = "warning"
+	AlertSuccess EventAlertType = "success"
+)
+
+// The Event type represents events submitted to the datadog event stream, the
+// optional fields are omitted when they are zero values.
+type Event struct {
+	Title          string         // the title of the event
+	Text           string         // the body of the event, may span multiple lines
+	Time           time.Time      // defaults to the time the agent receives the event
+	Hostname       string         // defaults to the host of the agent
+	AggregationKey string         // groups events in the event stream
+	Priority       EventPriority  // defaults to PriorityNormal
+	SourceType     string         // the integration reporting the event, like "nagios"
+	AlertType      EventAlertType // defaults to AlertInfo
+	Tags           []stats.Tag    // the list of tags set on the event
+}
+
+// ServiceCheckStatus is an enumeration of the statuses of datadog service
+// checks.
+type ServiceCheckStatus int
+
+const (
+	StatusOK ServiceCheckStatus = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+// String satisfies the fmt.Stringer interface.
+func (s ServiceCheckStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusWarning:
+		return "warning"
+	case StatusCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// The ServiceCheck type represents the status of a service reported to
+// datadog, the optional fields are omitted when they are zero values.
+type ServiceCheck struct {
+	Name     string             // the name of the service check
+	Status   ServiceCheckStatus // the status of the service
+	Time     time.Time          // defaults to the time the agent receives the check
+	Hostname string             // defaults to the host of the agent
+	Message  string             // describes the status, may span multiple lines
+	Tags     []stats.Tag        // the list of tags set on the service check
+}
+
+// appendEvent appends the dogstatsd representation of e to b, the container id
+// is omitted when it is empty.
+func appendEvent(b []byte, e Event, containerID string) []byte {
+	title := escapeNewlines(e.Title)
+	text := escapeNewlines(e.Text)
+
+	b = append(b, "_e{"...)
+	b = strconv.AppendInt(b, int64(len(title)), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(len(text)), 10)
+	b = append(b, "}:"...)
+	b = append(b, title...)
+	b = append(b, '|')
+	b = append(b, text...)
+
+	if !e.Time.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, e.Time.Unix(), 10)
+	}
+
+	b = appendField(b, "|h:", e.Hostname)
+	b = appendField(b, "|k:", e.AggregationKey)
+	b = appendField(b, "|p:", string(e.Priority))
+	b = appendField(b, "|s:", e.SourceType)
+	b = appendField(b, "|t:", string(e.AlertType))
+
+	if len(e.Tags) != 0 {
+		b = append(b, '|', '#')
+		b = appendTags(b, e.Tags)
+	}
+
+	b = appendField(b, "|c:", containerID)
+	return append(b, '\n')
+}
+
+// appendServiceCheck appends the dogstatsd representation of s to b, the
+// container id is omitted when it is empty.
+func appendServiceCheck(b []byte, s ServiceCheck, containerID string) []byte {
+	b = append(b, "_sc|"...)
+	b = append(b, s.Name...)
+	b = append(b, '|')
+	b = strconv.AppendInt(b, int64(s.Status), 10)
+
+	if !s.Time.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, s.Time.Unix(), 10)
+	}
+
+	b = appendField(b, "|h:", s.Hostname)
+
+	if len(s.Tags) != 0 {
+		b = append(b, '|', '#')
+		b = appendTags(b, s.Tags)
+	}
+
+	b = appendField(b, "|c:", containerID)
+
+	// The message must be the last field, occurrences of "m:" are escaped
+	// so the agent does not mistake them for the start of the field.
+	if len(s.Message) != 0 {
+		b = append(b, "|m:"...)
+		b = append(b, strings.Replace(escapeNewlines(s.Message), "m:", `m\:`, -1)...)
+	}
+
+	return append(b, '\n')
+}
+
+func appendField(b []byte, prefix string, value string) []byte {
+	if len(value) != 0 {
+		b = append(b, prefix...)
+		b = append(b, value...)
+	}
+	return b
+}
+
+func escapeNewlines(s string) string {
+	return strings.Replace(s, "\n", `\n`, -1)
+}
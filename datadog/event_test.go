@@ -0,0 +1,81 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAppendEvent(t *testing.T) {
+	tests := []struct {
+		e Event
+		c string
+		s string
+	}{
+		{
+			e: Event{Title: "deploy", Text: "version 42"},
+			s: "_e{6,10}:deploy|version 42\n",
+		},
+		{
+			e: Event{
+				Title:          "restart",
+				Text:           "the server\nrestarted",
+				Time:           time.Unix(1500000000, 0),
+				Hostname:       "host-1",
+				AggregationKey: "restarts",
+				Priority:       PriorityLow,
+				SourceType:     "supervisor",
+				AlertType:      AlertWarning,
+				Tags:           []stats.Tag{{"service", "api"}},
+			},
+			c: "0123abcd",
+			s: "_e{7,21}:restart|the server\\nrestarted|d:1500000000|h:host-1|k:restarts|p:low|s:supervisor|t:warning|#service:api|c:0123abcd\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.e.Title, func(t *testing.T) {
+			if s := string(appendEvent(nil, test.e, test.c)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func TestAppendServiceCheck(t *testing.T) {
+	tests := []struct {
+		sc ServiceCheck
+		c  string
+		s  string
+	}{
+		{
+			sc: ServiceCheck{Name: "db.up"},
+			s:  "_sc|db.up|0\n",
+		},
+		{
+			sc: ServiceCheck{
+				Name:     "db.up",
+				Status:   StatusCritical,
+				Time:     time.Unix(1500000000, 0),
+				Hostname: "host-1",
+				Message:  "timeout: no answer\nafter 5s",
+				Tags:     []stats.Tag{{"db", "users"}},
+			},
+			c: "0123abcd",
+			s: "_sc|db.up|2|d:1500000000|h:host-1|#db:users|c:0123abcd|m:timeout: no answer\\nafter 5s\n",
+		},
+		{
+			sc: ServiceCheck{Name: "db.up", Status: StatusWarning, Message: "alarm: slow ping"},
+			s:  "_sc|db.up|1|m:alarm\\: slow ping\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sc.Status.String(), func(t *testing.T) {
+			if s := string(appendServiceCheck(nil, test.sc, test.c)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
@@ -12,10 +12,11 @@ import (
 type MetricType string
 
 const (
-	Counter   MetricType = "c"
-	Gauge     MetricType = "g"
-	Histogram MetricType = "h"
-	Unknown   MetricType = "?"
+	Counter      MetricType = "c"
+	Gauge        MetricType = "g"
+	Histogram    MetricType = "h"
+	Distribution MetricType = "d"
+	Unknown      MetricType = "?"
 )
 
 // The Metric type is a representation of the metrics supported by datadog.
@@ -26,6 +27,11 @@ type Metric struct {
 	Value     float64     // the metric value
 	Rate      float64     // sample rate, a value between 0 and 1
 	Tags      []stats.Tag // the list of tags set on the metric
+
+	// ContainerID is the identifier of the container that the metric
+	// originates from, which the agent uses to tag the metric with the tags
+	// of the container.
+	ContainerID string
 }
 
 // String satisfies the fmt.Stringer interface.
@@ -47,7 +53,7 @@ func metricType(m *stats.Metric) MetricType {
 		return Counter
 	case stats.GaugeType:
 		return Gauge
-	case stats.HistogramType:
+	case stats.HistogramType, stats.SummaryType, stats.ExponentialHistogramType:
 		return Histogram
 	default:
 		return Unknown
@@ -119,6 +119,40 @@ var testMetrics = []struct {
 			Tags:  []stats.Tag{{"country", "china"}},
 		},
 	},
+
+	{
+		s: "request.latency:0.25|d|#route:home|c:0123abcd\n",
+		m: Metric{
+			Type:        Distribution,
+			Name:        "request.latency",
+			Value:       0.25,
+			Rate:        1,
+			Tags:        []stats.Tag{{"route", "home"}},
+			ContainerID: "0123abcd",
+		},
+	},
+
+	{
+		s: "request.count:1|c|@0.5|c:0123abcd\n",
+		m: Metric{
+			Type:        Counter,
+			Name:        "request.count",
+			Value:       1,
+			Rate:        0.5,
+			ContainerID: "0123abcd",
+		},
+	},
+
+	{
+		s: "request.size:512|h|c:0123abcd\n",
+		m: Metric{
+			Type:        Histogram,
+			Name:        "request.size",
+			Value:       512,
+			Rate:        1,
+			ContainerID: "0123abcd",
+		},
+	},
 }
 
 func TestMetricString(t *testing.T) {
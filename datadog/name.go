@@ -0,0 +1,56 @@
+package datadog
+
+import (
+	"hash/fnv"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DefaultMaxNameLength is the default maximum length of the metric names sent
+// by clients, it matches the limit enforced by datadog.
+const DefaultMaxNameLength = 200
+
+// maxLoggedNames is the maximum number of rejected names that a client
+// remembers having logged. Names are no longer logged past this limit, which
+// bounds the memory used by the client when names have unbounded cardinality.
+const maxLoggedNames = 1000
+
+// NamePolicy is an enumeration of the behaviors that clients can adopt when
+// the name of a metric exceeds the maximum length.
+type NamePolicy int
+
+const (
+	// NameTruncate truncates names that are too long and appends a hash of the
+	// full name, so distinct names sharing a long prefix don't collide.
+	NameTruncate NamePolicy = iota
+
+	// NameReject discards the metrics with names that are too long, a message
+	// is logged the first time each name is seen.
+	NameReject
+)
+
+// truncateName shortens name to max bytes, replacing the end of the name with
+// an underscore followed by the 8 hexadecimal digits of the 32 bits FNV-1a
+// hash of the full name. The name is cut on a rune boundary, so the result
+// may be shorter than max when it contains multi-byte characters.
+func truncateName(name string, max int) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+
+	suffix := strconv.FormatUint(uint64(h.Sum32()), 16)
+
+	for len(suffix) < 8 {
+		suffix = "0" + suffix
+	}
+
+	suffix = "_" + suffix
+
+	if n := max - len(suffix); n > 0 {
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		return name[:n] + suffix
+	}
+
+	return suffix[len(suffix)-max:]
+}
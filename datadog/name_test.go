@@ -0,0 +1,101 @@
+package datadog
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/segmentio/stats"
+)
+
+func TestTruncateName(t *testing.T) {
+	a := truncateName(strings.Repeat("a", 300)+".requests", 200)
+	b := truncateName(strings.Repeat("a", 300)+".errors", 200)
+
+	if len(a) != 200 || len(b) != 200 {
+		t.Error("bad lengths of truncated names:", len(a), len(b))
+	}
+
+	if a == b {
+		t.Error("truncated names with a common prefix collided:", a)
+	}
+
+	if !strings.HasPrefix(a, strings.Repeat("a", 191)+"_") {
+		t.Error("bad truncated name:", a)
+	}
+
+	if s := truncateName("abcdefgh", 4); len(s) != 4 {
+		t.Error("bad truncated name shorter than the hash:", s)
+	}
+}
+
+func TestTruncateNameUTF8(t *testing.T) {
+	s := truncateName("ab"+strings.Repeat("é", 20), 20)
+
+	if !utf8.ValidString(s) || len(s) != 19 {
+		t.Error("bad truncated name with multi-byte characters:", s)
+	}
+}
+
+func TestClientNegativeMaxNameLength(t *testing.T) {
+	c := NewClientWith(ClientConfig{MaxNameLength: -1})
+	defer c.Close()
+
+	if c.maxName != DefaultMaxNameLength {
+		t.Error("bad maximum name length:", c.maxName)
+	}
+}
+
+func TestClientName(t *testing.T) {
+	tests := []struct {
+		policy    NamePolicy
+		namespace string
+		name      string
+		ok        bool
+		fullName  string
+	}{
+		{NameTruncate, "ns", "short", true, "ns.short"},
+		{NameTruncate, "", "exactly10!", true, "exactly10!"},
+		{NameTruncate, "ns", "too_long", true, truncateName("ns.too_long", 10)},
+		{NameReject, "ns", "short", true, "ns.short"},
+		{NameReject, "ns", "too_long", false, ""},
+	}
+
+	for _, test := range tests {
+		c := &Client{maxName: 10, policy: test.policy}
+		namespace, name, ok := c.name(&stats.Metric{Namespace: test.namespace, Name: test.name})
+
+		if ok != test.ok {
+			t.Error("bad result for", test.name, ok)
+		}
+
+		if ok {
+			fullName := name
+			if len(namespace) != 0 {
+				fullName = namespace + "." + name
+			}
+			if fullName != test.fullName {
+				t.Error("bad name:", fullName)
+			}
+		}
+	}
+}
+
+func TestClientRejectBounded(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	c := &Client{maxName: 10, policy: NameReject}
+
+	for i := 0; i != 2*maxLoggedNames; i++ {
+		c.name(&stats.Metric{Name: "too_long_" + strconv.Itoa(i)})
+	}
+
+	if n := len(c.rejected); n != maxLoggedNames {
+		t.Error("bad number of remembered names:", n)
+	}
+}
@@ -0,0 +1,60 @@
+package datadog
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/segmentio/stats"
+)
+
+// EntityIDTag is the name of the tag carrying the value of the DD_ENTITY_ID
+// environment variable on metrics sent by clients with origin detection, the
+// variable is set to the pod uid by the datadog admission controller.
+const EntityIDTag = "dd.internal.entity_id"
+
+// cgroupPath is the path of the file listing the cgroups of the process, it is
+// a variable so tests can change it.
+var cgroupPath = "/proc/self/cgroup"
+
+// containerIDPattern matches the container ids in the paths of cgroups, which
+// are either 64 hexadecimal characters (docker, containerd), or uuids (ecs,
+// cri-o), optionally followed by the .scope suffix of systemd units.
+var containerIDPattern = regexp.MustCompile(`([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12}|[0-9a-f]{64})(?:\.scope)?$`)
+
+// readContainerID returns the id of the container of the process read from the
+// cgroups listed in path, or an empty string if the process does not run in a
+// container or the file cannot be read.
+func readContainerID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		// Each line has the form hierarchy-id:controllers:path.
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if m := containerIDPattern.FindStringSubmatch(parts[2]); m != nil {
+			return m[1]
+		}
+	}
+
+	return ""
+}
+
+// entityTags returns the tags identifying the entity of the process to the
+// agent, which are empty unless DD_ENTITY_ID is set.
+func entityTags() []stats.Tag {
+	if id := os.Getenv("DD_ENTITY_ID"); len(id) != 0 {
+		return []stats.Tag{{Name: EntityIDTag, Value: id}}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package datadog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadContainerID(t *testing.T) {
+	tests := []struct {
+		cgroup string
+		id     string
+	}{
+		{
+			cgroup: "12:devices:/user.slice\n0::/init.scope\n",
+			id:     "",
+		},
+		{
+			cgroup: "11:cpu,cpuacct:/docker/3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860\n",
+			id:     "3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860",
+		},
+		{
+			cgroup: "0::/system.slice/docker-3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860.scope\n",
+			id:     "3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860",
+		},
+		{
+			cgroup: "1:name=systemd:/ecs/task/34dc0b5e-626f-2c5c-4c6b-1d4a5d7f5c93\n",
+			id:     "34dc0b5e-626f-2c5c-4c6b-1d4a5d7f5c93",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cgroup")
+
+	for _, test := range tests {
+		if err := ioutil.WriteFile(path, []byte(test.cgroup), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if id := readContainerID(path); id != test.id {
+			t.Errorf("%q: bad container id: %q", test.cgroup, id)
+		}
+	}
+
+	if id := readContainerID(filepath.Join(dir, "missing")); id != "" {
+		t.Error("bad container id of a missing file:", id)
+	}
+}
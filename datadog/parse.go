@@ -15,12 +15,23 @@ func parseMetric(s string) (m Metric, err error) {
 	var typ string
 	var rate string
 	var tags string
+	var container string
 
 	val, next = nextToken(next, '|')
 	typ, next = nextToken(next, '|')
 	rate, tags = nextToken(next, '|')
 	name, val = split(val, ':')
 
+	// The container id extension is the last field of metrics sent by clients
+	// supporting origin detection.
+	if off := strings.LastIndex(tags, "|c:"); off >= 0 {
+		tags, container = tags[:off], tags[off+3:]
+	} else if strings.HasPrefix(tags, "c:") {
+		tags, container = "", tags[2:]
+	} else if strings.HasPrefix(rate, "c:") && len(tags) == 0 {
+		rate, container = "", rate[2:]
+	}
+
 	if len(name) == 0 {
 		err = fmt.Errorf("datadog: %#v is missing a metric name", s)
 		return
@@ -78,10 +89,11 @@ func parseMetric(s string) (m Metric, err error) {
 	}
 
 	m = Metric{
-		Type:  MetricType(typ),
-		Name:  name,
-		Value: value,
-		Rate:  sampleRate,
+		Type:        MetricType(typ),
+		Name:        name,
+		Value:       value,
+		Rate:        sampleRate,
+		ContainerID: container,
 	}
 
 	if len(tags) != 0 {
@@ -0,0 +1,24 @@
+package datadog
+
+// Serializer is an interface implemented by types that format the metrics
+// sent by datadog clients, the buffering and transport of the serialized
+// metrics are handled by the clients.
+type Serializer interface {
+	// AppendMetric appends the serialized representation of m to b and
+	// returns the extended buffer. The output must end with a newline so
+	// metrics can be batched in datagrams.
+	AppendMetric(b []byte, m Metric) []byte
+}
+
+// SerializerFunc makes it possible for simple functions to be used as metric
+// serializers.
+type SerializerFunc func([]byte, Metric) []byte
+
+// AppendMetric calls f.
+func (f SerializerFunc) AppendMetric(b []byte, m Metric) []byte {
+	return f(b, m)
+}
+
+// DogStatsD is the serializer producing metrics in the standard DogStatsD
+// format, it is the default serializer of clients.
+var DogStatsD Serializer = SerializerFunc(appendMetric)
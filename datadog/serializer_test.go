@@ -0,0 +1,49 @@
+package datadog
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestClientSerializer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address: conn.LocalAddr().String(),
+		Serializer: SerializerFunc(func(b []byte, m Metric) []byte {
+			b = append(b, "relay:"...)
+			b = append(b, m.Namespace...)
+			b = append(b, '/')
+			b = append(b, m.Name...)
+			b = append(b, '=')
+			b = strconv.AppendFloat(b, m.Value, 'g', -1, 64)
+			return append(b, '\n')
+		}),
+	})
+	defer c.Close()
+
+	e := stats.NewEngine("E")
+	e.Register(c)
+	e.Add("requests", 42)
+	e.Flush()
+
+	b := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(b[:n]); s != "relay:E/requests=42\n" {
+		t.Errorf("bad datagram: %q", s)
+	}
+}
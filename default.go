@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GaugeDefault configures the default value of a gauge reported to a handler
+// returned by NewGaugeDefaultHandler.
+type GaugeDefault struct {
+	// Name is the name of the gauge that the default value applies to.
+	Name string
+
+	// Value is the value reported for the series of the gauge which were not
+	// set during a flush interval.
+	Value float64
+}
+
+type gaugeDefaultHandler struct {
+	handler Handler
+	gauges  map[string]float64
+	mutex   sync.Mutex
+	entries map[string]*gaugeDefaultEntry
+}
+
+type gaugeDefaultEntry struct {
+	namespace string
+	name      string
+	tags      []Tag
+	unit      string
+	set       bool // whether the series was set since the last flush
+}
+
+// NewGaugeDefaultHandler returns a handler which passes the metrics it receives
+// to handler and remembers the series of the listed gauges.
+//
+// Every time the handler is flushed, series that were not set since the
+// previous flush are reported to handler with the default value of their
+// gauge and the current time, so they are always present in each interval
+// with a value chosen by the program rather than a stale or missing one. A
+// series set during an interval is reported with the values it was set to,
+// and the default is only reported again if it isn't set in a later interval.
+//
+// Unlike NewGaugeRefreshHandler, which repeats the last value of series, the
+// default doesn't depend on the past values, which suits gauges like the
+// number of jobs processed per interval where no update means zero, or a
+// known baseline.
+//
+// Series are remembered until the handler is reset, series of a gauge only
+// start being defaulted after they were set once.
+func NewGaugeDefaultHandler(handler Handler, gauges ...GaugeDefault) Handler {
+	h := &gaugeDefaultHandler{
+		handler: handler,
+		gauges:  make(map[string]float64, len(gauges)),
+		entries: make(map[string]*gaugeDefaultEntry),
+	}
+
+	for _, g := range gauges {
+		h.gauges[g.Name] = g.Value
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *gaugeDefaultHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *gaugeDefaultHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *gaugeDefaultHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe records that the gauge of m was set.
+func (h *gaugeDefaultHandler) observe(m *Metric) {
+	if m.Type != GaugeType {
+		return
+	}
+
+	if _, ok := h.gauges[m.Name]; !ok {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+
+	h.mutex.Lock()
+
+	e := h.entries[key]
+	if e == nil {
+		e = &gaugeDefaultEntry{
+			namespace: m.Namespace,
+			name:      m.Name,
+			tags:      tags,
+		}
+		h.entries[key] = e
+	}
+	e.unit = m.Unit
+	e.set = true
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+func (h *gaugeDefaultHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *gaugeDefaultHandler) FlushContext(ctx context.Context) {
+	now := time.Now()
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.entries))
+	defaults := make([]Metric, 0, len(h.entries))
+
+	for key, e := range h.entries {
+		if !e.set {
+			keys = append(keys, key)
+		}
+		e.set = false
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := h.entries[key]
+
+		defaults = append(defaults, Metric{
+			Type:      GaugeType,
+			Namespace: e.namespace,
+			Name:      e.name,
+			Tags:      e.tags,
+			Value:     h.gauges[e.name],
+			Time:      now,
+			Unit:      e.unit,
+		})
+	}
+
+	h.mutex.Unlock()
+
+	for i := range defaults {
+		h.handler.HandleMetric(&defaults[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *gaugeDefaultHandler) Reset() {
+	h.mutex.Lock()
+	h.entries = make(map[string]*gaugeDefaultEntry)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGaugeDefaultHandler(t *testing.T) {
+	h := &handler{}
+	x := NewGaugeDefaultHandler(h,
+		GaugeDefault{Name: "jobs.processed"},
+		GaugeDefault{Name: "replicas", Value: 3},
+	)
+
+	e := NewEngine("E")
+	e.Register(x)
+
+	e.Set("jobs.processed", 10, Tag{"queue", "A"})
+	e.Set("jobs.processed", 20, Tag{"queue", "B"})
+	e.Set("replicas", 5)
+	e.Set("conns", 6)
+	e.Flush()
+
+	if len(h.metrics) != 4 {
+		t.Error("bad metrics during the first interval:", h.metrics)
+	}
+
+	h.Reset()
+	e.Set("jobs.processed", 30, Tag{"queue", "B"})
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "jobs.processed", Tags: []Tag{{"queue", "B"}}, Value: 30},
+		{Type: GaugeType, Namespace: "E", Name: "jobs.processed", Tags: []Tag{{"queue", "A"}}, Value: 0},
+		{Type: GaugeType, Namespace: "E", Name: "replicas", Value: 3},
+	}) {
+		t.Error("bad metrics during the second interval:", h.metrics)
+	}
+
+	h.Reset()
+	x.(Resetter).Reset()
+	e.Flush()
+
+	if len(h.metrics) != 0 {
+		t.Error("series were defaulted after the handler was reset:", h.metrics)
+	}
+}
@@ -0,0 +1,182 @@
+package stats
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	counterPtrType   = reflect.TypeOf((*Counter)(nil))
+	gaugePtrType     = reflect.TypeOf((*Gauge)(nil))
+	histogramPtrType = reflect.TypeOf((*Histogram)(nil))
+	timerPtrType     = reflect.TypeOf((*Timer)(nil))
+)
+
+// DefineMetrics sets the fields of the struct pointed to by metrics to
+// counters, gauges, histograms, and timers producing metrics on eng, and
+// declares their help text and unit.
+//
+// The fields are configured with struct tags of the form
+//
+//	stats:"name,help=...,unit=..."
+//
+// where the name is required and the options are optional. The help text may
+// contain commas. Histograms with a unit naming a duration, like "seconds" or
+// "milliseconds", report durations passed to ObserveDuration in this unit.
+// Timers report durations in seconds, they only accept "seconds" as unit.
+// Fields without a stats tag, or with a tag set to "-", are left unchanged.
+//
+// For example:
+//
+//	var metrics struct {
+//		Requests *stats.Counter   `stats:"requests,help=Number of requests served."`
+//		Latency  *stats.Histogram `stats:"latency,help=Time to serve requests.,unit=seconds"`
+//	}
+//
+//	if err := eng.DefineMetrics(&metrics); err != nil {
+//		...
+//	}
+//
+// An error is returned if metrics is not a pointer to a struct, or if a tagged
+// field is unexported, of an unsupported type, or has an invalid tag, in which
+// case no fields are modified.
+func (eng *Engine) DefineMetrics(metrics interface{}) error {
+	v := reflect.ValueOf(metrics)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("stats: metrics must be defined on a pointer to a struct, got %T", metrics)
+	}
+
+	v = v.Elem()
+	t := v.Type()
+	defs := make([]metricDefinition, 0, t.NumField())
+
+	for i := 0; i != t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("stats")
+
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if len(f.PkgPath) != 0 {
+			return fmt.Errorf("stats: metric field %s.%s is not exported", t, f.Name)
+		}
+
+		d, err := parseMetricDefinition(tag)
+		if err != nil {
+			return fmt.Errorf("stats: metric field %s.%s: %s", t, f.Name, err)
+		}
+
+		switch f.Type {
+		case counterPtrType:
+			d.typ = CounterType
+		case gaugePtrType:
+			d.typ = GaugeType
+		case histogramPtrType:
+			d.typ = HistogramType
+		case timerPtrType:
+			// Timers always report durations in seconds, declaring another
+			// unit would misdescribe their values.
+			if len(d.unit) != 0 && d.unit != durationUnitName(time.Second) {
+				return fmt.Errorf("stats: metric field %s.%s is a timer with unit %q, timers only support seconds", t, f.Name, d.unit)
+			}
+			d.typ = HistogramType
+		default:
+			return fmt.Errorf("stats: metric field %s.%s has unsupported type %s", t, f.Name, f.Type)
+		}
+
+		d.index = i
+		defs = append(defs, d)
+	}
+
+	for _, d := range defs {
+		var m interface{}
+		unit := d.unit
+
+		switch v.Field(d.index).Type() {
+		case counterPtrType:
+			m = eng.Counter(d.name)
+		case gaugePtrType:
+			m = eng.Gauge(d.name)
+		case histogramPtrType:
+			h := eng.Histogram(d.name)
+			if u, ok := durationUnit(d.unit); ok {
+				h, unit = h.WithDurationUnit(u), "" // already declared
+			}
+			m = h
+		case timerPtrType:
+			m = eng.Timer(d.name)
+		}
+
+		if len(d.help) != 0 || len(unit) != 0 {
+			eng.Describe(d.typ, d.name, d.help, unit)
+		}
+
+		v.Field(d.index).Set(reflect.ValueOf(m))
+	}
+
+	return nil
+}
+
+// DefineMetrics sets the fields of the struct pointed to by metrics to metrics
+// produced on the default engine, see Engine.DefineMetrics.
+func DefineMetrics(metrics interface{}) error {
+	return DefaultEngine.DefineMetrics(metrics)
+}
+
+type metricDefinition struct {
+	index int
+	typ   MetricType
+	name  string
+	help  string
+	unit  string
+}
+
+func parseMetricDefinition(tag string) (d metricDefinition, err error) {
+	parts := strings.Split(tag, ",")
+	d.name = strings.TrimSpace(parts[0])
+
+	if len(d.name) == 0 {
+		err = fmt.Errorf("missing metric name in tag %q", tag)
+		return
+	}
+
+	// Parts that don't start with a known option continue the value of the
+	// previous option, which allows the help text to contain commas.
+	var last *string
+
+	for _, p := range parts[1:] {
+		switch {
+		case strings.HasPrefix(p, "help="):
+			last, d.help = &d.help, p[5:]
+		case strings.HasPrefix(p, "unit="):
+			last, d.unit = &d.unit, p[5:]
+		case last != nil:
+			*last += "," + p
+		default:
+			err = fmt.Errorf("unknown option %q in tag %q", p, tag)
+			return
+		}
+	}
+
+	return
+}
+
+func durationUnit(name string) (time.Duration, bool) {
+	for _, unit := range []time.Duration{
+		time.Nanosecond,
+		time.Microsecond,
+		time.Millisecond,
+		time.Second,
+		time.Minute,
+		time.Hour,
+	} {
+		if durationUnitName(unit) == name {
+			return unit, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type describer struct {
+	handler
+	schema []MetricSchema
+}
+
+func (d *describer) DescribeMetric(s MetricSchema) {
+	d.schema = append(d.schema, s)
+}
+
+func TestDefineMetrics(t *testing.T) {
+	var metrics struct {
+		Requests *Counter   `stats:"requests,help=Number of requests, by status.,unit=requests"`
+		Conns    *Gauge     `stats:"conns"`
+		Latency  *Histogram `stats:"latency,unit=milliseconds"`
+		Lookups  *Timer     `stats:"lookups,help=Time spent in lookups."`
+		Ignored  *Counter   `stats:"-"`
+		Other    int
+	}
+
+	h := &describer{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	if err := e.DefineMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics.Ignored != nil {
+		t.Error("the field with a stats tag set to \"-\" was modified")
+	}
+
+	metrics.Requests.Incr()
+	metrics.Conns.Set(2)
+	metrics.Latency.ObserveDuration(3 * time.Millisecond)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1},
+		{Type: GaugeType, Namespace: "E", Name: "conns", Value: 2},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 3, Unit: "milliseconds"},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if !reflect.DeepEqual(h.schema, []MetricSchema{
+		{Type: CounterType, Namespace: "E", Name: "requests", Help: "Number of requests, by status.", Unit: "requests"},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Unit: "milliseconds"},
+		{Type: HistogramType, Namespace: "E", Name: "lookups", Help: "Time spent in lookups."},
+	}) {
+		t.Error("bad descriptions:", h.schema)
+	}
+}
+
+func TestDefineMetricsErrors(t *testing.T) {
+	var counter *Counter
+
+	tests := []struct {
+		name    string
+		metrics interface{}
+	}{
+		{
+			name:    "not a pointer",
+			metrics: struct{}{},
+		},
+		{
+			name:    "not a struct",
+			metrics: &counter,
+		},
+		{
+			name: "unexported field",
+			metrics: &struct {
+				requests *Counter `stats:"requests"`
+			}{},
+		},
+		{
+			name: "unsupported type",
+			metrics: &struct {
+				Requests int `stats:"requests"`
+			}{},
+		},
+		{
+			name: "missing name",
+			metrics: &struct {
+				Requests *Counter `stats:",help=Number of requests."`
+			}{},
+		},
+		{
+			name: "timer unit",
+			metrics: &struct {
+				Lookups *Timer `stats:"lookups,unit=milliseconds"`
+			}{},
+		},
+		{
+			name: "unknown option",
+			metrics: &struct {
+				Requests *Counter `stats:"requests,buckets=1"`
+			}{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := NewEngine("E").DefineMetrics(test.metrics); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRegisterDescriber(t *testing.T) {
+	e := NewEngine("E")
+	e.Describe(CounterType, "requests", "Number of requests.", "")
+	e.Gauge("conns")
+
+	h := &describer{}
+	e.Register(h)
+
+	if !reflect.DeepEqual(h.schema, []MetricSchema{
+		{Type: CounterType, Namespace: "E", Name: "requests", Help: "Number of requests."},
+	}) {
+		t.Error("bad descriptions:", h.schema)
+	}
+}
@@ -0,0 +1,189 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The DerivativeConfig type is used to configure derivative handlers.
+type DerivativeConfig struct {
+	// Gauges is the list of names of the gauges that derivatives are reported
+	// for.
+	Gauges []string
+
+	// Suffix is appended to the names of gauges to form the names of their
+	// derivatives, defaults to DefaultRateSuffix.
+	Suffix string
+
+	// ZeroFirst enables reporting a derivative of zero on the first flush of
+	// a series, when no previous value is known. By default nothing is
+	// reported for a series until it was seen on two flushes.
+	ZeroFirst bool
+}
+
+type derivativeHandler struct {
+	handler Handler
+	suffix  string
+	zero    bool
+	gauges  map[string]struct{}
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]*derivativeEntry
+}
+
+type derivativeEntry struct {
+	namespace string
+	name      string
+	tags      []Tag
+	value     float64   // last value the gauge was set to
+	prev      float64   // value of the gauge on the previous flush
+	time      time.Time // time of the previous flush, zero before the first one
+}
+
+// NewDerivativeHandler returns a handler which passes the metrics it receives
+// to handler and, for the gauges listed in the configuration, reports gauges
+// carrying their per-second rate of change every time it is flushed.
+//
+// The rate of change of a series is the difference between its values on two
+// consecutive flushes divided by the time elapsed between them, which makes
+// the filling or draining of gauges like queue depths directly visible. The
+// handler retains the value of each series on the previous flush, series are
+// remembered until the handler is reset.
+func NewDerivativeHandler(handler Handler, config DerivativeConfig) Handler {
+	if len(config.Suffix) == 0 {
+		config.Suffix = DefaultRateSuffix
+	}
+
+	h := &derivativeHandler{
+		handler: handler,
+		suffix:  config.Suffix,
+		zero:    config.ZeroFirst,
+		gauges:  make(map[string]struct{}, len(config.Gauges)),
+		now:     time.Now,
+		entries: make(map[string]*derivativeEntry),
+	}
+
+	for _, name := range config.Gauges {
+		h.gauges[name] = struct{}{}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *derivativeHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *derivativeHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *derivativeHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe records the value of the gauge of m.
+func (h *derivativeHandler) observe(m *Metric) {
+	if m.Type != GaugeType {
+		return
+	}
+
+	if _, ok := h.gauges[m.Name]; !ok {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+
+	h.mutex.Lock()
+
+	e := h.entries[key]
+	if e == nil {
+		e = &derivativeEntry{
+			namespace: m.Namespace,
+			name:      m.Name + h.suffix,
+			tags:      tags,
+		}
+		h.entries[key] = e
+	}
+	e.value = m.Value
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+func (h *derivativeHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *derivativeHandler) FlushContext(ctx context.Context) {
+	now := h.now()
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.entries))
+	rates := make([]Metric, 0, len(h.entries))
+
+	for key := range h.entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := h.entries[key]
+		value, report := 0.0, h.zero
+
+		if !e.time.IsZero() {
+			if elapsed := now.Sub(e.time).Seconds(); elapsed > 0 {
+				value = (e.value - e.prev) / elapsed
+			}
+			report = true
+		}
+
+		if report {
+			rates = append(rates, Metric{
+				Type:      GaugeType,
+				Namespace: e.namespace,
+				Name:      e.name,
+				Tags:      e.tags,
+				Value:     value,
+				Time:      now,
+			})
+		}
+
+		e.prev, e.time = e.value, now
+	}
+
+	h.mutex.Unlock()
+
+	for i := range rates {
+		h.handler.HandleMetric(&rates[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *derivativeHandler) Reset() {
+	h.mutex.Lock()
+	h.entries = make(map[string]*derivativeEntry)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDerivativeHandler(t *testing.T) {
+	tests := []struct {
+		scenario string
+		zero     bool
+		first    []Metric
+	}{
+		{
+			scenario: "nothing is reported on the first flush by default",
+			zero:     false,
+			first:    nil,
+		},
+		{
+			scenario: "zero is reported on the first flush when configured",
+			zero:     true,
+			first: []Metric{
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth.rate", Tags: []Tag{{"queue", "jobs"}}, Value: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			now := time.Now()
+			h := &handler{}
+			d := NewDerivativeHandler(h, DerivativeConfig{
+				Gauges:    []string{"queue.depth"},
+				ZeroFirst: test.zero,
+			})
+			d.(*derivativeHandler).now = func() time.Time { return now }
+
+			e := NewEngine("E")
+			e.Register(d)
+
+			e.Set("queue.depth", 10, Tag{"queue", "jobs"})
+			e.Set("conns", 1)
+			h.metrics = nil
+			e.Flush()
+
+			if !reflect.DeepEqual(h.metrics, test.first) {
+				t.Error("bad metrics on the first flush:", h.metrics)
+			}
+
+			if h.flushed != 1 {
+				t.Error("the derivative handler did not flush the underlying handler")
+			}
+
+			now = now.Add(10 * time.Second)
+			h.metrics = nil
+			e.Set("queue.depth", 50, Tag{"queue", "jobs"})
+			e.Flush()
+
+			now = now.Add(5 * time.Second)
+			e.Set("queue.depth", 40, Tag{"queue", "jobs"})
+			e.Flush()
+
+			// Series which did not change report a derivative of zero.
+			now = now.Add(5 * time.Second)
+			e.Flush()
+
+			if !reflect.DeepEqual(h.metrics, []Metric{
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth", Tags: []Tag{{"queue", "jobs"}}, Value: 50},
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth.rate", Tags: []Tag{{"queue", "jobs"}}, Value: 4},
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth", Tags: []Tag{{"queue", "jobs"}}, Value: 40},
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth.rate", Tags: []Tag{{"queue", "jobs"}}, Value: -2},
+				{Type: GaugeType, Namespace: "E", Name: "queue.depth.rate", Tags: []Tag{{"queue", "jobs"}}, Value: 0},
+			}) {
+				t.Error("bad metrics:", h.metrics)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package stats
+
+import "sort"
+
+// MetricDelta represents the change of a metric between two snapshots
+// compared by DiffState.
+type MetricDelta struct {
+	// Type is the type of the metric.
+	Type MetricType
+
+	// Namespace in which the metric was generated.
+	Namespace string
+
+	// Name is the name of the metric.
+	Name string
+
+	// Tags is the list of tags set on the metric, sorted by name.
+	Tags []Tag
+
+	// Before and After are the values of the metric in the two snapshots, they
+	// are the totals of counters, the values of gauges, and the sums of the
+	// values observed by histograms and summaries.
+	Before float64
+	After  float64
+
+	// Count is the number of values observed by a histogram or summary
+	// between the two snapshots, it is always zero for counters and gauges.
+	Count int
+}
+
+// Delta returns the change of the value of the metric between the two
+// snapshots.
+func (d MetricDelta) Delta() float64 {
+	return d.After - d.Before
+}
+
+// DiffState compares two snapshots of metrics and returns the list of changes
+// that happened between them, sorted by namespace, name, tags, and type.
+//
+// Metrics are matched by type, namespace, name, and tags, regardless of the
+// order of the tags. Within a snapshot the values of counters with the same
+// identity are summed, the last value of gauges is retained, and the values of
+// histograms are counted and summed, so the function can be used directly on
+// the list of metrics received by a handler. Metrics that didn't change are
+// omitted from the result.
+//
+// This is mostly useful in tests, for example to verify that an operation
+// counted exactly 3 errors.
+func DiffState(before []Metric, after []Metric) []MetricDelta {
+	states := make(map[string]*diffState)
+	aggregateState(states, before, func(s *diffState) *diffValue { return &s.before })
+	aggregateState(states, after, func(s *diffState) *diffValue { return &s.after })
+
+	keys := make([]string, 0, len(states))
+
+	for key, s := range states {
+		if s.changed() {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	deltas := make([]MetricDelta, len(keys))
+
+	for i, key := range keys {
+		s := states[key]
+		deltas[i] = MetricDelta{
+			Type:      s.typ,
+			Namespace: s.namespace,
+			Name:      s.name,
+			Tags:      s.tags,
+			Before:    s.before.value,
+			After:     s.after.value,
+			Count:     s.after.count - s.before.count,
+		}
+	}
+
+	return deltas
+}
+
+type diffValue struct {
+	value float64
+	count int
+	set   bool
+}
+
+type diffState struct {
+	typ       MetricType
+	namespace string
+	name      string
+	tags      []Tag
+	before    diffValue
+	after     diffValue
+}
+
+func (s *diffState) changed() bool {
+	if s.typ == GaugeType {
+		return s.before.set != s.after.set || s.before.value != s.after.value
+	}
+	return s.before.value != s.after.value || s.before.count != s.after.count
+}
+
+func aggregateState(states map[string]*diffState, metrics []Metric, value func(*diffState) *diffValue) {
+	for _, m := range metrics {
+		tags := copyTags(m.Tags)
+		sort.SliceStable(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+
+		key := diffKey(m.Type, m.Namespace, m.Name, tags)
+		s := states[key]
+
+		if s == nil {
+			s = &diffState{
+				typ:       m.Type,
+				namespace: m.Namespace,
+				name:      m.Name,
+				tags:      tags,
+			}
+			states[key] = s
+		}
+
+		v := value(s)
+
+		switch m.Type {
+		case GaugeType:
+			v.value = m.Value
+		default:
+			v.value += m.Value
+		}
+
+		if m.Type == HistogramType || m.Type == SummaryType || m.Type == ExponentialHistogramType {
+			v.count++
+		}
+
+		v.set = true
+	}
+}
+
+func diffKey(typ MetricType, namespace string, name string, tags []Tag) string {
+	b := make([]byte, 0, 64)
+	b = append(b, namespace...)
+	b = append(b, 0)
+	b = append(b, name...)
+
+	for _, t := range tags {
+		b = append(b, 0)
+		b = append(b, t.Name...)
+		b = append(b, '=')
+		b = append(b, t.Value...)
+	}
+
+	b = append(b, 0)
+	b = append(b, byte('0'+typ))
+	return string(b)
+}
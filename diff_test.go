@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffState(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	e.Incr("errors", Tag{"type", "timeout"})
+	e.Set("conns", 2)
+	e.Set("idle", 1)
+	e.Observe("latency", 1)
+
+	before := append([]Metric(nil), h.metrics...)
+
+	e.Add("errors", 3, Tag{"type", "timeout"})
+	e.Incr("requests", Tag{"status", "ok"}, Tag{"method", "GET"})
+	e.Incr("requests", Tag{"method", "GET"}, Tag{"status", "ok"})
+	e.Set("conns", 5)
+	e.Set("idle", 1)
+	e.Observe("latency", 2)
+	e.Observe("latency", 4)
+
+	deltas := DiffState(before, h.metrics)
+
+	if !reflect.DeepEqual(deltas, []MetricDelta{
+		{Type: GaugeType, Namespace: "E", Name: "conns", Before: 2, After: 5},
+		{Type: CounterType, Namespace: "E", Name: "errors", Tags: []Tag{{"type", "timeout"}}, Before: 1, After: 4},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Before: 1, After: 7, Count: 2},
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"method", "GET"}, {"status", "ok"}}, After: 2},
+	}) {
+		t.Error("bad deltas:", deltas)
+	}
+
+	if d := deltas[1].Delta(); d != 3 {
+		t.Error("bad counter delta:", d)
+	}
+
+	if deltas := DiffState(h.metrics, h.metrics); len(deltas) != 0 {
+		t.Error("unexpected deltas between identical snapshots:", deltas)
+	}
+}
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"sync"
@@ -14,10 +15,198 @@ import (
 // DefaultEngine, which is implicitly used by all top-level functions of the
 // package.
 type Engine struct {
-	name     string
-	tags     []Tag
-	handlers []Handler
-	hmutex   sync.RWMutex
+	name        string
+	tags        []Tag
+	handlers    []Handler
+	hmutex      sync.RWMutex
+	schema      *schemaRegistry
+	spans       *spanRegistry
+	allow       *tagAllowlist
+	level       Level
+	verbose     *int32
+	flush       *flushConfig
+	classify    ErrorClassifier
+	lazy        []LazyTag
+	queue       *queueLatency
+	shard       string
+	shards      *shardAggregator
+	aggregates  *aggregator
+	reported    *int64
+	limits      *observationLimiter
+	nonFinite   *nonFiniteGuard
+	health      *engineHealth
+	tagCase     TagCase
+	idempotency *idempotencyCache
+	sampler     *sampler
+	seed        uint64
+	seeded      bool
+	outliers    *outlierDetector
+
+	// scoped is set on the engines of tenants, which share the state of the
+	// engine they derive from but only flush their own handlers.
+	scoped bool
+}
+
+// The EngineConfig type is used to configure engines.
+type EngineConfig struct {
+	// Name is the name of the engine, used as namespace of the metrics it
+	// produces.
+	Name string
+
+	// Tags is the list of tags set on all metrics produced by the engine.
+	Tags []Tag
+
+	// SpanNamer is used by the WithContext method to derive a "span" tag from
+	// contexts, it is disabled when nil.
+	//
+	// Span names become tag values and must therefore be low-cardinality
+	// operation names, never identifiers. To protect against mistakes the
+	// engine reports names in excess of MaxSpanNames as "other".
+	SpanNamer SpanNamer
+
+	// MaxSpanNames is the maximum number of distinct span names that the
+	// engine reports, defaults to DefaultMaxSpanNames.
+	MaxSpanNames int
+
+	// TagValues maps tag names to the list of values that these tags are
+	// allowed to take, for example {"status": {"ok", "error", "timeout"}}.
+	//
+	// Values that are not part of the list are reported as OtherTagValue,
+	// which guarantees that the cardinality of these tags stays bounded even
+	// if the program produces unexpected values. Tags with names that are not
+	// in the map are left untouched.
+	TagValues map[string][]string
+
+	// LogTagValues enables logging the tag values rewritten by the engine
+	// because they were not allowed, each offending value is logged once, up
+	// to the first 1000 values.
+	LogTagValues bool
+
+	// Verbosity is the initial verbosity of the engine, metrics produced with
+	// a level greater than the verbosity are discarded. See WithLevel.
+	Verbosity Level
+
+	// FlushTimeout is the maximum amount of time that the engine waits for
+	// each handler to flush, zero means no timeout.
+	//
+	// A handler which doesn't complete its flush in time is abandoned, so a
+	// slow or hung backend does not prevent the other handlers from being
+	// flushed. Handlers implementing the ContextFlusher interface receive a
+	// context carrying the deadline. Abandoned flushes are counted and can be
+	// retrieved with the FlushTimeouts method. A handler is not flushed again
+	// until its abandoned flush completed, the following flushes of the
+	// handler are abandoned in the meantime.
+	FlushTimeout time.Duration
+
+	// ErrorClassifier is used by RecordError to derive the category of the
+	// errors reported on the engine, defaults to ErrorTypeName.
+	ErrorClassifier ErrorClassifier
+
+	// QueueLatency enables reporting the QueueLatencyMetricName histogram,
+	// which measures the delay between the time metrics are produced and the
+	// time the engine is flushed. A growing latency indicates that handlers
+	// buffering metrics are not flushed often enough or that the backends
+	// are not keeping up.
+	//
+	// Enabling this option sets the time of all metrics produced by the
+	// engine, which has a small cost on every metric.
+	QueueLatency bool
+
+	// ShardAggregates enables reporting a shard-less aggregate of the metrics
+	// produced by engines returned by WithShard, in addition to the metrics
+	// carrying the shard tag. See WithShard for details.
+	ShardAggregates bool
+
+	// ShardGaugeTimeout is the duration after which the value of a gauge
+	// that a shard stopped setting no longer contributes to the shard-less
+	// aggregate, defaults to DefaultShardGaugeTimeout.
+	ShardGaugeTimeout time.Duration
+
+	// Aggregations maps metric names to functions aggregating their values
+	// over each flush interval, for example {"queue.depth": AggregateMax}.
+	//
+	// Instead of being passed to the handlers when they are produced, the
+	// values of these metrics are combined by the aggregation function for
+	// each series, and the aggregates are reported with their original type
+	// when the engine is flushed. The aggregates are then reset, so a gauge
+	// aggregated with AggregateMax reports the maximum value it took during
+	// the last interval rather than its last value.
+	Aggregations map[string]AggregateFunc
+
+	// ReportDropped enables reporting the DroppedMetricName counter, which
+	// counts the metrics discarded by the engine and its handlers since the
+	// last flush. See the Stats method and the DropCounter interface.
+	ReportDropped bool
+
+	// ReportHealth enables reporting metrics describing the health of the
+	// engine every time it is flushed, see the HealthMetricPrefix constant
+	// and the Stats method.
+	ReportHealth bool
+
+	// ObservationLimits maps histogram names to the maximum number of
+	// observations per second that the engine reports on them, for example
+	// {"cache.lookup.seconds": 1000}.
+	//
+	// Each histogram name is guarded by a token bucket which allows bursts of
+	// up to one second of observations, observations in excess are discarded.
+	// The next observation reported after some were discarded carries a
+	// sample rate (see Metric.Rate) which accounts for them, so handlers
+	// honoring sample rates keep approximately correct counts and sums. Unlike
+	// random sampling, the limit is a hard cap on the cost of instrumenting a
+	// histogram observed in a tight loop.
+	ObservationLimits map[string]float64
+
+	// NonFinite is the policy applied to metrics with NaN or infinite values,
+	// defaults to NonFiniteReject. Each metric name producing such values is
+	// logged once.
+	NonFinite NonFinitePolicy
+
+	// TagCase is the normalization applied to the names of the tags of the
+	// metrics produced by the engine, engine tags included, before they are
+	// passed to handlers. Defaults to TagCaseNone, which leaves the names
+	// unchanged. The names in TagValues are normalized as well.
+	TagCase TagCase
+
+	// IdempotencyWindow is the duration during which the idempotency keys
+	// passed to AddOnce, IncrOnce, and ObserveOnce are remembered, defaults
+	// to DefaultIdempotencyWindow.
+	IdempotencyWindow time.Duration
+
+	// MaxIdempotencyKeys is the maximum number of idempotency keys that the
+	// engine remembers, defaults to DefaultMaxIdempotencyKeys. The oldest
+	// keys are forgotten first when the limit is reached.
+	MaxIdempotencyKeys int
+
+	// SampleRates maps metric names to the rate at which they are sampled,
+	// between 0 and 1, for example {"cache.lookups": 0.01}.
+	//
+	// Sampled metrics are reported with a sample rate (see Metric.Rate) so
+	// handlers honoring sample rates keep approximately correct counts and
+	// sums. Sampling decisions are random unless the engine carries a seed,
+	// see WithSampleSeed and WithContext: all metrics produced with the same
+	// seed get consistent decisions, so the metrics of a sampled request are
+	// either all reported or all discarded.
+	SampleRates map[string]float64
+
+	// SampleSeeder is used by the WithContext method to derive sampling seeds
+	// from contexts, defaults to ContextSampleSeed.
+	SampleSeeder SampleSeeder
+
+	// Outliers maps histogram names to the thresholds above which their
+	// observations are outliers. Each outlier observation also increments a
+	// counter named after the histogram with the OutlierSuffix, carrying the
+	// tags of the observation, which tracks tail events cheaply alongside the
+	// distribution.
+	Outliers map[string]OutlierThreshold
+
+	// Buckets maps the names of histograms to the upper limits of their
+	// buckets, for example {"http.*.latency": stats.ErrorBoundBuckets(...)}.
+	// The names may be patterns in the syntax of path.Match, matched against
+	// the names of histograms with and without their namespace. The buckets
+	// of exact names take precedence over patterns, and longer patterns over
+	// shorter ones. The limits are passed to the handlers implementing the
+	// Describer interface with the schemas of histograms, see SetBuckets.
+	Buckets map[string][]float64
 }
 
 var (
@@ -30,10 +219,82 @@ var (
 
 // NewEngine creates and returns an engine with name and tags.
 func NewEngine(name string, tags ...Tag) *Engine {
-	return &Engine{
-		name: name,
-		tags: copyTags(tags),
+	return NewEngineWith(EngineConfig{
+		Name: name,
+		Tags: tags,
+	})
+}
+
+// NewEngineWith creates and returns an engine configured with config.
+func NewEngineWith(config EngineConfig) *Engine {
+	eng := &Engine{
+		name:     config.Name,
+		tags:     copyTags(config.Tags),
+		schema:   newSchemaRegistry(),
+		verbose:  new(int32),
+		flush:    &flushConfig{timeout: config.FlushTimeout},
+		classify: config.ErrorClassifier,
+		tagCase:  config.TagCase,
+	}
+
+	eng.idempotency = newIdempotencyCache(config.IdempotencyWindow, config.MaxIdempotencyKeys)
+
+	eng.nonFinite = newNonFiniteGuard(config.NonFinite)
+
+	if config.QueueLatency {
+		eng.queue = newQueueLatency()
+	}
+
+	if config.ShardAggregates {
+		eng.shards = newShardAggregator(config.ShardGaugeTimeout)
+	}
+
+	if len(config.Aggregations) != 0 {
+		eng.aggregates = newAggregator(config.Aggregations)
+	}
+
+	if config.ReportDropped {
+		eng.reported = new(int64)
+	}
+
+	if config.ReportHealth {
+		eng.health = &engineHealth{}
+	}
+
+	if len(config.SampleRates) != 0 {
+		eng.sampler = newSampler(config.SampleRates, config.SampleSeeder)
 	}
+
+	if len(config.Outliers) != 0 {
+		eng.outliers = newOutlierDetector(config.Outliers)
+	}
+
+	if len(config.ObservationLimits) != 0 {
+		eng.limits = newObservationLimiter(config.ObservationLimits)
+	}
+
+	for pattern, limits := range config.Buckets {
+		eng.schema.setBuckets(pattern, sortedBuckets(limits))
+	}
+
+	eng.SetVerbosity(config.Verbosity)
+
+	if config.SpanNamer != nil {
+		if config.MaxSpanNames == 0 {
+			config.MaxSpanNames = DefaultMaxSpanNames
+		}
+		eng.spans = newSpanRegistry(config.SpanNamer, config.MaxSpanNames)
+	}
+
+	if len(config.TagValues) != 0 {
+		values := make(map[string][]string, len(config.TagValues))
+		for name, list := range config.TagValues {
+			values[config.TagCase.Normalize(name)] = list
+		}
+		eng.allow = newTagAllowlist(values, config.LogTagValues)
+	}
+
+	return eng
 }
 
 // Name returns the name of the engine.
@@ -59,47 +320,254 @@ func (eng *Engine) Handlers() []Handler {
 //
 // To prevent any deadlock from happening this method should never be called
 // from the handler's HandleMetric method.
+//
+// Handlers implementing the Describer interface receive the help text, unit,
+// and objectives of the metrics declared on eng so far.
 func (eng *Engine) Register(handler Handler) {
 	eng.hmutex.Lock()
 	eng.handlers = append(eng.handlers, handler)
 	eng.hmutex.Unlock()
+
+	if d, ok := handler.(Describer); ok {
+		for _, s := range eng.schema.schema() {
+			if s.described() {
+				d.DescribeMetric(s)
+			}
+		}
+	}
 }
 
 // WithName creates a new engine which inherits the properties and handlers
 // of eng and uses the given name.
 func (eng *Engine) WithName(name string) *Engine {
-	return &Engine{
-		name:     name,
-		tags:     eng.tags,
-		handlers: eng.Handlers(),
-	}
+	return eng.derive(name, eng.tags)
 }
 
 // WithTags creates a new engine which inherits the properties and handlers,
 // adding the given tags to the returned engine.
 func (eng *Engine) WithTags(tags ...Tag) *Engine {
+	return eng.derive(eng.name, concatTags(eng.tags, tags))
+}
+
+// WithContext creates a new engine which inherits the properties and handlers
+// of eng, adding a "span" tag set to the span name that the engine's span
+// namer derives from ctx. When the engine samples metrics, the sampling
+// decisions of the returned engine are taken from the seed that the engine's
+// sample seeder derives from ctx.
+//
+// The method returns eng if neither a span name nor a sampling seed could be
+// derived from ctx.
+func (eng *Engine) WithContext(ctx context.Context) *Engine {
+	e := eng
+
+	if eng.spans != nil {
+		if span := eng.spans.name(ctx); len(span) != 0 {
+			e = e.WithTags(Tag{"span", span})
+		}
+	}
+
+	if eng.sampler != nil {
+		if seed, ok := eng.sampler.seeder(ctx); ok {
+			e = e.WithSampleSeed(seed)
+		}
+	}
+
+	return e
+}
+
+func (eng *Engine) derive(name string, tags []Tag) *Engine {
 	return &Engine{
-		name:     eng.name,
-		tags:     concatTags(eng.tags, tags),
-		handlers: eng.Handlers(),
+		name:        name,
+		tags:        tags,
+		handlers:    eng.Handlers(),
+		schema:      eng.schema,
+		spans:       eng.spans,
+		allow:       eng.allow,
+		level:       eng.level,
+		verbose:     eng.verbose,
+		flush:       eng.flush,
+		classify:    eng.classify,
+		lazy:        eng.lazy,
+		queue:       eng.queue,
+		shard:       eng.shard,
+		shards:      eng.shards,
+		aggregates:  eng.aggregates,
+		reported:    eng.reported,
+		limits:      eng.limits,
+		nonFinite:   eng.nonFinite,
+		health:      eng.health,
+		tagCase:     eng.tagCase,
+		idempotency: eng.idempotency,
+		sampler:     eng.sampler,
+		seed:        eng.seed,
+		seeded:      eng.seeded,
+		outliers:    eng.outliers,
+		scoped:      eng.scoped,
 	}
 }
 
-// Flush flushes all handlers of eng that implement the Flusher interface.
+// Flush flushes all handlers of eng that implement the Flusher or the
+// ContextFlusher interfaces.
+//
+// The engines of tenants only flush their handlers, the aggregates, queue
+// latency, dropped metrics, and health that they share with the engine they
+// were derived from are reported when that engine is flushed.
 func (eng *Engine) Flush() {
+	if !eng.scoped {
+		eng.report()
+	}
+
+	complete := true
+	eng.hmutex.RLock()
+
+	for _, h := range eng.handlers {
+		if !eng.flush.handler(h) {
+			complete = false
+		}
+	}
+
+	eng.hmutex.RUnlock()
+
+	if complete && !eng.scoped {
+		eng.flush.complete(time.Now())
+	}
+}
+
+// report produces the metrics on the state of eng which are reported on every
+// flush.
+func (eng *Engine) report() {
+	if eng.aggregates != nil {
+		eng.reportAggregates()
+	}
+
+	if eng.queue != nil {
+		eng.reportQueueLatency()
+	}
+
+	if eng.reported != nil {
+		eng.reportDropped()
+	}
+
+	if eng.health != nil {
+		eng.reportHealth()
+	}
+
+	if eng.shards != nil {
+		eng.shards.expire()
+	}
+}
+
+// FlushTimeouts returns the number of handler flushes that were abandoned
+// because they exceeded the flush timeout of eng.
+func (eng *Engine) FlushTimeouts() int64 {
+	return eng.flush.timedOut()
+}
+
+// Reset discards the state accumulated by all handlers of eng that implement
+// the Resetter interface, the handlers and configuration of the engine are
+// retained.
+//
+// The method is intended to isolate tests sharing an engine from each other,
+// it discards data that the handlers may not have published yet and should
+// not be used in production code.
+func (eng *Engine) Reset() {
+	eng.hmutex.RLock()
+
+	for _, h := range eng.handlers {
+		if r, ok := h.(Resetter); ok {
+			r.Reset()
+		}
+	}
+
+	eng.hmutex.RUnlock()
+}
+
+// Describe declares a metric on eng, setting its help text and unit.
+//
+// Metrics declared this way are reported by the Schema method even if they
+// were never produced by the program.
+func (eng *Engine) Describe(typ MetricType, name string, help string, unit string, keys ...string) {
+	eng.describe(MetricSchema{
+		Type:      typ,
+		Namespace: eng.name,
+		Name:      name,
+		Help:      help,
+		Unit:      unit,
+		TagKeys:   append(tagKeys(eng.tags), keys...),
+	})
+}
+
+// describeHelp sets the help text of the metric of type typ with name on eng.
+func (eng *Engine) describeHelp(typ MetricType, name string, help string) {
+	eng.describe(MetricSchema{
+		Type:      typ,
+		Namespace: eng.name,
+		Name:      name,
+		Help:      help,
+	})
+}
+
+// SetBuckets sets the upper limits of the buckets of the histograms with names
+// matching pattern, see EngineConfig.Buckets. It can be called while the engine
+// is in use, histograms which were already declared or produced are described
+// again to the handlers with their new buckets.
+func (eng *Engine) SetBuckets(pattern string, limits []float64) {
+	for _, s := range eng.schema.setBuckets(pattern, sortedBuckets(limits)) {
+		eng.announce(s)
+	}
+}
+
+// describe records s in the schema of eng, and passes the help text, unit,
+// objectives, and buckets to the handlers implementing the Describer interface.
+func (eng *Engine) describe(s MetricSchema) {
+	if d := eng.schema.describe(s); d != nil {
+		s = *d
+	} else if !s.described() {
+		return
+	}
+
+	eng.announce(s)
+}
+
+// declare records s in the schema of eng, the handlers implementing the
+// Describer interface are only passed the buckets configured for new
+// histograms.
+func (eng *Engine) declare(s MetricSchema) {
+	if d := eng.schema.describe(s); d != nil {
+		eng.announce(*d)
+	}
+}
+
+// announce passes s to the handlers implementing the Describer interface.
+func (eng *Engine) announce(s MetricSchema) {
 	eng.hmutex.RLock()
 
 	for _, h := range eng.handlers {
-		if f, ok := h.(Flusher); ok {
-			f.Flush()
+		if d, ok := h.(Describer); ok {
+			d.DescribeMetric(s)
 		}
 	}
 
 	eng.hmutex.RUnlock()
 }
 
+// Schema returns the list of metrics that were declared or produced on eng,
+// sorted by name.
+//
+// The schema is shared between eng and the engines derived from it with the
+// WithName and WithTags methods.
+func (eng *Engine) Schema() []MetricSchema {
+	return eng.schema.schema()
+}
+
 // Counter creates a new counter producing a metric with name and tag on eng.
 func (eng *Engine) Counter(name string, tags ...Tag) *Counter {
+	eng.schema.describe(MetricSchema{
+		Type:      CounterType,
+		Namespace: eng.name,
+		Name:      name,
+		TagKeys:   tagKeys(eng.tags, tags),
+	})
 	return &Counter{
 		eng:  eng,
 		name: name,
@@ -109,6 +577,12 @@ func (eng *Engine) Counter(name string, tags ...Tag) *Counter {
 
 // Gauge creates a new gauge producing a metric with name and tag on eng.
 func (eng *Engine) Gauge(name string, tags ...Tag) *Gauge {
+	eng.schema.describe(MetricSchema{
+		Type:      GaugeType,
+		Namespace: eng.name,
+		Name:      name,
+		TagKeys:   tagKeys(eng.tags, tags),
+	})
 	return &Gauge{
 		eng:  eng,
 		name: name,
@@ -118,15 +592,71 @@ func (eng *Engine) Gauge(name string, tags ...Tag) *Gauge {
 
 // Histogram creates a new hitsogram producing a metric with name and tag on eng.
 func (eng *Engine) Histogram(name string, tags ...Tag) *Histogram {
+	eng.declare(MetricSchema{
+		Type:      HistogramType,
+		Namespace: eng.name,
+		Name:      name,
+		TagKeys:   tagKeys(eng.tags, tags),
+	})
 	return &Histogram{
 		eng:  eng,
 		name: name,
 		tags: copyTags(tags),
+		kind: &histogramKind{},
+	}
+}
+
+// Summary creates a new summary producing a metric with name and tag on eng,
+// handlers supporting summaries estimate the quantiles of objectives, or of
+// DefaultObjectives if the list is empty.
+func (eng *Engine) Summary(name string, objectives []Objective, tags ...Tag) *Summary {
+	if len(objectives) == 0 {
+		objectives = DefaultObjectives
+	}
+	eng.describe(MetricSchema{
+		Type:       SummaryType,
+		Namespace:  eng.name,
+		Name:       name,
+		TagKeys:    tagKeys(eng.tags, tags),
+		Objectives: objectives,
+	})
+	return &Summary{
+		eng:  eng,
+		name: name,
+		tags: copyTags(tags),
+	}
+}
+
+// ExponentialHistogram creates a new exponential histogram producing a metric
+// with name and tag on eng, handlers supporting exponential histograms use at
+// most maxBuckets buckets for each sign of the observed values, or
+// DefaultExponentialMaxBuckets if it is zero or negative.
+func (eng *Engine) ExponentialHistogram(name string, maxBuckets int, tags ...Tag) *ExponentialHistogram {
+	if maxBuckets <= 0 {
+		maxBuckets = DefaultExponentialMaxBuckets
+	}
+	eng.describe(MetricSchema{
+		Type:       ExponentialHistogramType,
+		Namespace:  eng.name,
+		Name:       name,
+		TagKeys:    tagKeys(eng.tags, tags),
+		MaxBuckets: maxBuckets,
+	})
+	return &ExponentialHistogram{
+		eng:  eng,
+		name: name,
+		tags: copyTags(tags),
 	}
 }
 
 // Timer creates a new timer producing metrics with name and tag on eng.
 func (eng *Engine) Timer(name string, tags ...Tag) *Timer {
+	eng.declare(MetricSchema{
+		Type:      HistogramType,
+		Namespace: eng.name,
+		Name:      name,
+		TagKeys:   tagKeys(eng.tags, tags, []Tag{{"stamp", ""}}),
+	})
 	return &Timer{
 		eng:  eng,
 		name: name,
@@ -156,40 +686,164 @@ func (eng *Engine) Clock(name string, tags ...Tag) *Clock {
 
 // Incr increments by 1 the counter with name and tags on eng.
 func (eng *Engine) Incr(name string, tags ...Tag) {
-	eng.handle(CounterType, name, 1, tags, time.Time{})
+	eng.handle(CounterType, name, 1, "", tags, time.Time{}, time.Time{}, nil)
 }
 
 // Add adds value to the counter with name and tags on eng.
 func (eng *Engine) Add(name string, value float64, tags ...Tag) {
-	eng.handle(CounterType, name, value, tags, time.Time{})
+	eng.handle(CounterType, name, value, "", tags, time.Time{}, time.Time{}, nil)
 }
 
 // Set sets the gauge with name and tags on eng to value.
 func (eng *Engine) Set(name string, value float64, tags ...Tag) {
-	eng.handle(GaugeType, name, value, tags, time.Time{})
+	eng.handle(GaugeType, name, value, "", tags, time.Time{}, time.Time{}, nil)
+}
+
+// SetUntil sets the gauge with name and tags on eng to value, which is valid
+// until the given time.
+//
+// This is useful for gauges representing a prediction or a reservation, like
+// capacity reserved until a deadline: handlers retaining the state of series
+// stop exposing the series once it expired, unless it was set again with a
+// later expiration. Unlike the expiration of inactive gauges, the expiration
+// is declared by the metric and does not depend on how often it is updated.
+// See Metric.Expires.
+func (eng *Engine) SetUntil(name string, value float64, until time.Time, tags ...Tag) {
+	eng.handle(GaugeType, name, value, "", tags, time.Time{}, until, nil)
 }
 
 // Observe reports a value on the histogram with name and tags on eng.
 func (eng *Engine) Observe(name string, value float64, tags ...Tag) {
-	eng.handle(HistogramType, name, value, tags, time.Time{})
+	eng.handle(HistogramType, name, value, "", tags, time.Time{}, time.Time{}, nil)
+}
+
+// ObserveWithExemplar reports a value on the histogram with name and tags on eng,
+// with an exemplar identified by the exemplar tags, like a trace id, see
+// Metric.Exemplar.
+func (eng *Engine) ObserveWithExemplar(name string, value float64, exemplar []Tag, tags ...Tag) {
+	eng.handle(HistogramType, name, value, "", tags, time.Time{}, time.Time{}, exemplar)
 }
 
 // ObserveDuration reports a duration in seconds to the histogram with name and
 // tags on eng.
 func (eng *Engine) ObserveDuration(name string, value time.Duration, tags ...Tag) {
-	eng.handle(HistogramType, name, value.Seconds(), tags, time.Time{})
+	eng.handle(HistogramType, name, value.Seconds(), "seconds", tags, time.Time{}, time.Time{}, nil)
 }
 
-func (eng *Engine) handle(typ MetricType, name string, value float64, tags []Tag, time time.Time) {
+// IncrAndObserve increments by 1 the counter named counter and reports value
+// on the histogram named histogram, both with tags on eng.
+//
+// The method is equivalent to calling Incr and Observe with the same tags but
+// only assembles the tags and acquires the handlers once, which is useful for
+// instrumenting requests where both a count and a latency are reported.
+func (eng *Engine) IncrAndObserve(counter string, histogram string, value float64, tags ...Tag) {
+	if !eng.enabled() {
+		return
+	}
+
+	if !isFinite(value) {
+		var ok bool
+		if value, ok = eng.nonFinite.check(eng.name, histogram, value); !ok {
+			return
+		}
+	}
+
+	if eng.aggregates != nil || eng.limits != nil || eng.sampler != nil || eng.outliers != nil || (eng.shards != nil && len(eng.shard) != 0) {
+		// Aggregations, observation limits, sampling, outliers, and shard
+		// aggregates are applied by the handle method.
+		eng.Incr(counter, tags...)
+		eng.Observe(histogram, value, tags...)
+		return
+	}
+
+	metric := metricPool.Get().(*Metric)
+
+	metric.Namespace = eng.name
+	metric.Tags = eng.appendTags(metric.Tags, tags)
+	metric.Time = time.Time{}
+	metric.Unit = ""
+	metric.Rate = 0
+	metric.Expires = time.Time{}
+	metric.Exemplar = nil
+
+	if eng.queue != nil {
+		metric.Time = eng.queue.enqueue(2)
+	}
+
+	if eng.allow != nil {
+		eng.allow.rewrite(metric.Namespace, counter, metric.Tags)
+	}
+
+	eng.schema.observe(CounterType, metric.Namespace, counter, metric.Tags)
+
+	if d := eng.schema.observe(HistogramType, metric.Namespace, histogram, metric.Tags); d != nil {
+		eng.announce(*d)
+	}
+
+	eng.hmutex.RLock()
+
+	for _, handler := range eng.handlers {
+		metric.Type, metric.Name, metric.Value = CounterType, counter, 1
+		handler.HandleMetric(metric)
+		metric.Type, metric.Name, metric.Value = HistogramType, histogram, value
+		handler.HandleMetric(metric)
+	}
+
+	eng.hmutex.RUnlock()
+
+	metric.Namespace = ""
+	metric.Name = ""
+	metric.Tags = metric.Tags[:0]
+	metricPool.Put(metric)
+}
+
+func (eng *Engine) handle(typ MetricType, name string, value float64, unit string, tags []Tag, time time.Time, expires time.Time, exemplar []Tag) {
+	if !eng.enabled() {
+		return
+	}
+
+	value, rate, outlier, ok := eng.admit(typ, name, value)
+
+	if outlier {
+		defer eng.handle(CounterType, name+OutlierSuffix, 1, "", tags, time, expires, nil)
+	}
+
+	if !ok {
+		return
+	}
+
 	metric := metricPool.Get().(*Metric)
 
 	metric.Namespace = eng.name
 	metric.Type = typ
 	metric.Name = name
 	metric.Value = value
-	metric.Tags = append(metric.Tags, eng.tags...)
-	metric.Tags = append(metric.Tags, tags...)
+	metric.Unit = unit
+	metric.Rate = rate
+	metric.Tags = eng.appendTags(metric.Tags, tags)
 	metric.Time = time
+	metric.Expires = expires
+	metric.Exemplar = exemplar
+
+	if eng.queue != nil && time.IsZero() {
+		metric.Time = eng.queue.enqueue(1)
+	}
+
+	if eng.allow != nil {
+		eng.allow.rewrite(metric.Namespace, name, metric.Tags)
+	}
+
+	if d := eng.schema.observe(typ, metric.Namespace, name, metric.Tags); d != nil {
+		eng.announce(*d)
+	}
+
+	if eng.aggregates != nil && eng.aggregates.add(metric) {
+		metric.Namespace = ""
+		metric.Name = ""
+		metric.Tags = metric.Tags[:0]
+		metricPool.Put(metric)
+		return
+	}
 
 	eng.hmutex.RLock()
 
@@ -197,6 +851,20 @@ func (eng *Engine) handle(typ MetricType, name string, value float64, tags []Tag
 		handler.HandleMetric(metric)
 	}
 
+	if eng.shards != nil && len(eng.shard) != 0 {
+		aggregate := metricPool.Get().(*Metric)
+		eng.shards.aggregate(aggregate, metric, eng.shard)
+
+		for _, handler := range eng.handlers {
+			handler.HandleMetric(aggregate)
+		}
+
+		aggregate.Namespace = ""
+		aggregate.Name = ""
+		aggregate.Tags = aggregate.Tags[:0]
+		metricPool.Put(aggregate)
+	}
+
 	eng.hmutex.RUnlock()
 
 	metric.Namespace = ""
@@ -205,6 +873,40 @@ func (eng *Engine) handle(typ MetricType, name string, value float64, tags []Tag
 	metricPool.Put(metric)
 }
 
+// admit applies the non-finite policy, outlier detection, observation limits,
+// and sampling of eng to a metric of type typ with name and value. It returns
+// the value and sample rate to report, whether the value is an outlier, and
+// false if the metric must be discarded. Outliers are detected before limits
+// and sampling are applied so their counters stay exact.
+func (eng *Engine) admit(typ MetricType, name string, value float64) (float64, float64, bool, bool) {
+	if !isFinite(value) {
+		var ok bool
+		if value, ok = eng.nonFinite.check(eng.name, name, value); !ok {
+			return value, 0, false, false
+		}
+	}
+
+	outlier := eng.outliers != nil && typ == HistogramType && eng.outliers.outlier(name, value)
+	rate := 0.0
+
+	if eng.limits != nil && typ == HistogramType {
+		var ok bool
+		if rate, ok = eng.limits.allow(name); !ok {
+			return value, 0, outlier, false
+		}
+	}
+
+	if eng.sampler != nil {
+		r, ok := eng.sampler.sample(name, eng.seed, eng.seeded)
+		if !ok {
+			return value, 0, outlier, false
+		}
+		rate = combineRates(rate, r)
+	}
+
+	return value, rate, outlier, true
+}
+
 // C returns a new counter that produces a metric with name and tags on the
 // default engine.
 func C(name string, tags ...Tag) *Counter {
@@ -223,6 +925,12 @@ func H(name string, tags ...Tag) *Histogram {
 	return DefaultEngine.Histogram(name, tags...)
 }
 
+// MakeSummary returns a new summary that produces a metric with name and tags
+// on the default engine.
+func MakeSummary(name string, objectives []Objective, tags ...Tag) *Summary {
+	return DefaultEngine.Summary(name, objectives, tags...)
+}
+
 // T returns a new timer that produces a metric with name and tags on the
 // default engine.
 func T(name string, tags ...Tag) *Timer {
@@ -247,6 +955,12 @@ func Set(name string, value float64, tags ...Tag) {
 	DefaultEngine.Set(name, value, tags...)
 }
 
+// SetUntil sets the value of the metric identified by name and tags, which is
+// valid until the given time, on the default engine. See Engine.SetUntil.
+func SetUntil(name string, value float64, until time.Time, tags ...Tag) {
+	DefaultEngine.SetUntil(name, value, until, tags...)
+}
+
 // Observe reports a value for the metric identified by name and tags, a new
 // histogram is created in the default engine if none existed.
 func Observe(name string, value float64, tags ...Tag) {
@@ -265,6 +979,12 @@ func Time(name string, start time.Time, tags ...Tag) *Clock {
 	return DefaultEngine.Timer(name, tags...).StartAt(start)
 }
 
+// IncrAndObserve increments by 1 the counter named counter and reports value on
+// the histogram named histogram, both with tags on the default engine.
+func IncrAndObserve(counter string, histogram string, value float64, tags ...Tag) {
+	DefaultEngine.IncrAndObserve(counter, histogram, value, tags...)
+}
+
 // WithName creates a new engine which inherits the properties and handlers of
 // the default handler and uses the given name.
 func WithName(name string) *Engine {
@@ -277,6 +997,24 @@ func WithTags(tags ...Tag) *Engine {
 	return DefaultEngine.WithTags(tags...)
 }
 
+// Describe declares a metric on the default engine, setting its help text and
+// unit.
+func Describe(typ MetricType, name string, help string, unit string, keys ...string) {
+	DefaultEngine.Describe(typ, name, help, unit, keys...)
+}
+
+// Schema returns the list of metrics declared or produced on the default
+// engine.
+func Schema() []MetricSchema {
+	return DefaultEngine.Schema()
+}
+
+// WithContext creates a new engine which inherits the properties and handlers
+// of the default engine and adds a "span" tag derived from ctx.
+func WithContext(ctx context.Context) *Engine {
+	return DefaultEngine.WithContext(ctx)
+}
+
 // Register adds handler to the default engine.
 func Register(handler Handler) {
 	DefaultEngine.Register(handler)
@@ -287,6 +1025,12 @@ func Flush() {
 	DefaultEngine.Flush()
 }
 
+// Reset discards the state accumulated by the handlers of the default engine,
+// it should only be used to isolate tests from each other.
+func Reset() {
+	DefaultEngine.Reset()
+}
+
 func progname() (name string) {
 	if args := os.Args; len(args) != 0 {
 		name = filepath.Base(args[0])
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"math"
 	"reflect"
 	"testing"
 	"time"
@@ -77,6 +78,26 @@ func TestEngineFlush(t *testing.T) {
 	}
 }
 
+func TestEngineReset(t *testing.T) {
+	h := &handler{}
+	eng := NewEngine("E")
+	eng.Register(h)
+	eng.Register(HandlerFunc(func(*Metric) {}))
+
+	eng.Incr("A")
+	eng.Reset()
+
+	if len(h.metrics) != 0 {
+		t.Error("the handler was not reset:", h.metrics)
+	}
+
+	eng.Incr("B")
+
+	if !reflect.DeepEqual(h.metrics, []Metric{{Type: CounterType, Namespace: "E", Name: "B", Value: 1}}) {
+		t.Error("bad metrics after reset:", h.metrics)
+	}
+}
+
 func TestEngineAdd(t *testing.T) {
 	h := &handler{}
 	e := NewEngine("E", Tag{"base", "tag"})
@@ -149,6 +170,34 @@ func TestEngineSet(t *testing.T) {
 	}
 }
 
+func TestEngineSetUntil(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	until := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.SetUntil("A", 1, until)
+	e.Set("B", 2)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     1,
+			Expires:   until,
+		},
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "B",
+			Value:     2,
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
 func TestEngineObserve(t *testing.T) {
 	h := &handler{}
 	e := NewEngine("E", Tag{"base", "tag"})
@@ -200,6 +249,7 @@ func TestEngineObserveDuration(t *testing.T) {
 			Namespace: "E",
 			Name:      "A",
 			Value:     1,
+			Unit:      "seconds",
 			Tags:      []Tag{{"base", "tag"}},
 		},
 		{
@@ -207,6 +257,7 @@ func TestEngineObserveDuration(t *testing.T) {
 			Namespace: "E",
 			Name:      "B",
 			Value:     2,
+			Unit:      "seconds",
 			Tags:      []Tag{{"base", "tag"}},
 		},
 		{
@@ -214,6 +265,7 @@ func TestEngineObserveDuration(t *testing.T) {
 			Namespace: "E",
 			Name:      "C",
 			Value:     3,
+			Unit:      "seconds",
 			Tags:      []Tag{{"base", "tag"}, {"extra", "tag"}},
 		},
 	}) {
@@ -221,6 +273,37 @@ func TestEngineObserveDuration(t *testing.T) {
 	}
 }
 
+func TestEngineIncrAndObserve(t *testing.T) {
+	h1 := &handler{}
+	h2 := &handler{}
+	e := NewEngine("E", Tag{"base", "tag"})
+	e.Register(h1)
+	e.Register(h2)
+
+	e.IncrAndObserve("A", "B", 2, Tag{"extra", "tag"})
+
+	for _, h := range []*handler{h1, h2} {
+		if !reflect.DeepEqual(h.metrics, []Metric{
+			{
+				Type:      CounterType,
+				Namespace: "E",
+				Name:      "A",
+				Value:     1,
+				Tags:      []Tag{{"base", "tag"}, {"extra", "tag"}},
+			},
+			{
+				Type:      HistogramType,
+				Namespace: "E",
+				Name:      "B",
+				Value:     2,
+				Tags:      []Tag{{"base", "tag"}, {"extra", "tag"}},
+			},
+		}) {
+			t.Error("bad metrics:", h.metrics)
+		}
+	}
+}
+
 func TestEngineCounter(t *testing.T) {
 	e := NewEngine("E", Tag{"base", "tag"})
 	c := e.Counter("C", Tag{"extra", "tag"})
@@ -272,3 +355,43 @@ func TestEngineTimer(t *testing.T) {
 		t.Error("bad timer tags:", tags)
 	}
 }
+
+func TestEngineZeroValue(t *testing.T) {
+	h := &handler{}
+
+	var eng Engine
+	eng.Register(h)
+
+	eng.Describe(CounterType, "A", "Number of A.", "")
+	eng.SetBuckets("C", []float64{1, 2})
+	eng.Incr("A")
+	eng.Add("A", 2)
+	eng.Set("B", 1)
+	eng.Observe("C", 1)
+	eng.Observe("C", math.NaN())
+	eng.ObserveDuration("D", time.Second)
+	eng.IncrAndObserve("E", "F", 1)
+	eng.Counter("G").Incr()
+	eng.Gauge("H").Set(1)
+	eng.Histogram("I").Observe(1)
+	eng.ExponentialHistogram("J", 0).Observe(1)
+	eng.AddOnce("key", "K", 1)
+	eng.SuccessRate("L").WithRatio().Record(true)
+	eng.WithTags(Tag{"a", "1"}).Incr("M")
+	eng.WithLevel(1).Incr("N")
+	eng.SetVerbosity(1)
+	eng.Verbosity()
+
+	b := eng.Batch()
+	b.Incr("O")
+	b.Commit()
+
+	eng.Flush()
+	eng.Stats()
+	eng.FlushTimeouts()
+	eng.Schema()
+
+	if len(h.metrics) == 0 || h.flushed != 1 {
+		t.Errorf("bad state of the handler: %d metrics, %d flushes", len(h.metrics), h.flushed)
+	}
+}
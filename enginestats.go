@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DroppedMetricName is the name of the counter reported by engines configured
+// to report the number of metrics discarded by the engines and their handlers.
+const DroppedMetricName = "stats.engine.dropped"
+
+// HealthMetricPrefix is the prefix of the names of the metrics reported by
+// engines configured with ReportHealth:
+//
+//   - stats.engine.buffer_usage is a gauge reporting BufferUsage
+//   - stats.engine.active_flushes is a gauge reporting ActiveFlushes
+//   - stats.engine.last_flush_age is a gauge reporting the number of seconds
+//     since LastFlush, it is not reported before the first complete flush
+//   - stats.engine.handler_errors is a counter reporting the increments of
+//     HandlerErrors since the previous flush, its rate is the error rate of
+//     the handlers
+const HealthMetricPrefix = "stats.engine."
+
+// EngineStats carries counters describing the health of an engine.
+type EngineStats struct {
+	// Dropped is the number of metrics discarded by the engine because their
+	// values were not finite, and by the handlers of the engine which
+	// implement the DropCounter interface.
+	Dropped int64
+
+	// FlushTimeouts is the number of handler flushes that were abandoned
+	// because they exceeded the flush timeout of the engine.
+	FlushTimeouts int64
+
+	// ActiveFlushes is the number of goroutines flushing handlers of the
+	// engine, which includes the goroutines of abandoned flushes that did
+	// not complete yet. Engines only start goroutines to flush handlers when
+	// they are configured with a flush timeout.
+	ActiveFlushes int64
+
+	// LastFlush is the time of the last flush which completed for all
+	// handlers of the engine, or the zero time if there were none.
+	LastFlush time.Time
+
+	// BufferUsage is the highest fraction of buffer in use among the handlers
+	// of the engine which implement the BufferReporter interface.
+	BufferUsage float64
+
+	// HandlerErrors is the number of errors encountered by the handlers of
+	// the engine which implement the ErrorCounter interface.
+	HandlerErrors int64
+}
+
+// engineHealth carries the state of the health metrics reported by engines.
+type engineHealth struct {
+	errors int64 // handler errors reported by the previous flush
+}
+
+// Stats returns counters describing the health of eng, they can be used to
+// alert when the instrumentation of a program is lossy.
+//
+// The counters are shared between eng and the engines derived from it.
+func (eng *Engine) Stats() EngineStats {
+	stats := EngineStats{
+		Dropped:       eng.dropped(),
+		FlushTimeouts: eng.FlushTimeouts(),
+		ActiveFlushes: eng.flush.flushing(),
+		LastFlush:     eng.flush.lastFlush(),
+	}
+
+	eng.hmutex.RLock()
+
+	for _, h := range eng.handlers {
+		if r, ok := h.(BufferReporter); ok {
+			if usage := r.BufferUsage(); usage > stats.BufferUsage {
+				stats.BufferUsage = usage
+			}
+		}
+		if c, ok := h.(ErrorCounter); ok {
+			stats.HandlerErrors += c.Errors()
+		}
+	}
+
+	eng.hmutex.RUnlock()
+	return stats
+}
+
+func (eng *Engine) dropped() (n int64) {
+	n = eng.nonFinite.rejectedCount()
+	eng.hmutex.RLock()
+
+	for _, h := range eng.handlers {
+		if c, ok := h.(DropCounter); ok {
+			n += c.Dropped()
+		}
+	}
+
+	eng.hmutex.RUnlock()
+	return
+}
+
+// reportDropped reports the number of metrics dropped since the last flush on
+// the DroppedMetricName counter.
+func (eng *Engine) reportDropped() {
+	dropped := eng.dropped()
+
+	if n := dropped - atomic.SwapInt64(eng.reported, dropped); n > 0 {
+		eng.Add(DroppedMetricName, float64(n))
+	}
+}
+
+// reportHealth reports the metrics described by HealthMetricPrefix.
+func (eng *Engine) reportHealth() {
+	stats := eng.Stats()
+	eng.Set(HealthMetricPrefix+"buffer_usage", stats.BufferUsage)
+	eng.Set(HealthMetricPrefix+"active_flushes", float64(stats.ActiveFlushes))
+
+	if !stats.LastFlush.IsZero() {
+		eng.Set(HealthMetricPrefix+"last_flush_age", time.Since(stats.LastFlush).Seconds())
+	}
+
+	if n := stats.HandlerErrors - atomic.SwapInt64(&eng.health.errors, stats.HandlerErrors); n > 0 {
+		eng.Add(HealthMetricPrefix+"handler_errors", float64(n))
+	}
+}
+
+// Stats returns counters describing the health of the default engine.
+func Stats() EngineStats {
+	return DefaultEngine.Stats()
+}
@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type dropHandler struct {
+	handler
+	dropped int64
+}
+
+func (h *dropHandler) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+func TestEngineStats(t *testing.T) {
+	h1 := &dropHandler{}
+	h2 := &dropHandler{}
+	e := NewEngineWith(EngineConfig{
+		Name:          "E",
+		ReportDropped: true,
+	})
+	e.Register(h1)
+	e.Register(h2)
+	e.Register(&handler{})
+
+	h1.dropped = 2
+	h2.dropped = 3
+
+	if stats := e.WithName("F").Stats(); !reflect.DeepEqual(stats, EngineStats{Dropped: 5}) {
+		t.Error("bad engine stats:", stats)
+	}
+
+	e.Flush()
+	e.Flush()
+	h2.dropped = 4
+	e.Flush()
+
+	if !reflect.DeepEqual(h1.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: DroppedMetricName, Value: 5},
+		{Type: CounterType, Namespace: "E", Name: DroppedMetricName, Value: 1},
+	}) {
+		t.Error("bad metrics:", h1.metrics)
+	}
+}
+
+type healthHandler struct {
+	handler
+	usage  float64
+	errors int64
+}
+
+func (h *healthHandler) BufferUsage() float64 { return h.usage }
+
+func (h *healthHandler) Errors() int64 { return atomic.LoadInt64(&h.errors) }
+
+func TestEngineHealth(t *testing.T) {
+	h1 := &healthHandler{usage: 0.25, errors: 1}
+	h2 := &healthHandler{usage: 0.5, errors: 2}
+	e := NewEngineWith(EngineConfig{
+		Name:         "E",
+		ReportHealth: true,
+	})
+	e.Register(h1)
+	e.Register(h2)
+
+	if stats := e.Stats(); !reflect.DeepEqual(stats, EngineStats{BufferUsage: 0.5, HandlerErrors: 3}) {
+		t.Error("bad engine stats:", stats)
+	}
+
+	e.Flush()
+
+	if !reflect.DeepEqual(h1.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "stats.engine.buffer_usage", Value: 0.5},
+		{Type: GaugeType, Namespace: "E", Name: "stats.engine.active_flushes", Value: 0},
+		{Type: CounterType, Namespace: "E", Name: "stats.engine.handler_errors", Value: 3},
+	}) {
+		t.Error("bad metrics of the first flush:", h1.metrics)
+	}
+
+	if e.Stats().LastFlush.IsZero() {
+		t.Error("the time of the last flush was not recorded")
+	}
+
+	h1.Reset()
+	h2.errors = 4
+	e.Flush()
+
+	var names []string
+	for _, m := range h1.metrics {
+		names = append(names, m.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{
+		"stats.engine.buffer_usage",
+		"stats.engine.active_flushes",
+		"stats.engine.last_flush_age",
+		"stats.engine.handler_errors",
+	}) {
+		t.Error("bad metrics of the second flush:", h1.metrics)
+	}
+
+	if m := h1.metrics[3]; m.Value != 2 {
+		t.Error("bad increment of handler errors:", m.Value)
+	}
+}
+
+func TestEngineStatsActiveFlushes(t *testing.T) {
+	release := make(chan struct{})
+	e := NewEngineWith(EngineConfig{FlushTimeout: time.Millisecond})
+	e.Register(&slowHandler{release: release})
+	e.Flush()
+
+	stats := e.Stats()
+
+	if stats.ActiveFlushes != 1 || stats.FlushTimeouts != 1 {
+		t.Error("bad engine stats with an abandoned flush:", stats)
+	}
+
+	if !stats.LastFlush.IsZero() {
+		t.Error("an incomplete flush was recorded as the last flush")
+	}
+
+	close(release)
+}
+
+type slowHandler struct {
+	handler
+	release chan struct{}
+}
+
+func (h *slowHandler) Flush() { <-h.release }
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package stats
+
+// Enum is the constraint of the types of values accepted by enum tags, they
+// are typically integer or string types with a fixed set of constants.
+type Enum interface {
+	comparable
+	String() string
+}
+
+// EnumTag is the name of a tag whose values must be of the enum type E, which
+// prevents passing values from other types, or typos in raw strings, and
+// fragmenting the series of metrics.
+//
+// Enum tags are declared once and used to produce tags from the constants of
+// the enum type:
+//
+//	type Method int
+//
+//	const (
+//		Get Method = iota
+//		Post
+//	)
+//
+//	func (m Method) String() string { ... }
+//
+//	var MethodTag = stats.EnumTag[Method]("method")
+//
+//	stats.Incr("requests", MethodTag.Tag(Get))
+//
+// Note that untyped constants are still implicitly converted to E by the
+// compiler, so the constants of the enum type should always be used.
+type EnumTag[E Enum] string
+
+// Tag returns a tag with the name of t, and the string representation of value
+// as value.
+func (t EnumTag[E]) Tag(value E) Tag {
+	return Tag{Name: string(t), Value: value.String()}
+}
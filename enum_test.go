@@ -0,0 +1,44 @@
+//go:build go1.18
+// +build go1.18
+
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testMethod int
+
+const (
+	testGet testMethod = iota
+	testPost
+)
+
+func (m testMethod) String() string {
+	switch m {
+	case testGet:
+		return "GET"
+	case testPost:
+		return "POST"
+	default:
+		return "unknown"
+	}
+}
+
+func TestEnumTag(t *testing.T) {
+	method := EnumTag[testMethod]("method")
+
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+	e.Incr("requests", method.Tag(testGet))
+	e.Incr("requests", method.Tag(testPost))
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1, Tags: []Tag{{"method", "GET"}}},
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1, Tags: []Tag{{"method", "POST"}}},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"errors"
+	"reflect"
+)
+
+// ErrorMetricName is the name of the counter incremented by RecordError.
+const ErrorMetricName = "errors"
+
+// ErrorClassifier is the signature of functions used by engines to derive the
+// category of errors reported with RecordError.
+//
+// Categories become tag values and must therefore have a low cardinality,
+// classifiers should never return error messages.
+type ErrorClassifier func(err error) string
+
+// ErrorTypeName is the default error classifier, it returns the name of the
+// concrete type of err, for example "*os.PathError".
+func ErrorTypeName(err error) string {
+	return reflect.TypeOf(err).String()
+}
+
+// ErrorCategory associates a category name to a target error matched with
+// errors.Is.
+type ErrorCategory struct {
+	Name   string
+	Target error
+}
+
+// ErrorCategories returns a classifier which returns the name of the first
+// category with a target matching the errors it receives according to
+// errors.Is, or the result of calling fallback if none matched.
+//
+// When fallback is nil ErrorTypeName is used.
+func ErrorCategories(fallback ErrorClassifier, categories ...ErrorCategory) ErrorClassifier {
+	if fallback == nil {
+		fallback = ErrorTypeName
+	}
+
+	categories = append([]ErrorCategory(nil), categories...)
+
+	return func(err error) string {
+		for _, c := range categories {
+			if errors.Is(err, c.Target) {
+				return c.Name
+			}
+		}
+		return fallback(err)
+	}
+}
+
+// RecordError increments the ErrorMetricName counter of eng with the given
+// tags, adding an "error_category" tag set to the category that the engine's
+// error classifier derives from err, and an "error_type" tag set to the name
+// of the concrete type of err.
+//
+// The function does nothing if err is nil, which makes it convenient to call
+// unconditionally after operations that may fail.
+func RecordError(eng *Engine, err error, tags ...Tag) {
+	if err == nil {
+		return
+	}
+
+	typ := ErrorTypeName(err)
+	category := typ
+
+	if eng.classify != nil {
+		category = eng.classify(err)
+	}
+
+	eng.Incr(ErrorMetricName, concatTags(tags, []Tag{{"error_category", category}, {"error_type", typ}})...)
+}
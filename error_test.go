@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRecordError(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	RecordError(e, nil)
+	RecordError(e, &os.PathError{Op: "open", Path: "/", Err: io.EOF}, Tag{"op", "read"})
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "errors",
+			Value:     1,
+			Tags:      []Tag{{"op", "read"}, {"error_category", "*fs.PathError"}, {"error_type", "*fs.PathError"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestRecordErrorClassifier(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name: "E",
+		ErrorClassifier: ErrorCategories(
+			func(error) string { return "unknown" },
+			ErrorCategory{"eof", io.EOF},
+			ErrorCategory{"not_exist", os.ErrNotExist},
+		),
+	})
+	e.Register(h)
+
+	RecordError(e.WithName("F"), fmt.Errorf("reading: %w", io.EOF))
+	RecordError(e, errors.New("oops"))
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "F",
+			Name:      "errors",
+			Value:     1,
+			Tags:      []Tag{{"error_category", "eof"}, {"error_type", "*fmt.wrapError"}},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "errors",
+			Value:     1,
+			Tags:      []Tag{{"error_category", "unknown"}, {"error_type", "*errors.errorString"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
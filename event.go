@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"context"
+	"time"
+)
+
+// MetricEvent represents a metric emitted by a producer on a channel, instead
+// of being reported directly on an engine, see Engine.CollectEvents.
+type MetricEvent struct {
+	// Type is the type of the metric.
+	Type MetricType
+
+	// Name is the name of the metric, it is reported in the namespace of the
+	// engine collecting the event.
+	Name string
+
+	// Value is the value of the metric, the increment of counters.
+	Value float64
+
+	// Unit is the unit of the value, for example the unit of durations
+	// observed on histograms.
+	Unit string
+
+	// Tags are set on the metric in addition to the tags of the engine.
+	Tags []Tag
+
+	// Time is the time at which the event occurred, handlers use the time at
+	// which they receive the metric when it is the zero time.
+	Time time.Time
+}
+
+// CollectEvents reads events from the channel and reports them on eng, until
+// the channel is closed or ctx is canceled. The method returns nil when the
+// channel was closed, and the error of ctx otherwise.
+//
+// Events are reported in the order they are read, and each event is passed
+// to the handlers of eng before the next one is read. When the handlers can't
+// keep up with the producers the channel fills up, and producers block until
+// there is room for more events, which applies backpressure on them. Producers
+// which must not block can use TrySendEvent to discard events instead.
+//
+// Events may be produced by multiple goroutines, and multiple goroutines may
+// collect events from the same channel to process them concurrently.
+func (eng *Engine) CollectEvents(ctx context.Context, events <-chan MetricEvent) error {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			eng.handle(e.Type, e.Name, e.Value, e.Unit, e.Tags, e.Time, time.Time{}, nil)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// CollectEvents reads events from the channel and reports them on the default
+// engine, see Engine.CollectEvents.
+func CollectEvents(ctx context.Context, events <-chan MetricEvent) error {
+	return DefaultEngine.CollectEvents(ctx, events)
+}
+
+// TrySendEvent sends e on the channel if it can be done without blocking, and
+// returns whether the event was sent.
+func TrySendEvent(events chan<- MetricEvent, e MetricEvent) bool {
+	select {
+	case events <- e:
+		return true
+	default:
+		return false
+	}
+}
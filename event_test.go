@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectEvents(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E", Tag{"base", "tag"})
+	e.Register(h)
+
+	events := make(chan MetricEvent, 3)
+	events <- MetricEvent{Type: CounterType, Name: "requests", Value: 1, Tags: []Tag{{"status", "200"}}}
+	events <- MetricEvent{Type: GaugeType, Name: "conns", Value: 2}
+	events <- MetricEvent{Type: HistogramType, Name: "latency", Value: 0.5, Unit: "seconds", Time: time.Now()}
+	close(events)
+
+	if err := e.CollectEvents(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"base", "tag"}, {"status", "200"}}, Value: 1},
+		{Type: GaugeType, Namespace: "E", Name: "conns", Tags: []Tag{{"base", "tag"}}, Value: 2},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Tags: []Tag{{"base", "tag"}}, Value: 0.5, Unit: "seconds"},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestCollectEventsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := NewEngine("E").CollectEvents(ctx, make(chan MetricEvent)); err != context.Canceled {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestTrySendEvent(t *testing.T) {
+	events := make(chan MetricEvent, 1)
+
+	if !TrySendEvent(events, MetricEvent{Name: "A"}) {
+		t.Error("the event was not sent on a channel with room for it")
+	}
+
+	if TrySendEvent(events, MetricEvent{Name: "B"}) {
+		t.Error("the event was sent on a full channel")
+	}
+}
@@ -0,0 +1,301 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultExampleSize is the default number of examples retained per
+	// metric by example handlers.
+	DefaultExampleSize = 10
+
+	// DefaultExampleMaxTags is the default maximum number of tags retained
+	// in each example.
+	DefaultExampleMaxTags = 16
+
+	// DefaultExampleMaxValueLength is the default maximum length of the tag
+	// values retained in examples, longer values are truncated.
+	DefaultExampleMaxValueLength = 256
+)
+
+// The ExampleConfig type is used to configure example handlers.
+type ExampleConfig struct {
+	// Tags is the list of names of the tags which are removed from metrics
+	// before they are passed to the wrapped handler, and only retained in
+	// examples. These are typically high-cardinality tags like request or
+	// user identifiers.
+	Tags []string
+
+	// Metrics is the list of names of the metrics that examples are sampled
+	// for, all metrics are sampled when it is empty.
+	Metrics []string
+
+	// Size is the maximum number of examples retained per metric, defaults
+	// to DefaultExampleSize.
+	Size int
+
+	// MaxTags is the maximum number of tags retained in each example, tags in
+	// excess are discarded. Defaults to DefaultExampleMaxTags.
+	MaxTags int
+
+	// MaxValueLength is the maximum length of the tag values retained in
+	// examples, defaults to DefaultExampleMaxValueLength.
+	MaxValueLength int
+}
+
+// MetricExample is a snapshot of a metric retained by an example handler, with
+// all its tags.
+type MetricExample struct {
+	Type      MetricType `json:"type"`
+	Namespace string     `json:"namespace,omitempty"`
+	Name      string     `json:"name"`
+	Value     float64    `json:"value"`
+	Time      time.Time  `json:"time"`
+	Tags      []Tag      `json:"tags,omitempty"`
+}
+
+// ExampleHandler is a metric handler which retains a sample of the metrics it
+// receives, with all their tags, out-of-band of the series reported to the
+// handler it wraps.
+//
+// This gives a drill-down capability on aggregated metrics without the cost
+// of high-cardinality series: the tags listed in the configuration are
+// removed from the metrics passed to the wrapped handler, and a bounded
+// number of examples carrying these tags are retained for each metric. The
+// examples of a metric are a uniform sample of all the metrics received with
+// its name (reservoir sampling), they can be retrieved with the Examples
+// method or served as JSON on a debug endpoint by the ServeHTTP method.
+type ExampleHandler struct {
+	handler Handler
+	config  ExampleConfig
+	tags    map[string]struct{}
+	metrics map[string]struct{}
+	mutex   sync.Mutex
+	samples map[string]*exampleReservoir
+	rng     *rand.Rand
+}
+
+type exampleReservoir struct {
+	count    int
+	examples []MetricExample
+}
+
+// NewExampleHandler returns a handler which passes the metrics it receives to
+// handler, and retains examples of them according to config.
+func NewExampleHandler(handler Handler, config ExampleConfig) *ExampleHandler {
+	if config.Size <= 0 {
+		config.Size = DefaultExampleSize
+	}
+
+	if config.MaxTags <= 0 {
+		config.MaxTags = DefaultExampleMaxTags
+	}
+
+	if config.MaxValueLength <= 0 {
+		config.MaxValueLength = DefaultExampleMaxValueLength
+	}
+
+	h := &ExampleHandler{
+		handler: handler,
+		config:  config,
+		tags:    make(map[string]struct{}, len(config.Tags)),
+		samples: make(map[string]*exampleReservoir),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, name := range config.Tags {
+		h.tags[name] = struct{}{}
+	}
+
+	if len(config.Metrics) != 0 {
+		h.metrics = make(map[string]struct{}, len(config.Metrics))
+
+		for _, name := range config.Metrics {
+			h.metrics[name] = struct{}{}
+		}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *ExampleHandler) HandleMetric(m *Metric) {
+	h.handle(m, h.handler)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *ExampleHandler) HandleMetrics(metrics []*Metric) {
+	forwardMetrics(h.handler, metrics, h.handle)
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *ExampleHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// handle passes the metrics produced from m to next.
+func (h *ExampleHandler) handle(m *Metric, next Handler) {
+	if _, ok := h.metrics[m.Name]; ok || h.metrics == nil {
+		h.sample(m)
+	}
+
+	if !h.hasExampleTags(m.Tags) {
+		next.HandleMetric(m)
+		return
+	}
+
+	c := metricPool.Get().(*Metric)
+	tags := c.Tags[:0]
+	*c = *m
+	c.Tags = tags
+
+	for _, t := range m.Tags {
+		if _, ok := h.tags[t.Name]; !ok {
+			c.Tags = append(c.Tags, t)
+		}
+	}
+
+	next.HandleMetric(c)
+
+	c.Namespace = ""
+	c.Name = ""
+	c.Tags = c.Tags[:0]
+	metricPool.Put(c)
+}
+
+// Flush satisfies the Flusher interface.
+func (h *ExampleHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *ExampleHandler) FlushContext(ctx context.Context) {
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface, it discards the examples retained
+// by the handler.
+func (h *ExampleHandler) Reset() {
+	h.mutex.Lock()
+	h.samples = make(map[string]*exampleReservoir)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// Examples returns the examples retained by the handler, sorted by metric name
+// and time.
+func (h *ExampleHandler) Examples() []MetricExample {
+	h.mutex.Lock()
+	var examples []MetricExample
+
+	for _, r := range h.samples {
+		examples = append(examples, r.examples...)
+	}
+
+	h.mutex.Unlock()
+
+	sort.SliceStable(examples, func(i int, j int) bool {
+		if n1, n2 := examples[i].fullName(), examples[j].fullName(); n1 != n2 {
+			return n1 < n2
+		}
+		return examples[i].Time.Before(examples[j].Time)
+	})
+
+	return examples
+}
+
+// ServeHTTP satisfies the http.Handler interface, it writes the examples
+// retained by the handler as a JSON array.
+func (h *ExampleHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	examples := h.Examples()
+
+	if examples == nil {
+		examples = []MetricExample{}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(examples)
+}
+
+func (e *MetricExample) fullName() string {
+	return MetricSchema{Namespace: e.Namespace, Name: e.Name}.FullName()
+}
+
+func (h *ExampleHandler) hasExampleTags(tags []Tag) bool {
+	for _, t := range tags {
+		if _, ok := h.tags[t.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *ExampleHandler) sample(m *Metric) {
+	key := rateKey(m.Namespace, m.Name, nil)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	r := h.samples[key]
+	if r == nil {
+		r = &exampleReservoir{examples: make([]MetricExample, 0, h.config.Size)}
+		h.samples[key] = r
+	}
+
+	r.count++
+	i := len(r.examples)
+
+	if i == h.config.Size {
+		if i = h.rng.Intn(r.count); i >= len(r.examples) {
+			return
+		}
+	} else {
+		r.examples = append(r.examples, MetricExample{})
+	}
+
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	r.examples[i] = MetricExample{
+		Type:      m.Type,
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		Value:     m.Value,
+		Time:      t,
+		Tags:      h.exampleTags(m.Tags),
+	}
+}
+
+func (h *ExampleHandler) exampleTags(tags []Tag) []Tag {
+	if len(tags) > h.config.MaxTags {
+		tags = tags[:h.config.MaxTags]
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	c := make([]Tag, len(tags))
+
+	for i, t := range tags {
+		if len(t.Value) > h.config.MaxValueLength {
+			t.Value = t.Value[:h.config.MaxValueLength]
+		}
+		c[i] = t
+	}
+
+	return c
+}
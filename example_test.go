@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExampleHandler(t *testing.T) {
+	h := &handler{}
+	x := NewExampleHandler(h, ExampleConfig{
+		Tags:           []string{"request_id"},
+		Metrics:        []string{"latency"},
+		Size:           2,
+		MaxValueLength: 4,
+	})
+
+	e := NewEngine("E")
+	e.Register(x)
+
+	now := time.Unix(1500000000, 0)
+	b := e.Batch()
+	b.Observe("latency", 1, Tag{"path", "/"}, Tag{"request_id", "123456"})
+	b.Incr("requests", Tag{"request_id", "123456"})
+	b.CommitAt(now)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "latency", Tags: []Tag{{"path", "/"}}, Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if examples := x.Examples(); !reflect.DeepEqual(examples, []MetricExample{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "latency",
+			Value:     1,
+			Time:      now,
+			Tags:      []Tag{{"path", "/"}, {"request_id", "1234"}},
+		},
+	}) {
+		t.Error("bad examples:", examples)
+	}
+
+	for i := 0; i != 100; i++ {
+		e.Observe("latency", float64(i), Tag{"request_id", "42"})
+	}
+
+	if n := len(x.Examples()); n != 2 {
+		t.Error("bad number of examples:", n)
+	}
+
+	res := httptest.NewRecorder()
+	x.ServeHTTP(res, httptest.NewRequest("GET", "/debug/examples", nil))
+
+	var examples []MetricExample
+	if err := json.NewDecoder(res.Body).Decode(&examples); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(examples) != 2 || examples[0].Name != "latency" {
+		t.Error("bad examples served over http:", examples)
+	}
+
+	x.Reset()
+
+	res = httptest.NewRecorder()
+	x.ServeHTTP(res, httptest.NewRequest("GET", "/debug/examples", nil))
+
+	if s := strings.TrimSpace(res.Body.String()); s != "[]" {
+		t.Error("bad examples after reset:", s)
+	}
+}
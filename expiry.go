@@ -0,0 +1,186 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GaugeExpiry configures the inactivity timeout of a gauge reported to a
+// handler returned by NewGaugeExpiryHandler.
+type GaugeExpiry struct {
+	// Name is the name of the gauge that the timeout applies to.
+	Name string
+
+	// Timeout is the duration after which a series of the gauge that was not
+	// set is considered inactive.
+	Timeout time.Duration
+
+	// Default is the value that inactive series are reset to.
+	Default float64
+
+	// Remove is set to stop reporting inactive series instead of resetting
+	// them to Default, their last value is reported a final time with
+	// Expires set so handlers retaining the state of series drop them.
+	Remove bool
+}
+
+type gaugeExpiryHandler struct {
+	handler Handler
+	gauges  map[string]GaugeExpiry
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]*gaugeExpiryEntry
+}
+
+type gaugeExpiryEntry struct {
+	namespace string
+	name      string
+	tags      []Tag
+	value     float64
+	last      time.Time
+}
+
+// NewGaugeExpiryHandler returns a handler which passes the metrics it receives
+// to handler and tracks the last time each series of the listed gauges was set.
+//
+// Every time the handler is flushed, series that have been inactive for longer
+// than the timeout of their gauge are reset: a gauge metric carrying the
+// default value is reported to handler, so backends which retain the last
+// value of gauges don't expose a stale value forever. When Remove is set the
+// series is forgotten without being reset: its last value is reported with
+// Expires set to the time of the flush, so handlers which retain the state of
+// series, like the prometheus handler, stop exposing it. Either way, the series
+// starts being tracked again the next time it is set.
+func NewGaugeExpiryHandler(handler Handler, gauges ...GaugeExpiry) Handler {
+	h := &gaugeExpiryHandler{
+		handler: handler,
+		gauges:  make(map[string]GaugeExpiry, len(gauges)),
+		now:     time.Now,
+		entries: make(map[string]*gaugeExpiryEntry),
+	}
+
+	for _, g := range gauges {
+		h.gauges[g.Name] = g
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *gaugeExpiryHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *gaugeExpiryHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *gaugeExpiryHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe records the value of the gauge of m and the time it was set.
+func (h *gaugeExpiryHandler) observe(m *Metric) {
+	if m.Type != GaugeType {
+		return
+	}
+
+	if _, ok := h.gauges[m.Name]; !ok {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+	now := h.now()
+
+	h.mutex.Lock()
+
+	e := h.entries[key]
+	if e == nil {
+		e = &gaugeExpiryEntry{
+			namespace: m.Namespace,
+			name:      m.Name,
+			tags:      tags,
+		}
+		h.entries[key] = e
+	}
+	e.value = m.Value
+	e.last = now
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+func (h *gaugeExpiryHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *gaugeExpiryHandler) FlushContext(ctx context.Context) {
+	now := h.now()
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.entries))
+	resets := make([]Metric, 0, len(h.entries))
+
+	for key, e := range h.entries {
+		if now.Sub(e.last) >= h.gauges[e.name].Timeout {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := h.entries[key]
+		g := h.gauges[e.name]
+		delete(h.entries, key)
+
+		m := Metric{
+			Type:      GaugeType,
+			Namespace: e.namespace,
+			Name:      e.name,
+			Tags:      e.tags,
+			Value:     g.Default,
+			Time:      now,
+		}
+
+		if g.Remove {
+			m.Value = e.value
+			m.Expires = now
+		}
+
+		resets = append(resets, m)
+	}
+
+	h.mutex.Unlock()
+
+	for i := range resets {
+		h.handler.HandleMetric(&resets[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *gaugeExpiryHandler) Reset() {
+	h.mutex.Lock()
+	h.entries = make(map[string]*gaugeExpiryEntry)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGaugeExpiryHandler(t *testing.T) {
+	now := time.Now()
+	h := &handler{}
+	x := NewGaugeExpiryHandler(h,
+		GaugeExpiry{Name: "conns", Timeout: 10 * time.Second},
+		GaugeExpiry{Name: "sessions", Timeout: 10 * time.Second, Remove: true},
+		GaugeExpiry{Name: "temperature", Timeout: 20 * time.Second, Default: -1},
+	)
+	x.(*gaugeExpiryHandler).now = func() time.Time { return now }
+
+	e := NewEngine("E")
+	e.Register(x)
+
+	e.Set("conns", 2, Tag{"client", "A"})
+	e.Set("conns", 3, Tag{"client", "B"})
+	e.Set("sessions", 4)
+	e.Set("temperature", 5)
+	e.Set("other", 6)
+
+	now = now.Add(5 * time.Second)
+	e.Set("conns", 1, Tag{"client", "B"})
+	e.Flush()
+	h.Reset()
+
+	now = now.Add(5 * time.Second)
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "conns", Tags: []Tag{{"client", "A"}}, Value: 0},
+		{Type: GaugeType, Namespace: "E", Name: "sessions", Value: 4, Expires: now},
+	}) {
+		t.Error("bad metrics after the first timeout:", h.metrics)
+	}
+
+	h.Reset()
+	now = now.Add(10 * time.Second)
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "conns", Tags: []Tag{{"client", "B"}}, Value: 0},
+		{Type: GaugeType, Namespace: "E", Name: "temperature", Value: -1},
+	}) {
+		t.Error("bad metrics after the second timeout:", h.metrics)
+	}
+
+	h.Reset()
+	e.Flush()
+
+	if len(h.metrics) != 0 {
+		t.Error("expired gauges were reset more than once:", h.metrics)
+	}
+
+	if h.flushed != 4 {
+		t.Error("the gauge expiry handler did not flush the underlying handler")
+	}
+}
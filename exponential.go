@@ -0,0 +1,262 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultExponentialMaxBuckets is the maximum number of buckets of each
+	// sign of exponential histograms declared without a maximum.
+	DefaultExponentialMaxBuckets = 160
+
+	// MaxExponentialScale is the scale at which exponential sketches start,
+	// the upper limit of each bucket is about 1.0000007 times its lower limit.
+	MaxExponentialScale = 20
+
+	// MinExponentialScale is the lowest scale that exponential sketches are
+	// reduced to, each bucket covers 1024 powers of two which is enough for
+	// the full range of float64 values.
+	MinExponentialScale = -10
+)
+
+// An ExponentialHistogram represents a metric that reports a distribution of
+// observed values, like a Histogram, in buckets whose limits are powers of a
+// base which handlers supporting them adjust as values are observed.
+//
+// The buckets follow the exponential histograms of OpenTelemetry: at scale s
+// the base is 2^(2^-s), and the scale is reduced when the values span more
+// buckets than the maximum, which bounds the relative error of the
+// distribution without configuring the limits of the buckets. Handlers which
+// do not support exponential histograms report them like histograms.
+type ExponentialHistogram struct {
+	eng  *Engine // the engine to produce metrics on
+	name string  // the name of the histogram
+	tags []Tag   // the tags set on the histogram
+}
+
+// Name returns the name of the histogram.
+func (h *ExponentialHistogram) Name() string {
+	return h.name
+}
+
+// Tags returns the list of tags set on the histogram.
+//
+// The method returns a reference to the histogram's internal tag slice, it does
+// not make a copy. It's expected that the program will treat this value as a
+// read-only list and won't modify its content.
+func (h *ExponentialHistogram) Tags() []Tag {
+	return h.tags
+}
+
+// WithTags returns a copy of the histogram, potentially setting tags on the
+// returned object.
+func (h *ExponentialHistogram) WithTags(tags ...Tag) *ExponentialHistogram {
+	return &ExponentialHistogram{
+		eng:  h.eng,
+		name: h.name,
+		tags: concatTags(h.tags, tags),
+	}
+}
+
+// WithHelp sets the help text of the histogram's metric, which handlers like
+// the prometheus handler expose, and returns the histogram so the call can be
+// chained with its creation.
+func (h *ExponentialHistogram) WithHelp(help string) *ExponentialHistogram {
+	h.eng.describeHelp(ExponentialHistogramType, h.name, help)
+	return h
+}
+
+// Observe reports a value observed by the histogram.
+func (h *ExponentialHistogram) Observe(value float64) {
+	h.eng.handle(ExponentialHistogramType, h.name, value, "", h.tags, time.Time{}, time.Time{}, nil)
+}
+
+// ObserveDuration reports a duration observed by the histogram, in seconds.
+func (h *ExponentialHistogram) ObserveDuration(value time.Duration) {
+	h.eng.handle(ExponentialHistogramType, h.name, value.Seconds(), "seconds", h.tags, time.Time{}, time.Time{}, nil)
+}
+
+// ExponentialSketch counts values in the buckets of exponential histograms,
+// with separate buckets for positive and negative values and a bucket for
+// zeros. The sketch starts at MaxExponentialScale and reduces its scale when
+// the values of either sign span more than its maximum number of buckets.
+//
+// ExponentialSketch values are not safe to use concurrently.
+type ExponentialSketch struct {
+	maxBuckets int
+	scale      int
+	count      uint64
+	zero       uint64
+	positive   ExponentialBuckets
+	negative   ExponentialBuckets
+}
+
+// ExponentialBuckets is a range of consecutive buckets of an exponential
+// sketch, Counts[i] is the number of values in the bucket at index Offset+i.
+// Buckets of negative values hold the absolute values.
+type ExponentialBuckets struct {
+	Offset int
+	Counts []uint64
+}
+
+// NewExponentialSketch returns a sketch with at most maxBuckets buckets for
+// each sign, DefaultExponentialMaxBuckets is used when it is zero or negative.
+func NewExponentialSketch(maxBuckets int) *ExponentialSketch {
+	if maxBuckets <= 0 {
+		maxBuckets = DefaultExponentialMaxBuckets
+	}
+
+	return &ExponentialSketch{
+		maxBuckets: maxBuckets,
+		scale:      MaxExponentialScale,
+	}
+}
+
+// Insert adds value to the sketch, NaN and infinite values are ignored.
+func (s *ExponentialSketch) Insert(value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	switch {
+	case value > 0:
+		s.insert(&s.positive, value)
+	case value < 0:
+		s.insert(&s.negative, -value)
+	default:
+		s.zero++
+	}
+
+	s.count++
+}
+
+func (s *ExponentialSketch) insert(b *ExponentialBuckets, value float64) {
+	i := ExponentialIndex(value, s.scale)
+
+	if len(b.Counts) != 0 {
+		lo, hi := b.Offset, b.Offset+len(b.Counts)-1
+		if i < lo {
+			lo = i
+		}
+		if i > hi {
+			hi = i
+		}
+
+		by := 0
+		for s.scale-by > MinExponentialScale && (hi>>uint(by))-(lo>>uint(by)) >= s.maxBuckets {
+			by++
+		}
+
+		if by != 0 {
+			s.positive = s.positive.Downscale(by)
+			s.negative = s.negative.Downscale(by)
+			s.scale -= by
+			i >>= uint(by)
+		}
+	}
+
+	b.add(i)
+}
+
+// Scale returns the current scale of the sketch.
+func (s *ExponentialSketch) Scale() int {
+	return s.scale
+}
+
+// Count returns the number of values inserted in the sketch.
+func (s *ExponentialSketch) Count() uint64 {
+	return s.count
+}
+
+// ZeroCount returns the number of zeros inserted in the sketch.
+func (s *ExponentialSketch) ZeroCount() uint64 {
+	return s.zero
+}
+
+// Positive returns a copy of the buckets of positive values.
+func (s *ExponentialSketch) Positive() ExponentialBuckets {
+	return s.positive.copy()
+}
+
+// Negative returns a copy of the buckets of negative values.
+func (s *ExponentialSketch) Negative() ExponentialBuckets {
+	return s.negative.copy()
+}
+
+// Reset discards the values inserted in the sketch and restores its scale to
+// MaxExponentialScale.
+func (s *ExponentialSketch) Reset() {
+	s.scale = MaxExponentialScale
+	s.count = 0
+	s.zero = 0
+	s.positive = ExponentialBuckets{Counts: s.positive.Counts[:0]}
+	s.negative = ExponentialBuckets{Counts: s.negative.Counts[:0]}
+}
+
+// Downscale returns the buckets of b at a scale reduced by the given number,
+// each bucket merges the 2^by buckets of b that it covers.
+func (b ExponentialBuckets) Downscale(by int) ExponentialBuckets {
+	if by <= 0 || len(b.Counts) == 0 {
+		return b
+	}
+
+	offset := b.Offset >> uint(by)
+	last := (b.Offset + len(b.Counts) - 1) >> uint(by)
+	counts := make([]uint64, last-offset+1)
+
+	for i, n := range b.Counts {
+		counts[(b.Offset+i)>>uint(by)-offset] += n
+	}
+
+	return ExponentialBuckets{Offset: offset, Counts: counts}
+}
+
+func (b *ExponentialBuckets) add(i int) {
+	switch {
+	case len(b.Counts) == 0:
+		b.Offset, b.Counts = i, append(b.Counts[:0], 0)
+	case i < b.Offset:
+		counts := make([]uint64, b.Offset+len(b.Counts)-i)
+		copy(counts[b.Offset-i:], b.Counts)
+		b.Offset, b.Counts = i, counts
+	case i >= b.Offset+len(b.Counts):
+		b.Counts = append(b.Counts, make([]uint64, i-b.Offset-len(b.Counts)+1)...)
+	}
+
+	b.Counts[i-b.Offset]++
+}
+
+func (b ExponentialBuckets) copy() ExponentialBuckets {
+	return ExponentialBuckets{
+		Offset: b.Offset,
+		Counts: append([]uint64(nil), b.Counts...),
+	}
+}
+
+// ExponentialIndex returns the index of the bucket of the positive value at
+// scale, the bucket at index i holds the values greater than
+// ExponentialBound(i, scale) and lower or equal to ExponentialBound(i+1, scale).
+func ExponentialIndex(value float64, scale int) int {
+	if scale > 0 {
+		// The logarithm is exact for powers of two, which are the upper
+		// limits of buckets, other values may land in an adjacent bucket
+		// when they are within rounding errors of a limit.
+		return int(math.Ceil(math.Ldexp(math.Log2(value), scale))) - 1
+	}
+
+	frac, exp := math.Frexp(value)
+
+	if frac == 0.5 {
+		// Powers of two are the upper limits of their buckets.
+		exp--
+	}
+
+	return (exp - 1) >> uint(-scale)
+}
+
+// ExponentialBound returns the lower limit of the bucket at index at scale,
+// which is the upper limit of the bucket at index-1.
+func ExponentialBound(index int, scale int) float64 {
+	return math.Exp2(math.Ldexp(float64(index), -scale))
+}
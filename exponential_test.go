@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEngineExponentialHistogram(t *testing.T) {
+	h := &describeHandler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	x := e.ExponentialHistogram("latency", 20, Tag{"A", "1"})
+	x.Observe(1)
+	x.WithTags(Tag{"B", "2"}).ObserveDuration(2 * time.Second)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: ExponentialHistogramType, Namespace: "E", Name: "latency", Value: 1, Tags: []Tag{{"A", "1"}}},
+		{Type: ExponentialHistogramType, Namespace: "E", Name: "latency", Value: 2, Unit: "seconds", Tags: []Tag{{"A", "1"}, {"B", "2"}}},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if len(h.schemas) != 1 || h.schemas[0].MaxBuckets != 20 {
+		t.Error("the maximum number of buckets was not passed to the handler:", h.schemas)
+	}
+
+	if d := e.ExponentialHistogram("size", 0); d.Name() != "size" || len(d.Tags()) != 0 {
+		t.Error("bad histogram:", d.Name(), d.Tags())
+	}
+
+	if schema := e.Schema(); len(schema) != 2 || schema[1].MaxBuckets != DefaultExponentialMaxBuckets {
+		t.Error("bad schema:", schema)
+	}
+}
+
+func TestExponentialIndex(t *testing.T) {
+	tests := []struct {
+		value float64
+		scale int
+		index int
+	}{
+		{value: 1, scale: 0, index: -1},
+		{value: 1.5, scale: 0, index: 0},
+		{value: 2, scale: 0, index: 0},
+		{value: 3, scale: 0, index: 1},
+		{value: 0.5, scale: 0, index: -2},
+		{value: 8, scale: -1, index: 1},
+		{value: 5, scale: -1, index: 1},
+		{value: 4, scale: -1, index: 0},
+		{value: 2, scale: 1, index: 1},
+		{value: 1.5, scale: 1, index: 1},
+		{value: 1.4, scale: 1, index: 0},
+		{value: 1024, scale: 3, index: 79},
+	}
+
+	for _, test := range tests {
+		if index := ExponentialIndex(test.value, test.scale); index != test.index {
+			t.Errorf("index of %g at scale %d: %d != %d", test.value, test.scale, index, test.index)
+		}
+
+		lower := ExponentialBound(test.index, test.scale)
+		upper := ExponentialBound(test.index+1, test.scale)
+
+		if test.value <= lower || test.value > upper {
+			t.Errorf("%g is not in the bucket (%g, %g] at scale %d", test.value, lower, upper, test.scale)
+		}
+	}
+}
+
+func TestExponentialSketch(t *testing.T) {
+	s := NewExponentialSketch(10)
+
+	if s.Scale() != MaxExponentialScale {
+		t.Error("bad initial scale:", s.Scale())
+	}
+
+	values := []float64{0, 1, 2, 3, 4, 100, 500, -1, -0.5, math.NaN(), math.Inf(1)}
+
+	for _, v := range values {
+		s.Insert(v)
+	}
+
+	if s.Count() != 9 || s.ZeroCount() != 1 {
+		t.Error("bad counts:", s.Count(), s.ZeroCount())
+	}
+
+	// 1 to 500 spans 10 powers of two, which fit in 10 buckets at scale 0.
+	if s.Scale() != 0 {
+		t.Error("bad scale:", s.Scale())
+	}
+
+	if p := s.Positive(); !reflect.DeepEqual(p, ExponentialBuckets{Offset: -1, Counts: []uint64{1, 1, 2, 0, 0, 0, 0, 1, 0, 1}}) {
+		t.Error("bad positive buckets:", p)
+	}
+
+	if n := s.Negative(); !reflect.DeepEqual(n, ExponentialBuckets{Offset: -2, Counts: []uint64{1, 1}}) {
+		t.Error("bad negative buckets:", n)
+	}
+
+	s.Insert(1e6)
+
+	if s.Scale() != -2 || len(s.Positive().Counts) > 10 {
+		t.Error("the sketch was not downscaled:", s.Scale(), s.Positive())
+	}
+
+	var total uint64
+	for _, n := range s.Positive().Counts {
+		total += n
+	}
+
+	if total != 7 {
+		t.Error("values were lost when downscaling:", total)
+	}
+
+	s.Reset()
+
+	if s.Count() != 0 || s.Scale() != MaxExponentialScale || len(s.Positive().Counts) != 0 {
+		t.Error("the sketch was not reset:", s.Count(), s.Scale(), s.Positive())
+	}
+}
+
+func TestExponentialSketchError(t *testing.T) {
+	s := NewExponentialSketch(0)
+
+	for v := 1.0; v < 1000; v *= 1.01 {
+		s.Insert(v)
+	}
+
+	// The range needs 161 buckets at scale 4, it is covered at scale 3 where
+	// the upper limit of each bucket is 2^(1/8) times its lower limit.
+	if s.Scale() != 3 {
+		t.Error("bad scale:", s.Scale())
+	}
+
+	if base := ExponentialBound(1, s.Scale()); math.Abs(base-1.0905) > 1e-4 {
+		t.Error("bad base:", base)
+	}
+}
@@ -0,0 +1,220 @@
+package expvarstats
+
+import (
+	"expvar"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+const (
+	// DefaultReservoirSize is the default number of values that histograms
+	// retain to compute percentiles.
+	DefaultReservoirSize = 1028
+)
+
+// The HandlerConfig type is used to configure expvar handlers.
+type HandlerConfig struct {
+	// Percentiles is the list of percentiles (between 0 and 1) published for
+	// histograms in addition to their count and sum.
+	Percentiles []float64
+
+	// ReservoirSize is the maximum number of values retained by histograms to
+	// compute percentiles, defaults to DefaultReservoirSize.
+	ReservoirSize int
+}
+
+// Handler is a metric handler which aggregates the metrics it receives and
+// publishes them as expvar variables, making them available on /debug/vars
+// without any dependency outside of the standard library.
+//
+// Each metric is published under its full name (namespace and name separated
+// by a dot) as a map from the tags of its series, formatted as "k1=v1,k2=v2",
+// to their values. Counters publish their total, gauges their last value, and
+// histograms an object with their count, sum, and configured percentiles.
+// Values are only computed when the variables are read.
+//
+// Names that were already published by other parts of the program are never
+// overwritten, the metrics are not published and a message is logged instead.
+type Handler struct {
+	config  HandlerConfig
+	mutex   sync.Mutex
+	metrics map[string]*metric
+	rng     *rand.Rand
+}
+
+type metric struct {
+	handler *Handler
+	mtype   stats.MetricType
+	series  map[string]*series
+}
+
+type series struct {
+	value float64
+	res   *stats.Reservoir
+}
+
+// NewHandler creates and returns a new expvar handler which publishes the
+// median and 99th percentile of histograms.
+func NewHandler() *Handler {
+	return NewHandlerWith(HandlerConfig{
+		Percentiles: []float64{0.5, 0.99},
+	})
+}
+
+// NewHandlerWith creates and returns a new expvar handler configured with
+// config.
+func NewHandlerWith(config HandlerConfig) *Handler {
+	if config.ReservoirSize == 0 {
+		config.ReservoirSize = DefaultReservoirSize
+	}
+
+	percentiles := make([]float64, 0, len(config.Percentiles))
+
+	for _, p := range config.Percentiles {
+		if p < 0 || p > 1 {
+			log.Printf("stats/expvarstats: ignoring percentile out of the [0, 1] range: %g", p)
+			continue
+		}
+		percentiles = append(percentiles, p)
+	}
+
+	config.Percentiles = percentiles
+
+	return &Handler{
+		config:  config,
+		metrics: make(map[string]*metric),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (h *Handler) HandleMetric(m *stats.Metric) {
+	name := metricName(m)
+	tags := seriesName(m.Tags)
+
+	mtype := m.Type
+	if mtype == stats.SummaryType || mtype == stats.ExponentialHistogramType {
+		// Summaries and exponential histograms are published like histograms,
+		// with the percentiles of the reservoir.
+		mtype = stats.HistogramType
+	}
+
+	h.mutex.Lock()
+	entry, exists := h.metrics[name]
+
+	if !exists {
+		// Publishing is done after releasing the lock because reading the
+		// variables acquires the lock of the expvar package, then the one of
+		// the handler.
+		entry = &metric{
+			handler: h,
+			mtype:   mtype,
+			series:  make(map[string]*series),
+		}
+		h.metrics[name] = entry
+	}
+
+	s := entry.series[tags]
+
+	if s == nil {
+		s = &series{}
+		entry.series[tags] = s
+	}
+
+	// Sampled metrics stand for more than one occurrence, counters are
+	// incremented and histograms observe the value for each of them.
+	n := m.SampleCount()
+
+	switch entry.mtype {
+	case stats.CounterType:
+		s.value += m.Value * float64(n)
+	case stats.GaugeType:
+		s.value = m.Value
+	case stats.HistogramType:
+		if s.res == nil {
+			s.res = stats.NewReservoir(h.config.ReservoirSize, h.rng)
+		}
+		s.res.InsertN(m.Value, int64(n))
+	}
+
+	h.mutex.Unlock()
+
+	if !exists {
+		publish(name, expvar.Func(entry.value))
+	}
+}
+
+// publishMutex serializes the publications of variables, without it handlers
+// publishing the same name concurrently could both find it unused, and the
+// second call to expvar.Publish would panic.
+var publishMutex sync.Mutex
+
+// publish publishes v under name unless the name is already in use.
+func publish(name string, v expvar.Var) {
+	publishMutex.Lock()
+	defer publishMutex.Unlock()
+
+	if expvar.Get(name) != nil {
+		log.Printf("stats/expvarstats: not publishing %s because the name is already in use", name)
+		return
+	}
+
+	expvar.Publish(name, v)
+}
+
+// value is called by the expvar package to compute the value of the variable
+// published for m.
+func (m *metric) value() interface{} {
+	h := m.handler
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	values := make(map[string]interface{}, len(m.series))
+
+	for tags, s := range m.series {
+		if m.mtype != stats.HistogramType {
+			values[tags] = s.value
+			continue
+		}
+
+		v := make(map[string]float64, 2+len(h.config.Percentiles))
+		v["count"] = float64(s.res.Count())
+		v["sum"] = s.res.Sum()
+
+		for _, p := range h.config.Percentiles {
+			v[stats.PercentileName(p)] = s.res.Query(p)
+		}
+
+		values[tags] = v
+	}
+
+	return values
+}
+
+func metricName(m *stats.Metric) string {
+	if len(m.Namespace) == 0 {
+		return m.Name
+	}
+	return m.Namespace + "." + m.Name
+}
+
+func seriesName(tags []stats.Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	list := make([]string, len(tags))
+
+	for i, t := range tags {
+		list[i] = t.Name + "=" + t.Value
+	}
+
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,136 @@
+package expvarstats
+
+import (
+	"encoding/json"
+	"expvar"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+// testNames is used to give unique names to the variables published by tests,
+// so they can run multiple times in the same process.
+var testNames int64
+
+func testName(prefix string) string {
+	return prefix + "_" + strconv.FormatInt(atomic.AddInt64(&testNames, 1), 10)
+}
+
+func TestHandler(t *testing.T) {
+	name := testName("expvarstats_test")
+	h := NewHandlerWith(HandlerConfig{Percentiles: []float64{0.5}})
+	e := stats.NewEngine(name)
+	e.Register(h)
+
+	e.Add("requests", 1, stats.Tag{"status", "ok"}, stats.Tag{"method", "GET"})
+	e.Add("requests", 2, stats.Tag{"status", "ok"}, stats.Tag{"method", "GET"})
+	e.Incr("requests", stats.Tag{"status", "error"}, stats.Tag{"method", "GET"})
+	e.Set("conns", 10)
+	e.Set("conns", 5)
+	e.Observe("latency", 1)
+	e.Observe("latency", 2)
+	e.Observe("latency", 3)
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{
+			name: name + ".requests",
+			value: map[string]interface{}{
+				"method=GET,status=ok":    3.0,
+				"method=GET,status=error": 1.0,
+			},
+		},
+		{
+			name:  name + ".conns",
+			value: map[string]interface{}{"": 5.0},
+		},
+		{
+			name: name + ".latency",
+			value: map[string]interface{}{
+				"": map[string]interface{}{"count": 3.0, "sum": 6.0, "p50": 2.0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := expvar.Get(test.name)
+
+			if v == nil {
+				t.Fatal("the metric was not published")
+			}
+
+			var value interface{}
+
+			if err := json.Unmarshal([]byte(v.String()), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(value, test.value) {
+				t.Error("bad value:", value)
+			}
+		})
+	}
+}
+
+func TestHandlerExistingName(t *testing.T) {
+	name := testName("expvarstats_test_existing")
+	existing := expvar.NewInt(name + ".calls")
+	existing.Set(42)
+
+	e := stats.NewEngine(name)
+	e.Register(NewHandler())
+	e.Incr("calls")
+
+	if v := expvar.Get(name + ".calls"); v != existing {
+		t.Error("the existing variable was replaced:", v)
+	}
+}
+
+func TestHandlerConcurrentPublish(t *testing.T) {
+	name := testName("expvarstats_test_concurrent")
+	var wg sync.WaitGroup
+
+	for i := 0; i != 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := stats.NewEngine(name)
+			e.Register(NewHandler())
+			e.Incr("calls")
+		}()
+	}
+
+	wg.Wait()
+
+	if expvar.Get(name+".calls") == nil {
+		t.Error("the variable was not published")
+	}
+}
+
+func TestHandlerSampleCount(t *testing.T) {
+	name := testName("expvarstats_test_sampled")
+	h := NewHandlerWith(HandlerConfig{Percentiles: []float64{0.5}})
+
+	h.HandleMetric(&stats.Metric{Type: stats.CounterType, Namespace: name, Name: "calls", Value: 2, Rate: 0.25})
+	h.HandleMetric(&stats.Metric{Type: stats.HistogramType, Namespace: name, Name: "latency", Value: 1})
+	h.HandleMetric(&stats.Metric{Type: stats.HistogramType, Namespace: name, Name: "latency", Value: 3, Rate: 0.5})
+
+	var calls, latency map[string]interface{}
+	json.Unmarshal([]byte(expvar.Get(name+".calls").String()), &calls)
+	json.Unmarshal([]byte(expvar.Get(name+".latency").String()), &latency)
+
+	if v := calls[""]; v != 8.0 {
+		t.Error("the sampled counter was not weighted by its sample count:", calls)
+	}
+
+	if v := latency[""]; !reflect.DeepEqual(v, map[string]interface{}{"count": 3.0, "sum": 7.0, "p50": 3.0}) {
+		t.Error("the sampled histogram was not weighted by its sample count:", latency)
+	}
+}
@@ -0,0 +1,235 @@
+package fifo
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/influxdb"
+)
+
+const (
+	// DefaultBufferSize is the default size of the handler buffer, the buffer
+	// is written to the named pipe when it reaches this size.
+	DefaultBufferSize = 4096
+
+	// DefaultMaxBufferSize is the default maximum amount of data retained by
+	// handlers while no reader has the named pipe open.
+	DefaultMaxBufferSize = 1024 * 1024
+
+	// DefaultWriteTimeout is the default amount of time that handlers wait for
+	// the named pipe to become writable before giving up on a write.
+	DefaultWriteTimeout = 100 * time.Millisecond
+
+	// pipeBuf is the maximum size of writes to a pipe that are guaranteed to
+	// be atomic (PIPE_BUF on linux), writes are split on line boundaries to
+	// fit in this size when possible so readers never see interleaved lines.
+	pipeBuf = 4096
+)
+
+// The HandlerConfig type is used to configure named pipe handlers.
+type HandlerConfig struct {
+	// Path is the path to the named pipe that metrics are written to, the
+	// pipe must exist (see mkfifo(1)).
+	Path string
+
+	// Format is the function used to serialize metrics, defaults to the
+	// influxdb line protocol.
+	Format func([]byte, *stats.Metric) []byte
+
+	// BufferSize is the size of the output buffer used by the handler.
+	BufferSize int
+
+	// MaxBufferSize is the maximum amount of data retained by the handler
+	// when it cannot write to the named pipe, metrics are discarded past this
+	// size.
+	MaxBufferSize int
+
+	// WriteTimeout is the maximum amount of time spent waiting for the named
+	// pipe to become writable.
+	WriteTimeout time.Duration
+}
+
+// Handler is a metric handler which writes metrics to a named pipe, allowing
+// local collectors to read them without a network sidecar.
+//
+// The handler never blocks waiting for a reader to open the pipe. When no
+// reader is connected, or when the reader disconnects, the serialized metrics
+// are retained in memory and the pipe is opened again on the next flush.
+// Metrics filling the buffer only attempt to open the pipe once between
+// flushes, so a missing reader doesn't cost a system call on every metric.
+type Handler struct {
+	dropped int64 // first for alignment of atomic operations
+	mutex   sync.Mutex
+	config  HandlerConfig
+	file    *os.File
+	buffer  []byte
+	full    bool
+	waiting bool // no reader was found, set until the next call to Flush
+}
+
+// NewHandler creates and returns a new handler writing metrics to the named
+// pipe at path.
+func NewHandler(path string) *Handler {
+	return NewHandlerWith(HandlerConfig{
+		Path: path,
+	})
+}
+
+// NewHandlerWith creates and returns a new handler configured with config.
+func NewHandlerWith(config HandlerConfig) *Handler {
+	if config.Format == nil {
+		config.Format = influxdb.AppendMetric
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.MaxBufferSize == 0 {
+		config.MaxBufferSize = DefaultMaxBufferSize
+	}
+
+	if config.MaxBufferSize < config.BufferSize {
+		config.MaxBufferSize = config.BufferSize
+	}
+
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+
+	return &Handler{
+		config: config,
+		buffer: make([]byte, 0, config.BufferSize),
+	}
+}
+
+// Close satisfies the io.Closer interface.
+func (h *Handler) Close() (err error) {
+	h.mutex.Lock()
+	h.waiting = false
+	h.flush()
+
+	if h.file != nil {
+		err = h.file.Close()
+		h.file = nil
+	}
+
+	h.mutex.Unlock()
+	return
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (h *Handler) Flush() {
+	h.mutex.Lock()
+	h.waiting = false
+	h.flush()
+	h.mutex.Unlock()
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (h *Handler) HandleMetric(m *stats.Metric) {
+	h.mutex.Lock()
+	n := len(h.buffer)
+	h.buffer = h.config.Format(h.buffer, m)
+
+	if len(h.buffer) > h.config.MaxBufferSize {
+		h.buffer = h.buffer[:n]
+		atomic.AddInt64(&h.dropped, 1)
+
+		if !h.full {
+			h.full = true
+			log.Printf("stats/fifo: discarding metrics because the buffer for %s is full", h.config.Path)
+		}
+	} else if len(h.buffer) >= h.config.BufferSize && !h.waiting {
+		h.flush()
+	}
+
+	h.mutex.Unlock()
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// metrics discarded because the buffer of the handler was full.
+func (h *Handler) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// BufferUsage satisfies the stats.BufferReporter interface, it returns the
+// fraction of the maximum buffer size retained by the handler.
+func (h *Handler) BufferUsage() float64 {
+	h.mutex.Lock()
+	n := len(h.buffer)
+	h.mutex.Unlock()
+	return float64(n) / float64(h.config.MaxBufferSize)
+}
+
+func (h *Handler) flush() {
+	if len(h.buffer) == 0 {
+		return
+	}
+
+	if h.file == nil && !h.open() {
+		return
+	}
+
+	h.file.SetWriteDeadline(time.Now().Add(h.config.WriteTimeout))
+	b := h.buffer
+
+	for len(b) != 0 {
+		chunk := b
+
+		if len(chunk) > pipeBuf {
+			if i := bytes.LastIndexByte(chunk[:pipeBuf], '\n'); i >= 0 {
+				chunk = chunk[:i+1]
+			} else {
+				chunk = chunk[:pipeBuf]
+			}
+		}
+
+		n, err := h.file.Write(chunk)
+		b = b[n:]
+
+		if err != nil {
+			if !os.IsTimeout(err) {
+				// Most likely EPIPE, the reader has closed its end of the
+				// pipe. The remaining data is retained and the pipe will be
+				// opened again on the next flush.
+				h.file.Close()
+				h.file = nil
+			}
+			break
+		}
+	}
+
+	h.buffer = h.buffer[:copy(h.buffer, b)]
+
+	// The buffer is only considered to have room again once it was written
+	// entirely, a reader which stays too slow must not cause the discarded
+	// metrics to be logged again on every flush.
+	if len(h.buffer) == 0 {
+		h.full = false
+	}
+}
+
+func (h *Handler) open() bool {
+	// Opening a named pipe for writing blocks until a reader opens the other
+	// end, with O_NONBLOCK the call fails with ENXIO instead which means that
+	// no reader is available yet.
+	f, err := os.OpenFile(h.config.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+
+	if err != nil {
+		if e, ok := err.(*os.PathError); !ok || e.Err != syscall.ENXIO {
+			log.Printf("stats/fifo: opening %s failed: %s", h.config.Path, err)
+		}
+		h.waiting = true
+		return false
+	}
+
+	h.file = f
+	return true
+}
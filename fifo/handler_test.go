@@ -0,0 +1,192 @@
+// +build !windows
+
+package fifo
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func format(b []byte, m *stats.Metric) []byte {
+	b = append(b, m.Name...)
+	return append(b, '\n')
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats-fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics")
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip("creating named pipes is not supported:", err)
+	}
+
+	h := NewHandlerWith(HandlerConfig{
+		Path:   path,
+		Format: format,
+	})
+	defer h.Close()
+
+	e := stats.NewEngine("")
+	e.Register(h)
+
+	// No reader yet, the metric must be retained in the buffer.
+	e.Incr("A")
+	e.Flush()
+
+	r1 := openReader(t, path)
+	e.Incr("B")
+	e.Flush()
+
+	if lines := readLines(t, r1, 2); strings.Join(lines, ",") != "A,B" {
+		t.Error("bad lines:", lines)
+	}
+
+	// The reader disconnects, the next flush fails with EPIPE and the metric
+	// must be retained until a new reader connects.
+	r1.Close()
+	e.Incr("C")
+	e.Flush()
+
+	r2 := openReader(t, path)
+	defer r2.Close()
+	e.Incr("D")
+	e.Flush()
+
+	if lines := readLines(t, r2, 2); strings.Join(lines, ",") != "C,D" {
+		t.Error("bad lines:", lines)
+	}
+}
+
+func TestHandlerDropped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats-fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics")
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip("creating named pipes is not supported:", err)
+	}
+
+	h := NewHandlerWith(HandlerConfig{
+		Path:          path,
+		Format:        format,
+		BufferSize:    4,
+		MaxBufferSize: 4,
+	})
+	defer h.Close()
+
+	// No reader, the buffer fills up after two metrics.
+	for _, name := range []string{"A", "B", "C", "D"} {
+		h.HandleMetric(&stats.Metric{Name: name})
+	}
+
+	if n := h.Dropped(); n != 2 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	// The flush fails without a reader, the buffer must still be full.
+	h.Flush()
+
+	if !h.full {
+		t.Error("the buffer was reported as no longer full after a failed flush")
+	}
+
+	r := openReader(t, path)
+	defer r.Close()
+	h.Flush()
+
+	if h.full {
+		t.Error("the buffer was still reported as full after a successful flush")
+	}
+
+	if lines := readLines(t, r, 2); strings.Join(lines, ",") != "A,B" {
+		t.Error("bad lines:", lines)
+	}
+}
+
+func TestHandlerWaitingForReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats-fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics")
+
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip("creating named pipes is not supported:", err)
+	}
+
+	h := NewHandlerWith(HandlerConfig{
+		Path:       path,
+		Format:     format,
+		BufferSize: 2,
+	})
+	defer h.Close()
+
+	// No reader, the first full buffer attempts to open the pipe and the
+	// following ones wait for the next flush.
+	h.HandleMetric(&stats.Metric{Name: "A"})
+
+	if !h.waiting {
+		t.Error("the handler is not waiting for a reader after failing to open the pipe")
+	}
+
+	r := openReader(t, path)
+	defer r.Close()
+	h.HandleMetric(&stats.Metric{Name: "B"})
+
+	if h.file != nil {
+		t.Error("the pipe was opened again before the next flush")
+	}
+
+	h.Flush()
+
+	if h.waiting || h.file == nil {
+		t.Error("the pipe was not opened again by the flush")
+	}
+
+	if lines := readLines(t, r, 2); strings.Join(lines, ",") != "A,B" {
+		t.Error("bad lines:", lines)
+	}
+}
+
+func openReader(t *testing.T, path string) *os.File {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readLines(t *testing.T, f *os.File, n int) (lines []string) {
+	f.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(f)
+
+	for len(lines) != n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	return
+}
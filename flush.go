@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// flushConfig carries the flush timeout of engines, counts the flushes that
+// exceeded it, and tracks the health of flushes. Handlers are flushed without
+// timeout by a nil config, which is the config of zero-value engines.
+//
+// Flushes of a handler are serialized, a flush starts once the previous one
+// completed, even if it was abandoned after exceeding the timeout, so a hung
+// backend doesn't accumulate goroutines flushing the same handler.
+type flushConfig struct {
+	timeout  time.Duration
+	timeouts int64
+	active   int64 // number of goroutines flushing handlers
+	last     int64 // time of the last complete flush, in unix nanoseconds
+
+	mutex sync.Mutex
+	locks map[Handler]chan struct{}
+}
+
+// handler flushes h, the method returns false if the flush was abandoned.
+func (c *flushConfig) handler(h Handler) bool {
+	flush := flushFunc(h)
+
+	if flush == nil {
+		return true
+	}
+
+	if c == nil {
+		flush(context.Background())
+		return true
+	}
+
+	lock := c.lock(h)
+
+	if c.timeout == 0 {
+		lock <- struct{}{}
+		flush(context.Background())
+		<-lock
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		atomic.AddInt64(&c.timeouts, 1)
+		log.Printf("stats: abandoned flushing handler of type %T after %s, its previous flush is still running", h, c.timeout)
+		return false
+	}
+
+	done := make(chan struct{})
+	atomic.AddInt64(&c.active, 1)
+
+	go func() {
+		defer atomic.AddInt64(&c.active, -1)
+		defer close(done)
+		defer func() { <-lock }()
+		flush(ctx)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		atomic.AddInt64(&c.timeouts, 1)
+		log.Printf("stats: abandoned flushing handler of type %T after %s", h, c.timeout)
+		return false
+	}
+}
+
+// lock returns the channel used as lock to serialize the flushes of h, a value
+// is sent to acquire the lock and received to release it. Handlers of types
+// which cannot be compared (like HandlerFunc) get a new lock on each call.
+func (c *flushConfig) lock(h Handler) chan struct{} {
+	if !reflect.TypeOf(h).Comparable() {
+		return make(chan struct{}, 1)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lock := c.locks[h]
+
+	if lock == nil {
+		if c.locks == nil {
+			c.locks = make(map[Handler]chan struct{})
+		}
+		lock = make(chan struct{}, 1)
+		c.locks[h] = lock
+	}
+
+	return lock
+}
+
+// lastFlush returns the time of the last flush which completed for all
+// handlers, or the zero time if there were none.
+func (c *flushConfig) lastFlush() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	if t := atomic.LoadInt64(&c.last); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// complete records that handlers were all flushed at t.
+func (c *flushConfig) complete(t time.Time) {
+	if c != nil {
+		atomic.StoreInt64(&c.last, t.UnixNano())
+	}
+}
+
+// timedOut returns the number of flushes which exceeded the timeout.
+func (c *flushConfig) timedOut() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.timeouts)
+}
+
+// flushing returns the number of goroutines flushing handlers.
+func (c *flushConfig) flushing() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.active)
+}
+
+func flushFunc(h Handler) func(context.Context) {
+	switch f := h.(type) {
+	case ContextFlusher:
+		return f.FlushContext
+	case Flusher:
+		return func(context.Context) { f.Flush() }
+	default:
+		return nil
+	}
+}
+
+// flushHandler flushes h with ctx if it implements the ContextFlusher
+// interface, or with its Flush method if it only implements Flusher. Handlers
+// wrapping other handlers use it so the deadline of the engine's flush reaches
+// the handlers they wrap.
+func flushHandler(ctx context.Context, h Handler) {
+	if flush := flushFunc(h); flush != nil {
+		flush(ctx)
+	}
+}
@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type blockingFlusher struct {
+	calls int64 // first for alignment of atomic operations
+	handler
+	unblock chan struct{}
+}
+
+func (f *blockingFlusher) Flush() {
+	atomic.AddInt64(&f.calls, 1)
+	<-f.unblock
+}
+
+type contextFlusher struct {
+	handler
+	deadline bool
+}
+
+func (f *contextFlusher) FlushContext(ctx context.Context) {
+	_, f.deadline = ctx.Deadline()
+	f.flushed++
+}
+
+func TestEngineFlushTimeout(t *testing.T) {
+	h1 := &blockingFlusher{unblock: make(chan struct{})}
+	h2 := &contextFlusher{}
+	h3 := &handler{}
+	defer close(h1.unblock)
+
+	eng := NewEngineWith(EngineConfig{
+		Name:         "E",
+		FlushTimeout: 10 * time.Millisecond,
+	})
+	eng.Register(h1)
+	eng.Register(h2)
+	eng.Register(h3)
+
+	start := time.Now()
+	eng.Flush()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was blocked by a hung handler for", elapsed)
+	}
+
+	if n := eng.FlushTimeouts(); n != 1 {
+		t.Error("bad number of flush timeouts:", n)
+	}
+
+	if h2.flushed != 1 || !h2.deadline {
+		t.Error("the context flusher was not flushed with a deadline")
+	}
+
+	if h3.flushed != 1 {
+		t.Error("the handler following the hung handler was not flushed")
+	}
+}
+
+func TestEngineFlushSerialized(t *testing.T) {
+	h := &blockingFlusher{unblock: make(chan struct{})}
+
+	eng := NewEngineWith(EngineConfig{
+		Name:         "E",
+		FlushTimeout: 10 * time.Millisecond,
+	})
+	eng.Register(h)
+
+	eng.Flush()
+	eng.Flush() // abandoned while the first flush is still running
+
+	if n := eng.FlushTimeouts(); n != 2 {
+		t.Error("bad number of flush timeouts:", n)
+	}
+
+	if n := atomic.LoadInt64(&h.calls); n != 1 {
+		t.Error("the handler was flushed while its previous flush was still running:", n)
+	}
+
+	close(h.unblock)
+
+	for i := 0; i != 100 && eng.flush.flushing() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	eng.Flush()
+
+	if n := eng.FlushTimeouts(); n != 2 {
+		t.Error("bad number of flush timeouts after unblocking the handler:", n)
+	}
+
+	if n := atomic.LoadInt64(&h.calls); n != 2 {
+		t.Error("the handler was not flushed once its previous flush completed:", n)
+	}
+}
+
+func TestWrappedContextFlusher(t *testing.T) {
+	for _, test := range wrappers {
+		t.Run(test.name, func(t *testing.T) {
+			h := &contextFlusher{}
+			eng := NewEngineWith(EngineConfig{
+				Name:         "E",
+				FlushTimeout: time.Second,
+			})
+			eng.Register(test.wrap(h))
+			eng.Flush()
+
+			if h.flushed != 1 || !h.deadline {
+				t.Error("the wrapped context flusher was not flushed with a deadline")
+			}
+		})
+	}
+}
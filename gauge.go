@@ -1,6 +1,9 @@
 package stats
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // A Gauge represent a metric that reports a single value.
 type Gauge struct {
@@ -42,6 +45,27 @@ func (g *Gauge) WithTags(tags ...Tag) *Gauge {
 	}
 }
 
+// WithoutEngineTags returns a copy of the gauge which doesn't inherit the tags
+// of the engine it was created on, which is useful for process-wide gauges
+// that must not carry tags scoped to a request or a component.
+//
+// The internal value of the returned gauge is set to zero.
+func (g *Gauge) WithoutEngineTags() *Gauge {
+	return &Gauge{
+		eng:  g.eng.withoutTags(),
+		name: g.name,
+		tags: g.tags,
+	}
+}
+
+// WithHelp sets the help text of the gauge's metric, which handlers like the
+// prometheus handler expose, and returns the gauge so the call can be chained
+// with its creation.
+func (g *Gauge) WithHelp(help string) *Gauge {
+	g.eng.describeHelp(GaugeType, g.name, help)
+	return g
+}
+
 // Incr increments the gauge by a value of 1.
 func (g *Gauge) Incr() {
 	g.Add(1)
@@ -67,3 +91,42 @@ func (g *Gauge) Set(value float64) {
 	g.eng.Set(g.name, value, g.tags...)
 	g.mutex.Unlock()
 }
+
+// Track increments g and returns a function which decrements it, it is used to
+// report the number of operations in flight:
+//
+//	defer stats.Track(inflight)()
+//
+// Deferring the call guarantees that the gauge is decremented when the
+// operation panics. Calling the returned function more than once only
+// decrements the gauge once.
+func Track(g *Gauge) func() {
+	var once sync.Once
+	g.Incr()
+	return func() { once.Do(g.Decr) }
+}
+
+// TrackContext is like Track but also decrements g when ctx is done, which
+// bounds the time an operation is counted as in flight to the lifetime of the
+// context it runs in. The returned function must still be called to release
+// the resources associated with the tracking.
+func TrackContext(ctx context.Context, g *Gauge) func() {
+	done := make(chan struct{})
+	decr := Track(g)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			decr()
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			decr()
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package stats
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGaugeIncr(t *testing.T) {
@@ -129,6 +131,35 @@ func TestGaugeWithTags(t *testing.T) {
 	}
 }
 
+func TestGaugeWithoutEngineTags(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E", Tag{"service", "api"})
+	e.Register(h)
+
+	r := e.WithTags(Tag{"request", "42"}).WithLazyTags(LazyTag{"host", func() string { return "localhost" }})
+	r.Gauge("process.info", Tag{"version", "1.0"}).WithoutEngineTags().Set(1)
+	r.Gauge("inflight").Set(2)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "process.info",
+			Tags:      []Tag{{"version", "1.0"}},
+			Value:     1,
+		},
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "inflight",
+			Tags:      []Tag{{"service", "api"}, {"request", "42"}, {"host", "localhost"}},
+			Value:     2,
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
 func BenchmarkGauge(b *testing.B) {
 	e := NewEngine("E")
 
@@ -160,3 +191,64 @@ func BenchmarkGauge(b *testing.B) {
 		}
 	})
 }
+
+func TestTrack(t *testing.T) {
+	g := NewEngine("E").Gauge("inflight")
+
+	func() {
+		defer func() { recover() }()
+		defer Track(g)()
+
+		if v := g.Value(); v != 1 {
+			t.Error("bad value while tracking:", v)
+		}
+
+		panic("failed")
+	}()
+
+	if v := g.Value(); v != 0 {
+		t.Error("bad value after a panic:", v)
+	}
+
+	done := Track(g)
+	done()
+	done()
+
+	if v := g.Value(); v != 0 {
+		t.Error("bad value after calling the function twice:", v)
+	}
+}
+
+func TestTrackContext(t *testing.T) {
+	g := NewEngine("E").Gauge("inflight")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := TrackContext(ctx, g)
+	defer done()
+
+	if v := g.Value(); v != 1 {
+		t.Error("bad value while tracking:", v)
+	}
+
+	cancel()
+
+	for i := 0; i != 1000 && gaugeValue(g) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if v := gaugeValue(g); v != 0 {
+		t.Error("the gauge was not decremented when the context was canceled:", v)
+	}
+
+	done()
+
+	if v := gaugeValue(g); v != 0 {
+		t.Error("the gauge was decremented twice:", v)
+	}
+}
+
+func gaugeValue(g *Gauge) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
@@ -0,0 +1,196 @@
+package graphite
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// Format is an enumeration of the formats in which metrics can be sent to
+// carbon servers.
+type Format int
+
+const (
+	// Path is the classic graphite format, where tags are folded into the
+	// dotted path of metrics as name.value components:
+	//
+	//	namespace.name.tag1.v1.tag2.v2 value timestamp
+	//
+	// Each tag value creates a new path, so tags with a high cardinality
+	// quickly result in a large number of whisper files on the server.
+	Path Format = iota
+
+	// Tagged is the carbon 2.0 format supported by graphite 1.1 and above,
+	// where tags are sent separately from the name of metrics:
+	//
+	//	namespace.name;tag1=v1;tag2=v2 value timestamp
+	//
+	// Graphite indexes the tags and series can be queried by tags with the
+	// seriesByTag function.
+	Tagged
+)
+
+// String satisfies the fmt.Stringer interface.
+func (f Format) String() string {
+	switch f {
+	case Path:
+		return "path"
+	case Tagged:
+		return "tagged"
+	default:
+		return "unknown"
+	}
+}
+
+// AppendMetric appends the classic graphite representation of m to b, using
+// the metric time or the current time if it is not set.
+func AppendMetric(b []byte, m *stats.Metric) []byte {
+	return appendMetric(b, m, layout{format: Path}, metricTime(m))
+}
+
+// AppendTaggedMetric appends the carbon 2.0 tagged representation of m to b,
+// using the metric time or the current time if it is not set.
+func AppendTaggedMetric(b []byte, m *stats.Metric) []byte {
+	return appendMetric(b, m, layout{format: Tagged}, metricTime(m))
+}
+
+// layout describes how metrics are laid out in the lines sent to carbon
+// servers.
+type layout struct {
+	format    Format
+	separator string   // separator of path components, "." when empty
+	tags      []string // tags whose values are inserted in paths
+}
+
+func (l layout) sep() string {
+	if len(l.separator) == 0 {
+		return "."
+	}
+	return l.separator
+}
+
+func metricTime(m *stats.Metric) time.Time {
+	if m.Time.IsZero() {
+		return time.Now()
+	}
+	return m.Time
+}
+
+// appendMetric appends the representation of m to b with layout l, the
+// timestamp is omitted if t is the zero time so it can be added by
+// appendTimestamps.
+func appendMetric(b []byte, m *stats.Metric, l layout, t time.Time) []byte {
+	sep := l.sep()
+	// Path components are sanitized of the separator so tag values cannot
+	// create new levels in the hierarchy of metrics.
+	component := " ;" + sep
+
+	if len(m.Namespace) != 0 {
+		b = appendSanitized(b, m.Namespace, " ;")
+		b = append(b, sep...)
+	}
+
+	for _, name := range l.tags {
+		if v := tagValue(m.Tags, name); len(v) != 0 {
+			b = appendSanitized(b, v, component)
+			b = append(b, sep...)
+		}
+	}
+
+	b = appendSanitized(b, m.Name, " ;")
+
+	for _, tag := range m.Tags {
+		if len(tag.Name) == 0 || len(tag.Value) == 0 {
+			continue // carbon rejects empty tag names or values
+		}
+
+		if contains(l.tags, tag.Name) {
+			continue // already inserted in the path
+		}
+
+		if l.format == Tagged {
+			b = append(b, ';')
+			b = appendSanitized(b, tag.Name, " ;!^=")
+			b = append(b, '=')
+			b = appendSanitized(b, tag.Value, " ;~")
+		} else {
+			b = append(b, sep...)
+			b = appendSanitized(b, tag.Name, component)
+			b = append(b, sep...)
+			b = appendSanitized(b, tag.Value, component)
+		}
+	}
+
+	value := m.Value
+
+	if m.Type == stats.CounterType {
+		// The increment of a sampled counter stands for the increments
+		// which were discarded.
+		value *= float64(m.SampleCount())
+	}
+
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, value, 'g', -1, 64)
+
+	if !t.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.Unix(), 10)
+	}
+
+	return append(b, '\n')
+}
+
+// tagValue returns the value of the last tag with name, or an empty string if
+// there are none.
+func tagValue(tags []stats.Tag, name string) string {
+	for i := len(tags) - 1; i >= 0; i-- {
+		if tags[i].Name == name {
+			return tags[i].Value
+		}
+	}
+	return ""
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendTimestamps appends the lines to b, adding timestamp t to each of them.
+func appendTimestamps(b []byte, lines []byte, t time.Time) []byte {
+	ts := strconv.AppendInt(append(make([]byte, 0, 20), ' '), t.Unix(), 10)
+
+	for len(lines) != 0 {
+		i := bytes.IndexByte(lines, '\n')
+		b = append(b, lines[:i]...)
+		b = append(b, ts...)
+		b = append(b, '\n')
+		lines = lines[i+1:]
+	}
+
+	return b
+}
+
+// appendSanitized appends s to b, replacing the characters in chars, which
+// carbon doesn't accept, with underscores.
+func appendSanitized(b []byte, s string, chars string) []byte {
+	for i := 0; i != len(s); i++ {
+		c := s[i]
+
+		for j := 0; j != len(chars); j++ {
+			if c == chars[j] {
+				c = '_'
+				break
+			}
+		}
+
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAppendMetric(t *testing.T) {
+	tests := []struct {
+		format Format
+		s      string
+		m      stats.Metric
+	}{
+		{
+			format: Path,
+			s:      "test.metric.small 0 1\n",
+			m: stats.Metric{
+				Namespace: "test",
+				Name:      "metric.small",
+			},
+		},
+		{
+			format: Path,
+			s:      "test.metric.common.hello.world.answer.42 1 1\n",
+			m: stats.Metric{
+				Namespace: "test",
+				Name:      "metric.common",
+				Tags:      []stats.Tag{{"hello", "world"}, {"answer", "42"}, {"empty", ""}},
+				Value:     1,
+			},
+		},
+		{
+			format: Path,
+			s:      "test_metric.host.web1_example_com 0.5 1\n",
+			m: stats.Metric{
+				Name:  "test metric",
+				Tags:  []stats.Tag{{"host", "web1.example.com"}},
+				Value: 0.5,
+			},
+		},
+		{
+			format: Tagged,
+			s:      "test.metric.common;hello=world;answer=42 1 1\n",
+			m: stats.Metric{
+				Namespace: "test",
+				Name:      "metric.common",
+				Tags:      []stats.Tag{{"hello", "world"}, {"answer", "42"}, {"empty", ""}},
+				Value:     1,
+			},
+		},
+		{
+			format: Tagged,
+			s:      "test_metric;a_b=web1.example.com;c__d=_e 0.5 1\n",
+			m: stats.Metric{
+				Name:  "test;metric",
+				Tags:  []stats.Tag{{"a b", "web1.example.com"}, {"c!=d", "~e"}},
+				Value: 0.5,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format.String()+"/"+test.m.Name, func(t *testing.T) {
+			test.m.Time = time.Unix(1, 0)
+
+			if s := string(appendMetric(nil, &test.m, layout{format: test.format}, test.m.Time)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func TestAppendMetricSampleCount(t *testing.T) {
+	tests := []struct {
+		s string
+		m stats.Metric
+	}{
+		{
+			s: "requests 4\n",
+			m: stats.Metric{Type: stats.CounterType, Name: "requests", Value: 2, Rate: 0.5},
+		},
+		{
+			s: "latency 2\n",
+			m: stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 2, Rate: 0.5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.m.Name, func(t *testing.T) {
+			if s := string(appendMetric(nil, &test.m, layout{}, time.Time{})); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func TestAppendMetricLayout(t *testing.T) {
+	m := stats.Metric{
+		Namespace: "test",
+		Name:      "metric.common",
+		Tags:      []stats.Tag{{"host", "web1.example.com"}, {"code", "200"}, {"region", "us-east-1"}},
+		Value:     1,
+		Time:      time.Unix(1, 0),
+	}
+
+	tests := []struct {
+		scenario string
+		layout   layout
+		s        string
+	}{
+		{
+			scenario: "separator",
+			layout:   layout{separator: "/"},
+			s:        "test/metric.common/host/web1.example.com/code/200/region/us-east-1 1 1\n",
+		},
+		{
+			scenario: "path tags",
+			layout:   layout{tags: []string{"region", "host", "zone"}},
+			s:        "test.us-east-1.web1_example_com.metric.common.code.200 1 1\n",
+		},
+		{
+			scenario: "path tags with separator",
+			layout:   layout{separator: "_", tags: []string{"region"}},
+			s:        "test_us-east-1_metric.common_host_web1.example.com_code_200 1 1\n",
+		},
+		{
+			scenario: "path tags with the tagged format",
+			layout:   layout{format: Tagged, tags: []string{"host"}},
+			s:        "test.web1_example_com.metric.common;code=200;region=us-east-1 1 1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			if s := string(appendMetric(nil, &m, test.layout, m.Time)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func BenchmarkAppendMetric(b *testing.B) {
+	buffer := make([]byte, 4096)
+	metric := &stats.Metric{
+		Name:  "test.metric.common",
+		Tags:  []stats.Tag{{"hello", "world"}, {"answer", "42"}},
+		Value: 1,
+		Time:  time.Now(),
+	}
+
+	for _, format := range []Format{Path, Tagged} {
+		b.Run(format.String(), func(b *testing.B) {
+			for i := 0; i != b.N; i++ {
+				appendMetric(buffer[:0], metric, layout{format: format}, metric.Time)
+			}
+		})
+	}
+}
+
+func TestAppendTimestamps(t *testing.T) {
+	lines := appendMetric(nil, &stats.Metric{Name: "A", Value: 1}, layout{}, time.Time{})
+	lines = appendMetric(lines, &stats.Metric{Name: "B", Value: 2}, layout{}, time.Time{})
+
+	if s := string(appendTimestamps(nil, lines, time.Unix(42, 0))); s != "A 1 42\nB 2 42\n" {
+		t.Errorf("bad lines: %q", s)
+	}
+}
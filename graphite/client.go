@@ -0,0 +1,301 @@
+package graphite
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+const (
+	// DefaultAddress is the default address of the carbon server that clients
+	// send metrics to, using the plaintext protocol.
+	DefaultAddress = "localhost:2003"
+
+	// DefaultBufferSize is the default size of the client buffer, the buffer
+	// is sent to the server when it reaches this size.
+	DefaultBufferSize = 64 * 1024
+
+	// DefaultMaxBufferSize is the default maximum amount of data retained by
+	// clients while the server is unreachable.
+	DefaultMaxBufferSize = 1024 * 1024
+
+	// DefaultTimeout is the default timeout of connections and writes to the
+	// server.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultReconnectDelay is the default delay before clients attempt to
+	// reconnect to the server after a failure.
+	DefaultReconnectDelay = 1 * time.Second
+
+	// DefaultMaxReconnectDelay is the default maximum delay between attempts
+	// to reconnect to the server.
+	DefaultMaxReconnectDelay = 1 * time.Minute
+)
+
+// The ClientConfig type is used to configure graphite clients.
+type ClientConfig struct {
+	// Address of the carbon server to send metrics to.
+	Address string
+
+	// Format is the format in which metrics are sent, defaults to Path.
+	Format Format
+
+	// Separator is the separator of the components of metric paths, defaults
+	// to ".". It is inserted after the namespace, path tags, and, with the
+	// Path format, between the names and values of tags. Dots in the names
+	// of metrics are preserved.
+	Separator string
+
+	// PathTags is the list of tags whose values are inserted in the paths of
+	// metrics, in order, between the namespace and the name of metrics. For
+	// example with PathTags set to ["region", "host"]:
+	//
+	//	namespace.us-east-1.web1.name value timestamp
+	//
+	// The tags are not repeated in the rest of the line, tags missing from a
+	// metric are omitted from its path.
+	PathTags []string
+
+	// BufferSize is the size of the output buffer used by the client.
+	BufferSize int
+
+	// MaxBufferSize is the maximum amount of data retained by the client when
+	// it cannot write to the server, metrics are discarded past this size.
+	MaxBufferSize int
+
+	// Timeout is the maximum amount of time spent connecting or writing to
+	// the server.
+	Timeout time.Duration
+
+	// ReconnectDelay is the delay before the client attempts to reconnect to
+	// the server after failing to connect or write to it, metrics are kept in
+	// the buffer in the meantime. The delay doubles after each failed attempt
+	// up to MaxReconnectDelay, and is reset once connected.
+	ReconnectDelay time.Duration
+
+	// MaxReconnectDelay is the maximum delay between attempts to reconnect
+	// to the server.
+	MaxReconnectDelay time.Duration
+
+	// Timestamps is the source of the timestamps of the data points sent to
+	// the server, defaults to stats.MetricTimestamp. The plaintext protocol
+	// requires timestamps, stats.NoTimestamp is handled like
+	// stats.FlushTimestamp.
+	Timestamps stats.TimestampSource
+}
+
+// Client represents a graphite client that receives metrics from a stats
+// engine and sends them to a carbon server with the plaintext protocol.
+//
+// Carbon has no concept of metric types, every metric is sent as a data point
+// carrying its value: counters report their increments, gauges their values,
+// and histograms each observed value.
+type Client struct {
+	// Both fields are first for alignment of atomic operations.
+	dropped int64
+	errors  int64
+
+	mutex   sync.Mutex
+	config  ClientConfig
+	layout  layout
+	conn    net.Conn
+	buffer  []byte
+	pending []byte // lines without timestamps, used with stats.FlushTimestamp
+	full    bool
+	retry   time.Time     // time of the next attempt to connect
+	delay   time.Duration // delay before the next attempt after a failure
+}
+
+// NewClient creates and returns a new graphite client publishing metrics to
+// the server at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new graphite client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.MaxBufferSize == 0 {
+		config.MaxBufferSize = DefaultMaxBufferSize
+	}
+
+	if config.MaxBufferSize < config.BufferSize {
+		config.MaxBufferSize = config.BufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.ReconnectDelay == 0 {
+		config.ReconnectDelay = DefaultReconnectDelay
+	}
+
+	if config.MaxReconnectDelay == 0 {
+		config.MaxReconnectDelay = DefaultMaxReconnectDelay
+	}
+
+	if config.MaxReconnectDelay < config.ReconnectDelay {
+		config.MaxReconnectDelay = config.ReconnectDelay
+	}
+
+	if config.Timestamps == stats.NoTimestamp {
+		config.Timestamps = stats.FlushTimestamp
+	}
+
+	return &Client{
+		config: config,
+		delay:  config.ReconnectDelay,
+		layout: layout{
+			format:    config.Format,
+			separator: config.Separator,
+			tags:      config.PathTags,
+		},
+		buffer: make([]byte, 0, config.BufferSize),
+	}
+}
+
+// Close satisfies the io.Closer interface.
+func (c *Client) Close() (err error) {
+	c.mutex.Lock()
+	c.flush()
+
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+
+	c.mutex.Unlock()
+	return
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.mutex.Lock()
+	c.flush()
+	c.mutex.Unlock()
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	c.mutex.Lock()
+
+	// With flush timestamps the lines are buffered without timestamps, which
+	// are added when the lines are sent.
+	b, t := &c.buffer, metricTime(m)
+	if c.config.Timestamps == stats.FlushTimestamp {
+		b, t = &c.pending, time.Time{}
+	}
+
+	n := len(*b)
+	*b = appendMetric(*b, m, c.layout, t)
+
+	if len(c.buffer)+len(c.pending) > c.config.MaxBufferSize {
+		*b = (*b)[:n]
+		atomic.AddInt64(&c.dropped, 1)
+
+		if !c.full {
+			c.full = true
+			log.Printf("stats/graphite: discarding metrics because the buffer for %s is full", c.config.Address)
+		}
+	} else if len(c.buffer)+len(c.pending) >= c.config.BufferSize {
+		c.flush()
+	}
+
+	c.mutex.Unlock()
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// metrics discarded because the buffer of the client was full.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// failed attempts to connect or write to the server.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// BufferUsage satisfies the stats.BufferReporter interface, it returns the
+// fraction of the maximum buffer size retained by the client.
+func (c *Client) BufferUsage() float64 {
+	c.mutex.Lock()
+	n := len(c.buffer) + len(c.pending)
+	c.mutex.Unlock()
+	return float64(n) / float64(c.config.MaxBufferSize)
+}
+
+func (c *Client) flush() {
+	if len(c.pending) != 0 {
+		c.buffer = appendTimestamps(c.buffer, c.pending, time.Now())
+		c.pending = c.pending[:0]
+	}
+
+	if len(c.buffer) == 0 {
+		return
+	}
+
+	if c.conn == nil && !c.dial() {
+		return
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.config.Timeout))
+	n, err := c.conn.Write(c.buffer)
+
+	if err != nil {
+		// The connection is closed and the unsent data is retained, a new
+		// connection is opened on the next flush.
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/graphite: sending metrics to %s failed: %s", c.config.Address, err)
+		c.conn.Close()
+		c.conn = nil
+		c.backoff()
+	}
+
+	c.buffer = c.buffer[:copy(c.buffer, c.buffer[n:])]
+	c.full = false
+}
+
+func (c *Client) dial() bool {
+	if time.Now().Before(c.retry) {
+		return false
+	}
+
+	conn, err := net.DialTimeout("tcp", c.config.Address, c.config.Timeout)
+
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/graphite: connecting to %s failed: %s", c.config.Address, err)
+		c.backoff()
+		return false
+	}
+
+	c.conn = conn
+	c.delay = c.config.ReconnectDelay
+	return true
+}
+
+// backoff delays the next attempt to connect to the server, doubling the delay
+// of the following attempt.
+func (c *Client) backoff() {
+	c.retry = time.Now().Add(c.delay)
+
+	if c.delay *= 2; c.delay > c.config.MaxReconnectDelay {
+		c.delay = c.config.MaxReconnectDelay
+	}
+}
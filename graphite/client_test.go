@@ -0,0 +1,202 @@
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestClient(t *testing.T) {
+	for _, format := range []Format{Path, Tagged} {
+		t.Run(format.String(), func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			lines := make(chan string, 10)
+
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				r := bufio.NewScanner(conn)
+				for r.Scan() {
+					lines <- r.Text()
+				}
+			}()
+
+			client := NewClientWith(ClientConfig{
+				Address: l.Addr().String(),
+				Format:  format,
+			})
+			defer client.Close()
+
+			engine := stats.NewEngine("graphite")
+			engine.Register(client)
+			engine.Incr("A", stats.Tag{"k", "v"})
+			engine.Set("B", 2)
+			engine.Flush()
+
+			want := []string{"graphite.A.k.v 1", "graphite.B 2"}
+			if format == Tagged {
+				want[0] = "graphite.A;k=v 1"
+			}
+
+			for _, w := range want {
+				select {
+				case line := <-lines:
+					if !strings.HasPrefix(line, w+" ") {
+						t.Error("bad line:", line)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("timeout waiting for", w)
+				}
+			}
+		})
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:       addr,
+		BufferSize:    16,
+		MaxBufferSize: 32,
+	})
+
+	for i := 0; i != 10; i++ {
+		client.HandleMetric(&stats.Metric{Name: "metric", Time: time.Unix(1, 0)})
+	}
+
+	// The server is unreachable, metrics are retained up to the maximum size
+	// of the buffer.
+	if n := len(client.buffer); n == 0 || n > 32 {
+		t.Error("bad buffer size:", n)
+	}
+
+	if n := client.Dropped(); n == 0 {
+		t.Error("no metrics were reported as dropped")
+	}
+}
+
+func TestClientReconnectDelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:           addr,
+		ReconnectDelay:    50 * time.Millisecond,
+		MaxReconnectDelay: 80 * time.Millisecond,
+	})
+
+	client.HandleMetric(&stats.Metric{Name: "metric", Time: time.Unix(1, 0)})
+	client.Flush()
+
+	if n := client.Errors(); n != 1 {
+		t.Fatal("bad error count:", n)
+	}
+
+	// The client does not attempt to reconnect before the delay expires.
+	client.Flush()
+
+	if n := client.Errors(); n != 1 {
+		t.Error("bad error count before the reconnect delay:", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	client.Flush()
+
+	if n := client.Errors(); n != 2 {
+		t.Error("bad error count after the reconnect delay:", n)
+	}
+
+	if client.delay != 80*time.Millisecond {
+		t.Error("bad reconnect delay:", client.delay)
+	}
+
+	// The server becomes reachable, the buffered metrics are sent once the
+	// client reconnects.
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewScanner(conn)
+		for r.Scan() {
+			lines <- r.Text()
+		}
+	}()
+
+	time.Sleep(80 * time.Millisecond)
+	client.Flush()
+	defer client.Close()
+
+	select {
+	case line := <-lines:
+		if line != "metric 0 1" {
+			t.Error("bad line:", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the buffered metrics")
+	}
+
+	if client.delay != 50*time.Millisecond {
+		t.Error("the reconnect delay was not reset:", client.delay)
+	}
+}
+
+func TestClientFlushTimestamps(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    addr,
+		Timestamps: stats.FlushTimestamp,
+	})
+
+	client.HandleMetric(&stats.Metric{Name: "metric", Value: 1, Time: time.Unix(1, 0)})
+
+	if s := string(client.pending); s != "metric 1\n" {
+		t.Errorf("bad pending lines: %q", s)
+	}
+
+	// The server is unreachable, the lines are retained with the time of the
+	// first attempt to send them.
+	client.Flush()
+
+	if s := string(client.buffer); !strings.HasPrefix(s, "metric 1 ") || s == "metric 1 1\n" {
+		t.Errorf("bad buffered lines: %q", s)
+	}
+}
@@ -1,5 +1,7 @@
 package stats
 
+import "context"
+
 // Handler is an interface implemented by types that receive metrics and expose
 // them to diverse platforms.
 //
@@ -29,3 +31,63 @@ type Flusher interface {
 	// or buffered internally.
 	Flush()
 }
+
+// Resetter is an interface that may be implemented by metric handlers that
+// aggregate the state of the metrics they receive.
+type Resetter interface {
+	// Reset is called to discard all the state that the handler accumulated,
+	// as if it had never received any metrics.
+	Reset()
+}
+
+// DropCounter is an interface that may be implemented by metric handlers which
+// can discard metrics, for example when their buffers are full or when their
+// backend is unreachable.
+type DropCounter interface {
+	// Dropped returns the number of metrics discarded by the handler since it
+	// was created, the method is safe to call concurrently with the methods
+	// reporting metrics to the handler.
+	Dropped() int64
+}
+
+// ErrorCounter is an interface that may be implemented by metric handlers which
+// send metrics to a backend, and can fail doing so.
+type ErrorCounter interface {
+	// Errors returns the number of errors that the handler encountered
+	// sending metrics since it was created, the method is safe to call
+	// concurrently with the methods reporting metrics to the handler.
+	Errors() int64
+}
+
+// BufferReporter is an interface that may be implemented by metric handlers
+// which buffer the metrics they receive up to a maximum size.
+type BufferReporter interface {
+	// BufferUsage returns the fraction of the buffer of the handler which is
+	// in use, between 0 and 1, the method is safe to call concurrently with
+	// the methods reporting metrics to the handler.
+	BufferUsage() float64
+}
+
+// Describer is an interface that may be implemented by metric handlers which
+// expose the help text and unit of metrics.
+type Describer interface {
+	// DescribeMetric is called when a metric is declared with a help text or
+	// a unit on an engine that the handler is registered on, and when the
+	// handler is registered for the metrics that were declared before.
+	DescribeMetric(schema MetricSchema)
+}
+
+// describeMetric passes schema to h if it implements the Describer interface.
+func describeMetric(h Handler, schema MetricSchema) {
+	if d, ok := h.(Describer); ok {
+		d.DescribeMetric(schema)
+	}
+}
+
+// ContextFlusher is an interface that may be implemented by metric handlers
+// which can abort flushing their data when a context is canceled.
+type ContextFlusher interface {
+	// FlushContext is called instead of Flush when the handler is flushed by
+	// an engine, ctx carries the deadline of the engine's flush timeout.
+	FlushContext(ctx context.Context)
+}
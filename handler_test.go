@@ -22,6 +22,33 @@ func (h *handler) Flush() {
 	h.flushed++
 }
 
+func (h *handler) Reset() {
+	h.metrics = nil
+}
+
+// wrappers is the list of the handlers wrapping other handlers, it is used to
+// test that they forward the optional interfaces of handlers.
+var wrappers = []struct {
+	name string
+	wrap func(Handler) Handler
+}{
+	{"audit", func(h Handler) Handler {
+		return NewAuditHandler(h, AuditConfig{Sink: AuditSinkFunc(func(*AuditRecord) error { return nil })})
+	}},
+	{"breaker", func(h Handler) Handler { return NewCircuitBreaker(h, CircuitBreakerConfig{}) }},
+	{"burn rate", func(h Handler) Handler { return NewBurnRateHandler(h, BurnRateConfig{Name: "requests"}) }},
+	{"gauge default", func(h Handler) Handler { return NewGaugeDefaultHandler(h) }},
+	{"derivative", func(h Handler) Handler { return NewDerivativeHandler(h, DerivativeConfig{}) }},
+	{"example", func(h Handler) Handler { return NewExampleHandler(h, ExampleConfig{}) }},
+	{"gauge expiry", func(h Handler) Handler { return NewGaugeExpiryHandler(h) }},
+	{"info", func(h Handler) Handler { return NewInfoHandler(h, InfoConfig{}) }},
+	{"rate", func(h Handler) Handler { return NewRateHandler(h, RateHandlerConfig{}) }},
+	{"gauge refresh", func(h Handler) Handler { return NewGaugeRefreshHandler(h) }},
+	{"relabel", func(h Handler) Handler { r, _ := NewRelabelHandler(h); return r }},
+	{"route", func(h Handler) Handler { return NewRouteHandler(RouteHandlerConfig{Default: h}) }},
+	{"transform", func(h Handler) Handler { return NewTransformHandler(h) }},
+}
+
 func TestHandlerFunc(t *testing.T) {
 	metrics := []Metric{
 		{
@@ -0,0 +1,207 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// DefaultHDRLowestValue is the default smallest value that HDR sketches
+	// distinguish from zero, a microsecond when values are in seconds.
+	DefaultHDRLowestValue = 1e-6
+
+	// DefaultHDRHighestValue is the default largest value tracked by HDR
+	// sketches, an hour when values are in seconds.
+	DefaultHDRHighestValue = 3600
+
+	// MaxHDRSignificantDigits is the largest number of significant digits
+	// supported by HDR sketches.
+	MaxHDRSignificantDigits = 5
+)
+
+// HDRLayout describes the buckets of HDR sketches, it is derived from the range
+// of values and the number of significant digits, and is meant to be shared by
+// all the sketches of a handler.
+//
+// The layout follows the HdrHistogram design: values are scaled to integers
+// in units of the lowest value, and grouped in buckets covering powers of two
+// of the range. Each bucket is divided in linear sub-buckets, enough of them
+// to preserve the configured number of significant digits, so the relative
+// error on any recorded value is bounded by 10^-digits.
+//
+// HDRLayout values are immutable and safe to use concurrently.
+type HDRLayout struct {
+	scale                       float64
+	highest                     int64
+	subBucketHalfCountMagnitude uint
+	subBucketCount              int64
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	countsLen                   int
+}
+
+// NewHDRLayout returns the layout of HDR sketches tracking values from lowest
+// to highest with the given number of significant digits.
+//
+// DefaultHDRLowestValue is used when lowest is zero or negative, and
+// DefaultHDRHighestValue when highest is not larger than lowest. The number of
+// significant digits is clamped to the [1, MaxHDRSignificantDigits] range.
+func NewHDRLayout(lowest float64, highest float64, digits int) *HDRLayout {
+	if lowest <= 0 {
+		lowest = DefaultHDRLowestValue
+	}
+
+	if highest <= lowest {
+		highest = DefaultHDRHighestValue
+	}
+
+	if digits < 1 {
+		digits = 1
+	} else if digits > MaxHDRSignificantDigits {
+		digits = MaxHDRSignificantDigits
+	}
+
+	largestValueWithSingleUnitResolution := 2 * math.Pow10(digits)
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(largestValueWithSingleUnitResolution)))
+
+	l := &HDRLayout{
+		scale:                       1 / lowest,
+		highest:                     int64(math.Ceil(highest / lowest)),
+		subBucketHalfCountMagnitude: subBucketCountMagnitude - 1,
+		subBucketCount:              1 << subBucketCountMagnitude,
+	}
+
+	l.subBucketHalfCount = l.subBucketCount / 2
+	l.subBucketMask = l.subBucketCount - 1
+
+	bucketCount := 1
+	smallestUntrackable := l.subBucketCount
+
+	for smallestUntrackable <= l.highest {
+		if smallestUntrackable > math.MaxInt64/2 {
+			bucketCount++
+			break
+		}
+		smallestUntrackable <<= 1
+		bucketCount++
+	}
+
+	l.countsLen = (bucketCount + 1) * int(l.subBucketHalfCount)
+	return l
+}
+
+// MemorySize returns the number of bytes used by the counts of each sketch
+// using the layout.
+func (l *HDRLayout) MemorySize() int {
+	return 8 * l.countsLen
+}
+
+func (l *HDRLayout) bucketIndex(v int64) int {
+	return 64 - bits.LeadingZeros64(uint64(v|l.subBucketMask)) - int(l.subBucketHalfCountMagnitude+1)
+}
+
+func (l *HDRLayout) countsIndex(v int64) int {
+	bucketIdx := l.bucketIndex(v)
+	subBucketIdx := v >> uint(bucketIdx)
+	return ((bucketIdx + 1) << l.subBucketHalfCountMagnitude) + int(subBucketIdx-l.subBucketHalfCount)
+}
+
+// highestEquivalentValue returns the largest value recorded in the same
+// counter as the values at index i.
+func (l *HDRLayout) highestEquivalentValue(i int) int64 {
+	bucketIdx := (i >> l.subBucketHalfCountMagnitude) - 1
+	subBucketIdx := int64(i&int(l.subBucketHalfCount-1)) + l.subBucketHalfCount
+
+	if bucketIdx < 0 {
+		subBucketIdx -= l.subBucketHalfCount
+		bucketIdx = 0
+	}
+
+	return (subBucketIdx << uint(bucketIdx)) + (1 << uint(bucketIdx)) - 1
+}
+
+// HDRSketch records the distribution of values in the buckets of a HDRLayout,
+// its memory footprint is fixed by the layout regardless of the number of
+// inserted values. Values below zero are recorded as zero, and values above
+// the highest value of the layout as the highest value.
+//
+// Sketches are meant to be reset and reused rather than reallocated, which
+// avoids allocating their counts again.
+//
+// HDRSketch values are not safe to use concurrently.
+type HDRSketch struct {
+	layout *HDRLayout
+	counts []int64
+	count  int64
+}
+
+// NewHDRSketch returns a sketch recording values in the buckets of layout.
+func NewHDRSketch(layout *HDRLayout) *HDRSketch {
+	return &HDRSketch{
+		layout: layout,
+		counts: make([]int64, layout.countsLen),
+	}
+}
+
+// Insert adds value to the sketch.
+func (s *HDRSketch) Insert(value float64) {
+	s.InsertN(value, 1)
+}
+
+// InsertN adds n occurrences of value to the sketch, which is how sampled
+// metrics are recorded, see Metric.SampleCount.
+func (s *HDRSketch) InsertN(value float64, n int64) {
+	l := s.layout
+	v := int64(0)
+
+	if value > 0 {
+		if x := value * l.scale; x >= float64(l.highest) {
+			v = l.highest
+		} else {
+			v = int64(x)
+		}
+	}
+
+	s.counts[l.countsIndex(v)] += n
+	s.count += n
+}
+
+// Count returns the number of values inserted in the sketch.
+func (s *HDRSketch) Count() int64 {
+	return s.count
+}
+
+// Query returns the value at the quantile q (between 0 and 1) of the values
+// inserted in the sketch using the nearest-rank method, with the precision of
+// the layout. NaN is returned when the sketch is empty.
+func (s *HDRSketch) Query(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+
+	l := s.layout
+	rank := int64(math.Ceil(q * float64(s.count)))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	total := int64(0)
+
+	for i, n := range s.counts {
+		if total += n; total >= rank {
+			return float64(l.highestEquivalentValue(i)) / l.scale
+		}
+	}
+
+	return float64(l.highest) / l.scale
+}
+
+// Reset discards the values inserted in the sketch, its counts are retained
+// for the values inserted next.
+func (s *HDRSketch) Reset() {
+	for i := range s.counts {
+		s.counts[i] = 0
+	}
+	s.count = 0
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHDRLayoutMemorySize(t *testing.T) {
+	tests := []struct {
+		digits int
+		size   int
+	}{
+		{1, 3712},
+		{2, 26624},
+		{3, 188416},
+	}
+
+	for _, test := range tests {
+		l := NewHDRLayout(DefaultHDRLowestValue, DefaultHDRHighestValue, test.digits)
+
+		if size := l.MemorySize(); size != test.size {
+			t.Error("bad memory size for", test.digits, "significant digits:", size)
+		}
+	}
+}
+
+func TestHDRSketchQuery(t *testing.T) {
+	for digits := 1; digits <= 3; digits++ {
+		s := NewHDRSketch(NewHDRLayout(DefaultHDRLowestValue, DefaultHDRHighestValue, digits))
+
+		// Values spanning five orders of magnitude, from 1ms to 100s.
+		values := []float64{}
+		for v := 1e-3; v <= 100; v *= 1.01 {
+			values = append(values, v)
+			s.Insert(v)
+		}
+
+		for _, q := range []float64{0.01, 0.25, 0.5, 0.9, 0.99, 0.999} {
+			exact := values[int(math.Ceil(q*float64(len(values))))-1]
+			value := s.Query(q)
+
+			if err := math.Abs(value-exact) / exact; err > 2*math.Pow10(-digits) {
+				t.Errorf("quantile %g with %d significant digits is off by %.2f%%: %g != %g", q, digits, err*100, value, exact)
+			}
+		}
+	}
+}
+
+func TestHDRSketchClamp(t *testing.T) {
+	s := NewHDRSketch(NewHDRLayout(1, 1000, 2))
+	s.Insert(-1)
+	s.Insert(1e6)
+
+	if q := s.Query(0); q != 0 {
+		t.Error("negative values were not recorded as zero:", q)
+	}
+
+	if q := s.Query(1); q < 1000 || q > 1010 {
+		t.Error("values above the range were not recorded as the highest value:", q)
+	}
+}
+
+func TestHDRSketchReset(t *testing.T) {
+	s := NewHDRSketch(NewHDRLayout(1, 1000, 2))
+	s.InsertN(500, 10)
+
+	if n := s.Count(); n != 10 {
+		t.Error("bad count:", n)
+	}
+
+	s.Reset()
+
+	if n, q := s.Count(), s.Query(0.5); n != 0 || !math.IsNaN(q) {
+		t.Error("the sketch was not reset:", n, q)
+	}
+
+	s.Insert(2)
+
+	if q := s.Query(1); q != 2 {
+		t.Error("values inserted before the reset were retained:", q)
+	}
+}
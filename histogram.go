@@ -1,13 +1,34 @@
 package stats
 
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
 // A Histogram represent a metric that reports a distribution of observed
 // values.
 type Histogram struct {
-	eng  *Engine // the engine to produce metrics on
-	name string  // the name of the counter
-	tags []Tag   // the tags set on the counter
+	eng  *Engine       // the engine to produce metrics on
+	name string        // the name of the counter
+	tags []Tag         // the tags set on the counter
+	unit time.Duration // the unit of durations reported by the histogram
+	kind *histogramKind
+}
+
+// histogramKind is shared by copies of a histogram to guard against values and
+// durations being reported on the same metric.
+type histogramKind struct {
+	discarded int64 // first for alignment of atomic operations
+	kind      int32
+	warned    int32
 }
 
+const (
+	histogramValues int32 = iota + 1
+	histogramDurations
+)
+
 // Name returns the name of the histogram.
 func (h *Histogram) Name() string {
 	return h.name
@@ -22,6 +43,15 @@ func (h *Histogram) Tags() []Tag {
 	return h.tags
 }
 
+// Unit returns the unit in which the histogram reports durations, which is
+// time.Second unless it was changed with WithDurationUnit.
+func (h *Histogram) Unit() time.Duration {
+	if h.unit == 0 {
+		return time.Second
+	}
+	return h.unit
+}
+
 // WithTags returns a copy of the histogram, potentially setting tags on the
 // returned object.
 func (h *Histogram) WithTags(tags ...Tag) *Histogram {
@@ -29,10 +59,136 @@ func (h *Histogram) WithTags(tags ...Tag) *Histogram {
 		eng:  h.eng,
 		name: h.name,
 		tags: concatTags(h.tags, tags),
+		unit: h.unit,
+		kind: h.kind,
+	}
+}
+
+// WithDurationUnit returns a copy of the histogram which reports durations
+// passed to ObserveDuration in unit, for example time.Millisecond.
+func (h *Histogram) WithDurationUnit(unit time.Duration) *Histogram {
+	if unit <= 0 {
+		unit = time.Second
+	}
+
+	h.eng.describe(MetricSchema{
+		Type:      HistogramType,
+		Namespace: h.eng.name,
+		Name:      h.name,
+		Unit:      durationUnitName(unit),
+	})
+
+	return &Histogram{
+		eng:  h.eng,
+		name: h.name,
+		tags: h.tags,
+		unit: unit,
+		kind: h.kind,
+	}
+}
+
+// WithHelp sets the help text of the histogram's metric, which handlers like
+// the prometheus handler expose, and returns the histogram so the call can be
+// chained with its creation.
+func (h *Histogram) WithHelp(help string) *Histogram {
+	h.eng.describeHelp(HistogramType, h.name, help)
+	return h
+}
+
+// WithoutEngineTags returns a copy of the histogram which doesn't inherit the
+// tags of the engine it was created on, only the tags set on the histogram are
+// reported.
+func (h *Histogram) WithoutEngineTags() *Histogram {
+	return &Histogram{
+		eng:  h.eng.withoutTags(),
+		name: h.name,
+		tags: h.tags,
+		unit: h.unit,
+		kind: h.kind,
 	}
 }
 
 // Observe reports a value observed by the histogram.
 func (h *Histogram) Observe(value float64) {
-	h.eng.Observe(h.name, value, h.tags...)
+	if h.guard(histogramValues) {
+		h.eng.Observe(h.name, value, h.tags...)
+	}
+}
+
+// ObserveWithExemplar reports a value observed by the histogram with an exemplar
+// identified by tags, for example the id of the current trace:
+//
+//	h.ObserveWithExemplar(latency, stats.Tag{"trace_id", traceID})
+//
+// The exemplar tags are not tags of the series, handlers which support
+// exemplars, like the prometheus handler in the OpenMetrics format, expose
+// them alongside the bucket of the value.
+func (h *Histogram) ObserveWithExemplar(value float64, exemplar ...Tag) {
+	if h.guard(histogramValues) {
+		h.eng.ObserveWithExemplar(h.name, value, exemplar, h.tags...)
+	}
+}
+
+// ObserveDuration reports a duration observed by the histogram, expressed in
+// the unit of the histogram.
+//
+// A histogram should report either values or durations, mixing calls to
+// Observe and ObserveDuration on the same histogram likely means that values
+// of different units are mixed. The first method called determines the kind
+// of values that the histogram accepts, calls to the other method are
+// discarded, the first one is logged and all of them are counted, see
+// Discarded.
+func (h *Histogram) ObserveDuration(value time.Duration) {
+	if h.guard(histogramDurations) {
+		unit := h.Unit()
+		h.eng.handle(HistogramType, h.name, float64(value)/float64(unit), durationUnitName(unit), h.tags, time.Time{}, time.Time{}, nil)
+	}
+}
+
+func (h *Histogram) guard(kind int32) bool {
+	g := h.kind
+
+	if g == nil {
+		return true // histograms created internally, like clocks, are not guarded
+	}
+
+	if atomic.LoadInt32(&g.kind) == kind || atomic.CompareAndSwapInt32(&g.kind, 0, kind) {
+		return true
+	}
+
+	atomic.AddInt64(&g.discarded, 1)
+
+	if atomic.CompareAndSwapInt32(&g.warned, 0, 1) {
+		log.Printf("stats: discarding observations of mixed values and durations on histogram %s", h.name)
+	}
+
+	return false
+}
+
+// Discarded returns the number of observations discarded by the histogram and
+// its copies because they mixed values and durations, see ObserveDuration.
+func (h *Histogram) Discarded() int64 {
+	if h.kind == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&h.kind.discarded)
+}
+
+func durationUnitName(unit time.Duration) string {
+	switch unit {
+	case time.Nanosecond:
+		return "nanoseconds"
+	case time.Microsecond:
+		return "microseconds"
+	case time.Millisecond:
+		return "milliseconds"
+	case time.Second:
+		return "seconds"
+	case time.Minute:
+		return "minutes"
+	case time.Hour:
+		return "hours"
+	default:
+		return unit.String()
+	}
 }
@@ -3,6 +3,7 @@ package stats
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestHistogramIncr(t *testing.T) {
@@ -52,6 +53,36 @@ func TestHistogramSet(t *testing.T) {
 	}
 }
 
+func TestHistogramObserveWithExemplar(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	m := e.Histogram("A", Tag{"base", "tag"})
+	m.ObserveWithExemplar(1, Tag{"trace_id", "1234"})
+	m.Observe(2)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Tags:      []Tag{{"base", "tag"}},
+			Value:     1,
+			Exemplar:  []Tag{{"trace_id", "1234"}},
+		},
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Tags:      []Tag{{"base", "tag"}},
+			Value:     2,
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
 func TestHistogramWithTags(t *testing.T) {
 	e := NewEngine("E")
 	c1 := e.Histogram("A", Tag{"base", "tag"})
@@ -76,3 +107,68 @@ func BenchmarkHistogram(b *testing.B) {
 		}
 	})
 }
+
+func TestHistogramObserveDuration(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	m := e.Histogram("A").WithDurationUnit(time.Millisecond)
+	m.ObserveDuration(1500 * time.Microsecond)
+	m.WithTags(Tag{"extra", "tag"}).ObserveDuration(2 * time.Millisecond)
+
+	// Mixing values and durations on the same histogram is not allowed.
+	m.Observe(1)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     1.5,
+			Unit:      "milliseconds",
+		},
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     2,
+			Unit:      "milliseconds",
+			Tags:      []Tag{{"extra", "tag"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if schema := e.Schema(); len(schema) != 1 || schema[0].Unit != "milliseconds" {
+		t.Error("bad schema:", schema)
+	}
+
+	m.WithTags(Tag{"extra", "tag"}).Observe(2)
+
+	if n := m.Discarded(); n != 2 {
+		t.Error("bad number of discarded observations:", n)
+	}
+}
+
+func TestHistogramObserveDurationDefaultUnit(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	m := e.Histogram("A")
+	m.ObserveDuration(1500 * time.Millisecond)
+	m.Observe(1)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "A",
+			Value:     1.5,
+			Unit:      "seconds",
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
@@ -102,8 +102,8 @@ func (m metrics) observeBodyLength(len int, tags ...stats.Tag) {
 	m.eng.Observe("http.message.body.bytes", float64(len), tags...)
 }
 
-func (m metrics) observeRTT(rtt time.Duration, tags ...stats.Tag) {
-	m.eng.Observe("http.rtt.seconds", rtt.Seconds(), tags...)
+func (m metrics) incrMessageCountAndObserveRTT(rtt time.Duration, tags ...stats.Tag) {
+	m.eng.IncrAndObserve("http.message.count", "http.rtt.seconds", rtt.Seconds(), tags...)
 }
 
 func (m metrics) observeRequest(req *http.Request, op string, bodyLen int) {
@@ -132,11 +132,10 @@ func (m metrics) observeResponse(res *http.Response, op string, bodyLen int, rtt
 		t = appendRequestTags(t, req)
 	}
 
-	m.incrMessageCount(t...)
+	m.incrMessageCountAndObserveRTT(rtt, t...)
 	m.observeHeaderSize(len(res.Header), t...)
 	m.observeHeaderLength(responseHeaderLength(res), t...)
 	m.observeBodyLength(bodyLen, t...)
-	m.observeRTT(rtt, t...)
 }
 
 func (m metrics) observeError(req *http.Request, op string) {
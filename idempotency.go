@@ -0,0 +1,164 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdempotencyWindow is the default duration during which engines
+	// remember the idempotency keys of the metrics they reported.
+	DefaultIdempotencyWindow = 5 * time.Minute
+
+	// DefaultMaxIdempotencyKeys is the default maximum number of idempotency
+	// keys that engines remember.
+	DefaultMaxIdempotencyKeys = 10000
+)
+
+// AddOnce adds value to the counter with name and tags on eng, unless a value
+// was already added to the counter with the same idempotency key during the
+// idempotency window of the engine. The method returns whether the value was
+// reported.
+//
+// This is useful to prevent double-counting events in code paths which may be
+// retried, for example by using the identifier of a request or message as key.
+// Keys are scoped to the namespace and name of the metric, so the same key can
+// be used to report the event on multiple metrics, but not to tags.
+//
+// The guarantee is at-most-once within the window only: a key seen again after
+// the window expired is treated as a new key, and so is a key which was evicted
+// because the engine remembered MaxIdempotencyKeys more recent keys.
+func (eng *Engine) AddOnce(key string, name string, value float64, tags ...Tag) bool {
+	return eng.handleOnce(key, CounterType, name, value, tags)
+}
+
+// IncrOnce increments by 1 the counter with name and tags on eng, unless it
+// was already incremented with the same idempotency key. See AddOnce.
+func (eng *Engine) IncrOnce(key string, name string, tags ...Tag) bool {
+	return eng.handleOnce(key, CounterType, name, 1, tags)
+}
+
+// ObserveOnce reports a value on the histogram with name and tags on eng,
+// unless a value was already reported with the same idempotency key. See
+// AddOnce.
+func (eng *Engine) ObserveOnce(key string, name string, value float64, tags ...Tag) bool {
+	return eng.handleOnce(key, HistogramType, name, value, tags)
+}
+
+func (eng *Engine) handleOnce(key string, typ MetricType, name string, value float64, tags []Tag) bool {
+	if !eng.enabled() {
+		return false
+	}
+
+	if !eng.idempotency.record(eng.name, name, key) {
+		return false
+	}
+
+	eng.handle(typ, name, value, "", tags, time.Time{}, time.Time{}, nil)
+	return true
+}
+
+type idempotencyKey struct {
+	namespace string
+	name      string
+	key       string
+}
+
+type idempotencyEntry struct {
+	key  idempotencyKey
+	time time.Time
+}
+
+// idempotencyCache remembers the idempotency keys seen by engines, it is
+// bounded both in time and in size. Entries are kept in the order they were
+// recorded, which is also the order in which they expire since they all share
+// the same window.
+type idempotencyCache struct {
+	window time.Duration
+	max    int
+	now    func() time.Time
+	mutex  sync.Mutex
+	keys   map[idempotencyKey]time.Time
+	queue  []idempotencyEntry
+	head   int
+}
+
+func newIdempotencyCache(window time.Duration, max int) *idempotencyCache {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+
+	if max <= 0 {
+		max = DefaultMaxIdempotencyKeys
+	}
+
+	return &idempotencyCache{
+		window: window,
+		max:    max,
+		now:    time.Now,
+		keys:   make(map[idempotencyKey]time.Time),
+	}
+}
+
+// record returns true if key was not seen for the metric during the window,
+// in which case it is remembered until the window expires. The window starts
+// when a key is first seen, duplicates do not extend it. A nil cache, which is
+// the cache of zero-value engines, records every key.
+func (c *idempotencyCache) record(namespace string, name string, key string) bool {
+	if c == nil {
+		return true
+	}
+
+	k := idempotencyKey{namespace, name, key}
+	now := c.now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expire(now)
+
+	if _, ok := c.keys[k]; ok {
+		return false
+	}
+
+	if len(c.keys) >= c.max {
+		c.pop()
+	}
+
+	c.keys[k] = now
+	c.queue = append(c.queue, idempotencyEntry{key: k, time: now})
+	return true
+}
+
+func (c *idempotencyCache) expire(now time.Time) {
+	for c.head != len(c.queue) && now.Sub(c.queue[c.head].time) >= c.window {
+		c.pop()
+	}
+}
+
+func (c *idempotencyCache) pop() {
+	e := c.queue[c.head]
+	c.queue[c.head] = idempotencyEntry{}
+	c.head++
+
+	if t, ok := c.keys[e.key]; ok && t.Equal(e.time) {
+		delete(c.keys, e.key)
+	}
+
+	if c.head == len(c.queue) {
+		c.queue, c.head = c.queue[:0], 0
+	} else if c.head > len(c.queue)/2 {
+		n := copy(c.queue, c.queue[c.head:])
+		for i := n; i != len(c.queue); i++ {
+			c.queue[i] = idempotencyEntry{}
+		}
+		c.queue, c.head = c.queue[:n], 0
+	}
+}
+
+func (c *idempotencyCache) len() int {
+	c.mutex.Lock()
+	n := len(c.keys)
+	c.mutex.Unlock()
+	return n
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEngineIdempotency(t *testing.T) {
+	now := time.Now()
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:              "E",
+		IdempotencyWindow: time.Minute,
+	})
+	e.Register(h)
+	e.idempotency.now = func() time.Time { return now }
+
+	tests := []struct {
+		report func() bool
+		expect bool
+	}{
+		{func() bool { return e.IncrOnce("msg-1", "messages") }, true},
+		{func() bool { return e.IncrOnce("msg-1", "messages") }, false},
+		{func() bool { return e.WithTags(Tag{"retry", "1"}).IncrOnce("msg-1", "messages") }, false},
+		{func() bool { return e.IncrOnce("msg-2", "messages") }, true},
+		{func() bool { return e.ObserveOnce("msg-1", "bytes", 42) }, true},
+		{func() bool { return e.WithName("F").AddOnce("msg-1", "messages", 2) }, true},
+	}
+
+	for i, test := range tests {
+		if ok := test.report(); ok != test.expect {
+			t.Errorf("#%d: reported=%t, expected %t", i, ok, test.expect)
+		}
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if e.IncrOnce("msg-1", "messages") {
+		t.Error("a duplicate within the window was reported")
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if !e.IncrOnce("msg-1", "messages") {
+		t.Error("a key seen again after the window expired was not reported")
+	}
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "messages", Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "messages", Value: 1},
+		{Type: HistogramType, Namespace: "E", Name: "bytes", Value: 42},
+		{Type: CounterType, Namespace: "F", Name: "messages", Value: 2},
+		{Type: CounterType, Namespace: "E", Name: "messages", Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestIdempotencyCacheBounded(t *testing.T) {
+	now := time.Now()
+	c := newIdempotencyCache(time.Minute, 3)
+	c.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if !c.record("E", "messages", key) {
+			t.Errorf("%s: not recorded", key)
+		}
+		now = now.Add(time.Second)
+	}
+
+	if n := c.len(); n != 3 {
+		t.Error("bad number of keys:", n)
+	}
+
+	if !c.record("E", "messages", "a") {
+		t.Error("an evicted key was not treated as new")
+	}
+
+	if c.record("E", "messages", "d") {
+		t.Error("a recent key was evicted")
+	}
+
+	now = now.Add(time.Hour)
+	c.record("E", "messages", "e")
+
+	if n := c.len(); n != 1 {
+		t.Error("expired keys were not forgotten:", n)
+	}
+}
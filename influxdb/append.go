@@ -0,0 +1,105 @@
+package influxdb
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// field represents a single field of a line-protocol entry.
+type field struct {
+	name  string
+	value float64
+}
+
+// AppendMetric appends the line protocol representation of m to b, using the
+// metric time or the current time if it is not set.
+func AppendMetric(b []byte, m *stats.Metric) []byte {
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return appendMetric(b, m, t)
+}
+
+func appendMetric(b []byte, m *stats.Metric, t time.Time) []byte {
+	value := m.Value
+
+	if m.Type == stats.CounterType {
+		// The increment of a sampled counter stands for the increments
+		// which were discarded.
+		value *= float64(m.SampleCount())
+	}
+
+	return appendLine(b, m.Namespace, m.Name, m.Tags, []field{{"value", value}}, t)
+}
+
+func appendLine(b []byte, namespace string, name string, tags []stats.Tag, fields []field, t time.Time) []byte {
+	if len(namespace) != 0 {
+		b = appendEscaped(b, namespace, ", ")
+		b = append(b, '.')
+	}
+
+	b = appendEscaped(b, name, ", ")
+
+	for _, tag := range tags {
+		if len(tag.Name) == 0 || len(tag.Value) == 0 {
+			continue // influxdb rejects empty tag keys or values
+		}
+		b = append(b, ',')
+		b = appendEscaped(b, tag.Name, ", =")
+		b = append(b, '=')
+		b = appendEscaped(b, tag.Value, ", =")
+	}
+
+	for i, f := range fields {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = appendEscaped(b, f.name, ", =")
+		b = append(b, '=')
+		b = strconv.AppendFloat(b, f.value, 'g', -1, 64)
+	}
+
+	if !t.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.UnixNano(), 10)
+	}
+
+	return append(b, '\n')
+}
+
+// appendTimestamps appends the lines to b, adding timestamp t to each of them.
+func appendTimestamps(b []byte, lines []byte, t time.Time) []byte {
+	ts := strconv.AppendInt(append(make([]byte, 0, 20), ' '), t.UnixNano(), 10)
+
+	for len(lines) != 0 {
+		i := bytes.IndexByte(lines, '\n')
+		b = append(b, lines[:i]...)
+		b = append(b, ts...)
+		b = append(b, '\n')
+		lines = lines[i+1:]
+	}
+
+	return b
+}
+
+func appendEscaped(b []byte, s string, chars string) []byte {
+	for i := 0; i != len(s); i++ {
+		c := s[i]
+
+		for j := 0; j != len(chars); j++ {
+			if c == chars[j] {
+				b = append(b, '\\')
+				break
+			}
+		}
+
+		b = append(b, c)
+	}
+	return b
+}
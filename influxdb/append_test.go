@@ -0,0 +1,62 @@
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAppendMetric(t *testing.T) {
+	tests := []struct {
+		s string
+		m stats.Metric
+	}{
+		{
+			s: "test.metric.small value=0 1000000000\n",
+			m: stats.Metric{
+				Namespace: "test",
+				Name:      "metric.small",
+			},
+		},
+		{
+			s: "test.metric.common,hello=world,answer=42 value=1 1000000000\n",
+			m: stats.Metric{
+				Namespace: "test",
+				Name:      "metric.common",
+				Tags:      []stats.Tag{{"hello", "world"}, {"answer", "42"}, {"empty", ""}},
+				Value:     1,
+			},
+		},
+		{
+			s: "test\\ metric\\,escaped,a\\ b=c\\=d value=0.5 1000000000\n",
+			m: stats.Metric{
+				Name:  "test metric,escaped",
+				Tags:  []stats.Tag{{"a b", "c=d"}},
+				Value: 0.5,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.m.Name, func(t *testing.T) {
+			if s := string(appendMetric(nil, &test.m, time.Unix(1, 0))); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func BenchmarkAppendMetric(b *testing.B) {
+	buffer := make([]byte, 4096)
+	metric := &stats.Metric{
+		Name:  "test.metric.common",
+		Tags:  []stats.Tag{{"hello", "world"}, {"answer", "42"}},
+		Value: 1,
+	}
+	now := time.Now()
+
+	for i := 0; i != b.N; i++ {
+		appendMetric(buffer[:0], metric, now)
+	}
+}
@@ -0,0 +1,558 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultAddress is the default address of the influxdb server that
+	// clients send metrics to.
+	DefaultAddress = "http://localhost:8086"
+
+	// DefaultDatabase is the default database that clients write metrics to.
+	DefaultDatabase = "stats"
+
+	// DefaultBufferSize is the default size of the client buffer, the buffer
+	// is retained until the next flush when it reaches this size.
+	DefaultBufferSize = 64 * 1024
+
+	// DefaultBatchSize is the default number of lines sent in each request,
+	// it is the batch size recommended by influxdb.
+	DefaultBatchSize = 5000
+
+	// DefaultMaxPendingBatches is the default number of full batches of lines
+	// that clients retain until they are flushed.
+	DefaultMaxPendingBatches = 16
+
+	// DefaultTimeout is the default timeout of requests sent to the server.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultReservoirSize is the default number of values that histograms
+	// retain to compute percentiles.
+	DefaultReservoirSize = 1028
+
+	// DefaultMaxRetries is the default number of times that requests which
+	// failed are retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the default delay before the first retry of a
+	// failed request, the delay doubles on each retry.
+	DefaultRetryDelay = 500 * time.Millisecond
+)
+
+// The ClientConfig type is used to configure influxdb clients.
+type ClientConfig struct {
+	// Address of the influxdb server to send metrics to.
+	Address string
+
+	// Database is the name of the database that metrics are written to by
+	// the /write endpoint of InfluxDB 1.x.
+	Database string
+
+	// Bucket is the name of the bucket that metrics are written to. Setting
+	// it makes the client use the /api/v2/write endpoint of InfluxDB 2.x
+	// instead of the /write endpoint of InfluxDB 1.x, Database is then
+	// ignored.
+	Bucket string
+
+	// Organization is the name or id of the organization owning Bucket.
+	Organization string
+
+	// Token is the API token that requests are authenticated with, it is
+	// sent in the Authorization header. InfluxDB 1.x servers accept tokens of
+	// the form "username:password".
+	Token string
+
+	// BufferSize is the size of the output buffer used by the client.
+	BufferSize int
+
+	// BatchSize is the number of lines sent in each request, defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// MaxPendingBatches is the number of full batches of lines retained by the
+	// client until it is flushed, defaults to DefaultMaxPendingBatches. Lines
+	// received when this number is reached are dropped, see Dropped.
+	MaxPendingBatches int
+
+	// FlushInterval enables flushing the client in the background at this
+	// interval, in addition to the flushes of the engine it is registered
+	// on. The background flushes are stopped by closing the client.
+	FlushInterval time.Duration
+
+	// Timeout is the maximum amount of time that requests sent to the server
+	// are allowed to take.
+	Timeout time.Duration
+
+	// Transport is the HTTP transport used by the client to send requests,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// MaxRetries is the number of times that requests which were throttled
+	// (429), failed with a server error (5xx) or a network error are retried,
+	// a negative value disables retries. Requests rejected with other client
+	// errors are not retried.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry of a failed request, the
+	// delay doubles on each retry.
+	RetryDelay time.Duration
+
+	// Timestamps is the source of the timestamps of the lines sent to the
+	// server, defaults to stats.MetricTimestamp. With stats.NoTimestamp the
+	// server uses the time at which it receives the lines.
+	Timestamps stats.TimestampSource
+
+	// Percentiles is the list of percentiles (between 0 and 1) that histograms
+	// are decomposed into when the client is flushed.
+	//
+	// When the list is empty every observed value is sent to the server.
+	// Otherwise the client aggregates histograms between flushes and reports
+	// each series once with the count, sum, min and max of the values, and
+	// one field per percentile named after it (p50, p95, p99.9...).
+	Percentiles []float64
+
+	// ReservoirSize is the maximum number of values retained by histograms to
+	// compute percentiles. Percentiles are exact when fewer values than the
+	// reservoir size were observed between flushes, and estimated from a
+	// uniform sample of the values otherwise.
+	ReservoirSize int
+
+	// SignificantDigits enables computing percentiles with HDR histograms
+	// instead of reservoirs when set to a value between 1 and 5.
+	//
+	// HDR histograms record every observed value in logarithmic buckets with
+	// a relative error bounded by 10^-SignificantDigits, which suits values
+	// with a wide dynamic range like latencies spanning microseconds to
+	// seconds. Each series uses a fixed amount of memory determined by the
+	// number of significant digits and the LowestValue to HighestValue
+	// range, for example about 26 KB with 2 digits and 190 KB with 3 digits
+	// using the default range. The histograms are recorded in
+	// stats.HDRSketch values, which are reused across flushes.
+	SignificantDigits int
+
+	// LowestValue is the smallest value that HDR histograms distinguish from
+	// zero, defaults to stats.DefaultHDRLowestValue.
+	LowestValue float64
+
+	// HighestValue is the largest value tracked by HDR histograms, larger
+	// values are recorded as HighestValue. Defaults to
+	// stats.DefaultHDRHighestValue.
+	HighestValue float64
+
+	// Compressor is the compression codec applied to the bodies of requests
+	// sent to the server, requests are not compressed when it is nil.
+	// stats.GzipCompressor is supported by all servers.
+	Compressor stats.Compressor
+}
+
+// Client represents an influxdb client that receives metrics from a stats
+// engine and writes them to an influxdb server using the line protocol.
+//
+// Lines are only sent to the server when the client is flushed, outside of the
+// lock held by HandleMetric, so a slow or unavailable server never blocks the
+// code producing metrics.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	dropped int64
+	mutex   sync.Mutex
+	config  ClientConfig
+	url     string
+	httpc   http.Client
+	buffer  []byte
+	count   int      // number of lines in buffer
+	pending [][]byte // full batches, sent by the next flush
+	series  map[string]*series
+	rng     *rand.Rand
+	layout  *stats.HDRLayout
+	free    []*stats.HDRSketch // sketches of the series removed by flushes
+
+	// The buffers used to send batches, sends are serialized by sendMutex.
+	sendMutex sync.Mutex
+	lines     []byte // buffer with timestamps, used with stats.FlushTimestamp
+	zbuf      bytes.Buffer
+	zpool     *stats.CompressorPool
+
+	done chan struct{}
+	once sync.Once
+}
+
+type series struct {
+	namespace string
+	name      string
+	tags      []stats.Tag
+	res       *stats.Reservoir
+	hdr       *stats.HDRSketch
+}
+
+// NewClient creates and returns a new influxdb client publishing metrics to
+// the server at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new influxdb client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if len(config.Database) == 0 {
+		config.Database = DefaultDatabase
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.BatchSize == 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	if config.ReservoirSize == 0 {
+		config.ReservoirSize = DefaultReservoirSize
+	}
+
+	if config.MaxPendingBatches <= 0 {
+		config.MaxPendingBatches = DefaultMaxPendingBatches
+	}
+
+	percentiles := make([]float64, 0, len(config.Percentiles))
+
+	for _, p := range config.Percentiles {
+		if p < 0 || p > 1 {
+			log.Printf("stats/influxdb: ignoring percentile out of the [0, 1] range: %g", p)
+			continue
+		}
+		percentiles = append(percentiles, p)
+	}
+
+	config.Percentiles = percentiles
+
+	var layout *stats.HDRLayout
+
+	if config.SignificantDigits != 0 {
+		if config.SignificantDigits < 1 || config.SignificantDigits > stats.MaxHDRSignificantDigits {
+			log.Printf("stats/influxdb: ignoring significant digits out of the [1, %d] range: %d", stats.MaxHDRSignificantDigits, config.SignificantDigits)
+		} else {
+			layout = stats.NewHDRLayout(config.LowestValue, config.HighestValue, config.SignificantDigits)
+		}
+	}
+
+	c := &Client{
+		config: config,
+		url:    writeURL(config),
+		httpc: http.Client{
+			Transport: config.Transport,
+			Timeout:   config.Timeout,
+		},
+		buffer: make([]byte, 0, config.BufferSize),
+		series: make(map[string]*series),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		layout: layout,
+		done:   make(chan struct{}),
+	}
+
+	if config.Compressor != nil {
+		c.zpool = stats.NewCompressorPool(config.Compressor)
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	}
+
+	return c
+}
+
+// Close satisfies the io.Closer interface, it stops the background flushes and
+// flushes the client.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.Flush()
+	return nil
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// the server are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	now := c.now()
+	batches := c.pending
+	c.pending = nil
+
+	for key, s := range c.series {
+		c.buffer = appendLine(c.buffer, s.namespace, s.name, s.tags, c.fields(s), now)
+		delete(c.series, key)
+
+		if s.hdr != nil {
+			s.hdr.Reset()
+			c.free = append(c.free, s.hdr)
+		}
+
+		if c.count++; c.count >= c.config.BatchSize {
+			batches = append(batches, c.swap())
+		}
+	}
+
+	if len(c.buffer) != 0 {
+		batches = append(batches, c.swap())
+	}
+
+	c.mutex.Unlock()
+
+	for _, b := range batches {
+		c.flush(ctx, b)
+	}
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	if c.config.Timestamps != stats.MetricTimestamp {
+		t = time.Time{}
+	}
+
+	c.mutex.Lock()
+
+	if (m.Type == stats.HistogramType || m.Type == stats.SummaryType || m.Type == stats.ExponentialHistogramType) && len(c.config.Percentiles) != 0 {
+		c.observe(m)
+	} else {
+		c.buffer = appendMetric(c.buffer, m, t)
+
+		if c.count++; c.count >= c.config.BatchSize || len(c.buffer) >= c.config.BufferSize {
+			c.enqueue()
+		}
+	}
+
+	c.mutex.Unlock()
+}
+
+func (c *Client) observe(m *stats.Metric) {
+	key := appendLine(nil, m.Namespace, m.Name, m.Tags, nil, time.Time{})
+	s := c.series[string(key)]
+
+	if s == nil {
+		s = &series{
+			namespace: m.Namespace,
+			name:      m.Name,
+			tags:      append([]stats.Tag(nil), m.Tags...),
+		}
+
+		// With HDR histograms the reservoir retains no values, it only
+		// tracks the count, sum, min and max.
+		if c.layout != nil {
+			s.res = stats.NewReservoir(0, nil)
+			s.hdr = c.sketch()
+		} else {
+			s.res = stats.NewReservoir(c.config.ReservoirSize, c.rng)
+		}
+
+		c.series[string(key)] = s
+	}
+
+	n := m.SampleCount()
+	s.res.InsertN(m.Value, int64(n))
+
+	if s.hdr != nil {
+		s.hdr.InsertN(m.Value, int64(n))
+	}
+}
+
+func (c *Client) fields(s *series) []field {
+	fields := append(make([]field, 0, 4+len(c.config.Percentiles)),
+		field{"count", float64(s.res.Count())},
+		field{"sum", s.res.Sum()},
+		field{"min", s.res.Min()},
+		field{"max", s.res.Max()},
+	)
+
+	query := s.res.Query
+	if s.hdr != nil {
+		query = s.hdr.Query
+	}
+
+	for _, p := range c.config.Percentiles {
+		fields = append(fields, field{stats.PercentileName(p), query(p)})
+	}
+
+	return fields
+}
+
+// sketch returns a HDR sketch for a new series, reusing the sketch of a series
+// removed by a previous flush when there is one.
+func (c *Client) sketch() *stats.HDRSketch {
+	if n := len(c.free); n != 0 {
+		h := c.free[n-1]
+		c.free[n-1] = nil
+		c.free = c.free[:n-1]
+		return h
+	}
+	return stats.NewHDRSketch(c.layout)
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// requests to the server which failed.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// lines discarded because MaxPendingBatches was reached.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// now returns the timestamp of the lines generated by the client when it is
+// flushed, the zero time means that the lines have no timestamps.
+func (c *Client) now() time.Time {
+	if c.config.Timestamps == stats.MetricTimestamp {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+func (c *Client) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue retains the full buffer until the next flush, its lines are dropped
+// when MaxPendingBatches batches are already retained.
+func (c *Client) enqueue() {
+	if len(c.pending) < c.config.MaxPendingBatches {
+		c.pending = append(c.pending, c.swap())
+		return
+	}
+
+	atomic.AddInt64(&c.dropped, int64(c.count))
+	c.buffer = c.buffer[:0]
+	c.count = 0
+}
+
+// swap returns the buffer of lines and replaces it with an empty one.
+func (c *Client) swap() []byte {
+	b := c.buffer
+	c.buffer = make([]byte, 0, c.config.BufferSize)
+	c.count = 0
+	return b
+}
+
+func (c *Client) flush(ctx context.Context, b []byte) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	if c.config.Timestamps == stats.FlushTimestamp {
+		c.lines = appendTimestamps(c.lines[:0], b, time.Now())
+		b = c.lines
+	}
+
+	if err := c.write(ctx, b); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/influxdb: sending metrics to %s failed: %s", c.config.Address, err)
+	}
+}
+
+// write sends b to the server, retrying requests which were throttled or failed
+// with a server error with an exponential backoff, until ctx is canceled.
+func (c *Client) write(ctx context.Context, b []byte) error {
+	if c.zpool != nil {
+		c.zbuf.Reset()
+
+		if err := c.zpool.Compress(&c.zbuf, b); err != nil {
+			return err
+		}
+
+		b = c.zbuf.Bytes()
+	}
+
+	return retry.Do(ctx, c.config.MaxRetries, c.config.RetryDelay, func() (bool, error) {
+		return c.send(ctx, b)
+	})
+}
+
+// send sends a single request with the body b, it returns true if the request
+// failed and may be retried.
+func (c *Client) send(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if c.zpool != nil {
+		req.Header.Set("Content-Encoding", c.zpool.Encoding())
+	}
+
+	if len(c.config.Token) != 0 {
+		req.Header.Set("Authorization", "Token "+c.config.Token)
+	}
+
+	res, err := c.httpc.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	return retry.CheckResponse(res)
+}
+
+// writeURL returns the URL of the write endpoint of the server, which is the
+// endpoint of InfluxDB 2.x when a bucket is configured.
+func writeURL(config ClientConfig) string {
+	q := url.Values{}
+	q.Set("precision", "ns")
+
+	if len(config.Bucket) != 0 {
+		q.Set("bucket", config.Bucket)
+		q.Set("org", config.Organization)
+		return config.Address + "/api/v2/write?" + q.Encode()
+	}
+
+	q.Set("db", config.Database)
+	return config.Address + "/write?" + q.Encode()
+}
@@ -0,0 +1,463 @@
+package influxdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/testserver"
+)
+
+func startTestServer(t *testing.T) (*httptest.Server, func() []string) {
+	server := testserver.New(t, testserver.Config{
+		Status: http.StatusNoContent,
+		Check: func(req *http.Request) error {
+			if req.URL.Path != "/write" || req.URL.Query().Get("db") != "test" {
+				return fmt.Errorf("bad request: %s", req.URL)
+			}
+			return nil
+		},
+	})
+
+	return server.Server, func() []string {
+		var lines []string
+		for _, b := range server.Bodies() {
+			lines = append(lines, strings.Split(strings.TrimSpace(b), "\n")...)
+		}
+		return lines
+	}
+}
+
+func TestClient(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:  server.URL,
+		Database: "test",
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+	engine.Incr("A")
+	engine.Set("B", 2)
+	engine.Observe("C", 3)
+	engine.Observe("C", 4)
+	engine.Flush()
+
+	if n := len(lines()); n != 4 {
+		t.Error("bad number of lines written:", n, lines())
+	}
+}
+
+func TestClientPercentiles(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:     server.URL,
+		Database:    "test",
+		Percentiles: []float64{0.5, 0.99, 0.999},
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+
+	for i := 1; i <= 100; i++ {
+		engine.Observe("latency", float64(i), stats.Tag{"op", "read"})
+	}
+
+	engine.Flush()
+	written := lines()
+
+	if len(written) != 1 {
+		t.Fatal("bad number of lines written:", written)
+	}
+
+	line := written[0]
+	line = line[:strings.LastIndexByte(line, ' ')] // strip the timestamp
+
+	if line != "influxdb.test.latency,op=read count=100,sum=5050,min=1,max=100,p50=50,p99=99,p99.9=100" {
+		t.Error("bad line:", line)
+	}
+}
+
+func TestClientSampleCount(t *testing.T) {
+	for _, layout := range []string{"reservoir", "hdr"} {
+		t.Run(layout, func(t *testing.T) {
+			server, lines := startTestServer(t)
+			defer server.Close()
+
+			config := ClientConfig{
+				Address:     server.URL,
+				Database:    "test",
+				Percentiles: []float64{0.5},
+				Timestamps:  stats.NoTimestamp,
+			}
+
+			if layout == "hdr" {
+				config.SignificantDigits = 3
+			}
+
+			client := NewClientWith(config)
+			client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1, Rate: 0.5})
+			client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 1, Rate: 0.25})
+			client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 10})
+			client.Flush()
+
+			written := lines()
+			sort.Strings(written)
+
+			// HDR histograms report the percentile with their precision, the
+			// prefix of its value is compared.
+			if len(written) != 2 || !strings.HasPrefix(written[0], "latency count=5,sum=14,min=1,max=10,p50=1") || written[1] != "requests value=2" {
+				t.Error("sampled metrics were not weighted by their sample count:", written)
+			}
+		})
+	}
+}
+
+func TestClientHDRPercentiles(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:           server.URL,
+		Database:          "test",
+		Percentiles:       []float64{0.5, 0.99},
+		SignificantDigits: 3,
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+
+	for i := 1; i <= 100; i++ {
+		engine.Observe("latency", float64(i), stats.Tag{"op", "read"})
+	}
+
+	engine.Flush()
+	written := lines()
+
+	if len(written) != 1 {
+		t.Fatal("bad number of lines written:", written)
+	}
+
+	line := written[0]
+	line = line[:strings.LastIndexByte(line, ' ')] // strip the timestamp
+
+	if line != "influxdb.test.latency,op=read count=100,sum=5050,min=1,max=100,p50=50.003967,p99=99.024895" {
+		t.Error("bad line:", line)
+	}
+}
+
+func TestClientHDRReuse(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:           server.URL,
+		Database:          "test",
+		Percentiles:       []float64{0.99},
+		SignificantDigits: 2,
+		Timestamps:        stats.NoTimestamp,
+	})
+
+	client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 100})
+	sketch := onlySketch(t, client)
+	client.Flush()
+
+	client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 1})
+
+	if onlySketch(t, client) != sketch {
+		t.Error("the sketch of the flushed series was not reused")
+	}
+
+	client.Flush()
+
+	// The percentile is reported with the precision of the sketch.
+	if written := lines(); len(written) != 2 || !strings.HasPrefix(written[1], "latency count=1,sum=1,min=1,max=1,p99=1.00") {
+		t.Error("the values of the flushed series were retained:", written)
+	}
+}
+
+func onlySketch(t *testing.T, c *Client) *stats.HDRSketch {
+	if len(c.series) != 1 {
+		t.Fatal("bad number of series:", len(c.series))
+	}
+	for _, s := range c.series {
+		return s.hdr
+	}
+	return nil
+}
+
+func TestClientTimestamps(t *testing.T) {
+	tests := []struct {
+		source stats.TimestampSource
+		fields int
+	}{
+		{source: stats.MetricTimestamp, fields: 3},
+		{source: stats.FlushTimestamp, fields: 3},
+		{source: stats.NoTimestamp, fields: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source.String(), func(t *testing.T) {
+			server, lines := startTestServer(t)
+			defer server.Close()
+
+			client := NewClientWith(ClientConfig{
+				Address:     server.URL,
+				Database:    "test",
+				Timestamps:  test.source,
+				Percentiles: []float64{0.5},
+			})
+
+			engine := stats.NewEngine("influxdb.test")
+			engine.Register(client)
+			engine.Incr("A")
+			client.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "C", Value: 1, Time: time.Unix(1, 0)})
+			engine.Observe("B", 1)
+			engine.Flush()
+
+			l := lines()
+			if len(l) != 3 {
+				t.Fatal("bad number of lines written:", l)
+			}
+
+			for _, line := range l {
+				if n := len(strings.Fields(line)); n != test.fields {
+					t.Error("bad line:", line)
+				}
+			}
+
+			stamp := strings.Fields(l[1])
+			if test.source == stats.MetricTimestamp && stamp[2] != "1000000000" {
+				t.Error("the time of the metric was not used:", l[1])
+			}
+			if test.source == stats.FlushTimestamp && stamp[2] == "1000000000" {
+				t.Error("the time of the metric was used instead of the flush time:", l[1])
+			}
+		})
+	}
+}
+
+func TestClientWriteURL(t *testing.T) {
+	tests := []struct {
+		config ClientConfig
+		url    string
+	}{
+		{
+			config: ClientConfig{Address: "http://localhost:8086", Database: "test"},
+			url:    "http://localhost:8086/write?db=test&precision=ns",
+		},
+		{
+			config: ClientConfig{Address: "http://localhost:8086", Database: "test", Bucket: "metrics", Organization: "my org"},
+			url:    "http://localhost:8086/api/v2/write?bucket=metrics&org=my+org&precision=ns",
+		},
+	}
+
+	for _, test := range tests {
+		if url := writeURL(test.config); url != test.url {
+			t.Errorf("bad write url:\n- expected: %s\n- found:    %s", test.url, url)
+		}
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	var auth, path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		auth, path = req.Header.Get("Authorization"), req.URL.Path
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:      server.URL,
+		Bucket:       "metrics",
+		Organization: "test",
+		Token:        "secret",
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+	engine.Incr("A")
+	engine.Flush()
+
+	if auth != "Token secret" || path != "/api/v2/write" {
+		t.Error("bad request:", auth, path)
+	}
+
+	if n := client.Errors(); n != 0 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done() // hung backend
+	}))
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		MaxRetries: 3,
+		RetryDelay: time.Hour,
+	})
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "A", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if n := c.Errors(); n != 1 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		requests int
+		errors   int64
+	}{
+		{
+			statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusNoContent},
+			requests: 3,
+		},
+		{
+			statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			requests: 3,
+			errors:   1,
+		},
+		{
+			statuses: []int{http.StatusBadRequest, http.StatusNoContent},
+			requests: 1,
+			errors:   1,
+		},
+	}
+
+	for _, test := range tests {
+		var requests int
+
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.WriteHeader(test.statuses[requests])
+			requests++
+		}))
+
+		client := NewClientWith(ClientConfig{
+			Address:    server.URL,
+			Database:   "test",
+			MaxRetries: 2,
+			RetryDelay: time.Millisecond,
+		})
+
+		engine := stats.NewEngine("influxdb.test")
+		engine.Register(client)
+		engine.Incr("A")
+		engine.Flush()
+		server.Close()
+
+		if requests != test.requests {
+			t.Errorf("%v: bad number of requests: %d", test.statuses, requests)
+		}
+
+		if n := client.Errors(); n != test.errors {
+			t.Errorf("%v: bad number of errors: %d", test.statuses, n)
+		}
+	}
+}
+
+func TestClientBatchSize(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:   server.URL,
+		Database:  "test",
+		BatchSize: 2,
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+	engine.Incr("A")
+	engine.Incr("B")
+	engine.Incr("C")
+
+	if n := len(lines()); n != 0 {
+		t.Error("lines were written before the flush:", n, lines())
+	}
+
+	engine.Flush()
+
+	if n := len(lines()); n != 3 {
+		t.Error("bad number of lines written after the flush:", n, lines())
+	}
+}
+
+func TestClientMaxPendingBatches(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:           server.URL,
+		Database:          "test",
+		BatchSize:         2,
+		MaxPendingBatches: 1,
+	})
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		engine.Incr(name)
+	}
+
+	if n := client.Dropped(); n != 2 {
+		t.Error("bad number of dropped lines:", n)
+	}
+
+	engine.Flush()
+
+	if n := len(lines()); n != 3 {
+		t.Error("bad number of lines written by the flush:", n, lines())
+	}
+}
+
+func TestClientFlushInterval(t *testing.T) {
+	server, lines := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:       server.URL,
+		Database:      "test",
+		FlushInterval: 10 * time.Millisecond,
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("influxdb.test")
+	engine.Register(client)
+	engine.Incr("A")
+
+	for i := 0; i != 100 && len(lines()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(lines()); n != 1 {
+		t.Error("bad number of lines written in the background:", n, lines())
+	}
+}
@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInfoSuffix is the default suffix appended to the names of the
+	// info series produced by info handlers.
+	DefaultInfoSuffix = ".info"
+
+	// DefaultInfoRate is the default rate at which info handlers sample the
+	// increments of counters into info series.
+	DefaultInfoRate = 0.01
+)
+
+// The InfoConfig type is used to configure info handlers.
+type InfoConfig struct {
+	// Counters is the list of names of the counters that info series are
+	// produced for.
+	Counters []string
+
+	// Tags is the list of names of the tags which are removed from the
+	// counters before they are passed to the wrapped handler, and only
+	// retained on their info series. These are typically context-rich tags
+	// like user agents or regions.
+	Tags []string
+
+	// Rate is the rate, between 0 and 1, at which increments of the counters
+	// are reported on their info series, defaults to DefaultInfoRate.
+	Rate float64
+
+	// Suffix is appended to the names of counters to form the names of their
+	// info series, defaults to DefaultInfoSuffix.
+	Suffix string
+}
+
+type infoHandler struct {
+	handler  Handler
+	rate     float64
+	suffix   string
+	counters map[string]struct{}
+	tags     map[string]struct{}
+	mutex    sync.Mutex
+	rng      *rand.Rand
+}
+
+// NewInfoHandler returns a handler which passes the metrics it receives to
+// handler, and produces companion info series for the counters listed in the
+// configuration.
+//
+// The tags listed in the configuration are removed from the counters passed
+// to handler, which keeps their series aggregated with a low cardinality. A
+// sample of the increments, drawn at the configured rate, is also reported on
+// a counter named after the original one with the info suffix, carrying all
+// the tags. The info counters are reported with the sample rate (see
+// Metric.Rate) so the context of the sampled events can be broken down with
+// approximately correct counts, while the main series stay exact.
+//
+// This generalizes the idea of exemplars to counters, see ExampleHandler for
+// a handler retaining examples out-of-band instead of reporting them.
+func NewInfoHandler(handler Handler, config InfoConfig) Handler {
+	if config.Rate <= 0 || config.Rate > 1 {
+		config.Rate = DefaultInfoRate
+	}
+
+	if len(config.Suffix) == 0 {
+		config.Suffix = DefaultInfoSuffix
+	}
+
+	h := &infoHandler{
+		handler:  handler,
+		rate:     config.Rate,
+		suffix:   config.Suffix,
+		counters: make(map[string]struct{}, len(config.Counters)),
+		tags:     make(map[string]struct{}, len(config.Tags)),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, name := range config.Counters {
+		h.counters[name] = struct{}{}
+	}
+
+	for _, name := range config.Tags {
+		h.tags[name] = struct{}{}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *infoHandler) HandleMetric(m *Metric) {
+	h.handle(m, h.handler)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *infoHandler) HandleMetrics(metrics []*Metric) {
+	forwardMetrics(h.handler, metrics, h.handle)
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *infoHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// handle passes the metrics produced from m to next.
+func (h *infoHandler) handle(m *Metric, next Handler) {
+	if m.Type != CounterType {
+		next.HandleMetric(m)
+		return
+	}
+
+	if _, ok := h.counters[m.Name]; !ok {
+		next.HandleMetric(m)
+		return
+	}
+
+	c := metricPool.Get().(*Metric)
+	tags := c.Tags[:0]
+	*c = *m
+	c.Tags = tags
+
+	for _, t := range m.Tags {
+		if _, ok := h.tags[t.Name]; !ok {
+			c.Tags = append(c.Tags, t)
+		}
+	}
+
+	next.HandleMetric(c)
+
+	if h.sample() {
+		c.Name = m.Name + h.suffix
+		c.Tags = append(c.Tags[:0], m.Tags...)
+		c.Rate = combineRates(m.Rate, h.rate)
+		next.HandleMetric(c)
+	}
+
+	c.Namespace = ""
+	c.Name = ""
+	c.Tags = c.Tags[:0]
+	metricPool.Put(c)
+}
+
+// Flush satisfies the Flusher interface.
+func (h *infoHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *infoHandler) FlushContext(ctx context.Context) {
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *infoHandler) Reset() {
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+func (h *infoHandler) sample() bool {
+	if h.rate >= 1 {
+		return true
+	}
+	h.mutex.Lock()
+	x := h.rng.Float64()
+	h.mutex.Unlock()
+	return x < h.rate
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInfoHandler(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(NewInfoHandler(h, InfoConfig{
+		Counters: []string{"requests"},
+		Tags:     []string{"user_agent", "region"},
+		Rate:     1,
+	}))
+
+	e.Incr("requests", Tag{"status", "200"}, Tag{"user_agent", "curl/7.64"}, Tag{"region", "us-west-2"})
+	e.Observe("latency", 1, Tag{"user_agent", "curl/7.64"})
+	e.Incr("errors", Tag{"user_agent", "curl/7.64"})
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Value:     1,
+			Tags:      []Tag{{"status", "200"}},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests.info",
+			Value:     1,
+			Tags:      []Tag{{"status", "200"}, {"user_agent", "curl/7.64"}, {"region", "us-west-2"}},
+			Rate:      1,
+		},
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "latency",
+			Value:     1,
+			Tags:      []Tag{{"user_agent", "curl/7.64"}},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "errors",
+			Value:     1,
+			Tags:      []Tag{{"user_agent", "curl/7.64"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestInfoHandlerRate(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(NewInfoHandler(h, InfoConfig{
+		Counters: []string{"requests"},
+		Tags:     []string{"user_agent"},
+		Rate:     0.1,
+		Suffix:   "_info",
+	}))
+
+	for i := 0; i != 1000; i++ {
+		e.Incr("requests", Tag{"user_agent", "curl/7.64"})
+	}
+
+	counts := map[string]int{}
+
+	for _, m := range h.metrics {
+		counts[m.Name]++
+
+		if m.Name == "requests_info" && m.Rate != 0.1 {
+			t.Error("bad sample rate of the info series:", m.Rate)
+		}
+	}
+
+	if counts["requests"] != 1000 {
+		t.Error("the main series was sampled:", counts["requests"])
+	}
+
+	if n := counts["requests_info"]; n < 50 || n > 150 {
+		t.Error("bad number of info metrics:", n)
+	}
+}
+
+func TestInfoHandlerFlush(t *testing.T) {
+	h := &handler{}
+	NewInfoHandler(h, InfoConfig{}).(Flusher).Flush()
+
+	if h.flushed != 1 {
+		t.Error("the info handler did not flush the underlying handler")
+	}
+}
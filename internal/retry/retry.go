@@ -0,0 +1,62 @@
+// Package retry implements the retries with exponential backoff shared by the
+// clients which send metrics to remote backends.
+package retry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Do calls send until it succeeds or fails with an error which can't be
+// retried, send returns true when its error may be retried. At most maxRetries
+// retries are made, the first one after delay and the following ones after
+// twice the previous delay. Retries stop when ctx is canceled, the error of
+// the last call is returned.
+func Do(ctx context.Context, maxRetries int, delay time.Duration, send func() (bool, error)) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := send()
+
+		if err == nil || !retry || attempt >= maxRetries {
+			return err
+		}
+
+		if !Sleep(ctx, delay) {
+			return err
+		}
+
+		delay *= 2
+	}
+}
+
+// Sleep waits for delay, it returns false if ctx was canceled first.
+func Sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// CheckResponse returns an error carrying the status and the beginning of the
+// body of res if its status is not 2xx, and whether the request may be retried,
+// which is the case of throttled requests (429) and server errors (5xx). The
+// body of successful responses is discarded so the connection can be reused.
+func CheckResponse(res *http.Response) (bool, error) {
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return retry, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	return false, nil
+}
@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name  string
+		retry bool
+		fails int
+		calls int
+		err   error
+	}{
+		{name: "success", calls: 1},
+		{name: "retried", retry: true, fails: 2, calls: 3},
+		{name: "not retried", retry: false, fails: 2, calls: 1, err: errFailed},
+		{name: "too many retries", retry: true, fails: 5, calls: 4, err: errFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+
+			err := Do(context.Background(), 3, time.Millisecond, func() (bool, error) {
+				if calls++; calls <= test.fails {
+					return test.retry, errFailed
+				}
+				return false, nil
+			})
+
+			if err != test.err {
+				t.Error("bad error:", err)
+			}
+
+			if calls != test.calls {
+				t.Error("bad number of calls:", calls)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+
+	err := Do(ctx, 3, time.Hour, func() (bool, error) {
+		calls++
+		return true, errors.New("failed")
+	})
+
+	if err == nil || calls != 1 {
+		t.Error("retries were not stopped by the cancellation of the context:", err, calls)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		retry  bool
+		err    string
+	}{
+		{status: 204},
+		{status: 400, err: "400 Bad Request: oops"},
+		{status: 429, retry: true, err: "429 Too Many Requests: oops"},
+		{status: 503, retry: true, err: "503 Service Unavailable: oops"},
+	}
+
+	for _, test := range tests {
+		res := &http.Response{
+			Status:     fmt.Sprintf("%d %s", test.status, http.StatusText(test.status)),
+			StatusCode: test.status,
+			Body:       ioutil.NopCloser(strings.NewReader(" oops\n")),
+		}
+
+		retry, err := CheckResponse(res)
+
+		if retry != test.retry {
+			t.Errorf("bad retry for status %d: %t", test.status, retry)
+		}
+
+		if (err == nil && len(test.err) != 0) || (err != nil && err.Error() != test.err) {
+			t.Errorf("bad error for status %d: %v", test.status, err)
+		}
+	}
+}
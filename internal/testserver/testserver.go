@@ -0,0 +1,113 @@
+// Package testserver implements the HTTP server shared by the tests of the
+// clients which send metrics to remote backends.
+package testserver
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Config carries the configuration of test servers.
+type Config struct {
+	// Check is called with every request received by the server, the error
+	// it returns fails the test.
+	Check func(*http.Request) error
+
+	// Status is the status of the responses, defaults to http.StatusOK.
+	Status int
+
+	// Statuses are the statuses of the responses to the first requests, the
+	// following ones are answered with Status.
+	Statuses []int
+}
+
+// Server is a HTTP server recording the bodies of the requests it receives.
+// Bodies compressed with gzip or deflate, according to their Content-Encoding
+// header, are recorded after being decompressed.
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	bodies   []string
+	statuses []int
+	status   int
+}
+
+// New starts and returns a new test server configured with config, errors are
+// reported to t. The server must be closed by the caller.
+func New(t testing.TB, config Config) *Server {
+	if config.Status == 0 {
+		config.Status = http.StatusOK
+	}
+
+	s := &Server{
+		statuses: append([]int(nil), config.Statuses...),
+		status:   config.Status,
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if config.Check != nil {
+			if err := config.Check(req); err != nil {
+				t.Error(err)
+			}
+		}
+
+		b, err := readBody(req)
+		if err != nil {
+			t.Error(err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		res.WriteHeader(s.record(string(b)))
+	}))
+
+	return s
+}
+
+// Bodies returns the bodies of the requests received by the server.
+func (s *Server) Bodies() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// record appends body to the list of bodies received by the server and returns
+// the status of the response.
+func (s *Server) record(body string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.status
+	if len(s.statuses) != 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+
+	s.bodies = append(s.bodies, body)
+	return status
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	var r io.Reader = req.Body
+
+	switch req.Header.Get("Content-Encoding") {
+	case "gzip":
+		z, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		r = z
+	case "deflate":
+		f := flate.NewReader(req.Body)
+		defer f.Close()
+		r = f
+	}
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,50 @@
+package stats
+
+// LazyTag represents a tag with a value that is only computed when a metric
+// is reported, which is useful when the value is expensive to compute and
+// metrics may be discarded, for example because of their verbosity level.
+type LazyTag struct {
+	Name  string
+	Value func() string
+}
+
+// WithLazyTags creates a new engine which inherits the properties and handlers
+// of eng, adding the given lazy tags to the returned engine.
+//
+// The values of lazy tags are computed each time a metric is reported by the
+// engine, after verifying that the metric is not discarded. The tags of the
+// metrics are ordered with the engine tags first, followed by the lazy tags
+// in the order they were added, then by the tags passed when producing the
+// metric.
+func (eng *Engine) WithLazyTags(tags ...LazyTag) *Engine {
+	e := eng.derive(eng.name, eng.tags)
+	e.lazy = make([]LazyTag, 0, len(eng.lazy)+len(tags))
+	e.lazy = append(e.lazy, eng.lazy...)
+	e.lazy = append(e.lazy, tags...)
+	return e
+}
+
+// withoutTags returns an engine deriving from eng which doesn't set the engine
+// tags, lazy tags included, on the metrics it produces.
+func (eng *Engine) withoutTags() *Engine {
+	e := eng.derive(eng.name, nil)
+	e.lazy = nil
+	e.shard = ""
+	return e
+}
+
+// appendTags appends to dst the engine tags, the values of the lazy tags, and
+// tags, in this order. The names of the appended tags are normalized to the
+// tag case of the engine.
+func (eng *Engine) appendTags(dst []Tag, tags []Tag) []Tag {
+	n := len(dst)
+	dst = append(dst, eng.tags...)
+
+	for _, t := range eng.lazy {
+		dst = append(dst, Tag{t.Name, t.Value()})
+	}
+
+	dst = append(dst, tags...)
+	eng.tagCase.normalizeTags(dst[n:])
+	return dst
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEngineLazyTags(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E", Tag{"base", "tag"})
+	e.Register(h)
+
+	calls := 0
+	lazy := e.WithLazyTags(LazyTag{"expensive", func() string {
+		calls++
+		return "value"
+	}})
+
+	lazy.WithLevel(1).Incr("discarded")
+
+	if calls != 0 {
+		t.Error("the lazy tag was evaluated for a discarded metric")
+	}
+
+	lazy.Incr("A", Tag{"call", "tag"})
+	lazy.WithTags(Tag{"more", "tag"}).Set("B", 1)
+
+	b := lazy.Batch()
+	b.Observe("C", 1)
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "A", Value: 1, Tags: []Tag{{"base", "tag"}, {"expensive", "value"}, {"call", "tag"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 1, Tags: []Tag{{"base", "tag"}, {"more", "tag"}, {"expensive", "value"}}},
+		{Type: HistogramType, Namespace: "E", Name: "C", Value: 1, Tags: []Tag{{"base", "tag"}, {"expensive", "value"}}},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if calls != 3 {
+		t.Error("bad number of evaluations of the lazy tag:", calls)
+	}
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// observationLimiter caps the rate of observations reported on histograms,
+// using one token bucket per histogram name.
+type observationLimiter struct {
+	buckets map[string]*tokenBucket // read-only after construction
+}
+
+type tokenBucket struct {
+	mutex   sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64 // maximum number of tokens
+	tokens  float64
+	last    time.Time
+	dropped int // observations dropped since the last one that was reported
+}
+
+func newObservationLimiter(limits map[string]float64) *observationLimiter {
+	l := &observationLimiter{
+		buckets: make(map[string]*tokenBucket, len(limits)),
+	}
+
+	for name, rate := range limits {
+		if rate > 0 {
+			burst := rate
+			if burst < 1 {
+				burst = 1
+			}
+			l.buckets[name] = &tokenBucket{rate: rate, burst: burst, tokens: burst}
+		}
+	}
+
+	return l
+}
+
+// allow returns whether an observation of the histogram with name should be
+// reported, and the sample rate to report it with. The rate accounts for the
+// observations that were dropped since the last one was reported, and is zero
+// if none were dropped.
+func (l *observationLimiter) allow(name string) (rate float64, ok bool) {
+	b := l.buckets[name]
+	if b == nil {
+		return 0, true
+	}
+	return b.take(time.Now())
+}
+
+func (b *tokenBucket) take(now time.Time) (rate float64, ok bool) {
+	b.mutex.Lock()
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		b.dropped++
+		b.mutex.Unlock()
+		return 0, false
+	}
+
+	b.tokens--
+
+	if b.dropped != 0 {
+		rate = 1 / float64(1+b.dropped)
+		b.dropped = 0
+	}
+
+	b.mutex.Unlock()
+	return rate, true
+}
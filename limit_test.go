@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newObservationLimiter(map[string]float64{"A": 2}).buckets["A"]
+
+	steps := []struct {
+		delay time.Duration
+		rate  float64
+		ok    bool
+	}{
+		{delay: 0, rate: 0, ok: true},
+		{delay: 0, rate: 0, ok: true},
+		{delay: 0, rate: 0, ok: false}, // burst exhausted
+		{delay: 0, rate: 0, ok: false},
+		{delay: 500 * time.Millisecond, rate: 1.0 / 3, ok: true}, // one token refilled
+		{delay: 0, rate: 0, ok: false},
+		{delay: 10 * time.Second, rate: 0.5, ok: true}, // refilled up to the burst
+		{delay: 0, rate: 0, ok: true},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.delay)
+
+		if rate, ok := b.take(now); rate != step.rate || ok != step.ok {
+			t.Errorf("bad result at step %d: rate=%g ok=%t", i, rate, ok)
+		}
+	}
+}
+
+func TestEngineObservationLimits(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:              "E",
+		ObservationLimits: map[string]float64{"latency": 2},
+	})
+	e.Register(h)
+
+	for i := 0; i != 5; i++ {
+		e.Observe("latency", float64(i))
+		e.Observe("size", float64(i))
+	}
+
+	e.IncrAndObserve("requests", "latency", 5)
+
+	b := e.Batch()
+	b.Observe("latency", 6)
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 0},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 0},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 1},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 1},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 2},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 3},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 4},
+		{Type: CounterType, Namespace: "E", Name: "requests", Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"math"
 	"sync"
 	"time"
 )
@@ -17,6 +18,13 @@ const (
 
 	// HistogramType is the constant representing histogram metrics.
 	HistogramType
+
+	// SummaryType is the constant representing summary metrics.
+	SummaryType
+
+	// ExponentialHistogramType is the constant representing exponential
+	// histogram metrics.
+	ExponentialHistogramType
 )
 
 // String satisfies the fmt.Stringer interface.
@@ -28,6 +36,10 @@ func (t MetricType) String() string {
 		return "gauge"
 	case HistogramType:
 		return "histogram"
+	case SummaryType:
+		return "summary"
+	case ExponentialHistogramType:
+		return "exponential_histogram"
 	default:
 		return "unknown"
 	}
@@ -57,6 +69,47 @@ type Metric struct {
 
 	// Time is unused for now, reserved for future extensions.
 	Time time.Time
+
+	// Unit is the unit in which the value is expressed, it is only set when
+	// the unit is known, for example on durations reported by histograms.
+	Unit string
+
+	// Rate is the sample rate of the metric, between 0 and 1, when it stands
+	// for 1/Rate occurrences because others were discarded. Zero means that
+	// the metric was not sampled, like a rate of 1.
+	//
+	// Handlers which aggregate metrics should scale counts and sums by 1/Rate
+	// to keep their statistics correct, see SampleCount.
+	Rate float64
+
+	// Expires is the time until which the value of the metric is valid, it is
+	// set on metrics which represent a prediction or a reservation bounded in
+	// time, see Engine.SetUntil. The zero value means that the metric doesn't
+	// expire.
+	//
+	// Handlers which retain the state of series, like the prometheus handler,
+	// stop exposing a series once the time of its last update expired. This is
+	// independent of how recently the series was updated.
+	Expires time.Time
+
+	// Exemplar is the list of tags identifying an example of the event that
+	// the metric reports, typically the id of the trace it is part of, see
+	// Engine.ObserveWithExemplar. The value and time of the metric are the
+	// value and time of the exemplar.
+	//
+	// Exemplars are not part of the identity of series, handlers which don't
+	// support them ignore it.
+	Exemplar []Tag
+}
+
+// SampleCount returns the number of occurrences that m stands for, which is
+// 1/Rate rounded to the nearest integer when the metric was sampled, and 1
+// otherwise.
+func (m *Metric) SampleCount() uint64 {
+	if m.Rate <= 0 || m.Rate >= 1 {
+		return 1
+	}
+	return uint64(math.Floor(1/m.Rate + 0.5))
 }
 
 // metricPool is used as an internal store to cache metric objects.
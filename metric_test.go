@@ -21,3 +21,24 @@ func TestMetricTypeString(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricSampleCount(t *testing.T) {
+	tests := []struct {
+		rate  float64
+		count uint64
+	}{
+		{0, 1},
+		{1, 1},
+		{0.5, 2},
+		{0.3, 3},
+		{0.01, 100},
+	}
+
+	for _, test := range tests {
+		m := Metric{Rate: test.rate}
+
+		if n := m.SampleCount(); n != test.count {
+			t.Errorf("bad sample count for rate %g: %d != %d", test.rate, n, test.count)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+// Package newrelicstats exposes a client which pushes metrics to the New Relic
+// Metric API.
+package newrelicstats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultAddress is the default URL of the New Relic Metric API endpoint
+	// that clients send metrics to, accounts in the EU region must use
+	// EUAddress.
+	DefaultAddress = "https://metric-api.newrelic.com/metric/v1"
+
+	// EUAddress is the URL of the New Relic Metric API endpoint of the EU
+	// region.
+	EUAddress = "https://metric-api.eu.newrelic.com/metric/v1"
+
+	// DefaultBatchSize is the default number of series sent in each request.
+	DefaultBatchSize = 1000
+
+	// DefaultMaxPendingBatches is the default number of full batches of series
+	// that clients retain until they are flushed.
+	DefaultMaxPendingBatches = 16
+
+	// DefaultTimeout is the default timeout of requests sent to New Relic.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is the default number of times that requests which
+	// were throttled or failed with a server error are retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the default delay before the first retry of a
+	// failed request, the delay doubles on each retry.
+	DefaultRetryDelay = 500 * time.Millisecond
+)
+
+// The ClientConfig type is used to configure New Relic clients.
+type ClientConfig struct {
+	// Address is the URL of the Metric API endpoint, defaults to
+	// DefaultAddress.
+	Address string
+
+	// InsertKey is the New Relic license or insert key used to authenticate
+	// requests, it must be set.
+	InsertKey string
+
+	// Attributes is the list of attributes set on all metrics sent by the
+	// client, for example {"service.name", "my-service"}.
+	Attributes []stats.Tag
+
+	// BatchSize is the number of series sent in each request, defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// MaxPendingBatches is the number of full batches of series retained by
+	// the client until it is flushed, defaults to DefaultMaxPendingBatches.
+	// Series aggregated when this number is reached are dropped, see Dropped.
+	MaxPendingBatches int
+
+	// FlushInterval enables flushing the client in the background at this
+	// interval, in addition to the flushes of the engine it is registered
+	// on. The background flushes are stopped by closing the client.
+	FlushInterval time.Duration
+
+	// Timeout is the maximum amount of time that each request sent to New
+	// Relic is allowed to take.
+	Timeout time.Duration
+
+	// Transport is the HTTP transport used by the client to send requests,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// MaxRetries is the number of times that requests which were throttled
+	// (429), failed with a server error (5xx) or a network error are retried,
+	// a negative value disables retries. Requests rejected with other client
+	// errors are not retried.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry of a failed request, the
+	// delay doubles on each retry.
+	RetryDelay time.Duration
+
+	// Compressor is the compression codec applied to the bodies of requests,
+	// defaults to stats.GzipCompressor.
+	Compressor stats.Compressor
+
+	// OnError is called with the errors returned by requests sent to New
+	// Relic, the errors are logged by default.
+	OnError func(error)
+}
+
+// Client represents a New Relic client that aggregates the metrics it receives
+// from a stats engine and pushes them to the Metric API.
+//
+// Metrics are aggregated over each flush interval: counters are sent as New
+// Relic count metrics carrying the sum of their increments, gauges as gauge
+// metrics carrying their last value, and histograms as summary metrics
+// carrying the count, sum, min and max of the observed values. Requests are
+// compressed with gzip unless another compressor is configured.
+//
+// Requests are only sent when the client is flushed, outside of the lock held
+// by HandleMetric, so a slow or unavailable New Relic endpoint never blocks the
+// code producing metrics.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	dropped int64
+	mutex   sync.Mutex
+	config  ClientConfig
+	httpc   http.Client
+	start   time.Time
+	series  map[string]*series
+	order   []*series
+	pending [][]byte // full batches, sent by the next flush
+	done    chan struct{}
+	once    sync.Once
+	zpool   *stats.CompressorPool
+}
+
+type series struct {
+	mtype stats.MetricType
+	name  string
+	attrs map[string]string
+	value float64
+	count uint64
+	min   float64
+	max   float64
+}
+
+// NewClient creates and returns a new New Relic client publishing metrics to
+// the Metric API with insertKey.
+func NewClient(insertKey string) *Client {
+	return NewClientWith(ClientConfig{
+		InsertKey: insertKey,
+	})
+}
+
+// NewClientWith creates and returns a new New Relic client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	if config.MaxPendingBatches <= 0 {
+		config.MaxPendingBatches = DefaultMaxPendingBatches
+	}
+
+	if config.Compressor == nil {
+		config.Compressor = stats.GzipCompressor
+	}
+
+	if config.OnError == nil {
+		addr := config.Address
+		config.OnError = func(err error) {
+			log.Printf("stats/newrelicstats: sending metrics to %s failed: %s", addr, err)
+		}
+	}
+
+	c := &Client{
+		config: config,
+		httpc: http.Client{
+			Transport: config.Transport,
+			Timeout:   config.Timeout,
+		},
+		start:  time.Now(),
+		series: make(map[string]*series),
+		done:   make(chan struct{}),
+		zpool:  stats.NewCompressorPool(config.Compressor),
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	}
+
+	return c
+}
+
+// Close satisfies the io.Closer interface, it stops the background flushes and
+// flushes the client.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.Flush()
+	return nil
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	name := m.Name
+	if len(m.Namespace) != 0 {
+		name = m.Namespace + "." + name
+	}
+
+	mtype := m.Type
+	if mtype == stats.SummaryType || mtype == stats.ExponentialHistogramType {
+		// New Relic summaries carry no quantiles, summaries and exponential
+		// histograms are reported like histograms.
+		mtype = stats.HistogramType
+	}
+
+	key := seriesKey(mtype, name, m.Tags)
+
+	c.mutex.Lock()
+	s := c.series[key]
+
+	if s == nil {
+		s = &series{
+			mtype: mtype,
+			name:  name,
+			attrs: makeAttributes(m.Tags),
+		}
+		c.series[key] = s
+		c.order = append(c.order, s)
+	}
+
+	s.observe(m.Value, m.SampleCount())
+
+	if len(c.series) >= c.config.BatchSize {
+		c.enqueue(time.Now())
+	}
+
+	c.mutex.Unlock()
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// New Relic are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	batches := c.pending
+	c.pending = nil
+
+	if b := c.swap(time.Now()); b != nil {
+		batches = append(batches, b)
+	}
+
+	c.mutex.Unlock()
+
+	for _, b := range batches {
+		if err := c.write(ctx, b); err != nil {
+			c.fail(err)
+		}
+	}
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// requests to New Relic which failed.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// series discarded because MaxPendingBatches was reached.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *Client) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue retains the full batch of series until the next flush, the series
+// are dropped when MaxPendingBatches batches are already retained.
+func (c *Client) enqueue(now time.Time) {
+	if len(c.pending) < c.config.MaxPendingBatches {
+		if b := c.swap(now); b != nil {
+			c.pending = append(c.pending, b)
+		}
+		return
+	}
+
+	atomic.AddInt64(&c.dropped, int64(len(c.order)))
+	c.reset(now)
+}
+
+// swap returns the payload of the series aggregated since the last swap and
+// starts a new aggregation interval, it returns nil when there are no series.
+func (c *Client) swap(now time.Time) []byte {
+	if len(c.order) == 0 {
+		c.start = now
+		return nil
+	}
+
+	b, err := json.Marshal(c.payload(now))
+	c.reset(now)
+
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+
+	return b
+}
+
+func (c *Client) reset(now time.Time) {
+	c.start = now
+	c.series = make(map[string]*series)
+	c.order = c.order[:0]
+}
+
+func (c *Client) fail(err error) {
+	atomic.AddInt64(&c.errors, 1)
+	c.config.OnError(err)
+}
+
+func (c *Client) payload(now time.Time) []payload {
+	metrics := make([]metric, 0, len(c.order))
+
+	for _, s := range c.order {
+		m := metric{
+			Name:       s.name,
+			Type:       "gauge",
+			Value:      s.value,
+			Attributes: s.attrs,
+		}
+
+		switch s.mtype {
+		case stats.CounterType:
+			m.Type = "count"
+
+		case stats.HistogramType:
+			m.Type = "summary"
+			m.Value = summary{
+				Count: s.count,
+				Sum:   s.value,
+				Min:   s.min,
+				Max:   s.max,
+			}
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return []payload{{
+		Common: common{
+			Timestamp:  c.start.UnixNano() / int64(time.Millisecond),
+			Interval:   int64(now.Sub(c.start) / time.Millisecond),
+			Attributes: makeAttributes(c.config.Attributes),
+		},
+		Metrics: metrics,
+	}}
+}
+
+// write sends b to New Relic, retrying requests which were throttled or
+// failed with a server error with an exponential backoff, until ctx is canceled.
+func (c *Client) write(ctx context.Context, b []byte) error {
+	z := &bytes.Buffer{}
+
+	if err := c.zpool.Compress(z, b); err != nil {
+		return err
+	}
+
+	return retry.Do(ctx, c.config.MaxRetries, c.config.RetryDelay, func() (bool, error) {
+		return c.send(ctx, z.Bytes())
+	})
+}
+
+// send sends a single request with the compressed body b, it returns true if
+// the request failed and may be retried.
+func (c *Client) send(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequest("POST", c.config.Address, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", c.zpool.Encoding())
+	req.Header.Set("Api-Key", c.config.InsertKey)
+
+	res, err := c.httpc.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	return retry.CheckResponse(res)
+}
+
+// observe records n occurrences of value, sampled metrics stand for more than
+// one occurrence.
+func (s *series) observe(value float64, n uint64) {
+	switch s.mtype {
+	case stats.CounterType:
+		s.value += value * float64(n)
+
+	case stats.GaugeType:
+		s.value = value
+
+	case stats.HistogramType:
+		if s.count == 0 || value < s.min {
+			s.min = value
+		}
+		if s.count == 0 || value > s.max {
+			s.max = value
+		}
+		s.value += value * float64(n)
+		s.count += n
+	}
+}
+
+func seriesKey(mtype stats.MetricType, name string, tags []stats.Tag) string {
+	tags = append([]stats.Tag(nil), tags...)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+
+	b := make([]byte, 0, 64)
+	b = append(b, byte(mtype))
+	b = append(b, name...)
+
+	for _, t := range tags {
+		b = append(b, 0)
+		b = append(b, t.Name...)
+		b = append(b, '=')
+		b = append(b, t.Value...)
+	}
+
+	return string(b)
+}
+
+func makeAttributes(tags []stats.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]string, len(tags))
+
+	for _, t := range tags {
+		attrs[t.Name] = t.Value
+	}
+
+	return attrs
+}
+
+// payload is the JSON representation of a batch of metrics in the format of
+// the Metric API.
+type payload struct {
+	Common  common   `json:"common"`
+	Metrics []metric `json:"metrics"`
+}
+
+type common struct {
+	Timestamp  int64             `json:"timestamp"`
+	Interval   int64             `json:"interval.ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type metric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      interface{}       `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type summary struct {
+	Count uint64  `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
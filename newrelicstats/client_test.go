@@ -0,0 +1,284 @@
+package newrelicstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/testserver"
+)
+
+func startTestServer(t *testing.T, statuses ...int) (*httptest.Server, func() [][]map[string]interface{}) {
+	server := testserver.New(t, testserver.Config{
+		Status:   http.StatusAccepted,
+		Statuses: statuses,
+		Check: func(req *http.Request) error {
+			if key := req.Header.Get("Api-Key"); key != "secret" {
+				return fmt.Errorf("bad insert key: %s", key)
+			}
+			if enc := req.Header.Get("Content-Encoding"); enc != "gzip" {
+				return fmt.Errorf("bad content encoding: %s", enc)
+			}
+			return nil
+		},
+	})
+
+	return server.Server, func() [][]map[string]interface{} {
+		var bodies [][]map[string]interface{}
+
+		for _, b := range server.Bodies() {
+			var body []map[string]interface{}
+
+			if err := json.Unmarshal([]byte(b), &body); err != nil {
+				t.Error(err)
+			}
+
+			bodies = append(bodies, body)
+		}
+
+		return bodies
+	}
+}
+
+func TestClient(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		InsertKey:  "secret",
+		Attributes: []stats.Tag{{"service.name", "test"}},
+		OnError:    func(err error) { t.Error(err) },
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(c)
+
+	e.Incr("calls", stats.Tag{"op", "read"})
+	e.Add("calls", 2, stats.Tag{"op", "read"})
+	e.Set("conns", 3)
+	e.Set("conns", 4)
+	e.Observe("size", 1)
+	e.Observe("size", 5)
+	e.Flush()
+
+	if len(bodies()) != 1 {
+		t.Fatal("bad number of requests:", len(bodies()))
+	}
+
+	body := bodies()[0][0]
+	common := body["common"].(map[string]interface{})
+
+	if _, ok := common["interval.ms"]; !ok {
+		t.Error("missing interval:", common)
+	}
+
+	if !reflect.DeepEqual(common["attributes"], map[string]interface{}{"service.name": "test"}) {
+		t.Error("bad common attributes:", common["attributes"])
+	}
+
+	if !reflect.DeepEqual(body["metrics"], []interface{}{
+		map[string]interface{}{
+			"name":       "test.calls",
+			"type":       "count",
+			"value":      3.0,
+			"attributes": map[string]interface{}{"op": "read"},
+		},
+		map[string]interface{}{
+			"name":  "test.conns",
+			"type":  "gauge",
+			"value": 4.0,
+		},
+		map[string]interface{}{
+			"name":  "test.size",
+			"type":  "summary",
+			"value": map[string]interface{}{"count": 2.0, "sum": 6.0, "min": 1.0, "max": 5.0},
+		},
+	}) {
+		t.Error("bad metrics:", body["metrics"])
+	}
+
+	e.Flush()
+
+	if len(bodies()) != 1 {
+		t.Error("empty flushes sent requests:", len(bodies()))
+	}
+}
+
+func TestClientSampleCount(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:   server.URL,
+		InsertKey: "secret",
+		OnError:   func(err error) { t.Error(err) },
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1, Rate: 0.5})
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 2, Rate: 0.25})
+	c.Flush()
+
+	if len(bodies()) != 1 {
+		t.Fatal("bad number of requests:", len(bodies()))
+	}
+
+	if metrics := bodies()[0][0]["metrics"]; !reflect.DeepEqual(metrics, []interface{}{
+		map[string]interface{}{
+			"name":  "calls",
+			"type":  "count",
+			"value": 2.0,
+		},
+		map[string]interface{}{
+			"name":  "size",
+			"type":  "summary",
+			"value": map[string]interface{}{"count": 4.0, "sum": 8.0, "min": 2.0, "max": 2.0},
+		},
+	}) {
+		t.Error("sampled metrics were not weighted by their sample count:", metrics)
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done() // hung backend
+	}))
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		InsertKey:  "secret",
+		MaxRetries: 3,
+		RetryDelay: time.Hour,
+		OnError:    func(error) {},
+	})
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if n := c.Errors(); n != 1 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientBatchSize(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:   server.URL,
+		InsertKey: "secret",
+		BatchSize: 2,
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "a", Value: 1})
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "a", Value: 2})
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "b", Value: 1})
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "c", Value: 1})
+
+	if len(bodies()) != 0 {
+		t.Error("requests were sent before the flush:", len(bodies()))
+	}
+
+	c.Flush()
+
+	if n := len(bodies()); n != 2 {
+		t.Error("bad number of requests sent by the flush:", n)
+	}
+}
+
+func TestClientMaxPendingBatches(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	c := NewClientWith(ClientConfig{
+		Address:           server.URL,
+		InsertKey:         "secret",
+		BatchSize:         2,
+		MaxPendingBatches: 1,
+	})
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: name, Value: 1})
+	}
+
+	if n := c.Dropped(); n != 2 {
+		t.Error("bad number of dropped series:", n)
+	}
+
+	c.Flush()
+
+	if n := len(bodies()); n != 2 {
+		t.Error("bad number of requests sent by the flush:", n)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		errors   int64
+	}{
+		{
+			name:     "server error",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError},
+			requests: 3,
+		},
+		{
+			name:     "client error",
+			statuses: []int{http.StatusForbidden},
+			requests: 1,
+			errors:   1,
+		},
+		{
+			name:     "retries exhausted",
+			statuses: []int{500, 500, 500},
+			requests: 3,
+			errors:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, bodies := startTestServer(t, test.statuses...)
+			defer server.Close()
+
+			c := NewClientWith(ClientConfig{
+				Address:    server.URL,
+				InsertKey:  "secret",
+				MaxRetries: 2,
+				RetryDelay: time.Millisecond,
+				OnError:    func(error) {},
+			})
+
+			c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+			c.Flush()
+
+			if n := len(bodies()); n != test.requests {
+				t.Error("bad number of requests:", n)
+			}
+
+			if n := c.Errors(); n != test.errors {
+				t.Error("bad number of errors:", n)
+			}
+		})
+	}
+}
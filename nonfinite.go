@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// NonFinitePolicy is an enumeration of the ways engines handle NaN and
+// infinite values, which are usually the result of bugs in instrumentation
+// code (like divisions by zero) and break some backends.
+type NonFinitePolicy int
+
+const (
+	// NonFiniteReject discards metrics with NaN or infinite values, the
+	// discarded metrics are counted in the Dropped field of EngineStats.
+	NonFiniteReject NonFinitePolicy = iota
+
+	// NonFiniteClamp reports NaN and infinite values as zero.
+	NonFiniteClamp
+
+	// NonFinitePass reports NaN and infinite values as-is.
+	NonFinitePass
+)
+
+// String satisfies the fmt.Stringer interface.
+func (p NonFinitePolicy) String() string {
+	switch p {
+	case NonFiniteReject:
+		return "reject"
+	case NonFiniteClamp:
+		return "clamp"
+	case NonFinitePass:
+		return "pass"
+	default:
+		return "unknown"
+	}
+}
+
+// nonFiniteGuard applies the non-finite policy of engines.
+type nonFiniteGuard struct {
+	rejected int64 // first for alignment of atomic operations
+	policy   NonFinitePolicy
+	mutex    sync.Mutex
+	logged   map[string]struct{}
+}
+
+func newNonFiniteGuard(policy NonFinitePolicy) *nonFiniteGuard {
+	return &nonFiniteGuard{
+		policy: policy,
+		logged: make(map[string]struct{}),
+	}
+}
+
+// check returns the value to report in place of value, which is not finite,
+// and false if the metric must be discarded. Each metric name is logged once.
+// A nil guard, which is the guard of zero-value engines, discards the metrics
+// without counting nor logging them.
+func (g *nonFiniteGuard) check(namespace string, name string, value float64) (float64, bool) {
+	if g == nil {
+		return 0, false
+	}
+
+	switch g.policy {
+	case NonFinitePass:
+		return value, true
+	case NonFiniteClamp:
+		g.report(namespace, name, value, "reported as zero")
+		return 0, true
+	default:
+		atomic.AddInt64(&g.rejected, 1)
+		g.report(namespace, name, value, "discarded")
+		return 0, false
+	}
+}
+
+// rejectedCount returns the number of metrics discarded by g.
+func (g *nonFiniteGuard) rejectedCount() int64 {
+	if g == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&g.rejected)
+}
+
+func (g *nonFiniteGuard) report(namespace string, name string, value float64, action string) {
+	key := namespace + "." + name
+
+	g.mutex.Lock()
+	_, logged := g.logged[key]
+	if !logged {
+		g.logged[key] = struct{}{}
+	}
+	g.mutex.Unlock()
+
+	if !logged {
+		log.Printf("stats: value %g of metric %s is not finite and was %s", value, key, action)
+	}
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEngineNonFinite(t *testing.T) {
+	tests := []struct {
+		policy  NonFinitePolicy
+		values  []float64
+		dropped int64
+	}{
+		{policy: NonFiniteReject, values: []float64{1}, dropped: 3},
+		{policy: NonFiniteClamp, values: []float64{0, 0, 1, 0}, dropped: 0},
+		{policy: NonFinitePass, values: []float64{math.NaN(), math.Inf(1), 1, math.Inf(-1)}, dropped: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			h := &handler{}
+			e := NewEngineWith(EngineConfig{
+				Name:      "E",
+				NonFinite: test.policy,
+			})
+			e.Register(h)
+
+			e.Set("ratio", math.NaN())
+			e.Observe("latency", math.Inf(1))
+			e.Add("total", 1)
+
+			b := e.Batch()
+			b.Set("ratio", math.Inf(-1))
+			b.Commit()
+
+			values := make([]float64, len(h.metrics))
+			for i, m := range h.metrics {
+				values[i] = m.Value
+			}
+
+			if !equalFloats(values, test.values) {
+				t.Error("bad values:", values)
+			}
+
+			if stats := e.Stats(); !reflect.DeepEqual(stats, EngineStats{Dropped: test.dropped}) {
+				t.Error("bad engine stats:", stats)
+			}
+		})
+	}
+}
+
+func equalFloats(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+			return false
+		}
+	}
+	return true
+}
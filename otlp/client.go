@@ -0,0 +1,664 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultAddress is the default address of the OTLP/HTTP collector that
+	// clients send metrics to.
+	DefaultAddress = "http://localhost:4318"
+
+	// DefaultGRPCAddress is the default address of the OTLP/gRPC collector
+	// that clients send metrics to with GRPCProtocol.
+	DefaultGRPCAddress = "http://localhost:4317"
+
+	// DefaultTimeout is the default timeout of requests sent to the collector.
+	DefaultTimeout = 5 * time.Second
+
+	// scopeName is the name of the instrumentation scope of the exported
+	// metrics.
+	scopeName = "github.com/segmentio/stats"
+)
+
+// DefaultBuckets is the list of upper limits of the histogram buckets used
+// when none are configured for a metric.
+var DefaultBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Protocol is an enumeration of the OTLP transports that clients export metrics
+// with.
+type Protocol int
+
+const (
+	// HTTPProtocol exports metrics with the OTLP/HTTP transport and the JSON
+	// encoding, collectors expose it on port 4318.
+	HTTPProtocol Protocol = iota
+
+	// GRPCProtocol exports metrics with the OTLP/gRPC transport, collectors
+	// expose it on port 4317.
+	//
+	// gRPC requires HTTP/2, which the default transport only negotiates with
+	// https addresses. Collectors accepting plaintext connections require a
+	// Transport supporting HTTP/2 without TLS (h2c), like the transport of
+	// the golang.org/x/net/http2 package with AllowHTTP set.
+	GRPCProtocol
+)
+
+// String satisfies the fmt.Stringer interface.
+func (p Protocol) String() string {
+	switch p {
+	case HTTPProtocol:
+		return "http"
+	case GRPCProtocol:
+		return "grpc"
+	default:
+		return "unknown"
+	}
+}
+
+// Temporality is an enumeration of the aggregation temporalities that clients
+// export counters and histograms with.
+type Temporality int
+
+const (
+	// CumulativeTemporality exports the totals of series since they were
+	// first seen by the client, series are exported on every export.
+	CumulativeTemporality Temporality = iota
+
+	// DeltaTemporality exports the changes of series since the previous
+	// export, series are reset after each export and only exported when they
+	// changed.
+	DeltaTemporality
+)
+
+// String satisfies the fmt.Stringer interface.
+func (t Temporality) String() string {
+	switch t {
+	case CumulativeTemporality:
+		return "cumulative"
+	case DeltaTemporality:
+		return "delta"
+	default:
+		return "unknown"
+	}
+}
+
+// The ClientConfig type is used to configure OTLP clients.
+type ClientConfig struct {
+	// Address is the base URL of the collector, metrics are posted to the
+	// /v1/metrics path with OTLP/HTTP. Defaults to DefaultAddress, or
+	// DefaultGRPCAddress with GRPCProtocol.
+	Address string
+
+	// Protocol is the OTLP transport used to export metrics, defaults to
+	// HTTPProtocol.
+	Protocol Protocol
+
+	// Timeout is the maximum amount of time that requests sent to the
+	// collector are allowed to take.
+	Timeout time.Duration
+
+	// Transport is the HTTP transport used by the client to send requests,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Resource is the list of attributes of the resource producing the
+	// metrics, for example {"service.name", "my-service"}.
+	Resource []stats.Tag
+
+	// ResourceTags is the list of names of the tags which are exported as
+	// attributes of the resource instead of attributes of the data points,
+	// typically the names of the tags set in the EngineConfig of the engine
+	// the client is registered on, like the host or the region. Metrics with
+	// different values of these tags are exported as different resources.
+	ResourceTags []string
+
+	// Buckets maps metric names (namespace included) to the upper limits of
+	// the buckets of their histograms. The limits must be sorted in
+	// increasing order, DefaultBuckets is used for histograms which are not
+	// in the map.
+	//
+	// The buckets configured on the engines the client is registered on,
+	// with EngineConfig.Buckets or Engine.SetBuckets, take precedence.
+	Buckets map[string][]float64
+
+	// MinMax enables tracking the minimum and maximum values observed by
+	// histograms, which are reported in the min and max fields of the data
+	// points introduced in OTLP 0.11. The fields are omitted when disabled.
+	MinMax bool
+
+	// Temporality is the aggregation temporality of counters and histograms,
+	// defaults to CumulativeTemporality. Gauges always report their last value.
+	Temporality Temporality
+
+	// Temporalities maps metric names (namespace included) to the aggregation
+	// temporality of their counters or histograms, overriding Temporality.
+	// Backends like AWS CloudWatch expect deltas while others only accept
+	// cumulative values.
+	Temporalities map[string]Temporality
+
+	// Compressor is the compression codec applied to the bodies of requests
+	// sent to the server, requests are not compressed when it is nil.
+	// stats.GzipCompressor is supported by all servers.
+	Compressor stats.Compressor
+}
+
+// Client represents an OTLP client that aggregates the metrics it receives
+// from a stats engine and exports them to a collector over HTTP when it is
+// flushed.
+//
+// Counters and histograms are exported with the cumulative aggregation
+// temporality by default, each export carries the totals since their series
+// were first seen, which requires the client to retain their state between
+// exports. Metrics configured with the delta temporality carry the changes that
+// occurred since the previous export instead.
+type Client struct {
+	mutex  sync.Mutex
+	config ClientConfig
+	url    string
+	httpc  http.Client
+	start  time.Time
+	series map[string]*series
+	bounds map[string][]float64
+	zpool  *stats.CompressorPool
+	rtags  map[string]struct{}
+}
+
+type series struct {
+	resource   []keyValue // attributes of the resource, see ResourceTags
+	rkey       string
+	mtype      stats.MetricType
+	name       string
+	unit       string
+	attrs      []keyValue
+	value      float64
+	count      uint64
+	min        float64
+	max        float64
+	counts     []uint64
+	bounds     []float64
+	cumulative bool
+	start      time.Time // time the series was first seen, for cumulative series
+}
+
+// NewClient creates and returns a new OTLP client exporting metrics to the
+// collector at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new OTLP client configured with config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		if config.Protocol == GRPCProtocol {
+			config.Address = DefaultGRPCAddress
+		} else {
+			config.Address = DefaultAddress
+		}
+	}
+
+	path := "/v1/metrics"
+
+	if config.Protocol == GRPCProtocol {
+		path = grpcPath
+
+		if config.Transport == nil && strings.HasPrefix(config.Address, "http://") {
+			log.Printf("stats/otlp: the default transport does not support gRPC over plaintext connections to %s, a transport supporting h2c must be configured", config.Address)
+		}
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	c := &Client{
+		config: config,
+		url:    config.Address + path,
+		httpc: http.Client{
+			Transport: config.Transport,
+			Timeout:   config.Timeout,
+		},
+		start:  time.Now(),
+		series: make(map[string]*series),
+		bounds: make(map[string][]float64, len(config.Buckets)),
+	}
+
+	for name, limits := range config.Buckets {
+		c.bounds[name] = limits
+	}
+
+	if config.Compressor != nil {
+		c.zpool = stats.NewCompressorPool(config.Compressor)
+	}
+
+	if len(config.ResourceTags) != 0 {
+		c.rtags = make(map[string]struct{}, len(config.ResourceTags))
+
+		for _, name := range config.ResourceTags {
+			c.rtags[name] = struct{}{}
+		}
+	}
+
+	return c
+}
+
+// Close satisfies the io.Closer interface.
+func (c *Client) Close() error {
+	c.Flush()
+	return nil
+}
+
+// DescribeMetric satisfies the stats.Describer interface, the buckets of
+// histograms configured on engines replace the buckets configured on the
+// client for the same metric. The series of the histogram which were already
+// seen restart with the new buckets, their counts are reset.
+func (c *Client) DescribeMetric(schema stats.MetricSchema) {
+	if schema.Type != stats.HistogramType || len(schema.Buckets) == 0 {
+		return
+	}
+
+	name := schema.FullName()
+	c.mutex.Lock()
+	c.bounds[name] = schema.Buckets
+
+	for _, s := range c.series {
+		if s.name == name && s.mtype == stats.HistogramType && !equalBounds(s.bounds, schema.Buckets) {
+			s.reset(schema.Buckets)
+		}
+	}
+
+	c.mutex.Unlock()
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	name := m.Name
+	if len(m.Namespace) != 0 {
+		name = m.Namespace + "." + name
+	}
+
+	tags, rtags := c.splitTags(m.Tags)
+	attrs := makeAttributes(tags)
+	resource := makeAttributes(rtags)
+	rkey := seriesKey("", resource)
+	key := rkey + "\x01" + seriesKey(name, attrs)
+
+	mtype := m.Type
+	if mtype == stats.SummaryType || mtype == stats.ExponentialHistogramType {
+		// Summaries are exported as histograms, OTLP summaries are only
+		// meant for legacy systems. Exponential histograms are exported
+		// with the explicit buckets of histograms as well.
+		mtype = stats.HistogramType
+	}
+
+	c.mutex.Lock()
+	s := c.series[key]
+
+	if s == nil {
+		s = &series{
+			resource: resource,
+			rkey:     rkey,
+			mtype:    mtype,
+			name:     name,
+			unit:     m.Unit,
+			attrs:    attrs,
+		}
+
+		if s.mtype != stats.GaugeType && c.temporality(name) == CumulativeTemporality {
+			s.cumulative = true
+			s.start = time.Now()
+		}
+
+		if s.mtype == stats.HistogramType {
+			s.bounds = c.buckets(name)
+			s.counts = make([]uint64, len(s.bounds)+1)
+		}
+
+		c.series[key] = s
+	}
+
+	s.observe(m.Value, m.SampleCount())
+	c.mutex.Unlock()
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the request to the
+// collector is canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	now := time.Now()
+	req := c.request(c.start, now)
+	c.start = now
+
+	for key, s := range c.series {
+		if !s.cumulative {
+			delete(c.series, key)
+		}
+	}
+
+	c.mutex.Unlock()
+
+	if len(req.ResourceMetrics) == 0 {
+		return
+	}
+
+	if err := c.write(ctx, req); err != nil {
+		log.Printf("stats/otlp: sending metrics to %s failed: %s", c.config.Address, err)
+	}
+}
+
+func (c *Client) request(start time.Time, end time.Time) exportMetricsServiceRequest {
+	list := make([]*series, 0, len(c.series))
+
+	for _, s := range c.series {
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i int, j int) bool {
+		if list[i].rkey != list[j].rkey {
+			return list[i].rkey < list[j].rkey
+		}
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		return seriesKey("", list[i].attrs) < seriesKey("", list[j].attrs)
+	})
+
+	deltaStart, endTime := uint64(start.UnixNano()), uint64(end.UnixNano())
+	req := exportMetricsServiceRequest{}
+
+	for i, s := range list {
+		startTime := deltaStart
+		if s.cumulative {
+			startTime = uint64(s.start.UnixNano())
+		}
+
+		if i == 0 || list[i-1].rkey != s.rkey {
+			req.ResourceMetrics = append(req.ResourceMetrics, resourceMetrics{
+				Resource: resource{Attributes: mergeAttributes(makeAttributes(c.config.Resource), s.resource)},
+				ScopeMetrics: []scopeMetrics{{
+					Scope:   instrumentationScope{Name: scopeName},
+					Metrics: []metric{},
+				}},
+			})
+		}
+
+		scope := &req.ResourceMetrics[len(req.ResourceMetrics)-1].ScopeMetrics[0]
+		metrics := scope.Metrics
+
+		if n := len(metrics); n == 0 || metrics[n-1].Name != s.name {
+			scope.Metrics = append(metrics, s.metric())
+		}
+
+		m := &scope.Metrics[len(scope.Metrics)-1]
+
+		switch {
+		case m.Sum != nil:
+			m.Sum.DataPoints = append(m.Sum.DataPoints, s.numberDataPoint(startTime, endTime))
+		case m.Gauge != nil:
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, s.numberDataPoint(startTime, endTime))
+		case m.Histogram != nil:
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, s.histogramDataPoint(startTime, endTime, c.config.MinMax))
+		}
+	}
+
+	return req
+}
+
+// splitTags separates the tags which are exported as resource attributes from
+// the other tags. Resources cannot have the same attribute twice, when a tag is
+// repeated the last value is retained, like for tags added with WithTags.
+func (c *Client) splitTags(tags []stats.Tag) (others []stats.Tag, resource []stats.Tag) {
+	if len(c.rtags) == 0 {
+		return tags, nil
+	}
+
+tags:
+	for _, t := range tags {
+		if _, ok := c.rtags[t.Name]; !ok {
+			others = append(others, t)
+			continue
+		}
+
+		for i := range resource {
+			if resource[i].Name == t.Name {
+				resource[i] = t
+				continue tags
+			}
+		}
+
+		resource = append(resource, t)
+	}
+
+	return
+}
+
+func (c *Client) write(ctx context.Context, req exportMetricsServiceRequest) error {
+	if c.config.Protocol == GRPCProtocol {
+		return c.writeGRPC(ctx, req)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if c.zpool != nil {
+		z := &bytes.Buffer{}
+
+		if err = c.zpool.Compress(z, b); err != nil {
+			return err
+		}
+
+		b = z.Bytes()
+	}
+
+	r, err := http.NewRequest("POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+
+	if c.zpool != nil {
+		r.Header.Set("Content-Encoding", c.zpool.Encoding())
+	}
+
+	res, err := c.httpc.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if _, err := retry.CheckResponse(res); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) temporality(name string) Temporality {
+	if t, ok := c.config.Temporalities[name]; ok {
+		return t
+	}
+	return c.config.Temporality
+}
+
+func (c *Client) buckets(name string) []float64 {
+	if b, ok := c.bounds[name]; ok {
+		return b
+	}
+	return DefaultBuckets
+}
+
+// reset discards the values observed by the histogram series s, which
+// restarts with the buckets bounded by limits.
+func (s *series) reset(limits []float64) {
+	s.value, s.count, s.min, s.max = 0, 0, 0, 0
+	s.bounds = limits
+	s.counts = make([]uint64, len(limits)+1)
+
+	if s.cumulative {
+		s.start = time.Now()
+	}
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// observe records n occurrences of value, sampled metrics stand for more than
+// one occurrence.
+func (s *series) observe(value float64, n uint64) {
+	switch s.mtype {
+	case stats.CounterType:
+		s.value += value * float64(n)
+
+	case stats.GaugeType:
+		s.value = value
+
+	case stats.HistogramType:
+		if s.count == 0 || value < s.min {
+			s.min = value
+		}
+		if s.count == 0 || value > s.max {
+			s.max = value
+		}
+		s.count += n
+		s.value += value * float64(n)
+		s.counts[sort.SearchFloat64s(s.bounds, value)] += n
+	}
+}
+
+func (s *series) metric() metric {
+	m := metric{Name: s.name, Unit: s.unit}
+	temporality := aggregationTemporalityDelta
+
+	if s.cumulative {
+		temporality = aggregationTemporalityCumulative
+	}
+
+	switch s.mtype {
+	case stats.CounterType:
+		m.Sum = &sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            true,
+		}
+	case stats.GaugeType:
+		m.Gauge = &gauge{}
+	case stats.HistogramType:
+		m.Histogram = &histogram{
+			AggregationTemporality: temporality,
+		}
+	}
+
+	return m
+}
+
+func (s *series) numberDataPoint(start uint64, end uint64) numberDataPoint {
+	return numberDataPoint{
+		Attributes:        s.attrs,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      end,
+		AsDouble:          s.value,
+	}
+}
+
+func (s *series) histogramDataPoint(start uint64, end uint64, minmax bool) histogramDataPoint {
+	p := histogramDataPoint{
+		Attributes:        s.attrs,
+		StartTimeUnixNano: start,
+		TimeUnixNano:      end,
+		Count:             s.count,
+		Sum:               s.value,
+		BucketCounts:      s.counts,
+		ExplicitBounds:    s.bounds,
+	}
+
+	if minmax {
+		min, max := s.min, s.max
+		p.Min, p.Max = &min, &max
+	}
+
+	return p
+}
+
+func makeAttributes(tags []stats.Tag) []keyValue {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	attrs := make([]keyValue, len(tags))
+
+	for i, t := range tags {
+		attrs[i] = keyValue{Key: t.Name, Value: anyValue{StringValue: t.Value}}
+	}
+
+	sort.Slice(attrs, func(i int, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// mergeAttributes returns the sorted list of attributes of base overridden by
+// the attributes of other, both lists must be sorted.
+func mergeAttributes(base []keyValue, other []keyValue) []keyValue {
+	if len(other) == 0 {
+		return base
+	}
+
+	attrs := make([]keyValue, 0, len(base)+len(other))
+
+	for len(base) != 0 && len(other) != 0 {
+		switch {
+		case base[0].Key < other[0].Key:
+			attrs, base = append(attrs, base[0]), base[1:]
+		case base[0].Key > other[0].Key:
+			attrs, other = append(attrs, other[0]), other[1:]
+		default:
+			attrs, base, other = append(attrs, other[0]), base[1:], other[1:]
+		}
+	}
+
+	attrs = append(attrs, base...)
+	return append(attrs, other...)
+}
+
+func seriesKey(name string, attrs []keyValue) string {
+	b := make([]byte, 0, 64)
+	b = append(b, name...)
+
+	for _, a := range attrs {
+		b = append(b, 0)
+		b = append(b, a.Key...)
+		b = append(b, '=')
+		b = append(b, a.Value.StringValue...)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,298 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/testserver"
+)
+
+func startTestServer(t *testing.T) (*httptest.Server, func() []string) {
+	server := testserver.New(t, testserver.Config{
+		Check: func(req *http.Request) error {
+			if req.URL.Path != "/v1/metrics" || req.Header.Get("Content-Type") != "application/json" {
+				return fmt.Errorf("bad request: %s %v", req.URL, req.Header)
+			}
+			return nil
+		},
+	})
+	return server.Server, server.Bodies
+}
+
+var timestamps = regexp.MustCompile(`"(start)?[tT]imeUnixNano":"\d+",`)
+
+func TestClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		minmax bool
+		body   string
+	}{
+		{
+			name:   "without min and max",
+			minmax: false,
+			body:   `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"test"}}]},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.latency","histogram":{"dataPoints":[{"count":"3","sum":6,"bucketCounts":["1","2","0"],"explicitBounds":[1,5]}],"aggregationTemporality":1}},{"name":"otlp.requests","sum":{"dataPoints":[{"attributes":[{"key":"method","value":{"stringValue":"GET"}},{"key":"status","value":{"stringValue":"ok"}}],"asDouble":2}],"aggregationTemporality":1,"isMonotonic":true}}]}]}]}`,
+		},
+		{
+			name:   "with min and max",
+			minmax: true,
+			body:   `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"test"}}]},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.latency","histogram":{"dataPoints":[{"count":"3","sum":6,"bucketCounts":["1","2","0"],"explicitBounds":[1,5],"min":1,"max":3}],"aggregationTemporality":1}},{"name":"otlp.requests","sum":{"dataPoints":[{"attributes":[{"key":"method","value":{"stringValue":"GET"}},{"key":"status","value":{"stringValue":"ok"}}],"asDouble":2}],"aggregationTemporality":1,"isMonotonic":true}}]}]}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := startTestServer(t)
+			defer server.Close()
+
+			client := NewClientWith(ClientConfig{
+				Address:     server.URL,
+				Resource:    []stats.Tag{{"service.name", "test"}},
+				Buckets:     map[string][]float64{"otlp.latency": {1, 5}},
+				MinMax:      test.minmax,
+				Temporality: DeltaTemporality,
+			})
+
+			e := stats.NewEngine("otlp")
+			e.Register(client)
+			e.Incr("requests", stats.Tag{"status", "ok"}, stats.Tag{"method", "GET"})
+			e.Incr("requests", stats.Tag{"method", "GET"}, stats.Tag{"status", "ok"})
+			e.Observe("latency", 1)
+			e.Observe("latency", 2)
+			e.Observe("latency", 3)
+			e.Flush()
+			e.Flush() // nothing changed, no request is sent
+
+			reqs := requests()
+
+			if len(reqs) != 1 {
+				t.Fatal("bad number of requests:", len(reqs))
+			}
+
+			if body := timestamps.ReplaceAllString(reqs[0], ""); body != test.body {
+				t.Error("bad request body:")
+				t.Log("expected:", test.body)
+				t.Log("found:   ", body)
+			}
+		})
+	}
+}
+
+func TestClientTemporality(t *testing.T) {
+	server, requests := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:       server.URL,
+		Buckets:       map[string][]float64{"otlp.latency": {1}},
+		Temporality:   CumulativeTemporality,
+		Temporalities: map[string]Temporality{"otlp.errors": DeltaTemporality},
+	})
+
+	e := stats.NewEngine("otlp")
+	e.Register(client)
+
+	e.Add("requests", 2)
+	e.Incr("errors")
+	e.Observe("latency", 0.5)
+	e.Set("conns", 3)
+	e.Flush()
+
+	e.Incr("requests")
+	e.Observe("latency", 2)
+	e.Flush()
+
+	reqs := requests()
+
+	if len(reqs) != 2 {
+		t.Fatal("bad number of requests:", len(reqs))
+	}
+
+	for i, body := range []string{
+		`{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.conns","gauge":{"dataPoints":[{"asDouble":3}]}},{"name":"otlp.errors","sum":{"dataPoints":[{"asDouble":1}],"aggregationTemporality":1,"isMonotonic":true}},{"name":"otlp.latency","histogram":{"dataPoints":[{"count":"1","sum":0.5,"bucketCounts":["1","0"],"explicitBounds":[1]}],"aggregationTemporality":2}},{"name":"otlp.requests","sum":{"dataPoints":[{"asDouble":2}],"aggregationTemporality":2,"isMonotonic":true}}]}]}]}`,
+		`{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.latency","histogram":{"dataPoints":[{"count":"2","sum":2.5,"bucketCounts":["1","1"],"explicitBounds":[1]}],"aggregationTemporality":2}},{"name":"otlp.requests","sum":{"dataPoints":[{"asDouble":3}],"aggregationTemporality":2,"isMonotonic":true}}]}]}]}`,
+	} {
+		if found := timestamps.ReplaceAllString(reqs[i], ""); found != body {
+			t.Errorf("bad body of request %d:", i)
+			t.Log("expected:", body)
+			t.Log("found:   ", found)
+		}
+	}
+}
+
+func TestClientDefaultTemporality(t *testing.T) {
+	server, requests := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{Address: server.URL})
+
+	e := stats.NewEngine("otlp")
+	e.Register(client)
+	e.Incr("requests")
+	e.Flush()
+	e.Flush() // cumulative series are exported on every export
+
+	reqs := requests()
+
+	if len(reqs) != 2 {
+		t.Fatal("bad number of requests:", len(reqs))
+	}
+
+	body := `{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.requests","sum":{"dataPoints":[{"asDouble":1}],"aggregationTemporality":2,"isMonotonic":true}}]}]}]}`
+
+	for i, req := range reqs {
+		if found := timestamps.ReplaceAllString(req, ""); found != body {
+			t.Errorf("bad body of request %d:", i)
+			t.Log("expected:", body)
+			t.Log("found:   ", found)
+		}
+	}
+}
+
+func TestTemporalityString(t *testing.T) {
+	for temporality, s := range map[Temporality]string{
+		DeltaTemporality:      "delta",
+		CumulativeTemporality: "cumulative",
+		Temporality(-1):       "unknown",
+	} {
+		if temporality.String() != s {
+			t.Errorf("bad string for temporality %d: %s", temporality, temporality.String())
+		}
+	}
+}
+
+func TestClientResourceTags(t *testing.T) {
+	server, requests := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:      server.URL,
+		Resource:     []stats.Tag{{"service.name", "test"}, {"region", "default"}},
+		ResourceTags: []string{"host", "region"},
+		Temporality:  DeltaTemporality,
+	})
+
+	e := stats.NewEngineWith(stats.EngineConfig{
+		Name: "otlp",
+		Tags: []stats.Tag{{"host", "a"}, {"region", "us-west-2"}},
+	})
+	e.Register(client)
+	e.Incr("requests", stats.Tag{"status", "ok"})
+	e.WithTags(stats.Tag{"host", "b"}).Incr("requests")
+	e.Flush()
+
+	reqs := requests()
+
+	if len(reqs) != 1 {
+		t.Fatal("bad number of requests:", len(reqs))
+	}
+
+	body := `{"resourceMetrics":[` +
+		`{"resource":{"attributes":[{"key":"host","value":{"stringValue":"a"}},{"key":"region","value":{"stringValue":"us-west-2"}},{"key":"service.name","value":{"stringValue":"test"}}]},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.requests","sum":{"dataPoints":[{"attributes":[{"key":"status","value":{"stringValue":"ok"}}],"asDouble":1}],"aggregationTemporality":1,"isMonotonic":true}}]}]},` +
+		`{"resource":{"attributes":[{"key":"host","value":{"stringValue":"b"}},{"key":"region","value":{"stringValue":"us-west-2"}},{"key":"service.name","value":{"stringValue":"test"}}]},"scopeMetrics":[{"scope":{"name":"github.com/segmentio/stats"},"metrics":[{"name":"otlp.requests","sum":{"dataPoints":[{"asDouble":1}],"aggregationTemporality":1,"isMonotonic":true}}]}]}` +
+		`]}`
+
+	if found := timestamps.ReplaceAllString(reqs[0], ""); found != body {
+		t.Error("bad request body:")
+		t.Log("expected:", body)
+		t.Log("found:   ", found)
+	}
+}
+
+func TestClientSampleCount(t *testing.T) {
+	server, requests := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:     server.URL,
+		Buckets:     map[string][]float64{"latency": {1, 5}},
+		Temporality: DeltaTemporality,
+	})
+
+	client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1, Rate: 0.5})
+	client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "latency", Value: 2, Rate: 0.25})
+	client.Flush()
+
+	reqs := requests()
+
+	if len(reqs) != 1 {
+		t.Fatal("bad number of requests:", len(reqs))
+	}
+
+	body := timestamps.ReplaceAllString(reqs[0], "")
+
+	for _, s := range []string{
+		`"dataPoints":[{"count":"4","sum":8,"bucketCounts":["0","4","0"],"explicitBounds":[1,5]}]`,
+		`"dataPoints":[{"asDouble":2}]`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("sampled metrics were not weighted by their sample count, %s not found in %s", s, body)
+		}
+	}
+}
+
+func TestClientEngineBuckets(t *testing.T) {
+	server, requests := startTestServer(t)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:     server.URL,
+		Buckets:     map[string][]float64{"otlp.latency": {1, 5}},
+		Temporality: DeltaTemporality,
+	})
+
+	e := stats.NewEngineWith(stats.EngineConfig{
+		Name:    "otlp",
+		Buckets: map[string][]float64{"otlp.latency": {2, 4, 8}},
+	})
+	e.Register(client)
+	e.Histogram("latency").Observe(3)
+	e.Flush()
+
+	e.SetBuckets("otlp.latency", []float64{10})
+	e.Histogram("latency").Observe(3)
+	e.Flush()
+
+	reqs := requests()
+
+	if len(reqs) != 2 {
+		t.Fatal("bad number of requests:", len(reqs))
+	}
+
+	for i, s := range []string{
+		`"dataPoints":[{"count":"1","sum":3,"bucketCounts":["0","1","0","0"],"explicitBounds":[2,4,8]}]`,
+		`"dataPoints":[{"count":"1","sum":3,"bucketCounts":["1","0"],"explicitBounds":[10]}]`,
+	} {
+		if body := timestamps.ReplaceAllString(reqs[i], ""); !strings.Contains(body, s) {
+			t.Errorf("the buckets of the engine were not applied, %s not found in %s", s, body)
+		}
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done() // hung backend
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL)
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+}
@@ -0,0 +1,86 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/segmentio/stats/internal/retry"
+)
+
+// grpcPath is the path of the Export method of the gRPC metrics service of
+// collectors.
+const grpcPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// writeGRPC sends req to the collector with a unary call to the Export method
+// of the gRPC metrics service.
+//
+// The call is a HTTP/2 request carrying the protobuf encoding of req in a
+// single length-prefixed message, the status of the call is reported in the
+// grpc-status trailer of the response, or in its headers when the collector
+// rejects the call without sending a response message.
+func (c *Client) writeGRPC(ctx context.Context, req exportMetricsServiceRequest) error {
+	// The 5 bytes of the message prefix are reserved at the front of the
+	// buffer, the compression flag and the length are set once known.
+	b := appendExportMetricsServiceRequest(make([]byte, 5, 4096), req)
+	compressed := byte(0)
+
+	if c.zpool != nil {
+		z := bytes.NewBuffer(make([]byte, 5, len(b)))
+
+		if err := c.zpool.Compress(z, b[5:]); err != nil {
+			return err
+		}
+
+		b, compressed = z.Bytes(), 1
+	}
+
+	b[0] = compressed
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(b)-5))
+
+	r, err := http.NewRequest("POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("TE", "trailers")
+
+	if c.zpool != nil {
+		r.Header.Set("Grpc-Encoding", c.zpool.Encoding())
+	}
+
+	res, err := c.httpc.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if _, err := retry.CheckResponse(res); err != nil {
+		return err
+	}
+
+	// Trailers are only available once the body was read entirely, which
+	// retry.CheckResponse does for successful responses.
+
+	status, msg := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+
+	if len(status) == 0 {
+		status, msg = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+
+	switch status {
+	case "0":
+		return nil
+	case "":
+		return fmt.Errorf("response of %s without grpc status, the server may not support HTTP/2", c.url)
+	default:
+		if m, err := url.PathUnescape(msg); err == nil {
+			msg = m
+		}
+		return fmt.Errorf("grpc status %s: %s", status, msg)
+	}
+}
@@ -0,0 +1,226 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+func startGRPCServer(t *testing.T, status string, message string) (*httptest.Server, func() [][]byte) {
+	var messages [][]byte
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || req.URL.Path != grpcPath || req.Header.Get("Content-Type") != "application/grpc" {
+			t.Error("bad request:", req.Proto, req.URL, req.Header)
+		}
+
+		b, _ := ioutil.ReadAll(req.Body)
+
+		if len(b) < 5 || b[0] != 0 || int(binary.BigEndian.Uint32(b[1:5])) != len(b)-5 {
+			t.Error("bad message prefix:", b)
+		} else {
+			messages = append(messages, b[5:])
+		}
+
+		res.Header().Set("Content-Type", "application/grpc")
+		res.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte{0, 0, 0, 0, 0}) // empty ExportMetricsServiceResponse
+		res.Header().Set("Grpc-Status", status)
+		res.Header().Set("Grpc-Message", message)
+	}))
+
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server, func() [][]byte { return messages }
+}
+
+func TestClientGRPC(t *testing.T) {
+	server, messages := startGRPCServer(t, "0", "")
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:   server.URL,
+		Protocol:  GRPCProtocol,
+		Transport: server.Client().Transport,
+		Resource:  []stats.Tag{{"service.name", "test"}},
+		Buckets:   map[string][]float64{"otlp.latency": {1, 5}},
+	})
+
+	e := stats.NewEngine("otlp")
+	e.Register(client)
+	e.Add("requests", 2, stats.Tag{"status", "ok"})
+	e.Observe("latency", 3)
+	e.Flush()
+
+	msgs := messages()
+
+	if len(msgs) != 1 {
+		t.Fatal("bad number of messages:", len(msgs))
+	}
+
+	rm := decodeProtobuf(t, msgs[0]).message(t, 1)
+
+	if attr := rm.message(t, 1).message(t, 1); attr.string(1) != "service.name" || attr.message(t, 2).string(1) != "test" {
+		t.Error("bad resource attribute:", attr)
+	}
+
+	sm := rm.message(t, 2)
+
+	if name := sm.message(t, 1).string(1); name != scopeName {
+		t.Error("bad scope name:", name)
+	}
+
+	metrics := sm.all(t, 2)
+
+	if len(metrics) != 2 {
+		t.Fatal("bad number of metrics:", len(metrics))
+	}
+
+	latency, requests := metrics[0], metrics[1]
+
+	if latency.string(1) != "otlp.latency" {
+		t.Error("bad histogram name:", latency.string(1))
+	}
+
+	h := latency.message(t, 9)
+	p := h.message(t, 1)
+
+	if h.varint(2) != aggregationTemporalityCumulative || p.varint(4) != 1 || math.Float64frombits(p.varint(5)) != 3 {
+		t.Error("bad histogram:", h)
+	}
+
+	if counts := p.bytes(6); len(counts) != 24 || binary.LittleEndian.Uint64(counts[8:]) != 1 {
+		t.Error("bad bucket counts:", counts)
+	}
+
+	if bounds := p.bytes(7); len(bounds) != 16 || math.Float64frombits(binary.LittleEndian.Uint64(bounds[8:])) != 5 {
+		t.Error("bad explicit bounds:", bounds)
+	}
+
+	if requests.string(1) != "otlp.requests" {
+		t.Error("bad counter name:", requests.string(1))
+	}
+
+	s := requests.message(t, 7)
+	p = s.message(t, 1)
+
+	if s.varint(2) != aggregationTemporalityCumulative || s.varint(3) != 1 || math.Float64frombits(p.varint(4)) != 2 {
+		t.Error("bad sum:", s)
+	}
+
+	if attr := p.message(t, 7); attr.string(1) != "status" || attr.message(t, 2).string(1) != "ok" {
+		t.Error("bad data point attribute:", attr)
+	}
+}
+
+func TestClientGRPCStatus(t *testing.T) {
+	server, _ := startGRPCServer(t, "14", "collector%20unavailable")
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:   server.URL,
+		Protocol:  GRPCProtocol,
+		Transport: server.Client().Transport,
+	})
+
+	client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1})
+	err := client.write(context.Background(), client.request(client.start, client.start))
+
+	if err == nil || !strings.Contains(err.Error(), "grpc status 14: collector unavailable") {
+		t.Error("bad error:", err)
+	}
+}
+
+func TestProtocolString(t *testing.T) {
+	for protocol, s := range map[Protocol]string{
+		HTTPProtocol: "http",
+		GRPCProtocol: "grpc",
+		Protocol(-1): "unknown",
+	} {
+		if protocol.String() != s {
+			t.Errorf("bad string for protocol %d: %s", protocol, protocol.String())
+		}
+	}
+}
+
+// protobufField is a field of a protobuf message decoded by tests, fixed64
+// values are decoded as varints.
+type protobufField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+type protobufFields []protobufField
+
+func decodeProtobuf(t *testing.T, b []byte) protobufFields {
+	var fields protobufFields
+
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := protobufField{num: int(key >> 3)}
+
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatal("bad wire type:", key&7)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+func (fields protobufFields) field(num int) protobufField {
+	for _, f := range fields {
+		if f.num == num {
+			return f
+		}
+	}
+	return protobufField{}
+}
+
+func (fields protobufFields) varint(num int) uint64 {
+	return fields.field(num).varint
+}
+
+func (fields protobufFields) bytes(num int) []byte {
+	return fields.field(num).bytes
+}
+
+func (fields protobufFields) string(num int) string {
+	return string(fields.bytes(num))
+}
+
+func (fields protobufFields) message(t *testing.T, num int) protobufFields {
+	return decodeProtobuf(t, fields.bytes(num))
+}
+
+func (fields protobufFields) all(t *testing.T, num int) []protobufFields {
+	var messages []protobufFields
+	for _, f := range fields {
+		if f.num == num {
+			messages = append(messages, decodeProtobuf(t, f.bytes))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,111 @@
+package otlp
+
+import "strconv"
+
+// This file declares the subset of the OTLP/JSON encoding of the metrics
+// export requests used by clients, see
+// https://github.com/open-telemetry/opentelemetry-proto for the reference.
+//
+// Note that 64 bits integers are encoded as strings in OTLP/JSON.
+
+const (
+	// aggregationTemporalityDelta indicates that the data points of a metric
+	// report the changes since the previous export.
+	aggregationTemporalityDelta = 1
+
+	// aggregationTemporalityCumulative indicates that the data points of a
+	// metric report the totals since the start time of the series.
+	aggregationTemporalityCumulative = 2
+)
+
+type exportMetricsServiceRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeMetrics struct {
+	Scope   instrumentationScope `json:"scope"`
+	Metrics []metric             `json:"metrics"`
+}
+
+type instrumentationScope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name      string     `json:"name"`
+	Unit      string     `json:"unit,omitempty"`
+	Sum       *sum       `json:"sum,omitempty"`
+	Gauge     *gauge     `json:"gauge,omitempty"`
+	Histogram *histogram `json:"histogram,omitempty"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	Count             uint64     `json:"count,string"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      uint64s    `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+	Min               *float64   `json:"min,omitempty"`
+	Max               *float64   `json:"max,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// uint64s is a list of integers encoded as a list of strings.
+type uint64s []uint64
+
+func (u uint64s) MarshalJSON() ([]byte, error) {
+	b := make([]byte, 0, 4*len(u)+2)
+	b = append(b, '[')
+
+	for i, v := range u {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '"')
+		b = strconv.AppendUint(b, v, 10)
+		b = append(b, '"')
+	}
+
+	return append(b, ']'), nil
+}
@@ -0,0 +1,209 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// This file encodes the export requests in the protobuf encoding used by the
+// OTLP/gRPC transport. The messages are encoded by hand, following the field
+// numbers of the metrics.proto, resource.proto, and common.proto files of the
+// opentelemetry-proto repository.
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendExportMetricsServiceRequest(b []byte, req exportMetricsServiceRequest) []byte {
+	for _, rm := range req.ResourceMetrics {
+		b = appendMessageField(b, 1, func(b []byte) []byte {
+			return appendResourceMetrics(b, rm)
+		})
+	}
+	return b
+}
+
+func appendResourceMetrics(b []byte, rm resourceMetrics) []byte {
+	b = appendMessageField(b, 1, func(b []byte) []byte {
+		return appendAttributes(b, 1, rm.Resource.Attributes)
+	})
+
+	for _, sm := range rm.ScopeMetrics {
+		b = appendMessageField(b, 2, func(b []byte) []byte {
+			return appendScopeMetrics(b, sm)
+		})
+	}
+
+	return b
+}
+
+func appendScopeMetrics(b []byte, sm scopeMetrics) []byte {
+	b = appendMessageField(b, 1, func(b []byte) []byte {
+		return appendStringField(b, 1, sm.Scope.Name)
+	})
+
+	for _, m := range sm.Metrics {
+		b = appendMessageField(b, 2, func(b []byte) []byte {
+			return appendMetric(b, m)
+		})
+	}
+
+	return b
+}
+
+func appendMetric(b []byte, m metric) []byte {
+	b = appendStringField(b, 1, m.Name)
+
+	if len(m.Unit) != 0 {
+		b = appendStringField(b, 3, m.Unit)
+	}
+
+	switch {
+	case m.Gauge != nil:
+		b = appendMessageField(b, 5, func(b []byte) []byte {
+			for _, p := range m.Gauge.DataPoints {
+				b = appendNumberDataPoint(b, 1, p)
+			}
+			return b
+		})
+
+	case m.Sum != nil:
+		b = appendMessageField(b, 7, func(b []byte) []byte {
+			for _, p := range m.Sum.DataPoints {
+				b = appendNumberDataPoint(b, 1, p)
+			}
+			b = appendVarintField(b, 2, uint64(m.Sum.AggregationTemporality))
+			if m.Sum.IsMonotonic {
+				b = appendVarintField(b, 3, 1)
+			}
+			return b
+		})
+
+	case m.Histogram != nil:
+		b = appendMessageField(b, 9, func(b []byte) []byte {
+			for _, p := range m.Histogram.DataPoints {
+				b = appendHistogramDataPoint(b, 1, p)
+			}
+			return appendVarintField(b, 2, uint64(m.Histogram.AggregationTemporality))
+		})
+	}
+
+	return b
+}
+
+func appendNumberDataPoint(b []byte, field int, p numberDataPoint) []byte {
+	return appendMessageField(b, field, func(b []byte) []byte {
+		b = appendFixed64Field(b, 2, p.StartTimeUnixNano)
+		b = appendFixed64Field(b, 3, p.TimeUnixNano)
+		b = appendDoubleField(b, 4, p.AsDouble)
+		return appendAttributes(b, 7, p.Attributes)
+	})
+}
+
+func appendHistogramDataPoint(b []byte, field int, p histogramDataPoint) []byte {
+	return appendMessageField(b, field, func(b []byte) []byte {
+		b = appendFixed64Field(b, 2, p.StartTimeUnixNano)
+		b = appendFixed64Field(b, 3, p.TimeUnixNano)
+		b = appendFixed64Field(b, 4, p.Count)
+		b = appendDoubleField(b, 5, p.Sum)
+
+		// Repeated scalars are packed, the counts and bounds are written as
+		// a single field carrying the concatenation of their values.
+		b = appendMessageField(b, 6, func(b []byte) []byte {
+			for _, c := range p.BucketCounts {
+				b = appendFixed64(b, c)
+			}
+			return b
+		})
+
+		b = appendMessageField(b, 7, func(b []byte) []byte {
+			for _, x := range p.ExplicitBounds {
+				b = appendFixed64(b, math.Float64bits(x))
+			}
+			return b
+		})
+
+		b = appendAttributes(b, 9, p.Attributes)
+
+		if p.Min != nil {
+			b = appendDoubleField(b, 11, *p.Min)
+		}
+
+		if p.Max != nil {
+			b = appendDoubleField(b, 12, *p.Max)
+		}
+
+		return b
+	})
+}
+
+func appendAttributes(b []byte, field int, attrs []keyValue) []byte {
+	for _, a := range attrs {
+		b = appendMessageField(b, field, func(b []byte) []byte {
+			b = appendStringField(b, 1, a.Key)
+			return appendMessageField(b, 2, func(b []byte) []byte {
+				return appendStringField(b, 1, a.Value.StringValue)
+			})
+		})
+	}
+	return b
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendFixed64Field(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireFixed64)
+	return appendFixed64(b, v)
+}
+
+func appendDoubleField(b []byte, field int, f float64) []byte {
+	return appendFixed64Field(b, field, math.Float64bits(f))
+}
+
+// appendMessageField appends the embedded message encoded by f to b, prefixed
+// with its length. The message is encoded in place and moved after the prefix
+// once its length is known, which avoids encoding it in a separate buffer.
+func appendMessageField(b []byte, field int, f func([]byte) []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	start := len(b)
+	b = f(b)
+	size := len(b) - start
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(size))
+
+	b = append(b, prefix[:n]...)
+	copy(b[start+n:], b[start:start+size])
+	copy(b[start:], prefix[:n])
+	return b
+}
+
+func appendKey(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var x [8]byte
+	binary.LittleEndian.PutUint64(x[:], v)
+	return append(b, x[:]...)
+}
@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// OutlierSuffix is appended to the names of histograms to form the name of
+	// the counter of their outliers.
+	OutlierSuffix = ".outliers_total"
+
+	// DefaultOutlierWindow is the default number of recent observations that
+	// rolling outlier thresholds are estimated from.
+	DefaultOutlierWindow = 1000
+)
+
+// OutlierThreshold configures the detection of outliers on a histogram, see
+// EngineConfig.Outliers.
+type OutlierThreshold struct {
+	// Value is a static threshold, observations greater than the value are
+	// outliers.
+	Value float64
+
+	// Percentile enables deriving the threshold from a rolling estimate of
+	// the percentile, between 0 and 1, of the recent observations of the
+	// histogram, for example 0.99.
+	//
+	// The estimate is only available once Window observations were made,
+	// Value is used as threshold until then, and no outliers are detected if
+	// it is zero.
+	Percentile float64
+
+	// Window is the number of recent observations that the percentile is
+	// estimated from, defaults to DefaultOutlierWindow.
+	Window int
+}
+
+// outlierDetector compares the observations of histograms to their outlier
+// thresholds.
+type outlierDetector struct {
+	trackers map[string]*outlierTracker // read-only after construction
+}
+
+type outlierTracker struct {
+	mutex      sync.Mutex
+	threshold  float64 // zero when no threshold is known
+	percentile float64
+	window     []float64 // ring of recent observations
+	next       int       // index of the next observation in the ring
+	full       bool      // whether the ring was filled once
+	stale      int       // observations since the threshold was estimated
+	refresh    int       // observations after which the threshold is estimated
+	sorted     []float64
+}
+
+func newOutlierDetector(thresholds map[string]OutlierThreshold) *outlierDetector {
+	d := &outlierDetector{
+		trackers: make(map[string]*outlierTracker, len(thresholds)),
+	}
+
+	for name, t := range thresholds {
+		if t.Value <= 0 && (t.Percentile <= 0 || t.Percentile >= 1) {
+			continue
+		}
+
+		tracker := &outlierTracker{threshold: t.Value}
+
+		if t.Percentile > 0 && t.Percentile < 1 {
+			if t.Window <= 0 {
+				t.Window = DefaultOutlierWindow
+			}
+
+			tracker.percentile = t.Percentile
+			tracker.window = make([]float64, t.Window)
+			tracker.sorted = make([]float64, t.Window)
+
+			// Sorting the window on every observation would be too costly,
+			// the estimate is refreshed a few times per window instead.
+			if tracker.refresh = t.Window / 16; tracker.refresh == 0 {
+				tracker.refresh = 1
+			}
+
+			// The threshold is estimated as soon as the window is filled.
+			tracker.stale = tracker.refresh - 1
+		}
+
+		d.trackers[name] = tracker
+	}
+
+	return d
+}
+
+// outlier returns whether value, observed on the histogram with name, is an
+// outlier.
+func (d *outlierDetector) outlier(name string, value float64) bool {
+	t := d.trackers[name]
+	if t == nil {
+		return false
+	}
+
+	t.mutex.Lock()
+	outlier := t.threshold > 0 && value > t.threshold
+
+	if t.window != nil {
+		t.observe(value)
+	}
+
+	t.mutex.Unlock()
+	return outlier
+}
+
+func (t *outlierTracker) observe(value float64) {
+	t.window[t.next] = value
+
+	if t.next++; t.next == len(t.window) {
+		t.next, t.full = 0, true
+	}
+
+	if !t.full {
+		return
+	}
+
+	if t.stale++; t.stale < t.refresh {
+		return
+	}
+
+	t.stale = 0
+	copy(t.sorted, t.window)
+	sort.Float64s(t.sorted)
+	t.threshold = t.sorted[int(t.percentile*float64(len(t.sorted)-1)+0.5)]
+}
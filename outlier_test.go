@@ -0,0 +1,121 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEngineOutliersStatic(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:     "E",
+		Outliers: map[string]OutlierThreshold{"latency": {Value: 1}},
+	})
+	e.Register(h)
+
+	e.Observe("latency", 0.5, Tag{"operation", "read"})
+	e.Observe("latency", 2, Tag{"operation", "write"})
+	e.Observe("size", 2, Tag{"operation", "write"})
+	e.IncrAndObserve("calls", "latency", 3, Tag{"operation", "read"})
+
+	want := []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 0.5, Tags: []Tag{{"operation", "read"}}},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 2, Tags: []Tag{{"operation", "write"}}},
+		{Type: CounterType, Namespace: "E", Name: "latency" + OutlierSuffix, Value: 1, Tags: []Tag{{"operation", "write"}}},
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 2, Tags: []Tag{{"operation", "write"}}},
+		{Type: CounterType, Namespace: "E", Name: "calls", Value: 1, Tags: []Tag{{"operation", "read"}}},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Value: 3, Tags: []Tag{{"operation", "read"}}},
+		{Type: CounterType, Namespace: "E", Name: "latency" + OutlierSuffix, Value: 1, Tags: []Tag{{"operation", "read"}}},
+	}
+
+	if !reflect.DeepEqual(h.metrics, want) {
+		t.Errorf("bad metrics:\n- expected: %#v\n- found:    %#v", want, h.metrics)
+	}
+}
+
+func TestEngineOutliersPercentile(t *testing.T) {
+	tests := []struct {
+		scenario  string
+		threshold OutlierThreshold
+		coldStart int
+	}{
+		{
+			scenario:  "no outliers are detected until the window is filled",
+			threshold: OutlierThreshold{Percentile: 0.9, Window: 100},
+			coldStart: 0,
+		},
+		{
+			scenario:  "the static threshold is used until the window is filled",
+			threshold: OutlierThreshold{Value: 95, Percentile: 0.9, Window: 100},
+			coldStart: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			h := &handler{}
+			e := NewEngineWith(EngineConfig{
+				Name:     "E",
+				Outliers: map[string]OutlierThreshold{"latency": test.threshold},
+			})
+			e.Register(h)
+
+			// Filling the window with values from 0 to 99 sets the threshold
+			// to their 90th percentile.
+			for i := 0; i != 100; i++ {
+				e.Observe("latency", float64(i))
+			}
+
+			if n := countOutliers(h.metrics); n != test.coldStart {
+				t.Error("bad number of outliers during the cold start:", n)
+			}
+
+			h.metrics = nil
+
+			for i := 0; i != 100; i++ {
+				e.Observe("latency", float64(i))
+			}
+
+			if n := countOutliers(h.metrics); n != 10 {
+				t.Error("bad number of outliers:", n)
+			}
+		})
+	}
+}
+
+func TestOutlierDetectorRolling(t *testing.T) {
+	d := newOutlierDetector(map[string]OutlierThreshold{
+		"latency": {Percentile: 0.5, Window: 16},
+	})
+
+	for i := 0; i != 16; i++ {
+		d.outlier("latency", 1)
+	}
+
+	if !d.outlier("latency", 2) {
+		t.Error("values above the median must be outliers")
+	}
+
+	// The threshold follows the shift of the distribution.
+	for i := 0; i != 16; i++ {
+		d.outlier("latency", 10)
+	}
+
+	if d.outlier("latency", 2) {
+		t.Error("values below the median must not be outliers")
+	}
+
+	if d.outlier("other", 1e9) {
+		t.Error("histograms without thresholds must not have outliers")
+	}
+}
+
+func countOutliers(metrics []Metric) int {
+	n := 0
+	for _, m := range metrics {
+		if m.Name == "latency"+OutlierSuffix {
+			n++
+		}
+	}
+	return n
+}
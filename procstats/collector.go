@@ -5,6 +5,8 @@ import (
 	"runtime"
 	"sync"
 	"time"
+
+	"github.com/segmentio/stats"
 )
 
 type Collector interface {
@@ -15,17 +17,75 @@ type CollectorFunc func()
 
 func (f CollectorFunc) Collect() { f() }
 
+// A Sampler is a Collector which separates reading a sample from reporting it,
+// the samples of collectors implementing the interface which exceed the
+// collect timeout are discarded instead of being reported late.
+type Sampler interface {
+	Collector
+
+	// Sample reads a sample and returns a function reporting it.
+	Sample() func()
+}
+
 type Config struct {
 	Collector       Collector
 	CollectInterval time.Duration
+
+	// CollectTimeout is the maximum amount of time that a sample may take
+	// before it is considered skipped, defaults to CollectInterval.
+	//
+	// Samples are collected by a dedicated worker and never overlap, a sample
+	// that exceeds the timeout is left to complete in the background and the
+	// samples scheduled while it is running are skipped, so slow reads of
+	// /proc on a loaded host do not pile up goroutines or delay the following
+	// samples. Skipped samples are counted by the procstats.collector.skipped
+	// metric. The late samples of collectors implementing the Sampler
+	// interface are discarded when they complete, and closing the collector
+	// does not wait for a sample that exceeded the timeout.
+	CollectTimeout time.Duration
+
+	// Engine is the engine on which the collector reports its own metrics,
+	// defaults to stats.DefaultEngine.
+	Engine *stats.Engine
 }
 
+// MultiCollector returns a collector which collects samples with all of the
+// given collectors, it implements the Sampler interface and reports the samples
+// of the collectors which implement it together.
 func MultiCollector(collectors ...Collector) Collector {
-	return CollectorFunc(func() {
-		for _, c := range collectors {
-			c.Collect()
+	return multiCollector(collectors)
+}
+
+type multiCollector []Collector
+
+func (m multiCollector) Collect() {
+	m.Sample()()
+}
+
+func (m multiCollector) Sample() func() {
+	reports := make([]func(), 0, len(m))
+
+	for _, c := range m {
+		if r := sample(c); r != nil {
+			reports = append(reports, r)
 		}
-	})
+	}
+
+	return func() {
+		for _, r := range reports {
+			r()
+		}
+	}
+}
+
+// sample collects a sample with c, it returns the function reporting the
+// sample when c is a Sampler, and nil when c reported it already.
+func sample(c Collector) func() {
+	if s, ok := c.(Sampler); ok {
+		return s.Sample()
+	}
+	c.Collect()
+	return nil
 }
 
 func StartCollector(collector Collector) io.Closer {
@@ -37,6 +97,9 @@ func StartCollectorWith(config Config) io.Closer {
 
 	stop := make(chan struct{})
 	join := make(chan struct{})
+	samples := make(chan struct{})
+	// Buffered so the worker completes a sample abandoned by Close.
+	done := make(chan func(), 1)
 
 	go func() {
 		// Locks the OS thread, stats collection heavily relies on blocking
@@ -44,18 +107,77 @@ func StartCollectorWith(config Config) io.Closer {
 		// increases the chance for the Go runtime to detected that the thread
 		// is blocked an schedule a new one.
 		runtime.LockOSThread()
-
 		defer runtime.UnlockOSThread()
+
+		for range samples {
+			done <- sample(config.Collector)
+		}
+	}()
+
+	go func() {
 		defer close(join)
+		defer close(samples)
 
 		ticker := time.NewTicker(config.CollectInterval)
+		defer ticker.Stop()
+
+		var busy bool
+		var late bool // the running sample exceeded the timeout
+		var timeout <-chan time.Time
+
+		report := func(r func()) {
+			if r != nil && !late {
+				r()
+			}
+			busy, late, timeout = false, false, nil
+		}
+
+		expire := func() {
+			config.Engine.Incr("procstats.collector.skipped", stats.Tag{"reason", "timeout"})
+			late, timeout = true, nil
+		}
 
 		for {
 			select {
 			case <-ticker.C:
-				config.Collector.Collect()
+				if busy {
+					config.Engine.Incr("procstats.collector.skipped", stats.Tag{"reason", "busy"})
+					continue
+				}
+				busy, timeout = true, time.After(config.CollectTimeout)
+				samples <- struct{}{}
+
+			case r := <-done:
+				report(r)
+
+			case <-timeout:
+				expire()
+
 			case <-stop:
-				config.Collector.Collect()
+				if busy && !late {
+					select {
+					case r := <-done:
+						report(r)
+					case <-timeout:
+						expire()
+					}
+				}
+
+				if busy {
+					// The sample was abandoned, the worker exits once it
+					// completes.
+					return
+				}
+
+				busy, timeout = true, time.After(config.CollectTimeout)
+				samples <- struct{}{}
+
+				select {
+				case r := <-done:
+					report(r)
+				case <-timeout:
+					expire()
+				}
 				return
 			}
 		}
@@ -69,10 +191,18 @@ func setConfigDefaults(config Config) Config {
 		config.CollectInterval = 5 * time.Second
 	}
 
+	if config.CollectTimeout == 0 {
+		config.CollectTimeout = config.CollectInterval
+	}
+
 	if config.Collector == nil {
 		config.Collector = MultiCollector()
 	}
 
+	if config.Engine == nil {
+		config.Engine = stats.DefaultEngine
+	}
+
 	return config
 }
 
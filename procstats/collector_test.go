@@ -1,6 +1,7 @@
 package procstats
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -46,3 +47,141 @@ func TestCollectorCloser(t *testing.T) {
 		t.Error("unexpected error reported when closing a collector:", err)
 	}
 }
+
+func TestCollectorTimeout(t *testing.T) {
+	h := &handler{}
+	e := stats.NewEngine("")
+	e.Register(h)
+
+	var mutex sync.Mutex
+	var calls int
+	unblock := make(chan struct{})
+
+	c := StartCollectorWith(Config{
+		CollectInterval: time.Millisecond,
+		CollectTimeout:  time.Millisecond,
+		Engine:          e,
+		Collector: CollectorFunc(func() {
+			mutex.Lock()
+			calls++
+			n := calls
+			mutex.Unlock()
+
+			if n == 1 {
+				<-unblock
+			}
+		}),
+	})
+
+	time.Sleep(20 * time.Millisecond)
+
+	mutex.Lock()
+	if calls != 1 {
+		t.Error("samples were collected while the first one was blocked:", calls)
+	}
+	mutex.Unlock()
+
+	close(unblock)
+	c.Close()
+
+	reasons := map[string]int{}
+
+	for _, m := range h.metrics {
+		if m.Name == "procstats.collector.skipped" {
+			reasons[m.Tags[0].Value]++
+		}
+	}
+
+	if reasons["timeout"] != 1 || reasons["busy"] == 0 {
+		t.Error("bad skipped samples:", reasons)
+	}
+}
+
+type blockingSampler struct {
+	mutex    sync.Mutex
+	calls    int
+	reported int
+	unblock  chan struct{}
+}
+
+func (s *blockingSampler) Collect() { s.Sample()() }
+
+func (s *blockingSampler) Sample() func() {
+	s.mutex.Lock()
+	s.calls++
+	n := s.calls
+	s.mutex.Unlock()
+
+	if n == 1 {
+		<-s.unblock
+	}
+
+	return func() {
+		s.mutex.Lock()
+		s.reported++
+		s.mutex.Unlock()
+	}
+}
+
+func (s *blockingSampler) counts() (calls int, reported int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls, s.reported
+}
+
+func TestCollectorDiscardLateSamples(t *testing.T) {
+	s := &blockingSampler{unblock: make(chan struct{})}
+
+	c := StartCollectorWith(Config{
+		CollectInterval: time.Millisecond,
+		CollectTimeout:  time.Millisecond,
+		Engine:          stats.NewEngine(""),
+		Collector:       MultiCollector(s),
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	close(s.unblock)
+
+	for i := 0; i != 100; i++ {
+		if calls, _ := s.counts(); calls > 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Close()
+
+	if calls, reported := s.counts(); calls < 2 || reported != calls-1 {
+		t.Errorf("the late sample was reported: %d samples, %d reported", calls, reported)
+	}
+}
+
+func TestCollectorCloseAbandonedSample(t *testing.T) {
+	s := &blockingSampler{unblock: make(chan struct{})}
+	defer close(s.unblock)
+
+	c := StartCollectorWith(Config{
+		CollectInterval: time.Millisecond,
+		CollectTimeout:  time.Millisecond,
+		Engine:          stats.NewEngine(""),
+		Collector:       s,
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan struct{})
+
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("closing the collector blocked on the abandoned sample")
+	}
+
+	if _, reported := s.counts(); reported != 0 {
+		t.Error("the abandoned sample was reported")
+	}
+}
@@ -90,7 +90,19 @@ func NewProcMetricsWith(eng *stats.Engine, pid int) *ProcMetrics {
 
 // Collect satsifies the Collector interface.
 func (p *ProcMetrics) Collect() {
-	if m, err := collectProcMetrics(p.pid); err == nil {
+	p.Sample()()
+}
+
+// Sample satisfies the Sampler interface, the sample is read from the
+// process and reported when the returned function is called.
+func (p *ProcMetrics) Sample() func() {
+	m, err := collectProcMetrics(p.pid)
+
+	return func() {
+		if err != nil {
+			return
+		}
+
 		// CPU
 		p.cpu.user.Set(m.cpu.user.Seconds())
 		p.cpu.sys.Set(m.cpu.sys.Seconds())
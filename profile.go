@@ -0,0 +1,183 @@
+package stats
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteProfile writes a snapshot of metrics to w as a gzip-compressed pprof
+// profile, which can be explored with `go tool pprof` to find out which
+// metrics dominate the volume produced by a program.
+//
+// Each metric name is mapped to a call stack, with the namespace as the root
+// frame followed by one frame per dot-separated component of the name, so
+// "http.req.count" in namespace "api" becomes api → http → req → count. Flame
+// graphs then group metrics by prefix. Each stack carries two values: the
+// number of metrics with this name in the snapshot ("metrics"), and the number
+// of distinct tag sets among them ("series").
+//
+// The snapshot is typically the list of metrics received by a handler over a
+// period of time.
+func WriteProfile(w io.Writer, metrics []Metric) error {
+	z := gzip.NewWriter(w)
+
+	if _, err := z.Write(encodeProfile(metrics)); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+type profileSample struct {
+	stack   []string
+	metrics int64
+	series  map[string]struct{}
+}
+
+func encodeProfile(metrics []Metric) []byte {
+	samples := make(map[string]*profileSample)
+
+	for _, m := range metrics {
+		key := m.Namespace + "\x00" + m.Name
+		s := samples[key]
+
+		if s == nil {
+			s = &profileSample{series: make(map[string]struct{})}
+
+			if len(m.Namespace) != 0 {
+				s.stack = append(s.stack, m.Namespace)
+			}
+
+			s.stack = append(s.stack, strings.Split(m.Name, ".")...)
+			samples[key] = s
+		}
+
+		tags := copyTags(m.Tags)
+		sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+
+		s.metrics++
+		s.series[diffKey(m.Type, "", "", tags)] = struct{}{}
+	}
+
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	p := &profileEncoder{strings: map[string]int64{"": 0}, table: []string{""}, funcs: map[string]uint64{}}
+	b := []byte{}
+
+	// sample_type
+	b = appendProtoMessage(b, 1, p.valueType("metrics", "count"))
+	b = appendProtoMessage(b, 1, p.valueType("series", "count"))
+
+	for _, key := range keys {
+		s := samples[key]
+		locations := make([]uint64, len(s.stack))
+
+		// Locations are ordered from the leaf to the root of the stack, the
+		// path to the frame is used as function name so identical components
+		// at different positions are not merged.
+		for i := range s.stack {
+			locations[len(s.stack)-1-i] = p.function(s.stack[:i+1])
+		}
+
+		sample := []byte{}
+		sample = appendProtoPacked(sample, 1, locations...)
+		sample = appendProtoPacked(sample, 2, uint64(s.metrics), uint64(len(s.series)))
+		b = appendProtoMessage(b, 2, sample)
+	}
+
+	// location and function, ids of locations and functions are the same
+	for i, name := range p.names {
+		id := uint64(i + 1)
+
+		line := appendProtoVarint(nil, 1, id)
+		location := appendProtoVarint(nil, 1, id)
+		location = appendProtoMessage(location, 4, line)
+		b = appendProtoMessage(b, 4, location)
+
+		function := appendProtoVarint(nil, 1, id)
+		function = appendProtoVarint(function, 2, uint64(p.str(name)))
+		b = appendProtoMessage(b, 5, function)
+	}
+
+	// string_table, must be last since functions add strings to it
+	for _, s := range p.table {
+		b = appendProtoBytes(b, 6, []byte(s))
+	}
+
+	return b
+}
+
+type profileEncoder struct {
+	strings map[string]int64
+	table   []string
+	funcs   map[string]uint64
+	names   []string
+}
+
+func (p *profileEncoder) str(s string) int64 {
+	i, ok := p.strings[s]
+	if !ok {
+		i = int64(len(p.table))
+		p.strings[s] = i
+		p.table = append(p.table, s)
+	}
+	return i
+}
+
+func (p *profileEncoder) valueType(typ string, unit string) []byte {
+	b := appendProtoVarint(nil, 1, uint64(p.str(typ)))
+	return appendProtoVarint(b, 2, uint64(p.str(unit)))
+}
+
+// function returns the id of the function representing the last frame of
+// stack, which is also the id of its location.
+func (p *profileEncoder) function(stack []string) uint64 {
+	key := strings.Join(stack, "\x00")
+	id, ok := p.funcs[key]
+	if !ok {
+		p.names = append(p.names, stack[len(stack)-1])
+		id = uint64(len(p.names))
+		p.funcs[key] = id
+	}
+	return id
+}
+
+// The functions below implement the subset of the protobuf wire format needed
+// to encode profiles.
+
+func appendProtoUvarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+func appendProtoVarint(b []byte, field int, x uint64) []byte {
+	b = appendProtoUvarint(b, uint64(field)<<3)
+	return appendProtoUvarint(b, x)
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = appendProtoUvarint(b, uint64(field)<<3|2)
+	b = appendProtoUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendProtoMessage(b []byte, field int, msg []byte) []byte {
+	return appendProtoBytes(b, field, msg)
+}
+
+func appendProtoPacked(b []byte, field int, values ...uint64) []byte {
+	packed := []byte{}
+	for _, v := range values {
+		packed = appendProtoUvarint(packed, v)
+	}
+	return appendProtoBytes(b, field, packed)
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestWriteProfile(t *testing.T) {
+	b := &bytes.Buffer{}
+
+	if err := WriteProfile(b, []Metric{
+		{Type: CounterType, Namespace: "api", Name: "http.req.count", Tags: []Tag{{"code", "200"}}},
+		{Type: CounterType, Namespace: "api", Name: "http.req.count", Tags: []Tag{{"code", "500"}}},
+		{Type: CounterType, Namespace: "api", Name: "http.req.count", Tags: []Tag{{"code", "500"}}},
+		{Type: GaugeType, Namespace: "api", Name: "http.conns"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var samples [][]uint64
+	var strings []string
+
+	for len(p) != 0 {
+		field, data, n := readProtoBytes(p)
+		p = p[n:]
+
+		switch field {
+		case 2: // sample
+			_, _, n := readProtoBytes(data) // location_id
+			_, values, _ := readProtoBytes(data[n:])
+			samples = append(samples, readProtoPacked(values))
+		case 6: // string_table
+			strings = append(strings, string(data))
+		}
+	}
+
+	if !reflect.DeepEqual(samples, [][]uint64{{1, 1}, {3, 2}}) {
+		t.Error("bad samples:", samples)
+	}
+
+	if !reflect.DeepEqual(strings, []string{"", "metrics", "count", "series", "api", "http", "conns", "req"}) {
+		t.Error("bad string table:", strings)
+	}
+}
+
+func readProtoUvarint(b []byte) (x uint64, n int) {
+	for shift := uint(0); ; shift += 7 {
+		c := b[n]
+		n++
+		x |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return
+		}
+	}
+}
+
+func readProtoBytes(b []byte) (field int, data []byte, n int) {
+	tag, n1 := readProtoUvarint(b)
+	size, n2 := readProtoUvarint(b[n1:])
+	n = n1 + n2 + int(size)
+	return int(tag >> 3), b[n1+n2 : n], n
+}
+
+func readProtoPacked(b []byte) (values []uint64) {
+	for len(b) != 0 {
+		x, n := readProtoUvarint(b)
+		values = append(values, x)
+		b = b[n:]
+	}
+	return
+}
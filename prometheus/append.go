@@ -0,0 +1,232 @@
+package prometheus
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appendMetric appends the samples of m to b, with the time of the last update
+// of m as timestamp if timestamps is true.
+func appendMetric(b []byte, m metric, openMetrics bool, timestamps bool) []byte {
+	var ts string
+
+	if timestamps && !m.time.IsZero() {
+		ts = formatTimestamp(m.time, openMetrics)
+	}
+
+	if !openMetrics {
+		switch m.mtype {
+		case histogram:
+			return appendHistogram(b, m, ts, nil)
+		case summary:
+			return appendSummary(b, m, ts)
+		case nativeHistogram:
+			return appendNativeHistogram(b, m, ts)
+		default:
+			return appendSample(b, m.name, "", m.labels, m.value, nil, ts, nil)
+		}
+	}
+
+	switch m.mtype {
+	case counter:
+		name := familyName(m)
+		b = appendSample(b, name, "_total", m.labels, m.value, nil, ts, exemplarAt(m.exemplars, 0))
+		b = appendSample(b, name, "_created", m.labels, unixSeconds(m.created), nil, ts, nil)
+	case histogram:
+		b = appendHistogram(b, m, ts, m.exemplars)
+		b = appendSample(b, m.name, "_created", m.labels, unixSeconds(m.created), nil, ts, nil)
+	case summary:
+		b = appendSummary(b, m, ts)
+		b = appendSample(b, m.name, "_created", m.labels, unixSeconds(m.created), nil, ts, nil)
+	case nativeHistogram:
+		b = appendNativeHistogram(b, m, ts)
+		b = appendSample(b, m.name, "_created", m.labels, unixSeconds(m.created), nil, ts, nil)
+	default:
+		b = appendSample(b, m.name, "", m.labels, m.value, nil, ts, nil)
+	}
+
+	return b
+}
+
+// familyName returns the name of the metric family of m in the OpenMetrics
+// format, where the names of counter families do not carry the _total suffix
+// of their samples.
+func familyName(m metric) string {
+	if m.mtype == counter {
+		return strings.TrimSuffix(m.name, "_total")
+	}
+	return m.name
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// appendHistogram appends the samples of the histogram m to b, exemplars are the
+// exemplars of its buckets, or nil if they are not exposed.
+func appendHistogram(b []byte, m metric, ts string, exemplars []exemplar) []byte {
+	var cumulative uint64
+
+	for i, limit := range m.buckets.limits {
+		cumulative += m.buckets.counts[i]
+		b = appendSample(b, m.name, "_bucket", m.labels, float64(cumulative), &label{"le", formatFloat(limit)}, ts, exemplarAt(exemplars, i))
+	}
+
+	b = appendSample(b, m.name, "_bucket", m.labels, float64(m.count), &label{"le", "+Inf"}, ts, exemplarAt(exemplars, len(m.buckets.limits)))
+	b = appendSample(b, m.name, "_sum", m.labels, m.value, nil, ts, nil)
+	b = appendSample(b, m.name, "_count", m.labels, float64(m.count), nil, ts, nil)
+	return b
+}
+
+func appendSummary(b []byte, m metric, ts string) []byte {
+	for _, q := range m.quantiles {
+		b = appendSample(b, m.name, "", m.labels, q.value, &label{"quantile", formatFloat(q.q)}, ts, nil)
+	}
+
+	b = appendSample(b, m.name, "_sum", m.labels, m.value, nil, ts, nil)
+	b = appendSample(b, m.name, "_count", m.labels, float64(m.count), nil, ts, nil)
+	return b
+}
+
+// appendSample appends a sample to b, followed by the timestamp ts unless it is
+// empty, and by the exemplar ex unless it is nil.
+func appendSample(b []byte, name string, suffix string, l labels, value float64, extra *label, ts string, ex *exemplar) []byte {
+	b = append(b, name...)
+	b = append(b, suffix...)
+
+	if len(l) != 0 || extra != nil {
+		b = append(b, '{')
+		b = appendLabels(b, l)
+
+		if extra != nil {
+			if len(l) != 0 {
+				b = append(b, ',')
+			}
+			b = appendLabel(b, *extra)
+		}
+
+		b = append(b, '}')
+	}
+
+	b = append(b, ' ')
+	b = appendFloat(b, value)
+
+	if len(ts) != 0 {
+		b = append(b, ' ')
+		b = append(b, ts...)
+	}
+
+	if ex != nil && len(ex.labels) != 0 {
+		b = append(b, " # {"...)
+		b = appendLabels(b, ex.labels)
+		b = append(b, "} "...)
+		b = appendFloat(b, ex.value)
+		b = append(b, ' ')
+		b = appendFloat(b, unixSeconds(ex.time))
+	}
+
+	return append(b, '\n')
+}
+
+// exemplarAt returns the exemplar at index i, or nil if there are none.
+func exemplarAt(exemplars []exemplar, i int) *exemplar {
+	if i < len(exemplars) {
+		return &exemplars[i]
+	}
+	return nil
+}
+
+// formatTimestamp formats t as a sample timestamp, in milliseconds in the
+// prometheus text format and in seconds in the OpenMetrics format.
+func formatTimestamp(t time.Time, openMetrics bool) string {
+	if openMetrics {
+		return formatFloat(unixSeconds(t))
+	}
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func appendLabels(b []byte, l labels) []byte {
+	for i, x := range l {
+		if i != 0 {
+			b = append(b, ',')
+		}
+		b = appendLabel(b, x)
+	}
+	return b
+}
+
+func appendLabel(b []byte, l label) []byte {
+	b = append(b, l.name...)
+	b = append(b, '=', '"')
+	b = appendEscaped(b, l.value, true)
+	return append(b, '"')
+}
+
+func appendType(b []byte, name string, mtype metricType, openMetrics bool) []byte {
+	b = append(b, "# TYPE "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+
+	if openMetrics && mtype == untyped {
+		b = append(b, "unknown"...)
+	} else {
+		b = append(b, mtype.String()...)
+	}
+
+	return append(b, '\n')
+}
+
+func appendHelp(b []byte, name string, help string, openMetrics bool) []byte {
+	b = append(b, "# HELP "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = appendEscaped(b, help, openMetrics)
+	return append(b, '\n')
+}
+
+func appendUnit(b []byte, name string, unit string) []byte {
+	b = append(b, "# UNIT "...)
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = append(b, unit...)
+	return append(b, '\n')
+}
+
+func appendEscaped(b []byte, s string, quote bool) []byte {
+	for i := 0; i != len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b = append(b, '\\', '\\')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '"':
+			if quote {
+				b = append(b, '\\', '"')
+			} else {
+				b = append(b, c)
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, "NaN"...)
+	case math.IsInf(f, +1):
+		return append(b, "+Inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-Inf"...)
+	default:
+		return strconv.AppendFloat(b, f, 'g', -1, 64)
+	}
+}
+
+func formatFloat(f float64) string {
+	return string(appendFloat(nil, f))
+}
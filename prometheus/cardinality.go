@@ -0,0 +1,115 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// DefaultTopCardinality is the number of metrics returned by the handler of
+// CardinalityHandler when the request doesn't specify one.
+const DefaultTopCardinality = 10
+
+// Cardinality carries the number of series of a metric exposed by a handler.
+type Cardinality struct {
+	// Name is the name of the exposed metric.
+	Name string `json:"name"`
+
+	// Type is the prometheus type of the metric, "counter", "gauge", or
+	// "histogram".
+	Type string `json:"type"`
+
+	// Series is the number of series that the metric currently has.
+	Series int `json:"series"`
+}
+
+// Cardinality returns the number of series of the metric with name, the name of
+// the exposed metric, or zero if the handler doesn't have such a metric.
+//
+// Comparing the value with the cardinality that a metric is expected to have
+// is a way to set targeted caps, like DownsampleSeries, on the metrics that
+// need them.
+func (h *Handler) Cardinality(name string) int {
+	s := &h.metrics
+	s.mutex.RLock()
+	n := 0
+
+	if e := s.entries[name]; e != nil {
+		n = e.cardinality()
+	}
+
+	s.mutex.RUnlock()
+	return n
+}
+
+// TopCardinality returns the n metrics of the handler with the most series,
+// sorted by decreasing number of series then by name. All metrics are returned
+// when n is negative.
+//
+// Only the number of series of each metric is read, the series themselves are
+// not collected, so the method is cheap enough to be called periodically to
+// surface the biggest contributors to the cost of a program's metrics.
+func (h *Handler) TopCardinality(n int) []Cardinality {
+	s := &h.metrics
+	s.mutex.RLock()
+	top := make([]Cardinality, 0, len(s.entries))
+
+	for _, e := range s.entries {
+		top = append(top, Cardinality{
+			Name:   e.name,
+			Type:   e.mtype.String(),
+			Series: e.cardinality(),
+		})
+	}
+
+	s.mutex.RUnlock()
+
+	sort.Slice(top, func(i int, j int) bool {
+		if top[i].Series != top[j].Series {
+			return top[i].Series > top[j].Series
+		}
+		return top[i].Name < top[j].Name
+	})
+
+	if n >= 0 && n < len(top) {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// CardinalityHandler returns a http handler which serves the result of the
+// TopCardinality method of h as a JSON array, it is intended to be mounted on
+// an administration endpoint. The number of metrics is read from the "n" query
+// parameter and defaults to DefaultTopCardinality.
+func CardinalityHandler(h *Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			res.Header().Set("Allow", "GET")
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := DefaultTopCardinality
+
+		if s := req.URL.Query().Get("n"); len(s) != 0 {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(res, "invalid value of the n parameter: "+s, http.StatusBadRequest)
+				return
+			}
+			n = v
+		}
+
+		res.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(res).Encode(h.TopCardinality(n))
+	})
+}
+
+func (e *metricEntry) cardinality() int {
+	e.mutex.Lock()
+	n := len(e.states)
+	e.mutex.Unlock()
+	return n
+}
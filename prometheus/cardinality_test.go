@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+func TestHandlerTopCardinality(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("E")
+	e.Register(h)
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		e.Incr("requests", stats.Tag{"path", path})
+		e.Observe("latency", 1, stats.Tag{"path", path}, stats.Tag{"method", "GET"})
+		e.Observe("latency", 1, stats.Tag{"path", path}, stats.Tag{"method", "POST"})
+	}
+	e.Set("conns", 1)
+
+	if n := h.Cardinality("E_latency"); n != 6 {
+		t.Error("bad cardinality of E_latency:", n)
+	}
+
+	if n := h.Cardinality("E_missing"); n != 0 {
+		t.Error("bad cardinality of a missing metric:", n)
+	}
+
+	tests := []struct {
+		n   int
+		top []Cardinality
+	}{
+		{
+			n: 2,
+			top: []Cardinality{
+				{Name: "E_latency", Type: "histogram", Series: 6},
+				{Name: "E_requests", Type: "counter", Series: 3},
+			},
+		},
+		{
+			n: -1,
+			top: []Cardinality{
+				{Name: "E_latency", Type: "histogram", Series: 6},
+				{Name: "E_requests", Type: "counter", Series: 3},
+				{Name: "E_conns", Type: "gauge", Series: 1},
+			},
+		},
+		{
+			n:   0,
+			top: []Cardinality{},
+		},
+	}
+
+	for _, test := range tests {
+		if top := h.TopCardinality(test.n); !reflect.DeepEqual(top, test.top) {
+			t.Errorf("n=%d: bad cardinality: %+v", test.n, top)
+		}
+	}
+}
+
+func TestCardinalityHandler(t *testing.T) {
+	h := &Handler{}
+	h.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "requests", Value: 1})
+	h.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/cardinality", 200, `[{"name":"conns","type":"gauge","series":1},{"name":"requests","type":"counter","series":1}]`},
+		{"/cardinality?n=1", 200, `[{"name":"conns","type":"gauge","series":1}]`},
+		{"/cardinality?n=x", 400, "invalid value of the n parameter: x"},
+	}
+
+	for _, test := range tests {
+		res := httptest.NewRecorder()
+		CardinalityHandler(h).ServeHTTP(res, httptest.NewRequest("GET", test.url, nil))
+
+		if res.Code != test.status {
+			t.Errorf("%s: bad status: %d", test.url, res.Code)
+		}
+
+		if body := strings.TrimSpace(res.Body.String()); body != test.body {
+			t.Errorf("%s: bad body: %s", test.url, body)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package prometheus
+
+import "fmt"
+
+// ConflictPolicy is an enumeration of the behaviors of handlers when metrics
+// of different types, or with different help texts, are reported under the
+// same name. This usually happens when two packages accidentally use the same
+// metric name.
+type ConflictPolicy int
+
+const (
+	// ConflictFold keeps the type of the first metric reported under a name
+	// and folds the values of other types into it, the last help text wins.
+	// Conflicts are not reported.
+	ConflictFold ConflictPolicy = iota
+
+	// ConflictKeepFirst behaves like ConflictFold but keeps the first help
+	// text, and reports conflicts as warnings.
+	ConflictKeepFirst
+
+	// ConflictTakeLast replaces the metric with the last type and help text
+	// reported under its name, discarding the series of the previous type, and
+	// reports conflicts as warnings.
+	ConflictTakeLast
+
+	// ConflictReject keeps the first type and help text, rejects the values of
+	// other types, which are counted as dropped, and reports conflicts as
+	// errors.
+	ConflictReject
+)
+
+// String satisfies the fmt.Stringer interface.
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictFold:
+		return "fold"
+	case ConflictKeepFirst:
+		return "keep-first"
+	case ConflictTakeLast:
+		return "take-last"
+	case ConflictReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// ConflictError is the error reported by handlers when a metric conflicts with
+// a metric of the same name.
+type ConflictError struct {
+	// Policy is the policy that was applied to the conflict.
+	Policy ConflictPolicy
+
+	// Name is the name of the exposed metric.
+	Name string
+
+	// Field is the conflicting property of the metric, "type" or "help".
+	Field string
+
+	// Current is the value of the property when the conflict was detected.
+	Current string
+
+	// Conflict is the value of the property that conflicted with Current.
+	Conflict string
+}
+
+// Error satisfies the error interface.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("stats/prometheus: metric %s has %s %q but was reported with %s %q (%s)",
+		e.Name, e.Field, e.Current, e.Field, e.Conflict, e.Policy)
+}
+
+// conflict returns an error for c if it wasn't reported before, the write lock
+// of the store must be held.
+func (s *metricStore) conflict(c ConflictError) error {
+	if _, ok := s.conflicts[c]; ok {
+		return nil
+	}
+
+	if s.conflicts == nil {
+		s.conflicts = make(map[ConflictError]struct{})
+	}
+
+	s.conflicts[c] = struct{}{}
+	return &c
+}
@@ -0,0 +1,532 @@
+package prometheus
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// DefaultBuckets is the list of upper limits of the histogram buckets used
+// when none are configured for a metric, they are suited to report request
+// latencies in seconds.
+var DefaultBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// Handler is a metric handler that aggregates the metrics it receives and
+// exposes them to prometheus servers over HTTP.
+//
+// The zero-value is a valid handler which uses DefaultBuckets for all
+// histograms.
+type Handler struct {
+	// Both fields are first for alignment of atomic operations.
+	lastScrape int64 // unix nanoseconds
+	metrics    metricStore
+
+	// Buckets maps metric names to the upper limits of the buckets of their
+	// histograms, the names are the names of the exposed metrics (namespace
+	// included, with dots converted to underscores). The limits must be
+	// sorted in increasing order, stats.ErrorBoundBuckets can be used to
+	// compute them from a range and a relative error.
+	Buckets map[string][]float64
+
+	// DownsampleSeries is the number of series of a histogram after which new
+	// series of the histogram are created with DownsampledBuckets, which
+	// bounds the cost of histograms with a high cardinality. Existing series
+	// keep their buckets. Downsampling is disabled when set to zero.
+	DownsampleSeries int
+
+	// DownsampledBuckets is the list of upper limits of the buckets of the
+	// downsampled series of histograms, downsampled series only expose their
+	// sum and count when it is empty.
+	DownsampledBuckets []float64
+
+	// Sort is the order in which metrics are exposed, defaults to SortByName.
+	Sort SortOrder
+
+	// ExposeLastScrape enables exposing the LastScrapeMetricName gauge, set
+	// to the time of the previous successful scrape of the handler.
+	ExposeLastScrape bool
+
+	// Rounding maps metric names to the rounding applied to the values of
+	// their counters and gauges when they are exposed, the names are the
+	// names of the exposed metrics. The stored values are not rounded, so
+	// counters don't accumulate rounding errors.
+	Rounding map[string]Rounding
+
+	// Conflicts is the policy applied when metrics of different types, or
+	// with different help texts, are reported under the same name, defaults
+	// to ConflictFold.
+	Conflicts ConflictPolicy
+
+	// MaxMetrics is the maximum number of distinct metric names held by the
+	// handler, metrics with new names are rejected once the limit is reached
+	// and counted by Rejected, which protects against bugs embedding ids or
+	// other unbounded values in metric names. The number of rejected metrics
+	// is exposed as the RejectedMetricsMetricName counter. The number of
+	// names is not limited when set to zero.
+	MaxMetrics int
+
+	// ExemplarTags is the list of names of the tags which make the exemplars
+	// of counters and histogram buckets instead of labels of their series,
+	// typically trace identifiers. Exemplars set on metrics, for example by
+	// stats.Histogram.ObserveWithExemplar, take precedence. The last exemplar
+	// of each counter and of each histogram bucket is exposed when scrapers
+	// negotiate the OpenMetrics format, it is not exposed in the classic text
+	// format.
+	ExemplarTags []string
+
+	// Timestamps enables exposing the time of the last update of each series
+	// as the timestamp of its samples. Prometheus uses the time of scrapes
+	// when it is disabled, which is usually preferable, timestamps are useful
+	// when the metrics are collected by intermediaries delaying the scrapes.
+	Timestamps bool
+
+	// DisableCompression disables compressing the expositions with gzip when
+	// the scrapers accept it.
+	DisableCompression bool
+
+	// OnConflict is called with a *ConflictError the first time each conflict
+	// is detected, conflicts are logged when it is nil. It is not called with
+	// the ConflictFold policy.
+	OnConflict func(error)
+
+	snapshots snapshotStore
+}
+
+// Rounding configures the rounding of the values of a metric, it is used to
+// avoid exposing long decimal tails for values like amounts of currency.
+type Rounding struct {
+	// Places is the number of decimal places that values are rounded to, a
+	// negative number rounds to tens, hundreds, etc... It is ignored when
+	// Unit is set.
+	Places int
+
+	// Unit rounds values to the nearest multiple of it, 1024 rounds values to
+	// the nearest kibibyte for example.
+	Unit float64
+}
+
+// Round returns value rounded according to r.
+func (r Rounding) Round(value float64) float64 {
+	if r.Unit > 0 {
+		return math.Round(value/r.Unit) * r.Unit
+	}
+	scale := math.Pow10(r.Places)
+	return math.Round(value*scale) / scale
+}
+
+// LastScrapeMetricName is the name of the gauge exposed by handlers configured
+// with ExposeLastScrape.
+const LastScrapeMetricName = "stats_prometheus_last_scrape_timestamp_seconds"
+
+// RejectedMetricsMetricName is the name of the counter exposed by handlers
+// configured with MaxMetrics, counting the metrics rejected because the limit
+// was reached.
+const RejectedMetricsMetricName = "stats_prometheus_rejected_metrics_total"
+
+// SortOrder is an enumeration of the orders in which handlers can expose
+// metrics. Series of the same metric are always exposed together, as required
+// by the exposition format, the order only affects readability.
+type SortOrder int
+
+const (
+	// SortByName sorts metrics by name, then series by labels.
+	SortByName SortOrder = iota
+
+	// SortByTypeAndName groups metrics by type, then sorts them like
+	// SortByName.
+	SortByTypeAndName
+
+	// SortByInsertion exposes metrics in the order they were first received
+	// by the handler, and series in the order they were first updated.
+	SortByInsertion
+)
+
+// HandleMetric satisfies the stats.Handler interface.
+func (h *Handler) HandleMetric(m *stats.Metric) {
+	if err := h.metrics.update(m, h.layout, h.Conflicts, h.MaxMetrics, h.ExemplarTags); err != nil {
+		h.conflict(err)
+	}
+}
+
+// HandleMetrics satisfies the stats.BatchHandler interface, the metrics are
+// applied atomically with regards to scrapes of the handler.
+func (h *Handler) HandleMetrics(metrics []*stats.Metric) {
+	for _, err := range h.metrics.updateBatch(metrics, h.layout, h.Conflicts, h.MaxMetrics, h.ExemplarTags) {
+		h.conflict(err)
+	}
+}
+
+// DescribeMetric satisfies the stats.Describer interface, the help text of the
+// metric is exposed in HELP comments, the quantiles of the objectives of
+// summaries are exposed with the quantile label, and the maximum number of
+// buckets of exponential histograms bounds the buckets of their series. The
+// unit is exposed in UNIT comments of the OpenMetrics format when the metric
+// name ends with it, as required by the specification.
+//
+// The buckets of histograms configured on engines are applied with SetBuckets,
+// they replace the buckets configured on the handler for the same metric.
+func (h *Handler) DescribeMetric(schema stats.MetricSchema) {
+	name := metricName(&stats.Metric{Namespace: schema.Namespace, Name: schema.Name})
+
+	if err := h.metrics.describe(name, schema.Help, schema.Unit, schema.Objectives, schema.MaxBuckets, h.Conflicts); err != nil {
+		h.conflict(err)
+	}
+
+	if schema.Type == stats.HistogramType && len(schema.Buckets) != 0 {
+		h.SetBuckets(name, schema.Buckets)
+	}
+}
+
+func (h *Handler) conflict(err error) {
+	if h.OnConflict != nil {
+		h.OnConflict(err)
+	} else {
+		log.Print(err)
+	}
+}
+
+// Reset satisfies the stats.Resetter interface, it discards the state of all
+// metrics exposed by the handler.
+func (h *Handler) Reset() {
+	h.metrics.reset()
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// series updates rejected because their labels didn't match the labels of the
+// metric, or because the metric had a new name past the MaxMetrics limit.
+func (h *Handler) Dropped() int64 {
+	return atomic.LoadInt64(&h.metrics.dropped)
+}
+
+// Rejected returns the number of metrics rejected because their name was new
+// and the handler already held MaxMetrics metric names.
+func (h *Handler) Rejected() int64 {
+	return atomic.LoadInt64(&h.metrics.rejected)
+}
+
+// ServeHTTP satisfies the http.Handler interface, it writes the current state
+// of the metrics in the prometheus text exposition format, or in the
+// OpenMetrics format if the client accepts it.
+//
+// Exponential histograms are exposed as native histograms in the protobuf
+// format, which prometheus servers request when native histograms are enabled.
+// The text formats cannot carry native histograms, they expose their non-empty
+// buckets as the buckets of classic histograms.
+//
+// The OpenMetrics exposition carries the _created samples of counters and
+// histograms, set to the time at which each series was first updated.
+//
+// The exposition is compressed with gzip if the client accepts it, unless
+// DisableCompression is set.
+func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		res.Header().Set("Allow", "GET, HEAD")
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	protobuf := acceptsProtobuf(req)
+	openMetrics := !protobuf && acceptsOpenMetrics(req)
+
+	switch {
+	case protobuf:
+		res.Header().Set("Content-Type", protobufContentType)
+	case openMetrics:
+		res.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	default:
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+
+	var w io.Writer = res
+	var z *gzip.Writer
+
+	res.Header().Add("Vary", "Accept-Encoding")
+
+	if !h.DisableCompression && acceptsGzip(req) {
+		res.Header().Set("Content-Encoding", "gzip")
+
+		if req.Method != "HEAD" {
+			z = gzipPool.Get().(*gzip.Writer)
+			z.Reset(res)
+			w = z
+		}
+	}
+
+	if req.Method == "HEAD" {
+		return
+	}
+
+	var err error
+
+	if protobuf {
+		err = h.writeProtobuf(w, h.collect(nil))
+	} else {
+		err = h.writeMetrics(w, h.collect(nil), openMetrics)
+	}
+
+	if z != nil {
+		if e := z.Close(); err == nil {
+			err = e
+		}
+		z.Reset(ioutil.Discard)
+		gzipPool.Put(z)
+	}
+
+	if err == nil {
+		atomic.StoreInt64(&h.lastScrape, now().UnixNano())
+	}
+}
+
+// ListenAndServe exposes the metrics of the handler on the /metrics path of an
+// HTTP server listening on addr, it returns when the server fails.
+func (h *Handler) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	return http.ListenAndServe(addr, mux)
+}
+
+// ListenAndServe creates a handler registered on the default engine and exposes
+// its metrics on the /metrics path of an HTTP server listening on addr, it
+// returns when the server fails. Programs which configure the handler, or
+// register it on another engine, should use the method of Handler instead.
+func ListenAndServe(addr string) error {
+	h := &Handler{}
+	stats.Register(h)
+	return h.ListenAndServe(addr)
+}
+
+// LastScrape returns the time at which the handler last served a complete
+// exposition, or the zero time if it was never scraped.
+//
+// Comparing this time with the current time is a way to detect that the
+// prometheus server stopped scraping a program, independently of the metrics
+// reported by the program.
+func (h *Handler) LastScrape() time.Time {
+	if t := atomic.LoadInt64(&h.lastScrape); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// WriteTo satisfies the io.WriterTo interface, it writes the current state of
+// the metrics to w in the prometheus text exposition format.
+//
+// The method is intended to push metrics to servers which accept this format,
+// like the import endpoints of VictoriaMetrics, it is not recorded as a scrape.
+func (h *Handler) WriteTo(w io.Writer) (int64, error) {
+	c := &countWriter{w: w}
+	err := h.writeMetrics(c, h.collect(nil), false)
+	return c.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeMetrics serializes metrics to w in chunks of up to chunkSize bytes, so
+// the full exposition is never buffered in memory regardless of how many
+// series the handler exposes.
+func (h *Handler) writeMetrics(w io.Writer, metrics []metric, openMetrics bool) (err error) {
+	buf := bufferPool.Get().(*buffer)
+	b := buf.b[:0]
+	name := ""
+
+	for _, m := range metrics {
+		if m.name != name {
+			b = appendHeader(b, m, openMetrics)
+			name = m.name
+		}
+
+		if m.mtype == counter || m.mtype == gauge {
+			if r, ok := h.Rounding[m.name]; ok {
+				m.value = r.Round(m.value)
+			}
+		}
+
+		b = appendMetric(b, m, openMetrics, h.Timestamps)
+
+		if len(b) >= chunkSize {
+			if _, err = w.Write(b); err != nil {
+				break
+			}
+			b = b[:0]
+		}
+	}
+
+	if openMetrics {
+		b = append(b, "# EOF\n"...)
+	}
+
+	if err == nil && len(b) != 0 {
+		_, err = w.Write(b)
+	}
+
+	buf.b = b
+	bufferPool.Put(buf)
+	return
+}
+
+func (h *Handler) collect(metrics []metric) []metric {
+	metrics = h.metrics.collect(metrics)
+	metrics = h.snapshots.merge(metrics)
+
+	if h.ExposeLastScrape {
+		if t := h.LastScrape(); !t.IsZero() {
+			metrics = append(metrics, metric{
+				mtype: gauge,
+				name:  LastScrapeMetricName,
+				help:  "Time of the previous successful scrape of the handler.",
+				value: unixSeconds(t),
+				order: math.MaxUint64,
+			})
+		}
+	}
+
+	if h.MaxMetrics > 0 {
+		metrics = append(metrics, metric{
+			mtype: counter,
+			name:  RejectedMetricsMetricName,
+			help:  "Number of metrics rejected because the limit of metric names was reached.",
+			value: float64(h.Rejected()),
+			order: math.MaxUint64,
+		})
+	}
+
+	switch h.Sort {
+	case SortByTypeAndName:
+		sort.Sort(byTypeAndName(metrics))
+	case SortByInsertion:
+		sort.Sort(byInsertion(metrics))
+	default:
+		sort.Sort(byNameAndLabels(metrics))
+	}
+
+	return metrics
+}
+
+func appendHeader(b []byte, m metric, openMetrics bool) []byte {
+	name := m.name
+
+	if openMetrics {
+		name = familyName(m)
+	}
+
+	if len(m.help) != 0 {
+		b = appendHelp(b, name, m.help, openMetrics)
+	}
+
+	b = appendType(b, name, m.mtype, openMetrics)
+
+	if openMetrics && len(m.unit) != 0 && strings.HasSuffix(name, "_"+m.unit) {
+		b = appendUnit(b, name, m.unit)
+	}
+
+	return b
+}
+
+// acceptsOpenMetrics returns whether the OpenMetrics format is negotiated for
+// req, which is the case when the client accepts it with a quality at least as
+// high as the classic text format.
+func acceptsOpenMetrics(req *http.Request) bool {
+	om := quality(req.Header["Accept"], "application/openmetrics-text", "")
+	text := quality(req.Header["Accept"], "text/plain", "")
+	return om > 0 && om >= text
+}
+
+// acceptsProtobuf returns whether the protobuf format is negotiated for req,
+// which is the case when the client accepts it with a quality at least as high
+// as the text formats.
+func acceptsProtobuf(req *http.Request) bool {
+	pb := quality(req.Header["Accept"], "application/vnd.google.protobuf", "")
+	om := quality(req.Header["Accept"], "application/openmetrics-text", "")
+	text := quality(req.Header["Accept"], "text/plain", "")
+	return pb > 0 && pb >= om && pb >= text
+}
+
+func acceptsGzip(req *http.Request) bool {
+	return quality(req.Header["Accept-Encoding"], "gzip", "*") > 0
+}
+
+// quality returns the quality with which the values of an Accept or
+// Accept-Encoding header accept token, or the wildcard if token is not listed
+// explicitly. The quality is zero if neither are listed.
+func quality(values []string, token string, wildcard string) float64 {
+	q, found := 0.0, false
+
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			params := strings.Split(elem, ";")
+			name := strings.TrimSpace(params[0])
+
+			if name != token && (name != wildcard || found || wildcard == "") {
+				continue
+			}
+
+			v := 1.0
+
+			for _, p := range params[1:] {
+				if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+					if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+						v = f
+					}
+				}
+			}
+
+			if name == token {
+				q, found = v, true
+			} else {
+				q = v
+			}
+		}
+	}
+
+	return q
+}
+
+func (h *Handler) layout(name string) histogramLayout {
+	limits, ok := h.Buckets[name]
+	if !ok {
+		limits = DefaultBuckets
+	}
+
+	return histogramLayout{
+		limits:     limits,
+		downsample: h.DownsampleSeries,
+		coarse:     h.DownsampledBuckets,
+	}
+}
+
+// chunkSize is the size of the chunks written to the response by handlers.
+const chunkSize = 32 * 1024
+
+type buffer struct {
+	b []byte
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(ioutil.Discard) },
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return &buffer{make([]byte, 0, chunkSize+4096)} },
+}
@@ -0,0 +1,1064 @@
+package prometheus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestHandlerServeHTTP(t *testing.T) {
+	h := &Handler{
+		Buckets: map[string][]float64{
+			"test_latency_seconds": {0.1, 1},
+		},
+	}
+
+	e := stats.NewEngine("test", stats.Tag{"host", "A"})
+	e.Register(h)
+
+	e.Incr("requests.count", stats.Tag{"status", "200"})
+	e.Incr("requests.count", stats.Tag{"status", "200"})
+	e.Incr("requests.count", stats.Tag{"status", "500"})
+	e.Set("conns", 42)
+	e.Observe("latency.seconds", 0.05)
+	e.Observe("latency.seconds", 0.5)
+	e.Observe("latency.seconds", 5)
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ctype := res.Header.Get("Content-Type"); ctype != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Error("bad content type:", ctype)
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+
+	if s := string(b); s != `# TYPE test_conns gauge
+test_conns{host="A"} 42
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{host="A",le="0.1"} 1
+test_latency_seconds_bucket{host="A",le="1"} 2
+test_latency_seconds_bucket{host="A",le="+Inf"} 3
+test_latency_seconds_sum{host="A"} 5.55
+test_latency_seconds_count{host="A"} 3
+# TYPE test_requests_count counter
+test_requests_count{host="A",status="200"} 2
+test_requests_count{host="A",status="500"} 1
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerServeHTTPOpenMetrics(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{
+		Buckets: map[string][]float64{
+			"test_latency_seconds": {1},
+		},
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Incr("requests.total")
+	e.Observe("latency.seconds", 0.5)
+	e.Set("conns", 42)
+
+	clock = clock.Add(10 * time.Second) // _created must not change
+	e.Incr("requests.total")
+	e.Observe("latency.seconds", 5)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if ctype := res.Header().Get("Content-Type"); ctype != "application/openmetrics-text; version=1.0.0; charset=utf-8" {
+		t.Error("bad content type:", ctype)
+	}
+
+	if s := res.Body.String(); s != `# TYPE test_conns gauge
+test_conns 42
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="1"} 1
+test_latency_seconds_bucket{le="+Inf"} 2
+test_latency_seconds_sum 5.5
+test_latency_seconds_count 2
+test_latency_seconds_created 1.5e+09
+# TYPE test_requests counter
+test_requests_total 2
+test_requests_created 1.5e+09
+# EOF
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerSummary(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	s := e.Summary("latency", []stats.Objective{{Quantile: 0.5, Error: 0.01}, {Quantile: 0.9, Error: 0.01}})
+
+	for i := 1; i <= 10; i++ {
+		s.WithTags(stats.Tag{"op", "read"}).Observe(float64(i))
+	}
+
+	// Summaries declared without objectives use the default objectives.
+	e.Summary("size", nil).Observe(1)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# TYPE test_latency summary
+test_latency{op="read",quantile="0.5"} 6
+test_latency{op="read",quantile="0.9"} 10
+test_latency_sum{op="read"} 55
+test_latency_count{op="read"} 10
+# TYPE test_size summary
+test_size{quantile="0.5"} 1
+test_size{quantile="0.9"} 1
+test_size{quantile="0.99"} 1
+test_size_sum 1
+test_size_count 1
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	// Summaries are preserved by snapshots.
+	b := &bytes.Buffer{}
+	if err := h.WriteSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Handler{}
+	if err := m.MergeSnapshot("worker", b); err != nil {
+		t.Fatal(err)
+	}
+
+	res = httptest.NewRecorder()
+	m.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); !strings.Contains(s, `test_latency{op="read",quantile="0.9"} 10`) {
+		t.Error("bad exposition of the snapshot:\n" + s)
+	}
+}
+
+func TestHandlerLabelMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    [][]stats.Tag
+		want    string
+		dropped int64
+	}{
+		{
+			name:    "extra label",
+			tags:    [][]stats.Tag{{{"status", "200"}}, {{"status", "500"}, {"method", "GET"}}},
+			want:    "# TYPE test_requests counter\ntest_requests{status=\"200\"} 1\n",
+			dropped: 1,
+		},
+		{
+			name:    "missing label",
+			tags:    [][]stats.Tag{{{"status", "200"}}, nil},
+			want:    "# TYPE test_requests counter\ntest_requests{status=\"200\"} 1\n",
+			dropped: 1,
+		},
+		{
+			name:    "different label",
+			tags:    [][]stats.Tag{nil, {{"status", "200"}}},
+			want:    "# TYPE test_requests counter\ntest_requests 1\n",
+			dropped: 1,
+		},
+		{
+			name: "same labels in different order",
+			tags: [][]stats.Tag{{{"a", "1"}, {"b", "2"}}, {{"b", "3"}, {"a", "4"}}},
+			want: "# TYPE test_requests counter\ntest_requests{a=\"1\",b=\"2\"} 1\ntest_requests{a=\"4\",b=\"3\"} 1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Handler{}
+			e := stats.NewEngine("test")
+			e.Register(h)
+
+			for _, tags := range test.tags {
+				e.Incr("requests", tags...)
+			}
+
+			b := &bytes.Buffer{}
+			h.writeMetrics(b, h.collect(nil), false)
+
+			if s := b.String(); s != test.want {
+				t.Error("bad exposition:\n" + s)
+			}
+
+			if n := h.Dropped(); n != test.dropped {
+				t.Error("bad number of dropped metrics:", n)
+			}
+		})
+	}
+}
+
+func TestHandlerLastScrape(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{ExposeLastScrape: true}
+	e := stats.NewEngine("test")
+	e.Register(h)
+	e.Set("conns", 42)
+
+	if !h.LastScrape().IsZero() {
+		t.Error("the handler reported a scrape before being scraped")
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/metrics", nil))
+
+	if !h.LastScrape().IsZero() {
+		t.Error("HEAD requests must not be recorded as scrapes")
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != "# TYPE test_conns gauge\ntest_conns 42\n" {
+		t.Error("bad exposition of the first scrape:\n" + s)
+	}
+
+	if last := h.LastScrape(); !last.Equal(clock) {
+		t.Error("bad last scrape time:", last)
+	}
+
+	clock = clock.Add(15 * time.Second)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# HELP stats_prometheus_last_scrape_timestamp_seconds Time of the previous successful scrape of the handler.
+# TYPE stats_prometheus_last_scrape_timestamp_seconds gauge
+stats_prometheus_last_scrape_timestamp_seconds 1.5e+09
+# TYPE test_conns gauge
+test_conns 42
+` {
+		t.Error("bad exposition of the second scrape:\n" + s)
+	}
+
+	if last := h.LastScrape(); !last.Equal(clock) {
+		t.Error("bad last scrape time:", last)
+	}
+}
+
+func TestHandlerMaxMetrics(t *testing.T) {
+	h := &Handler{MaxMetrics: 2}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Set("conns", 1)
+	e.Incr("requests")
+	e.Incr("user_42_requests")
+	e.Incr("user_43_requests")
+
+	// Metrics with existing names are still accepted past the limit.
+	e.Set("conns", 2)
+	e.Incr("requests")
+
+	if n := h.Rejected(); n != 2 {
+		t.Error("bad number of rejected metrics:", n)
+	}
+
+	if n := h.Dropped(); n != 2 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# HELP stats_prometheus_rejected_metrics_total Number of metrics rejected because the limit of metric names was reached.
+# TYPE stats_prometheus_rejected_metrics_total counter
+stats_prometheus_rejected_metrics_total 2
+# TYPE test_conns gauge
+test_conns 2
+# TYPE test_requests counter
+test_requests 2
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	// Resetting the handler frees the names.
+	h.Reset()
+	e.Incr("user_42_requests")
+
+	if n := h.Rejected(); n != 2 {
+		t.Error("bad number of rejected metrics after reset:", n)
+	}
+}
+
+func TestHandlerSampleRate(t *testing.T) {
+	h := &Handler{
+		Buckets: map[string][]float64{
+			"test_latency": {1},
+		},
+	}
+
+	h.HandleMetric(&stats.Metric{Type: stats.HistogramType, Namespace: "test", Name: "latency", Value: 0.5})
+	h.HandleMetric(&stats.Metric{Type: stats.HistogramType, Namespace: "test", Name: "latency", Value: 2, Rate: 0.25})
+	h.HandleMetric(&stats.Metric{Type: stats.CounterType, Namespace: "test", Name: "requests", Value: 1, Rate: 0.5})
+
+	b := &bytes.Buffer{}
+	h.writeMetrics(b, h.collect(nil), false)
+
+	if s := b.String(); s != `# TYPE test_latency histogram
+test_latency_bucket{le="1"} 1
+test_latency_bucket{le="+Inf"} 5
+test_latency_sum 8.5
+test_latency_count 5
+# TYPE test_requests counter
+test_requests 2
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerDescribeMetric(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	h := &Handler{Buckets: map[string][]float64{"test_latency_seconds": {1}}}
+	e := stats.NewEngine("test")
+	e.Describe(stats.GaugeType, "conns", "Number of open connections.", "connections")
+	e.Register(h)
+	e.Describe(stats.HistogramType, "latency.seconds", "Time to serve requests.", "seconds")
+
+	e.Set("conns", 42)
+	e.Observe("latency.seconds", 0.5)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	// The unit of conns is not exposed because the name doesn't end with it.
+	if s := res.Body.String(); s != `# HELP test_conns Number of open connections.
+# TYPE test_conns gauge
+test_conns 42
+# HELP test_latency_seconds Time to serve requests.
+# TYPE test_latency_seconds histogram
+# UNIT test_latency_seconds seconds
+test_latency_seconds_bucket{le="1"} 1
+test_latency_seconds_bucket{le="+Inf"} 1
+test_latency_seconds_sum 0.5
+test_latency_seconds_count 1
+test_latency_seconds_created 1.5e+09
+# EOF
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	h.Reset()
+	e.Set("conns", 1)
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# HELP test_conns Number of open connections.
+# TYPE test_conns gauge
+test_conns 1
+` {
+		t.Error("bad exposition after reset:\n" + s)
+	}
+}
+
+func TestHandlerRounding(t *testing.T) {
+	h := &Handler{
+		Rounding: map[string]Rounding{
+			"test_revenue_dollars": {Places: 2},
+			"test_memory_bytes":    {Unit: 1024},
+			"test_latency":         {Places: 0},
+			"test_duration":        {Places: 0},
+		},
+		Buckets: map[string][]float64{"test_latency": {1}},
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	for i := 0; i != 3; i++ {
+		e.Add("revenue.dollars", 0.1)
+	}
+	e.Set("memory.bytes", 1500)
+	e.Observe("latency", 0.25)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Histograms are not rounded.
+	if s := res.Body.String(); s != `# TYPE test_latency histogram
+test_latency_bucket{le="1"} 1
+test_latency_bucket{le="+Inf"} 1
+test_latency_sum 0.25
+test_latency_count 1
+# TYPE test_memory_bytes gauge
+test_memory_bytes 1024
+# TYPE test_revenue_dollars counter
+test_revenue_dollars 0.3
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	// The stored value must not be rounded.
+	e.Add("revenue.dollars", 0.004)
+	e.Add("revenue.dollars", 0.004)
+
+	// Summaries are not rounded either.
+	e.Summary("duration", nil).Observe(0.25)
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); !strings.HasSuffix(s, "test_revenue_dollars 0.31\n") {
+		t.Error("bad exposition after accumulating values below the precision:\n" + s)
+	} else if !strings.Contains(s, "test_duration_sum 0.25\n") || !strings.Contains(s, "test_duration{quantile=\"0.5\"} 0.25\n") {
+		t.Error("bad exposition of summaries:\n" + s)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		rounding Rounding
+		value    float64
+		rounded  float64
+	}{
+		{Rounding{Places: 2}, 1.23456, 1.23},
+		{Rounding{Places: 0}, 2.5, 3},
+		{Rounding{Places: -2}, 1234, 1200},
+		{Rounding{Unit: 1024}, 3000, 3072},
+		{Rounding{Unit: 0.5, Places: 3}, 1.3, 1.5},
+	}
+
+	for _, test := range tests {
+		if rounded := test.rounding.Round(test.value); rounded != test.rounded {
+			t.Errorf("%+v: %g rounded to %g instead of %g", test.rounding, test.value, rounded, test.rounded)
+		}
+	}
+}
+
+func TestHandlerDownsampling(t *testing.T) {
+	h := &Handler{
+		Buckets:            map[string][]float64{"test_latency": {0.1, 1}},
+		DownsampleSeries:   2,
+		DownsampledBuckets: []float64{1},
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Observe("latency", 0.05, stats.Tag{"path", "/a"})
+	e.Observe("latency", 0.05, stats.Tag{"path", "/b"})
+	e.Observe("latency", 0.05, stats.Tag{"path", "/c"})
+	e.Observe("latency", 0.5, stats.Tag{"path", "/a"})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# TYPE test_latency histogram
+test_latency_bucket{path="/a",le="0.1"} 1
+test_latency_bucket{path="/a",le="1"} 2
+test_latency_bucket{path="/a",le="+Inf"} 2
+test_latency_sum{path="/a"} 0.55
+test_latency_count{path="/a"} 2
+test_latency_bucket{path="/b",le="0.1"} 1
+test_latency_bucket{path="/b",le="1"} 1
+test_latency_bucket{path="/b",le="+Inf"} 1
+test_latency_sum{path="/b"} 0.05
+test_latency_count{path="/b"} 1
+test_latency_bucket{path="/c",le="1"} 1
+test_latency_bucket{path="/c",le="+Inf"} 1
+test_latency_sum{path="/c"} 0.05
+test_latency_count{path="/c"} 1
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerDownsamplingSumAndCount(t *testing.T) {
+	h := &Handler{
+		Buckets:          map[string][]float64{"test_latency": {1}},
+		DownsampleSeries: 1,
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Observe("latency", 0.5, stats.Tag{"path", "/a"})
+	e.Observe("latency", 0.5, stats.Tag{"path", "/b"})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# TYPE test_latency histogram
+test_latency_bucket{path="/a",le="1"} 1
+test_latency_bucket{path="/a",le="+Inf"} 1
+test_latency_sum{path="/a"} 0.5
+test_latency_count{path="/a"} 1
+test_latency_bucket{path="/b",le="+Inf"} 1
+test_latency_sum{path="/b"} 0.5
+test_latency_count{path="/b"} 1
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerConflicts(t *testing.T) {
+	tests := []struct {
+		policy  ConflictPolicy
+		mtype   metricType
+		value   float64
+		help    string
+		errors  int
+		dropped int64
+	}{
+		{policy: ConflictFold, mtype: counter, value: 12, help: "B"},
+		{policy: ConflictKeepFirst, mtype: counter, value: 12, help: "A", errors: 2},
+		{policy: ConflictTakeLast, mtype: gauge, value: 5, help: "B", errors: 2},
+		{policy: ConflictReject, mtype: counter, value: 2, help: "A", errors: 2, dropped: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			var errs []error
+
+			h := &Handler{
+				Conflicts:  test.policy,
+				OnConflict: func(err error) { errs = append(errs, err) },
+			}
+
+			e := stats.NewEngine("test")
+			e.Register(h)
+			e.Describe(stats.CounterType, "calls", "A", "")
+			e.Describe(stats.CounterType, "calls", "B", "")
+
+			e.Incr("calls")
+			e.Incr("calls")
+			e.Set("calls", 5)
+			e.Set("calls", 5)
+
+			metrics := h.collect(nil)
+
+			if len(metrics) != 1 {
+				t.Fatal("bad metrics:", metrics)
+			}
+
+			if m := metrics[0]; m.mtype != test.mtype || m.value != test.value || m.help != test.help {
+				t.Errorf("bad metric: %s %s %g (%q)", m.name, m.mtype, m.value, m.help)
+			}
+
+			if len(errs) != test.errors {
+				t.Error("bad conflicts:", errs)
+			}
+
+			for _, err := range errs {
+				if c, ok := err.(*ConflictError); !ok || c.Name != "test_calls" || c.Policy != test.policy {
+					t.Error("bad conflict error:", err)
+				}
+			}
+
+			if n := h.Dropped(); n != test.dropped {
+				t.Error("bad number of dropped metrics:", n)
+			}
+		})
+	}
+}
+
+func TestHandlerTimestamps(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{Timestamps: true}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Incr("requests.total")
+	clock = clock.Add(1500 * time.Millisecond)
+	e.Set("conns", 42)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# TYPE test_conns gauge
+test_conns 42 1500000001500
+# TYPE test_requests_total counter
+test_requests_total 1 1500000000000
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if s := res.Body.String(); !strings.Contains(s, "test_conns 42 1.5000000015e+09\n") {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerGzip(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+	e.Set("conns", 42)
+
+	tests := []struct {
+		scenario string
+		handler  *Handler
+		encoding string
+		gzip     bool
+	}{
+		{scenario: "gzip", handler: h, encoding: "gzip, deflate", gzip: true},
+		{scenario: "any encoding", handler: h, encoding: "*", gzip: true},
+		{scenario: "gzip refused", handler: h, encoding: "gzip;q=0, identity"},
+		{scenario: "no encoding", handler: h},
+		{scenario: "compression disabled", handler: &Handler{DisableCompression: true}, encoding: "gzip"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if test.encoding != "" {
+				req.Header.Set("Accept-Encoding", test.encoding)
+			}
+			res := httptest.NewRecorder()
+			test.handler.ServeHTTP(res, req)
+
+			if enc := res.Header().Get("Content-Encoding"); (enc == "gzip") != test.gzip {
+				t.Fatal("bad content encoding:", enc)
+			}
+
+			body := res.Body.Bytes()
+
+			if test.gzip {
+				z, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = ioutil.ReadAll(z); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.handler == h && string(body) != "# TYPE test_conns gauge\ntest_conns 42\n" {
+				t.Errorf("bad exposition: %q", body)
+			}
+		})
+	}
+}
+
+func TestHandlerExemplars(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{
+		Buckets:      map[string][]float64{"test_latency_seconds": {1}},
+		ExemplarTags: []string{"trace_id"},
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Incr("requests.total", stats.Tag{"trace_id", "a"})
+	e.Observe("latency.seconds", 0.5, stats.Tag{"trace_id", "b"})
+	clock = clock.Add(time.Second)
+	e.Incr("requests.total")
+	e.Observe("latency.seconds", 5, stats.Tag{"trace_id", "c"})
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if s := res.Body.String(); s != `# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="1"} 1 # {trace_id="b"} 0.5 1.5e+09
+test_latency_seconds_bucket{le="+Inf"} 2 # {trace_id="c"} 5 1.500000001e+09
+test_latency_seconds_sum 5.5
+test_latency_seconds_count 2
+test_latency_seconds_created 1.5e+09
+# TYPE test_requests counter
+test_requests_total 2 # {trace_id="a"} 1 1.5e+09
+test_requests_created 1.5e+09
+# EOF
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	// Exemplars are not exposed in the classic text format, and the tags do
+	// not become labels.
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); strings.Contains(s, "trace_id") {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerObserveWithExemplar(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	h := &Handler{
+		Buckets:      map[string][]float64{"test_latency_seconds": {1}},
+		ExemplarTags: []string{"trace_id"},
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	latency := e.Histogram("latency.seconds")
+	latency.ObserveWithExemplar(0.5, stats.Tag{"span_id", "x"}, stats.Tag{"trace_id", "a"})
+	latency.Observe(0.2)
+	e.Observe("latency.seconds", 2, stats.Tag{"trace_id", "b"})
+	e.ObserveWithExemplar("latency.seconds", 3, []stats.Tag{{"trace_id", "c"}}, stats.Tag{"trace_id", "d"})
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if s := res.Body.String(); !strings.Contains(s, `test_latency_seconds_bucket{le="1"} 2 # {span_id="x",trace_id="a"} 0.5 1.5e+09
+test_latency_seconds_bucket{le="+Inf"} 4 # {trace_id="c"} 3 1.5e+09
+`) {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerEngineBuckets(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngineWith(stats.EngineConfig{
+		Name:    "test",
+		Buckets: map[string][]float64{"*.latency": {0.1, 1}},
+	})
+	e.Register(h)
+
+	e.Observe("db.latency", 0.5)
+	e.Observe("size", 0.5)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); !strings.Contains(s, `test_db_latency_bucket{le="0.1"} 0
+test_db_latency_bucket{le="1"} 1
+test_db_latency_bucket{le="+Inf"} 1
+`) || !strings.Contains(s, `test_size_bucket{le="0.005"} 0`) {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	// The buckets changed on the engine apply to the handler, the existing
+	// series are re-bucketed.
+	e.SetBuckets("*.latency", []float64{1})
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); !strings.Contains(s, `# TYPE test_db_latency histogram
+test_db_latency_bucket{le="1"} 1
+test_db_latency_bucket{le="+Inf"} 1
+`) {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	tests := []struct {
+		accept      string
+		encoding    string
+		openMetrics bool
+		protobuf    bool
+		gzip        bool
+	}{
+		{},
+		{accept: "text/plain", encoding: "identity"},
+		{accept: "application/openmetrics-text; version=1.0.0,text/plain;q=0.5", encoding: "gzip", openMetrics: true, gzip: true},
+		{accept: "application/openmetrics-text;q=0.5,text/plain", encoding: "deflate, *;q=0.1", gzip: true},
+		{accept: "application/openmetrics-text;q=0,*/*", encoding: "*, gzip;q=0"},
+		{accept: "*/*", encoding: "*", gzip: true},
+		{accept: "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,application/openmetrics-text;q=0.5", openMetrics: true, protobuf: true},
+		{accept: "application/vnd.google.protobuf;q=0.5,application/openmetrics-text;q=0.6", openMetrics: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept+"/"+test.encoding, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set("Accept-Encoding", test.encoding)
+
+			if om := acceptsOpenMetrics(req); om != test.openMetrics {
+				t.Error("bad format negotiation:", om)
+			}
+
+			if pb := acceptsProtobuf(req); pb != test.protobuf {
+				t.Error("bad protobuf negotiation:", pb)
+			}
+
+			if gz := acceptsGzip(req); gz != test.gzip {
+				t.Error("bad encoding negotiation:", gz)
+			}
+		})
+	}
+}
+
+func TestHandlerMethodNotAllowed(t *testing.T) {
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/metrics", nil)
+	(&Handler{}).ServeHTTP(res, req)
+
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Error("bad status:", res.Code)
+	}
+}
+
+func TestHandlerReset(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	e.Incr("calls")
+	e.Reset()
+
+	if metrics := h.collect(nil); len(metrics) != 0 {
+		t.Error("the handler was not reset:", metrics)
+	}
+
+	e.Incr("calls")
+
+	if metrics := h.collect(nil); len(metrics) != 1 || metrics[0].value != 1 {
+		t.Error("bad metrics after reset:", metrics)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"requests", "requests"},
+		{"http.requests-count", "http_requests_count"},
+		{"0abc", "_abc"},
+		{"a:b_c9", "a:b_c9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			if s := sanitizeName(test.in); s != test.out {
+				t.Error(s)
+			}
+		})
+	}
+}
+
+func TestAppendEscaped(t *testing.T) {
+	if s := string(appendLabel(nil, label{"a", "x\\y\"z\n"})); s != `a="x\\y\"z\n"` {
+		t.Error(s)
+	}
+}
+
+func TestHandlerBatchAtomicity(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := e.Batch()
+		for i := 0; i != 1000; i++ {
+			b.Incr("A")
+			b.Incr("B")
+			b.Commit()
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		values := map[string]float64{}
+		for _, m := range h.collect(nil) {
+			values[m.name] = m.value
+		}
+
+		if values["test_A"] != values["test_B"] {
+			t.Fatal("a scrape observed a partial batch:", values)
+		}
+	}
+}
+
+func TestHandlerSort(t *testing.T) {
+	tests := []struct {
+		name  string
+		order SortOrder
+		want  []string
+	}{
+		{
+			name:  "name",
+			order: SortByName,
+			want:  []string{`test_alpha 3`, `test_beta{x="1"} 2`, `test_beta{x="2"} 1`, `test_mid 1`, `test_zeta 1`},
+		},
+		{
+			name:  "type and name",
+			order: SortByTypeAndName,
+			want:  []string{`test_mid 1`, `test_zeta 1`, `test_alpha 3`, `test_beta{x="1"} 2`, `test_beta{x="2"} 1`},
+		},
+		{
+			name:  "insertion",
+			order: SortByInsertion,
+			want:  []string{`test_beta{x="2"} 1`, `test_beta{x="1"} 2`, `test_zeta 1`, `test_mid 1`, `test_alpha 3`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Handler{Sort: test.order}
+			e := stats.NewEngine("test")
+			e.Register(h)
+
+			e.Set("beta", 1, stats.Tag{"x", "2"})
+			e.Incr("zeta")
+			e.Set("beta", 2, stats.Tag{"x", "1"})
+			e.Incr("mid")
+			e.Set("alpha", 3)
+
+			b := &bytes.Buffer{}
+			h.writeMetrics(b, h.collect(nil), false)
+
+			var samples []string
+			for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					samples = append(samples, line)
+				}
+			}
+
+			if !reflect.DeepEqual(samples, test.want) {
+				t.Error("bad order:", samples)
+			}
+		})
+	}
+}
+
+type chunkWriter struct {
+	bytes.Buffer
+	chunks []int
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.chunks = append(w.chunks, len(b))
+	return w.Buffer.Write(b)
+}
+
+func TestHandlerWriteMetricsChunked(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	for i := 0; i != 10000; i++ {
+		e.Set("series", float64(i), stats.Tag{"id", fmt.Sprint(i)})
+	}
+
+	w := &chunkWriter{}
+	metrics := h.collect(nil)
+
+	if err := h.writeMetrics(w, metrics, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.chunks) < 2 {
+		t.Error("the exposition was not written in chunks:", w.chunks)
+	}
+
+	for _, n := range w.chunks {
+		if n > chunkSize+4096 {
+			t.Error("chunk too large:", n)
+		}
+	}
+
+	if lines := bytes.Count(w.Bytes(), []byte("\n")); lines != 10001 {
+		t.Error("bad number of lines:", lines)
+	}
+}
+
+func TestHandlerExpires(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+	e.SetUntil("reserved", 4, clock.Add(time.Minute), stats.Tag{"pool", "a"})
+	e.SetUntil("reserved", 2, clock.Add(time.Hour), stats.Tag{"pool", "b"})
+	e.Set("conns", 1)
+
+	scrape := func() string {
+		b := &bytes.Buffer{}
+		h.WriteTo(b)
+		return b.String()
+	}
+
+	if s := scrape(); s != `# TYPE test_conns gauge
+test_conns 1
+# TYPE test_reserved gauge
+test_reserved{pool="a"} 4
+test_reserved{pool="b"} 2
+` {
+		t.Error("bad exposition before expiration:\n" + s)
+	}
+
+	// The expiration is declared by the metric, updating the series doesn't
+	// postpone it unless the update carries a later expiration.
+	clock = clock.Add(30 * time.Second)
+	e.SetUntil("reserved", 3, clock.Add(30*time.Second), stats.Tag{"pool", "a"})
+	clock = clock.Add(30 * time.Second)
+
+	if s := scrape(); s != `# TYPE test_conns gauge
+test_conns 1
+# TYPE test_reserved gauge
+test_reserved{pool="b"} 2
+` {
+		t.Error("bad exposition after expiration:\n" + s)
+	}
+
+	e.SetUntil("reserved", 5, clock.Add(time.Minute), stats.Tag{"pool", "a"})
+
+	if s := scrape(); s != `# TYPE test_conns gauge
+test_conns 1
+# TYPE test_reserved gauge
+test_reserved{pool="a"} 5
+test_reserved{pool="b"} 2
+` {
+		t.Error("bad exposition after the expired series was set again:\n" + s)
+	}
+}
@@ -0,0 +1,159 @@
+package prometheus
+
+import (
+	"sort"
+
+	"github.com/segmentio/stats"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type labels []label
+
+// metricLabels returns the labels and the exemplar of m, the exemplar set on m
+// takes precedence over the one made of the tags named in exemplarTags.
+func metricLabels(m *stats.Metric, exemplarTags []string) (labels, labels) {
+	l, exemplar := splitLabels(m.Tags, exemplarTags)
+
+	if len(m.Exemplar) != 0 {
+		exemplar = makeLabels(m.Exemplar)
+	}
+
+	return l, exemplar
+}
+
+// splitLabels returns the labels made of tags, and the labels made of the tags
+// named in exemplarTags which are excluded from the former.
+func splitLabels(tags []stats.Tag, exemplarTags []string) (labels, labels) {
+	if len(exemplarTags) == 0 {
+		return makeLabels(tags), nil
+	}
+
+	var exemplar labels
+	l := make(labels, 0, len(tags))
+
+	for _, t := range tags {
+		x := label{name: sanitizeName(t.Name), value: t.Value}
+
+		if isExemplarTag(t.Name, exemplarTags) {
+			exemplar = append(exemplar, x)
+		} else {
+			l = append(l, x)
+		}
+	}
+
+	if len(l) == 0 {
+		l = nil
+	}
+
+	sort.Stable(l)
+	sort.Stable(exemplar)
+	return l, exemplar
+}
+
+func isExemplarTag(name string, exemplarTags []string) bool {
+	for _, t := range exemplarTags {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func makeLabels(tags []stats.Tag) labels {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	l := make(labels, 0, len(tags))
+
+	for _, t := range tags {
+		l = append(l, label{
+			name:  sanitizeName(t.Name),
+			value: t.Value,
+		})
+	}
+
+	sort.Stable(l)
+	return l
+}
+
+func (l labels) Len() int               { return len(l) }
+func (l labels) Less(i int, j int) bool { return l[i].name < l[j].name }
+func (l labels) Swap(i int, j int)      { l[i], l[j] = l[j], l[i] }
+
+func (l labels) key() string {
+	return string(appendLabels(nil, l))
+}
+
+func (l labels) names() []string {
+	names := make([]string, len(l))
+	for i, x := range l {
+		names[i] = x.name
+	}
+	return names
+}
+
+func (l labels) hasNames(names []string) bool {
+	if len(l) != len(names) {
+		return false
+	}
+	for i := range l {
+		if l[i].name != names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (l labels) equal(other labels) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for i := range l {
+		if l[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (l labels) less(other labels) bool {
+	for i := 0; i != len(l) && i != len(other); i++ {
+		if l[i].name != other[i].name {
+			return l[i].name < other[i].name
+		}
+		if l[i].value != other[i].value {
+			return l[i].value < other[i].value
+		}
+	}
+	return len(l) < len(other)
+}
+
+// sanitizeName converts s to a valid prometheus metric or label name, dots
+// used as separators by the stats package and any other invalid characters
+// are replaced with underscores.
+func sanitizeName(s string) string {
+	for i := 0; i != len(s); i++ {
+		if !isNameChar(s[i], i) {
+			b := []byte(s)
+			for j := i; j != len(b); j++ {
+				if !isNameChar(b[j], j) {
+					b[j] = '_'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+func isNameChar(c byte, i int) bool {
+	return (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c == '_' || c == ':') ||
+		(c >= '0' && c <= '9' && i != 0)
+}
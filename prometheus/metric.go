@@ -0,0 +1,693 @@
+package prometheus
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type metricType int
+
+const (
+	untyped metricType = iota
+	counter
+	gauge
+	histogram
+	summary
+	nativeHistogram
+)
+
+func (t metricType) String() string {
+	switch t {
+	case counter:
+		return "counter"
+	case gauge:
+		return "gauge"
+	case histogram, nativeHistogram:
+		return "histogram"
+	case summary:
+		return "summary"
+	default:
+		return "untyped"
+	}
+}
+
+func metricTypeOf(t stats.MetricType) metricType {
+	switch t {
+	case stats.CounterType:
+		return counter
+	case stats.GaugeType:
+		return gauge
+	case stats.HistogramType:
+		return histogram
+	case stats.SummaryType:
+		return summary
+	case stats.ExponentialHistogramType:
+		return nativeHistogram
+	default:
+		return untyped
+	}
+}
+
+// metric is a snapshot of the state of a single series, it is produced when
+// collecting the content of a metric store.
+type metric struct {
+	mtype     metricType
+	name      string
+	help      string
+	unit      string
+	value     float64 // counter and gauge values, histogram and summary sum
+	count     uint64  // histogram and summary count
+	buckets   buckets
+	native    nativeBuckets // native histogram buckets
+	quantiles []quantile    // summary quantiles
+	exemplars []exemplar    // counter exemplar, or histogram exemplars by bucket
+	time      time.Time
+	created   time.Time
+	labels    labels
+	order     uint64 // insertion order of the metric in the store
+	series    uint64 // insertion order of the series in the metric
+}
+
+// quantile is the estimate of a quantile of a summary.
+type quantile struct {
+	q     float64
+	value float64
+}
+
+// exemplar is a sample of the events counted by a counter or a histogram bucket,
+// identified by labels which are too specific to be labels of the series, like
+// trace identifiers. Exemplars are only exposed in the OpenMetrics format.
+type exemplar struct {
+	labels labels
+	value  float64
+	time   time.Time
+}
+
+// byNameAndLabels sorts metrics by name first, then by labels, which groups
+// series of the same metric together as required by the exposition format.
+type byNameAndLabels []metric
+
+func (m byNameAndLabels) Len() int      { return len(m) }
+func (m byNameAndLabels) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byNameAndLabels) Less(i, j int) bool {
+	if m[i].name != m[j].name {
+		return m[i].name < m[j].name
+	}
+	return m[i].labels.less(m[j].labels)
+}
+
+// byTypeAndName sorts metrics by type first, then like byNameAndLabels.
+type byTypeAndName []metric
+
+func (m byTypeAndName) Len() int      { return len(m) }
+func (m byTypeAndName) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byTypeAndName) Less(i, j int) bool {
+	if m[i].mtype != m[j].mtype {
+		return m[i].mtype < m[j].mtype
+	}
+	return byNameAndLabels(m).Less(i, j)
+}
+
+// byInsertion sorts metrics in the order they were first seen by the store,
+// then series of each metric in the order they were first seen. Metrics which
+// have the same order, like metrics merged from snapshots, are sorted by name.
+type byInsertion []metric
+
+func (m byInsertion) Len() int      { return len(m) }
+func (m byInsertion) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byInsertion) Less(i, j int) bool {
+	if m[i].order != m[j].order {
+		return m[i].order < m[j].order
+	}
+	if m[i].name != m[j].name {
+		return m[i].name < m[j].name
+	}
+	return m[i].series < m[j].series
+}
+
+// buckets carries the upper limits of histogram buckets and the number of
+// values observed in each of them (not cumulative).
+type buckets struct {
+	limits []float64
+	counts []uint64
+}
+
+// histogramLayout describes the buckets of the series of a histogram.
+type histogramLayout struct {
+	limits     []float64
+	downsample int       // number of series after which coarse is used
+	coarse     []float64 // limits of downsampled series
+}
+
+// buckets returns the limits of the buckets of a new series of the histogram,
+// n is the number of series that the histogram already has.
+func (l histogramLayout) buckets(n int) []float64 {
+	if l.downsample > 0 && n >= l.downsample {
+		return l.coarse
+	}
+	return l.limits
+}
+
+func makeBuckets(limits []float64) buckets {
+	return buckets{
+		limits: limits,
+		counts: make([]uint64, len(limits)),
+	}
+}
+
+func (b buckets) observe(value float64, n uint64) {
+	if i := sort.SearchFloat64s(b.limits, value); i < len(b.limits) {
+		b.counts[i] += n
+	}
+}
+
+func (b buckets) copy() buckets {
+	return buckets{
+		limits: b.limits,
+		counts: append([]uint64(nil), b.counts...),
+	}
+}
+
+// kahanSum accumulates floating point values using the Kahan-Babuska
+// (Neumaier) compensated summation algorithm.
+//
+// Naively accumulating many small values into a large sum loses the low-order
+// bits of each addition, which over millions of observations makes the total
+// drift from the exact value. The compensation term retains the lost bits and
+// is added back when reading the sum, for the cost of a few extra floating
+// point operations per addition.
+type kahanSum struct {
+	sum float64
+	c   float64
+}
+
+func (k *kahanSum) add(x float64) {
+	t := k.sum + x
+
+	if abs(k.sum) >= abs(x) {
+		k.c += (k.sum - t) + x
+	} else {
+		k.c += (x - t) + k.sum
+	}
+
+	k.sum = t
+}
+
+func (k *kahanSum) value() float64 {
+	return k.sum + k.c
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// metricState is the state of a single series, identified by the name of its
+// metric and its labels.
+type metricState struct {
+	labels  labels
+	value   kahanSum // counter and gauge values, histogram and summary sum
+	count   uint64   // histogram and summary count
+	buckets buckets
+	sketch  *stats.QuantileSketch    // summary quantiles
+	native  *stats.ExponentialSketch // native histogram buckets
+	time    time.Time
+	created time.Time // time of the first update, exposed in OpenMetrics
+	expires time.Time // expiration declared by the last update, if any
+	order   uint64    // insertion order of the series in its metric
+
+	// Last exemplar of counters, or of each bucket of histograms (the last
+	// one is the +Inf bucket), allocated when the first exemplar is seen.
+	exemplars []exemplar
+}
+
+// update applies value to the state, n is the number of occurrences of the
+// value that the update stands for, see stats.Metric.SampleCount.
+func (s *metricState) update(mtype metricType, value float64, n uint64, time time.Time) {
+	switch mtype {
+	case counter:
+		s.value.add(value * float64(n))
+
+	case gauge:
+		s.value = kahanSum{sum: value}
+
+	case histogram:
+		s.value.add(value * float64(n))
+		s.count += n
+		s.buckets.observe(value, n)
+
+	case summary:
+		s.value.add(value * float64(n))
+		s.count += n
+
+		for i := uint64(0); i != n; i++ {
+			s.sketch.Insert(value)
+		}
+
+	case nativeHistogram:
+		s.value.add(value * float64(n))
+		s.count += n
+
+		for i := uint64(0); i != n; i++ {
+			s.native.Insert(value)
+		}
+	}
+
+	s.time = time
+}
+
+// exemplify records the exemplar of an update of the state with value, only
+// counters and histograms have exemplars.
+func (s *metricState) exemplify(mtype metricType, value float64, l labels, time time.Time) {
+	i := 0
+
+	switch mtype {
+	case counter:
+		if s.exemplars == nil {
+			s.exemplars = make([]exemplar, 1)
+		}
+	case histogram:
+		if s.exemplars == nil {
+			s.exemplars = make([]exemplar, len(s.buckets.limits)+1)
+		}
+		i = sort.SearchFloat64s(s.buckets.limits, value)
+	default:
+		return
+	}
+
+	s.exemplars[i] = exemplar{labels: l, value: value, time: time}
+}
+
+// quantiles returns the estimates of the quantiles of the objectives of the
+// sketch of the state, the method must be called with the mutex of the entry
+// held.
+func (s *metricState) quantiles() []quantile {
+	if s.sketch == nil {
+		return nil
+	}
+
+	objectives := s.sketch.Objectives()
+	quantiles := make([]quantile, len(objectives))
+
+	for i, o := range objectives {
+		quantiles[i] = quantile{q: o.Quantile, value: s.sketch.Query(o.Quantile)}
+	}
+
+	return quantiles
+}
+
+// metricEntry groups the states of all series of a metric.
+type metricEntry struct {
+	mutex      sync.Mutex
+	mtype      metricType
+	name       string
+	help       string
+	unit       string
+	layout     histogramLayout
+	objectives []stats.Objective // objectives of summaries
+	maxBuckets int               // maximum number of buckets of native histograms
+	labels     []string          // label names of the first series, shared by all series
+	states     map[string]*metricState
+	order      uint64 // insertion order of the metric in its store
+	series     uint64 // number of series ever inserted in the metric
+
+	// Label names of the series rejected because they didn't match the label
+	// names of the metric, used to log each mismatch once.
+	mismatches map[string]struct{}
+}
+
+// update applies m to the series with labels, recording the exemplar unless it
+// is empty. The method returns false if the series was rejected.
+func (e *metricEntry) update(m *stats.Metric, labels labels, exemplar labels, time time.Time) bool {
+	key := labels.key()
+
+	e.mutex.Lock()
+	state := e.states[key]
+
+	if state == nil {
+		// Prometheus requires all series of a metric to have the same set of
+		// labels, the first series establishes it and series with different
+		// label names are rejected.
+		if len(e.states) == 0 {
+			e.labels = labels.names()
+		} else if !labels.hasNames(e.labels) {
+			e.mismatch(labels)
+			e.mutex.Unlock()
+			return false
+		}
+
+		e.series++
+		state = &metricState{labels: labels, created: time, order: e.series}
+
+		switch e.mtype {
+		case histogram:
+			state.buckets = makeBuckets(e.layout.buckets(len(e.states)))
+		case summary:
+			state.sketch = stats.NewQuantileSketch(e.objectives...)
+		case nativeHistogram:
+			state.native = stats.NewExponentialSketch(e.maxBuckets)
+		}
+
+		e.states[key] = state
+	}
+
+	state.update(e.mtype, m.Value, m.SampleCount(), time)
+	state.expires = m.Expires
+
+	if len(exemplar) != 0 {
+		state.exemplify(e.mtype, m.Value, exemplar, time)
+	}
+
+	e.mutex.Unlock()
+	return true
+}
+
+// mismatch logs that a series with labels was rejected, the method must be
+// called with the entry's mutex held.
+func (e *metricEntry) mismatch(labels labels) {
+	names := strings.Join(labels.names(), ",")
+
+	if _, logged := e.mismatches[names]; logged {
+		return
+	}
+
+	if e.mismatches == nil {
+		e.mismatches = make(map[string]struct{})
+	}
+
+	e.mismatches[names] = struct{}{}
+	log.Printf("stats/prometheus: discarding series of %s with labels [%s] because the metric has labels [%s]", e.name, names, strings.Join(e.labels, ","))
+}
+
+// collect appends the series of the entry to metrics, series which expired at
+// the given time are removed instead.
+func (e *metricEntry) collect(metrics []metric, now time.Time) []metric {
+	e.mutex.Lock()
+
+	for key, s := range e.states {
+		if !s.expires.IsZero() && !now.Before(s.expires) {
+			delete(e.states, key)
+			continue
+		}
+
+		metrics = append(metrics, metric{
+			mtype:     e.mtype,
+			name:      e.name,
+			help:      e.help,
+			unit:      e.unit,
+			value:     s.value.value(),
+			count:     s.count,
+			buckets:   s.buckets.copy(),
+			native:    makeNativeBuckets(s.native),
+			quantiles: s.quantiles(),
+			exemplars: append([]exemplar(nil), s.exemplars...),
+			time:      s.time,
+			created:   s.created,
+			labels:    s.labels,
+			order:     e.order,
+			series:    s.order,
+		})
+	}
+
+	e.mutex.Unlock()
+	return metrics
+}
+
+// metricStore holds the state of all metrics received by a handler.
+type metricStore struct {
+	dropped  int64 // first for alignment of atomic operations
+	rejected int64 // first for alignment of atomic operations
+	mutex    sync.RWMutex
+	entries  map[string]*metricEntry
+	inserts  uint64 // number of metrics ever inserted in the store
+
+	// Whether the rejection of a new metric name was logged, rejections are
+	// only logged once since name explosions would flood the logs.
+	rejectLogged bool
+
+	// Help texts and units of metrics, retained when the store is reset.
+	descriptions map[string]description
+
+	// Conflicts already reported, used to report each conflict once.
+	conflicts map[ConflictError]struct{}
+}
+
+type description struct {
+	help       string
+	unit       string
+	objectives []stats.Objective
+	maxBuckets int
+}
+
+// update applies m to the store, the returned error is a conflict that must be
+// reported according to the policy, it is returned instead of being reported
+// by the store so it isn't reported while holding the lock. The tags of m
+// named in exemplarTags make the exemplar of the update instead of labels, see
+// metricLabels.
+func (s *metricStore) update(m *stats.Metric, layout func(string) histogramLayout, policy ConflictPolicy, maxNames int, exemplarTags []string) error {
+	mtype := metricTypeOf(m.Type)
+	name := metricName(m)
+	labels, exemplar := metricLabels(m, exemplarTags)
+	time := metricTime(m)
+
+	// Updates hold the read lock of the store while they apply so collections
+	// cannot observe a partial batch of updates, see updateBatch.
+	s.mutex.RLock()
+	entry := s.entries[name]
+
+	if entry != nil && (entry.mtype == mtype || policy == ConflictFold) {
+		s.count(entry.update(m, labels, exemplar, time))
+		s.mutex.RUnlock()
+		return nil
+	}
+
+	s.mutex.RUnlock()
+	s.mutex.Lock()
+	err := s.apply(m, labels, exemplar, time, layout, policy, maxNames)
+	s.mutex.Unlock()
+	return err
+}
+
+// updateBatch applies all metrics to the store while holding the write lock,
+// which guarantees that a concurrent collection observes either none or all
+// of them.
+func (s *metricStore) updateBatch(metrics []*stats.Metric, layout func(string) histogramLayout, policy ConflictPolicy, maxNames int, exemplarTags []string) (errs []error) {
+	s.mutex.Lock()
+
+	for _, m := range metrics {
+		labels, exemplar := metricLabels(m, exemplarTags)
+
+		if err := s.apply(m, labels, exemplar, metricTime(m), layout, policy, maxNames); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.mutex.Unlock()
+	return
+}
+
+// apply applies m to the store, the write lock must be held.
+func (s *metricStore) apply(m *stats.Metric, labels labels, exemplar labels, time time.Time, layout func(string) histogramLayout, policy ConflictPolicy, maxNames int) error {
+	entry, err := s.lookup(metricTypeOf(m.Type), metricName(m), layout, policy, maxNames)
+
+	if entry != nil {
+		s.count(entry.update(m, labels, exemplar, time))
+	} else {
+		s.count(false)
+	}
+
+	return err
+}
+
+// count records whether an update was applied, rejected updates are counted as
+// dropped metrics.
+func (s *metricStore) count(ok bool) {
+	if !ok {
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// lookup returns the entry for the metric with name, creating it if needed.
+//
+// When the metric exists with a different type the policy decides which entry
+// is returned, nil means that the update must be rejected. The error is set
+// the first time a conflict is seen. New names are rejected when the store
+// already holds maxNames metrics, unless maxNames is zero.
+// The method must be called with the write lock of the store held.
+func (s *metricStore) lookup(mtype metricType, name string, layout func(string) histogramLayout, policy ConflictPolicy, maxNames int) (*metricEntry, error) {
+	if s.entries == nil {
+		s.entries = make(map[string]*metricEntry)
+	}
+
+	entry := s.entries[name]
+
+	if entry == nil {
+		if maxNames > 0 && len(s.entries) >= maxNames {
+			s.reject(name, maxNames)
+			return nil, nil
+		}
+
+		s.inserts++
+		entry = s.newEntry(mtype, name, layout, s.inserts)
+		s.entries[name] = entry
+		return entry, nil
+	}
+
+	// Metrics of different types sharing the same name are not supported by
+	// prometheus, the policy decides whether values of other types are folded
+	// into the entry, rejected, or replace it.
+	if entry.mtype == mtype || policy == ConflictFold {
+		return entry, nil
+	}
+
+	err := s.conflict(ConflictError{
+		Policy:   policy,
+		Name:     name,
+		Field:    "type",
+		Current:  entry.mtype.String(),
+		Conflict: mtype.String(),
+	})
+
+	switch policy {
+	case ConflictKeepFirst:
+	case ConflictTakeLast:
+		entry = s.newEntry(mtype, name, layout, entry.order)
+		s.entries[name] = entry
+	default:
+		entry = nil
+	}
+
+	return entry, err
+}
+
+// reject counts the rejection of the metric with name because the store holds
+// maxNames metrics, the first rejection is logged. The method must be called
+// with the write lock of the store held.
+func (s *metricStore) reject(name string, maxNames int) {
+	atomic.AddInt64(&s.rejected, 1)
+
+	if !s.rejectLogged {
+		s.rejectLogged = true
+		log.Printf("stats/prometheus: discarding metric %s because the limit of %d metric names was reached, further metrics will be discarded silently", name, maxNames)
+	}
+}
+
+func (s *metricStore) newEntry(mtype metricType, name string, layout func(string) histogramLayout, order uint64) *metricEntry {
+	entry := &metricEntry{
+		mtype:  mtype,
+		name:   name,
+		states: make(map[string]*metricState),
+		order:  order,
+	}
+
+	if mtype == histogram {
+		entry.layout = layout(name)
+	}
+
+	if d, ok := s.descriptions[name]; ok {
+		entry.help, entry.unit, entry.objectives, entry.maxBuckets = d.help, d.unit, d.objectives, d.maxBuckets
+	}
+
+	return entry
+}
+
+// describe sets the help text, unit, summary objectives, and maximum number of
+// buckets of native histograms of the metric with name, empty values leave the
+// current ones unchanged. The policy decides which help text is kept when the
+// metric was already described with a different one. Series created before the
+// objectives or the maximum number of buckets are changed keep the previous
+// ones.
+func (s *metricStore) describe(name string, help string, unit string, objectives []stats.Objective, maxBuckets int, policy ConflictPolicy) (err error) {
+	s.mutex.Lock()
+
+	if s.descriptions == nil {
+		s.descriptions = make(map[string]description)
+	}
+
+	d := s.descriptions[name]
+
+	if len(help) != 0 && len(d.help) != 0 && help != d.help && policy != ConflictFold {
+		err = s.conflict(ConflictError{
+			Policy:   policy,
+			Name:     name,
+			Field:    "help",
+			Current:  d.help,
+			Conflict: help,
+		})
+
+		if policy != ConflictTakeLast {
+			help = ""
+		}
+	}
+
+	if len(help) != 0 {
+		d.help = help
+	}
+
+	if len(unit) != 0 {
+		d.unit = unit
+	}
+
+	if len(objectives) != 0 {
+		d.objectives = objectives
+	}
+
+	if maxBuckets != 0 {
+		d.maxBuckets = maxBuckets
+	}
+
+	s.descriptions[name] = d
+
+	if e := s.entries[name]; e != nil {
+		e.mutex.Lock()
+		e.help, e.unit, e.objectives, e.maxBuckets = d.help, d.unit, d.objectives, d.maxBuckets
+		e.mutex.Unlock()
+	}
+
+	s.mutex.Unlock()
+	return
+}
+
+func (s *metricStore) reset() {
+	s.mutex.Lock()
+	s.entries = nil
+	s.conflicts = nil
+	s.mutex.Unlock()
+}
+
+func (s *metricStore) collect(metrics []metric) []metric {
+	s.mutex.RLock()
+	t := now()
+
+	for _, e := range s.entries {
+		metrics = e.collect(metrics, t)
+	}
+
+	s.mutex.RUnlock()
+	return metrics
+}
+
+func metricName(m *stats.Metric) string {
+	if len(m.Namespace) == 0 {
+		return sanitizeName(m.Name)
+	}
+	return sanitizeName(m.Namespace + "." + m.Name)
+}
+
+func metricTime(m *stats.Metric) time.Time {
+	if m.Time.IsZero() {
+		return now()
+	}
+	return m.Time
+}
+
+// now is a variable so tests can control the time reported on metrics.
+var now = time.Now
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKahanSum(t *testing.T) {
+	const n = 10000000
+	const x = 0.1
+
+	naive := 0.0
+	kahan := kahanSum{}
+
+	for i := 0; i != n; i++ {
+		naive += x
+		kahan.add(x)
+	}
+
+	exact := n * x
+
+	if d := math.Abs(naive - exact); d < 1e-6 {
+		t.Error("naive summation was expected to drift, error =", d)
+	}
+
+	if d := math.Abs(kahan.value() - exact); d > 1e-6 {
+		t.Error("compensated summation drifted, error =", d)
+	}
+}
+
+func TestKahanSumLargeAndSmall(t *testing.T) {
+	k := kahanSum{}
+	k.add(1e100)
+	k.add(1.0)
+	k.add(-1e100)
+
+	if v := k.value(); v != 1.0 {
+		t.Error("bad sum:", v)
+	}
+}
+
+func TestMetricStateUpdateHistogram(t *testing.T) {
+	s := metricState{buckets: makeBuckets([]float64{1, 2})}
+	s.update(histogram, 0.5, 1, now())
+	s.update(histogram, 1.5, 1, now())
+	s.update(histogram, 1.5, 1, now())
+	s.update(histogram, 10, 1, now())
+
+	if v := s.value.value(); v != 13.5 {
+		t.Error("bad sum:", v)
+	}
+
+	if s.count != 4 {
+		t.Error("bad count:", s.count)
+	}
+
+	if c := s.buckets.counts; c[0] != 1 || c[1] != 2 {
+		t.Error("bad bucket counts:", c)
+	}
+}
+
+func BenchmarkMetricStateUpdate(b *testing.B) {
+	s := metricState{buckets: makeBuckets(DefaultBuckets)}
+	t := now()
+
+	for i := 0; i != b.N; i++ {
+		s.update(histogram, 0.1, 1, t)
+	}
+}
@@ -0,0 +1,127 @@
+package prometheus
+
+import "github.com/segmentio/stats"
+
+// maxNativeSchema is the highest schema of native histograms supported by
+// prometheus, series of exponential histograms at higher scales are downscaled
+// when they are exposed.
+const maxNativeSchema = 8
+
+// nativeBuckets is a snapshot of the buckets of a native histogram, which are
+// the buckets of the exponential sketch of the series. Bucket i of the sketch
+// is bucket i+1 of prometheus, whose buckets hold the values up to their upper
+// limit instead of above their lower limit.
+type nativeBuckets struct {
+	scale    int
+	zero     uint64
+	positive stats.ExponentialBuckets
+	negative stats.ExponentialBuckets
+}
+
+func makeNativeBuckets(s *stats.ExponentialSketch) nativeBuckets {
+	if s == nil {
+		return nativeBuckets{}
+	}
+
+	n := nativeBuckets{
+		scale:    s.Scale(),
+		zero:     s.ZeroCount(),
+		positive: s.Positive(),
+		negative: s.Negative(),
+	}
+
+	return n.downscale(n.scale - maxNativeSchema)
+}
+
+func (n nativeBuckets) downscale(by int) nativeBuckets {
+	if by <= 0 {
+		return n
+	}
+
+	return nativeBuckets{
+		scale:    n.scale - by,
+		zero:     n.zero,
+		positive: n.positive.Downscale(by),
+		negative: n.negative.Downscale(by),
+	}
+}
+
+// merge returns the sum of n and other, at the lowest of their scales.
+func (n nativeBuckets) merge(other nativeBuckets) nativeBuckets {
+	scale := n.scale
+	if other.scale < scale {
+		scale = other.scale
+	}
+
+	a, b := n.downscale(n.scale-scale), other.downscale(other.scale-scale)
+
+	return nativeBuckets{
+		scale:    scale,
+		zero:     a.zero + b.zero,
+		positive: mergeExponentialBuckets(a.positive, b.positive),
+		negative: mergeExponentialBuckets(a.negative, b.negative),
+	}
+}
+
+func mergeExponentialBuckets(a stats.ExponentialBuckets, b stats.ExponentialBuckets) stats.ExponentialBuckets {
+	if len(a.Counts) == 0 {
+		a, b = b, a
+	}
+
+	first, last := a.Offset, a.Offset+len(a.Counts)
+
+	if len(b.Counts) != 0 {
+		if b.Offset < first {
+			first = b.Offset
+		}
+		if end := b.Offset + len(b.Counts); end > last {
+			last = end
+		}
+	}
+
+	counts := make([]uint64, last-first)
+
+	for i, c := range a.Counts {
+		counts[a.Offset+i-first] += c
+	}
+
+	for i, c := range b.Counts {
+		counts[b.Offset+i-first] += c
+	}
+
+	return stats.ExponentialBuckets{Offset: first, Counts: counts}
+}
+
+// appendNativeHistogram appends the samples of the native histogram m to b,
+// as a classic histogram whose buckets are the non-empty buckets of m since the
+// text formats cannot carry native histograms.
+func appendNativeHistogram(b []byte, m metric, ts string) []byte {
+	n := m.native
+	cumulative := uint64(0)
+
+	for i := len(n.negative.Counts) - 1; i >= 0; i-- {
+		if c := n.negative.Counts[i]; c != 0 {
+			cumulative += c
+			limit := -stats.ExponentialBound(n.negative.Offset+i, n.scale)
+			b = appendSample(b, m.name, "_bucket", m.labels, float64(cumulative), &label{"le", formatFloat(limit)}, ts, nil)
+		}
+	}
+
+	if n.zero != 0 {
+		cumulative += n.zero
+		b = appendSample(b, m.name, "_bucket", m.labels, float64(cumulative), &label{"le", "0"}, ts, nil)
+	}
+
+	for i, c := range n.positive.Counts {
+		if c != 0 {
+			cumulative += c
+			limit := stats.ExponentialBound(n.positive.Offset+i+1, n.scale)
+			b = appendSample(b, m.name, "_bucket", m.labels, float64(cumulative), &label{"le", formatFloat(limit)}, ts, nil)
+		}
+	}
+
+	b = appendSample(b, m.name, "_bucket", m.labels, float64(m.count), &label{"le", "+Inf"}, ts, nil)
+	b = appendSample(b, m.name, "_sum", m.labels, m.value, nil, ts, nil)
+	b = appendSample(b, m.name, "_count", m.labels, float64(m.count), nil, ts, nil)
+	return b
+}
@@ -0,0 +1,252 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestHandlerExponentialHistogram(t *testing.T) {
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	x := e.ExponentialHistogram("latency", 4)
+	for _, v := range []float64{-1, 0, 1, 2, 3} {
+		x.Observe(v)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+
+	if s := res.Body.String(); s != `# TYPE test_latency histogram
+test_latency_bucket{le="-0.5"} 1
+test_latency_bucket{le="0"} 2
+test_latency_bucket{le="1"} 3
+test_latency_bucket{le="2"} 4
+test_latency_bucket{le="4"} 5
+test_latency_bucket{le="+Inf"} 5
+test_latency_sum 5
+test_latency_count 5
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+}
+
+func TestHandlerProtobuf(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	h := &Handler{}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	x := e.ExponentialHistogram("latency", 4).WithHelp("Latency of requests.")
+	for _, v := range []float64{-1, 0, 1, 2, 3} {
+		x.Observe(v)
+	}
+
+	e.Set("conns", 42)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	if ct := res.Header().Get("Content-Type"); ct != protobufContentType {
+		t.Error("bad content type:", ct)
+	}
+
+	var families []protobufFields
+
+	for b := res.Body.Bytes(); len(b) != 0; {
+		size, n := binary.Uvarint(b)
+		families = append(families, decodeProtobuf(t, b[n:n+int(size)]))
+		b = b[n+int(size):]
+	}
+
+	if len(families) != 2 {
+		t.Fatal("bad number of metric families:", len(families))
+	}
+
+	conns := families[0]
+
+	if conns.string(1) != "test_conns" || conns.varint(3) != protobufGauge {
+		t.Error("bad gauge family:", conns)
+	}
+
+	if g := conns.message(t, 4).message(t, 2); math.Float64frombits(g.varint(1)) != 42 {
+		t.Error("bad gauge value:", g)
+	}
+
+	latency := families[1]
+
+	if latency.string(1) != "test_latency" || latency.string(2) != "Latency of requests." || latency.varint(3) != protobufHistogram {
+		t.Error("bad histogram family:", latency)
+	}
+
+	hist := latency.message(t, 4).message(t, 7)
+
+	if hist.varint(1) != 5 || math.Float64frombits(hist.varint(2)) != 5 || hist.varint(5) != 0 || hist.varint(7) != 1 {
+		t.Error("bad histogram:", hist)
+	}
+
+	// Bucket i of the sketch is bucket i+1 of prometheus, the negative bucket
+	// of -1 is the bucket 0 and the positive buckets of 1, 2, and 3 are the
+	// buckets 0 to 2. Spans have zigzag offsets, deltas are zigzag encoded.
+	if span := hist.message(t, 9); span.varint(1) != 0 || span.varint(2) != 1 {
+		t.Error("bad negative span:", span)
+	}
+
+	if deltas := hist.bytes(10); !reflect.DeepEqual(deltas, []byte{2}) {
+		t.Error("bad negative deltas:", deltas)
+	}
+
+	if span := hist.message(t, 12); span.varint(1) != 0 || span.varint(2) != 3 {
+		t.Error("bad positive span:", span)
+	}
+
+	if deltas := hist.bytes(13); !reflect.DeepEqual(deltas, []byte{2, 0, 0}) {
+		t.Error("bad positive deltas:", deltas)
+	}
+
+	if created := hist.message(t, 15); created.varint(1) != 1500000000 {
+		t.Error("bad created timestamp:", created)
+	}
+}
+
+func TestAppendNativeBuckets(t *testing.T) {
+	tests := []struct {
+		buckets stats.ExponentialBuckets
+		spans   [][2]uint64 // zigzag offset and length
+		deltas  []byte
+	}{
+		{
+			buckets: stats.ExponentialBuckets{},
+		},
+		{
+			buckets: stats.ExponentialBuckets{Offset: -1, Counts: []uint64{1, 0, 0, 1}},
+			spans:   [][2]uint64{{0, 1}, {4, 1}},
+			deltas:  []byte{2, 0},
+		},
+		{
+			buckets: stats.ExponentialBuckets{Offset: 2, Counts: []uint64{0, 3, 1, 0, 2}},
+			spans:   [][2]uint64{{8, 2}, {2, 1}},
+			deltas:  []byte{6, 3, 2},
+		},
+	}
+
+	for _, test := range tests {
+		fields := decodeProtobuf(t, appendNativeBuckets(nil, 12, 13, test.buckets))
+
+		var spans [][2]uint64
+		for _, f := range fields {
+			if f.num == 12 {
+				span := decodeProtobuf(t, f.bytes)
+				spans = append(spans, [2]uint64{span.varint(1), span.varint(2)})
+			}
+		}
+
+		if !reflect.DeepEqual(spans, test.spans) {
+			t.Errorf("%v: bad spans: %v", test.buckets, spans)
+		}
+
+		if deltas := fields.bytes(13); !reflect.DeepEqual(deltas, test.deltas) {
+			t.Errorf("%v: bad deltas: %v", test.buckets, deltas)
+		}
+	}
+}
+
+func TestNativeBucketsMerge(t *testing.T) {
+	a := nativeBuckets{
+		scale:    1,
+		zero:     1,
+		positive: stats.ExponentialBuckets{Offset: 0, Counts: []uint64{1, 2, 3}},
+	}
+
+	b := nativeBuckets{
+		scale:    0,
+		zero:     2,
+		positive: stats.ExponentialBuckets{Offset: 2, Counts: []uint64{4}},
+		negative: stats.ExponentialBuckets{Offset: -1, Counts: []uint64{5}},
+	}
+
+	if m := a.merge(b); !reflect.DeepEqual(m, nativeBuckets{
+		scale:    0,
+		zero:     3,
+		positive: stats.ExponentialBuckets{Offset: 0, Counts: []uint64{3, 3, 4}},
+		negative: stats.ExponentialBuckets{Offset: -1, Counts: []uint64{5}},
+	}) {
+		t.Errorf("bad merge: %+v", m)
+	}
+}
+
+// protobufField is a field of a protobuf message decoded by tests, fixed64
+// values are decoded as varints.
+type protobufField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+type protobufFields []protobufField
+
+func decodeProtobuf(t *testing.T, b []byte) protobufFields {
+	var fields protobufFields
+
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := protobufField{num: int(key >> 3)}
+
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatal("bad wire type:", key&7)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+func (fields protobufFields) field(num int) protobufField {
+	for _, f := range fields {
+		if f.num == num {
+			return f
+		}
+	}
+	return protobufField{}
+}
+
+func (fields protobufFields) varint(num int) uint64 {
+	return fields.field(num).varint
+}
+
+func (fields protobufFields) bytes(num int) []byte {
+	return fields.field(num).bytes
+}
+
+func (fields protobufFields) string(num int) string {
+	return string(fields.bytes(num))
+}
+
+func (fields protobufFields) message(t *testing.T, num int) protobufFields {
+	return decodeProtobuf(t, fields.bytes(num))
+}
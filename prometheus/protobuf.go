@@ -0,0 +1,356 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// protobufContentType is the content type of the protobuf exposition format,
+// a stream of io.prometheus.client.MetricFamily messages each prefixed with its
+// length. It is the only format carrying native histograms.
+const protobufContentType = "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited"
+
+// Wire types of the protobuf encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Values of the io.prometheus.client.MetricType enumeration.
+const (
+	protobufCounter   = 0
+	protobufGauge     = 1
+	protobufSummary   = 2
+	protobufUntyped   = 3
+	protobufHistogram = 4
+)
+
+// writeProtobuf serializes metrics to w in the protobuf exposition format, in
+// chunks like writeMetrics. The messages are encoded by hand, following the
+// definitions of the metrics.proto file of the prometheus client model.
+func (h *Handler) writeProtobuf(w io.Writer, metrics []metric) (err error) {
+	buf := bufferPool.Get().(*buffer)
+	b := buf.b[:0]
+
+	for i := 0; i != len(metrics); {
+		j := i + 1
+
+		for j != len(metrics) && metrics[j].name == metrics[i].name {
+			j++
+		}
+
+		family := metrics[i:j]
+		i = j
+
+		for k := range family {
+			if r, ok := h.Rounding[family[k].name]; ok && family[k].mtype != histogram && family[k].mtype != nativeHistogram {
+				family[k].value = r.Round(family[k].value)
+			}
+		}
+
+		b = appendDelimited(b, func(b []byte) []byte {
+			return appendMetricFamily(b, family, h.Timestamps)
+		})
+
+		if len(b) >= chunkSize {
+			if _, err = w.Write(b); err != nil {
+				break
+			}
+			b = b[:0]
+		}
+	}
+
+	if err == nil && len(b) != 0 {
+		_, err = w.Write(b)
+	}
+
+	buf.b = b
+	bufferPool.Put(buf)
+	return
+}
+
+// appendMetricFamily appends the fields of the MetricFamily message of the
+// series in family, which all have the same name, to b.
+func appendMetricFamily(b []byte, family []metric, timestamps bool) []byte {
+	m := family[0]
+	b = appendStringField(b, 1, m.name)
+
+	if len(m.help) != 0 {
+		b = appendStringField(b, 2, m.help)
+	}
+
+	b = appendVarintField(b, 3, protobufType(m.mtype))
+
+	for _, m := range family {
+		b = appendMessageField(b, 4, func(b []byte) []byte {
+			return appendProtobufMetric(b, m, timestamps)
+		})
+	}
+
+	return b
+}
+
+func protobufType(t metricType) uint64 {
+	switch t {
+	case counter:
+		return protobufCounter
+	case gauge:
+		return protobufGauge
+	case summary:
+		return protobufSummary
+	case histogram, nativeHistogram:
+		return protobufHistogram
+	default:
+		return protobufUntyped
+	}
+}
+
+// appendProtobufMetric appends the fields of the Metric message of m to b.
+func appendProtobufMetric(b []byte, m metric, timestamps bool) []byte {
+	for _, l := range m.labels {
+		b = appendLabelPair(b, 1, l)
+	}
+
+	switch m.mtype {
+	case counter:
+		b = appendMessageField(b, 3, func(b []byte) []byte {
+			b = appendDoubleField(b, 1, m.value)
+			if ex := exemplarAt(m.exemplars, 0); ex != nil && len(ex.labels) != 0 {
+				b = appendExemplar(b, 2, *ex)
+			}
+			return appendTimestampField(b, 3, m.created)
+		})
+
+	case gauge:
+		b = appendMessageField(b, 2, func(b []byte) []byte {
+			return appendDoubleField(b, 1, m.value)
+		})
+
+	case summary:
+		b = appendMessageField(b, 4, func(b []byte) []byte {
+			b = appendVarintField(b, 1, m.count)
+			b = appendDoubleField(b, 2, m.value)
+			for _, q := range m.quantiles {
+				b = appendMessageField(b, 3, func(b []byte) []byte {
+					b = appendDoubleField(b, 1, q.q)
+					return appendDoubleField(b, 2, q.value)
+				})
+			}
+			return appendTimestampField(b, 4, m.created)
+		})
+
+	case histogram:
+		b = appendMessageField(b, 7, func(b []byte) []byte {
+			return appendClassicHistogram(b, m)
+		})
+
+	case nativeHistogram:
+		b = appendMessageField(b, 7, func(b []byte) []byte {
+			return appendNativeHistogramMessage(b, m)
+		})
+
+	default:
+		b = appendMessageField(b, 5, func(b []byte) []byte {
+			return appendDoubleField(b, 1, m.value)
+		})
+	}
+
+	if timestamps && !m.time.IsZero() {
+		b = appendVarintField(b, 6, uint64(m.time.UnixNano()/int64(time.Millisecond)))
+	}
+
+	return b
+}
+
+// appendClassicHistogram appends the fields of the Histogram message of the
+// histogram m to b, the +Inf bucket is implied by the count.
+func appendClassicHistogram(b []byte, m metric) []byte {
+	b = appendVarintField(b, 1, m.count)
+	b = appendDoubleField(b, 2, m.value)
+
+	cumulative := uint64(0)
+
+	for i, limit := range m.buckets.limits {
+		cumulative += m.buckets.counts[i]
+		b = appendMessageField(b, 3, func(b []byte) []byte {
+			b = appendVarintField(b, 1, cumulative)
+			b = appendDoubleField(b, 2, limit)
+			if ex := exemplarAt(m.exemplars, i); ex != nil && len(ex.labels) != 0 {
+				b = appendExemplar(b, 3, *ex)
+			}
+			return b
+		})
+	}
+
+	return appendTimestampField(b, 15, m.created)
+}
+
+// appendNativeHistogramMessage appends the fields of the Histogram message of
+// the native histogram m to b. Buckets are encoded as spans of consecutive
+// non-empty buckets, with the difference of each count to the previous one.
+func appendNativeHistogramMessage(b []byte, m metric) []byte {
+	n := m.native
+	b = appendVarintField(b, 1, m.count)
+	b = appendDoubleField(b, 2, m.value)
+	b = appendVarintField(b, 5, zigzag(int64(n.scale)))
+
+	if n.zero != 0 {
+		b = appendVarintField(b, 7, n.zero)
+	}
+
+	b = appendNativeBuckets(b, 9, 10, n.negative)
+	b = appendNativeBuckets(b, 12, 13, n.positive)
+
+	if n.zero == 0 && len(n.positive.Counts) == 0 && len(n.negative.Counts) == 0 {
+		// Histograms without buckets are only recognized as native
+		// histograms by prometheus when they have a span, an empty one is
+		// added like the official client does.
+		b = appendMessageField(b, 12, func(b []byte) []byte {
+			return appendVarintField(b, 2, 0)
+		})
+	}
+
+	return appendTimestampField(b, 15, m.created)
+}
+
+// appendNativeBuckets appends the spans and deltas of buckets to b, with the
+// field numbers of the positive or negative buckets.
+func appendNativeBuckets(b []byte, spanField int, deltaField int, buckets stats.ExponentialBuckets) []byte {
+	var deltas []uint64
+	var prev uint64
+	var spans int
+	var end int // index of the end of the previous span
+
+	for i := 0; i != len(buckets.Counts); {
+		if buckets.Counts[i] == 0 {
+			i++
+			continue
+		}
+
+		j := i
+		for j != len(buckets.Counts) && buckets.Counts[j] != 0 {
+			deltas = append(deltas, zigzag(int64(buckets.Counts[j])-int64(prev)))
+			prev = buckets.Counts[j]
+			j++
+		}
+
+		// The index of prometheus buckets is the index of the sketch plus
+		// one, the offset of the first span is the index of its first bucket
+		// and the offsets of the next ones the gaps between spans.
+		index := buckets.Offset + i + 1
+		offset := index
+
+		if spans++; spans != 1 {
+			offset = index - end
+		}
+
+		b = appendMessageField(b, spanField, func(b []byte) []byte {
+			b = appendVarintField(b, 1, zigzag(int64(offset)))
+			return appendVarintField(b, 2, uint64(j-i))
+		})
+
+		end = index + j - i
+		i = j
+	}
+
+	if len(deltas) != 0 {
+		b = appendMessageField(b, deltaField, func(b []byte) []byte {
+			for _, d := range deltas {
+				b = appendVarint(b, d)
+			}
+			return b
+		})
+	}
+
+	return b
+}
+
+func appendLabelPair(b []byte, field int, l label) []byte {
+	return appendMessageField(b, field, func(b []byte) []byte {
+		b = appendStringField(b, 1, l.name)
+		return appendStringField(b, 2, l.value)
+	})
+}
+
+func appendExemplar(b []byte, field int, ex exemplar) []byte {
+	return appendMessageField(b, field, func(b []byte) []byte {
+		for _, l := range ex.labels {
+			b = appendLabelPair(b, 1, l)
+		}
+		b = appendDoubleField(b, 2, ex.value)
+		return appendTimestampField(b, 3, ex.time)
+	})
+}
+
+// appendTimestampField appends t as a google.protobuf.Timestamp message, unless
+// it is the zero time.
+func appendTimestampField(b []byte, field int, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendMessageField(b, field, func(b []byte) []byte {
+		b = appendVarintField(b, 1, uint64(t.Unix()))
+		return appendVarintField(b, 2, uint64(t.Nanosecond()))
+	})
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendDoubleField(b []byte, field int, f float64) []byte {
+	var x [8]byte
+	binary.LittleEndian.PutUint64(x[:], math.Float64bits(f))
+	b = appendKey(b, field, wireFixed64)
+	return append(b, x[:]...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendKey(b, field, wireVarint)
+	return appendVarint(b, v)
+}
+
+// appendMessageField appends the embedded message encoded by f to b.
+func appendMessageField(b []byte, field int, f func([]byte) []byte) []byte {
+	return appendDelimited(appendKey(b, field, wireBytes), f)
+}
+
+// appendDelimited appends the message encoded by f to b, prefixed with its
+// length. The message is encoded in place and moved after the prefix once its
+// length is known, which avoids encoding it in a separate buffer.
+func appendDelimited(b []byte, f func([]byte) []byte) []byte {
+	start := len(b)
+	b = f(b)
+	size := len(b) - start
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(size))
+
+	b = append(b, prefix[:n]...)
+	copy(b[start+n:], b[start:start+size])
+	copy(b[start:], prefix[:n])
+	return b
+}
+
+func appendKey(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
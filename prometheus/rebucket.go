@@ -0,0 +1,137 @@
+package prometheus
+
+import (
+	"math"
+	"sort"
+)
+
+// SetBuckets changes the upper limits of the buckets of the histogram with
+// name, the name of the exposed metric, to limits. The limits must be sorted in
+// increasing order. It is intended to apply bucket changes of configuration
+// reloads to a live handler, the Buckets field must not be modified once the
+// handler is in use.
+//
+// The existing series of the histogram are re-bucketed rather than reset, so
+// their sum and count are preserved exactly and the distribution of their
+// values is approximated in the new buckets. Values are assumed to be spread
+// uniformly within each old bucket (the first bucket starts at zero when its
+// limit is positive), and the counts of each old bucket are distributed to
+// the new buckets in proportion to their overlap with it, rounded so that the
+// total count of each old bucket is preserved. Values above the last old limit
+// remain above all limits, and values of old buckets beyond the last new limit
+// are only counted in the total. The approximation is exact when the new
+// limits are a subset of the old ones, which is the case when buckets are
+// merged.
+//
+// Downsampled series (see DownsampleSeries) keep their buckets.
+func (h *Handler) SetBuckets(name string, limits []float64) {
+	s := &h.metrics
+	s.mutex.Lock()
+
+	if current, ok := h.Buckets[name]; ok && sameLimits(current, limits) {
+		s.mutex.Unlock()
+		return
+	}
+
+	buckets := make(map[string][]float64, len(h.Buckets)+1)
+	for n, l := range h.Buckets {
+		buckets[n] = l
+	}
+	buckets[name] = limits
+	h.Buckets = buckets
+
+	if e := s.entries[name]; e != nil && e.mtype == histogram {
+		e.mutex.Lock()
+		old := e.layout.limits
+		e.layout.limits = limits
+
+		for _, state := range e.states {
+			if sameLimits(state.buckets.limits, old) {
+				state.buckets = state.buckets.rebucket(limits)
+			}
+		}
+
+		e.mutex.Unlock()
+	}
+
+	s.mutex.Unlock()
+}
+
+// rebucket returns the counts of b distributed to buckets with limits, see
+// SetBuckets for a description of the approximation.
+func (b buckets) rebucket(limits []float64) buckets {
+	r := makeBuckets(limits)
+	shares := make([]float64, len(limits)+1) // the last share is above all limits
+
+	for i, count := range b.counts {
+		if count == 0 {
+			continue
+		}
+
+		hi := b.limits[i]
+		lo := hi
+
+		if i != 0 {
+			lo = b.limits[i-1]
+		} else if hi > 0 {
+			lo = 0
+		}
+
+		for j := range shares {
+			shares[j] = 0
+		}
+
+		if lo == hi {
+			shares[sort.SearchFloat64s(limits, hi)] = 1
+		} else {
+			for j := range shares {
+				nlo, nhi := math.Inf(-1), math.Inf(+1)
+				if j != 0 {
+					nlo = limits[j-1]
+				}
+				if j != len(limits) {
+					nhi = limits[j]
+				}
+				if overlap := math.Min(hi, nhi) - math.Max(lo, nlo); overlap > 0 {
+					shares[j] = overlap / (hi - lo)
+				}
+			}
+		}
+
+		distribute(r.counts, shares, count)
+	}
+
+	return r
+}
+
+// distribute adds count to counts in proportion to shares using the largest
+// remainder method, so the sum of the additions is exactly count. Shares past
+// the end of counts are dropped.
+func distribute(counts []uint64, shares []float64, count uint64) {
+	total := uint64(0)
+	parts := make([]uint64, len(shares))
+	remainders := make([]int, 0, len(shares))
+
+	for j, share := range shares {
+		parts[j] = uint64(math.Floor(share * float64(count)))
+		total += parts[j]
+		if share > 0 {
+			remainders = append(remainders, j)
+		}
+	}
+
+	sort.SliceStable(remainders, func(a int, b int) bool {
+		ra := shares[remainders[a]]*float64(count) - float64(parts[remainders[a]])
+		rb := shares[remainders[b]]*float64(count) - float64(parts[remainders[b]])
+		return ra > rb
+	})
+
+	for i := 0; total < count && len(remainders) != 0; i++ {
+		parts[remainders[i%len(remainders)]]++
+		total++
+	}
+
+	for j := range counts {
+		counts[j] += parts[j]
+	}
+}
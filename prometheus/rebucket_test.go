@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+func TestBucketsRebucket(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   buckets
+		limits []float64
+		counts []uint64
+	}{
+		{
+			name:   "merge",
+			from:   buckets{limits: []float64{1, 2, 3, 4}, counts: []uint64{1, 2, 3, 4}},
+			limits: []float64{2, 4},
+			counts: []uint64{3, 7},
+		},
+		{
+			name:   "split",
+			from:   buckets{limits: []float64{2, 4}, counts: []uint64{10, 4}},
+			limits: []float64{1, 2, 3, 4},
+			counts: []uint64{5, 5, 2, 2},
+		},
+		{
+			name:   "uneven split",
+			from:   buckets{limits: []float64{3}, counts: []uint64{2}},
+			limits: []float64{1, 2, 3},
+			counts: []uint64{1, 1, 0},
+		},
+		{
+			name:   "shrink",
+			from:   buckets{limits: []float64{1, 2}, counts: []uint64{3, 4}},
+			limits: []float64{1},
+			counts: []uint64{3},
+		},
+		{
+			name:   "negative limit",
+			from:   buckets{limits: []float64{-1, 1}, counts: []uint64{2, 2}},
+			limits: []float64{-2, 0, 2},
+			counts: []uint64{0, 3, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.from.rebucket(test.limits)
+
+			if !reflect.DeepEqual(b.limits, test.limits) || !reflect.DeepEqual(b.counts, test.counts) {
+				t.Errorf("bad buckets: %v %v", b.limits, b.counts)
+			}
+		})
+	}
+}
+
+func TestHandlerSetBuckets(t *testing.T) {
+	h := &Handler{Buckets: map[string][]float64{"test_latency": {1, 2, 3, 4}}}
+	e := stats.NewEngine("test")
+	e.Register(h)
+
+	for _, v := range []float64{0.5, 1.5, 2.5, 3.5, 10} {
+		e.Observe("latency", v)
+	}
+
+	h.SetBuckets("test_latency", []float64{2, 4})
+	e.Observe("latency", 0.5)
+
+	metrics := h.collect(nil)
+
+	if len(metrics) != 1 {
+		t.Fatal("bad metrics:", metrics)
+	}
+
+	m := metrics[0]
+
+	if m.count != 6 || m.value != 18.5 {
+		t.Error("the sum or count of the histogram changed:", m.count, m.value)
+	}
+
+	if !reflect.DeepEqual(m.buckets.limits, []float64{2, 4}) || !reflect.DeepEqual(m.buckets.counts, []uint64{3, 2}) {
+		t.Errorf("bad buckets: %v %v", m.buckets.limits, m.buckets.counts)
+	}
+
+	if limits := h.Buckets["test_latency"]; !reflect.DeepEqual(limits, []float64{2, 4}) {
+		t.Error("the bucket configuration was not updated:", limits)
+	}
+}
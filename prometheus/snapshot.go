@@ -0,0 +1,374 @@
+package prometheus
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// snapshotVersion is the version of the snapshot encoding, it is incremented
+// when the encoding changes in an incompatible way.
+//
+// Version 2 added the quantiles of summaries and the buckets of native
+// histograms.
+const snapshotVersion = 2
+
+// WriteSnapshot writes the state of the metrics held by the handler to w, in a
+// binary encoding that can be read by the MergeSnapshot method of another
+// handler.
+//
+// Snapshots are intended to support pre-fork servers, where each worker
+// process records metrics in its own handler and periodically sends snapshots
+// to the master process over a pipe, so the master can expose the aggregated
+// state of all workers on a single endpoint.
+func (h *Handler) WriteSnapshot(w io.Writer) error {
+	metrics := h.metrics.collect(nil)
+	s := snapshot{
+		Version: snapshotVersion,
+		Metrics: make([]snapshotMetric, len(metrics)),
+	}
+
+	for i, m := range metrics {
+		s.Metrics[i] = makeSnapshotMetric(m)
+	}
+
+	return gob.NewEncoder(w).Encode(s)
+}
+
+// MergeSnapshot reads a snapshot written by WriteSnapshot from r, and merges
+// it into the metrics exposed by the handler. The snapshot replaces the one
+// previously received from the same source, which is typically the identifier
+// of a worker process, so snapshots of cumulative values can be merged
+// repeatedly without being counted twice.
+//
+// The series of all sources and of the handler itself are merged when the
+// handler is scraped, series with the same name and labels are combined:
+//
+//   - counters report the sum of their values
+//   - gauges report the sum of their values, which suits gauges counting
+//     resources like connections or in-flight requests
+//   - histograms report the sums of their buckets, counts, and sums, series
+//     with buckets that differ from the buckets of the same series in the
+//     first source are discarded
+//   - native histograms report the sums of their buckets, counts, and sums,
+//     at the lowest scale of the series
+//   - summaries report the sums of their counts and sums, quantiles cannot
+//     be merged and are the quantiles of the series in the first source
+//
+// Like the series of the handler, all series of a metric must have the same
+// label names, series of other sources which don't are discarded, and a
+// metric must have the same type in all sources.
+func (h *Handler) MergeSnapshot(source string, r io.Reader) error {
+	var s snapshot
+
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("stats/prometheus: unsupported snapshot version: %d", s.Version)
+	}
+
+	metrics := make([]metric, len(s.Metrics))
+
+	for i, m := range s.Metrics {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("stats/prometheus: invalid snapshot of metric %s: %s", m.Name, err)
+		}
+		metrics[i] = m.metric()
+	}
+
+	h.snapshots.set(source, metrics)
+	return nil
+}
+
+// RemoveSnapshot discards the snapshot received from source, for example
+// because the worker process that sent it exited.
+//
+// Counters and histograms exposed by the handler decrease when the snapshot of
+// a source is removed, which prometheus interprets as a counter reset.
+func (h *Handler) RemoveSnapshot(source string) {
+	h.snapshots.set(source, nil)
+}
+
+type snapshot struct {
+	Version int
+	Metrics []snapshotMetric
+}
+
+type snapshotMetric struct {
+	Type    int
+	Name    string
+	Help    string
+	Unit    string
+	Labels  []snapshotLabel
+	Value   float64
+	Count   uint64
+	Limits  []float64
+	Counts  []uint64
+	Time    int64
+	Created int64
+
+	// Quantiles of summaries, encoded as pairs of quantiles and values.
+	Quantiles []float64
+
+	// Buckets of native histograms.
+	Scale          int
+	ZeroCount      uint64
+	PositiveOffset int
+	Positive       []uint64
+	NegativeOffset int
+	Negative       []uint64
+}
+
+type snapshotLabel struct {
+	Name  string
+	Value string
+}
+
+func makeSnapshotMetric(m metric) snapshotMetric {
+	s := snapshotMetric{
+		Type:    int(m.mtype),
+		Name:    m.name,
+		Help:    m.help,
+		Unit:    m.unit,
+		Value:   m.value,
+		Count:   m.count,
+		Limits:  m.buckets.limits,
+		Counts:  m.buckets.counts,
+		Time:    unixNano(m.time),
+		Created: unixNano(m.created),
+	}
+
+	if m.mtype == nativeHistogram {
+		s.Scale = m.native.scale
+		s.ZeroCount = m.native.zero
+		s.PositiveOffset, s.Positive = m.native.positive.Offset, m.native.positive.Counts
+		s.NegativeOffset, s.Negative = m.native.negative.Offset, m.native.negative.Counts
+	}
+
+	if len(m.quantiles) != 0 {
+		s.Quantiles = make([]float64, 0, 2*len(m.quantiles))
+
+		for _, q := range m.quantiles {
+			s.Quantiles = append(s.Quantiles, q.q, q.value)
+		}
+	}
+
+	if len(m.labels) != 0 {
+		s.Labels = make([]snapshotLabel, len(m.labels))
+
+		for i, l := range m.labels {
+			s.Labels[i] = snapshotLabel{l.name, l.value}
+		}
+	}
+
+	return s
+}
+
+// validate returns an error if s is inconsistent, snapshots are received from
+// other processes and are checked before being merged.
+func (s snapshotMetric) validate() error {
+	switch {
+	case s.Type < int(untyped) || s.Type > int(nativeHistogram):
+		return fmt.Errorf("unknown metric type: %d", s.Type)
+	case len(s.Counts) != len(s.Limits):
+		return fmt.Errorf("%d bucket counts for %d bucket limits", len(s.Counts), len(s.Limits))
+	case !sort.Float64sAreSorted(s.Limits):
+		return fmt.Errorf("bucket limits are not sorted")
+	case len(s.Quantiles)%2 != 0:
+		return fmt.Errorf("odd number of quantile values: %d", len(s.Quantiles))
+	case s.Scale < stats.MinExponentialScale || s.Scale > stats.MaxExponentialScale:
+		return fmt.Errorf("native histogram scale out of range: %d", s.Scale)
+	}
+	return nil
+}
+
+func (s snapshotMetric) metric() metric {
+	m := metric{
+		mtype:   metricType(s.Type),
+		name:    s.Name,
+		help:    s.Help,
+		unit:    s.Unit,
+		value:   s.Value,
+		count:   s.Count,
+		buckets: buckets{limits: s.Limits, counts: s.Counts},
+		native: nativeBuckets{
+			scale:    s.Scale,
+			zero:     s.ZeroCount,
+			positive: stats.ExponentialBuckets{Offset: s.PositiveOffset, Counts: s.Positive},
+			negative: stats.ExponentialBuckets{Offset: s.NegativeOffset, Counts: s.Negative},
+		},
+		time:    fromUnixNano(s.Time),
+		created: fromUnixNano(s.Created),
+		order:   math.MaxUint64 - 1, // after the metrics of the handler
+	}
+
+	for i := 0; i+1 < len(s.Quantiles); i += 2 {
+		m.quantiles = append(m.quantiles, quantile{q: s.Quantiles[i], value: s.Quantiles[i+1]})
+	}
+
+	if len(s.Labels) != 0 {
+		m.labels = make(labels, len(s.Labels))
+
+		for i, l := range s.Labels {
+			m.labels[i] = label{l.Name, l.Value}
+		}
+
+		sort.Stable(m.labels)
+	}
+
+	return m
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+// snapshotStore holds the snapshots merged into a handler.
+type snapshotStore struct {
+	mutex   sync.RWMutex
+	sources map[string][]metric
+}
+
+func (s *snapshotStore) set(source string, metrics []metric) {
+	s.mutex.Lock()
+
+	if metrics == nil {
+		delete(s.sources, source)
+	} else {
+		if s.sources == nil {
+			s.sources = make(map[string][]metric)
+		}
+		s.sources[source] = metrics
+	}
+
+	s.mutex.Unlock()
+}
+
+// merge combines the series of all snapshots with metrics, which are the
+// series of the handler.
+func (s *snapshotStore) merge(metrics []metric) []metric {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.sources) == 0 {
+		return metrics
+	}
+
+	// Sources are merged in a deterministic order so the first series of a
+	// metric, which determines its label names, doesn't change between
+	// scrapes.
+	sources := make([]string, 0, len(s.sources))
+	for source := range s.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	series := make(map[string]int, len(metrics))
+	first := make(map[string]int)
+
+	for i, m := range metrics {
+		series[m.name+"\x00"+m.labels.key()] = i
+		if _, ok := first[m.name]; !ok {
+			first[m.name] = i
+		}
+	}
+
+	for _, source := range sources {
+		for _, m := range s.sources[source] {
+			if i, ok := first[m.name]; ok {
+				f := &metrics[i]
+
+				if f.mtype != m.mtype || !m.labels.hasNames(f.labels.names()) {
+					continue
+				}
+			}
+
+			key := m.name + "\x00" + m.labels.key()
+
+			// Series of a histogram may have different buckets when it is
+			// downsampled, only series with the same buckets are merged.
+			if i, ok := series[key]; ok {
+				if sameLimits(metrics[i].buckets.limits, m.buckets.limits) {
+					metrics[i].merge(m)
+				}
+				continue
+			}
+
+			m.buckets = m.buckets.copy()
+			series[key] = len(metrics)
+
+			if _, ok := first[m.name]; !ok {
+				first[m.name] = len(metrics)
+			}
+
+			metrics = append(metrics, m)
+		}
+	}
+
+	return metrics
+}
+
+// merge combines the state of other with the state of m, both must be series
+// of the same metric. Series with different numbers of buckets are not merged.
+func (m *metric) merge(other metric) {
+	if len(other.buckets.counts) != len(m.buckets.counts) {
+		return
+	}
+
+	m.value += other.value
+	m.count += other.count
+
+	for i, n := range other.buckets.counts {
+		m.buckets.counts[i] += n
+	}
+
+	if m.mtype == nativeHistogram {
+		m.native = m.native.merge(other.native)
+	}
+
+	if other.time.After(m.time) {
+		m.time = other.time
+	}
+
+	if !other.created.IsZero() && (m.created.IsZero() || other.created.Before(m.created)) {
+		m.created = other.created
+	}
+
+	if len(m.help) == 0 {
+		m.help = other.help
+	}
+
+	if len(m.unit) == 0 {
+		m.unit = other.unit
+	}
+}
+
+func sameLimits(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestHandlerMergeSnapshot(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time { return clock }
+
+	buckets := map[string][]float64{"test_latency": {1}}
+	master := &Handler{Buckets: buckets}
+	workers := []*Handler{{Buckets: buckets}, {Buckets: buckets}}
+
+	for i, w := range workers {
+		e := stats.NewEngine("test")
+		e.Register(w)
+		e.Add("requests", float64(i+1), stats.Tag{"status", "200"})
+		e.Set("conns", 2)
+		e.Observe("latency", 0.5)
+		e.Observe("latency", float64(i+1))
+	}
+
+	e := stats.NewEngine("test")
+	e.Register(master)
+	e.Incr("requests", stats.Tag{"status", "500"})
+
+	// Series with label names that don't match the first source are
+	// discarded.
+	for i, tags := range [][]stats.Tag{nil, {{"code", "42"}}} {
+		w := stats.NewEngine("test")
+		w.Register(workers[i])
+		w.Incr("errors", tags...)
+	}
+
+	merge := func(i int) {
+		b := &bytes.Buffer{}
+
+		if err := workers[i].WriteSnapshot(b); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := master.MergeSnapshot(fmt.Sprint(i), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Merging the same source twice must not count its values twice.
+	merge(0)
+	merge(1)
+	merge(1)
+
+	b := &bytes.Buffer{}
+	master.writeMetrics(b, master.collect(nil), false)
+
+	if s := b.String(); s != `# TYPE test_conns gauge
+test_conns 4
+# TYPE test_errors counter
+test_errors 1
+# TYPE test_latency histogram
+test_latency_bucket{le="1"} 3
+test_latency_bucket{le="+Inf"} 4
+test_latency_sum 4
+test_latency_count 4
+# TYPE test_requests counter
+test_requests{status="200"} 3
+test_requests{status="500"} 1
+` {
+		t.Error("bad exposition:\n" + s)
+	}
+
+	master.RemoveSnapshot("1")
+	b.Reset()
+	master.writeMetrics(b, master.collect(nil), false)
+
+	if s := b.String(); s != `# TYPE test_conns gauge
+test_conns 2
+# TYPE test_errors counter
+test_errors 1
+# TYPE test_latency histogram
+test_latency_bucket{le="1"} 2
+test_latency_bucket{le="+Inf"} 2
+test_latency_sum 1.5
+test_latency_count 2
+# TYPE test_requests counter
+test_requests{status="200"} 1
+test_requests{status="500"} 1
+` {
+		t.Error("bad exposition after removing a snapshot:\n" + s)
+	}
+}
+
+func TestHandlerMergeSnapshotInvalid(t *testing.T) {
+	h := &Handler{}
+
+	if err := h.MergeSnapshot("a", bytes.NewBufferString("garbage")); err == nil {
+		t.Error("expected an error when merging an invalid snapshot")
+	}
+
+	tests := []struct {
+		name     string
+		snapshot snapshot
+	}{
+		{
+			name:     "version",
+			snapshot: snapshot{Version: 1},
+		},
+		{
+			name: "type",
+			snapshot: snapshot{Version: snapshotVersion, Metrics: []snapshotMetric{
+				{Type: 42, Name: "test_latency"},
+			}},
+		},
+		{
+			name: "counts",
+			snapshot: snapshot{Version: snapshotVersion, Metrics: []snapshotMetric{
+				{Type: int(histogram), Name: "test_latency", Limits: []float64{1}, Counts: []uint64{1, 2}},
+			}},
+		},
+		{
+			name: "limits",
+			snapshot: snapshot{Version: snapshotVersion, Metrics: []snapshotMetric{
+				{Type: int(histogram), Name: "test_latency", Limits: []float64{2, 1}, Counts: []uint64{1, 2}},
+			}},
+		},
+		{
+			name: "quantiles",
+			snapshot: snapshot{Version: snapshotVersion, Metrics: []snapshotMetric{
+				{Type: int(summary), Name: "test_latency", Quantiles: []float64{0.5}},
+			}},
+		},
+		{
+			name: "scale",
+			snapshot: snapshot{Version: snapshotVersion, Metrics: []snapshotMetric{
+				{Type: int(nativeHistogram), Name: "test_latency", Scale: 1000},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			gob.NewEncoder(b).Encode(test.snapshot)
+
+			if err := h.MergeSnapshot("a", b); err == nil {
+				t.Error("expected an error when merging an invalid snapshot")
+			}
+		})
+	}
+
+	if len(h.snapshots.sources) != 0 {
+		t.Error("invalid snapshots were merged:", h.snapshots.sources)
+	}
+}
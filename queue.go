@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// QueueLatencyMetricName is the name of the histogram reported by engines
+	// configured to measure the delay between the time metrics are produced
+	// and the time they are flushed.
+	QueueLatencyMetricName = "stats.engine.queue_latency"
+
+	// queueLatencySamples is the maximum number of delays reported on each
+	// flush, metrics are sampled uniformly when more were produced.
+	queueLatencySamples = 1024
+)
+
+// queueLatency records the times at which metrics are produced by an engine
+// until it is flushed.
+type queueLatency struct {
+	mutex   sync.Mutex
+	count   int
+	samples []time.Time
+	rng     *rand.Rand
+}
+
+func newQueueLatency() *queueLatency {
+	return &queueLatency{
+		samples: make([]time.Time, 0, queueLatencySamples),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// enqueue records that n metrics were produced now, and returns the time.
+func (q *queueLatency) enqueue(n int) time.Time {
+	t := time.Now()
+	q.enqueueAt(t, n)
+	return t
+}
+
+func (q *queueLatency) enqueueAt(t time.Time, n int) {
+	q.mutex.Lock()
+
+	for i := 0; i != n; i++ {
+		q.count++
+
+		if len(q.samples) < cap(q.samples) {
+			q.samples = append(q.samples, t)
+		} else if j := q.rng.Intn(q.count); j < len(q.samples) {
+			q.samples[j] = t
+		}
+	}
+
+	q.mutex.Unlock()
+}
+
+// flush returns the delays between the recorded times and now, and resets
+// the state of q.
+func (q *queueLatency) flush(now time.Time) []time.Duration {
+	q.mutex.Lock()
+	delays := make([]time.Duration, len(q.samples))
+
+	for i, t := range q.samples {
+		delays[i] = now.Sub(t)
+	}
+
+	q.samples = q.samples[:0]
+	q.count = 0
+	q.mutex.Unlock()
+	return delays
+}
+
+// reportQueueLatency reports the delays of the metrics produced since the last
+// flush on the QueueLatencyMetricName histogram, the metrics are not recorded
+// in the queue latency themselves.
+func (eng *Engine) reportQueueLatency() {
+	delays := eng.queue.flush(time.Now())
+
+	if len(delays) == 0 {
+		return
+	}
+
+	e := eng.derive(eng.name, eng.tags)
+	e.queue = nil
+
+	for _, d := range delays {
+		e.ObserveDuration(QueueLatencyMetricName, d)
+	}
+}
@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngineQueueLatency(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:         "E",
+		QueueLatency: true,
+	})
+	e.Register(h)
+
+	e.Incr("A")
+	e.WithTags(Tag{"a", "b"}).IncrAndObserve("B", "C", 1)
+
+	b := e.Batch()
+	b.Incr("D")
+	b.CommitAt(time.Now().Add(-time.Second))
+
+	e.Flush()
+	e.Flush() // nothing was produced since the last flush
+
+	if len(h.metrics) != 8 {
+		t.Fatal("bad number of metrics:", len(h.metrics))
+	}
+
+	for i, m := range h.metrics[4:] {
+		if m.Name != QueueLatencyMetricName || m.Type != HistogramType {
+			t.Error("bad queue latency metric:", m)
+		}
+
+		if i == 3 && m.Value < 1 {
+			t.Error("bad queue latency of the batch:", m.Value)
+		}
+	}
+}
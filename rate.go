@@ -0,0 +1,218 @@
+package stats
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateSuffix is the default suffix appended to the names of rate
+	// metrics produced by rate handlers.
+	DefaultRateSuffix = ".rate"
+
+	// DefaultRateInterval is the default interval at which rate handlers are
+	// expected to be flushed.
+	DefaultRateInterval = 1 * time.Second
+)
+
+// The RateHandlerConfig type is used to configure rate handlers.
+type RateHandlerConfig struct {
+	// Interval is the interval at which the handler is flushed, the sums of
+	// counters are divided by this value to produce per-second rates.
+	// Defaults to DefaultRateInterval.
+	Interval time.Duration
+
+	// Suffix is appended to the names of counters to form the names of the
+	// rate metrics, defaults to DefaultRateSuffix.
+	Suffix string
+
+	// Counters is the list of counter names for which rates are produced.
+	Counters []string
+}
+
+type rateHandler struct {
+	handler  Handler
+	interval float64
+	suffix   string
+	counters map[string]struct{}
+	mutex    sync.Mutex
+	rates    map[string]*rateEntry
+}
+
+type rateEntry struct {
+	namespace string
+	name      string
+	tags      []Tag
+	sum       float64
+}
+
+// NewRateHandler returns a handler which passes the metrics it receives to
+// handler and, for the counters listed in the configuration, reports gauges
+// carrying the per-second rate of the counters every time it is flushed.
+//
+// Rates are computed by dividing the sum of the counter increments seen since
+// the last flush by the configured interval, so they are aligned on the flush
+// interval and can be displayed as-is by tools that don't compute rates. The
+// engine the handler is registered on must be flushed at this interval for
+// the rates to be accurate.
+func NewRateHandler(handler Handler, config RateHandlerConfig) Handler {
+	if len(config.Suffix) == 0 {
+		config.Suffix = DefaultRateSuffix
+	}
+
+	if config.Interval < 0 {
+		log.Printf("stats: ignoring negative rate interval: %s", config.Interval)
+		config.Interval = 0
+	}
+
+	if config.Interval == 0 {
+		config.Interval = DefaultRateInterval
+	}
+
+	h := &rateHandler{
+		handler:  handler,
+		interval: config.Interval.Seconds(),
+		suffix:   config.Suffix,
+		counters: make(map[string]struct{}, len(config.Counters)),
+		rates:    make(map[string]*rateEntry),
+	}
+
+	for _, name := range config.Counters {
+		h.counters[name] = struct{}{}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *rateHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *rateHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *rateHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe adds the increment of the counter of m to its rate.
+func (h *rateHandler) observe(m *Metric) {
+	if m.Type != CounterType {
+		return
+	}
+
+	if _, ok := h.counters[m.Name]; !ok {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+
+	h.mutex.Lock()
+
+	e := h.rates[key]
+	if e == nil {
+		e = &rateEntry{
+			namespace: m.Namespace,
+			name:      m.Name + h.suffix,
+			tags:      tags,
+		}
+		h.rates[key] = e
+	}
+	e.sum += m.Value
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+//
+// Counters that didn't change since the last flush are reported with a rate
+// of zero, then forgotten until they change again.
+func (h *rateHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *rateHandler) FlushContext(ctx context.Context) {
+	now := time.Now()
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.rates))
+	rates := make([]Metric, 0, len(h.rates))
+
+	for key := range h.rates {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := h.rates[key]
+
+		if e.sum == 0 {
+			delete(h.rates, key)
+		}
+
+		rates = append(rates, Metric{
+			Type:      GaugeType,
+			Namespace: e.namespace,
+			Name:      e.name,
+			Tags:      e.tags,
+			Value:     e.sum / h.interval,
+			Time:      now,
+		})
+
+		e.sum = 0
+	}
+
+	h.mutex.Unlock()
+
+	for i := range rates {
+		h.handler.HandleMetric(&rates[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *rateHandler) Reset() {
+	h.mutex.Lock()
+	h.rates = make(map[string]*rateEntry)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+func rateKey(namespace string, name string, tags []Tag) string {
+	b := &strings.Builder{}
+	b.WriteString(namespace)
+	b.WriteByte(0)
+	b.WriteString(name)
+
+	for _, t := range tags {
+		b.WriteByte(0)
+		b.WriteString(t.Name)
+		b.WriteByte('=')
+		b.WriteString(t.Value)
+	}
+
+	return b.String()
+}
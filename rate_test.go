@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateHandler(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(NewRateHandler(h, RateHandlerConfig{
+		Interval: 10 * time.Second,
+		Counters: []string{"requests"},
+	}))
+
+	e.Add("requests", 10, Tag{"status", "ok"})
+	e.Add("requests", 20, Tag{"status", "ok"})
+	e.Incr("errors")
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"status", "ok"}}, Value: 10},
+		{Type: CounterType, Namespace: "E", Name: "requests", Tags: []Tag{{"status", "ok"}}, Value: 20},
+		{Type: CounterType, Namespace: "E", Name: "errors", Value: 1},
+		{Type: GaugeType, Namespace: "E", Name: "requests.rate", Tags: []Tag{{"status", "ok"}}, Value: 3},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if h.flushed != 1 {
+		t.Error("the rate handler did not flush the underlying handler")
+	}
+
+	h.metrics = nil
+	e.Flush()
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "requests.rate", Tags: []Tag{{"status", "ok"}}, Value: 0},
+	}) {
+		t.Error("bad metrics after flushing idle counters:", h.metrics)
+	}
+}
+
+func TestRateHandlerDefaultInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		h := &handler{}
+		e := NewEngine("E")
+		e.Register(NewRateHandler(h, RateHandlerConfig{
+			Interval: interval,
+			Counters: []string{"requests"},
+		}))
+
+		e.Add("requests", 5)
+		e.Flush()
+
+		if !reflect.DeepEqual(h.metrics, []Metric{
+			{Type: CounterType, Namespace: "E", Name: "requests", Value: 5},
+			{Type: GaugeType, Namespace: "E", Name: "requests.rate", Value: 5},
+		}) {
+			t.Errorf("bad metrics with an interval of %s: %v", interval, h.metrics)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GaugeRefresh configures the refresh interval of a gauge reported to a
+// handler returned by NewGaugeRefreshHandler.
+type GaugeRefresh struct {
+	// Name is the name of the gauge that the interval applies to.
+	Name string
+
+	// Interval is the duration after which a series of the gauge that was not
+	// reported is reported again with its last value.
+	Interval time.Duration
+}
+
+type gaugeRefreshHandler struct {
+	handler Handler
+	gauges  map[string]GaugeRefresh
+	now     func() time.Time
+	mutex   sync.Mutex
+	entries map[string]*gaugeRefreshEntry
+}
+
+type gaugeRefreshEntry struct {
+	namespace string
+	name      string
+	tags      []Tag
+	unit      string
+	value     float64
+	last      time.Time
+}
+
+// NewGaugeRefreshHandler returns a handler which passes the metrics it receives
+// to handler and remembers the last value of each series of the listed gauges.
+//
+// Every time the handler is flushed, series that were not reported for longer
+// than the interval of their gauge are reported again to handler with their
+// last value and the current time. This keeps gauges that legitimately stay
+// constant, like configuration values, from being considered stale by
+// backends which expect series to be updated regularly. It is the complement
+// of NewGaugeExpiryHandler, which resets gauges that stopped being set.
+//
+// Series are remembered until the handler is reset, only gauges that are
+// expected to live as long as the program should be refreshed.
+func NewGaugeRefreshHandler(handler Handler, gauges ...GaugeRefresh) Handler {
+	h := &gaugeRefreshHandler{
+		handler: handler,
+		gauges:  make(map[string]GaugeRefresh, len(gauges)),
+		now:     time.Now,
+		entries: make(map[string]*gaugeRefreshEntry),
+	}
+
+	for _, g := range gauges {
+		h.gauges[g.Name] = g
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *gaugeRefreshHandler) HandleMetric(m *Metric) {
+	h.handler.HandleMetric(m)
+	h.observe(m)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *gaugeRefreshHandler) HandleMetrics(metrics []*Metric) {
+	handleMetrics(h.handler, metrics)
+
+	for _, m := range metrics {
+		h.observe(m)
+	}
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (h *gaugeRefreshHandler) DescribeMetric(schema MetricSchema) {
+	describeMetric(h.handler, schema)
+}
+
+// observe records the value of the gauge of m and the time it was set.
+func (h *gaugeRefreshHandler) observe(m *Metric) {
+	if m.Type != GaugeType {
+		return
+	}
+
+	if _, ok := h.gauges[m.Name]; !ok {
+		return
+	}
+
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+	now := h.now()
+
+	h.mutex.Lock()
+
+	e := h.entries[key]
+	if e == nil {
+		e = &gaugeRefreshEntry{
+			namespace: m.Namespace,
+			name:      m.Name,
+			tags:      tags,
+		}
+		h.entries[key] = e
+	}
+	e.unit = m.Unit
+	e.value = m.Value
+	e.last = now
+
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the Flusher interface.
+func (h *gaugeRefreshHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *gaugeRefreshHandler) FlushContext(ctx context.Context) {
+	now := h.now()
+
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.entries))
+	refreshes := make([]Metric, 0, len(h.entries))
+
+	for key, e := range h.entries {
+		if now.Sub(e.last) >= h.gauges[e.name].Interval {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		e := h.entries[key]
+		e.last = now
+
+		refreshes = append(refreshes, Metric{
+			Type:      GaugeType,
+			Namespace: e.namespace,
+			Name:      e.name,
+			Tags:      e.tags,
+			Value:     e.value,
+			Time:      now,
+			Unit:      e.unit,
+		})
+	}
+
+	h.mutex.Unlock()
+
+	for i := range refreshes {
+		h.handler.HandleMetric(&refreshes[i])
+	}
+
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *gaugeRefreshHandler) Reset() {
+	h.mutex.Lock()
+	h.entries = make(map[string]*gaugeRefreshEntry)
+	h.mutex.Unlock()
+
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGaugeRefreshHandler(t *testing.T) {
+	now := time.Now()
+	h := &handler{}
+	x := NewGaugeRefreshHandler(h,
+		GaugeRefresh{Name: "config.workers", Interval: 10 * time.Second},
+		GaugeRefresh{Name: "config.version", Interval: 20 * time.Second},
+	)
+	x.(*gaugeRefreshHandler).now = func() time.Time { return now }
+
+	e := NewEngine("E")
+	e.Register(x)
+
+	e.Set("config.workers", 4, Tag{"pool", "A"})
+	e.Set("config.workers", 8, Tag{"pool", "B"})
+	e.Set("config.version", 3)
+	e.Set("conns", 6)
+
+	now = now.Add(5 * time.Second)
+	e.Set("config.workers", 2, Tag{"pool", "B"})
+	e.Flush()
+	h.Reset()
+
+	now = now.Add(5 * time.Second)
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "config.workers", Tags: []Tag{{"pool", "A"}}, Value: 4},
+	}) {
+		t.Error("bad metrics after the first interval:", h.metrics)
+	}
+
+	h.Reset()
+	now = now.Add(5 * time.Second)
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "config.workers", Tags: []Tag{{"pool", "B"}}, Value: 2},
+	}) {
+		t.Error("bad metrics after the second interval:", h.metrics)
+	}
+
+	h.Reset()
+	now = now.Add(10 * time.Second)
+	e.Flush()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "config.version", Value: 3},
+		{Type: GaugeType, Namespace: "E", Name: "config.workers", Tags: []Tag{{"pool", "A"}}, Value: 4},
+		{Type: GaugeType, Namespace: "E", Name: "config.workers", Tags: []Tag{{"pool", "B"}}, Value: 2},
+	}) {
+		t.Error("bad metrics after the third interval:", h.metrics)
+	}
+
+	h.Reset()
+	e.Flush()
+
+	if len(h.metrics) != 0 {
+		t.Error("gauges were refreshed before their interval elapsed:", h.metrics)
+	}
+
+	if h.flushed != 5 {
+		t.Error("the gauge refresh handler did not flush the underlying handler")
+	}
+}
@@ -0,0 +1,141 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Registry holds the definitions of metrics made independently of the engine
+// that they are eventually reported on.
+//
+// Libraries define their metrics on a registry, usually a package variable,
+// without knowing the engine of the application they are used in, and the
+// application binds the registry to its engine at startup:
+//
+//	// In the library.
+//	var Metrics = stats.NewRegistry()
+//
+//	var requests = Metrics.Engine().Counter("mylib.requests")
+//
+//	// In the application.
+//	if issues := mylib.Metrics.Validate(stats.ValidatorConfig{}); len(issues) != 0 {
+//		...
+//	}
+//	mylib.Metrics.Bind(stats.DefaultEngine)
+//
+// Metrics produced on the engine of the registry are reported on the engines
+// it is bound to, with their names, tags, and policies. Metrics produced
+// before the registry is bound to any engine are discarded.
+type Registry struct {
+	eng *Engine
+}
+
+// NewRegistry creates and returns a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{eng: NewEngine("")}
+}
+
+// Engine returns the engine that the metrics of the registry must be created
+// on, it has no name and no tags.
+func (r *Registry) Engine() *Engine {
+	return r.eng
+}
+
+// Describe declares a metric on the registry, see Engine.Describe.
+func (r *Registry) Describe(typ MetricType, name string, help string, unit string, keys ...string) {
+	r.eng.Describe(typ, name, help, unit, keys...)
+}
+
+// DefineMetrics defines the metrics of the struct pointed to by metrics on the
+// registry, see Engine.DefineMetrics.
+func (r *Registry) DefineMetrics(metrics interface{}) error {
+	return r.eng.DefineMetrics(metrics)
+}
+
+// Definitions returns the list of metrics declared or produced on the
+// registry, sorted by name.
+func (r *Registry) Definitions() []MetricSchema {
+	return r.eng.Schema()
+}
+
+// Validate checks the definitions of the registry against config and returns
+// the issues it detected, sorted by metric name and kind of issue. Only the
+// limits on the lengths of names and tags apply to definitions, and the tag
+// values are not checked since definitions only list tag names.
+func (r *Registry) Validate(config ValidatorConfig) []ValidationIssue {
+	var issues []ValidationIssue
+	types := make(map[string]MetricType)
+
+	report := func(s MetricSchema, kind ValidationIssueKind, tag string, msg string) {
+		issues = append(issues, ValidationIssue{
+			Kind:    kind,
+			Name:    s.Name,
+			Tag:     tag,
+			Message: msg,
+		})
+	}
+
+	for _, s := range r.Definitions() {
+		if !validName(s.Name) {
+			report(s, InvalidName, "", fmt.Sprintf("invalid metric name %q", s.Name))
+		}
+
+		if max := config.MaxNameLength; max != 0 && len(s.Name) > max {
+			report(s, NameTooLong, "", fmt.Sprintf("name of %d bytes exceeds the limit of %d", len(s.Name), max))
+		}
+
+		if max := config.MaxTags; max != 0 && len(s.TagKeys) > max {
+			report(s, TooManyTags, "", fmt.Sprintf("%d tags exceed the limit of %d", len(s.TagKeys), max))
+		}
+
+		for _, key := range s.TagKeys {
+			if !validName(key) {
+				report(s, InvalidTag, key, fmt.Sprintf("invalid tag name %q", key))
+			}
+
+			if max := config.MaxTagLength; max != 0 && len(key) > max {
+				report(s, TagTooLong, key, fmt.Sprintf("tag %s exceeds the length limit of %d", key, max))
+			}
+		}
+
+		if typ, ok := types[s.Name]; !ok {
+			types[s.Name] = s.Type
+		} else if typ != s.Type {
+			report(s, TypeConflict, "", fmt.Sprintf("defined as %s and %s", typ, s.Type))
+		}
+	}
+
+	sort.SliceStable(issues, func(i int, j int) bool {
+		if a, b := issues[i], issues[j]; a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return issues[i].Kind < issues[j].Kind
+	})
+
+	return issues
+}
+
+// Bind starts reporting the metrics of the registry on eng, and declares the
+// metrics described on the registry on eng. A registry may be bound to multiple
+// engines, the metrics are then reported on all of them.
+func (r *Registry) Bind(eng *Engine) {
+	r.eng.Register(&registryBinding{eng: eng})
+}
+
+// registryBinding is the handler reporting the metrics of a registry on an
+// engine it was bound to.
+type registryBinding struct {
+	eng *Engine
+}
+
+// HandleMetric satisfies the Handler interface.
+func (b *registryBinding) HandleMetric(m *Metric) {
+	b.eng.handle(m.Type, m.Name, m.Value, m.Unit, m.Tags, m.Time, m.Expires, nil)
+}
+
+// DescribeMetric satisfies the Describer interface.
+func (b *registryBinding) DescribeMetric(s MetricSchema) {
+	s.Namespace = b.eng.name
+	s.TagKeys = append(tagKeys(b.eng.tags), s.TagKeys...)
+	b.eng.describe(s)
+}
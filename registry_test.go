@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Describe(CounterType, "lib.requests", "Number of requests.", "", "status")
+	requests := r.Engine().Counter("lib.requests")
+
+	var metrics struct {
+		Conns *Gauge `stats:"lib.conns,help=Number of connections."`
+	}
+
+	if err := r.DefineMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	// Metrics produced before binding are discarded.
+	requests.Incr()
+
+	h := &handler{}
+	e := NewEngine("app", Tag{"region", "us"})
+	e.Register(h)
+	r.Bind(e)
+
+	requests.WithTags(Tag{"status", "ok"}).Incr()
+	metrics.Conns.Set(2)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "app",
+			Name:      "lib.requests",
+			Value:     1,
+			Tags:      []Tag{{"region", "us"}, {"status", "ok"}},
+		},
+		{
+			Type:      GaugeType,
+			Namespace: "app",
+			Name:      "lib.conns",
+			Value:     2,
+			Tags:      []Tag{{"region", "us"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if schema := e.Schema(); !reflect.DeepEqual(schema, []MetricSchema{
+		{
+			Type:      GaugeType,
+			Namespace: "app",
+			Name:      "lib.conns",
+			Help:      "Number of connections.",
+			TagKeys:   []string{"region"},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "app",
+			Name:      "lib.requests",
+			Help:      "Number of requests.",
+			TagKeys:   []string{"region", "status"},
+		},
+	}) {
+		t.Error("bad schema of the bound engine:", schema)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	r := NewRegistry()
+	r.Describe(CounterType, "requests", "", "", "status")
+	r.Describe(GaugeType, "requests", "", "", "status")
+	r.Describe(GaugeType, "bad name", "", "")
+	r.Describe(HistogramType, "latency", "", "", "a-very-long-tag-name")
+
+	issues := r.Validate(ValidatorConfig{MaxTagLength: 10})
+	kinds := make([]ValidationIssueKind, len(issues))
+
+	for i, issue := range issues {
+		kinds[i] = issue.Kind
+	}
+
+	if !reflect.DeepEqual(kinds, []ValidationIssueKind{InvalidName, TagTooLong, TypeConflict}) {
+		t.Error("bad issues:", issues)
+	}
+}
@@ -0,0 +1,303 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// RelabelAction is an enumeration of the actions that relabeling rules can
+// apply to metrics.
+type RelabelAction int
+
+const (
+	// RelabelReplace sets the target of the rule to the expanded replacement
+	// when the source matches the rule's regular expression.
+	RelabelReplace RelabelAction = iota
+
+	// RelabelKeep discards metrics where the source does not match the rule's
+	// regular expression.
+	RelabelKeep
+
+	// RelabelDrop discards metrics where the source matches the rule's regular
+	// expression.
+	RelabelDrop
+
+	// RelabelRename renames the tags with names matching the rule's regular
+	// expression to the expanded replacement.
+	RelabelRename
+
+	// RelabelDropTag removes the tags with names matching the rule's regular
+	// expression.
+	RelabelDropTag
+)
+
+// String satisfies the fmt.Stringer interface.
+func (a RelabelAction) String() string {
+	switch a {
+	case RelabelReplace:
+		return "replace"
+	case RelabelKeep:
+		return "keep"
+	case RelabelDrop:
+		return "drop"
+	case RelabelRename:
+		return "rename"
+	case RelabelDropTag:
+		return "droptag"
+	default:
+		return "unknown"
+	}
+}
+
+// RelabelName is the label name used by relabeling rules to refer to the name
+// of metrics instead of one of their tags.
+const RelabelName = "__name__"
+
+// RelabelRule represents a rule applied to metrics by a relabeling handler,
+// it mirrors the relabel_configs of Prometheus.
+type RelabelRule struct {
+	// Action is the action taken by the rule, defaults to RelabelReplace.
+	Action RelabelAction
+
+	// Source is the name of the tag that the rule's regular expression is
+	// matched against, RelabelName refers to the metric name and is used when
+	// Source is empty.
+	Source string
+
+	// Regex is the regular expression matched by the rule, it is anchored on
+	// both ends and defaults to "(.*)".
+	Regex string
+
+	// Target is the name of the tag set by RelabelReplace rules, RelabelName
+	// refers to the metric name.
+	Target string
+
+	// Replacement is the value set by RelabelReplace and RelabelRename rules,
+	// it may refer to capture groups of the regular expression and defaults
+	// to "$1".
+	Replacement string
+}
+
+type relabelRule struct {
+	RelabelRule
+	regex *regexp.Regexp
+}
+
+type relabelHandler struct {
+	handler Handler
+	rules   []relabelRule
+}
+
+// NewRelabelHandler returns a handler which applies rules in order to the
+// metrics it receives before passing them to handler.
+//
+// This is useful to rename or drop metrics and tags produced by third-party
+// instrumentation when exposing them, without changing the instrumentation
+// itself. An error is returned if one of the rules is invalid.
+func NewRelabelHandler(handler Handler, rules ...RelabelRule) (Handler, error) {
+	h := &relabelHandler{
+		handler: handler,
+		rules:   make([]relabelRule, len(rules)),
+	}
+
+	for i, r := range rules {
+		if len(r.Source) == 0 {
+			r.Source = RelabelName
+		}
+
+		if len(r.Regex) == 0 {
+			r.Regex = "(.*)"
+		}
+
+		if len(r.Replacement) == 0 {
+			r.Replacement = "$1"
+		}
+
+		switch r.Action {
+		case RelabelReplace:
+			if len(r.Target) == 0 {
+				return nil, fmt.Errorf("stats: relabeling rule at index %d has no target", i)
+			}
+		case RelabelKeep, RelabelDrop, RelabelRename, RelabelDropTag:
+		default:
+			return nil, fmt.Errorf("stats: relabeling rule at index %d has an unknown action: %d", i, r.Action)
+		}
+
+		regex, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("stats: relabeling rule at index %d has an invalid regular expression: %s", i, err)
+		}
+
+		h.rules[i] = relabelRule{RelabelRule: r, regex: regex}
+	}
+
+	return h, nil
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *relabelHandler) HandleMetric(m *Metric) {
+	h.handle(m, h.handler)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *relabelHandler) HandleMetrics(metrics []*Metric) {
+	forwardMetrics(h.handler, metrics, h.handle)
+}
+
+// DescribeMetric satisfies the Describer interface, the schema is passed to the
+// wrapped handler under the name produced by the rules, unless they drop it.
+func (h *relabelHandler) DescribeMetric(schema MetricSchema) {
+	if name, ok := h.relabelName(schema.Name); ok {
+		schema.Name = name
+		describeMetric(h.handler, schema)
+	}
+}
+
+// handle passes the metrics produced from m to next.
+func (h *relabelHandler) handle(m *Metric, next Handler) {
+	c := metricPool.Get().(*Metric)
+	*c = Metric{
+		Type:      m.Type,
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		Tags:      append(c.Tags[:0], m.Tags...),
+		Value:     m.Value,
+		Time:      m.Time,
+		Unit:      m.Unit,
+		Rate:      m.Rate,
+		Expires:   m.Expires,
+		Exemplar:  m.Exemplar,
+	}
+
+	if h.relabel(c) {
+		next.HandleMetric(c)
+	}
+
+	c.Namespace = ""
+	c.Name = ""
+	c.Tags = c.Tags[:0]
+	metricPool.Put(c)
+}
+
+// Flush satisfies the Flusher interface.
+func (h *relabelHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *relabelHandler) FlushContext(ctx context.Context) {
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *relabelHandler) Reset() {
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+func (h *relabelHandler) relabel(m *Metric) bool {
+	for _, r := range h.rules {
+		switch r.Action {
+		case RelabelKeep:
+			if !r.regex.MatchString(relabelGet(m, r.Source)) {
+				return false
+			}
+
+		case RelabelDrop:
+			if r.regex.MatchString(relabelGet(m, r.Source)) {
+				return false
+			}
+
+		case RelabelReplace:
+			value := relabelGet(m, r.Source)
+			if match := r.regex.FindStringSubmatchIndex(value); match != nil {
+				relabelSet(m, r.Target, string(r.regex.ExpandString(nil, r.Replacement, value, match)))
+			}
+
+		case RelabelRename:
+			for i, t := range m.Tags {
+				if match := r.regex.FindStringSubmatchIndex(t.Name); match != nil {
+					m.Tags[i].Name = string(r.regex.ExpandString(nil, r.Replacement, t.Name, match))
+				}
+			}
+
+		case RelabelDropTag:
+			tags := m.Tags[:0]
+			for _, t := range m.Tags {
+				if !r.regex.MatchString(t.Name) {
+					tags = append(tags, t)
+				}
+			}
+			m.Tags = tags
+		}
+	}
+
+	return len(m.Name) != 0
+}
+
+// relabelName applies the rules matching on metric names to name, it returns
+// false if the metric is dropped. The rules matching on tags are ignored since
+// schemas carry no tag values.
+func (h *relabelHandler) relabelName(name string) (string, bool) {
+	for _, r := range h.rules {
+		if r.Source != RelabelName {
+			continue
+		}
+
+		switch r.Action {
+		case RelabelKeep:
+			if !r.regex.MatchString(name) {
+				return "", false
+			}
+
+		case RelabelDrop:
+			if r.regex.MatchString(name) {
+				return "", false
+			}
+
+		case RelabelReplace:
+			if r.Target != RelabelName {
+				continue
+			}
+			if match := r.regex.FindStringSubmatchIndex(name); match != nil {
+				name = string(r.regex.ExpandString(nil, r.Replacement, name, match))
+			}
+		}
+	}
+
+	return name, len(name) != 0
+}
+
+func relabelGet(m *Metric, name string) string {
+	if name == RelabelName {
+		return m.Name
+	}
+
+	for _, t := range m.Tags {
+		if t.Name == name {
+			return t.Value
+		}
+	}
+
+	return ""
+}
+
+func relabelSet(m *Metric, name string, value string) {
+	if name == RelabelName {
+		m.Name = value
+		return
+	}
+
+	for i, t := range m.Tags {
+		if t.Name == name {
+			m.Tags[i].Value = value
+			return
+		}
+	}
+
+	m.Tags = append(m.Tags, Tag{name, value})
+}
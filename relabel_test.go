@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelabelHandler(t *testing.T) {
+	h := &handler{}
+	r, err := NewRelabelHandler(h,
+		RelabelRule{Action: RelabelDrop, Regex: "debug\\..*"},
+		RelabelRule{Action: RelabelKeep, Source: "env", Regex: "prod|staging"},
+		RelabelRule{Regex: "thirdparty_(.*)", Target: RelabelName},
+		RelabelRule{Source: "host", Regex: "([^.]+)\\..*", Target: "host"},
+		RelabelRule{Action: RelabelRename, Regex: "http_(.*)", Replacement: "$1"},
+		RelabelRule{Action: RelabelDropTag, Regex: "user_id"},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine("E")
+	e.Register(r)
+
+	e.Incr("debug.calls", Tag{"env", "prod"})
+	e.Incr("requests", Tag{"env", "dev"})
+	e.Incr("thirdparty_requests", Tag{"env", "prod"}, Tag{"host", "web1.example.com"}, Tag{"user_id", "42"})
+	e.Set("http_conns", 1, Tag{"env", "staging"}, Tag{"http_method", "GET"})
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Value:     1,
+			Tags:      []Tag{{"env", "prod"}, {"host", "web1"}},
+		},
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "http_conns",
+			Value:     1,
+			Tags:      []Tag{{"env", "staging"}, {"method", "GET"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestRelabelHandlerExemplar(t *testing.T) {
+	h := &handler{}
+	r, err := NewRelabelHandler(h, RelabelRule{Action: RelabelRename, Regex: "http_(.*)", Replacement: "$1"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine("E")
+	e.Register(r)
+	e.ObserveWithExemplar("http_latency", 0.5, []Tag{{"trace_id", "1234"}})
+
+	if len(h.metrics) != 1 || !reflect.DeepEqual(h.metrics[0].Exemplar, []Tag{{"trace_id", "1234"}}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestRelabelHandlerInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RelabelRule
+	}{
+		{
+			name: "missing target",
+			rule: RelabelRule{Action: RelabelReplace},
+		},
+		{
+			name: "unknown action",
+			rule: RelabelRule{Action: RelabelAction(-1)},
+		},
+		{
+			name: "invalid regex",
+			rule: RelabelRule{Action: RelabelDrop, Regex: "("},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewRelabelHandler(&handler{}, test.rule); err == nil {
+				t.Error("expected an error for an invalid relabeling rule")
+			}
+		})
+	}
+}
+
+func TestRelabelHandlerFlush(t *testing.T) {
+	h := &handler{}
+	r, _ := NewRelabelHandler(h)
+	r.(Flusher).Flush()
+
+	if h.flushed != 1 {
+		t.Error("the relabeling handler did not flush the underlying handler")
+	}
+}
+
+func TestRelabelHandlerDescribe(t *testing.T) {
+	h := &describeHandler{}
+	r, _ := NewRelabelHandler(h,
+		RelabelRule{Action: RelabelDrop, Regex: "internal_.*"},
+		RelabelRule{Target: RelabelName, Regex: "(.*)_total", Replacement: "${1}_count"},
+		RelabelRule{Action: RelabelKeep, Source: "env", Regex: "prod"},
+	)
+
+	r.(Describer).DescribeMetric(MetricSchema{Name: "internal_calls", Help: "dropped"})
+	r.(Describer).DescribeMetric(MetricSchema{Name: "calls_total", Help: "renamed"})
+
+	if len(h.schemas) != 1 || h.schemas[0].Name != "calls_count" {
+		t.Error("bad schemas passed to the wrapped handler:", h.schemas)
+	}
+}
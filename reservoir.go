@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reservoir records a uniform sample of the values inserted in it, and is used
+// by handlers to compute approximate percentiles of histograms.
+//
+// The reservoir retains all values until it reaches its size, percentiles are
+// then exact. Past the size, values are sampled with Vitter's algorithm R so
+// every inserted value has the same probability of being retained. The count,
+// sum, min and max are always exact, a reservoir of size zero retains no values
+// and only tracks them.
+//
+// Reservoir values are not safe to use concurrently.
+type Reservoir struct {
+	size   int
+	rng    *rand.Rand
+	values []float64
+	sorted bool
+	count  int64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// NewReservoir returns a reservoir retaining up to size values, sampled with
+// the random numbers of rng. The rng may be shared by reservoirs which are
+// used under the same lock, and must not be nil when size is positive.
+func NewReservoir(size int, rng *rand.Rand) *Reservoir {
+	return &Reservoir{size: size, rng: rng}
+}
+
+// Insert adds value to the reservoir.
+func (r *Reservoir) Insert(value float64) {
+	r.InsertN(value, 1)
+}
+
+// InsertN adds n occurrences of value to the reservoir, which is how sampled
+// metrics are recorded, see Metric.SampleCount. Each occurrence has the same
+// probability of being retained as the other values, the count and sum are
+// updated in constant time and at most size values are replaced.
+func (r *Reservoir) InsertN(value float64, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	if r.count == 0 || value < r.min {
+		r.min = value
+	}
+
+	if r.count == 0 || value > r.max {
+		r.max = value
+	}
+
+	seen := r.count
+	r.count += n
+	r.sum += value * float64(n)
+
+	for ; n != 0 && len(r.values) < r.size; n-- {
+		r.values = append(r.values, value)
+		r.sorted = false
+		seen++
+	}
+
+	if n == 0 {
+		return
+	}
+
+	if n < int64(len(r.values)) {
+		for ; n != 0; n-- {
+			seen++
+			if j := r.rng.Int63n(seen); j < int64(len(r.values)) {
+				r.values[j] = value
+				r.sorted = false
+			}
+		}
+		return
+	}
+
+	// With algorithm R each retained value survives the n occurrences with
+	// probability seen/(seen+n), so the values are replaced independently
+	// with the complementary probability instead of drawing n times.
+	p := float64(n) / float64(seen+n)
+
+	for i := range r.values {
+		if r.rng.Float64() < p {
+			r.values[i] = value
+			r.sorted = false
+		}
+	}
+}
+
+// Count returns the number of values inserted in the reservoir.
+func (r *Reservoir) Count() int64 {
+	return r.count
+}
+
+// Sum returns the sum of the values inserted in the reservoir.
+func (r *Reservoir) Sum() float64 {
+	return r.sum
+}
+
+// Min returns the smallest value inserted in the reservoir, or zero when it is
+// empty.
+func (r *Reservoir) Min() float64 {
+	return r.min
+}
+
+// Max returns the largest value inserted in the reservoir, or zero when it is
+// empty.
+func (r *Reservoir) Max() float64 {
+	return r.max
+}
+
+// Query returns the value at the quantile q (between 0 and 1) of the sample
+// using the nearest-rank method. NaN is returned when no values are retained.
+func (r *Reservoir) Query(q float64) float64 {
+	if len(r.values) == 0 {
+		return math.NaN()
+	}
+
+	if !r.sorted {
+		sort.Float64s(r.values)
+		r.sorted = true
+	}
+
+	i := int(math.Ceil(q*float64(len(r.values)))) - 1
+
+	if i < 0 {
+		i = 0
+	}
+
+	if i >= len(r.values) {
+		i = len(r.values) - 1
+	}
+
+	return r.values[i]
+}
+
+// Reset discards the values inserted in the reservoir, its sample is retained
+// for the values inserted next.
+func (r *Reservoir) Reset() {
+	r.values = r.values[:0]
+	r.sorted = false
+	r.count = 0
+	r.sum = 0
+	r.min = 0
+	r.max = 0
+}
+
+// PercentileName returns the name used by handlers to report the quantile p
+// (between 0 and 1) as a percentile, for example 0.5 is reported as "p50" and
+// 0.999 as "p99.9". Percentiles are rounded to three decimals.
+func PercentileName(p float64) string {
+	s := strconv.FormatFloat(p*100, 'f', -1, 64)
+
+	if strings.IndexByte(s, '.') >= 0 && len(s) > 6 {
+		s = strconv.FormatFloat(p*100, 'f', 3, 64)
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+
+	return "p" + s
+}
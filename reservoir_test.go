@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestReservoirQuery(t *testing.T) {
+	r := NewReservoir(100, rand.New(rand.NewSource(1)))
+
+	if v := r.Query(0.5); !math.IsNaN(v) {
+		t.Error("an empty reservoir did not return NaN:", v)
+	}
+
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		r.Insert(v)
+	}
+
+	if r.Count() != 5 || r.Sum() != 15 || r.Min() != 1 || r.Max() != 5 {
+		t.Error("bad count, sum, min or max:", r.Count(), r.Sum(), r.Min(), r.Max())
+	}
+
+	for q, v := range map[float64]float64{0: 1, 0.5: 3, 0.8: 4, 1: 5} {
+		if x := r.Query(q); x != v {
+			t.Errorf("quantile %g: %g != %g", q, x, v)
+		}
+	}
+
+	r.Reset()
+
+	if r.Count() != 0 || r.Sum() != 0 || !math.IsNaN(r.Query(0.5)) {
+		t.Error("the reservoir was not reset")
+	}
+}
+
+func TestReservoirInsertN(t *testing.T) {
+	r := NewReservoir(100, rand.New(rand.NewSource(1)))
+	r.InsertN(1, 10)
+	r.InsertN(2, 1e9)
+
+	if r.Count() != 1e9+10 || r.Sum() != 2e9+10 {
+		t.Error("bad count or sum:", r.Count(), r.Sum())
+	}
+
+	if len(r.values) != 100 {
+		t.Fatal("bad number of retained values:", len(r.values))
+	}
+
+	// The values inserted 10 times among a billion occurrences are expected
+	// to be all replaced.
+	if v := r.Query(0.01); v != 2 {
+		t.Error("the sample is not representative of the inserted values:", r.values)
+	}
+}
+
+func TestReservoirSizeZero(t *testing.T) {
+	r := NewReservoir(0, nil)
+	r.InsertN(3, 2)
+	r.Insert(1)
+
+	if r.Count() != 3 || r.Sum() != 7 || r.Min() != 1 || r.Max() != 3 {
+		t.Error("bad count, sum, min or max:", r.Count(), r.Sum(), r.Min(), r.Max())
+	}
+
+	if len(r.values) != 0 {
+		t.Error("a reservoir of size zero retained values:", r.values)
+	}
+}
+
+func TestPercentileName(t *testing.T) {
+	tests := []struct {
+		p float64
+		s string
+	}{
+		{0.5, "p50"},
+		{0.95, "p95"},
+		{0.99, "p99"},
+		{0.999, "p99.9"},
+		{1.0 / 3, "p33.333"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := PercentileName(test.p); s != test.s {
+				t.Error(s)
+			}
+		})
+	}
+}
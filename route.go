@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+)
+
+// The RouteHandlerConfig type is used to configure routing handlers.
+type RouteHandlerConfig struct {
+	// Tag is the name of the tag that metrics are routed on.
+	Tag string
+
+	// Routes maps values of the routing tag to the handlers that metrics
+	// carrying these values are passed to.
+	Routes map[string]Handler
+
+	// Default is the handler that metrics are passed to when they don't have
+	// the routing tag or when its value is not part of the routes, these
+	// metrics are discarded when Default is nil.
+	Default Handler
+}
+
+type routeHandler struct {
+	tag      string
+	routes   map[string]int // indexes in handlers
+	fallback int            // index in handlers, -1 without default handler
+	handlers []Handler
+}
+
+// NewRouteHandler returns a handler which passes each metric it receives to a
+// handler chosen by the value of one of the metric's tags.
+//
+// This is useful to isolate the metrics of multiple tenants of a program, for
+// example by sending the metrics tagged team=payments and team=search to
+// different accounts of a backend, while sharing the same instrumentation.
+// The routing table cannot be changed after the handler was created.
+func NewRouteHandler(config RouteHandlerConfig) Handler {
+	h := &routeHandler{
+		tag:      config.Tag,
+		routes:   make(map[string]int, len(config.Routes)),
+		fallback: -1,
+	}
+
+	for value, handler := range config.Routes {
+		h.handlers, h.routes[value] = appendRouteHandler(h.handlers, handler)
+	}
+
+	if config.Default != nil {
+		h.handlers, h.fallback = appendRouteHandler(h.handlers, config.Default)
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *routeHandler) HandleMetric(m *Metric) {
+	if i := h.route(m.Tags); i >= 0 {
+		h.handlers[i].HandleMetric(m)
+	}
+}
+
+// HandleMetrics satisfies the BatchHandler interface, each handler receives the
+// metrics routed to it in a single call if it implements the interface.
+func (h *routeHandler) HandleMetrics(metrics []*Metric) {
+	routed := make([][]*Metric, len(h.handlers))
+
+	for _, m := range metrics {
+		if i := h.route(m.Tags); i >= 0 {
+			routed[i] = append(routed[i], m)
+		}
+	}
+
+	for i, list := range routed {
+		if len(list) != 0 {
+			handleMetrics(h.handlers[i], list)
+		}
+	}
+}
+
+// DescribeMetric satisfies the Describer interface, schemas are passed to all
+// the handlers that metrics may be routed to.
+func (h *routeHandler) DescribeMetric(schema MetricSchema) {
+	for _, handler := range h.handlers {
+		describeMetric(handler, schema)
+	}
+}
+
+// Flush satisfies the Flusher interface.
+func (h *routeHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *routeHandler) FlushContext(ctx context.Context) {
+	for _, handler := range h.handlers {
+		flushHandler(ctx, handler)
+	}
+}
+
+// Reset satisfies the Resetter interface.
+func (h *routeHandler) Reset() {
+	for _, handler := range h.handlers {
+		if r, ok := handler.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+// route returns the index of the handler that metrics with tags are routed to,
+// or -1 if they are discarded.
+func (h *routeHandler) route(tags []Tag) int {
+	for _, t := range tags {
+		if t.Name == h.tag {
+			if i, ok := h.routes[t.Value]; ok {
+				return i
+			}
+			break
+		}
+	}
+	return h.fallback
+}
+
+// appendRouteHandler appends handler to handlers unless it is already part of
+// the list, so handlers serving multiple routes are only flushed once, and
+// returns its index in the list. Handlers of types which cannot be compared
+// (like HandlerFunc) are always appended.
+func appendRouteHandler(handlers []Handler, handler Handler) ([]Handler, int) {
+	if reflect.TypeOf(handler).Comparable() {
+		for i, h := range handlers {
+			if reflect.TypeOf(h).Comparable() && h == handler {
+				return handlers, i
+			}
+		}
+	}
+	return append(handlers, handler), len(handlers)
+}
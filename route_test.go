@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouteHandler(t *testing.T) {
+	payments := &handler{}
+	search := &handler{}
+	fallback := &handler{}
+
+	e := NewEngine("E")
+	e.Register(NewRouteHandler(RouteHandlerConfig{
+		Tag: "team",
+		Routes: map[string]Handler{
+			"payments": payments,
+			"billing":  payments,
+			"search":   search,
+		},
+		Default: fallback,
+	}))
+
+	e.Incr("charges", Tag{"team", "payments"})
+	e.Incr("invoices", Tag{"team", "billing"})
+	e.Incr("queries", Tag{"team", "search"})
+	e.Incr("logins", Tag{"team", "auth"})
+	e.Incr("requests")
+	e.Flush()
+
+	tests := []struct {
+		name    string
+		handler *handler
+		metrics []Metric
+	}{
+		{
+			name:    "payments",
+			handler: payments,
+			metrics: []Metric{
+				{Type: CounterType, Namespace: "E", Name: "charges", Tags: []Tag{{"team", "payments"}}, Value: 1},
+				{Type: CounterType, Namespace: "E", Name: "invoices", Tags: []Tag{{"team", "billing"}}, Value: 1},
+			},
+		},
+		{
+			name:    "search",
+			handler: search,
+			metrics: []Metric{
+				{Type: CounterType, Namespace: "E", Name: "queries", Tags: []Tag{{"team", "search"}}, Value: 1},
+			},
+		},
+		{
+			name:    "default",
+			handler: fallback,
+			metrics: []Metric{
+				{Type: CounterType, Namespace: "E", Name: "logins", Tags: []Tag{{"team", "auth"}}, Value: 1},
+				{Type: CounterType, Namespace: "E", Name: "requests", Value: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.handler.metrics, test.metrics) {
+				t.Error("bad metrics:", test.handler.metrics)
+			}
+
+			if test.handler.flushed != 1 {
+				t.Error("bad flush count:", test.handler.flushed)
+			}
+		})
+	}
+}
+
+func TestRouteHandlerNoDefault(t *testing.T) {
+	routed := 0
+	h := NewRouteHandler(RouteHandlerConfig{
+		Tag: "team",
+		Routes: map[string]Handler{
+			"search": HandlerFunc(func(*Metric) { routed++ }),
+		},
+	})
+
+	h.HandleMetric(&Metric{Name: "queries", Tags: []Tag{{"team", "search"}}})
+	h.HandleMetric(&Metric{Name: "logins", Tags: []Tag{{"team", "auth"}}})
+	h.(Flusher).Flush()
+
+	if routed != 1 {
+		t.Error("bad number of routed metrics:", routed)
+	}
+}
+
+func TestRouteHandlerBatch(t *testing.T) {
+	payments := &batchHandler{}
+	search := &batchHandler{}
+
+	e := NewEngine("E")
+	e.Register(NewRouteHandler(RouteHandlerConfig{
+		Tag: "team",
+		Routes: map[string]Handler{
+			"payments": payments,
+			"search":   search,
+		},
+	}))
+
+	b := e.Batch()
+	b.Incr("charges", Tag{"team", "payments"})
+	b.Incr("queries", Tag{"team", "search"})
+	b.Incr("refunds", Tag{"team", "payments"})
+	b.Incr("logins", Tag{"team", "auth"})
+	b.Commit()
+
+	if payments.batches != 1 || len(payments.metrics) != 2 {
+		t.Error("bad batches routed to the payments handler:", payments.batches, payments.metrics)
+	}
+
+	if search.batches != 1 || len(search.metrics) != 1 {
+		t.Error("bad batches routed to the search handler:", search.batches, search.metrics)
+	}
+}
@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SampleSeeder is the signature of functions used by engines to derive the
+// seed of sampling decisions from contexts, for example a hash of the trace ID
+// of the current request (see SampleSeed).
+//
+// The function must return false if ctx carries no seed.
+type SampleSeeder func(ctx context.Context) (seed uint64, ok bool)
+
+// SampleSeed returns a sampling seed derived from s, it is intended to turn
+// request or trace identifiers into seeds.
+func SampleSeed(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+type sampleSeedKey struct{}
+
+// ContextWithSampleSeed returns a copy of ctx carrying seed, which is retrieved
+// by ContextSampleSeed.
+func ContextWithSampleSeed(ctx context.Context, seed uint64) context.Context {
+	return context.WithValue(ctx, sampleSeedKey{}, seed)
+}
+
+// ContextSampleSeed returns the seed carried by ctx, it is the default sample
+// seeder of engines.
+func ContextSampleSeed(ctx context.Context) (uint64, bool) {
+	seed, ok := ctx.Value(sampleSeedKey{}).(uint64)
+	return seed, ok
+}
+
+// WithSampleSeed creates a new engine which inherits the properties and
+// handlers of eng, and takes the sampling decisions of the metrics it produces
+// from seed instead of independent randomness. See EngineConfig.SampleRates.
+func (eng *Engine) WithSampleSeed(seed uint64) *Engine {
+	e := eng.derive(eng.name, eng.tags)
+	e.seed, e.seeded = seed, true
+	return e
+}
+
+// sampler takes the sampling decisions of engines.
+type sampler struct {
+	rates  map[string]float64 // read-only after construction
+	seeder SampleSeeder
+	mutex  sync.Mutex
+	rng    *rand.Rand
+}
+
+func newSampler(rates map[string]float64, seeder SampleSeeder) *sampler {
+	s := &sampler{
+		rates:  make(map[string]float64, len(rates)),
+		seeder: seeder,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if s.seeder == nil {
+		s.seeder = ContextSampleSeed
+	}
+
+	for name, rate := range rates {
+		if rate > 0 && rate < 1 {
+			s.rates[name] = rate
+		}
+	}
+
+	return s
+}
+
+// sample returns whether the metric with name should be reported, and the
+// sample rate to report it with, zero if it is not sampled.
+//
+// When seeded, the decision only depends on the seed and the rate: all metrics
+// produced with the same seed and rate get the same decision, and a seed kept
+// at a rate is also kept at all greater rates.
+func (s *sampler) sample(name string, seed uint64, seeded bool) (float64, bool) {
+	rate, ok := s.rates[name]
+	if !ok {
+		return 0, true
+	}
+
+	var x float64
+
+	if seeded {
+		x = uniform(seed)
+	} else {
+		s.mutex.Lock()
+		x = s.rng.Float64()
+		s.mutex.Unlock()
+	}
+
+	return rate, x < rate
+}
+
+// uniform maps seed to a number in [0, 1), seeds are mixed with the splitmix64
+// finalizer so sequential seeds are spread uniformly.
+func uniform(seed uint64) float64 {
+	seed += 0x9e3779b97f4a7c15
+	seed = (seed ^ (seed >> 30)) * 0xbf58476d1ce4e5b9
+	seed = (seed ^ (seed >> 27)) * 0x94d049bb133111eb
+	seed ^= seed >> 31
+	return float64(seed>>11) / (1 << 53)
+}
+
+// combineRates returns the sample rate of a metric sampled at both r1 and r2,
+// zero means that a metric was not sampled.
+func combineRates(r1 float64, r2 float64) float64 {
+	switch {
+	case r1 == 0:
+		return r2
+	case r2 == 0:
+		return r1
+	default:
+		return r1 * r2
+	}
+}
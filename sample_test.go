@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEngineSampleSeed(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:        "E",
+		SampleRates: map[string]float64{"requests": 0.5, "bytes": 0.5},
+	})
+	e.Register(h)
+
+	sampled := 0
+
+	for i := 0; i != 1000; i++ {
+		h.metrics = nil
+
+		r := e.WithContext(ContextWithSampleSeed(context.Background(), uint64(i)))
+		r.Incr("requests")
+		r.Observe("bytes", 42)
+		r.Set("conns", 1)
+
+		kept := len(h.metrics) == 3
+
+		switch len(h.metrics) {
+		case 1:
+		case 3:
+			sampled++
+
+			for _, m := range h.metrics[:2] {
+				if m.Rate != 0.5 {
+					t.Errorf("%s: bad sample rate: %g", m.Name, m.Rate)
+				}
+			}
+		default:
+			t.Fatalf("seed %d: inconsistent sampling decisions: %v", i, h.metrics)
+		}
+
+		if m := h.metrics[len(h.metrics)-1]; m.Name != "conns" || m.Rate != 0 {
+			t.Errorf("seed %d: metrics without a sample rate were sampled", i)
+		}
+
+		// The decision only depends on the seed.
+		h.metrics = nil
+		e.WithSampleSeed(uint64(i)).Incr("requests")
+
+		if (len(h.metrics) == 1) != kept {
+			t.Fatalf("seed %d: the decision changed between engines", i)
+		}
+	}
+
+	if sampled < 400 || sampled > 600 {
+		t.Error("bad number of sampled requests:", sampled)
+	}
+}
+
+func TestEngineSampleSeeder(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:         "E",
+		SampleRates:  map[string]float64{"requests": 0.5},
+		SampleSeeder: func(ctx context.Context) (uint64, bool) { return 1, true },
+	})
+	e.Register(h)
+
+	want := uniform(1) < 0.5
+
+	for i := 0; i != 10; i++ {
+		e.WithContext(context.Background()).Incr("requests")
+	}
+
+	if n := len(h.metrics); (want && n != 10) || (!want && n != 0) {
+		t.Error("bad number of sampled metrics:", n)
+	}
+}
+
+func TestSamplerNestedRates(t *testing.T) {
+	s := newSampler(map[string]float64{"a": 0.1, "b": 0.5}, nil)
+
+	for seed := uint64(0); seed != 1000; seed++ {
+		_, a := s.sample("a", seed, true)
+		_, b := s.sample("b", seed, true)
+
+		if a && !b {
+			t.Fatalf("seed %d: kept at a rate of 0.1 but not 0.5", seed)
+		}
+	}
+}
+
+func TestSampleSeed(t *testing.T) {
+	if SampleSeed("4bf92f3577b34da6") != SampleSeed("4bf92f3577b34da6") {
+		t.Error("the seeds of the same identifier differ")
+	}
+
+	if SampleSeed("4bf92f3577b34da6") == SampleSeed("00f067aa0ba902b7") {
+		t.Error("the seeds of different identifiers are equal")
+	}
+}
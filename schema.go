@@ -0,0 +1,342 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetricSchema describes a metric that a program may produce, it is used to
+// generate catalogs or documentation of the metrics exposed by an application.
+type MetricSchema struct {
+	// Type is the type of the metric.
+	Type MetricType `json:"type"`
+
+	// Namespace in which the metric is produced.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name is the name of the metric as defined by the program.
+	Name string `json:"name"`
+
+	// Help is a human-readable description of the metric.
+	Help string `json:"help,omitempty"`
+
+	// Unit is the unit in which values of the metric are expressed.
+	Unit string `json:"unit,omitempty"`
+
+	// TagKeys is the sorted list of tag names that may be set on the metric.
+	TagKeys []string `json:"tag_keys,omitempty"`
+
+	// Objectives is the list of quantiles estimated by handlers for summaries.
+	Objectives []Objective `json:"objectives,omitempty"`
+
+	// Buckets is the list of upper limits of the buckets of histograms, in
+	// increasing order, see EngineConfig.Buckets.
+	Buckets []float64 `json:"buckets,omitempty"`
+
+	// MaxBuckets is the maximum number of buckets of each sign of exponential
+	// histograms.
+	MaxBuckets int `json:"max_buckets,omitempty"`
+}
+
+// FullName returns the name of the metric prefixed with its namespace.
+func (s MetricSchema) FullName() string {
+	if len(s.Namespace) == 0 {
+		return s.Name
+	}
+	return s.Namespace + "." + s.Name
+}
+
+// described returns whether s carries information for the handlers
+// implementing the Describer interface.
+func (s MetricSchema) described() bool {
+	return len(s.Help) != 0 || len(s.Unit) != 0 || len(s.Objectives) != 0 || len(s.Buckets) != 0 || s.MaxBuckets != 0
+}
+
+// WriteSchemaMarkdown writes schema to w as a markdown table.
+func WriteSchemaMarkdown(w io.Writer, schema []MetricSchema) (err error) {
+	if _, err = io.WriteString(w, "| Name | Type | Unit | Tags | Description |\n|---|---|---|---|---|\n"); err != nil {
+		return
+	}
+
+	for _, s := range schema {
+		if _, err = fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n",
+			s.FullName(),
+			s.Type,
+			s.Unit,
+			strings.Join(s.TagKeys, ", "),
+			strings.Replace(s.Help, "|", "\\|", -1),
+		); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (t MetricType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (t *MetricType) UnmarshalText(b []byte) error {
+	for _, typ := range []MetricType{CounterType, GaugeType, HistogramType, SummaryType, ExponentialHistogramType} {
+		if string(b) == typ.String() {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("stats: unknown metric type: %q", b)
+}
+
+type schemaKey struct {
+	typ       MetricType
+	namespace string
+	name      string
+}
+
+// schemaRegistry records the metrics that were declared or produced by the
+// engines sharing it, and the buckets of histograms. The methods of a nil
+// registry, which is the registry of zero-value engines, record nothing.
+type schemaRegistry struct {
+	mutex   sync.RWMutex
+	entries map[schemaKey]*MetricSchema
+	buckets map[string][]float64 // histogram buckets by name or pattern
+
+	// seen maps the keys of the entries to a copy of their tag keys, it is
+	// updated with the entries and lets observe skip the mutex for series
+	// which were already seen, which is the case of almost every metric.
+	seen sync.Map
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		entries: make(map[schemaKey]*MetricSchema),
+	}
+}
+
+// describe records s in the registry. When s declares a new histogram with
+// buckets configured for its name, the method returns the schema of the
+// histogram carrying the buckets, which must be passed to the describers.
+func (r *schemaRegistry) describe(s MetricSchema) (described *MetricSchema) {
+	if r == nil {
+		return nil
+	}
+
+	key := schemaKey{s.Type, s.Namespace, s.Name}
+
+	r.mutex.Lock()
+
+	if e := r.entries[key]; e == nil {
+		s.TagKeys = mergeTagKeys(nil, s.TagKeys...)
+
+		if s.Type == HistogramType && len(s.Buckets) == 0 {
+			if s.Buckets = r.lookupBuckets(s); len(s.Buckets) != 0 {
+				c := s
+				described = &c
+			}
+		}
+
+		r.entries[key] = &s
+		r.seen.Store(key, append([]string(nil), s.TagKeys...))
+	} else {
+		if len(s.Help) != 0 {
+			e.Help = s.Help
+		}
+		if len(s.Unit) != 0 {
+			e.Unit = s.Unit
+		}
+		if len(s.Objectives) != 0 {
+			e.Objectives = s.Objectives
+		}
+		if len(s.Buckets) != 0 {
+			e.Buckets = s.Buckets
+		}
+		if s.MaxBuckets != 0 {
+			e.MaxBuckets = s.MaxBuckets
+		}
+		if !hasTagKeys(e.TagKeys, s.TagKeys...) {
+			e.TagKeys = mergeTagKeys(e.TagKeys, s.TagKeys...)
+			r.seen.Store(key, append([]string(nil), e.TagKeys...))
+		}
+	}
+
+	r.mutex.Unlock()
+	return
+}
+
+// setBuckets configures the buckets of the histograms with names matching
+// pattern, it returns the schemas of the histograms already in the registry
+// whose buckets changed.
+func (r *schemaRegistry) setBuckets(pattern string, limits []float64) (changed []MetricSchema) {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+
+	if r.buckets == nil {
+		r.buckets = make(map[string][]float64)
+	}
+
+	r.buckets[pattern] = limits
+
+	for _, e := range r.entries {
+		if e.Type != HistogramType {
+			continue
+		}
+
+		if b := r.lookupBuckets(*e); len(b) != 0 && !equalBuckets(b, e.Buckets) {
+			e.Buckets = b
+			s := *e
+			s.TagKeys = append([]string(nil), e.TagKeys...)
+			changed = append(changed, s)
+		}
+	}
+
+	r.mutex.Unlock()
+	return
+}
+
+// lookupBuckets returns the buckets configured for the histogram of s, the
+// buckets configured for its name take precedence over patterns, and longer
+// patterns over shorter ones. The mutex must be held.
+func (r *schemaRegistry) lookupBuckets(s MetricSchema) []float64 {
+	if len(r.buckets) == 0 {
+		return nil
+	}
+
+	name, fullName := s.Name, s.FullName()
+
+	if b, ok := r.buckets[name]; ok {
+		return b
+	}
+
+	if b, ok := r.buckets[fullName]; ok {
+		return b
+	}
+
+	var match string
+	var buckets []float64
+
+	for pattern, b := range r.buckets {
+		if len(pattern) < len(match) || (len(pattern) == len(match) && pattern > match) {
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, name); !ok {
+			if ok, _ = path.Match(pattern, fullName); !ok {
+				continue
+			}
+		}
+
+		match, buckets = pattern, b
+	}
+
+	return buckets
+}
+
+func equalBuckets(b1 []float64, b2 []float64) bool {
+	if len(b1) != len(b2) {
+		return false
+	}
+	for i := range b1 {
+		if b1[i] != b2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *schemaRegistry) observe(typ MetricType, namespace string, name string, tags []Tag) *MetricSchema {
+	if r == nil {
+		return nil
+	}
+
+	key := schemaKey{typ, namespace, name}
+
+	if keys, ok := r.seen.Load(key); ok && hasTags(keys.([]string), tags) {
+		return nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, t := range tags {
+		keys[i] = t.Name
+	}
+
+	return r.describe(MetricSchema{
+		Type:      typ,
+		Namespace: namespace,
+		Name:      name,
+		TagKeys:   keys,
+	})
+}
+
+func (r *schemaRegistry) schema() []MetricSchema {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.RLock()
+	schema := make([]MetricSchema, 0, len(r.entries))
+
+	for _, e := range r.entries {
+		s := *e
+		s.TagKeys = append([]string(nil), e.TagKeys...)
+		schema = append(schema, s)
+	}
+
+	r.mutex.RUnlock()
+
+	sort.Slice(schema, func(i int, j int) bool {
+		if n1, n2 := schema[i].FullName(), schema[j].FullName(); n1 != n2 {
+			return n1 < n2
+		}
+		return schema[i].Type < schema[j].Type
+	})
+
+	return schema
+}
+
+func hasTags(keys []string, tags []Tag) bool {
+	for _, t := range tags {
+		if !hasTagKeys(keys, t.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTagKeys(keys []string, names ...string) bool {
+	for _, name := range names {
+		if i := sort.SearchStrings(keys, name); i == len(keys) || keys[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeTagKeys(keys []string, names ...string) []string {
+	for _, name := range names {
+		if i := sort.SearchStrings(keys, name); i == len(keys) || keys[i] != name {
+			keys = append(keys, "")
+			copy(keys[i+1:], keys[i:])
+			keys[i] = name
+		}
+	}
+	return keys
+}
+
+func tagKeys(tags ...[]Tag) []string {
+	var keys []string
+	for _, list := range tags {
+		for _, t := range list {
+			keys = append(keys, t.Name)
+		}
+	}
+	return keys
+}
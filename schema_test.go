@@ -0,0 +1,229 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEngineSchema(t *testing.T) {
+	e := NewEngine("E", Tag{"base", "tag"})
+	e.Describe(CounterType, "requests", "Number of requests served.", "requests", "status")
+	e.Gauge("conns")
+	e.Observe("latency", 1, Tag{"path", "/"})
+	e.Observe("latency", 2, Tag{"method", "GET"})
+	e.WithName("F").Incr("errors")
+
+	if schema := e.Schema(); !reflect.DeepEqual(schema, []MetricSchema{
+		{
+			Type:      GaugeType,
+			Namespace: "E",
+			Name:      "conns",
+			TagKeys:   []string{"base"},
+		},
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "latency",
+			TagKeys:   []string{"base", "method", "path"},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Help:      "Number of requests served.",
+			Unit:      "requests",
+			TagKeys:   []string{"base", "status"},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "F",
+			Name:      "errors",
+			TagKeys:   []string{"base"},
+		},
+	}) {
+		t.Error("bad schema:", schema)
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	b, err := json.Marshal(MetricSchema{
+		Type:    CounterType,
+		Name:    "requests",
+		Help:    "Number of requests served.",
+		TagKeys: []string{"status"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(b); s != `{"type":"counter","name":"requests","help":"Number of requests served.","tag_keys":["status"]}` {
+		t.Error("bad json:", s)
+	}
+}
+
+func TestMetricTypeText(t *testing.T) {
+	for _, typ := range []MetricType{CounterType, GaugeType, HistogramType, SummaryType, ExponentialHistogramType} {
+		b, _ := typ.MarshalText()
+		var x MetricType
+
+		if err := x.UnmarshalText(b); err != nil || x != typ {
+			t.Errorf("%s: bad round trip: %s (%v)", typ, x, err)
+		}
+	}
+
+	var x MetricType
+
+	if err := x.UnmarshalText([]byte("meter")); err == nil {
+		t.Error("expected an error for an unknown metric type")
+	}
+}
+
+func TestWriteSchemaMarkdown(t *testing.T) {
+	b := &bytes.Buffer{}
+
+	if err := WriteSchemaMarkdown(b, []MetricSchema{
+		{
+			Type:      HistogramType,
+			Namespace: "E",
+			Name:      "latency",
+			Unit:      "seconds",
+			TagKeys:   []string{"method", "path"},
+			Help:      "Time to serve a request.",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := b.String(); s != `| Name | Type | Unit | Tags | Description |
+|---|---|---|---|---|
+| `+"`E.latency`"+` | histogram | seconds | method, path | Time to serve a request. |
+` {
+		t.Error("bad markdown:", s)
+	}
+}
+
+func TestWithHelp(t *testing.T) {
+	h := &describer{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	c := e.Counter("requests", Tag{"status", "200"}).WithHelp("Number of requests.")
+	e.Gauge("conns").WithHelp("Number of open connections.")
+	e.Histogram("latency").WithDurationUnit(time.Millisecond).WithHelp("Time to serve requests.")
+	e.Timer("wait").WithHelp("Time spent waiting.")
+	e.Summary("size", nil).WithHelp("Size of responses.")
+
+	c.Incr()
+
+	if c.Value() != 1 {
+		t.Error("the counter was not returned by WithHelp:", c.Value())
+	}
+
+	if schema := e.Schema(); !reflect.DeepEqual(schema, []MetricSchema{
+		{Type: GaugeType, Namespace: "E", Name: "conns", Help: "Number of open connections."},
+		{Type: HistogramType, Namespace: "E", Name: "latency", Help: "Time to serve requests.", Unit: "milliseconds"},
+		{Type: CounterType, Namespace: "E", Name: "requests", Help: "Number of requests.", TagKeys: []string{"status"}},
+		{Type: SummaryType, Namespace: "E", Name: "size", Help: "Size of responses.", Objectives: DefaultObjectives},
+		{Type: HistogramType, Namespace: "E", Name: "wait", Help: "Time spent waiting.", TagKeys: []string{"stamp"}},
+	}) {
+		t.Error("bad schema:", schema)
+	}
+
+	if len(h.schema) != 7 || h.schema[0].Help != "Number of requests." {
+		t.Error("bad descriptions:", h.schema)
+	}
+}
+
+func TestEngineBuckets(t *testing.T) {
+	h := &describer{}
+	e := NewEngineWith(EngineConfig{
+		Name: "E",
+		Buckets: map[string][]float64{
+			"http.*":         {1, 2},
+			"http.*.latency": {2, 0.5, 1},
+			"http.db.size":   {100},
+			"E.rpc.latency":  {3},
+		},
+	})
+	e.Register(h)
+
+	e.Histogram("http.server.latency")
+	e.Observe("http.db.size", 1)
+	e.Timer("rpc.latency")
+	e.Observe("http.requests", 1)
+	e.Observe("other", 1)
+	e.Incr("http.errors")
+
+	buckets := func() map[string][]float64 {
+		b := make(map[string][]float64)
+		for _, s := range h.schema {
+			b[s.Name] = s.Buckets
+		}
+		return b
+	}
+
+	if b := buckets(); !reflect.DeepEqual(b, map[string][]float64{
+		"http.server.latency": {0.5, 1, 2},
+		"http.db.size":        {100},
+		"rpc.latency":         {3},
+		"http.requests":       {1, 2},
+	}) {
+		t.Error("bad buckets:", b)
+	}
+
+	// Changing the buckets at runtime describes the existing histograms
+	// again, with their new buckets.
+	h.schema = nil
+	e.SetBuckets("http.*", []float64{5})
+
+	if b := buckets(); !reflect.DeepEqual(b, map[string][]float64{
+		"http.requests": {5},
+	}) {
+		t.Error("bad buckets after SetBuckets:", b)
+	}
+
+	// Handlers registered later receive the buckets with the schema.
+	h = &describer{}
+	e.Register(h)
+
+	if b := buckets(); len(b) != 4 || !reflect.DeepEqual(b["http.requests"], []float64{5}) {
+		t.Error("bad buckets of a new handler:", b)
+	}
+}
+
+func TestSchemaRegistryObserveSeen(t *testing.T) {
+	r := newSchemaRegistry()
+	tags := []Tag{{"a", "1"}, {"b", "2"}}
+
+	r.observe(CounterType, "E", "A", tags)
+
+	// Series which were already seen must not acquire the mutex, which would
+	// deadlock here, while new tag keys must still be recorded.
+	done := make(chan struct{})
+	r.mutex.Lock()
+
+	go func() {
+		r.observe(CounterType, "E", "A", tags[:1])
+		r.observe(CounterType, "E", "A", tags)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("observing a series which was already seen acquired the mutex")
+	}
+
+	r.mutex.Unlock()
+	<-done
+
+	r.observe(CounterType, "E", "A", []Tag{{"c", "3"}})
+
+	if s := r.schema(); len(s) != 1 || !reflect.DeepEqual(s[0].TagKeys, []string{"a", "b", "c"}) {
+		t.Error("bad schema:", s)
+	}
+}
@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// ShardTag is the name of the tag set on metrics produced by engines returned
+// by WithShard.
+const ShardTag = "shard"
+
+// DefaultShardGaugeTimeout is the default duration after which the value of a
+// gauge that a shard stopped setting no longer contributes to the aggregate.
+const DefaultShardGaugeTimeout = 5 * time.Minute
+
+// WithShard creates a new engine which inherits the properties and handlers
+// of eng, adding a ShardTag tag set to shard to the metrics it produces.
+//
+// When the engine was configured with ShardAggregates, every metric produced
+// by the returned engine is also reported without the shard tag, so both the
+// per-shard and the rolled-up series are available without recording metrics
+// twice. Counters and histograms are reported as-is in the aggregate series,
+// which sums them in the backends, while gauges report the sum of the last
+// values set by each shard. The value of a shard is retained across flushes so
+// the aggregate doesn't dip when only some shards set the gauge during a flush
+// interval, it is discarded when the shard didn't set the gauge for the
+// ShardGaugeTimeout of the engine.
+func (eng *Engine) WithShard(shard string) *Engine {
+	e := eng.WithTags(Tag{ShardTag, shard})
+	e.shard = shard
+	return e
+}
+
+// shardAggregator maintains the state needed to aggregate the metrics of
+// multiple shards. The values of gauges are indexed by the key of the series
+// then by shard, they expire when the engine is flushed after the shard didn't
+// set them for the timeout, so series which are no longer updated don't
+// accumulate.
+type shardAggregator struct {
+	timeout time.Duration
+	now     func() time.Time
+	mutex   sync.Mutex
+	gauges  map[string]map[string]shardGauge
+}
+
+type shardGauge struct {
+	value float64
+	time  time.Time
+}
+
+func newShardAggregator(timeout time.Duration) *shardAggregator {
+	if timeout <= 0 {
+		timeout = DefaultShardGaugeTimeout
+	}
+
+	return &shardAggregator{
+		timeout: timeout,
+		now:     time.Now,
+		gauges:  make(map[string]map[string]shardGauge),
+	}
+}
+
+// aggregate sets c to the shard-less copy of m, which was produced by shard.
+func (a *shardAggregator) aggregate(c *Metric, m *Metric, shard string) {
+	c.Type = m.Type
+	c.Namespace = m.Namespace
+	c.Name = m.Name
+	c.Value = m.Value
+	c.Time = m.Time
+	c.Unit = m.Unit
+	c.Rate = m.Rate
+	c.Expires = m.Expires
+	c.Exemplar = m.Exemplar
+	c.Tags = c.Tags[:0]
+
+	for _, t := range m.Tags {
+		if t.Name != ShardTag {
+			c.Tags = append(c.Tags, t)
+		}
+	}
+
+	if m.Type == GaugeType {
+		c.Value = a.setGauge(diffKey(c.Type, c.Namespace, c.Name, c.Tags), shard, m.Value)
+	}
+}
+
+// setGauge records the value of a gauge for a shard and returns the sum of the
+// values of all shards. The sum is recomputed from the values of each shard,
+// so rounding errors don't accumulate over updates.
+func (a *shardAggregator) setGauge(key string, shard string, value float64) float64 {
+	now := a.now()
+	a.mutex.Lock()
+	values := a.gauges[key]
+
+	if values == nil {
+		values = make(map[string]shardGauge)
+		a.gauges[key] = values
+	}
+
+	values[shard] = shardGauge{value: value, time: now}
+	sum := 0.0
+
+	for _, v := range values {
+		sum += v.value
+	}
+
+	a.mutex.Unlock()
+	return sum
+}
+
+// expire discards the values of gauges which were not set by their shard for
+// the timeout, it is called when the engine is flushed.
+func (a *shardAggregator) expire() {
+	limit := a.now().Add(-a.timeout)
+	a.mutex.Lock()
+
+	for key, values := range a.gauges {
+		for shard, v := range values {
+			if v.time.Before(limit) {
+				delete(values, shard)
+			}
+		}
+
+		if len(values) == 0 {
+			delete(a.gauges, key)
+		}
+	}
+
+	a.mutex.Unlock()
+}
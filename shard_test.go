@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEngineWithShard(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	e.WithShard("1").Incr("A")
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "A", Value: 1, Tags: []Tag{{"shard", "1"}}},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestEngineShardAggregates(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:            "E",
+		ShardAggregates: true,
+	})
+	e.Register(h)
+
+	s1 := e.WithShard("1")
+	s2 := e.WithShard("2")
+
+	s1.Incr("A", Tag{"a", "b"})
+	s1.Set("B", 2)
+	s2.Set("B", 3)
+	s1.Set("B", 1)
+
+	b := s2.Batch()
+	b.Observe("C", 1)
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "A", Value: 1, Tags: []Tag{{"shard", "1"}, {"a", "b"}}},
+		{Type: CounterType, Namespace: "E", Name: "A", Value: 1, Tags: []Tag{{"a", "b"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 2, Tags: []Tag{{"shard", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 2},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 3, Tags: []Tag{{"shard", "2"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 5},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 1, Tags: []Tag{{"shard", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 4},
+		{Type: HistogramType, Namespace: "E", Name: "C", Value: 1, Tags: []Tag{{"shard", "2"}}},
+		{Type: HistogramType, Namespace: "E", Name: "C", Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
+
+func TestEngineShardAggregatesFlush(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:              "E",
+		ShardAggregates:   true,
+		ShardGaugeTimeout: time.Minute,
+	})
+	e.Register(h)
+
+	now := time.Now()
+	e.shards.now = func() time.Time { return now }
+
+	e.WithShard("1").Set("B", 2)
+	e.WithShard("2").Set("B", 3)
+	e.Flush()
+
+	h.Reset()
+	e.WithShard("1").Set("B", 1)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 1, Tags: []Tag{{"shard", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 4},
+	}) {
+		t.Error("bad metrics after flushing:", h.metrics)
+	}
+
+	now = now.Add(2 * time.Minute)
+	e.Flush()
+
+	h.Reset()
+	e.WithShard("1").Set("B", 1)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 1, Tags: []Tag{{"shard", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "B", Value: 1},
+	}) {
+		t.Error("bad metrics after the shards expired:", h.metrics)
+	}
+
+	now = now.Add(2 * time.Minute)
+	e.Flush()
+
+	if n := len(e.shards.gauges); n != 0 {
+		t.Error("bad number of gauges retained by the shard aggregator:", n)
+	}
+}
@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMaxSpanNames is the default maximum number of distinct span names
+// reported by engines.
+const DefaultMaxSpanNames = 100
+
+// SpanNamer is the signature of functions used by engines to derive the name
+// of the tracing span that a context carries, for example the operation name
+// of the current span of a tracing library.
+//
+// The function must return an empty string if ctx carries no span.
+type SpanNamer func(ctx context.Context) string
+
+// spanRegistry keeps track of the span names reported by engines to bound the
+// cardinality of the "span" tag.
+type spanRegistry struct {
+	namer SpanNamer
+	limit int
+	mutex sync.RWMutex
+	names map[string]struct{}
+}
+
+func newSpanRegistry(namer SpanNamer, limit int) *spanRegistry {
+	return &spanRegistry{
+		namer: namer,
+		limit: limit,
+		names: make(map[string]struct{}),
+	}
+}
+
+func (r *spanRegistry) name(ctx context.Context) string {
+	name := r.namer(ctx)
+
+	if len(name) == 0 {
+		return ""
+	}
+
+	r.mutex.RLock()
+	_, known := r.names[name]
+	r.mutex.RUnlock()
+
+	if known {
+		return name
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, known = r.names[name]; !known {
+		if len(r.names) >= r.limit {
+			return "other"
+		}
+		r.names[name] = struct{}{}
+	}
+
+	return name
+}
@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type spanKey struct{}
+
+func spanName(ctx context.Context) string {
+	name, _ := ctx.Value(spanKey{}).(string)
+	return name
+}
+
+func TestEngineWithContext(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:         "E",
+		Tags:         []Tag{{"base", "tag"}},
+		SpanNamer:    spanName,
+		MaxSpanNames: 2,
+	})
+	e.Register(h)
+
+	for _, span := range []string{"A", "", "B", "C", "A"} {
+		e.WithContext(context.WithValue(context.Background(), spanKey{}, span)).Incr("calls")
+	}
+
+	tags := make([][]Tag, len(h.metrics))
+	for i, m := range h.metrics {
+		tags[i] = m.Tags
+	}
+
+	if !reflect.DeepEqual(tags, [][]Tag{
+		{{"base", "tag"}, {"span", "A"}},
+		{{"base", "tag"}},
+		{{"base", "tag"}, {"span", "B"}},
+		{{"base", "tag"}, {"span", "other"}},
+		{{"base", "tag"}, {"span", "A"}},
+	}) {
+		t.Error("bad tags:", tags)
+	}
+}
+
+func TestEngineWithContextDisabled(t *testing.T) {
+	e := NewEngine("E")
+	ctx := context.WithValue(context.Background(), spanKey{}, "A")
+
+	if e.WithContext(ctx) != e {
+		t.Error("the engine should be returned when no span namer is configured")
+	}
+}
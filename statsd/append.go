@@ -0,0 +1,148 @@
+package statsd
+
+import (
+	"strconv"
+
+	"github.com/segmentio/stats"
+)
+
+// Protocol is an enumeration of the variants of the statsd protocol that
+// clients can speak.
+type Protocol int
+
+const (
+	// StatsD is the plain statsd protocol, which has no concept of tags: tags
+	// are folded into the dotted names of metrics as name.value components,
+	// and histograms are sent as timers in milliseconds:
+	//
+	//	namespace.name.tag1.v1.tag2.v2:value|type|@rate
+	StatsD Protocol = iota
+
+	// DogStatsD is the protocol extended by datadog, where tags are sent
+	// separately from the names of metrics and histograms have their own
+	// type:
+	//
+	//	namespace.name:value|type|@rate|#tag1:v1,tag2:v2
+	DogStatsD
+)
+
+// String satisfies the fmt.Stringer interface.
+func (p Protocol) String() string {
+	switch p {
+	case StatsD:
+		return "statsd"
+	case DogStatsD:
+		return "dogstatsd"
+	default:
+		return "unknown"
+	}
+}
+
+// AppendMetric appends the plain statsd representation of m to b.
+func AppendMetric(b []byte, m *stats.Metric) []byte {
+	return appendMetric(b, m, StatsD, m.Rate)
+}
+
+// AppendDogStatsDMetric appends the dogstatsd representation of m to b.
+func AppendDogStatsDMetric(b []byte, m *stats.Metric) []byte {
+	return appendMetric(b, m, DogStatsD, m.Rate)
+}
+
+// appendMetric appends the representation of m to b with protocol p, rate is
+// the sample rate of the metric, zero or one if it was not sampled.
+func appendMetric(b []byte, m *stats.Metric, p Protocol, rate float64) []byte {
+	value, typ := m.Value, metricType(m, p)
+
+	if p == StatsD {
+		if typ == "ms" && m.Unit == "seconds" {
+			value *= 1000
+		}
+
+		// A signed gauge value is interpreted by statsd servers as a change
+		// of the gauge, negative values are sent after resetting the gauge
+		// to zero so they are set as is.
+		if typ == "g" && value < 0 {
+			b = appendLine(b, m, p, 0, typ, 0)
+		}
+	}
+
+	return appendLine(b, m, p, value, typ, rate)
+}
+
+func appendLine(b []byte, m *stats.Metric, p Protocol, value float64, typ string, rate float64) []byte {
+	if len(m.Namespace) != 0 {
+		b = appendSanitized(b, m.Namespace, ":|@#\n")
+		b = append(b, '.')
+	}
+
+	b = appendSanitized(b, m.Name, ":|@#\n")
+
+	if p == StatsD {
+		for _, tag := range m.Tags {
+			if len(tag.Name) == 0 || len(tag.Value) == 0 {
+				continue
+			}
+			b = append(b, '.')
+			b = appendSanitized(b, tag.Name, ".:|@#\n")
+			b = append(b, '.')
+			b = appendSanitized(b, tag.Value, ".:|@#\n")
+		}
+	}
+
+	b = append(b, ':')
+	b = strconv.AppendFloat(b, value, 'g', -1, 64)
+	b = append(b, '|')
+	b = append(b, typ...)
+
+	if rate != 0 && rate != 1 {
+		b = append(b, '|', '@')
+		b = strconv.AppendFloat(b, rate, 'g', -1, 64)
+	}
+
+	if p == DogStatsD && len(m.Tags) != 0 {
+		b = append(b, '|', '#')
+
+		for i, tag := range m.Tags {
+			if i != 0 {
+				b = append(b, ',')
+			}
+			b = appendSanitized(b, tag.Name, ":|,#\n")
+			b = append(b, ':')
+			b = appendSanitized(b, tag.Value, "|,#\n")
+		}
+	}
+
+	return append(b, '\n')
+}
+
+func metricType(m *stats.Metric, p Protocol) string {
+	switch m.Type {
+	case stats.CounterType:
+		return "c"
+	case stats.GaugeType:
+		return "g"
+	default:
+		if p == DogStatsD {
+			return "h"
+		}
+		return "ms"
+	}
+}
+
+// appendSanitized appends s to b, replacing the characters in chars, which are
+// separators of the protocol, with underscores.
+func appendSanitized(b []byte, s string, chars string) []byte {
+	for i := 0; i != len(s); i++ {
+		c := s[i]
+
+		for j := 0; j != len(chars); j++ {
+			if c == chars[j] {
+				c = '_'
+				break
+			}
+		}
+
+		b = append(b, c)
+	}
+	return b
+}
@@ -0,0 +1,107 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAppendMetric(t *testing.T) {
+	tests := []struct {
+		protocol Protocol
+		rate     float64
+		s        string
+		m        stats.Metric
+	}{
+		{
+			protocol: StatsD,
+			s:        "test.requests.code.200.method.GET:1|c\n",
+			m: stats.Metric{
+				Type:      stats.CounterType,
+				Namespace: "test",
+				Name:      "requests",
+				Tags:      []stats.Tag{{"code", "200"}, {"method", "GET"}, {"empty", ""}},
+				Value:     1,
+			},
+		},
+		{
+			protocol: StatsD,
+			rate:     0.5,
+			s:        "latency.host.web1_example_com:250|ms|@0.5\n",
+			m: stats.Metric{
+				Type:  stats.HistogramType,
+				Name:  "latency",
+				Tags:  []stats.Tag{{"host", "web1.example.com"}},
+				Value: 0.25,
+				Unit:  "seconds",
+			},
+		},
+		{
+			protocol: StatsD,
+			s:        "temperature:0|g\ntemperature:-4|g\n",
+			m: stats.Metric{
+				Type:  stats.GaugeType,
+				Name:  "temperature",
+				Value: -4,
+			},
+		},
+		{
+			protocol: DogStatsD,
+			s:        "test.requests:1|c|#code:200,method:GET\n",
+			m: stats.Metric{
+				Type:      stats.CounterType,
+				Namespace: "test",
+				Name:      "requests",
+				Tags:      []stats.Tag{{"code", "200"}, {"method", "GET"}},
+				Value:     1,
+			},
+		},
+		{
+			protocol: DogStatsD,
+			rate:     0.1,
+			s:        "a_b:0.25|h|@0.1|#host:web1.example.com,x_y:a_b\n",
+			m: stats.Metric{
+				Type:  stats.HistogramType,
+				Name:  "a:b",
+				Tags:  []stats.Tag{{"host", "web1.example.com"}, {"x:y", "a,b"}},
+				Value: 0.25,
+				Unit:  "seconds",
+			},
+		},
+		{
+			protocol: DogStatsD,
+			s:        "temperature:-4|g\n",
+			m: stats.Metric{
+				Type:  stats.GaugeType,
+				Name:  "temperature",
+				Value: -4,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.protocol.String()+"/"+test.m.Name, func(t *testing.T) {
+			if s := string(appendMetric(nil, &test.m, test.protocol, test.rate)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
+
+func BenchmarkAppendMetric(b *testing.B) {
+	buffer := make([]byte, 4096)
+	metric := &stats.Metric{
+		Type:  stats.CounterType,
+		Name:  "test.metric.common",
+		Tags:  []stats.Tag{{"hello", "world"}, {"answer", "42"}},
+		Value: 1,
+	}
+
+	for _, protocol := range []Protocol{StatsD, DogStatsD} {
+		b.Run(protocol.String(), func(b *testing.B) {
+			for i := 0; i != b.N; i++ {
+				appendMetric(buffer[:0], metric, protocol, 0)
+			}
+		})
+	}
+}
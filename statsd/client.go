@@ -0,0 +1,221 @@
+// Package statsd exposes a client sending metrics to statsd servers, with the
+// plain statsd protocol or its dogstatsd extensions.
+package statsd
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/stats"
+)
+
+const (
+	// DefaultAddress is the default address of the statsd server that clients
+	// send metrics to.
+	DefaultAddress = "localhost:8125"
+
+	// DefaultPacketSize is the default maximum size of the datagrams sent over
+	// UDP, it fits in the 1500 bytes MTU of ethernet networks once the IP and
+	// UDP headers are added.
+	DefaultPacketSize = 1432
+
+	// DefaultUnixPacketSize is the default maximum size of the datagrams sent
+	// over unix domain sockets, which are not bounded by the MTU of a network.
+	DefaultUnixPacketSize = 8192
+
+	// unixPrefix is the prefix of the addresses of unix domain sockets.
+	unixPrefix = "unix://"
+)
+
+// The ClientConfig type is used to configure statsd clients.
+type ClientConfig struct {
+	// Address of the statsd server to send metrics to, either a host:port
+	// pair to send UDP datagrams to, or the path of a unix datagram socket
+	// prefixed with "unix://", for example "unix:///var/run/statsd.sock".
+	Address string
+
+	// Protocol is the variant of the statsd protocol spoken by the client,
+	// defaults to StatsD.
+	Protocol Protocol
+
+	// PacketSize is the maximum size of the datagrams sent by the client,
+	// metrics are packed in datagrams up to this size. Defaults to
+	// DefaultPacketSize over UDP and DefaultUnixPacketSize over unix domain
+	// sockets.
+	PacketSize int
+
+	// SampleRates sets the rate at which the client samples the metrics of
+	// each type, between 0 and 1. The rate is sent with the sampled metrics
+	// so servers scale their values, combined with the rate of metrics that
+	// were already sampled by the engine. Types missing from the map are not
+	// sampled.
+	SampleRates map[stats.MetricType]float64
+}
+
+// Client represents a statsd client that receives metrics from a stats engine
+// and sends them to a statsd server, packing multiple metrics in each datagram.
+type Client struct {
+	// Both fields are first for alignment of atomic operations.
+	dropped int64
+	errors  int64
+
+	mutex  sync.Mutex
+	config ClientConfig
+	conn   net.Conn
+	packet []byte
+	line   []byte
+	count  int // number of metrics in the packet
+	random func() float64
+}
+
+// NewClient creates and returns a new statsd client publishing metrics to the
+// server at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new statsd client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.PacketSize == 0 {
+		if strings.HasPrefix(config.Address, unixPrefix) {
+			config.PacketSize = DefaultUnixPacketSize
+		} else {
+			config.PacketSize = DefaultPacketSize
+		}
+	}
+
+	return &Client{
+		config: config,
+		packet: make([]byte, 0, config.PacketSize),
+		random: rand.Float64,
+	}
+}
+
+// Close satisfies the io.Closer interface.
+func (c *Client) Close() (err error) {
+	c.mutex.Lock()
+	c.send()
+
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+
+	c.mutex.Unlock()
+	return
+}
+
+// Flush satisfies the stats.Flusher interface, it sends the metrics packed in
+// the current datagram.
+func (c *Client) Flush() {
+	c.mutex.Lock()
+	c.send()
+	c.mutex.Unlock()
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	rate := m.Rate
+
+	if r, ok := c.config.SampleRates[m.Type]; ok && r < 1 {
+		if c.random() >= r {
+			return
+		}
+		rate = combineRates(rate, r)
+	}
+
+	c.mutex.Lock()
+	c.line = appendMetric(c.line[:0], m, c.config.Protocol, rate)
+
+	switch {
+	case len(c.line) > c.config.PacketSize:
+		atomic.AddInt64(&c.dropped, 1)
+		log.Printf("stats/statsd: discarding metric %s because it does not fit in a datagram of %d bytes", m.Name, c.config.PacketSize)
+
+	default:
+		if len(c.packet)+len(c.line) > c.config.PacketSize {
+			c.send()
+		}
+		c.packet = append(c.packet, c.line...)
+		c.count++
+	}
+
+	c.mutex.Unlock()
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// metrics discarded because they could not be sent to the server.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// failed attempts to connect or write to the server.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// send writes the packet to the server, the metrics are discarded if it fails
+// since datagrams are not retried.
+func (c *Client) send() {
+	if len(c.packet) == 0 {
+		return
+	}
+
+	if c.conn != nil || c.dial() {
+		if _, err := c.conn.Write(c.packet); err != nil {
+			atomic.AddInt64(&c.errors, 1)
+			atomic.AddInt64(&c.dropped, int64(c.count))
+			log.Printf("stats/statsd: sending metrics to %s failed: %s", c.config.Address, err)
+
+			// Unix sockets fail when the server restarts, the connection is
+			// opened again on the next attempt.
+			c.conn.Close()
+			c.conn = nil
+		}
+	} else {
+		atomic.AddInt64(&c.dropped, int64(c.count))
+	}
+
+	c.packet = c.packet[:0]
+	c.count = 0
+}
+
+func (c *Client) dial() bool {
+	network, addr := "udp", c.config.Address
+
+	if strings.HasPrefix(addr, unixPrefix) {
+		network, addr = "unixgram", addr[len(unixPrefix):]
+	}
+
+	conn, err := net.Dial(network, addr)
+
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/statsd: connecting to %s failed: %s", c.config.Address, err)
+		return false
+	}
+
+	c.conn = conn
+	return true
+}
+
+// combineRates returns the sample rate of a metric sampled at both r1 and r2,
+// zero means that a metric was not sampled.
+func combineRates(r1 float64, r2 float64) float64 {
+	if r1 == 0 {
+		return r2
+	}
+	return r1 * r2
+}
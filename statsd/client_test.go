@@ -0,0 +1,131 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// readPackets returns the datagrams received on conn until it times out.
+func readPackets(t *testing.T, conn net.PacketConn) []string {
+	var packets []string
+	b := make([]byte, 65536)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(b[:n]))
+	}
+}
+
+func TestClientPacking(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    conn.LocalAddr().String(),
+		PacketSize: 20,
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("E")
+	engine.Register(client)
+	engine.Incr("A")
+	engine.Incr("B")
+	engine.Incr("C")
+	engine.Incr("D", stats.Tag{"name", "a-value-too-large-for-the-packet"})
+	engine.Flush()
+
+	packets := readPackets(t, conn)
+	want := []string{"E.A:1|c\nE.B:1|c\n", "E.C:1|c\n"}
+
+	if strings.Join(packets, "|") != strings.Join(want, "|") {
+		t.Errorf("bad packets:\n- expected: %q\n- found:    %q", want, packets)
+	}
+
+	if n := client.Dropped(); n != 1 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+}
+
+func TestClientSampleRates(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:     conn.LocalAddr().String(),
+		Protocol:    DogStatsD,
+		SampleRates: map[stats.MetricType]float64{stats.HistogramType: 0.5},
+	})
+	defer client.Close()
+
+	values := []float64{0.7, 0.2}
+	client.random = func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+
+	client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "A", Value: 1})
+	client.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "A", Value: 2, Rate: 0.5})
+	client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "B", Value: 1})
+	client.Flush()
+
+	packets := readPackets(t, conn)
+
+	if s := strings.Join(packets, ""); s != "A:2|h|@0.25\nB:1|c\n" {
+		t.Errorf("bad packets: %q", s)
+	}
+}
+
+func TestClientUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "statsd.sock")
+	client := NewClient("unix://" + path)
+	defer client.Close()
+
+	if client.config.PacketSize != DefaultUnixPacketSize {
+		t.Error("bad packet size:", client.config.PacketSize)
+	}
+
+	// The socket does not exist yet, the metrics are dropped.
+	client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "A", Value: 1})
+	client.Flush()
+
+	if n := client.Dropped(); n != 1 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	client.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "B", Value: 1})
+	client.Flush()
+
+	if packets := readPackets(t, conn); len(packets) != 1 || packets[0] != "B:1|c\n" {
+		t.Errorf("bad packets: %q", packets)
+	}
+}
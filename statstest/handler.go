@@ -0,0 +1,59 @@
+// Package statstest exposes utilities to test the instrumentation of programs
+// using the stats package.
+package statstest
+
+import (
+	"sync"
+
+	"github.com/segmentio/stats"
+)
+
+// Handler is a stats handler retaining in memory all the metrics it receives,
+// it is safe to use concurrently.
+type Handler struct {
+	mutex   sync.Mutex
+	metrics []stats.Metric
+	flushed int
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (h *Handler) HandleMetric(m *stats.Metric) {
+	c := *m
+	c.Tags = append([]stats.Tag(nil), m.Tags...)
+
+	h.mutex.Lock()
+	h.metrics = append(h.metrics, c)
+	h.mutex.Unlock()
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (h *Handler) Flush() {
+	h.mutex.Lock()
+	h.flushed++
+	h.mutex.Unlock()
+}
+
+// Reset satisfies the stats.Resetter interface, it discards the metrics
+// received by the handler.
+func (h *Handler) Reset() {
+	h.mutex.Lock()
+	h.metrics = nil
+	h.mutex.Unlock()
+}
+
+// Metrics returns a copy of the list of metrics received by the handler since
+// it was created or reset, in the order they were received.
+func (h *Handler) Metrics() []stats.Metric {
+	h.mutex.Lock()
+	metrics := append([]stats.Metric(nil), h.metrics...)
+	h.mutex.Unlock()
+	return metrics
+}
+
+// FlushCalls returns the number of times the handler was flushed.
+func (h *Handler) FlushCalls() int {
+	h.mutex.Lock()
+	n := h.flushed
+	h.mutex.Unlock()
+	return n
+}
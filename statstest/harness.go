@@ -0,0 +1,354 @@
+package statstest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+// Harness is a handler exposing fluent assertions on the state of the metrics
+// it received, for example:
+//
+//	h := statstest.NewHarness(t, eng)
+//	...
+//	h.AssertCounter("errors").WithTag("code", "500").Equals(3)
+//	h.AssertHistogram("latency").CountEquals(1)
+//
+// The state of a series is the sum of the values of counters, the last value
+// of gauges, and the count and sum of the values observed by histograms, see
+// stats.DiffState. Failed assertions are reported as errors of the test with
+// the nearest series found, so typos in names or tags are easy to spot.
+type Harness struct {
+	Handler
+	t testing.TB
+}
+
+// NewHarness returns a harness reporting failed assertions to t, registered on
+// eng unless it is nil.
+func NewHarness(t testing.TB, eng *stats.Engine) *Harness {
+	h := &Harness{t: t}
+
+	if eng != nil {
+		eng.Register(h)
+	}
+
+	return h
+}
+
+// State returns the state of all series received by the harness, sorted by
+// namespace, name, tags, and type.
+func (h *Harness) State() []stats.MetricDelta {
+	return stats.DiffState(nil, h.Metrics())
+}
+
+// AssertCounter returns an assertion on the counter with name, the name may be
+// prefixed with the namespace of the metric.
+func (h *Harness) AssertCounter(name string) *Assertion {
+	return &Assertion{h: h, typ: stats.CounterType, name: name}
+}
+
+// AssertGauge returns an assertion on the gauge with name, the name may be
+// prefixed with the namespace of the metric.
+func (h *Harness) AssertGauge(name string) *Assertion {
+	return &Assertion{h: h, typ: stats.GaugeType, name: name}
+}
+
+// AssertHistogram returns an assertion on the histogram with name, the name may
+// be prefixed with the namespace of the metric.
+func (h *Harness) AssertHistogram(name string) *Assertion {
+	return &Assertion{h: h, typ: stats.HistogramType, name: name}
+}
+
+// Assertion represents an assertion on the series of a metric, it matches the
+// series of the metric carrying at least the tags it was configured with,
+// regardless of their order.
+//
+// When several series match, the values of counters and histograms are summed
+// while gauges are reported as ambiguous.
+type Assertion struct {
+	h    *Harness
+	typ  stats.MetricType
+	name string
+	tags []stats.Tag
+}
+
+// WithTag returns a copy of the assertion restricted to the series where the
+// tag with name is set to value.
+func (a *Assertion) WithTag(name string, value string) *Assertion {
+	c := *a
+	c.tags = append(append([]stats.Tag(nil), a.tags...), stats.Tag{Name: name, Value: value})
+	return &c
+}
+
+// Exists asserts that at least one series matches, it returns whether the
+// assertion succeeded.
+func (a *Assertion) Exists() bool {
+	a.h.t.Helper()
+	_, ok := a.match()
+	return ok
+}
+
+// Absent asserts that no series match, it returns whether the assertion
+// succeeded.
+func (a *Assertion) Absent() bool {
+	a.h.t.Helper()
+
+	if matches := a.find(); len(matches) != 0 {
+		a.h.t.Errorf("statstest: expected no %s, found %s", a, formatSeries(matches[0]))
+		return false
+	}
+
+	return true
+}
+
+// Equals asserts that the value of the matching series equals value, which is
+// the sum of the increments of counters, the last value of gauges, and the sum
+// of the values observed by histograms. It returns whether the assertion
+// succeeded.
+func (a *Assertion) Equals(value float64) bool {
+	a.h.t.Helper()
+
+	d, ok := a.match()
+	if !ok {
+		return false
+	}
+
+	if d.After != value {
+		a.h.t.Errorf("statstest: %s: expected value %g, found %g", a, value, d.After)
+		return false
+	}
+
+	return true
+}
+
+// SumEquals asserts that the sum of the values observed by the matching
+// histograms equals sum, it returns whether the assertion succeeded.
+func (a *Assertion) SumEquals(sum float64) bool {
+	a.h.t.Helper()
+	return a.Equals(sum)
+}
+
+// CountEquals asserts that the number of values observed by the matching
+// histograms equals count, it returns whether the assertion succeeded.
+func (a *Assertion) CountEquals(count int) bool {
+	a.h.t.Helper()
+
+	d, ok := a.match()
+	if !ok {
+		return false
+	}
+
+	if d.Count != count {
+		a.h.t.Errorf("statstest: %s: expected %d observations, found %d", a, count, d.Count)
+		return false
+	}
+
+	return true
+}
+
+// String satisfies the fmt.Stringer interface.
+func (a *Assertion) String() string {
+	s := fmt.Sprintf("%s %s", a.typ, a.name)
+
+	if len(a.tags) != 0 {
+		s += " with tags " + formatTags(a.tags)
+	}
+
+	return s
+}
+
+// match returns the aggregated state of the matching series, or reports an
+// error naming the nearest series if none match.
+func (a *Assertion) match() (stats.MetricDelta, bool) {
+	a.h.t.Helper()
+	state := a.h.State()
+	matches := a.filter(state)
+
+	switch {
+	case len(matches) == 0:
+		if n, ok := a.nearest(state); ok {
+			a.h.t.Errorf("statstest: no %s, nearest series: %s", a, formatSeries(n))
+		} else {
+			a.h.t.Errorf("statstest: no %s, no metrics were reported", a)
+		}
+		return stats.MetricDelta{}, false
+
+	case len(matches) > 1 && a.typ == stats.GaugeType:
+		series := make([]string, len(matches))
+		for i, m := range matches {
+			series[i] = formatSeries(m)
+		}
+		a.h.t.Errorf("statstest: ambiguous %s, it matches %s", a, strings.Join(series, ", "))
+		return stats.MetricDelta{}, false
+	}
+
+	d := matches[0]
+
+	for _, m := range matches[1:] {
+		d.After += m.After
+		d.Count += m.Count
+	}
+
+	return d, true
+}
+
+func (a *Assertion) find() []stats.MetricDelta {
+	return a.filter(a.h.State())
+}
+
+func (a *Assertion) filter(state []stats.MetricDelta) []stats.MetricDelta {
+	var matches []stats.MetricDelta
+
+	for _, d := range state {
+		if d.Type == a.typ && a.matchName(d) && matchingTags(a.tags, d.Tags) == len(a.tags) {
+			matches = append(matches, d)
+		}
+	}
+
+	return matches
+}
+
+func (a *Assertion) matchName(d stats.MetricDelta) bool {
+	return d.Name == a.name || fullName(d) == a.name
+}
+
+// nearest returns the series nearest to the assertion: the series of the
+// metric with the most similar tags if the metric exists, or the series with
+// the closest name otherwise.
+func (a *Assertion) nearest(state []stats.MetricDelta) (nearest stats.MetricDelta, found bool) {
+	best := 0
+
+	for _, d := range state {
+		if !a.matchName(d) {
+			continue
+		}
+
+		score := similarTags(a.tags, d.Tags)
+
+		if d.Type == a.typ {
+			// Series of the expected type are preferred to series of
+			// metrics reported with another type.
+			score += 1 << 20
+		}
+
+		if !found || score > best {
+			nearest, best, found = d, score, true
+		}
+	}
+
+	if found {
+		return
+	}
+
+	for _, d := range state {
+		score := -distance(a.name, d.Name)
+
+		if !found || score > best {
+			nearest, best, found = d, score, true
+		}
+	}
+
+	return
+}
+
+// matchingTags returns the number of tags in expected that are set to the same
+// value in tags.
+func matchingTags(expected []stats.Tag, tags []stats.Tag) int {
+	n := 0
+
+	for _, e := range expected {
+		for _, t := range tags {
+			if t == e {
+				n++
+				break
+			}
+		}
+	}
+
+	return n
+}
+
+// similarTags scores the similarity of tags with expected: tags set to the
+// expected values score the most, then tags with the expected names, and tags
+// which are not expected lower the score.
+func similarTags(expected []stats.Tag, tags []stats.Tag) int {
+	score := 2 * matchingTags(expected, tags)
+
+	for _, t := range tags {
+		found := false
+
+		for _, e := range expected {
+			if t.Name == e.Name {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			score++
+		} else {
+			score--
+		}
+	}
+
+	return score
+}
+
+func fullName(d stats.MetricDelta) string {
+	return stats.MetricSchema{Namespace: d.Namespace, Name: d.Name}.FullName()
+}
+
+func formatSeries(d stats.MetricDelta) string {
+	return fmt.Sprintf("%s %s%s", d.Type, fullName(d), formatTags(d.Tags))
+}
+
+func formatTags(tags []stats.Tag) string {
+	tags = append([]stats.Tag(nil), tags...)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+
+	s := make([]string, len(tags))
+	for i, t := range tags {
+		s[i] = t.Name + "=" + t.Value
+	}
+
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+// distance returns the Levenshtein distance between s1 and s2.
+func distance(s1 string, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	next := make([]int, len(s2)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(s1); i++ {
+		next[0] = i
+
+		for j := 1; j <= len(s2); j++ {
+			cost := 1
+			if s1[i-1] == s2[j-1] {
+				cost = 0
+			}
+			next[j] = min(prev[j]+1, next[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, next = next, prev
+	}
+
+	return prev[len(s2)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,129 @@
+package statstest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/stats"
+)
+
+// recorder captures the errors reported by harnesses.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestHarness(t *testing.T) {
+	r := &recorder{}
+	e := stats.NewEngine("E")
+	h := NewHarness(r, e)
+
+	e.Add("errors", 2, stats.Tag{"code", "500"}, stats.Tag{"method", "GET"})
+	e.Incr("errors", stats.Tag{"method", "POST"}, stats.Tag{"code", "500"})
+	e.Incr("errors", stats.Tag{"code", "502"})
+	e.Set("conns", 1, stats.Tag{"pool", "a"})
+	e.Set("conns", 4, stats.Tag{"pool", "a"})
+	e.Set("conns", 2, stats.Tag{"pool", "b"})
+	e.Observe("latency", 1)
+	e.Observe("latency", 2)
+
+	tests := []struct {
+		scenario string
+		assert   func() bool
+		err      string
+	}{
+		{
+			scenario: "counters are summed across matching series",
+			assert:   func() bool { return h.AssertCounter("errors").WithTag("code", "500").Equals(3) },
+		},
+		{
+			scenario: "tags are matched regardless of their order",
+			assert: func() bool {
+				return h.AssertCounter("E.errors").WithTag("method", "GET").WithTag("code", "500").Equals(2)
+			},
+		},
+		{
+			scenario: "gauges report their last value",
+			assert:   func() bool { return h.AssertGauge("conns").WithTag("pool", "a").Equals(4) },
+		},
+		{
+			scenario: "histograms report their count and sum",
+			assert: func() bool {
+				return h.AssertHistogram("latency").CountEquals(2) && h.AssertHistogram("latency").SumEquals(3)
+			},
+		},
+		{
+			scenario: "absent series",
+			assert:   func() bool { return h.AssertCounter("errors").WithTag("code", "404").Absent() },
+		},
+		{
+			scenario: "mismatching values are reported",
+			assert:   func() bool { return h.AssertCounter("errors").WithTag("code", "502").Equals(2) },
+			err:      "statstest: counter errors with tags {code=502}: expected value 2, found 1",
+		},
+		{
+			scenario: "the nearest series of the metric is reported",
+			assert:   func() bool { return h.AssertCounter("errors").WithTag("code", "503").Exists() },
+			err:      "statstest: no counter errors with tags {code=503}, nearest series: counter E.errors{code=502}",
+		},
+		{
+			scenario: "the series with the nearest name is reported",
+			assert:   func() bool { return h.AssertHistogram("latancy").CountEquals(2) },
+			err:      "statstest: no histogram latancy, nearest series: histogram E.latency{}",
+		},
+		{
+			scenario: "ambiguous gauges are reported",
+			assert:   func() bool { return h.AssertGauge("conns").Equals(4) },
+			err:      "statstest: ambiguous gauge conns, it matches gauge E.conns{pool=a}, gauge E.conns{pool=b}",
+		},
+		{
+			scenario: "unexpected series are reported",
+			assert:   func() bool { return h.AssertGauge("conns").WithTag("pool", "b").Absent() },
+			err:      "statstest: expected no gauge conns with tags {pool=b}, found gauge E.conns{pool=b}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			r.errors = nil
+			ok := test.assert()
+
+			if ok != (test.err == "") {
+				t.Error("bad assertion result:", ok)
+			}
+
+			switch {
+			case test.err == "" && len(r.errors) != 0:
+				t.Error("unexpected errors:", r.errors)
+			case test.err != "" && (len(r.errors) != 1 || r.errors[0] != test.err):
+				t.Errorf("bad errors:\n- expected: %q\n- found:    %q", test.err, r.errors)
+			}
+		})
+	}
+}
+
+func TestHarnessReset(t *testing.T) {
+	r := &recorder{}
+	e := stats.NewEngine("E")
+	h := NewHarness(r, e)
+
+	e.Incr("errors")
+	h.Reset()
+	e.Incr("errors")
+
+	if !h.AssertCounter("errors").Equals(1) {
+		t.Error(r.errors)
+	}
+
+	h.Reset()
+
+	if h.AssertCounter("errors").Exists() || r.errors[len(r.errors)-1] != "statstest: no counter errors, no metrics were reported" {
+		t.Error("bad errors:", r.errors)
+	}
+}
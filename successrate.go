@@ -0,0 +1,160 @@
+package stats
+
+import "sync"
+
+const (
+	// SuccessSuffix is appended to the names of success rates to form the
+	// name of the counter of successful operations.
+	SuccessSuffix = ".success"
+
+	// TotalSuffix is appended to the names of success rates to form the name
+	// of the counter of all operations.
+	TotalSuffix = ".total"
+
+	// SuccessRatioSuffix is appended to the names of success rates to form the
+	// name of the gauge reporting the ratio of successful operations.
+	SuccessRatioSuffix = ".success_ratio"
+)
+
+// A SuccessRate tracks the outcome of operations with a pair of counters, the
+// number of successful operations and the total number of operations, which
+// are the numerator and denominator of service level objectives.
+//
+// Both counters are reported in a single batch every time an outcome is
+// recorded, so handlers implementing the BatchHandler interface never expose
+// a success count which is ahead of the total.
+type SuccessRate struct {
+	eng   *Engine // the engine to produce metrics on
+	name  string  // the name of the success rate
+	tags  []Tag   // the tags set on the success rate
+	ratio bool    // whether the success ratio gauge is reported
+	state *successRateState
+}
+
+// successRateState is shared by copies of a success rate returned by the
+// WithRatio method, which report the same series.
+type successRateState struct {
+	mutex   sync.Mutex
+	success float64
+	total   float64
+}
+
+// SuccessRate creates a new success rate producing metrics with name and tags
+// on eng.
+func (eng *Engine) SuccessRate(name string, tags ...Tag) *SuccessRate {
+	keys := tagKeys(eng.tags, tags)
+
+	for _, suffix := range []string{SuccessSuffix, TotalSuffix} {
+		eng.schema.describe(MetricSchema{
+			Type:      CounterType,
+			Namespace: eng.name,
+			Name:      name + suffix,
+			TagKeys:   keys,
+		})
+	}
+
+	return &SuccessRate{
+		eng:   eng,
+		name:  name,
+		tags:  copyTags(tags),
+		state: &successRateState{},
+	}
+}
+
+// Name returns the name of the success rate.
+func (r *SuccessRate) Name() string {
+	return r.name
+}
+
+// Tags returns the list of tags set on the success rate.
+//
+// The method returns a reference to the internal tag slice, it does not make a
+// copy. It's expected that the program will treat this value as a read-only
+// list and won't modify its content.
+func (r *SuccessRate) Tags() []Tag {
+	return r.tags
+}
+
+// Ratio returns the ratio of successful operations recorded so far, or zero if
+// no operations were recorded.
+func (r *SuccessRate) Ratio() float64 {
+	r.state.mutex.Lock()
+	defer r.state.mutex.Unlock()
+	return r.state.ratio()
+}
+
+// WithTags returns a copy of the success rate, potentially setting tags on the
+// returned object.
+//
+// The internal counts of the returned success rate are set to zero.
+func (r *SuccessRate) WithTags(tags ...Tag) *SuccessRate {
+	return &SuccessRate{
+		eng:   r.eng,
+		name:  r.name,
+		tags:  concatTags(r.tags, tags),
+		ratio: r.ratio,
+		state: &successRateState{},
+	}
+}
+
+// WithRatio returns a copy of the success rate which also reports the ratio of
+// successful operations on a gauge named after the success rate with the
+// SuccessRatioSuffix, so dashboards can display it without dividing the
+// counters in queries.
+//
+// The ratio is computed from the operations recorded since the success rate
+// was created. It becomes less sensitive to recent failures as the number of
+// operations grows, computing the ratio of the counters' rates over a window
+// in queries is more accurate when the backend supports it.
+func (r *SuccessRate) WithRatio() *SuccessRate {
+	r.eng.schema.describe(MetricSchema{
+		Type:      GaugeType,
+		Namespace: r.eng.name,
+		Name:      r.name + SuccessRatioSuffix,
+		TagKeys:   tagKeys(r.eng.tags, r.tags),
+	})
+
+	return &SuccessRate{
+		eng:   r.eng,
+		name:  r.name,
+		tags:  r.tags,
+		ratio: true,
+		state: r.state,
+	}
+}
+
+// Record records the outcome of an operation, incrementing the total counter
+// and, if success is true, the success counter.
+//
+// The success counter is reported with an increment of zero on failures so
+// its series exists as soon as the total does.
+func (r *SuccessRate) Record(success bool) {
+	inc := 0.0
+	if success {
+		inc = 1
+	}
+
+	s := r.state
+	s.mutex.Lock()
+	s.success += inc
+	s.total++
+	ratio := s.ratio()
+	s.mutex.Unlock()
+
+	b := r.eng.Batch()
+	b.Add(r.name+SuccessSuffix, inc, r.tags...)
+	b.Add(r.name+TotalSuffix, 1, r.tags...)
+
+	if r.ratio {
+		b.Set(r.name+SuccessRatioSuffix, ratio, r.tags...)
+	}
+
+	b.Commit()
+}
+
+func (s *successRateState) ratio() float64 {
+	if s.total == 0 {
+		return 0
+	}
+	return s.success / s.total
+}
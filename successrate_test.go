@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuccessRate(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	r := e.SuccessRate("requests", Tag{"path", "/"})
+	r.Record(true)
+	r.Record(false)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "requests.success", Tags: []Tag{{"path", "/"}}, Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "requests.total", Tags: []Tag{{"path", "/"}}, Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "requests.success", Tags: []Tag{{"path", "/"}}, Value: 0},
+		{Type: CounterType, Namespace: "E", Name: "requests.total", Tags: []Tag{{"path", "/"}}, Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	h.Reset()
+	w := r.WithRatio()
+	w.Record(true)
+	w.Record(true)
+
+	if !reflect.DeepEqual(h.metrics[len(h.metrics)-1], Metric{
+		Type:      GaugeType,
+		Namespace: "E",
+		Name:      "requests.success_ratio",
+		Tags:      []Tag{{"path", "/"}},
+		Value:     0.75,
+	}) {
+		t.Error("bad success ratio:", h.metrics)
+	}
+
+	if ratio := r.Ratio(); ratio != 0.75 {
+		t.Error("the success rate and its copy with a ratio don't share their counts:", ratio)
+	}
+
+	if ratio := r.WithTags(Tag{"method", "GET"}).Ratio(); ratio != 0 {
+		t.Error("bad ratio of a success rate with no operations:", ratio)
+	}
+}
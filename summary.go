@@ -0,0 +1,252 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Objective is a target of the quantile sketches of summaries, the quantile
+// between 0 and 1 is estimated with a rank error of at most Error, for example
+// {Quantile: 0.99, Error: 0.001} estimates the 99th percentile with a value
+// between the 98.9th and 99.1th percentiles.
+type Objective struct {
+	Quantile float64 `json:"quantile"`
+	Error    float64 `json:"error"`
+}
+
+// DefaultObjectives is the list of objectives of summaries declared without
+// objectives.
+var DefaultObjectives = []Objective{
+	{Quantile: 0.5, Error: 0.05},
+	{Quantile: 0.9, Error: 0.01},
+	{Quantile: 0.99, Error: 0.001},
+}
+
+// A Summary represents a metric that reports a distribution of observed values
+// as a list of quantiles, which handlers estimate with quantile sketches.
+//
+// Unlike histograms, which count the values in predefined buckets that can be
+// aggregated across instances, summaries report precise quantiles for each
+// series but cannot be aggregated. Handlers which do not support summaries
+// report them like histograms.
+type Summary struct {
+	eng  *Engine // the engine to produce metrics on
+	name string  // the name of the summary
+	tags []Tag   // the tags set on the summary
+}
+
+// Name returns the name of the summary.
+func (s *Summary) Name() string {
+	return s.name
+}
+
+// Tags returns the list of tags set on the summary.
+//
+// The method returns a reference to the summary's internal tag slice, it does
+// not make a copy. It's expected that the program will treat this value as a
+// read-only list and won't modify its content.
+func (s *Summary) Tags() []Tag {
+	return s.tags
+}
+
+// WithTags returns a copy of the summary, potentially setting tags on the
+// returned object.
+func (s *Summary) WithTags(tags ...Tag) *Summary {
+	return &Summary{
+		eng:  s.eng,
+		name: s.name,
+		tags: concatTags(s.tags, tags),
+	}
+}
+
+// WithHelp sets the help text of the summary's metric, which handlers like the
+// prometheus handler expose, and returns the summary so the call can be chained
+// with its creation.
+func (s *Summary) WithHelp(help string) *Summary {
+	s.eng.describeHelp(SummaryType, s.name, help)
+	return s
+}
+
+// Observe reports a value observed by the summary.
+func (s *Summary) Observe(value float64) {
+	s.eng.handle(SummaryType, s.name, value, "", s.tags, time.Time{}, time.Time{}, nil)
+}
+
+// ObserveDuration reports a duration observed by the summary, in seconds.
+func (s *Summary) ObserveDuration(value time.Duration) {
+	s.eng.handle(SummaryType, s.name, value.Seconds(), "seconds", s.tags, time.Time{}, time.Time{}, nil)
+}
+
+// QuantileSketch estimates quantiles of a stream of values with bounded errors
+// on the ranks of a list of objectives, using the targeted quantiles algorithm
+// of Cormode, Korn, Muthukrishnan, and Srivastava. The memory used by the
+// sketch grows with the logarithm of the number of values.
+//
+// QuantileSketch values are not safe to use concurrently.
+type QuantileSketch struct {
+	objectives []Objective
+	samples    []sketchSample
+	buffer     []float64
+	count      float64
+}
+
+type sketchSample struct {
+	value float64
+	width float64 // difference between the lowest ranks of the sample and the previous one
+	delta float64 // difference between the highest and lowest ranks of the sample
+}
+
+// sketchBufferSize is the number of values buffered by sketches before being
+// merged into their samples.
+const sketchBufferSize = 500
+
+// NewQuantileSketch returns a sketch estimating quantiles of values with the
+// accuracy of objectives, DefaultObjectives is used when the list is empty.
+func NewQuantileSketch(objectives ...Objective) *QuantileSketch {
+	if len(objectives) == 0 {
+		objectives = DefaultObjectives
+	}
+
+	return &QuantileSketch{
+		objectives: objectives,
+		buffer:     make([]float64, 0, sketchBufferSize),
+	}
+}
+
+// Objectives returns the list of objectives of the sketch.
+func (s *QuantileSketch) Objectives() []Objective {
+	return s.objectives
+}
+
+// Insert adds value to the sketch.
+func (s *QuantileSketch) Insert(value float64) {
+	if s.buffer = append(s.buffer, value); len(s.buffer) == cap(s.buffer) {
+		s.flush()
+	}
+}
+
+// Count returns the number of values inserted in the sketch.
+func (s *QuantileSketch) Count() int {
+	return int(s.count) + len(s.buffer)
+}
+
+// Query returns the estimate of the quantile q of the values inserted in the
+// sketch, which is only accurate for the quantiles of the objectives. NaN is
+// returned when the sketch is empty.
+func (s *QuantileSketch) Query(q float64) float64 {
+	s.flush()
+
+	if len(s.samples) == 0 {
+		return math.NaN()
+	}
+
+	rank := math.Ceil(q * s.count)
+	rank += math.Ceil(s.invariant(rank) / 2)
+	prev := s.samples[0]
+	r := 0.0
+
+	for _, c := range s.samples[1:] {
+		r += prev.width
+
+		if r+c.width+c.delta > rank {
+			return prev.value
+		}
+
+		prev = c
+	}
+
+	return prev.value
+}
+
+// Reset discards the values inserted in the sketch.
+func (s *QuantileSketch) Reset() {
+	s.samples = s.samples[:0]
+	s.buffer = s.buffer[:0]
+	s.count = 0
+}
+
+// invariant returns the maximum error allowed on the rank r, the minimum of the
+// errors allowed by each objective.
+func (s *QuantileSketch) invariant(r float64) float64 {
+	min := math.MaxFloat64
+
+	for _, o := range s.objectives {
+		var f float64
+
+		if o.Quantile*s.count <= r {
+			f = (2 * o.Error * r) / o.Quantile
+		} else {
+			f = (2 * o.Error * (s.count - r)) / (1 - o.Quantile)
+		}
+
+		if f < min {
+			min = f
+		}
+	}
+
+	return min
+}
+
+// flush merges the buffered values into the samples of the sketch.
+func (s *QuantileSketch) flush() {
+	if len(s.buffer) == 0 {
+		return
+	}
+
+	sort.Float64s(s.buffer)
+	r := 0.0
+	i := 0
+
+	for _, value := range s.buffer {
+		for i < len(s.samples) && s.samples[i].value <= value {
+			r += s.samples[i].width
+			i++
+		}
+
+		sample := sketchSample{value: value, width: 1}
+
+		if i != 0 && i != len(s.samples) {
+			sample.delta = math.Max(0, math.Floor(s.invariant(r))-1)
+		}
+
+		s.samples = append(s.samples, sketchSample{})
+		copy(s.samples[i+1:], s.samples[i:])
+		s.samples[i] = sample
+		s.count++
+		r++
+		i++
+	}
+
+	s.buffer = s.buffer[:0]
+	s.compress()
+}
+
+// compress merges adjacent samples when the error of the merged sample stays
+// within the invariant.
+func (s *QuantileSketch) compress() {
+	if len(s.samples) < 2 {
+		return
+	}
+
+	x := s.samples[len(s.samples)-1]
+	xi := len(s.samples) - 1
+	r := s.count - 1 - x.width
+
+	for i := len(s.samples) - 2; i >= 0; i-- {
+		c := s.samples[i]
+
+		if c.width+x.width+x.delta <= s.invariant(r) {
+			x.width += c.width
+			s.samples[xi] = x
+			copy(s.samples[i:], s.samples[i+1:])
+			s.samples = s.samples[:len(s.samples)-1]
+			xi--
+		} else {
+			x = c
+			xi = i
+		}
+
+		r -= c.width
+	}
+}
@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type describeHandler struct {
+	handler
+	schemas []MetricSchema
+}
+
+func (h *describeHandler) DescribeMetric(s MetricSchema) {
+	h.schemas = append(h.schemas, s)
+}
+
+func TestEngineSummary(t *testing.T) {
+	h := &describeHandler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	objectives := []Objective{{Quantile: 0.5, Error: 0.01}}
+	s := e.Summary("latency", objectives, Tag{"A", "1"})
+	s.Observe(1)
+	s.WithTags(Tag{"B", "2"}).ObserveDuration(2 * time.Second)
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: SummaryType, Namespace: "E", Name: "latency", Value: 1, Tags: []Tag{{"A", "1"}}},
+		{Type: SummaryType, Namespace: "E", Name: "latency", Value: 2, Unit: "seconds", Tags: []Tag{{"A", "1"}, {"B", "2"}}},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if len(h.schemas) != 1 || !reflect.DeepEqual(h.schemas[0].Objectives, objectives) {
+		t.Error("the objectives were not passed to the handler:", h.schemas)
+	}
+
+	// Handlers registered later receive the objectives as well.
+	h2 := &describeHandler{}
+	e.Register(h2)
+
+	if len(h2.schemas) != 1 || !reflect.DeepEqual(h2.schemas[0].Objectives, objectives) {
+		t.Error("the objectives were not passed to the handler registered later:", h2.schemas)
+	}
+
+	if d := e.Summary("size", nil); d.Name() != "size" || len(d.Tags()) != 0 {
+		t.Error("bad summary:", d.Name(), d.Tags())
+	}
+
+	if schema := e.Schema(); len(schema) != 2 || !reflect.DeepEqual(schema[1].Objectives, DefaultObjectives) {
+		t.Error("bad schema:", schema)
+	}
+}
+
+func TestQuantileSketch(t *testing.T) {
+	tests := []struct {
+		scenario string
+		count    int
+		values   func(i int, rng *rand.Rand) float64
+	}{
+		{
+			scenario: "uniform values",
+			count:    100000,
+			values:   func(i int, rng *rand.Rand) float64 { return rng.Float64() },
+		},
+		{
+			scenario: "exponential values",
+			count:    100000,
+			values:   func(i int, rng *rand.Rand) float64 { return rng.ExpFloat64() },
+		},
+		{
+			scenario: "increasing values",
+			count:    10000,
+			values:   func(i int, rng *rand.Rand) float64 { return float64(i) },
+		},
+		{
+			scenario: "few values",
+			count:    10,
+			values:   func(i int, rng *rand.Rand) float64 { return float64(10 - i) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			sketch := NewQuantileSketch()
+			values := make([]float64, test.count)
+
+			for i := range values {
+				values[i] = test.values(i, rng)
+				sketch.Insert(values[i])
+			}
+
+			sort.Float64s(values)
+
+			if n := sketch.Count(); n != test.count {
+				t.Error("bad count:", n)
+			}
+
+			for _, o := range DefaultObjectives {
+				v := sketch.Query(o.Quantile)
+				rank := float64(sort.SearchFloat64s(values, v)) / float64(len(values))
+
+				// Allow one value of error for small counts.
+				if math.Abs(rank-o.Quantile) > o.Error+1/float64(len(values)) {
+					t.Errorf("quantile %g: the rank of %g is %g", o.Quantile, v, rank)
+				}
+			}
+
+			if len(sketch.samples) >= test.count && test.count > 1000 {
+				t.Error("the sketch did not compress its samples:", len(sketch.samples))
+			}
+		})
+	}
+}
+
+func TestQuantileSketchEmpty(t *testing.T) {
+	sketch := NewQuantileSketch()
+
+	if v := sketch.Query(0.5); !math.IsNaN(v) {
+		t.Error("bad quantile of an empty sketch:", v)
+	}
+
+	sketch.Insert(1)
+	sketch.Reset()
+
+	if n := sketch.Count(); n != 0 {
+		t.Error("bad count after reset:", n)
+	}
+}
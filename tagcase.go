@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TagCase is an enumeration of the normalizations that engines can apply to
+// the names of tags, which prevents tags like "RequestID" and "request_id",
+// set by different code paths, from being reported as distinct dimensions.
+type TagCase int
+
+const (
+	// TagCaseNone leaves the names of tags unchanged.
+	TagCaseNone TagCase = iota
+
+	// TagCaseLower converts the names of tags to lower case, "RequestID"
+	// becomes "requestid".
+	TagCaseLower
+
+	// TagCaseSnake converts the names of tags to snake case, "RequestID" and
+	// "request-id" become "request_id".
+	TagCaseSnake
+)
+
+// String satisfies the fmt.Stringer interface.
+func (c TagCase) String() string {
+	switch c {
+	case TagCaseNone:
+		return "none"
+	case TagCaseLower:
+		return "lower"
+	case TagCaseSnake:
+		return "snake"
+	default:
+		return "unknown"
+	}
+}
+
+// Normalize returns name converted to the tag case c.
+func (c TagCase) Normalize(name string) string {
+	switch c {
+	case TagCaseLower:
+		return lowerCase(name)
+	case TagCaseSnake:
+		return snakeCase(name)
+	default:
+		return name
+	}
+}
+
+// normalizeTags converts in place the names of tags to the tag case c.
+func (c TagCase) normalizeTags(tags []Tag) {
+	if c == TagCaseNone {
+		return
+	}
+	for i := range tags {
+		tags[i].Name = c.Normalize(tags[i].Name)
+	}
+}
+
+func lowerCase(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf || (c >= 'A' && c <= 'Z') {
+			return strings.ToLower(s)
+		}
+	}
+	return s
+}
+
+func snakeCase(s string) string {
+	if isSnakeCase(s) {
+		return s
+	}
+
+	runes := []rune(s)
+	b := make([]rune, 0, len(runes)+4)
+
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == ' ' || r == '_':
+			if n := len(b); n != 0 && b[n-1] != '_' {
+				b = append(b, '_')
+			}
+
+		case unicode.IsUpper(r):
+			if n := len(b); n != 0 && b[n-1] != '_' {
+				prev := runes[i-1]
+				next := rune(0)
+				if i+1 < len(runes) {
+					next = runes[i+1]
+				}
+				// A new word starts after a lower case letter or a digit, or
+				// at the last upper case letter of an acronym followed by a
+				// lower case letter, like the "S" of "HTTPStatus".
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && unicode.IsLower(next)) {
+					b = append(b, '_')
+				}
+			}
+			b = append(b, unicode.ToLower(r))
+
+		default:
+			b = append(b, r)
+		}
+	}
+
+	return strings.TrimSuffix(string(b), "_")
+}
+
+func isSnakeCase(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= utf8.RuneSelf, c >= 'A' && c <= 'Z', c == '-', c == ' ':
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagCaseNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		lower string
+		snake string
+	}{
+		{name: "request_id", lower: "request_id", snake: "request_id"},
+		{name: "RequestID", lower: "requestid", snake: "request_id"},
+		{name: "requestId", lower: "requestid", snake: "request_id"},
+		{name: "HTTPStatus", lower: "httpstatus", snake: "http_status"},
+		{name: "request-id", lower: "request-id", snake: "request_id"},
+		{name: "Shard2Name", lower: "shard2name", snake: "shard2_name"},
+		{name: "Ünicode", lower: "ünicode", snake: "ünicode"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s := TagCaseNone.Normalize(test.name); s != test.name {
+				t.Error("bad name without normalization:", s)
+			}
+			if s := TagCaseLower.Normalize(test.name); s != test.lower {
+				t.Error("bad lower case name:", s)
+			}
+			if s := TagCaseSnake.Normalize(test.name); s != test.snake {
+				t.Error("bad snake case name:", s)
+			}
+		})
+	}
+}
+
+func TestEngineTagCase(t *testing.T) {
+	h := &handler{}
+	e := NewEngineWith(EngineConfig{
+		Name:      "E",
+		Tags:      []Tag{{"Region", "us"}},
+		TagCase:   TagCaseSnake,
+		TagValues: map[string][]string{"StatusCode": {"200"}},
+	})
+	e.Register(h)
+
+	e.Incr("requests", Tag{"RequestID", "1"}, Tag{"status_code", "404"})
+	e.WithTags(Tag{"requestId", "2"}).Incr("requests")
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Value:     1,
+			Tags:      []Tag{{"region", "us"}, {"request_id", "1"}, {"status_code", OtherTagValue}},
+		},
+		{
+			Type:      CounterType,
+			Namespace: "E",
+			Name:      "requests",
+			Value:     1,
+			Tags:      []Tag{{"region", "us"}, {"request_id", "2"}},
+		},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+}
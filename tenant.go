@@ -0,0 +1,209 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultTenantTag is the default name of the tag set to the tenant ID on the
+// metrics produced by tenant engines.
+const DefaultTenantTag = "tenant"
+
+// The TenantConfig type is used to configure tenant scopes.
+type TenantConfig struct {
+	// Tag is the name of the tag set to the tenant ID on the metrics produced
+	// by tenant engines, defaults to DefaultTenantTag.
+	Tag string
+
+	// Prefix enables prefixing the namespace of tenant engines with the
+	// tenant ID, so the metrics of each tenant have distinct names, for
+	// example "acme.myapp.requests" instead of "myapp.requests".
+	Prefix bool
+
+	// MaxSeries is the maximum number of series (distinct combinations of
+	// metric name and tags) that each tenant can produce, metrics of new
+	// series in excess are discarded and counted by the Dropped method. The
+	// number of series is not limited when set to zero.
+	MaxSeries int
+
+	// MaxTenants is the maximum number of tenants that are scoped separately,
+	// tenants in excess share the scope of the OtherTagValue tenant, which
+	// bounds the cardinality of the tenant tag. The number of tenants is not
+	// limited when set to zero.
+	MaxTenants int
+
+	// Handlers maps tenant IDs to the handlers that the metrics of these
+	// tenants are sent to instead of the handlers of the engine, which
+	// isolates their backends from the other tenants.
+	Handlers map[string][]Handler
+}
+
+// Tenants derives engines scoped to tenants from an engine, isolating the
+// metrics of each tenant from the other tenants.
+//
+// The metrics produced by a tenant engine carry a tag set to the tenant ID
+// and, optionally, a namespace prefixed with it. Each tenant has its own
+// series budget, so a tenant producing runaway cardinality has its new series
+// discarded without affecting the series of the other tenants, and tenants
+// can be configured to report to dedicated handlers.
+type Tenants struct {
+	eng     *Engine
+	config  TenantConfig
+	mutex   sync.Mutex
+	tenants map[string]*tenantScope
+}
+
+type tenantScope struct {
+	dropped  int64 // first for alignment of atomic operations
+	eng      *Engine
+	base     *Engine   // engine of the handlers shared by tenants
+	handlers []Handler // dedicated handlers, nil if the tenant uses base
+	max      int
+	mutex    sync.Mutex
+	series   map[string]struct{}
+}
+
+// NewTenants returns tenant scopes deriving engines from eng, configured with
+// config.
+func NewTenants(eng *Engine, config TenantConfig) *Tenants {
+	if len(config.Tag) == 0 {
+		config.Tag = DefaultTenantTag
+	}
+
+	return &Tenants{
+		eng:     eng,
+		config:  config,
+		tenants: make(map[string]*tenantScope),
+	}
+}
+
+// Engine returns the engine scoped to tenant, the same engine is returned for
+// all calls with the same tenant ID.
+func (t *Tenants) Engine(tenant string) *Engine {
+	return t.scope(tenant).eng
+}
+
+// Dropped returns the number of metrics of tenant which were discarded because
+// they exceeded the series budget of the tenant, zero if no engine was created
+// for tenant.
+func (t *Tenants) Dropped(tenant string) int64 {
+	t.mutex.Lock()
+	s := t.tenants[tenant]
+	t.mutex.Unlock()
+
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (t *Tenants) scope(tenant string) *tenantScope {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s := t.tenants[tenant]; s != nil {
+		return s
+	}
+
+	if max := t.config.MaxTenants; max != 0 && len(t.tenants) >= max {
+		if tenant = OtherTagValue; t.tenants[tenant] != nil {
+			return t.tenants[tenant]
+		}
+	}
+
+	name := t.eng.name
+	if t.config.Prefix {
+		name = MetricSchema{Namespace: tenant, Name: name}.FullName()
+	}
+
+	s := &tenantScope{
+		eng:      t.eng.derive(name, concatTags(t.eng.tags, []Tag{{t.config.Tag, tenant}})),
+		base:     t.eng,
+		handlers: t.config.Handlers[tenant],
+		max:      t.config.MaxSeries,
+		series:   make(map[string]struct{}),
+	}
+	s.eng.handlers = []Handler{s}
+	s.eng.scoped = true
+	t.tenants[tenant] = s
+	return s
+}
+
+// HandleMetric satisfies the Handler interface.
+func (s *tenantScope) HandleMetric(m *Metric) {
+	if s.max != 0 && !s.allow(m) {
+		atomic.AddInt64(&s.dropped, 1)
+		return
+	}
+
+	if s.handlers != nil {
+		for _, h := range s.handlers {
+			h.HandleMetric(m)
+		}
+		return
+	}
+
+	s.base.hmutex.RLock()
+
+	for _, h := range s.base.handlers {
+		h.HandleMetric(m)
+	}
+
+	s.base.hmutex.RUnlock()
+}
+
+// Flush satisfies the Flusher interface, the dedicated handlers of the tenant
+// are flushed, the shared handlers are flushed with the engine they belong to.
+func (s *tenantScope) Flush() {
+	s.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (s *tenantScope) FlushContext(ctx context.Context) {
+	for _, h := range s.handlers {
+		flushHandler(ctx, h)
+	}
+}
+
+// Reset satisfies the Resetter interface, it resets the series budget and the
+// dedicated handlers of the tenant.
+func (s *tenantScope) Reset() {
+	s.mutex.Lock()
+	s.series = make(map[string]struct{})
+	s.mutex.Unlock()
+
+	for _, h := range s.handlers {
+		if r, ok := h.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+// Dropped satisfies the DropCounter interface.
+func (s *tenantScope) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *tenantScope) allow(m *Metric) bool {
+	tags := copyTags(m.Tags)
+	sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+	key := rateKey(m.Namespace, m.Name, tags)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.series[key]; ok {
+		return true
+	}
+
+	if len(s.series) >= s.max {
+		return false
+	}
+
+	s.series[key] = struct{}{}
+	return true
+}
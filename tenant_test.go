@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	shared := &handler{}
+	dedicated := &handler{}
+
+	e := NewEngine("app")
+	e.Register(shared)
+
+	tenants := NewTenants(e, TenantConfig{
+		Prefix:    true,
+		MaxSeries: 2,
+		Handlers:  map[string][]Handler{"big": {dedicated}},
+	})
+
+	acme := tenants.Engine("acme")
+
+	if tenants.Engine("acme") != acme {
+		t.Error("the engine of a tenant changed between calls")
+	}
+
+	acme.Incr("requests", Tag{"path", "/a"})
+	acme.Incr("requests", Tag{"path", "/b"})
+	acme.Incr("requests", Tag{"path", "/c"}) // exceeds the budget
+	acme.Incr("requests", Tag{"path", "/a"})
+	tenants.Engine("other").Incr("requests", Tag{"path", "/c"})
+	tenants.Engine("big").Incr("requests")
+
+	if n := tenants.Dropped("acme"); n != 1 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	names := make([]string, len(shared.metrics))
+	for i, m := range shared.metrics {
+		names[i] = m.Namespace + " " + m.Tags[0].Value + " " + m.Tags[1].Value
+	}
+
+	if !reflect.DeepEqual(names, []string{
+		"acme.app acme /a",
+		"acme.app acme /b",
+		"acme.app acme /a",
+		"other.app other /c",
+	}) {
+		t.Error("bad metrics on the shared handler:", names)
+	}
+
+	if !reflect.DeepEqual(dedicated.metrics, []Metric{
+		{
+			Type:      CounterType,
+			Namespace: "big.app",
+			Name:      "requests",
+			Value:     1,
+			Tags:      []Tag{{"tenant", "big"}},
+		},
+	}) {
+		t.Error("bad metrics on the dedicated handler:", dedicated.metrics)
+	}
+
+	tenants.Engine("big").Flush()
+
+	if dedicated.flushed != 1 || shared.flushed != 0 {
+		t.Error("bad flushes of the tenant engine:", dedicated.flushed, shared.flushed)
+	}
+}
+
+func TestTenantsMaxTenants(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("app")
+	e.Register(h)
+
+	tenants := NewTenants(e, TenantConfig{MaxTenants: 1})
+	tenants.Engine("a").Incr("requests")
+	tenants.Engine("b").Incr("requests")
+	tenants.Engine("c").Incr("requests")
+
+	if tenants.Engine("b") != tenants.Engine("c") {
+		t.Error("tenants in excess do not share the same engine")
+	}
+
+	values := make([]string, len(h.metrics))
+	for i, m := range h.metrics {
+		values[i] = m.Tags[0].Value
+	}
+
+	if !reflect.DeepEqual(values, []string{"a", OtherTagValue, OtherTagValue}) {
+		t.Error("bad tenant tags:", values)
+	}
+}
+
+func TestTenantsDroppedUnknownTenant(t *testing.T) {
+	tenants := NewTenants(NewEngine("E"), TenantConfig{MaxTenants: 1})
+
+	if n := tenants.Dropped("acme"); n != 0 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	if len(tenants.tenants) != 0 {
+		t.Error("the scope of the tenant was created by Dropped:", tenants.tenants)
+	}
+}
+
+func TestTenantsFlush(t *testing.T) {
+	shared := &handler{}
+	dedicated := &handler{}
+
+	e := NewEngineWith(EngineConfig{
+		Name:         "app",
+		Aggregations: map[string]AggregateFunc{"queue.depth": AggregateMax},
+	})
+	e.Register(shared)
+
+	tenants := NewTenants(e, TenantConfig{
+		Handlers: map[string][]Handler{"big": {dedicated}},
+	})
+
+	e.Set("queue.depth", 3)
+	tenants.Engine("big").Flush()
+
+	if len(shared.metrics) != 0 || len(dedicated.metrics) != 0 {
+		t.Error("the tenant engine reported the state of the base engine:", shared.metrics, dedicated.metrics)
+	}
+
+	if dedicated.flushed != 1 || shared.flushed != 0 {
+		t.Error("bad flushes of the tenant engine:", dedicated.flushed, shared.flushed)
+	}
+
+	e.Flush()
+
+	if len(shared.metrics) != 1 || shared.flushed != 1 {
+		t.Error("the base engine did not report its aggregates:", shared.metrics)
+	}
+}
@@ -33,6 +33,25 @@ func (t *Timer) WithTags(tags ...Tag) *Timer {
 	}
 }
 
+// WithoutEngineTags returns a copy of the timer which doesn't inherit the tags
+// of the engine it was created on, only the tags set on the timer are
+// reported.
+func (t *Timer) WithoutEngineTags() *Timer {
+	return &Timer{
+		eng:  t.eng.withoutTags(),
+		name: t.name,
+		tags: t.tags,
+	}
+}
+
+// WithHelp sets the help text of the timer's metric, which handlers like the
+// prometheus handler expose, and returns the timer so the call can be chained
+// with its creation.
+func (t *Timer) WithHelp(help string) *Timer {
+	t.eng.describeHelp(HistogramType, t.name, help)
+	return t
+}
+
 // Start the timer, returning a clock object that should be used to publish the
 // timer metrics.
 func (t *Timer) Start() *Clock {
@@ -55,6 +74,7 @@ func (t *Timer) StartAt(now time.Time) *Clock {
 			name: t.name,
 			tags: tags,
 		},
-		last: now,
+		start: now,
+		last:  now,
 	}
 }
@@ -0,0 +1,36 @@
+package stats
+
+// TimestampSource is an enumeration of the sources that handlers pushing
+// metrics to a backend can derive the timestamps of the data points from.
+//
+// Backends disagree on the timestamps they expect, handlers supporting the
+// configuration of their timestamp source document which sources they accept.
+type TimestampSource int
+
+const (
+	// MetricTimestamp uses the time carried by metrics, or the time at which
+	// the handler received them if it is not set.
+	MetricTimestamp TimestampSource = iota
+
+	// FlushTimestamp uses the time at which the handler sends the metrics to
+	// the backend, which aligns all data points of a flush on the same time.
+	FlushTimestamp
+
+	// NoTimestamp omits timestamps, letting the backend use the time at which
+	// it receives the metrics.
+	NoTimestamp
+)
+
+// String satisfies the fmt.Stringer interface.
+func (s TimestampSource) String() string {
+	switch s {
+	case MetricTimestamp:
+		return "metric"
+	case FlushTimestamp:
+		return "flush"
+	case NoTimestamp:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
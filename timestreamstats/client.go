@@ -0,0 +1,417 @@
+// Package timestreamstats exposes a client which writes metrics to Amazon
+// Timestream tables.
+package timestreamstats
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultTimeout is the default timeout of requests sent to Timestream.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is the default number of times that throttled
+	// requests are retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the default delay before the first retry of a
+	// throttled request, the delay doubles on each retry.
+	DefaultRetryDelay = 100 * time.Millisecond
+
+	// DefaultReservoirSize is the default number of values that histograms
+	// retain to compute percentiles.
+	DefaultReservoirSize = 1028
+
+	// DefaultMaxPendingBatches is the default number of full batches of
+	// records that clients retain until they are flushed.
+	DefaultMaxPendingBatches = 16
+)
+
+// DefaultPercentiles is the list of percentiles that histograms are decomposed
+// into when none are configured.
+var DefaultPercentiles = []float64{0.5, 0.9, 0.99}
+
+// The ClientConfig type is used to configure Timestream clients.
+type ClientConfig struct {
+	// Writer is the AWS client used to write records, it must be set.
+	Writer Writer
+
+	// Database is the name of the Timestream database that records are
+	// written to.
+	Database string
+
+	// Table is the name of the Timestream table that records are written to.
+	Table string
+
+	// BatchSize is the number of records sent in each request, it is capped
+	// to and defaults to MaxRecordsPerRequest.
+	BatchSize int
+
+	// MaxPendingBatches is the number of full batches of records retained by
+	// the client until it is flushed, defaults to DefaultMaxPendingBatches.
+	// Records received when this number is reached are dropped, see Dropped.
+	MaxPendingBatches int
+
+	// FlushInterval enables flushing the client in the background at this
+	// interval, in addition to the flushes of the engine it is registered
+	// on. The background flushes are stopped by closing the client.
+	FlushInterval time.Duration
+
+	// Timeout is the maximum amount of time that each request sent to
+	// Timestream is allowed to take.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times that throttled requests are retried,
+	// a negative value disables retries.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry of a throttled request,
+	// the delay doubles on each retry.
+	RetryDelay time.Duration
+
+	// Percentiles is the list of percentiles (between 0 and 1) that
+	// histograms are decomposed into, defaults to DefaultPercentiles.
+	//
+	// The client aggregates histograms between flushes and writes the count
+	// and sum of the values of each series, and one measure per percentile
+	// named after it (name.p50, name.p99.9...).
+	Percentiles []float64
+
+	// ReservoirSize is the maximum number of values retained by histograms to
+	// compute percentiles, past this size percentiles are estimated from a
+	// uniform sample of the values.
+	ReservoirSize int
+}
+
+// Client represents a Timestream client that receives metrics from a stats
+// engine and writes them to a Timestream table.
+//
+// Counters and gauges are converted to records carrying a single DOUBLE
+// measure named after the metric (namespace included), and tags are converted
+// to dimensions. Tags with empty values are omitted because Timestream rejects
+// empty dimensions.
+//
+// Records are only sent to Timestream when the client is flushed, outside of
+// the lock held by HandleMetric, so a slow or unavailable Timestream never
+// blocks the code producing metrics.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	dropped int64
+	mutex   sync.Mutex
+	config  ClientConfig
+	records []Record
+	pending [][]Record // full batches, sent by the next flush
+	series  map[string]*series
+	rng     *rand.Rand
+	done    chan struct{}
+	once    sync.Once
+}
+
+type series struct {
+	name       string
+	dimensions []Dimension
+	res        *stats.Reservoir
+}
+
+// NewClient creates and returns a new Timestream client writing metrics to the
+// table of database with writer.
+func NewClient(writer Writer, database string, table string) *Client {
+	return NewClientWith(ClientConfig{
+		Writer:   writer,
+		Database: database,
+		Table:    table,
+	})
+}
+
+// NewClientWith creates and returns a new Timestream client configured with
+// config.
+func NewClientWith(config ClientConfig) *Client {
+	if config.BatchSize <= 0 || config.BatchSize > MaxRecordsPerRequest {
+		config.BatchSize = MaxRecordsPerRequest
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	if config.ReservoirSize == 0 {
+		config.ReservoirSize = DefaultReservoirSize
+	}
+
+	if config.MaxPendingBatches <= 0 {
+		config.MaxPendingBatches = DefaultMaxPendingBatches
+	}
+
+	if config.Percentiles == nil {
+		config.Percentiles = DefaultPercentiles
+	}
+
+	percentiles := make([]float64, 0, len(config.Percentiles))
+
+	for _, p := range config.Percentiles {
+		if p < 0 || p > 1 {
+			log.Printf("stats/timestreamstats: ignoring percentile out of the [0, 1] range: %g", p)
+			continue
+		}
+		percentiles = append(percentiles, p)
+	}
+
+	config.Percentiles = percentiles
+
+	c := &Client{
+		config:  config,
+		records: make([]Record, 0, config.BatchSize),
+		series:  make(map[string]*series),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:    make(chan struct{}),
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	}
+
+	return c
+}
+
+// Close satisfies the io.Closer interface, it stops the background flushes and
+// flushes the client.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.Flush()
+	return nil
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// Timestream are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	now := recordTime(time.Now())
+	batches := c.pending
+	c.pending = nil
+
+	if len(c.records) != 0 {
+		batches = append(batches, c.records)
+		c.records = make([]Record, 0, c.config.BatchSize)
+	}
+
+	for key, s := range c.series {
+		batches = c.appendFlushed(batches, s.name+".count", s.dimensions, strconv.FormatInt(s.res.Count(), 10), Bigint, now)
+		batches = c.appendFlushed(batches, s.name+".sum", s.dimensions, formatFloat(s.res.Sum()), Double, now)
+
+		for _, p := range c.config.Percentiles {
+			batches = c.appendFlushed(batches, s.name+"."+stats.PercentileName(p), s.dimensions, formatFloat(s.res.Query(p)), Double, now)
+		}
+
+		delete(c.series, key)
+	}
+
+	c.mutex.Unlock()
+
+	for _, records := range batches {
+		c.flush(ctx, records)
+	}
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	name := m.Name
+	if len(m.Namespace) != 0 {
+		name = m.Namespace + "." + name
+	}
+
+	dimensions := makeDimensions(m.Tags)
+
+	c.mutex.Lock()
+
+	if m.Type == stats.HistogramType || m.Type == stats.SummaryType || m.Type == stats.ExponentialHistogramType {
+		c.observe(name, dimensions, m.Value, int64(m.SampleCount()))
+	} else {
+		t := m.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+
+		value := m.Value
+
+		if m.Type == stats.CounterType {
+			// The increment of a sampled counter stands for the
+			// increments which were discarded.
+			value *= float64(m.SampleCount())
+		}
+
+		c.append(name, dimensions, formatFloat(value), Double, recordTime(t))
+	}
+
+	c.mutex.Unlock()
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// requests to Timestream which failed.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// records discarded because MaxPendingBatches was reached.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *Client) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) observe(name string, dimensions []Dimension, value float64, n int64) {
+	key := seriesKey(name, dimensions)
+	s := c.series[key]
+
+	if s == nil {
+		s = &series{
+			name:       name,
+			dimensions: dimensions,
+			res:        stats.NewReservoir(c.config.ReservoirSize, c.rng),
+		}
+		c.series[key] = s
+	}
+
+	s.res.InsertN(value, n)
+}
+
+// append adds a record to the current batch, which is retained until the next
+// flush once full. The batch is dropped when MaxPendingBatches full batches are
+// already retained.
+func (c *Client) append(name string, dimensions []Dimension, value string, vtype MeasureValueType, t string) {
+	c.records = append(c.records, makeRecord(name, dimensions, value, vtype, t))
+
+	if len(c.records) < c.config.BatchSize {
+		return
+	}
+
+	if len(c.pending) < c.config.MaxPendingBatches {
+		c.pending = append(c.pending, c.records)
+		c.records = make([]Record, 0, c.config.BatchSize)
+	} else {
+		atomic.AddInt64(&c.dropped, int64(len(c.records)))
+		c.records = c.records[:0]
+	}
+}
+
+// appendFlushed adds a record to the last batch of batches, which are sent by
+// the flush in progress.
+func (c *Client) appendFlushed(batches [][]Record, name string, dimensions []Dimension, value string, vtype MeasureValueType, t string) [][]Record {
+	if n := len(batches); n == 0 || len(batches[n-1]) >= c.config.BatchSize {
+		batches = append(batches, make([]Record, 0, c.config.BatchSize))
+	}
+	n := len(batches) - 1
+	batches[n] = append(batches[n], makeRecord(name, dimensions, value, vtype, t))
+	return batches
+}
+
+func makeRecord(name string, dimensions []Dimension, value string, vtype MeasureValueType, t string) Record {
+	return Record{
+		Dimensions:       dimensions,
+		MeasureName:      name,
+		MeasureValue:     value,
+		MeasureValueType: vtype,
+		Time:             t,
+		TimeUnit:         TimeUnitMilliseconds,
+	}
+}
+
+func (c *Client) flush(ctx context.Context, records []Record) {
+	if err := c.write(ctx, records); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		log.Printf("stats/timestreamstats: writing %d records to %s.%s failed: %s", len(records), c.config.Database, c.config.Table, err)
+	}
+}
+
+// write sends records to Timestream, retrying throttled requests with an
+// exponential backoff, until ctx is canceled.
+func (c *Client) write(ctx context.Context, records []Record) error {
+	input := &WriteRecordsInput{
+		DatabaseName: c.config.Database,
+		TableName:    c.config.Table,
+		Records:      records,
+	}
+
+	return retry.Do(ctx, c.config.MaxRetries, c.config.RetryDelay, func() (bool, error) {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+		err := c.config.Writer.WriteRecords(attemptCtx, input)
+		return throttled(err), err
+	})
+}
+
+// throttled returns true if err reports that the request was throttled.
+func throttled(err error) bool {
+	e, ok := err.(interface {
+		ErrorCode() string
+	})
+	return ok && e.ErrorCode() == "ThrottlingException"
+}
+
+func makeDimensions(tags []stats.Tag) []Dimension {
+	dimensions := make([]Dimension, 0, len(tags))
+
+	for _, t := range tags {
+		if len(t.Name) != 0 && len(t.Value) != 0 {
+			dimensions = append(dimensions, Dimension{Name: t.Name, Value: t.Value})
+		}
+	}
+
+	return dimensions
+}
+
+func seriesKey(name string, dimensions []Dimension) string {
+	b := make([]byte, 0, 64)
+	b = append(b, name...)
+
+	for _, d := range dimensions {
+		b = append(b, 0)
+		b = append(b, d.Name...)
+		b = append(b, '=')
+		b = append(b, d.Value...)
+	}
+
+	return string(b)
+}
+
+func recordTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
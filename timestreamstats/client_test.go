@@ -0,0 +1,282 @@
+package timestreamstats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type testWriter struct {
+	mutex    sync.Mutex
+	inputs   []*WriteRecordsInput
+	failures []error // errors returned by the next calls
+}
+
+func (w *testWriter) WriteRecords(ctx context.Context, input *WriteRecordsInput) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.failures) != 0 {
+		err := w.failures[0]
+		w.failures = w.failures[1:]
+		return err
+	}
+
+	w.inputs = append(w.inputs, input)
+	return nil
+}
+
+type apiError string
+
+func (e apiError) Error() string     { return string(e) }
+func (e apiError) ErrorCode() string { return string(e) }
+
+func TestClient(t *testing.T) {
+	w := &testWriter{}
+	c := NewClientWith(ClientConfig{
+		Writer:      w,
+		Database:    "db",
+		Table:       "metrics",
+		Percentiles: []float64{0.5},
+	})
+
+	c.HandleMetric(&stats.Metric{
+		Type:      stats.CounterType,
+		Namespace: "test",
+		Name:      "calls",
+		Value:     1,
+		Tags:      []stats.Tag{{"op", "read"}, {"empty", ""}},
+		Time:      time.Unix(1500000000, 0),
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(c)
+	e.Observe("size", 1)
+	e.Observe("size", 2)
+	e.Observe("size", 3)
+	e.Flush()
+
+	if len(w.inputs) != 1 {
+		t.Fatal("bad number of requests:", len(w.inputs))
+	}
+
+	input := w.inputs[0]
+
+	if input.DatabaseName != "db" || input.TableName != "metrics" {
+		t.Error("bad destination:", input.DatabaseName, input.TableName)
+	}
+
+	if r := input.Records[0]; !reflect.DeepEqual(r, Record{
+		Dimensions:       []Dimension{{"op", "read"}},
+		MeasureName:      "test.calls",
+		MeasureValue:     "1",
+		MeasureValueType: Double,
+		Time:             "1500000000000",
+		TimeUnit:         TimeUnitMilliseconds,
+	}) {
+		t.Errorf("bad counter record: %+v", r)
+	}
+
+	measures := map[string]string{}
+	types := map[string]MeasureValueType{}
+
+	for _, r := range input.Records[1:] {
+		measures[r.MeasureName] = r.MeasureValue
+		types[r.MeasureName] = r.MeasureValueType
+	}
+
+	if !reflect.DeepEqual(measures, map[string]string{
+		"test.size.count": "3",
+		"test.size.sum":   "6",
+		"test.size.p50":   "2",
+	}) {
+		t.Error("bad histogram records:", measures)
+	}
+
+	if types["test.size.count"] != Bigint || types["test.size.sum"] != Double {
+		t.Error("bad histogram measure types:", types)
+	}
+}
+
+func TestClientSampleCount(t *testing.T) {
+	w := &testWriter{}
+	c := NewClientWith(ClientConfig{
+		Writer:      w,
+		Database:    "db",
+		Table:       "metrics",
+		Percentiles: []float64{0.5},
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1, Rate: 0.5})
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 2, Rate: 0.25})
+	c.HandleMetric(&stats.Metric{Type: stats.HistogramType, Name: "size", Value: 6})
+	c.Flush()
+
+	if len(w.inputs) != 1 {
+		t.Fatal("bad number of requests:", len(w.inputs))
+	}
+
+	measures := map[string]string{}
+
+	for _, r := range w.inputs[0].Records {
+		measures[r.MeasureName] = r.MeasureValue
+	}
+
+	if !reflect.DeepEqual(measures, map[string]string{
+		"calls":      "2",
+		"size.count": "5",
+		"size.sum":   "14",
+		"size.p50":   "2",
+	}) {
+		t.Error("sampled metrics were not weighted by their sample count:", measures)
+	}
+}
+
+func TestClientBatchSize(t *testing.T) {
+	w := &testWriter{}
+	c := NewClientWith(ClientConfig{
+		Writer:    w,
+		BatchSize: 1000, // capped to MaxRecordsPerRequest
+	})
+
+	for i := 0; i != 250; i++ {
+		c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+	}
+
+	if len(w.inputs) != 0 {
+		t.Error("records were sent before flushing:", len(w.inputs))
+	}
+
+	c.Flush()
+
+	for i, n := range []int{100, 100, 50} {
+		if len(w.inputs[i].Records) != n {
+			t.Errorf("bad number of records in request %d: %d", i, len(w.inputs[i].Records))
+		}
+	}
+}
+
+func TestClientMaxPendingBatches(t *testing.T) {
+	w := &testWriter{}
+	c := NewClientWith(ClientConfig{
+		Writer:            w,
+		BatchSize:         10,
+		MaxPendingBatches: 2,
+	})
+
+	for i := 0; i != 45; i++ {
+		c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+	}
+
+	if n := c.Dropped(); n != 20 {
+		t.Error("bad number of dropped records:", n)
+	}
+
+	c.Flush()
+
+	if len(w.inputs) != 3 || len(w.inputs[2].Records) != 5 {
+		t.Error("bad requests sent by the flush:", len(w.inputs))
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error
+		requests int
+		errors   int64
+	}{
+		{
+			name:     "throttled",
+			failures: []error{apiError("ThrottlingException"), apiError("ThrottlingException")},
+			requests: 1,
+		},
+		{
+			name:     "retries exhausted",
+			failures: []error{apiError("ThrottlingException"), apiError("ThrottlingException"), apiError("ThrottlingException")},
+			errors:   1,
+		},
+		{
+			name:     "not retried",
+			failures: []error{errors.New("validation failed")},
+			errors:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &testWriter{failures: test.failures}
+			c := NewClientWith(ClientConfig{
+				Writer:     w,
+				MaxRetries: 2,
+				RetryDelay: time.Millisecond,
+			})
+
+			c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+			c.Flush()
+
+			if len(w.inputs) != test.requests {
+				t.Error("bad number of successful requests:", len(w.inputs))
+			}
+
+			if n := c.Errors(); n != test.errors {
+				t.Error("bad number of errors:", n)
+			}
+		})
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	w := &testWriter{failures: []error{apiError("ThrottlingException"), apiError("ThrottlingException")}}
+	c := NewClientWith(ClientConfig{
+		Writer:     w,
+		MaxRetries: 1,
+		RetryDelay: time.Hour,
+	})
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if n := c.Errors(); n != 1 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientFlushInterval(t *testing.T) {
+	w := &testWriter{}
+	c := NewClientWith(ClientConfig{
+		Writer:        w,
+		FlushInterval: time.Millisecond,
+	})
+	defer c.Close()
+
+	c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Name: "conns", Value: 1})
+
+	for i := 0; i != 1000; i++ {
+		w.mutex.Lock()
+		n := len(w.inputs)
+		w.mutex.Unlock()
+
+		if n != 0 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Error("the client was not flushed in the background")
+}
@@ -0,0 +1,59 @@
+package timestreamstats
+
+import "context"
+
+// MaxRecordsPerRequest is the maximum number of records that Timestream
+// accepts in a single WriteRecords request.
+const MaxRecordsPerRequest = 100
+
+// Writer is the interface of the AWS clients used to write records to
+// Timestream.
+//
+// The package doesn't depend on the AWS SDK, programs provide an adapter which
+// converts the input to a timestreamwrite.WriteRecordsInput and calls the
+// WriteRecords method of the SDK client. Errors which have an ErrorCode method
+// returning "ThrottlingException", like the API errors of the SDK, are
+// retried by the client.
+type Writer interface {
+	WriteRecords(ctx context.Context, input *WriteRecordsInput) error
+}
+
+// WriteRecordsInput mirrors the input of the Timestream WriteRecords API.
+type WriteRecordsInput struct {
+	DatabaseName string
+	TableName    string
+	Records      []Record
+}
+
+// Record mirrors a Timestream record carrying a single measure.
+type Record struct {
+	Dimensions       []Dimension
+	MeasureName      string
+	MeasureValue     string
+	MeasureValueType MeasureValueType
+	Time             string // milliseconds since the unix epoch
+	TimeUnit         string
+}
+
+// Dimension mirrors a Timestream dimension, the tags of metrics are converted
+// to dimensions.
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// MeasureValueType is the type of the value of a Timestream measure.
+type MeasureValueType string
+
+const (
+	// Double is the type of the values of counters, gauges and most of the
+	// measures that histograms are decomposed into.
+	Double MeasureValueType = "DOUBLE"
+
+	// Bigint is the type of the count of histograms.
+	Bigint MeasureValueType = "BIGINT"
+)
+
+// TimeUnitMilliseconds is the unit of the time of the records written by
+// clients.
+const TimeUnitMilliseconds = "MILLISECONDS"
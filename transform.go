@@ -0,0 +1,163 @@
+package stats
+
+import "context"
+
+// Transform is the type of functions transforming the values of metrics before
+// they are emitted, see NewTransformHandler.
+//
+// Transforms must be pure functions of the value: the same value must always
+// be transformed to the same result, regardless of the time or of the values
+// transformed before.
+type Transform func(value float64) float64
+
+// Pipeline returns a transform applying transforms in order, the result of
+// each transform being the value passed to the next one.
+func Pipeline(transforms ...Transform) Transform {
+	transforms = append([]Transform(nil), transforms...)
+	return func(value float64) float64 {
+		for _, t := range transforms {
+			value = t(value)
+		}
+		return value
+	}
+}
+
+// Scale returns a transform multiplying values by factor, for example
+// Scale(1.0/(1<<20)) converts bytes to mebibytes.
+func Scale(factor float64) Transform {
+	return func(value float64) float64 { return value * factor }
+}
+
+// Offset returns a transform adding delta to values.
+func Offset(delta float64) Transform {
+	return func(value float64) float64 { return value + delta }
+}
+
+// MetricTransform configures the transformation of the values of a metric by a
+// handler returned by NewTransformHandler.
+type MetricTransform struct {
+	// Name is the name of the metric that the transform applies to.
+	Name string
+
+	// Transform is the function applied to the values of the metric.
+	Transform Transform
+
+	// Unit is the unit of the transformed values, the unit of the metric is
+	// left unchanged when it is empty.
+	Unit string
+}
+
+type transformHandler struct {
+	handler    Handler
+	transforms map[string]metricTransform
+}
+
+type metricTransform struct {
+	transform Transform
+	unit      string
+}
+
+// NewTransformHandler returns a handler which passes the metrics it receives to
+// handler, with the values of the listed metrics transformed.
+//
+// This separates the values produced by the program from their representation
+// in a backend, for example to report sizes in megabytes to a backend while
+// other handlers of the engine keep receiving bytes: the metrics received by
+// the handler are left unchanged, handler receives transformed copies.
+//
+// Transforms listed for the same metric are composed in order, like with
+// Pipeline. Transforms apply to each value before it is aggregated by handler,
+// the values of counters are increments and the values of histograms are
+// observations, so only linear transforms like Scale keep sums and rates
+// consistent with the original values.
+func NewTransformHandler(handler Handler, transforms ...MetricTransform) Handler {
+	h := &transformHandler{
+		handler:    handler,
+		transforms: make(map[string]metricTransform, len(transforms)),
+	}
+
+	for _, t := range transforms {
+		if t.Transform == nil {
+			continue
+		}
+
+		if prev, ok := h.transforms[t.Name]; ok {
+			t.Transform = Pipeline(prev.transform, t.Transform)
+
+			if len(t.Unit) == 0 {
+				t.Unit = prev.unit
+			}
+		}
+
+		h.transforms[t.Name] = metricTransform{
+			transform: t.Transform,
+			unit:      t.Unit,
+		}
+	}
+
+	return h
+}
+
+// HandleMetric satisfies the Handler interface.
+func (h *transformHandler) HandleMetric(m *Metric) {
+	h.handle(m, h.handler)
+}
+
+// HandleMetrics satisfies the BatchHandler interface, the metrics are passed to
+// the wrapped handler in a single call if it implements the interface.
+func (h *transformHandler) HandleMetrics(metrics []*Metric) {
+	forwardMetrics(h.handler, metrics, h.handle)
+}
+
+// DescribeMetric satisfies the Describer interface, the schemas of transformed
+// metrics are passed to the wrapped handler with the unit of the transform.
+func (h *transformHandler) DescribeMetric(schema MetricSchema) {
+	if t, ok := h.transforms[schema.Name]; ok && len(t.unit) != 0 {
+		schema.Unit = t.unit
+	}
+	describeMetric(h.handler, schema)
+}
+
+// handle passes the metrics produced from m to next.
+func (h *transformHandler) handle(m *Metric, next Handler) {
+	t, ok := h.transforms[m.Name]
+	if !ok {
+		next.HandleMetric(m)
+		return
+	}
+
+	c := metricPool.Get().(*Metric)
+	tags := c.Tags[:0]
+	*c = *m
+	c.Tags = append(tags, m.Tags...)
+	c.Value = t.transform(m.Value)
+
+	if len(t.unit) != 0 {
+		c.Unit = t.unit
+	}
+
+	next.HandleMetric(c)
+
+	c.Namespace = ""
+	c.Name = ""
+	c.Tags = c.Tags[:0]
+	metricPool.Put(c)
+}
+
+// Flush satisfies the Flusher interface.
+func (h *transformHandler) Flush() {
+	h.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the ContextFlusher interface, ctx is passed to the
+// wrapped handlers which implement the interface.
+func (h *transformHandler) FlushContext(ctx context.Context) {
+	flushHandler(ctx, h.handler)
+}
+
+// Reset satisfies the Resetter interface.
+func (h *transformHandler) Reset() {
+	if r, ok := h.handler.(Resetter); ok {
+		r.Reset()
+	}
+}
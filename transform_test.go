@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTransformHandler(t *testing.T) {
+	h1 := &handler{}
+	h2 := &handler{}
+
+	e := NewEngine("E")
+	e.Register(h1)
+	e.Register(NewTransformHandler(h2,
+		MetricTransform{Name: "size", Transform: Scale(1.0 / 1024), Unit: "kilobytes"},
+		MetricTransform{Name: "size", Transform: Offset(1)},
+		MetricTransform{Name: "temp", Transform: Pipeline(Offset(-32), Scale(5.0/9))},
+	))
+
+	e.handle(HistogramType, "size", 2048, "bytes", []Tag{{"A", "1"}}, time.Time{}, time.Time{}, nil)
+	e.Set("temp", 212)
+	e.Incr("calls")
+
+	if !reflect.DeepEqual(h2.metrics, []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 3, Unit: "kilobytes", Tags: []Tag{{"A", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "temp", Value: 100},
+		{Type: CounterType, Namespace: "E", Name: "calls", Value: 1},
+	}) {
+		t.Error("bad transformed metrics:", h2.metrics)
+	}
+
+	// Other handlers keep receiving the original values.
+	if !reflect.DeepEqual(h1.metrics, []Metric{
+		{Type: HistogramType, Namespace: "E", Name: "size", Value: 2048, Unit: "bytes", Tags: []Tag{{"A", "1"}}},
+		{Type: GaugeType, Namespace: "E", Name: "temp", Value: 212},
+		{Type: CounterType, Namespace: "E", Name: "calls", Value: 1},
+	}) {
+		t.Error("bad original metrics:", h1.metrics)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		transform Transform
+		value     float64
+		result    float64
+	}{
+		{transform: Pipeline(), value: 42, result: 42},
+		{transform: Pipeline(Scale(2)), value: 21, result: 42},
+		{transform: Pipeline(Scale(2), Offset(1)), value: 1, result: 3},
+		{transform: Pipeline(Offset(1), Scale(2)), value: 1, result: 4},
+	}
+
+	for _, test := range tests {
+		if result := test.transform(test.value); result != test.result {
+			t.Errorf("bad result of transforming %g: %g != %g", test.value, test.result, result)
+		}
+	}
+}
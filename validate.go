@@ -0,0 +1,277 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ValidationIssueKind is an enumeration of the kinds of issues detected by
+// validators.
+type ValidationIssueKind int
+
+const (
+	// InvalidName is reported for metrics with an empty name, or a name made
+	// of characters other than letters, digits, and "_.:-", or with empty
+	// dot-separated components.
+	InvalidName ValidationIssueKind = iota
+
+	// NameTooLong is reported for metrics with names longer than the maximum
+	// length configured on the validator.
+	NameTooLong
+
+	// InvalidTag is reported for tags with an empty name or value, or with a
+	// name that is not a valid metric name.
+	InvalidTag
+
+	// TagTooLong is reported for tags with a name or value longer than the
+	// maximum length configured on the validator.
+	TagTooLong
+
+	// TooManyTags is reported for metrics carrying more tags than the maximum
+	// configured on the validator.
+	TooManyTags
+
+	// TypeConflict is reported for metrics produced with different types.
+	TypeConflict
+
+	// CardinalityExceeded is reported for metrics with more series (distinct
+	// sets of tags) than the maximum configured on the validator.
+	CardinalityExceeded
+
+	// NonFiniteValue is reported for metrics produced with NaN or infinite
+	// values, which reach handlers when the engine is configured with the
+	// NonFinitePass policy.
+	NonFiniteValue
+)
+
+// String satisfies the fmt.Stringer interface.
+func (k ValidationIssueKind) String() string {
+	switch k {
+	case InvalidName:
+		return "invalid name"
+	case NameTooLong:
+		return "name too long"
+	case InvalidTag:
+		return "invalid tag"
+	case TagTooLong:
+		return "tag too long"
+	case TooManyTags:
+		return "too many tags"
+	case TypeConflict:
+		return "type conflict"
+	case CardinalityExceeded:
+		return "cardinality exceeded"
+	case NonFiniteValue:
+		return "non-finite value"
+	default:
+		return "unknown"
+	}
+}
+
+// ValidationIssue describes an issue detected by a validator.
+type ValidationIssue struct {
+	// Kind is the kind of the issue.
+	Kind ValidationIssueKind
+
+	// Namespace and Name identify the metric that the issue was detected on.
+	Namespace string
+	Name      string
+
+	// Tag is the name of the tag involved in the issue, if any.
+	Tag string
+
+	// Message is a human-readable description of the issue.
+	Message string
+}
+
+// String satisfies the fmt.Stringer interface.
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s", MetricSchema{Namespace: i.Namespace, Name: i.Name}.FullName(), i.Message)
+}
+
+// The ValidatorConfig type is used to configure validators, the limits are not
+// checked when set to zero.
+type ValidatorConfig struct {
+	// MaxNameLength is the maximum length of metric names, namespace
+	// included.
+	MaxNameLength int
+
+	// MaxTagLength is the maximum length of the names and values of tags.
+	MaxTagLength int
+
+	// MaxTags is the maximum number of tags of a metric, engine tags included.
+	MaxTags int
+
+	// MaxSeries is the maximum number of series of a metric.
+	MaxSeries int
+}
+
+// Validator is a metric handler which checks the metrics it receives instead of
+// sending them anywhere, and collects a report of the issues it detects.
+//
+// An engine with a validator as its only handler runs in dry-run mode, which
+// is useful to catch instrumentation bugs in tests, for example:
+//
+//	v := stats.NewValidator(stats.ValidatorConfig{MaxSeries: 100})
+//	eng := stats.NewEngine("test")
+//	eng.Register(v)
+//
+//	// ...exercise the instrumented code on eng...
+//
+//	for _, issue := range v.Report() {
+//		t.Error(issue)
+//	}
+//
+// Each issue is reported once, regardless of the number of metrics it was
+// detected on.
+type Validator struct {
+	config ValidatorConfig
+	mutex  sync.Mutex
+	types  map[string]MetricType
+	series map[string]map[string]struct{}
+	issues map[ValidationIssue]struct{}
+}
+
+// NewValidator creates and returns a new validator configured with config.
+func NewValidator(config ValidatorConfig) *Validator {
+	v := &Validator{config: config}
+	v.Reset()
+	return v
+}
+
+// HandleMetric satisfies the Handler interface.
+func (v *Validator) HandleMetric(m *Metric) {
+	name := MetricSchema{Namespace: m.Namespace, Name: m.Name}.FullName()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !validName(m.Name) {
+		v.report(m, InvalidName, "", fmt.Sprintf("invalid metric name %q", m.Name))
+	}
+
+	if max := v.config.MaxNameLength; max != 0 && len(name) > max {
+		v.report(m, NameTooLong, "", fmt.Sprintf("name of %d bytes exceeds the limit of %d", len(name), max))
+	}
+
+	if max := v.config.MaxTags; max != 0 && len(m.Tags) > max {
+		v.report(m, TooManyTags, "", fmt.Sprintf("%d tags exceed the limit of %d", len(m.Tags), max))
+	}
+
+	for _, t := range m.Tags {
+		switch {
+		case !validName(t.Name):
+			v.report(m, InvalidTag, t.Name, fmt.Sprintf("invalid tag name %q", t.Name))
+		case len(t.Value) == 0:
+			v.report(m, InvalidTag, t.Name, fmt.Sprintf("empty value of tag %s", t.Name))
+		}
+
+		if max := v.config.MaxTagLength; max != 0 && (len(t.Name) > max || len(t.Value) > max) {
+			v.report(m, TagTooLong, t.Name, fmt.Sprintf("tag %s exceeds the length limit of %d", t.Name, max))
+		}
+	}
+
+	if typ, ok := v.types[name]; !ok {
+		v.types[name] = m.Type
+	} else if typ != m.Type {
+		v.report(m, TypeConflict, "", fmt.Sprintf("produced as %s and %s", typ, m.Type))
+	}
+
+	if max := v.config.MaxSeries; max != 0 {
+		series := v.series[name]
+		if series == nil {
+			series = make(map[string]struct{})
+			v.series[name] = series
+		}
+
+		tags := copyTags(m.Tags)
+		sort.Slice(tags, func(i int, j int) bool { return tags[i].Name < tags[j].Name })
+
+		if key := rateKey(m.Namespace, m.Name, tags); len(series) < max {
+			series[key] = struct{}{}
+		} else if _, ok := series[key]; !ok {
+			v.report(m, CardinalityExceeded, "", fmt.Sprintf("more than %d series", max))
+		}
+	}
+
+	if !isFinite(m.Value) {
+		v.report(m, NonFiniteValue, "", fmt.Sprintf("non-finite value %g", m.Value))
+	}
+}
+
+// Reset satisfies the Resetter interface, it discards the issues and the state
+// of the metrics seen by the validator.
+func (v *Validator) Reset() {
+	v.mutex.Lock()
+	v.types = make(map[string]MetricType)
+	v.series = make(map[string]map[string]struct{})
+	v.issues = make(map[ValidationIssue]struct{})
+	v.mutex.Unlock()
+}
+
+// Report returns the list of issues detected by the validator, sorted by metric
+// name and kind of issue.
+func (v *Validator) Report() []ValidationIssue {
+	v.mutex.Lock()
+	issues := make([]ValidationIssue, 0, len(v.issues))
+
+	for issue := range v.issues {
+		issues = append(issues, issue)
+	}
+
+	v.mutex.Unlock()
+
+	sort.Slice(issues, func(i int, j int) bool {
+		a, b := issues[i], issues[j]
+		switch {
+		case a.Namespace != b.Namespace:
+			return a.Namespace < b.Namespace
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.Tag != b.Tag:
+			return a.Tag < b.Tag
+		default:
+			return a.Message < b.Message
+		}
+	})
+
+	return issues
+}
+
+// report records an issue, the method must be called with the mutex held.
+func (v *Validator) report(m *Metric, kind ValidationIssueKind, tag string, msg string) {
+	v.issues[ValidationIssue{
+		Kind:      kind,
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		Tag:       tag,
+		Message:   msg,
+	}] = struct{}{}
+}
+
+func validName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for _, part := range strings.Split(name, ".") {
+		if len(part) == 0 {
+			return false
+		}
+
+		for i := 0; i != len(part); i++ {
+			switch c := part[i]; {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '_', c == ':', c == '-':
+			default:
+				return false
+			}
+		}
+	}
+
+	return true
+}
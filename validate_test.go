@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	v := NewValidator(ValidatorConfig{
+		MaxNameLength: 16,
+		MaxTagLength:  8,
+		MaxTags:       2,
+		MaxSeries:     2,
+	})
+
+	e := NewEngineWith(EngineConfig{Name: "E", NonFinite: NonFinitePass})
+	e.Register(v)
+
+	e.Incr("requests", Tag{"status", "200"})
+	e.Incr("requests", Tag{"status", "404"})
+	e.Incr("requests", Tag{"status", "200"})
+	e.Incr("requests", Tag{"status", "500"})
+	e.Set("requests", 1)
+	e.Set("bad name", 1)
+	e.Set("very.long.metric.name", 1)
+	e.Set("conns", 1, Tag{"a", "1"}, Tag{"b", "2"}, Tag{"", "3"})
+	e.Set("temperature", math.NaN(), Tag{"location", "basement.1"})
+	e.Observe("latency", 1, Tag{"path", ""})
+
+	if report := v.Report(); !reflect.DeepEqual(report, []ValidationIssue{
+		{Kind: InvalidName, Namespace: "E", Name: "bad name", Message: `invalid metric name "bad name"`},
+		{Kind: InvalidTag, Namespace: "E", Name: "conns", Message: `invalid tag name ""`},
+		{Kind: TooManyTags, Namespace: "E", Name: "conns", Message: "3 tags exceed the limit of 2"},
+		{Kind: InvalidTag, Namespace: "E", Name: "latency", Tag: "path", Message: "empty value of tag path"},
+		{Kind: TypeConflict, Namespace: "E", Name: "requests", Message: "produced as counter and gauge"},
+		{Kind: CardinalityExceeded, Namespace: "E", Name: "requests", Message: "more than 2 series"},
+		{Kind: TagTooLong, Namespace: "E", Name: "temperature", Tag: "location", Message: "tag location exceeds the length limit of 8"},
+		{Kind: NonFiniteValue, Namespace: "E", Name: "temperature", Message: "non-finite value NaN"},
+		{Kind: NameTooLong, Namespace: "E", Name: "very.long.metric.name", Message: "name of 23 bytes exceeds the limit of 16"},
+	}) {
+		t.Error("bad report:")
+		for _, issue := range report {
+			t.Logf("%#v", issue)
+		}
+	}
+
+	v.Reset()
+
+	if report := v.Report(); len(report) != 0 {
+		t.Error("the report was not reset:", report)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"requests", true},
+		{"http.requests_total", true},
+		{"rpc:calls-count", true},
+		{"", false},
+		{".requests", false},
+		{"http..requests", false},
+		{"requests/s", false},
+	}
+
+	for _, test := range tests {
+		if valid := validName(test.name); valid != test.valid {
+			t.Errorf("%q: valid=%t", test.name, valid)
+		}
+	}
+}
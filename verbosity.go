@@ -0,0 +1,65 @@
+package stats
+
+import "sync/atomic"
+
+// Level represents the verbosity level of metrics, similarly to log levels.
+//
+// Metrics produced by engines created with NewEngine have level zero and are
+// always reported. Engines returned by WithLevel produce metrics of a higher
+// level which are only reported when the verbosity of the engine is raised,
+// this way detailed metrics can be enabled at runtime while investigating an
+// issue without changing the instrumentation.
+type Level int32
+
+// WithLevel creates a new engine which inherits the properties and handlers
+// of eng and produces metrics with the given verbosity level.
+//
+// The metrics produced by the returned engine are discarded unless the
+// verbosity of the engine is greater or equal to level. Verbosity is shared
+// between eng and all engines derived from it.
+func (eng *Engine) WithLevel(level Level) *Engine {
+	e := eng.derive(eng.name, eng.tags)
+	e.level = level
+	return e
+}
+
+// Level returns the verbosity level of the metrics produced by eng.
+func (eng *Engine) Level() Level {
+	return eng.level
+}
+
+// SetVerbosity sets the verbosity of eng and of the engines derived from it,
+// the method is safe to call concurrently while metrics are produced. The
+// verbosity of zero-value engines is always zero, the call has no effect on
+// them.
+func (eng *Engine) SetVerbosity(verbosity Level) {
+	if eng.verbose != nil {
+		atomic.StoreInt32(eng.verbose, int32(verbosity))
+	}
+}
+
+// Verbosity returns the current verbosity of eng.
+func (eng *Engine) Verbosity() Level {
+	if eng.verbose == nil {
+		return 0
+	}
+	return Level(atomic.LoadInt32(eng.verbose))
+}
+
+// enabled returns true if the metrics produced by eng should be reported, it
+// costs a single atomic load for engines producing metrics with a level above
+// zero.
+func (eng *Engine) enabled() bool {
+	return eng.level <= 0 || eng.level <= eng.Verbosity()
+}
+
+// WithLevel returns an engine derived from the default engine which produces
+// metrics with the given verbosity level.
+func WithLevel(level Level) *Engine {
+	return DefaultEngine.WithLevel(level)
+}
+
+// SetVerbosity sets the verbosity of the default engine.
+func SetVerbosity(verbosity Level) {
+	DefaultEngine.SetVerbosity(verbosity)
+}
@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEngineVerbosity(t *testing.T) {
+	h := &handler{}
+	e := NewEngine("E")
+	e.Register(h)
+
+	v1 := e.WithLevel(1)
+	v2 := v1.WithName("F").WithLevel(2)
+
+	e.Incr("A")
+	v1.Incr("B")
+	v2.Incr("C")
+
+	e.SetVerbosity(1)
+	v1.Incr("D")
+	v2.IncrAndObserve("E", "F", 1)
+
+	v2.SetVerbosity(2)
+	b := v2.Batch()
+	b.Incr("G")
+	b.Commit()
+
+	if !reflect.DeepEqual(h.metrics, []Metric{
+		{Type: CounterType, Namespace: "E", Name: "A", Value: 1},
+		{Type: CounterType, Namespace: "E", Name: "D", Value: 1},
+		{Type: CounterType, Namespace: "F", Name: "G", Value: 1},
+	}) {
+		t.Error("bad metrics:", h.metrics)
+	}
+
+	if v := e.Verbosity(); v != 2 {
+		t.Error("bad verbosity:", v)
+	}
+
+	if l := v2.Level(); l != 2 {
+		t.Error("bad level:", l)
+	}
+}
+
+func BenchmarkEngineDisabledLevel(b *testing.B) {
+	e := NewEngine("E").WithLevel(1)
+	e.Register(HandlerFunc(func(*Metric) {}))
+
+	for i := 0; i != b.N; i++ {
+		e.Incr("A")
+	}
+}
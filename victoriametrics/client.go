@@ -0,0 +1,261 @@
+// Package victoriametrics exposes a client which pushes metrics to the import
+// endpoints of VictoriaMetrics.
+package victoriametrics
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+	"github.com/segmentio/stats/prometheus"
+)
+
+const (
+	// DefaultAddress is the default address of the VictoriaMetrics server that
+	// clients send metrics to.
+	DefaultAddress = "http://localhost:8428"
+
+	// ImportPath is the path of the VictoriaMetrics endpoint which imports
+	// metrics in the prometheus text exposition format.
+	ImportPath = "/api/v1/import/prometheus"
+
+	// DefaultBufferSize is the default size of the client buffer, a request is
+	// sent to the server when the buffer reaches this size.
+	DefaultBufferSize = 64 * 1024
+
+	// DefaultTimeout is the default timeout of requests sent to the server.
+	DefaultTimeout = 5 * time.Second
+)
+
+// The ClientConfig type is used to configure VictoriaMetrics clients.
+type ClientConfig struct {
+	// Address of the VictoriaMetrics server to send metrics to.
+	Address string
+
+	// BufferSize is the size of the output buffer used by the client, the
+	// metrics are split into requests of about this size.
+	BufferSize int
+
+	// Timeout is the maximum amount of time that requests sent to the server
+	// are allowed to take.
+	Timeout time.Duration
+
+	// Transport is the HTTP transport used by the client to send requests,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Gzip enables compressing the bodies of requests sent to the server with
+	// gzip, it is equivalent to setting Compressor to stats.GzipCompressor.
+	Gzip bool
+
+	// Compressor is the compression codec applied to the bodies of requests
+	// sent to the server, requests are not compressed when it is nil and Gzip
+	// is not set.
+	Compressor stats.Compressor
+
+	// Buckets maps metric names to the upper limits of the buckets of their
+	// histograms, see prometheus.Handler.
+	Buckets map[string][]float64
+
+	// Rounding maps metric names to the rounding applied to the values of
+	// their counters and gauges, see prometheus.Handler.
+	Rounding map[string]prometheus.Rounding
+
+	// OnError is called with the errors returned by requests sent to the
+	// server, the errors are logged by default.
+	OnError func(error)
+}
+
+// Client represents a VictoriaMetrics client which receives metrics from a
+// stats engine and pushes them to a VictoriaMetrics server every time it is
+// flushed.
+//
+// Metrics are aggregated the same way a prometheus.Handler does, and pushed in
+// the prometheus text exposition format: counters and histograms carry the
+// cumulative values since the client was created, so functions like rate()
+// work on the imported series.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	handler *prometheus.Handler
+	config  ClientConfig
+	url     string
+	httpc   http.Client
+	mutex   sync.Mutex
+	buffer  []byte
+	zbuffer bytes.Buffer
+	zpool   *stats.CompressorPool
+}
+
+// NewClient creates and returns a new VictoriaMetrics client publishing
+// metrics to the server at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new VictoriaMetrics client configured
+// with config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.Gzip && config.Compressor == nil {
+		config.Compressor = stats.GzipCompressor
+	}
+
+	if config.OnError == nil {
+		addr := config.Address
+		config.OnError = func(err error) {
+			log.Printf("stats/victoriametrics: sending metrics to %s failed: %s", addr, err)
+		}
+	}
+
+	c := &Client{
+		handler: &prometheus.Handler{
+			Buckets:  config.Buckets,
+			Rounding: config.Rounding,
+		},
+		config: config,
+		url:    config.Address + ImportPath,
+		httpc: http.Client{
+			Transport: config.Transport,
+			Timeout:   config.Timeout,
+		},
+		buffer: make([]byte, 0, config.BufferSize),
+	}
+
+	if config.Compressor != nil {
+		c.zpool = stats.NewCompressorPool(config.Compressor)
+	}
+
+	return c
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	c.handler.HandleMetric(m)
+}
+
+// HandleMetrics satisfies the stats.BatchHandler interface.
+func (c *Client) HandleMetrics(metrics []*stats.Metric) {
+	c.handler.HandleMetrics(metrics)
+}
+
+// DescribeMetric satisfies the stats.Describer interface.
+func (c *Client) DescribeMetric(schema stats.MetricSchema) {
+	c.handler.DescribeMetric(schema)
+}
+
+// Reset satisfies the stats.Resetter interface.
+func (c *Client) Reset() {
+	c.handler.Reset()
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// requests to the server which failed.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Close satisfies the io.Closer interface.
+func (c *Client) Close() error {
+	c.Flush()
+	return nil
+}
+
+// Flush satisfies the stats.Flusher interface, it pushes the current state of
+// all metrics to the server.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// the server are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	c.handler.WriteTo(bufferWriter{c, ctx})
+	c.flush(ctx)
+	c.mutex.Unlock()
+}
+
+// bufferWriter appends the exposition written by the prometheus handler to
+// the buffer of the client, sending it when it reaches the buffer size. The
+// handler writes chunks of complete lines, so requests never split a line.
+type bufferWriter struct {
+	*Client
+	ctx context.Context
+}
+
+func (w bufferWriter) Write(b []byte) (int, error) {
+	w.buffer = append(w.buffer, b...)
+
+	if len(w.buffer) >= w.config.BufferSize {
+		w.flush(w.ctx)
+	}
+
+	return len(b), nil
+}
+
+func (c *Client) flush(ctx context.Context) {
+	if len(c.buffer) == 0 {
+		return
+	}
+
+	if err := c.write(ctx, c.buffer); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		c.config.OnError(err)
+	}
+
+	c.buffer = c.buffer[:0]
+}
+
+func (c *Client) write(ctx context.Context, b []byte) error {
+	if c.zpool != nil {
+		c.zbuffer.Reset()
+
+		if err := c.zpool.Compress(&c.zbuffer, b); err != nil {
+			return err
+		}
+
+		b = c.zbuffer.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if c.zpool != nil {
+		req.Header.Set("Content-Encoding", c.zpool.Encoding())
+	}
+
+	res, err := c.httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if _, err := retry.CheckResponse(res); err != nil {
+		return err
+	}
+
+	return nil
+}
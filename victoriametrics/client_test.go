@@ -0,0 +1,175 @@
+package victoriametrics
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/testserver"
+)
+
+func startTestServer(t *testing.T, status int) (*httptest.Server, func() []string) {
+	server := testserver.New(t, testserver.Config{
+		Status: status,
+		Check: func(req *http.Request) error {
+			if req.URL.Path != ImportPath {
+				return fmt.Errorf("bad request: %s", req.URL)
+			}
+			return nil
+		},
+	})
+	return server.Server, server.Bodies
+}
+
+func TestClient(t *testing.T) {
+	for _, gzip := range []bool{false, true} {
+		t.Run(map[bool]string{false: "identity", true: "gzip"}[gzip], func(t *testing.T) {
+			server, bodies := startTestServer(t, http.StatusNoContent)
+			defer server.Close()
+
+			client := NewClientWith(ClientConfig{
+				Address: server.URL,
+				Gzip:    gzip,
+				OnError: func(err error) { t.Error(err) },
+			})
+
+			e := stats.NewEngine("test")
+			e.Register(client)
+			e.Incr("requests")
+			e.Set("conns", 2)
+			e.Flush()
+
+			e.Incr("requests")
+			e.Flush()
+
+			if b := bodies(); len(b) != 2 || b[1] != `# TYPE test_conns gauge
+test_conns 2
+# TYPE test_requests counter
+test_requests 2
+` {
+				t.Error("bad request bodies:", b)
+			}
+		})
+	}
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Encoding() string { return "deflate" }
+
+func (flateCompressor) NewWriter(w io.Writer) stats.CompressWriter {
+	z, _ := flate.NewWriter(w, flate.BestSpeed)
+	return z
+}
+
+func TestClientCompressor(t *testing.T) {
+	server, bodies := startTestServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		Compressor: flateCompressor{},
+		OnError:    func(err error) { t.Error(err) },
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(client)
+
+	for i := 0; i != 2; i++ {
+		e.Incr("requests")
+		e.Flush()
+	}
+
+	if b := bodies(); len(b) != 2 || b[1] != "# TYPE test_requests counter\ntest_requests 2\n" {
+		t.Error("bad request bodies:", b)
+	}
+}
+
+func TestClientBufferSize(t *testing.T) {
+	server, bodies := startTestServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    server.URL,
+		BufferSize: 1,
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(client)
+
+	for i := 0; i != 10000; i++ {
+		e.Incr("requests", stats.Tag{"id", strings.Repeat("x", i%100) + string(rune('a'+i%26))})
+	}
+
+	e.Flush()
+
+	if n := len(bodies()); n < 2 {
+		t.Error("the metrics were not split into multiple requests:", n)
+	}
+
+	for _, b := range bodies() {
+		if !strings.HasSuffix(b, "\n") {
+			t.Error("a request body ends with an incomplete line")
+		}
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done() // hung backend
+	}))
+	defer server.Close()
+
+	var errs []error
+	c := NewClientWith(ClientConfig{
+		Address: server.URL,
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(c)
+	e.Incr("requests")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if len(errs) != 1 {
+		t.Error("bad errors:", errs)
+	}
+}
+
+func TestClientOnError(t *testing.T) {
+	server, _ := startTestServer(t, http.StatusBadRequest)
+	defer server.Close()
+
+	var errs []error
+	client := NewClientWith(ClientConfig{
+		Address: server.URL,
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+
+	e := stats.NewEngine("test")
+	e.Register(client)
+	e.Incr("requests")
+	e.Flush()
+
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "400 Bad Request") {
+		t.Error("bad errors:", errs)
+	}
+}
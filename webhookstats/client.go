@@ -0,0 +1,473 @@
+// Package webhookstats exposes a client which posts metrics to an arbitrary
+// HTTP endpoint, with a payload shaped by a user-supplied template.
+package webhookstats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/retry"
+)
+
+const (
+	// DefaultBatchSize is the default number of metrics sent in each request.
+	DefaultBatchSize = 500
+
+	// DefaultMaxPendingBatches is the default number of full batches of
+	// metrics that clients retain until they are flushed.
+	DefaultMaxPendingBatches = 16
+
+	// DefaultTimeout is the default timeout of requests sent by clients.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxRetries is the default number of times that requests which
+	// were throttled or failed with a server error are retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the default delay before the first retry of a
+	// failed request, the delay doubles on each retry.
+	DefaultRetryDelay = 500 * time.Millisecond
+
+	// DefaultContentType is the default content type of the payloads.
+	DefaultContentType = "application/json"
+
+	// DefaultTemplate is the template used by clients which are not configured
+	// with one, it produces a JSON object with a list of metrics:
+	//
+	//	{"metrics":[{"name":"app.requests","type":"counter","value":1,"timestamp":1500000000000,"tags":{"status":"200"}}]}
+	DefaultTemplate = `{"metrics":[{{range $i, $m := .Metrics}}{{if $i}},{{end}}` +
+		`{"name":{{json $m.FullName}},"type":{{json $m.Type}},"value":{{json $m.Value}},` +
+		`"timestamp":{{unixMilli $m.Time}},"tags":{{json $m.TagMap}}}{{end}}]}`
+)
+
+// Payload is the data that templates are executed with, each request carries
+// one payload.
+type Payload struct {
+	// Time is the time at which the payload was produced.
+	Time time.Time
+
+	// Metrics is the list of metrics of the payload, in the order they were
+	// received.
+	Metrics []Measure
+}
+
+// Measure exposes the fields of a metric to templates.
+type Measure struct {
+	// Type is the type of the metric, "counter", "gauge", or "histogram".
+	Type string
+
+	// Namespace is the namespace in which the metric was produced.
+	Namespace string
+
+	// Name is the name of the metric, without its namespace.
+	Name string
+
+	// FullName is the name of the metric prefixed with its namespace.
+	FullName string
+
+	// Value is the value of the metric, the increment of counters.
+	Value float64
+
+	// Unit is the unit of the value, when known.
+	Unit string
+
+	// Rate is the sample rate of the metric, zero if it was not sampled.
+	Rate float64
+
+	// Time is the time at which the metric was produced.
+	Time time.Time
+
+	// Tags is the list of tags of the metric, in the order they were set.
+	Tags []stats.Tag
+
+	// TagMap maps the names of the tags of the metric to their values.
+	TagMap map[string]string
+}
+
+// Funcs is the list of functions available to templates in addition to the
+// builtin functions of the text/template package:
+//
+//	json      encodes its argument in JSON, it must be used to insert strings
+//	          in JSON payloads since it quotes and escapes them
+//	unix      returns the number of seconds since the epoch of a time
+//	unixMilli returns the number of milliseconds since the epoch of a time
+var Funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+	"unixMilli": func(t time.Time) int64 {
+		return t.UnixNano() / int64(time.Millisecond)
+	},
+}
+
+// The ClientConfig type is used to configure webhook clients.
+type ClientConfig struct {
+	// URL is the URL of the endpoint that metrics are posted to, it must be
+	// set.
+	URL string
+
+	// Template is the text/template source used to produce the payloads of
+	// requests from a Payload, defaults to DefaultTemplate. See Funcs for the
+	// functions available to the template.
+	Template string
+
+	// ContentType is the content type of the payloads, defaults to
+	// DefaultContentType. The payloads produced by the template are checked
+	// to be valid JSON when the content type is JSON.
+	ContentType string
+
+	// Header is the list of headers set on requests, for example to carry
+	// authentication tokens.
+	Header http.Header
+
+	// BatchSize is the number of metrics sent in each request, defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// MaxPendingBatches is the number of full batches of metrics retained by
+	// the client until it is flushed, defaults to DefaultMaxPendingBatches.
+	// Metrics received when this number is reached are dropped, see Dropped.
+	MaxPendingBatches int
+
+	// FlushInterval enables flushing the client in the background at this
+	// interval, in addition to the flushes of the engine it is registered
+	// on. The background flushes are stopped by closing the client.
+	FlushInterval time.Duration
+
+	// Timeout is the maximum amount of time that each request is allowed to
+	// take.
+	Timeout time.Duration
+
+	// Transport is the HTTP transport used by the client to send requests,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// MaxRetries is the number of times that requests which were throttled
+	// (429), failed with a server error (5xx) or a network error are retried,
+	// a negative value disables retries. Requests rejected with other client
+	// errors are not retried.
+	MaxRetries int
+
+	// RetryDelay is the delay before the first retry of a failed request, the
+	// delay doubles on each retry.
+	RetryDelay time.Duration
+
+	// Compressor is the compression codec applied to the payloads, they are
+	// not compressed when it is nil.
+	Compressor stats.Compressor
+
+	// OnError is called with the errors returned by requests and by the
+	// execution of the template, the errors are logged by default. The
+	// metrics of a payload that could not be produced are discarded.
+	OnError func(error)
+}
+
+// Client represents a client which receives metrics from a stats engine and
+// posts them in batches to a webhook, the payloads are produced by executing a
+// template with a Payload carrying the batch of metrics.
+//
+// Metrics are not aggregated, each metric received by the client appears in
+// the payloads. Payloads are only produced and sent when the client is flushed,
+// outside of the lock held by HandleMetric, so a slow or unavailable webhook
+// never blocks the code producing metrics.
+type Client struct {
+	errors  int64 // first for alignment of atomic operations
+	dropped int64
+	mutex   sync.Mutex
+	config  ClientConfig
+	tmpl    *template.Template
+	json    bool
+	httpc   http.Client
+	zpool   *stats.CompressorPool
+	metrics []Measure
+	pending [][]Measure // full batches, sent by the next flush
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewClient creates and returns a new webhook client posting metrics to url
+// with payloads produced by the template tmpl. An error is returned if the
+// template is invalid.
+func NewClient(url string, tmpl string) (*Client, error) {
+	return NewClientWith(ClientConfig{
+		URL:      url,
+		Template: tmpl,
+	})
+}
+
+// NewClientWith creates and returns a new webhook client configured with
+// config. An error is returned if the configuration is invalid.
+func NewClientWith(config ClientConfig) (*Client, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("stats/webhookstats: missing webhook url")
+	}
+
+	if len(config.Template) == 0 {
+		config.Template = DefaultTemplate
+	}
+
+	if len(config.ContentType) == 0 {
+		config.ContentType = DefaultContentType
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	if config.MaxPendingBatches <= 0 {
+		config.MaxPendingBatches = DefaultMaxPendingBatches
+	}
+
+	if config.OnError == nil {
+		url := config.URL
+		config.OnError = func(err error) {
+			log.Printf("stats/webhookstats: sending metrics to %s failed: %s", url, err)
+		}
+	}
+
+	tmpl, err := template.New("webhook").Funcs(Funcs).Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("stats/webhookstats: invalid template: %s", err)
+	}
+
+	c := &Client{
+		config: config,
+		tmpl:   tmpl,
+		json:   strings.Contains(config.ContentType, "json"),
+		httpc: http.Client{
+			Transport: config.Transport,
+			Timeout:   config.Timeout,
+		},
+		metrics: make([]Measure, 0, config.BatchSize),
+		done:    make(chan struct{}),
+	}
+
+	if config.Compressor != nil {
+		c.zpool = stats.NewCompressorPool(config.Compressor)
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	}
+
+	return c, nil
+}
+
+// Close satisfies the io.Closer interface, it stops the background flushes and
+// flushes the client.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	c.Flush()
+	return nil
+}
+
+// HandleMetric satisfies the stats.Handler interface.
+func (c *Client) HandleMetric(m *stats.Metric) {
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	tags := make([]stats.Tag, len(m.Tags))
+	tagMap := make(map[string]string, len(m.Tags))
+
+	for i, tag := range m.Tags {
+		tags[i] = tag
+		tagMap[tag.Name] = tag.Value
+	}
+
+	c.mutex.Lock()
+	c.metrics = append(c.metrics, Measure{
+		Type:      m.Type.String(),
+		Namespace: m.Namespace,
+		Name:      m.Name,
+		FullName:  stats.MetricSchema{Namespace: m.Namespace, Name: m.Name}.FullName(),
+		Value:     m.Value,
+		Unit:      m.Unit,
+		Rate:      m.Rate,
+		Time:      t,
+		Tags:      tags,
+		TagMap:    tagMap,
+	})
+
+	if len(c.metrics) >= c.config.BatchSize {
+		c.enqueue()
+	}
+
+	c.mutex.Unlock()
+}
+
+// Flush satisfies the stats.Flusher interface.
+func (c *Client) Flush() {
+	c.FlushContext(context.Background())
+}
+
+// FlushContext satisfies the stats.ContextFlusher interface, the requests to
+// the webhook are canceled when ctx is.
+func (c *Client) FlushContext(ctx context.Context) {
+	c.mutex.Lock()
+	batches := c.pending
+	c.pending = nil
+
+	if len(c.metrics) != 0 {
+		batches = append(batches, c.swap())
+	}
+
+	c.mutex.Unlock()
+
+	for _, metrics := range batches {
+		c.flush(ctx, metrics)
+	}
+}
+
+// Errors satisfies the stats.ErrorCounter interface, it returns the number of
+// payloads which could not be produced or sent.
+func (c *Client) Errors() int64 {
+	return atomic.LoadInt64(&c.errors)
+}
+
+// Dropped satisfies the stats.DropCounter interface, it returns the number of
+// metrics discarded because MaxPendingBatches was reached.
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *Client) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue retains the full batch of metrics until the next flush, the metrics
+// are dropped when MaxPendingBatches batches are already retained.
+func (c *Client) enqueue() {
+	if len(c.pending) < c.config.MaxPendingBatches {
+		c.pending = append(c.pending, c.swap())
+		return
+	}
+
+	atomic.AddInt64(&c.dropped, int64(len(c.metrics)))
+
+	for i := range c.metrics {
+		c.metrics[i] = Measure{}
+	}
+	c.metrics = c.metrics[:0]
+}
+
+// swap returns the batch of metrics and replaces it with an empty one.
+func (c *Client) swap() []Measure {
+	metrics := c.metrics
+	c.metrics = make([]Measure, 0, c.config.BatchSize)
+	return metrics
+}
+
+func (c *Client) flush(ctx context.Context, metrics []Measure) {
+	b, err := c.payload(Payload{Time: time.Now(), Metrics: metrics})
+
+	if err == nil {
+		err = c.write(ctx, b)
+	}
+
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		c.config.OnError(err)
+	}
+}
+
+// payload executes the template with p.
+func (c *Client) payload(p Payload) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	if err := c.tmpl.Execute(buffer, p); err != nil {
+		return nil, fmt.Errorf("executing the template: %s", err)
+	}
+
+	b := buffer.Bytes()
+
+	if c.json && !json.Valid(b) {
+		return nil, fmt.Errorf("the template produced an invalid JSON payload: %.128q", b)
+	}
+
+	if c.zpool != nil {
+		z := &bytes.Buffer{}
+
+		if err := c.zpool.Compress(z, b); err != nil {
+			return nil, err
+		}
+
+		b = z.Bytes()
+	}
+
+	return b, nil
+}
+
+// write sends b to the webhook, retrying requests which were throttled or
+// failed with a server error with an exponential backoff, until ctx is canceled.
+func (c *Client) write(ctx context.Context, b []byte) error {
+	return retry.Do(ctx, c.config.MaxRetries, c.config.RetryDelay, func() (bool, error) {
+		return c.send(ctx, b)
+	})
+}
+
+// send sends a single request with the body b, it returns true if the request
+// failed and may be retried.
+func (c *Client) send(ctx context.Context, b []byte) (bool, error) {
+	req, err := http.NewRequest("POST", c.config.URL, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	for name, values := range c.config.Header {
+		req.Header[name] = values
+	}
+
+	req.Header.Set("Content-Type", c.config.ContentType)
+
+	if c.zpool != nil {
+		req.Header.Set("Content-Encoding", c.zpool.Encoding())
+	}
+
+	res, err := c.httpc.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	return retry.CheckResponse(res)
+}
@@ -0,0 +1,262 @@
+package webhookstats
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/internal/testserver"
+)
+
+func startTestServer(t *testing.T, statuses ...int) (*httptest.Server, func() []string) {
+	server := testserver.New(t, testserver.Config{
+		Statuses: statuses,
+		Check: func(req *http.Request) error {
+			if token := req.Header.Get("Authorization"); token != "Bearer secret" {
+				return fmt.Errorf("bad authorization: %s", token)
+			}
+			return nil
+		},
+	})
+	return server.Server, server.Bodies
+}
+
+func TestClient(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		template string
+		body     string
+	}{
+		{
+			name: "default",
+			body: `{"metrics":[` +
+				`{"name":"test.calls","type":"counter","value":1,"timestamp":1500000000000,"tags":{"op":"read"}},` +
+				`{"name":"test.conns","type":"gauge","value":4,"timestamp":1500000000000,"tags":{}}]}`,
+		},
+		{
+			name:     "custom",
+			template: `[{{range $i, $m := .Metrics}}{{if $i}},{{end}}[{{json $m.Name}},{{$m.Value}},{{unix $m.Time}}]{{end}}]`,
+			body:     `[["calls",1,1500000000],["conns",4,1500000000]]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewClientWith(ClientConfig{
+				URL:      server.URL,
+				Template: test.template,
+				Header:   http.Header{"Authorization": {"Bearer secret"}},
+				OnError:  func(err error) { t.Error(err) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Unix(1500000000, 0)
+			c.HandleMetric(&stats.Metric{Type: stats.CounterType, Namespace: "test", Name: "calls", Value: 1, Tags: []stats.Tag{{"op", "read"}}, Time: now})
+			c.HandleMetric(&stats.Metric{Type: stats.GaugeType, Namespace: "test", Name: "conns", Value: 4, Time: now})
+			c.Flush()
+			c.Flush()
+
+			b := bodies()
+
+			if len(b) == 0 || b[len(b)-1] != test.body {
+				t.Error("bad request bodies:", b)
+			}
+		})
+	}
+
+	if n := len(bodies()); n != len(tests) {
+		t.Error("empty flushes sent requests:", n)
+	}
+}
+
+func TestClientInvalidConfig(t *testing.T) {
+	if _, err := NewClient("", ""); err == nil {
+		t.Error("expected an error for a missing url")
+	}
+
+	if _, err := NewClient("http://localhost", "{{range}}"); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestClientTemplateErrors(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		template string
+		error    string
+	}{
+		{
+			name:     "execution",
+			template: `{{range .Metrics}}{{.Missing}}{{end}}`,
+			error:    "executing the template",
+		},
+		{
+			name:     "invalid json",
+			template: `{"name":{{range .Metrics}}{{.Name}}{{end}}}`,
+			error:    "invalid JSON payload",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var errs []error
+
+			c, err := NewClientWith(ClientConfig{
+				URL:      server.URL,
+				Template: test.template,
+				OnError:  func(err error) { errs = append(errs, err) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+			c.Flush()
+
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.error) {
+				t.Error("bad errors:", errs)
+			}
+
+			if n := c.Errors(); n != 1 {
+				t.Error("bad number of errors:", n)
+			}
+		})
+	}
+
+	if n := len(bodies()); n != 0 {
+		t.Error("payloads that could not be produced were sent:", n)
+	}
+}
+
+func TestClientFlushContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done() // hung backend
+	}))
+	defer server.Close()
+
+	c, _ := NewClientWith(ClientConfig{
+		URL:        server.URL,
+		MaxRetries: 3,
+		RetryDelay: time.Hour,
+		OnError:    func(error) {},
+	})
+	c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	c.FlushContext(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("the flush was not canceled with its context, it lasted", elapsed)
+	}
+
+	if n := c.Errors(); n != 1 {
+		t.Error("bad number of errors:", n)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		errors   int64
+	}{
+		{
+			name:     "server error",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError},
+			requests: 3,
+		},
+		{
+			name:     "throttled",
+			statuses: []int{http.StatusTooManyRequests},
+			requests: 2,
+		},
+		{
+			name:     "client error",
+			statuses: []int{http.StatusBadRequest},
+			requests: 1,
+			errors:   1,
+		},
+		{
+			name:     "retries exhausted",
+			statuses: []int{500, 500, 500},
+			requests: 3,
+			errors:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, bodies := startTestServer(t, test.statuses...)
+			defer server.Close()
+
+			c, _ := NewClientWith(ClientConfig{
+				URL:        server.URL,
+				Header:     http.Header{"Authorization": {"Bearer secret"}},
+				BatchSize:  1,
+				MaxRetries: 2,
+				RetryDelay: time.Millisecond,
+				OnError:    func(error) {},
+			})
+
+			c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+			c.Flush()
+
+			if n := len(bodies()); n != test.requests {
+				t.Error("bad number of requests:", n)
+			}
+
+			if n := c.Errors(); n != test.errors {
+				t.Error("bad number of errors:", n)
+			}
+		})
+	}
+}
+
+func TestClientMaxPendingBatches(t *testing.T) {
+	server, bodies := startTestServer(t)
+	defer server.Close()
+
+	c, _ := NewClientWith(ClientConfig{
+		URL:               server.URL,
+		Header:            http.Header{"Authorization": {"Bearer secret"}},
+		BatchSize:         2,
+		MaxPendingBatches: 1,
+	})
+
+	for i := 0; i != 5; i++ {
+		c.HandleMetric(&stats.Metric{Type: stats.CounterType, Name: "calls", Value: 1})
+	}
+
+	if n := len(bodies()); n != 0 {
+		t.Error("payloads were sent before the flush:", n)
+	}
+
+	if n := c.Dropped(); n != 2 {
+		t.Error("bad number of dropped metrics:", n)
+	}
+
+	c.Flush()
+
+	if n := len(bodies()); n != 2 {
+		t.Error("bad number of payloads sent by the flush:", n)
+	}
+}